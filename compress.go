@@ -0,0 +1,276 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	// ErrCorruptBlock means a compressed block of a backup could not be decoded
+	ErrCorruptBlock = errors.New("Corrupt compressed block")
+)
+
+// BlockCompressor compresses the blocks of items written to backup files
+// and streams. Only a flate compressor is provided. Others such as snappy or
+// zstd are not included, but can be plugged in by implementing this interface.
+type BlockCompressor interface {
+	// Name identifies the compressor in the backup manifest
+	Name() string
+	// Compress appends the compressed src to dst
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress appends the decompressed src to dst
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+var blockCompressors = struct {
+	sync.Mutex
+	m map[string]BlockCompressor
+}{m: make(map[string]BlockCompressor)}
+
+func init() {
+	RegisterBlockCompressor(noCompressor{})
+	RegisterBlockCompressor(FlateCompressor)
+}
+
+// RegisterBlockCompressor makes a compressor available for loading the
+// backups written by using it
+func RegisterBlockCompressor(c BlockCompressor) {
+	blockCompressors.Lock()
+	defer blockCompressors.Unlock()
+	blockCompressors.m[c.Name()] = c
+}
+
+func getBlockCompressor(name string) (BlockCompressor, error) {
+	blockCompressors.Lock()
+	defer blockCompressors.Unlock()
+	if c, ok := blockCompressors.m[name]; ok {
+		return c, nil
+	}
+
+	return nil, fmt.Errorf("Unknown block compressor %s", name)
+}
+
+type noCompressor struct{}
+
+func (noCompressor) Name() string {
+	return "none"
+}
+
+func (noCompressor) Compress(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
+
+func (noCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
+
+// FlateCompressor compresses blocks by using DEFLATE at its fastest level
+var FlateCompressor BlockCompressor = &flateCompressor{}
+
+type flateCompressor struct {
+	writers sync.Pool
+}
+
+func (c *flateCompressor) Name() string {
+	return "flate"
+}
+
+func (c *flateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, _ := c.writers.Get().(*flate.Writer)
+	if w == nil {
+		w, _ = flate.NewWriter(buf, flate.BestSpeed)
+	} else {
+		w.Reset(buf)
+	}
+	defer c.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *flateCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+
+	if _, err := io.Copy(buf, r); err != nil {
+		return dst, ErrCorruptBlock
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Block format: [4 byte raw len][4 byte compressed len][compressed bytes]
+// A block with zero lengths terminates the stream.
+const blockHeaderSize = 8
+
+// Blocks hold up to DiskBlockSize raw bytes. Compressing incompressible
+// bytes adds some overhead, which is allowed for by a factor of two.
+func maxBlockLen() (raw, compressed int) {
+	return DiskBlockSize, 2 * DiskBlockSize
+}
+
+// Buffers the written bytes and writes them out as compressed blocks
+// of up to DiskBlockSize bytes
+type blockWriter struct {
+	w    io.Writer
+	c    BlockCompressor
+	buf  []byte
+	cbuf []byte
+	hdr  [blockHeaderSize]byte
+}
+
+func newBlockWriter(w io.Writer, c BlockCompressor) *blockWriter {
+	return &blockWriter{
+		w:   w,
+		c:   c,
+		buf: make([]byte, 0, DiskBlockSize),
+	}
+}
+
+func (b *blockWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := copy(b.buf[len(b.buf):cap(b.buf)], p)
+		b.buf = b.buf[:len(b.buf)+k]
+		p = p[k:]
+
+		if len(b.buf) == cap(b.buf) {
+			if err := b.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+func (b *blockWriter) writeBlock(rawLen int, data []byte) error {
+	binary.BigEndian.PutUint32(b.hdr[0:4], uint32(rawLen))
+	binary.BigEndian.PutUint32(b.hdr[4:8], uint32(len(data)))
+	if _, err := b.w.Write(b.hdr[:]); err != nil || len(data) == 0 {
+		return err
+	}
+
+	_, err := b.w.Write(data)
+	return err
+}
+
+func (b *blockWriter) flush() error {
+	if len(b.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if b.cbuf, err = b.c.Compress(b.cbuf[:0], b.buf); err != nil {
+		return err
+	}
+
+	if err = b.writeBlock(len(b.buf), b.cbuf); err == nil {
+		b.buf = b.buf[:0]
+	}
+
+	return err
+}
+
+// Close writes out the pending bytes followed by the terminating block
+func (b *blockWriter) Close() error {
+	if err := b.flush(); err != nil {
+		return err
+	}
+
+	return b.writeBlock(0, nil)
+}
+
+// Reads the bytes of the compressed blocks written by blockWriter
+type blockReader struct {
+	r    io.Reader
+	c    BlockCompressor
+	buf  []byte
+	pos  int
+	cbuf []byte
+	hdr  [blockHeaderSize]byte
+	eof  bool
+}
+
+func newBlockReader(r io.Reader, c BlockCompressor) *blockReader {
+	return &blockReader{r: r, c: c}
+}
+
+func (b *blockReader) Read(p []byte) (int, error) {
+	for b.pos == len(b.buf) {
+		if b.eof {
+			return 0, io.EOF
+		}
+
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, b.buf[b.pos:])
+	b.pos += n
+	return n, nil
+}
+
+func (b *blockReader) fill() error {
+	if _, err := io.ReadFull(b.r, b.hdr[:]); err != nil {
+		return err
+	}
+
+	rawLen := int(binary.BigEndian.Uint32(b.hdr[0:4]))
+	l := int(binary.BigEndian.Uint32(b.hdr[4:8]))
+	if rawLen == 0 && l == 0 {
+		b.eof = true
+		return nil
+	}
+
+	// The lengths are checked before allocating, so that a corrupt
+	// header does not cause a huge allocation
+	if maxRaw, maxLen := maxBlockLen(); rawLen > maxRaw || l > maxLen {
+		return ErrCorruptBlock
+	}
+
+	if cap(b.cbuf) < l {
+		b.cbuf = make([]byte, l)
+	}
+	b.cbuf = b.cbuf[:l]
+
+	if _, err := io.ReadFull(b.r, b.cbuf); err != nil {
+		return err
+	}
+
+	var err error
+	if b.buf, err = b.c.Decompress(b.buf[:0], b.cbuf); err != nil {
+		return err
+	}
+
+	if len(b.buf) != rawLen {
+		return ErrCorruptBlock
+	}
+
+	b.pos = 0
+	return nil
+}
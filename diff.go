@@ -0,0 +1,155 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"unsafe"
+
+	"github.com/couchbase/nitro/skiplist"
+)
+
+// DiffOp describes how an item changed between two snapshots
+type DiffOp int
+
+const (
+	// DiffCreated means the item is visible only in the newer snapshot
+	DiffCreated DiffOp = iota
+	// DiffDeleted means the item is visible only in the older snapshot
+	DiffDeleted
+)
+
+func (op DiffOp) String() string {
+	if op == DiffCreated {
+		return "created"
+	}
+
+	return "deleted"
+}
+
+// DiffIterator iterates over the items changed between two snapshots in the
+// key order. An item replaced in between is returned as deleted followed by
+// created. Versions of an item are ordered by their sequence numbers, hence
+// the iterator positions itself by using the insert comparator.
+type DiffIterator struct {
+	count       int
+	refreshRate int
+
+	from, to *Snapshot
+	op       DiffOp
+	iter     *skiplist.Iterator
+	buf      *skiplist.ActionBuffer
+}
+
+// NewDiffIterator creates an iterator for the changes from the snapshot
+// `from` to the newer snapshot `to`. It returns nil if either snapshot is
+// closed or `from` is not older than `to`.
+func (m *Nitro) NewDiffIterator(from, to *Snapshot) *DiffIterator {
+	if from.sn >= to.sn || !from.Open() {
+		return nil
+	}
+
+	if !to.Open() {
+		from.Close()
+		return nil
+	}
+
+	buf := m.store.MakeBuf()
+	return &DiffIterator{
+		from: from,
+		to:   to,
+		iter: m.store.NewIterator(m.insCmp, buf),
+		buf:  buf,
+	}
+}
+
+func (it *DiffIterator) skipUnchanged() {
+	for ; it.iter.Valid(); it.iter.Next() {
+		itm := (*Item)(it.iter.Get())
+		if inFrom, inTo := it.from.isVisible(itm), it.to.isVisible(itm); inFrom != inTo {
+			it.op = DiffCreated
+			if inFrom {
+				it.op = DiffDeleted
+			}
+			return
+		}
+		it.count++
+	}
+}
+
+// SeekFirst moves cursor to the first changed item
+func (it *DiffIterator) SeekFirst() {
+	it.iter.SeekFirst()
+	it.skipUnchanged()
+}
+
+// Seek moves cursor to the first changed item with key greater than or
+// equal to the specified key
+func (it *DiffIterator) Seek(bs []byte) {
+	itm := it.to.db.newItem(bs, false)
+	it.iter.Seek(unsafe.Pointer(itm))
+	it.skipUnchanged()
+}
+
+// Valid returns false when the iterator has reached the end
+func (it *DiffIterator) Valid() bool {
+	return it.iter.Valid()
+}
+
+// Get returns the current item data
+func (it *DiffIterator) Get() []byte {
+	return (*Item)(it.iter.Get()).Bytes()
+}
+
+// GetNode returns the skiplist node which holds the current item
+func (it *DiffIterator) GetNode() *skiplist.Node {
+	return it.iter.GetNode()
+}
+
+// Op returns whether the current item was created or deleted
+func (it *DiffIterator) Op() DiffOp {
+	return it.op
+}
+
+// Next moves iterator cursor to the next changed item
+func (it *DiffIterator) Next() {
+	it.iter.Next()
+	it.count++
+	it.skipUnchanged()
+	if it.refreshRate > 0 && it.count > it.refreshRate {
+		it.Refresh()
+		it.count = 0
+	}
+}
+
+// Refresh releases the SMR accessor token held by the iterator and
+// acquires a new one, as with Iterator.Refresh
+func (it *DiffIterator) Refresh() {
+	if it.Valid() {
+		db := it.to.db
+		itm := db.ptrToItem(it.GetNode().Item())
+		it.iter.Close()
+		it.iter = db.store.NewIterator(db.insCmp, it.buf)
+		it.iter.Seek(unsafe.Pointer(itm))
+		it.skipUnchanged()
+	}
+}
+
+// SetRefreshRate sets automatic refresh frequency. By default, it is unlimited
+func (it *DiffIterator) SetRefreshRate(rate int) {
+	it.refreshRate = rate
+}
+
+// Close executes destructor for iterator
+func (it *DiffIterator) Close() {
+	it.from.Close()
+	it.to.Close()
+	it.to.db.store.FreeBuf(it.buf)
+	it.iter.Close()
+}
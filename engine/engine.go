@@ -0,0 +1,175 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package engine defines a key value store interface implemented by both
+// the memory optimized Nitro engine and the disk backed Plasma engine, so
+// that the engine can be chosen by the configuration.
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+
+	"github.com/couchbase/nitro"
+	"github.com/couchbase/nitro/plasma"
+)
+
+var (
+	// ErrItemNotFound means the key does not exist
+	ErrItemNotFound = plasma.ErrItemNotFound
+	// ErrNotPersistent means the store is not configured with a directory
+	ErrNotPersistent = errors.New("Store has no directory to persist into")
+)
+
+// Type of the storage engine
+type Type int
+
+const (
+	// MemDB keeps the items in memory by using Nitro. The items are
+	// persisted as disk backups, which are loaded when the store is created.
+	MemDB Type = iota
+	// Plasma keeps the items in a Plasma instance, which caches the
+	// items in memory and persists them into its log
+	Plasma
+)
+
+func (t Type) String() string {
+	switch t {
+	case MemDB:
+		return "memdb"
+	case Plasma:
+		return "plasma"
+	}
+
+	return fmt.Sprintf("Type(%d)", int(t))
+}
+
+// ParseType returns the engine type of the name returned by Type.String
+func ParseType(name string) (Type, error) {
+	switch name {
+	case "memdb":
+		return MemDB, nil
+	case "plasma":
+		return Plasma, nil
+	}
+
+	return 0, fmt.Errorf("Unknown storage engine %q", name)
+}
+
+// Config - storage engine configuration
+type Config struct {
+	Type Type
+	// Directory where the store is persisted. It is optional for MemDB,
+	// which can not be persisted without it.
+	Dir string
+	// Number of goroutines used by MemDB to persist and load the store
+	Concurrency int
+
+	// Engine specific configuration. Key comparator of Nitro and the file
+	// of Plasma are set by the store.
+	MemDB  nitro.Config
+	Plasma plasma.Config
+}
+
+// DefaultConfig returns the default configuration of the engine type for
+// a store persisted in the directory
+func DefaultConfig(t Type, dir string) Config {
+	return Config{
+		Type:        t,
+		Dir:         dir,
+		Concurrency: runtime.GOMAXPROCS(0),
+		MemDB:       nitro.DefaultConfig(),
+		Plasma:      plasma.DefaultConfig(),
+	}
+}
+
+// Store is a key value store
+type Store interface {
+	// NewWriter creates a writer. Writers should not be shared by goroutines.
+	NewWriter() Writer
+	// NewSnapshot creates an immutable snapshot of the store
+	NewSnapshot() (Snapshot, error)
+	// Persist writes the items into the store directory, from where they
+	// are recovered when the store is created again
+	Persist() error
+	// Close releases the store. Snapshots and iterators should be closed
+	// beforehand.
+	Close()
+}
+
+// Writer provides access to a store for a single goroutine
+type Writer interface {
+	// Put inserts or replaces the value of a key. Readers see either the
+	// previous or the new value.
+	Put(k, v []byte) error
+	// Delete removes a key. Deleting a missing key is not an error.
+	Delete(k []byte) error
+	// Get returns a copy of the value of a key, or ErrItemNotFound
+	Get(k []byte) ([]byte, error)
+	// Close releases the writer, which may be handed out again by
+	// NewWriter. The writer should not be used afterwards.
+	Close()
+}
+
+// BulkLoader is implemented by the stores which load a batch of items
+// faster than writing them one by one
+type BulkLoader interface {
+	// NewLoader creates a loader of items into the store
+	NewLoader() Loader
+}
+
+// Loader collects the items of a bulk load. Add may be called by
+// concurrent goroutines.
+type Loader interface {
+	// Add adds an item to the load. Of the items added with equal keys,
+	// the one added last is retained.
+	Add(k, v []byte) error
+	// Load writes the items added into the store. Writers should not be
+	// used during the load.
+	Load() error
+}
+
+// Snapshot is an immutable view of a store
+type Snapshot interface {
+	// NewIterator creates an iterator over the snapshot
+	NewIterator() Iterator
+	// Close releases the snapshot
+	Close()
+}
+
+// Iterator iterates over the items of a snapshot in the key order. The key
+// and value returned are valid until the iterator is moved.
+type Iterator interface {
+	SeekFirst()
+	// Seek moves the iterator to the first key greater than or equal to k
+	Seek(k []byte)
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	Next()
+	Close()
+}
+
+// New creates a store using the configured engine. A store persisted in
+// the directory is recovered.
+func New(cfg Config) (Store, error) {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	switch cfg.Type {
+	case MemDB:
+		return newMemDBStore(cfg)
+	case Plasma:
+		return newPlasmaStore(cfg)
+	}
+
+	return nil, fmt.Errorf("Unknown storage engine %v", cfg.Type)
+}
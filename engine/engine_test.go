@@ -0,0 +1,256 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+var testTypes = []Type{MemDB, Plasma}
+
+func newTestStore(t *testing.T, typ Type) Store {
+	s, err := New(DefaultConfig(typ, "teststore.data"))
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	return s
+}
+
+func TestStoreOps(t *testing.T) {
+	for _, typ := range testTypes {
+		os.RemoveAll("teststore.data")
+		s := newTestStore(t, typ)
+		w := s.NewWriter()
+
+		n := 10000
+		for i := 0; i < n; i++ {
+			w.Put([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", i)))
+		}
+
+		snap, _ := s.NewSnapshot()
+		for i := 0; i < n; i += 2 {
+			w.Put([]byte(fmt.Sprintf("key-%10d", i)), []byte("newval"))
+			w.Delete([]byte(fmt.Sprintf("key-%10d", i+1)))
+		}
+
+		for i := 0; i < n; i++ {
+			v, err := w.Get([]byte(fmt.Sprintf("key-%10d", i)))
+			if i%2 == 0 && (err != nil || string(v) != "newval") {
+				t.Errorf("%v: Expected newval for key-%d, got %s, %v", typ, i, v, err)
+			} else if i%2 == 1 && err != ErrItemNotFound {
+				t.Errorf("%v: Expected key-%d to be deleted, got %v", typ, i, err)
+			}
+		}
+
+		// Snapshot is not affected by the later mutations
+		i := 0
+		itr := snap.NewIterator()
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			k, v := fmt.Sprintf("key-%10d", i), fmt.Sprintf("val-%d", i)
+			if string(itr.Key()) != k || string(itr.Value()) != v {
+				t.Fatalf("%v: Expected %s:%s, got %s:%s", typ, k, v, itr.Key(), itr.Value())
+			}
+			i++
+		}
+		itr.Close()
+		snap.Close()
+
+		if i != n {
+			t.Errorf("%v: Expected %d items, got %d", typ, n, i)
+		}
+
+		i = 0
+		snap, _ = s.NewSnapshot()
+		itr = snap.NewIterator()
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			if k := fmt.Sprintf("key-%10d", i); string(itr.Key()) != k || string(itr.Value()) != "newval" {
+				t.Fatalf("%v: Expected %s:newval, got %s:%s", typ, k, itr.Key(), itr.Value())
+			}
+			i += 2
+		}
+
+		if i != n {
+			t.Errorf("%v: Expected %d items, got %d", typ, n/2, i/2)
+		}
+
+		itr.Seek([]byte(fmt.Sprintf("key-%10d", 101)))
+		if !itr.Valid() || string(itr.Key()) != fmt.Sprintf("key-%10d", 102) {
+			t.Errorf("%v: Expected seek to the next live key", typ)
+		}
+		itr.Close()
+		snap.Close()
+		s.Close()
+	}
+
+	os.RemoveAll("teststore.data")
+}
+
+func TestStorePersist(t *testing.T) {
+	for _, typ := range testTypes {
+		os.RemoveAll("teststore.data")
+		s := newTestStore(t, typ)
+		w := s.NewWriter()
+
+		n := 10000
+		for i := 0; i < n; i++ {
+			w.Put([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", i)))
+		}
+
+		for round := 0; round < 2; round++ {
+			if err := s.Persist(); err != nil {
+				t.Fatalf("%v: Expected no error. got=%v", typ, err)
+			}
+			s.Close()
+
+			s = newTestStore(t, typ)
+			w = s.NewWriter()
+			for i := 0; i < n; i++ {
+				v, err := w.Get([]byte(fmt.Sprintf("key-%10d", i)))
+				if exp := fmt.Sprintf("val-%d", i); err != nil || string(v) != exp {
+					t.Fatalf("%v: Expected %s for key-%d, got %s, %v", typ, exp, i, v, err)
+				}
+			}
+		}
+		s.Close()
+	}
+
+	os.RemoveAll("teststore.data")
+}
+
+func TestStoreWriterReuse(t *testing.T) {
+	for _, typ := range testTypes {
+		os.RemoveAll("teststore.data")
+		s := newTestStore(t, typ)
+
+		w := s.NewWriter()
+		w.Put([]byte("key"), []byte("val"))
+		w.Close()
+
+		// A released writer is handed out again
+		w = s.NewWriter()
+		if v, err := w.Get([]byte("key")); err != nil || string(v) != "val" {
+			t.Errorf("%v: Expected val, got %s, %v", typ, v, err)
+		}
+		w.Close()
+
+		if ms, ok := s.(*memDBStore); ok && len(ms.writers) != 1 {
+			t.Errorf("Expected a pooled writer, got %d", len(ms.writers))
+		}
+		s.Close()
+	}
+
+	os.RemoveAll("teststore.data")
+}
+
+func TestStoreReplaceVisible(t *testing.T) {
+	for _, typ := range testTypes {
+		os.RemoveAll("teststore.data")
+		s := newTestStore(t, typ)
+		k := []byte("key")
+		w := s.NewWriter()
+		w.Put(k, []byte("val"))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			w := s.NewWriter()
+			defer w.Close()
+			for i := 0; i < 100000; i++ {
+				w.Put(k, []byte(fmt.Sprintf("val-%d", i)))
+			}
+		}()
+
+		// A replaced key is never seen missing
+	loop:
+		for {
+			select {
+			case <-done:
+				break loop
+			default:
+			}
+
+			if _, err := w.Get(k); err != nil {
+				t.Fatalf("%v: Expected no error. got=%v", typ, err)
+			}
+		}
+
+		w.Close()
+		s.Close()
+	}
+
+	os.RemoveAll("teststore.data")
+}
+
+func TestStoreBulkLoad(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	defer os.RemoveAll("teststore.data")
+	s := newTestStore(t, MemDB)
+	defer s.Close()
+
+	snap, _ := s.NewSnapshot()
+	defer snap.Close()
+
+	n := 10000
+	l := s.(BulkLoader).NewLoader()
+	for i := n - 1; i >= 0; i-- {
+		l.Add([]byte(fmt.Sprintf("key-%10d", i)), []byte("oldval"))
+		l.Add([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", i)))
+	}
+	if err := l.Load(); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	// Store is not empty, hence items are written one by one
+	l = s.(BulkLoader).NewLoader()
+	l.Add([]byte(fmt.Sprintf("key-%10d", n)), []byte(fmt.Sprintf("val-%d", n)))
+	l.Add([]byte(fmt.Sprintf("key-%10d", 0)), []byte("newval"))
+	if err := l.Load(); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	w := s.NewWriter()
+	defer w.Close()
+	for i := 0; i <= n; i++ {
+		exp := fmt.Sprintf("val-%d", i)
+		if i == 0 {
+			exp = "newval"
+		}
+
+		if v, err := w.Get([]byte(fmt.Sprintf("key-%10d", i))); err != nil || string(v) != exp {
+			t.Fatalf("Expected %s, got %s, %v", exp, v, err)
+		}
+	}
+
+	// Snapshot created before the load does not see the items
+	itr := snap.NewIterator()
+	defer itr.Close()
+	if itr.SeekFirst(); itr.Valid() {
+		t.Errorf("Expected no items, got %s", itr.Key())
+	}
+}
+
+func TestParseType(t *testing.T) {
+	for _, typ := range testTypes {
+		if parsed, err := ParseType(typ.String()); err != nil || parsed != typ {
+			t.Errorf("Expected %v, got %v, %v", typ, parsed, err)
+		}
+	}
+
+	if _, err := ParseType("unknown"); err == nil {
+		t.Errorf("Expected an error for unknown engine")
+	}
+
+	if _, err := New(Config{Type: Type(100)}); err == nil {
+		t.Errorf("Expected an error for unknown engine")
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package engine
+
+import (
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/couchbase/nitro"
+)
+
+// Items are stored in Nitro as key value pairs ordered by the key
+type memDBStore struct {
+	cfg Config
+	db  *nitro.Nitro
+
+	// Writers hold it shared, while Nitro snapshots are created
+	rw        sync.RWMutex
+	persistMu sync.Mutex
+
+	// A put holds the lock of its key exclusively, so that a lookup does
+	// not see the key missing between its delete and insert in Nitro
+	keyLocks []sync.RWMutex
+
+	// Nitro writers cannot be released, hence they are reused
+	writersMu sync.Mutex
+	writers   []*nitro.Writer
+}
+
+// Number of locks serializing the updates of a key with its lookups
+const keyLockSlots = 1024
+
+func newMemDBStore(cfg Config) (*memDBStore, error) {
+	cfg.MemDB.SetKeyComparator(nitro.CompareKV)
+	s := &memDBStore{
+		cfg:      cfg,
+		db:       nitro.NewWithConfig(cfg.MemDB),
+		keyLocks: make([]sync.RWMutex, keyLockSlots),
+	}
+
+	if cfg.Dir == "" {
+		return s, nil
+	}
+
+	// Previous backup is retained until the new one is in place
+	if _, err := os.Stat(cfg.Dir); os.IsNotExist(err) {
+		os.Rename(cfg.Dir+".old", cfg.Dir)
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "data")); err == nil {
+		snap, err := s.db.LoadFromDisk(cfg.Dir, cfg.Concurrency, nil)
+		if err != nil {
+			s.db.Close()
+			return nil, err
+		}
+		snap.Close()
+	}
+
+	return s, nil
+}
+
+func (s *memDBStore) NewWriter() Writer {
+	s.writersMu.Lock()
+	defer s.writersMu.Unlock()
+
+	if n := len(s.writers); n > 0 {
+		w := s.writers[n-1]
+		s.writers = s.writers[:n-1]
+		return &memDBWriter{s: s, w: w}
+	}
+
+	return &memDBWriter{s: s, w: s.db.NewWriter()}
+}
+
+func (s *memDBStore) keyLock(k []byte) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write(k)
+	return &s.keyLocks[h.Sum32()%keyLockSlots]
+}
+
+func (s *memDBStore) newSnapshot() (*nitro.Snapshot, error) {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+	return s.db.NewSnapshot()
+}
+
+func (s *memDBStore) NewSnapshot() (Snapshot, error) {
+	snap, err := s.newSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &memDBSnapshot{snap: snap}, nil
+}
+
+// Backup is written aside and swapped with the previous backup
+func (s *memDBStore) Persist() error {
+	if s.cfg.Dir == "" {
+		return ErrNotPersistent
+	}
+
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+
+	snap, err := s.newSnapshot()
+	if err != nil {
+		return err
+	}
+
+	tmpdir, olddir := s.cfg.Dir+".tmp", s.cfg.Dir+".old"
+	os.RemoveAll(tmpdir)
+	if err := s.db.StoreToDisk(tmpdir, snap, s.cfg.Concurrency, nil); err != nil {
+		return err
+	}
+
+	os.RemoveAll(olddir)
+	if err := os.Rename(s.cfg.Dir, olddir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Rename(tmpdir, s.cfg.Dir); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(olddir)
+}
+
+func (s *memDBStore) Close() {
+	s.db.Close()
+}
+
+type memDBWriter struct {
+	s *memDBStore
+	w *nitro.Writer
+}
+
+func (w *memDBWriter) Put(k, v []byte) error {
+	l := w.s.keyLock(k)
+	w.s.rw.RLock()
+	defer w.s.rw.RUnlock()
+	l.Lock()
+	defer l.Unlock()
+
+	w.w.Delete(nitro.KVToBytes(k, nil))
+	w.w.Put(nitro.KVToBytes(k, v))
+	return nil
+}
+
+func (w *memDBWriter) Delete(k []byte) error {
+	l := w.s.keyLock(k)
+	w.s.rw.RLock()
+	defer w.s.rw.RUnlock()
+	l.Lock()
+	defer l.Unlock()
+
+	w.w.Delete(nitro.KVToBytes(k, nil))
+	return nil
+}
+
+func (w *memDBWriter) Get(k []byte) ([]byte, error) {
+	l := w.s.keyLock(k)
+	w.s.rw.RLock()
+	defer w.s.rw.RUnlock()
+	l.RLock()
+	defer l.RUnlock()
+
+	n := w.w.GetNode(nitro.KVToBytes(k, nil))
+	if n == nil {
+		return nil, ErrItemNotFound
+	}
+
+	_, v := nitro.KVFromBytes((*nitro.Item)(n.Item()).Bytes())
+	return append([]byte(nil), v...), nil
+}
+
+func (w *memDBWriter) Close() {
+	w.s.writersMu.Lock()
+	defer w.s.writersMu.Unlock()
+
+	w.s.writers = append(w.s.writers, w.w)
+	w.w = nil
+}
+
+func (s *memDBStore) NewLoader() Loader {
+	return &memDBLoader{s: s}
+}
+
+// Items are built by the Nitro bulk loader if the store is empty and are
+// written one by one otherwise
+type memDBLoader struct {
+	s     *memDBStore
+	mu    sync.Mutex
+	items [][]byte
+}
+
+func (l *memDBLoader) Add(k, v []byte) error {
+	itm := nitro.KVToBytes(k, v)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.items = append(l.items, itm)
+	return nil
+}
+
+func (l *memDBLoader) Load() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	items := l.items
+	l.items = nil
+
+	if err := l.s.load(items); err != nitro.ErrNotEmpty {
+		return err
+	}
+
+	w := l.s.NewWriter()
+	defer w.Close()
+	for _, itm := range items {
+		if err := w.Put(nitro.KVFromBytes(itm)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *memDBStore) load(items [][]byte) error {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	snap, err := s.db.LoadFromItems(items, s.cfg.Concurrency)
+	if err != nil {
+		return err
+	}
+
+	snap.Close()
+	return nil
+}
+
+type memDBSnapshot struct {
+	snap *nitro.Snapshot
+}
+
+func (s *memDBSnapshot) NewIterator() Iterator {
+	return &memDBIterator{itr: s.snap.NewIterator()}
+}
+
+func (s *memDBSnapshot) Close() {
+	s.snap.Close()
+}
+
+type memDBIterator struct {
+	itr *nitro.Iterator
+}
+
+func (it *memDBIterator) SeekFirst() {
+	it.itr.SeekFirst()
+}
+
+func (it *memDBIterator) Seek(k []byte) {
+	it.itr.Seek(nitro.KVToBytes(k, nil))
+}
+
+func (it *memDBIterator) Valid() bool {
+	return it.itr.Valid()
+}
+
+func (it *memDBIterator) Key() []byte {
+	k, _ := nitro.KVFromBytes(it.itr.Get())
+	return k
+}
+
+func (it *memDBIterator) Value() []byte {
+	_, v := nitro.KVFromBytes(it.itr.Get())
+	return v
+}
+
+func (it *memDBIterator) Next() {
+	it.itr.Next()
+}
+
+func (it *memDBIterator) Close() {
+	it.itr.Close()
+}
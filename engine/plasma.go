@@ -0,0 +1,103 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package engine
+
+import (
+	"context"
+
+	"github.com/couchbase/nitro/plasma"
+)
+
+// Items are stored by using the Plasma MVCC key value API
+type plasmaStore struct {
+	ps *plasma.Plasma
+}
+
+func newPlasmaStore(cfg Config) (*plasmaStore, error) {
+	cfg.Plasma.File = cfg.Dir
+	ps, err := plasma.New(cfg.Plasma)
+	if err != nil {
+		return nil, err
+	}
+
+	return &plasmaStore{ps: ps}, nil
+}
+
+// Writers are taken from the writer pool of Plasma
+func (s *plasmaStore) NewWriter() Writer {
+	return &plasmaWriter{ps: s.ps, w: s.ps.AcquireWriter()}
+}
+
+func (s *plasmaStore) NewSnapshot() (Snapshot, error) {
+	return &plasmaSnapshot{snap: s.ps.NewSnapshot()}, nil
+}
+
+func (s *plasmaStore) Persist() error {
+	return s.ps.PersistAllContext(context.Background())
+}
+
+func (s *plasmaStore) Close() {
+	s.ps.Close()
+}
+
+type plasmaWriter struct {
+	ps *plasma.Plasma
+	w  *plasma.Writer
+}
+
+func (w *plasmaWriter) Put(k, v []byte) error {
+	_, err := w.w.ReplaceKV(k, v)
+	return err
+}
+
+func (w *plasmaWriter) Delete(k []byte) error {
+	_, err := w.w.DeleteKV(k)
+	return err
+}
+
+func (w *plasmaWriter) Get(k []byte) ([]byte, error) {
+	v, err := w.w.LookupKV(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), v...), nil
+}
+
+func (w *plasmaWriter) Close() {
+	w.ps.ReleaseWriter(w.w)
+	w.w = nil
+}
+
+type plasmaSnapshot struct {
+	snap *plasma.Snapshot
+}
+
+func (s *plasmaSnapshot) NewIterator() Iterator {
+	return &plasmaIterator{s.snap.NewIterator()}
+}
+
+func (s *plasmaSnapshot) Close() {
+	s.snap.Close()
+}
+
+// Iterator positioning methods return errors, which are also returned by
+// the Err method of the Plasma iterator
+type plasmaIterator struct {
+	*plasma.MVCCIterator
+}
+
+func (it *plasmaIterator) SeekFirst() {
+	it.MVCCIterator.SeekFirst()
+}
+
+func (it *plasmaIterator) Next() {
+	it.MVCCIterator.Next()
+}
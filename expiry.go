@@ -0,0 +1,100 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/couchbase/nitro/skiplist"
+)
+
+// Expiry is kept as unix time in seconds
+var expiryClock = func() uint32 {
+	return uint32(time.Now().Unix())
+}
+
+func isExpired(itm *Item) bool {
+	expiry := itm.Expiry()
+	return expiry != 0 && expiry <= expiryClock()
+}
+
+// Expiry returns the unix time in seconds at which the item expires, or 0
+// if the item does not expire
+func (itm *Item) Expiry() uint32 {
+	if itm.hasExpiry() {
+		return *itm.expiryPtr()
+	}
+
+	return 0
+}
+
+// PutWithTTL inserts an item which expires after the ttl. Expired items are
+// not visible in the snapshots created after the expiry and they are purged
+// by the snapshot GC, without having to be deleted. An expired item which is
+// yet to be purged does not prevent the item from being put again.
+// Expiry is not retained by disk backups. PutWithTTL panics unless the
+// instance is configured by Config.EnableTTL.
+func (w *Writer) PutWithTTL(bs []byte, ttl time.Duration) *skiplist.Node {
+	if !w.useTTL {
+		panic("nitro: PutWithTTL requires Config.EnableTTL")
+	}
+
+	expiry := expiryClock() + uint32((ttl+time.Second-1)/time.Second)
+	return w.put(w.newItemWithExpiry(bs, expiry, w.useMemoryMgmt))
+}
+
+// Examines the next expirySweepRate items from the sweep cursor and marks
+// the expired items as deleted in the snapshot, so that they are collected
+// along with the snapshot. Writers are not active during NewSnapshot, hence
+// the items cannot be deleted concurrently.
+func (m *Nitro) sweepExpired(snap *Snapshot) (head, tail *skiplist.Node) {
+	buf := m.store.MakeBuf()
+	defer m.store.FreeBuf(buf)
+
+	iter := m.store.NewIterator(m.iterCmp, buf)
+	defer iter.Close()
+
+	if m.sweepCursor == nil {
+		iter.SeekFirst()
+	} else {
+		iter.Seek(unsafe.Pointer(m.sweepCursor))
+	}
+
+	var count int64
+	for i := 0; i < m.expirySweepRate && iter.Valid(); i++ {
+		n := iter.GetNode()
+		itm := (*Item)(n.Item())
+		if expiry := itm.Expiry(); expiry != 0 && expiry <= snap.ts &&
+			atomic.CompareAndSwapUint32(&itm.deadSn, 0, snap.sn) {
+
+			n.SetLink(nil)
+			if tail == nil {
+				head = n
+			} else {
+				tail.SetLink(n)
+			}
+			tail = n
+			count++
+		}
+
+		iter.Next()
+	}
+
+	// Sweep starts over once the end is reached
+	m.sweepCursor = nil
+	if iter.Valid() {
+		m.sweepCursor = m.ptrToItem(iter.Get())
+	}
+
+	atomic.AddInt64(&m.itemsCount, -count)
+	return
+}
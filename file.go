@@ -10,6 +10,7 @@
 package nitro
 
 import "os"
+import "io"
 import "bufio"
 import "errors"
 
@@ -46,25 +47,28 @@ type FileReader interface {
 func (m *Nitro) newFileWriter(t FileType) FileWriter {
 	var w FileWriter
 	if t == RawdbFile {
-		w = &rawFileWriter{db: m}
+		w = &rawFileWriter{db: m, compressor: m.compressor}
 	}
 	return w
 }
 
-func (m *Nitro) newFileReader(t FileType, ver int) FileReader {
+func (m *Nitro) newFileReader(t FileType, ver int, c BlockCompressor) FileReader {
 	var r FileReader
 	if t == RawdbFile {
-		r = &rawFileReader{db: m, version: ver}
+		r = &rawFileReader{db: m, version: ver, compressor: c}
 	}
 	return r
 }
 
+// Items are written as compressed blocks if a compressor is provided
 type rawFileWriter struct {
-	db   *Nitro
-	fd   *os.File
-	w    *bufio.Writer
-	buf  []byte
-	path string
+	db         *Nitro
+	fd         *os.File
+	w          *bufio.Writer
+	bw         *blockWriter
+	compressor BlockCompressor
+	buf        []byte
+	path       string
 }
 
 func (f *rawFileWriter) Open(path string) error {
@@ -73,32 +77,64 @@ func (f *rawFileWriter) Open(path string) error {
 	if err == nil {
 		f.buf = make([]byte, encodeBufSize)
 		f.w = bufio.NewWriterSize(f.fd, DiskBlockSize)
+		if f.compressor != nil {
+			f.bw = newBlockWriter(f.w, f.compressor)
+		}
 	}
 	return err
 }
 
 func (f *rawFileWriter) WriteItem(itm *Item) error {
+	if f.bw != nil {
+		return f.db.EncodeItem(itm, f.buf, f.bw)
+	}
 	return f.db.EncodeItem(itm, f.buf, f.w)
 }
 
+// The last block and the terminator are written only by Close, hence the
+// file is complete only if it returns nil
 func (f *rawFileWriter) Close() error {
 	terminator := &Item{}
 
-	if err := f.WriteItem(terminator); err != nil {
-		return err
+	err := f.WriteItem(terminator)
+	if err == nil && f.bw != nil {
+		err = f.bw.Close()
 	}
 
-	f.w.Flush()
-	return f.fd.Close()
+	if err == nil {
+		err = f.w.Flush()
+	}
+
+	if cerr := f.fd.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Closes the writers which are open and returns the first error
+func closeFileWriters(writers []FileWriter) error {
+	var err error
+	for i, w := range writers {
+		if w != nil {
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+			writers[i] = nil
+		}
+	}
+
+	return err
 }
 
 type rawFileReader struct {
-	version int
-	db      *Nitro
-	fd      *os.File
-	r       *bufio.Reader
-	buf     []byte
-	path    string
+	version    int
+	db         *Nitro
+	fd         *os.File
+	r          io.Reader
+	compressor BlockCompressor
+	buf        []byte
+	path       string
 }
 
 func (f *rawFileReader) Open(path string) error {
@@ -107,6 +143,9 @@ func (f *rawFileReader) Open(path string) error {
 	if err == nil {
 		f.buf = make([]byte, encodeBufSize)
 		f.r = bufio.NewReaderSize(f.fd, DiskBlockSize)
+		if f.compressor != nil {
+			f.r = newBlockReader(f.r, f.compressor)
+		}
 	}
 	return err
 }
@@ -0,0 +1,364 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package importer loads CSV or newline delimited JSON records into a
+// store. Each record is mapped into a key value pair by a user function.
+//
+//	cfg := importer.DefaultConfig(importer.CSV)
+//	cfg.Header = true
+//	cfg.Map = func(r *importer.Record) ([]byte, []byte, error) {
+//		return []byte(r.Field("id")), []byte(r.Field("name")), nil
+//	}
+//	n, err := importer.Import(f, store, cfg)
+//
+// The input is read in batches of records, which are decoded and mapped by
+// concurrent workers. Items are fed into the bulk loader of the store if it
+// implements engine.BulkLoader, and are written by a store writer of each
+// worker otherwise. CSV records are split by a single reader, as quoted
+// fields may span lines.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/couchbase/nitro/engine"
+)
+
+// ErrNoMapFunc means the configuration has no map function
+var ErrNoMapFunc = errors.New("Map function is not configured")
+
+// Format of the input
+type Format int
+
+const (
+	// CSV has a record of comma separated fields per line
+	CSV Format = iota
+	// JSON has a JSON object per line
+	JSON
+)
+
+// Record is an input record passed to the map function
+type Record struct {
+	// Line number of the record, starting from 1
+	Line int64
+	// Fields of a CSV record and the header, if any
+	Fields []string
+	Header []string
+	// Decoded JSON object. Numbers are decoded as json.Number.
+	Object map[string]interface{}
+}
+
+// Field returns the CSV field with the header name or the JSON object
+// field with the name formatted as a string. It returns an empty string
+// if the field does not exist.
+func (r *Record) Field(name string) string {
+	if r.Object != nil {
+		switch v := r.Object[name].(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+
+	for i, h := range r.Header {
+		if h == name && i < len(r.Fields) {
+			return r.Fields[i]
+		}
+	}
+
+	return ""
+}
+
+// MapFunc maps a record into a key value pair. A nil key skips the record.
+type MapFunc func(r *Record) (k, v []byte, err error)
+
+// ProgressCallback is called with the number of records imported and the
+// number of bytes read so far
+type ProgressCallback func(records, bytes int64)
+
+// Config - importer configuration
+type Config struct {
+	Format Format
+	Map    MapFunc
+
+	// First CSV record holds the field names
+	Header bool
+	// CSV field delimiter
+	Comma rune
+
+	Concurrency int
+	BatchSize   int
+	Progress    ProgressCallback
+}
+
+// DefaultConfig returns a configuration for the format. Map function has
+// to be set by the caller.
+func DefaultConfig(f Format) Config {
+	return Config{
+		Format:      f,
+		Comma:       ',',
+		Concurrency: runtime.GOMAXPROCS(0),
+		BatchSize:   1000,
+	}
+}
+
+// Records read from the input, which are raw lines of JSON or CSV fields,
+// along with their line numbers
+type batch struct {
+	nums   []int64
+	lines  [][]byte
+	fields [][]string
+	bytes  int64
+}
+
+type importer struct {
+	cfg    Config
+	header []string
+
+	done chan struct{}
+	once sync.Once
+	err  error
+
+	mu      sync.Mutex
+	records int64
+	bytes   int64
+}
+
+// Import reads the records from the input and writes them into the store.
+// It returns the number of records imported. Import stops at the first
+// error returned by the input, the map function or the store. Items loaded
+// in bulk are written into the store once the input is read, and none of
+// them are written if the import fails.
+func Import(r io.Reader, s engine.Store, cfg Config) (int64, error) {
+	if cfg.Map == nil {
+		return 0, ErrNoMapFunc
+	}
+
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+
+	imp := &importer{cfg: cfg, done: make(chan struct{})}
+	bchan := make(chan *batch, cfg.Concurrency)
+
+	var loader engine.Loader
+	if bl, ok := s.(engine.BulkLoader); ok {
+		loader = bl.NewLoader()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			put := func(k, v []byte) error {
+				return loader.Add(k, v)
+			}
+
+			if loader == nil {
+				w := s.NewWriter()
+				defer w.Close()
+				put = w.Put
+			}
+
+			for b := range bchan {
+				if err := imp.process(put, b); err != nil {
+					imp.fail(err)
+				}
+			}
+		}()
+	}
+
+	var err error
+	if cfg.Format == JSON {
+		err = imp.readJSON(r, bchan)
+	} else {
+		err = imp.readCSV(r, bchan)
+	}
+
+	if err != nil {
+		imp.fail(err)
+	}
+
+	close(bchan)
+	wg.Wait()
+
+	if loader != nil {
+		if imp.err != nil {
+			return 0, imp.err
+		}
+
+		if err := loader.Load(); err != nil {
+			return 0, err
+		}
+	}
+
+	return imp.records, imp.err
+}
+
+func (imp *importer) fail(err error) {
+	imp.once.Do(func() {
+		imp.err = err
+		close(imp.done)
+	})
+}
+
+// Returns false once the import has failed
+func (imp *importer) send(bchan chan *batch, b *batch) bool {
+	select {
+	case bchan <- b:
+		return true
+	case <-imp.done:
+		return false
+	}
+}
+
+func (imp *importer) readJSON(r io.Reader, bchan chan *batch) error {
+	br := bufio.NewReader(r)
+	b := &batch{}
+	var line int64
+	for {
+		bs, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if line++; len(bytes.TrimSpace(bs)) > 0 {
+			b.nums = append(b.nums, line)
+			b.lines = append(b.lines, bs)
+		}
+		b.bytes += int64(len(bs))
+
+		if len(b.lines) == imp.cfg.BatchSize || (err == io.EOF && len(b.lines) > 0) {
+			if !imp.send(bchan, b) {
+				return nil
+			}
+			b = &batch{}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (imp *importer) readCSV(r io.Reader, bchan chan *batch) error {
+	cr := csv.NewReader(r)
+	cr.Comma = imp.cfg.Comma
+	cr.FieldsPerRecord = -1
+
+	if imp.cfg.Header {
+		header, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		imp.header = header
+	}
+
+	b := &batch{}
+	var offset int64
+	for {
+		fields, err := cr.Read()
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if fields != nil {
+			line, _ := cr.FieldPos(0)
+			b.nums = append(b.nums, int64(line))
+			b.fields = append(b.fields, fields)
+		}
+
+		if len(b.fields) == imp.cfg.BatchSize || (err == io.EOF && len(b.fields) > 0) {
+			curr := cr.InputOffset()
+			b.bytes, offset = curr-offset, curr
+			if !imp.send(bchan, b) {
+				return nil
+			}
+			b = &batch{}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (imp *importer) process(put func(k, v []byte) error, b *batch) error {
+	select {
+	case <-imp.done:
+		return nil
+	default:
+	}
+
+	var count int64
+	write := func(r *Record) error {
+		k, v, err := imp.cfg.Map(r)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", r.Line, err)
+		}
+
+		if k != nil {
+			if err := put(k, v); err != nil {
+				return err
+			}
+			count++
+		}
+
+		return nil
+	}
+
+	for i, line := range b.lines {
+		r := &Record{Line: b.nums[i]}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		if err := dec.Decode(&r.Object); err != nil {
+			return fmt.Errorf("line %d: %v", r.Line, err)
+		}
+
+		if err := write(r); err != nil {
+			return err
+		}
+	}
+
+	for i, fields := range b.fields {
+		r := &Record{Line: b.nums[i], Fields: fields, Header: imp.header}
+		if err := write(r); err != nil {
+			return err
+		}
+	}
+
+	imp.mu.Lock()
+	defer imp.mu.Unlock()
+	imp.records += count
+	imp.bytes += b.bytes
+	if imp.cfg.Progress != nil {
+		imp.cfg.Progress(imp.records, imp.bytes)
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/nitro/engine"
+)
+
+func newTestStore(t *testing.T) engine.Store {
+	s, err := engine.New(engine.DefaultConfig(engine.MemDB, ""))
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	return s
+}
+
+func checkItems(t *testing.T, s engine.Store, n int, skip func(i int) bool) {
+	w := s.NewWriter()
+	for i := 0; i < n; i++ {
+		k := fmt.Sprintf("key-%10d", i)
+		v, err := w.Get([]byte(k))
+		if skip(i) {
+			if err != engine.ErrItemNotFound {
+				t.Fatalf("Expected %s to be skipped, got %v", k, err)
+			}
+		} else if exp := fmt.Sprintf("val-%d", i); err != nil || string(v) != exp {
+			t.Fatalf("Expected %s for %s, got %s, %v", exp, k, v, err)
+		}
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	var buf bytes.Buffer
+	n := 10000
+	buf.WriteString("id;name\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "%d;\"val-%d\"\n", i, i)
+	}
+	size := int64(buf.Len())
+
+	var records, read int64
+	cfg := DefaultConfig(CSV)
+	cfg.Header = true
+	cfg.Comma = ';'
+	cfg.Concurrency = 4
+	cfg.BatchSize = 100
+	cfg.Progress = func(r, b int64) {
+		records, read = r, b
+	}
+	cfg.Map = func(r *Record) ([]byte, []byte, error) {
+		var id int
+		fmt.Sscan(r.Field("id"), &id)
+		if id%10 == 0 {
+			return nil, nil, nil
+		}
+		return []byte(fmt.Sprintf("key-%10d", id)), []byte(r.Field("name")), nil
+	}
+
+	count, err := Import(&buf, s, cfg)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	if exp := int64(n - n/10); count != exp || records != exp {
+		t.Errorf("Expected %d records, got %d, %d", exp, count, records)
+	}
+
+	if read != size {
+		t.Errorf("Expected %d bytes, got %d", size, read)
+	}
+
+	checkItems(t, s, n, func(i int) bool { return i%10 == 0 })
+}
+
+func TestImportJSON(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	var buf bytes.Buffer
+	n := 10000
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "{\"id\": %d, \"name\": \"val-%d\"}\n", i, i)
+		if i%100 == 0 {
+			buf.WriteString("\n")
+		}
+	}
+	size := int64(buf.Len())
+
+	var read int64
+	cfg := DefaultConfig(JSON)
+	cfg.BatchSize = 100
+	cfg.Progress = func(_, b int64) {
+		read = b
+	}
+	cfg.Map = func(r *Record) ([]byte, []byte, error) {
+		var id int
+		fmt.Sscan(r.Field("id"), &id)
+		return []byte(fmt.Sprintf("key-%10d", id)), []byte(r.Field("name")), nil
+	}
+
+	count, err := Import(&buf, s, cfg)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	if count != int64(n) || read != size {
+		t.Errorf("Expected %d records and %d bytes, got %d, %d", n, size, count, read)
+	}
+
+	checkItems(t, s, n, func(int) bool { return false })
+}
+
+func TestImportWriters(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	defer os.RemoveAll("teststore.data")
+	s, err := engine.New(engine.DefaultConfig(engine.Plasma, "teststore.data"))
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer s.Close()
+
+	var buf bytes.Buffer
+	n := 1000
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "%d,val-%d\n", i, i)
+	}
+
+	// Plasma is not a bulk loader, hence items are written by writers
+	cfg := DefaultConfig(CSV)
+	cfg.BatchSize = 100
+	cfg.Map = func(r *Record) ([]byte, []byte, error) {
+		var id int
+		fmt.Sscan(r.Fields[0], &id)
+		return []byte(fmt.Sprintf("key-%10d", id)), []byte(r.Fields[1]), nil
+	}
+
+	if count, err := Import(&buf, s, cfg); err != nil || count != int64(n) {
+		t.Fatalf("Expected %d records, got %d, %v", n, count, err)
+	}
+
+	checkItems(t, s, n, func(int) bool { return false })
+}
+
+func TestImportErrors(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	if _, err := Import(strings.NewReader(""), s, DefaultConfig(CSV)); err != ErrNoMapFunc {
+		t.Errorf("Expected ErrNoMapFunc, got %v", err)
+	}
+
+	errBad := errors.New("bad record")
+	cfg := DefaultConfig(CSV)
+	cfg.BatchSize = 2
+	cfg.Map = func(r *Record) ([]byte, []byte, error) {
+		if r.Fields[0] == "bad" {
+			return nil, nil, errBad
+		}
+		return []byte(r.Fields[0]), nil, nil
+	}
+
+	input := "a\nb\n\"multi\nline\"\nbad\nc\n"
+	if _, err := Import(strings.NewReader(input), s, cfg); err == nil || err.Error() != "line 5: bad record" {
+		t.Errorf("Expected error on line 5, got %v", err)
+	}
+
+	cfg.Format = JSON
+	cfg.Map = func(r *Record) ([]byte, []byte, error) {
+		return nil, nil, nil
+	}
+	input = "{\"a\": 1}\n\n{\"b\": \n"
+	if _, err := Import(strings.NewReader(input), s, cfg); err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("Expected error on line 3, got %v", err)
+	}
+}
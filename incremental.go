@@ -0,0 +1,327 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+var (
+	// ErrInvalidBaseSnapshot means the base of an incremental backup is not
+	// older than the snapshot being backed up
+	ErrInvalidBaseSnapshot = errors.New("Base snapshot is not older than the snapshot")
+)
+
+// Layout of an incremental backup directory:
+// nitro.json - manifest referring to the parent backup
+// data/      - items inserted since the base snapshot
+// deleted/   - items of the base snapshot deleted since then
+const deletedDir = "deleted"
+
+// StoreIncrementalToDisk backups only the items changed between the base and
+// the snap snapshots, where base is the snapshot persisted into parentDir by
+// StoreToDisk or StoreIncrementalToDisk. The changes are identified by the
+// sequence numbers of the items, hence the base snapshot should be kept open
+// until this call returns so that the deleted items are not collected.
+// Only the snap snapshot is closed once done.
+func (m *Nitro) StoreIncrementalToDisk(dir, parentDir string, base, snap *Snapshot,
+	concurr int, itmCallback ItemCallback) (err error) {
+	defer snap.Close()
+
+	if base.sn >= snap.sn {
+		return ErrInvalidBaseSnapshot
+	}
+
+	if m.useMemoryMgmt {
+		m.shutdownWg1.Add(1)
+		defer m.shutdownWg1.Done()
+	}
+
+	// Keep the chain relocatable when backups are siblings
+	parent := parentDir
+	if rel, err := filepath.Rel(dir, parentDir); err == nil {
+		parent = rel
+	}
+
+	mf := backupManifest{Version: version, Sn: snap.sn, Parent: parent, BaseSn: base.sn}
+	if m.compressor != nil {
+		mf.Compression = m.compressor.Name()
+	}
+
+	// Items visible in snap, which were not visible in base
+	inserted := func(itm *Item) bool {
+		return !base.isVisible(itm)
+	}
+
+	if err = m.storeItems(filepath.Join(dir, "data"), snap, concurr, inserted, itmCallback); err != nil {
+		return err
+	}
+
+	// Items visible in base, which are not visible in snap
+	deleted := func(itm *Item) bool {
+		return !snap.isVisible(itm)
+	}
+
+	if err = m.storeItems(filepath.Join(dir, deletedDir), base, concurr, deleted, nil); err != nil {
+		return err
+	}
+
+	// Manifest is written once the backup is complete
+	manifest, _ := json.Marshal(mf)
+	return ioutil.WriteFile(filepath.Join(dir, "nitro.json"), manifest, 0660)
+}
+
+// Writes the items of the snapshot accepted by the filter as shard files
+func (m *Nitro) storeItems(datadir string, snap *Snapshot, concurr int,
+	filter func(*Item) bool, itmCallback ItemCallback) error {
+
+	os.MkdirAll(datadir, 0755)
+	shards := runtime.NumCPU()
+
+	writers := make([]FileWriter, shards)
+	files := make([]string, shards)
+	defer closeFileWriters(writers)
+
+	for shard := 0; shard < shards; shard++ {
+		w := m.newFileWriter(m.fileType)
+		file := fmt.Sprintf("shard-%d", shard)
+		if err := w.Open(filepath.Join(datadir, file)); err != nil {
+			return err
+		}
+
+		writers[shard] = w
+		files[shard] = file
+	}
+
+	visitorCallback := func(itm *Item, shard int) error {
+		if m.hasShutdown {
+			return ErrShutdown
+		}
+
+		if !filter(itm) {
+			return nil
+		}
+
+		if err := writers[shard].WriteItem(itm); err != nil {
+			return err
+		}
+
+		if itmCallback != nil {
+			itmCallback(&ItemEntry{itm: itm, n: nil})
+		}
+
+		return nil
+	}
+
+	if err := m.Visitor(snap, visitorCallback, shards, concurr); err != nil {
+		return err
+	}
+
+	// Files are listed once they are complete
+	if err := closeFileWriters(writers); err != nil {
+		return err
+	}
+
+	bs, _ := json.Marshal(files)
+	return ioutil.WriteFile(filepath.Join(datadir, "files.json"), bs, 0660)
+}
+
+// LoadIncrementalFromDisk restores Nitro from a chain of incremental backups
+// ending at dir. The full backup at the start of the chain is loaded first
+// and the changes recorded by each incremental backup are applied in order.
+// A full backup may also be given, which is same as LoadFromDisk.
+func (m *Nitro) LoadIncrementalFromDisk(dir string, concurr int, callb ItemCallback) (*Snapshot, error) {
+	var chain []string
+	var manifests []backupManifest
+
+	if concurr <= 0 {
+		concurr = runtime.GOMAXPROCS(0)
+	}
+
+	for {
+		mf, err := readBackupManifest(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		if mf.Parent == "" {
+			break
+		}
+
+		chain = append(chain, dir)
+		manifests = append(manifests, mf)
+		if filepath.IsAbs(mf.Parent) {
+			dir = mf.Parent
+		} else {
+			dir = filepath.Join(dir, mf.Parent)
+		}
+	}
+
+	snap, err := m.LoadFromDisk(dir, concurr, callb)
+	if err != nil || len(chain) == 0 {
+		return snap, err
+	}
+	defer snap.Close()
+
+	m.loadLock.Lock()
+	defer m.loadLock.Unlock()
+	writers := m.getLoadWriters(concurr)
+
+	deleteItem := func(w *Writer, itm *Item) {
+		w.Delete(itm.Bytes())
+		w.freeItem(itm)
+	}
+
+	insertItem := func(w *Writer, itm *Item) {
+		itm.bornSn = w.getCurrSn()
+		if n, success := w.store.Insert2(unsafe.Pointer(itm),
+			w.insCmp, w.existCmp, w.buf, w.rand.Float32, &w.slSts1); success {
+			w.count++
+			if callb != nil {
+				callb(&ItemEntry{itm: itm, n: n})
+			}
+		} else {
+			w.freeItem(itm)
+		}
+	}
+
+	// Deletions are applied before insertions, since an item replaced
+	// since the base snapshot is recorded in both
+	for i := len(chain) - 1; i >= 0; i-- {
+		mf := manifests[i]
+		compressor, err := getManifestCompressor(mf)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = m.loadItems(filepath.Join(chain[i], deletedDir), mf.Version,
+			compressor, writers, deleteItem); err != nil {
+			return nil, err
+		}
+
+		if err = m.loadItems(filepath.Join(chain[i], "data"), mf.Version,
+			compressor, writers, insertItem); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.NewSnapshot()
+}
+
+// Writers cannot be released, hence the writers applying the incremental
+// backups are reused by the later loads. It should be called with loadLock
+// held.
+func (m *Nitro) getLoadWriters(n int) []*Writer {
+	for len(m.loadWriters) < n {
+		m.loadWriters = append(m.loadWriters, m.NewWriter())
+	}
+
+	return m.loadWriters[:n]
+}
+
+// Reads the shard files concurrently by using the writers
+func (m *Nitro) loadItems(datadir string, ver int, c BlockCompressor,
+	writers []*Writer, callb func(*Writer, *Item)) error {
+
+	var wg sync.WaitGroup
+	var files []string
+
+	bs, err := ioutil.ReadFile(filepath.Join(datadir, "files.json"))
+	if err != nil {
+		return err
+	}
+	json.Unmarshal(bs, &files)
+
+	readers := make([]FileReader, len(files))
+	errors := make([]error, len(files))
+	defer func() {
+		for _, r := range readers {
+			if r != nil {
+				r.Close()
+			}
+		}
+	}()
+
+	for i, file := range files {
+		r := m.newFileReader(m.fileType, ver, c)
+		if err := r.Open(filepath.Join(datadir, file)); err != nil {
+			return err
+		}
+
+		readers[i] = r
+	}
+
+	wchan := make(chan int)
+	for _, w := range writers {
+		wg.Add(1)
+		go func(w *Writer) {
+			defer wg.Done()
+
+			for shard := range wchan {
+				r := readers[shard]
+				for {
+					itm, err := r.ReadItem()
+					if err != nil {
+						errors[shard] = err
+						break
+					}
+
+					if itm == nil {
+						break
+					}
+
+					callb(w, itm)
+				}
+			}
+		}(w)
+	}
+
+	for i := range files {
+		wchan <- i
+	}
+	close(wchan)
+	wg.Wait()
+
+	for _, err := range errors {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readBackupManifest(dir string) (backupManifest, error) {
+	var mf backupManifest
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "nitro.json"))
+	if err == nil {
+		err = json.Unmarshal(bs, &mf)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+
+	return mf, err
+}
+
+func getManifestCompressor(mf backupManifest) (BlockCompressor, error) {
+	if mf.Compression == "" {
+		return nil, nil
+	}
+
+	return getBlockCompressor(mf.Compression)
+}
@@ -19,6 +19,14 @@ import (
 
 var itemHeaderSize = unsafe.Sizeof(Item{})
 
+// Items put with a ttl carry the expiry between the header and the data,
+// which is flagged in the dataLen
+const (
+	itemExpirySize    = unsafe.Sizeof(uint32(0))
+	itemHasExpiryFlag = uint32(1) << 31
+	itemDataLenMask   = itemHasExpiryFlag - 1
+)
+
 // Item represents nitro item header
 // The item data is followed by the header.
 // Item data is a block of bytes. The user can store key and value into a
@@ -36,6 +44,14 @@ func (m *Nitro) newItem(data []byte, useMM bool) (itm *Item) {
 	return itm
 }
 
+func (m *Nitro) newItemWithExpiry(data []byte, expiry uint32, useMM bool) (itm *Item) {
+	l := len(data)
+	itm = m.allocItem2(l, true, useMM)
+	*itm.expiryPtr() = expiry
+	copy(itm.Bytes(), data)
+	return itm
+}
+
 func (m *Nitro) freeItem(itm *Item) {
 	if m.useMemoryMgmt {
 		m.freeFun(unsafe.Pointer(itm))
@@ -43,7 +59,15 @@ func (m *Nitro) freeItem(itm *Item) {
 }
 
 func (m *Nitro) allocItem(l int, useMM bool) (itm *Item) {
+	return m.allocItem2(l, false, useMM)
+}
+
+func (m *Nitro) allocItem2(l int, hasExpiry bool, useMM bool) (itm *Item) {
 	blockSize := itemHeaderSize + uintptr(l)
+	if hasExpiry {
+		blockSize += itemExpirySize
+	}
+
 	if useMM {
 		itm = (*Item)(m.mallocFun(int(blockSize)))
 		itm.deadSn = 0
@@ -54,9 +78,28 @@ func (m *Nitro) allocItem(l int, useMM bool) (itm *Item) {
 	}
 
 	itm.dataLen = uint32(l)
+	if hasExpiry {
+		itm.dataLen |= itemHasExpiryFlag
+	}
 	return
 }
 
+func (itm *Item) hasExpiry() bool {
+	return itm.dataLen&itemHasExpiryFlag != 0
+}
+
+func (itm *Item) expiryPtr() *uint32 {
+	return (*uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(itm)) + itemHeaderSize))
+}
+
+func (itm *Item) dataOffset() uintptr {
+	if itm.hasExpiry() {
+		return itemHeaderSize + itemExpirySize
+	}
+
+	return itemHeaderSize
+}
+
 // EncodeItem encodes in [4 byte len][item_bytes] format.
 func (m *Nitro) EncodeItem(itm *Item, buf []byte, w io.Writer) error {
 	l := 4
@@ -64,7 +107,7 @@ func (m *Nitro) EncodeItem(itm *Item, buf []byte, w io.Writer) error {
 		return errNotEnoughSpace
 	}
 
-	binary.BigEndian.PutUint32(buf[0:4], uint32(itm.dataLen))
+	binary.BigEndian.PutUint32(buf[0:4], itm.dataLen&itemDataLenMask)
 	if _, err := w.Write(buf[0:4]); err != nil {
 		return err
 	}
@@ -105,8 +148,8 @@ func (m *Nitro) DecodeItem(ver int, buf []byte, r io.Reader) (*Item, error) {
 
 // Bytes return item data bytes
 func (itm *Item) Bytes() (bs []byte) {
-	l := itm.dataLen
-	dataOffset := uintptr(unsafe.Pointer(itm)) + itemHeaderSize
+	l := itm.dataLen & itemDataLenMask
+	dataOffset := uintptr(unsafe.Pointer(itm)) + itm.dataOffset()
 
 	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&bs))
 	hdr.Data = dataOffset
@@ -118,7 +161,7 @@ func (itm *Item) Bytes() (bs []byte) {
 // ItemSize returns total bytes consumed by item representation
 func ItemSize(p unsafe.Pointer) int {
 	itm := (*Item)(p)
-	return int(itemHeaderSize + uintptr(itm.dataLen))
+	return int(itm.dataOffset() + uintptr(itm.dataLen&itemDataLenMask))
 }
 
 // KVToBytes encodes key-value pair to item bytes which can be passed
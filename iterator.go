@@ -30,7 +30,7 @@ loop:
 		return
 	}
 	itm := (*Item)(it.iter.Get())
-	if itm.bornSn > it.snap.sn || (itm.deadSn > 0 && itm.deadSn <= it.snap.sn) {
+	if !it.snap.isVisible(itm) {
 		it.iter.Next()
 		it.count++
 		goto loop
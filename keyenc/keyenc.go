@@ -0,0 +1,243 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package keyenc provides order-preserving encoders for composite keys.
+//
+// Every field is encoded such that bytes.Compare on the encoded keys yields
+// the same ordering as comparing the fields one after another. Hence the
+// encoded keys can be used with the default byte-wise comparators of nitro
+// and plasma without writing a custom CompareFn.
+//
+// Fields are appended to a byte slice one by one:
+//
+//	k := keyenc.AppendString(nil, "user")
+//	k = keyenc.AppendUint64(k, 42)
+//	k = keyenc.AppendInt64Desc(k, ts)
+//
+// and decoded in the same order using the corresponding Decode functions.
+package keyenc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	// ErrShortBuffer means the encoded key ended before the field was complete
+	ErrShortBuffer = errors.New("keyenc: insufficient bytes to decode field")
+	// ErrInvalidEncoding means the field bytes are not a valid encoding
+	ErrInvalidEncoding = errors.New("keyenc: invalid field encoding")
+)
+
+const (
+	escByte  byte = 0x00
+	escZero  byte = 0xff
+	escTerm  byte = 0x01
+	signMask      = uint64(1) << 63
+)
+
+// AppendUint64 appends an ascending order encoding of v
+func AppendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// AppendUint64Desc appends a descending order encoding of v
+func AppendUint64Desc(b []byte, v uint64) []byte {
+	return AppendUint64(b, ^v)
+}
+
+// DecodeUint64 decodes a field encoded by AppendUint64 and returns the
+// remaining bytes
+func DecodeUint64(b []byte) (uint64, []byte, error) {
+	if len(b) < 8 {
+		return 0, b, ErrShortBuffer
+	}
+
+	return binary.BigEndian.Uint64(b[:8]), b[8:], nil
+}
+
+// DecodeUint64Desc decodes a field encoded by AppendUint64Desc
+func DecodeUint64Desc(b []byte) (uint64, []byte, error) {
+	v, rest, err := DecodeUint64(b)
+	return ^v, rest, err
+}
+
+// AppendInt64 appends an ascending order encoding of v
+func AppendInt64(b []byte, v int64) []byte {
+	return AppendUint64(b, uint64(v)^signMask)
+}
+
+// AppendInt64Desc appends a descending order encoding of v
+func AppendInt64Desc(b []byte, v int64) []byte {
+	return AppendUint64Desc(b, uint64(v)^signMask)
+}
+
+// DecodeInt64 decodes a field encoded by AppendInt64
+func DecodeInt64(b []byte) (int64, []byte, error) {
+	v, rest, err := DecodeUint64(b)
+	return int64(v ^ signMask), rest, err
+}
+
+// DecodeInt64Desc decodes a field encoded by AppendInt64Desc
+func DecodeInt64Desc(b []byte) (int64, []byte, error) {
+	v, rest, err := DecodeUint64Desc(b)
+	return int64(v ^ signMask), rest, err
+}
+
+// Negative floats have all bits flipped and positive floats only the sign
+// bit, which orders -Inf < negatives < -0 < +0 < positives < +Inf.
+func floatToOrdered(f float64) uint64 {
+	u := math.Float64bits(f)
+	if u&signMask != 0 {
+		return ^u
+	}
+
+	return u | signMask
+}
+
+func orderedToFloat(u uint64) float64 {
+	if u&signMask != 0 {
+		return math.Float64frombits(u &^ signMask)
+	}
+
+	return math.Float64frombits(^u)
+}
+
+// AppendFloat64 appends an ascending order encoding of f
+func AppendFloat64(b []byte, f float64) []byte {
+	return AppendUint64(b, floatToOrdered(f))
+}
+
+// AppendFloat64Desc appends a descending order encoding of f
+func AppendFloat64Desc(b []byte, f float64) []byte {
+	return AppendUint64Desc(b, floatToOrdered(f))
+}
+
+// DecodeFloat64 decodes a field encoded by AppendFloat64
+func DecodeFloat64(b []byte) (float64, []byte, error) {
+	u, rest, err := DecodeUint64(b)
+	return orderedToFloat(u), rest, err
+}
+
+// DecodeFloat64Desc decodes a field encoded by AppendFloat64Desc
+func DecodeFloat64Desc(b []byte) (float64, []byte, error) {
+	u, rest, err := DecodeUint64Desc(b)
+	return orderedToFloat(u), rest, err
+}
+
+// AppendBytes appends an ascending order encoding of a variable length
+// byte string. Zero bytes are escaped as 0x00 0xff and the field is
+// terminated by 0x00 0x01, so that a string always sorts before any
+// of its extensions and the following fields do not affect the ordering.
+func AppendBytes(b []byte, v []byte) []byte {
+	for _, c := range v {
+		if c == escByte {
+			b = append(b, escByte, escZero)
+		} else {
+			b = append(b, c)
+		}
+	}
+
+	return append(b, escByte, escTerm)
+}
+
+// AppendBytesDesc appends a descending order encoding of v
+func AppendBytesDesc(b []byte, v []byte) []byte {
+	n := len(b)
+	b = AppendBytes(b, v)
+	invert(b[n:])
+	return b
+}
+
+// AppendString appends an ascending order encoding of s
+func AppendString(b []byte, s string) []byte {
+	return AppendBytes(b, []byte(s))
+}
+
+// AppendStringDesc appends a descending order encoding of s
+func AppendStringDesc(b []byte, s string) []byte {
+	return AppendBytesDesc(b, []byte(s))
+}
+
+// DecodeBytes decodes a field encoded by AppendBytes. The returned slice
+// is a newly allocated copy.
+func DecodeBytes(b []byte) ([]byte, []byte, error) {
+	return decodeBytes(b, 0)
+}
+
+// DecodeBytesDesc decodes a field encoded by AppendBytesDesc
+func DecodeBytesDesc(b []byte) ([]byte, []byte, error) {
+	return decodeBytes(b, 0xff)
+}
+
+// DecodeString decodes a field encoded by AppendString
+func DecodeString(b []byte) (string, []byte, error) {
+	v, rest, err := DecodeBytes(b)
+	return string(v), rest, err
+}
+
+// DecodeStringDesc decodes a field encoded by AppendStringDesc
+func DecodeStringDesc(b []byte) (string, []byte, error) {
+	v, rest, err := DecodeBytesDesc(b)
+	return string(v), rest, err
+}
+
+func decodeBytes(b []byte, mask byte) ([]byte, []byte, error) {
+	var v []byte
+	for i := 0; i < len(b); i++ {
+		c := b[i] ^ mask
+		if c != escByte {
+			v = append(v, c)
+			continue
+		}
+
+		if i+1 >= len(b) {
+			return nil, b, ErrShortBuffer
+		}
+
+		switch b[i+1] ^ mask {
+		case escZero:
+			v = append(v, escByte)
+			i++
+		case escTerm:
+			if v == nil {
+				v = []byte{}
+			}
+			return v, b[i+2:], nil
+		default:
+			return nil, b, ErrInvalidEncoding
+		}
+	}
+
+	return nil, b, ErrShortBuffer
+}
+
+// PrefixEnd returns the smallest key which is greater than every key having
+// the given prefix. It can be used as the exclusive upper bound for a prefix
+// range scan. A nil return value means there is no upper bound.
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
+
+func invert(b []byte) {
+	for i := range b {
+		b[i] = ^b[i]
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package keyenc
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+type testKey struct {
+	s string
+	i int64
+	f float64
+	u uint64
+}
+
+func (k testKey) encode() []byte {
+	b := AppendString(nil, k.s)
+	b = AppendInt64(b, k.i)
+	b = AppendFloat64Desc(b, k.f)
+	return AppendUint64(b, k.u)
+}
+
+func (k testKey) less(o testKey) bool {
+	if k.s != o.s {
+		return k.s < o.s
+	}
+	if k.i != o.i {
+		return k.i < o.i
+	}
+	if k.f != o.f {
+		return k.f > o.f
+	}
+	return k.u < o.u
+}
+
+func TestCompositeKeyOrder(t *testing.T) {
+	strs := []string{"", "a", "a\x00", "a\x00b", "ab", "b", "\xff", "\x00"}
+	ints := []int64{math.MinInt64, -100, -1, 0, 1, 100, math.MaxInt64}
+	floats := []float64{math.Inf(-1), -1.5, -0.25, 0, 0.25, 1.5, math.Inf(1)}
+
+	var keys []testKey
+	for i := 0; i < 2000; i++ {
+		keys = append(keys, testKey{
+			s: strs[rand.Intn(len(strs))],
+			i: ints[rand.Intn(len(ints))],
+			f: floats[rand.Intn(len(floats))],
+			u: uint64(rand.Intn(4)),
+		})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i].encode(), keys[j].encode()) < 0
+	})
+
+	for i := 1; i < len(keys); i++ {
+		if keys[i].less(keys[i-1]) {
+			t.Errorf("ordering mismatch %v > %v", keys[i-1], keys[i])
+		}
+	}
+}
+
+func TestCompositeKeyDecode(t *testing.T) {
+	b := AppendString(nil, "x\x00y")
+	b = AppendStringDesc(b, "desc\x00")
+	b = AppendInt64Desc(b, -42)
+	b = AppendFloat64(b, -3.25)
+	b = AppendUint64Desc(b, 7)
+	b = AppendBytes(b, nil)
+
+	s, rest, err := DecodeString(b)
+	if err != nil || s != "x\x00y" {
+		t.Fatalf("expected x\\x00y, got %q (%v)", s, err)
+	}
+
+	s, rest, err = DecodeStringDesc(rest)
+	if err != nil || s != "desc\x00" {
+		t.Fatalf("expected desc\\x00, got %q (%v)", s, err)
+	}
+
+	i, rest, err := DecodeInt64Desc(rest)
+	if err != nil || i != -42 {
+		t.Fatalf("expected -42, got %d (%v)", i, err)
+	}
+
+	f, rest, err := DecodeFloat64(rest)
+	if err != nil || f != -3.25 {
+		t.Fatalf("expected -3.25, got %v (%v)", f, err)
+	}
+
+	u, rest, err := DecodeUint64Desc(rest)
+	if err != nil || u != 7 {
+		t.Fatalf("expected 7, got %d (%v)", u, err)
+	}
+
+	bs, rest, err := DecodeBytes(rest)
+	if err != nil || len(bs) != 0 || len(rest) != 0 {
+		t.Fatalf("expected empty field, got %v rest %v (%v)", bs, rest, err)
+	}
+
+	if _, _, err := DecodeString([]byte("abc")); err != ErrShortBuffer {
+		t.Errorf("expected short buffer error, got %v", err)
+	}
+
+	if _, _, err := DecodeUint64([]byte{1, 2}); err != ErrShortBuffer {
+		t.Errorf("expected short buffer error, got %v", err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	prefix := AppendString(nil, "user")
+	end := PrefixEnd(prefix)
+	k := AppendUint64(append([]byte(nil), prefix...), math.MaxUint64)
+
+	if bytes.Compare(k, end) >= 0 || bytes.Compare(prefix, end) >= 0 {
+		t.Errorf("prefix end %v is not an upper bound", end)
+	}
+
+	if PrefixEnd([]byte{0xff, 0xff}) != nil {
+		t.Errorf("expected nil upper bound")
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,6 +37,8 @@ var (
 	ErrMaxSnapshotsLimitReached = fmt.Errorf("Maximum snapshots limit reached")
 	// ErrShutdown means an operation on a shutdown Nitro instance
 	ErrShutdown = fmt.Errorf("Nitro instance has been shutdown")
+	// ErrNotEmpty means a bulk load into a Nitro instance having items
+	ErrNotEmpty = fmt.Errorf("Nitro instance is not empty")
 )
 
 // KeyCompare implements item data key comparator
@@ -63,9 +66,22 @@ func (e *ItemEntry) Node() *skiplist.Node {
 // ItemCallback implements callback used for backup file to Nitro restore API
 type ItemCallback func(*ItemEntry)
 
+// LoadProgressCallback is called by LoadFromDisk with the number of items
+// loaded so far and the number of items in the backup, which is 0 for the
+// backups not recording it
+type LoadProgressCallback func(loaded, total int64)
+
+// Batch of items read by LoadFromDisk to be built into a skiplist segment
+type loadBatch struct {
+	seg   *skiplist.Segment
+	items []unsafe.Pointer
+}
+
 const (
-	defaultRefreshRate = 10000
-	gcchanBufSize      = 256
+	defaultRefreshRate     = 10000
+	defaultExpirySweepRate = 10000
+	gcchanBufSize          = 256
+	loadSegmentSize        = 10000
 )
 
 var (
@@ -92,6 +108,7 @@ func DefaultConfig() Config {
 	cfg.fileType = RawdbFile
 	cfg.useMemoryMgmt = false
 	cfg.refreshRate = defaultRefreshRate
+	cfg.expirySweepRate = defaultExpirySweepRate
 	return cfg
 }
 
@@ -204,13 +221,20 @@ func (w *Writer) Put(bs []byte) {
 
 // Put2 returns the skiplist node of the item if Put() succeeds
 func (w *Writer) Put2(bs []byte) (n *skiplist.Node) {
+	return w.put(w.newItem(bs, w.useMemoryMgmt))
+}
+
+func (w *Writer) put(x *Item) (n *skiplist.Node) {
 	var success bool
-	x := w.newItem(bs, w.useMemoryMgmt)
 	x.bornSn = w.getCurrSn()
+retry:
 	n, success = w.store.Insert2(unsafe.Pointer(x), w.insCmp, w.existCmp, w.buf,
 		w.rand.Float32, &w.slSts1)
 	if success {
 		w.count++
+	} else if isExpired((*Item)(n.Item())) && w.DeleteNode(n) {
+		// An expired item which is yet to be purged is replaced
+		goto retry
 	} else {
 		w.freeItem(x)
 		n = nil
@@ -277,7 +301,9 @@ func (w *Writer) GetNode(bs []byte) *skiplist.Node {
 	x.bornSn = w.getCurrSn()
 
 	if found := iter.SeekWithCmp(unsafe.Pointer(x), w.insCmp, w.existCmp); found {
-		return iter.GetNode()
+		if n := iter.GetNode(); !isExpired((*Item)(n.Item())) {
+			return n
+		}
 	}
 
 	return nil
@@ -297,6 +323,12 @@ type Config struct {
 	useDeltaFiles bool
 	mallocFun     skiplist.MallocFn
 	freeFun       skiplist.FreeFn
+
+	compressor BlockCompressor
+
+	useTTL          bool
+	expirySweepRate int
+	loadProgress    LoadProgressCallback
 }
 
 // SetKeyComparator provides key comparator for the Nitro item data
@@ -316,6 +348,34 @@ func (cfg *Config) UseMemoryMgmt(malloc skiplist.MallocFn, free skiplist.FreeFn)
 	}
 }
 
+// SetBlockCompressor enables compression of disk backups and snapshot
+// streams in blocks of DiskBlockSize bytes. The compressor is registered
+// for loading the backups written by using it.
+func (cfg *Config) SetBlockCompressor(c BlockCompressor) {
+	cfg.compressor = c
+	if c != nil {
+		RegisterBlockCompressor(c)
+	}
+}
+
+// SetLoadProgressCallback sets the callback for reporting the progress of
+// LoadFromDisk
+func (cfg *Config) SetLoadProgressCallback(fn LoadProgressCallback) {
+	cfg.loadProgress = fn
+}
+
+// EnableTTL enables the items to be put with a ttl by Writer.PutWithTTL.
+// Only the items put with a ttl use additional 4 bytes for the expiry.
+func (cfg *Config) EnableTTL() {
+	cfg.useTTL = true
+}
+
+// SetExpirySweepRate sets the number of items examined for expiry by each
+// NewSnapshot call. The expired items found are purged by the snapshot GC.
+func (cfg *Config) SetExpirySweepRate(n int) {
+	cfg.expirySweepRate = n
+}
+
 // UseDeltaInterleaving option enables to avoid additional memory required during disk backup
 // as due to locking of older snapshots. This non-intrusive backup mode
 // eliminates the need for locking garbage collectable old snapshots. But, it may
@@ -341,10 +401,17 @@ type Nitro struct {
 	leastUnrefSn uint32
 	itemsCount   int64
 
+	// Items with expiry are swept incrementally from the cursor
+	sweepCursor *Item
+
 	wlist    *Writer
 	gcchan   chan *skiplist.Node
 	freechan chan *skiplist.Node
 
+	// Writers used by LoadIncrementalFromDisk
+	loadLock    sync.Mutex
+	loadWriters []*Writer
+
 	hasShutdown bool
 	shutdownWg1 sync.WaitGroup // GC workers and StoreToDisk task
 	shutdownWg2 sync.WaitGroup // Free workers
@@ -507,6 +574,7 @@ func (m *Nitro) NewWriter() *Writer {
 // Snapshot describes Nitro immutable snapshot
 type Snapshot struct {
 	sn       uint32
+	ts       uint32
 	refCount int32
 	db       *Nitro
 	count    int64
@@ -517,11 +585,12 @@ type Snapshot struct {
 // SnapshotSize returns the memory used by Nitro snapshot metadata
 func SnapshotSize(p unsafe.Pointer) int {
 	s := (*Snapshot)(p)
-	return int(unsafe.Sizeof(s.sn) + unsafe.Sizeof(s.refCount) + unsafe.Sizeof(s.db) +
+	return int(unsafe.Sizeof(s.sn) + unsafe.Sizeof(s.ts) + unsafe.Sizeof(s.refCount) + unsafe.Sizeof(s.db) +
 		unsafe.Sizeof(s.count) + unsafe.Sizeof(s.gclist))
 }
 
-// Count returns the number of items in the Nitro snapshot
+// Count returns the number of items in the Nitro snapshot. Expired items
+// are counted until they are purged.
 func (s Snapshot) Count() int64 {
 	return s.count
 }
@@ -578,6 +647,16 @@ func (s *Snapshot) Close() {
 	}
 }
 
+// Returns true if the item is visible in the snapshot
+func (s *Snapshot) isVisible(itm *Item) bool {
+	if itm.bornSn > s.sn || (itm.deadSn != 0 && itm.deadSn <= s.sn) {
+		return false
+	}
+
+	expiry := itm.Expiry()
+	return expiry == 0 || expiry > s.ts
+}
+
 // NewIterator creates a new snapshot iterator
 func (s *Snapshot) NewIterator() *Iterator {
 	return s.db.NewIterator(s)
@@ -620,7 +699,17 @@ func (m *Nitro) NewSnapshot() (*Snapshot, error) {
 		w.count = 0
 	}
 
-	snap := &Snapshot{db: m, sn: m.getCurrSn(), refCount: 1, count: m.ItemsCount()}
+	snap := &Snapshot{db: m, sn: m.getCurrSn(), ts: expiryClock(), refCount: 1}
+	if m.useTTL {
+		if expHead, expTail := m.sweepExpired(snap); tail == nil {
+			head, tail = expHead, expTail
+		} else if expHead != nil {
+			tail.SetLink(expHead)
+			tail = expTail
+		}
+	}
+
+	snap.count = m.ItemsCount()
 	m.snapshots.Insert(unsafe.Pointer(snap), CompareSnapshot, buf, &m.snapshots.Stats)
 	snap.gclist = head
 	newSn := atomic.AddUint32(&m.currSn, 1)
@@ -876,6 +965,18 @@ func (m *Nitro) changeDeltaWrState(state int,
 	return err
 }
 
+// Manifest of a disk backup
+type backupManifest struct {
+	Version     int    `json:"version"`
+	Compression string `json:"compression,omitempty"`
+	Sn          uint32 `json:"sn,omitempty"`
+	Items       int64  `json:"items,omitempty"`
+
+	// Incremental backups refer to the backup they are based on
+	Parent string `json:"parent,omitempty"`
+	BaseSn uint32 `json:"base_sn,omitempty"`
+}
+
 // StoreToDisk backups Nitro snapshot to disk
 // Concurrent threads are used to perform backup and concurrency can be specified.
 func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback ItemCallback) (err error) {
@@ -899,13 +1000,7 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 
 	writers := make([]FileWriter, shards)
 	files := make([]string, shards)
-	defer func() {
-		for _, w := range writers {
-			if w != nil {
-				w.Close()
-			}
-		}
-	}()
+	defer closeFileWriters(writers)
 
 	for shard := 0; shard < shards; shard++ {
 		w := m.newFileWriter(m.fileType)
@@ -923,13 +1018,7 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 	if m.useDeltaFiles {
 		deltaWriters := make([]FileWriter, m.numWriters())
 		deltaFiles := make([]string, m.numWriters())
-		defer func() {
-			for _, w := range deltaWriters {
-				if w != nil {
-					w.Close()
-				}
-			}
-		}()
+		defer closeFileWriters(deltaWriters)
 
 		deltadir := filepath.Join(dir, "delta")
 		os.MkdirAll(deltadir, 0755)
@@ -958,14 +1047,29 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 		fakeSnap.refCount = 1
 		snap = &fakeSnap
 
+		// Delta files are listed once they are complete
 		defer func() {
-			if err = m.changeDeltaWrState(dwStateTerminate, nil, nil); err == nil {
+			derr := m.changeDeltaWrState(dwStateTerminate, nil, nil)
+			if derr == nil {
+				derr = closeFileWriters(deltaWriters)
+			}
+
+			if derr == nil {
 				bs, _ := json.Marshal(deltaFiles)
-				err = ioutil.WriteFile(filepath.Join(deltadir, "files.json"), bs, 0660)
+				derr = ioutil.WriteFile(filepath.Join(deltadir, "files.json"), bs, 0660)
+			}
+
+			if err == nil {
+				err = derr
 			}
 		}()
 	}
 
+	mf := backupManifest{Version: version, Sn: snap.sn}
+	if m.compressor != nil {
+		mf.Compression = m.compressor.Name()
+	}
+
 	visitorCallback := func(itm *Item, shard int) error {
 		if m.hasShutdown {
 			return ErrShutdown
@@ -975,6 +1079,7 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 		if err := w.WriteItem(itm); err != nil {
 			return err
 		}
+		atomic.AddInt64(&mf.Items, 1)
 
 		if itmCallback != nil {
 			itmCallback(&ItemEntry{itm: itm, n: nil})
@@ -983,9 +1088,15 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 		return nil
 	}
 
-	manifest, _ := json.Marshal(map[string]interface{}{"version": version})
-	if err = ioutil.WriteFile(filepath.Join(manifestdir, "nitro.json"), manifest, 0660); err == nil {
-		if err = m.Visitor(snap, visitorCallback, shards, concurr); err == nil {
+	// Manifest records the number of items written. It is written once the
+	// shard files are complete.
+	if err = m.Visitor(snap, visitorCallback, shards, concurr); err == nil {
+		err = closeFileWriters(writers)
+	}
+
+	if err == nil {
+		manifest, _ := json.Marshal(mf)
+		if err = ioutil.WriteFile(filepath.Join(manifestdir, "nitro.json"), manifest, 0660); err == nil {
 			bs, _ := json.Marshal(files)
 			err = ioutil.WriteFile(filepath.Join(datadir, "files.json"), bs, 0660)
 		}
@@ -995,6 +1106,9 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 }
 
 // LoadFromDisk restores Nitro from a disk backup
+// The backup is loaded by concurr readers and concurr skiplist builders, which
+// default to GOMAXPROCS if concurr is not positive. The load progress callback
+// set in the config is called as the items are added into the skiplist.
 func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snapshot, error) {
 	var wg sync.WaitGroup
 	var files []string
@@ -1002,14 +1116,25 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 	var err error
 	manifestdir := dir
 	var version int
+	var compressor BlockCompressor
+	var mf backupManifest
+
+	if concurr <= 0 {
+		concurr = runtime.GOMAXPROCS(0)
+	}
 
 	// Read file version
 	if bs, err := ioutil.ReadFile(filepath.Join(manifestdir, "nitro.json")); err == nil {
-		mMap := make(map[string]int)
-		if err = json.Unmarshal(bs, &mMap); err != nil {
+		if err = json.Unmarshal(bs, &mf); err != nil {
 			return nil, err
 		}
-		version = mMap["version"]
+		version = mf.Version
+
+		if mf.Compression != "" {
+			if compressor, err = getBlockCompressor(mf.Compression); err != nil {
+				return nil, err
+			}
+		}
 	} else if !os.IsNotExist(err) {
 		return nil, err
 	}
@@ -1021,10 +1146,14 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 	json.Unmarshal(bs, &files)
 
 	var nodeCallb skiplist.NodeCallback
+	var loaded int64
+	var progressMu sync.Mutex
+	var readWg, buildWg sync.WaitGroup
 	wchan := make(chan int)
+	bchan := make(chan loadBatch, concurr)
 	b := skiplist.NewBuilderWithConfig(m.newStoreConfig())
 	b.SetItemSizeFunc(ItemSize)
-	segments := make([]*skiplist.Segment, len(files))
+	segments := make([][]*skiplist.Segment, len(files))
 	readers := make([]FileReader, len(files))
 	errors := make([]error, len(files))
 
@@ -1043,9 +1172,7 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 	}()
 
 	for i, file := range files {
-		segments[i] = b.NewSegment()
-		segments[i].SetNodeCallback(nodeCallb)
-		r := m.newFileReader(m.fileType, version)
+		r := m.newFileReader(m.fileType, version, compressor)
 		datafile := filepath.Join(datadir, file)
 		if err := r.Open(datafile); err != nil {
 			return nil, err
@@ -1054,35 +1181,71 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 		readers[i] = r
 	}
 
+	// Each shard file holds a key range, which is read in batches of
+	// loadSegmentSize items. Every batch is built into a separate segment,
+	// so that the segments can be built concurrently by the builders
+	// irrespective of the number of shard files.
 	for i := 0; i < concurr; i++ {
-		wg.Add(1)
-		go func(wg *sync.WaitGroup) {
-			defer wg.Done()
+		readWg.Add(1)
+		go func() {
+			defer readWg.Done()
 
 			for shard := range wchan {
 				r := readers[shard]
+				var items []unsafe.Pointer
 			loop:
 				for {
 					itm, err := r.ReadItem()
 					if err != nil {
 						errors[shard] = err
-						return
+						break loop
+					}
+
+					if itm != nil {
+						items = append(items, unsafe.Pointer(itm))
+					}
+
+					if len(items) == loadSegmentSize || (itm == nil && len(items) > 0) {
+						seg := b.NewSegment()
+						seg.SetNodeCallback(nodeCallb)
+						segments[shard] = append(segments[shard], seg)
+						bchan <- loadBatch{seg: seg, items: items}
+						items = nil
 					}
 
 					if itm == nil {
 						break loop
 					}
-					segments[shard].Add(unsafe.Pointer(itm))
 				}
 			}
-		}(&wg)
+		}()
+
+		buildWg.Add(1)
+		go func() {
+			defer buildWg.Done()
+
+			for batch := range bchan {
+				for _, itm := range batch.items {
+					batch.seg.Add(itm)
+				}
+
+				if m.loadProgress != nil {
+					progressMu.Lock()
+					loaded += int64(len(batch.items))
+					m.loadProgress(loaded, mf.Items)
+					progressMu.Unlock()
+				}
+			}
+		}()
 	}
 
 	for i := range files {
 		wchan <- i
 	}
 	close(wchan)
-	wg.Wait()
+	readWg.Wait()
+	close(bchan)
+	buildWg.Wait()
 
 	for _, err := range errors {
 		if err != nil {
@@ -1090,7 +1253,12 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 		}
 	}
 
-	m.store = b.Assemble(segments...)
+	var all []*skiplist.Segment
+	for _, segs := range segments {
+		all = append(all, segs...)
+	}
+
+	m.store = b.Assemble(all...)
 
 	// Delta processing
 	if m.useDeltaFiles {
@@ -1117,7 +1285,7 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 		}()
 
 		for i, file := range files {
-			r := m.newFileReader(m.fileType, version)
+			r := m.newFileReader(m.fileType, version, compressor)
 			deltafile := filepath.Join(deltadir, file)
 			if err := r.Open(deltafile); err != nil {
 				return nil, err
@@ -1187,6 +1355,95 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 	return m.NewSnapshot()
 }
 
+// LoadFromItems builds the store of an empty Nitro instance from the items
+// by using the skiplist builder, as LoadFromDisk does, which is faster than
+// inserting them one by one. The items may be in any order. Of the items
+// having equal keys, the one which comes last is retained. Snapshots created
+// earlier do not see the items. ErrNotEmpty is returned if the instance has
+// items, including deleted items which are not yet freed. Writers should
+// not be used during the load.
+func (m *Nitro) LoadFromItems(items [][]byte, concurr int) (*Snapshot, error) {
+	var wg sync.WaitGroup
+
+	if concurr <= 0 {
+		concurr = runtime.GOMAXPROCS(0)
+	}
+
+	if !m.isEmpty() {
+		return nil, ErrNotEmpty
+	}
+
+	sn := m.getCurrSn()
+	itms := make([]unsafe.Pointer, len(items))
+	for i, bs := range items {
+		itm := m.newItem(bs, m.useMemoryMgmt)
+		itm.bornSn = sn
+		itms[i] = unsafe.Pointer(itm)
+	}
+
+	keyOf := func(i int) []byte {
+		return (*Item)(itms[i]).Bytes()
+	}
+
+	sort.SliceStable(itms, func(i, j int) bool {
+		return m.keyCmp(keyOf(i), keyOf(j)) < 0
+	})
+
+	uniq := itms[:0]
+	for i, itm := range itms {
+		if i+1 < len(itms) && m.keyCmp(keyOf(i), keyOf(i+1)) == 0 {
+			m.freeItem((*Item)(itm))
+			continue
+		}
+		uniq = append(uniq, itm)
+	}
+
+	b := skiplist.NewBuilderWithConfig(m.newStoreConfig())
+	b.SetItemSizeFunc(ItemSize)
+	bchan := make(chan loadBatch, concurr)
+	for i := 0; i < concurr; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range bchan {
+				for _, itm := range batch.items {
+					batch.seg.Add(itm)
+				}
+			}
+		}()
+	}
+
+	var segments []*skiplist.Segment
+	for len(uniq) > 0 {
+		n := loadSegmentSize
+		if n > len(uniq) {
+			n = len(uniq)
+		}
+
+		seg := b.NewSegment()
+		segments = append(segments, seg)
+		bchan <- loadBatch{seg: seg, items: uniq[:n]}
+		uniq = uniq[n:]
+	}
+	close(bchan)
+	wg.Wait()
+
+	m.store = b.Assemble(segments...)
+	m.itemsCount = int64(m.store.GetStats().NodeCount)
+	return m.NewSnapshot()
+}
+
+// Nodes of deleted items are counted until they are freed by the collector
+func (m *Nitro) isEmpty() bool {
+	buf := m.store.MakeBuf()
+	defer m.store.FreeBuf(buf)
+	itr := m.store.NewIterator(m.iterCmp, buf)
+	defer itr.Close()
+
+	itr.SeekFirst()
+	return !itr.Valid()
+}
+
 // DumpStats returns Nitro statistics
 func (m *Nitro) DumpStats() string {
 	return m.aggrStoreStats().String()
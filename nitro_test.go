@@ -18,6 +18,10 @@ import "math/rand"
 import "sync"
 import "runtime"
 import "encoding/binary"
+import "io"
+import "strings"
+import "errors"
+import "path/filepath"
 import "github.com/couchbase/nitro/mm"
 
 var testConf Config
@@ -735,3 +739,507 @@ func TestCloseWithActiveIterators(t *testing.T) {
 	wg.Wait()
 
 }
+
+func TestLoadStoreDiskCompressed(t *testing.T) {
+	os.RemoveAll("db.dump")
+	defer os.RemoveAll("db.dump")
+
+	var wg sync.WaitGroup
+	cfg := testConf
+	cfg.SetBlockCompressor(FlateCompressor)
+	db := NewWithConfig(cfg)
+	defer db.Close()
+
+	n := 100000
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go doInsert(db, &wg, n/runtime.GOMAXPROCS(0), true, true)
+	}
+	wg.Wait()
+
+	snap, _ := db.NewSnapshot()
+	n = int(snap.Count())
+	if err := db.StoreToDisk("db.dump", snap, 8, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	// Compression is picked up from the manifest
+	db2 := NewWithConfig(testConf)
+	defer db2.Close()
+	snap, err := db2.LoadFromDisk("db.dump", 8, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	if count := CountItems(snap); count != n {
+		t.Errorf("Expected %v, got %v", n, count)
+	}
+
+	// A corrupt block length is rejected before it is allocated
+	hdr := make([]byte, blockHeaderSize)
+	binary.BigEndian.PutUint32(hdr[0:4], 1)
+	binary.BigEndian.PutUint32(hdr[4:8], 1<<31)
+	r := newBlockReader(strings.NewReader(string(hdr)), FlateCompressor)
+	if _, err := r.Read(make([]byte, 1)); err != ErrCorruptBlock {
+		t.Errorf("Expected corrupt block error, got %v", err)
+	}
+}
+
+type failingCompressor struct{ noCompressor }
+
+func (failingCompressor) Compress(dst, src []byte) ([]byte, error) {
+	return nil, errors.New("compress failed")
+}
+
+func TestStoreToDiskFlushError(t *testing.T) {
+	os.RemoveAll("db.dump")
+	defer os.RemoveAll("db.dump")
+
+	cfg := testConf
+	cfg.SetBlockCompressor(failingCompressor{})
+	db := NewWithConfig(cfg)
+	defer db.Close()
+
+	// Items fit in a block, which is compressed only when the file is closed
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err == nil {
+		t.Fatalf("Expected the flush error")
+	}
+
+	if _, err := os.Stat(filepath.Join("db.dump", "nitro.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected no manifest, got %v", err)
+	}
+}
+
+func TestStoreLoadStream(t *testing.T) {
+	for _, c := range []BlockCompressor{nil, FlateCompressor} {
+		cfg := testConf
+		cfg.SetBlockCompressor(c)
+		db := NewWithConfig(cfg)
+
+		w := db.NewWriter()
+		n := 20000
+		for i := 0; i < n; i++ {
+			w.Put([]byte(fmt.Sprintf("%010d", i)))
+		}
+
+		snap, _ := db.NewSnapshot()
+		r, pw := io.Pipe()
+		errch := make(chan error, 1)
+		go func() {
+			err := db.StoreToWriter(pw, snap, nil)
+			pw.CloseWithError(err)
+			errch <- err
+		}()
+
+		var loaded int
+		db2 := NewWithConfig(testConf)
+		snap2, err := db2.LoadFromReader(r, func(*ItemEntry) { loaded++ })
+		if err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+
+		if err := <-errch; err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+
+		itr := db2.NewIterator(snap2)
+		i := 0
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			if exp := fmt.Sprintf("%010d", i); string(itr.Get()) != exp {
+				t.Fatalf("Expected %s, got %s", exp, itr.Get())
+			}
+			i++
+		}
+		itr.Close()
+
+		if i != n || loaded != n || snap2.Count() != int64(n) {
+			t.Errorf("Expected %d items, got %d, loaded %d", n, i, loaded)
+		}
+
+		snap2.Close()
+		db2.Close()
+		db.Close()
+	}
+
+	if _, err := New().LoadFromReader(strings.NewReader("not a snapshot"), nil); err != ErrInvalidStream {
+		t.Errorf("Expected invalid stream error, got %v", err)
+	}
+}
+
+func snapshotKeys(db *Nitro, snap *Snapshot) []string {
+	var keys []string
+	itr := db.NewIterator(snap)
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		keys = append(keys, string(itr.Get()))
+	}
+	itr.Close()
+	return keys
+}
+
+func TestLoadStoreIncremental(t *testing.T) {
+	os.RemoveAll("db.incr")
+	defer os.RemoveAll("db.incr")
+
+	cfg := testConf
+	cfg.SetBlockCompressor(FlateCompressor)
+	db := NewWithConfig(cfg)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	// The base snapshot is kept open until the next backup is taken
+	snap0, _ := db.NewSnapshot()
+	snap0.Open()
+	if err := db.StoreToDisk("db.incr/base", snap0, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	for i := 0; i < 2000; i += 2 {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	for i := 10000; i < 11000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap1, _ := db.NewSnapshot()
+	snap1.Open()
+	if err := db.StoreIncrementalToDisk("db.incr/incr1", "db.incr/base", snap0, snap1, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	snap0.Close()
+
+	// Items replaced since the base are recorded as deleted and inserted
+	for i := 10000; i < 10500; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	for i := 5000; i < 5100; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap2, _ := db.NewSnapshot()
+	snap2.Open()
+	expected := snapshotKeys(db, snap2)
+	if err := db.StoreIncrementalToDisk("db.incr/incr2", "db.incr/incr1", snap1, snap2, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	snap1.Close()
+
+	if err := db.StoreIncrementalToDisk("db.incr/incr3", "db.incr/incr2", snap2, snap2, 4, nil); err != ErrInvalidBaseSnapshot {
+		t.Errorf("Expected invalid base snapshot error, got %v", err)
+	}
+
+	db2 := NewWithConfig(testConf)
+	defer db2.Close()
+	snap, err := db2.LoadIncrementalFromDisk("db.incr/incr2", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	got := snapshotKeys(db2, snap)
+	if len(got) != len(expected) || int(snap.Count()) != len(expected) {
+		t.Fatalf("Expected %d items, got %d, count %d", len(expected), len(got), snap.Count())
+	}
+
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected %s, got %s", expected[i], got[i])
+		}
+	}
+
+	// The concurrency defaults to GOMAXPROCS
+	db3 := NewWithConfig(testConf)
+	defer db3.Close()
+	snap3, err := db3.LoadIncrementalFromDisk("db.incr/incr2", 0, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap3.Close()
+
+	if int(snap3.Count()) != len(expected) || len(db3.loadWriters) != runtime.GOMAXPROCS(0) {
+		t.Fatalf("Expected %d items, got %d with %d writers", len(expected), snap3.Count(), len(db3.loadWriters))
+	}
+}
+
+func TestDiffIterator(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap1, _ := db.NewSnapshot()
+	defer snap1.Close()
+
+	for i := 0; i < 100; i += 2 {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	for i := 500; i < 510; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	for i := 1000; i < 1050; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	// Neither created nor deleted items outside the snapshots are returned
+	snap2, _ := db.NewSnapshot()
+	defer snap2.Close()
+	w.Put([]byte(fmt.Sprintf("%010d", 2000)))
+	w.Delete([]byte(fmt.Sprintf("%010d", 1)))
+
+	if db.NewDiffIterator(snap2, snap1) != nil {
+		t.Errorf("Expected no iterator for snapshots out of order")
+	}
+
+	var exp []string
+	for i := 0; i < 100; i += 2 {
+		exp = append(exp, fmt.Sprintf("%s %010d", DiffDeleted, i))
+	}
+	for i := 500; i < 510; i++ {
+		exp = append(exp, fmt.Sprintf("%s %010d", DiffDeleted, i))
+		exp = append(exp, fmt.Sprintf("%s %010d", DiffCreated, i))
+	}
+	for i := 1000; i < 1050; i++ {
+		exp = append(exp, fmt.Sprintf("%s %010d", DiffCreated, i))
+	}
+
+	itr := db.NewDiffIterator(snap1, snap2)
+	itr.SetRefreshRate(7)
+	var got []string
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		got = append(got, fmt.Sprintf("%s %s", itr.Op(), itr.Get()))
+	}
+
+	itr.Seek([]byte(fmt.Sprintf("%010d", 505)))
+	if !itr.Valid() || itr.Op() != DiffDeleted || string(itr.Get()) != fmt.Sprintf("%010d", 505) {
+		t.Errorf("Expected to seek to the deleted item")
+	}
+	itr.Close()
+
+	if len(got) != len(exp) {
+		t.Fatalf("Expected %d changes, got %d", len(exp), len(got))
+	}
+
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Fatalf("Expected %s, got %s", exp[i], got[i])
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := uint32(1000)
+	expiryClock = func() uint32 { return atomic.LoadUint32(&now) }
+	defer func() {
+		expiryClock = func() uint32 { return uint32(time.Now().Unix()) }
+	}()
+
+	cfg := testConf
+	cfg.EnableTTL()
+	cfg.SetExpirySweepRate(64)
+	db := NewWithConfig(cfg)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 200; i++ {
+		bs := []byte(fmt.Sprintf("%010d", i))
+		if i%2 == 0 {
+			w.PutWithTTL(bs, 10*time.Second)
+		} else {
+			w.Put(bs)
+		}
+	}
+
+	snap1, _ := db.NewSnapshot()
+	if count := CountItems(snap1); count != 200 {
+		t.Errorf("Expected 200 items, got %d", count)
+	}
+
+	// Expired items are neither visible in new snapshots nor to the writer,
+	// whereas the older snapshots remain consistent
+	atomic.StoreUint32(&now, 1010)
+	if w.GetNode([]byte(fmt.Sprintf("%010d", 0))) != nil {
+		t.Errorf("Expected expired item not to be found")
+	}
+
+	if w.Put2([]byte(fmt.Sprintf("%010d", 0))) == nil {
+		t.Errorf("Expected expired item to be replaced")
+	}
+
+	snap2, _ := db.NewSnapshot()
+	if count := CountItems(snap2); count != 101 {
+		t.Errorf("Expected 101 items, got %d", count)
+	}
+
+	if count := CountItems(snap1); count != 200 {
+		t.Errorf("Expected 200 items, got %d", count)
+	}
+	snap1.Close()
+	snap2.Close()
+
+	// Expired items are purged a few at a time by the snapshots
+	for i := 0; i < 4; i++ {
+		snap, _ := db.NewSnapshot()
+		snap.Close()
+	}
+
+	if count := db.ItemsCount(); count != 101 {
+		t.Errorf("Expected 101 items, got %d", count)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for db.store.GetStats().NodeCount != 101 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if count := db.store.GetStats().NodeCount; count != 101 {
+		t.Errorf("Expected expired items to be purged, got %d nodes", count)
+	}
+}
+
+func TestExpiryItemSize(t *testing.T) {
+	cfg := testConf
+	cfg.EnableTTL()
+	db := NewWithConfig(cfg)
+	defer db.Close()
+
+	w := db.NewWriter()
+	bs := []byte("0000000000")
+	n1 := w.Put2(bs)
+	n2 := w.PutWithTTL([]byte("0000000001"), time.Minute)
+
+	// Only the items put with a ttl carry the expiry
+	if sz := ItemSize(n1.Item()); sz != int(itemHeaderSize)+len(bs) {
+		t.Errorf("Expected item size %d, got %d", int(itemHeaderSize)+len(bs), sz)
+	}
+
+	if sz := ItemSize(n2.Item()); sz != int(itemHeaderSize+itemExpirySize)+len(bs) {
+		t.Errorf("Expected item size %d, got %d", int(itemHeaderSize+itemExpirySize)+len(bs), sz)
+	}
+
+	if itm := (*Item)(n2.Item()); string(itm.Bytes()) != "0000000001" || itm.Expiry() == 0 {
+		t.Errorf("Unexpected item %s with expiry %d", itm.Bytes(), itm.Expiry())
+	}
+
+	db2 := NewWithConfig(testConf)
+	defer db2.Close()
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected PutWithTTL to panic without EnableTTL")
+		}
+	}()
+	db2.NewWriter().PutWithTTL(bs, time.Minute)
+}
+
+func TestLoadFromDiskConcurrent(t *testing.T) {
+	os.RemoveAll("db.dump")
+	defer os.RemoveAll("db.dump")
+
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	n := 100000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	for _, concurr := range []int{1, 8, 0} {
+		var calls int
+		var lastLoaded, lastTotal int64
+		cfg := testConf
+		cfg.SetLoadProgressCallback(func(loaded, total int64) {
+			if loaded < lastLoaded {
+				t.Errorf("Expected progress to increase, got %d after %d", loaded, lastLoaded)
+			}
+			calls++
+			lastLoaded, lastTotal = loaded, total
+		})
+
+		db2 := NewWithConfig(cfg)
+		snap2, err := db2.LoadFromDisk("db.dump", concurr, nil)
+		if err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+
+		if lastLoaded != int64(n) || lastTotal != int64(n) {
+			t.Errorf("Expected progress %d/%d, got %d/%d", n, n, lastLoaded, lastTotal)
+		}
+
+		if calls < n/loadSegmentSize {
+			t.Errorf("Expected at least %d progress calls, got %d", n/loadSegmentSize, calls)
+		}
+
+		i := 0
+		itr := snap2.NewIterator()
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			if exp := fmt.Sprintf("%010d", i); string(itr.Get()) != exp {
+				t.Fatalf("Expected %s, got %s", exp, itr.Get())
+			}
+			i++
+		}
+		itr.Close()
+
+		if i != n || snap2.Count() != int64(n) {
+			t.Errorf("Expected %d items, got %d, count %d", n, i, snap2.Count())
+		}
+
+		snap2.Close()
+		db2.Close()
+	}
+}
+
+func TestLoadFromItems(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	n := 100000
+	items := make([][]byte, 0, n+1)
+	for i := n - 1; i >= 0; i-- {
+		items = append(items, []byte(fmt.Sprintf("%010d", i)))
+	}
+	items = append(items, []byte(fmt.Sprintf("%010d", 0)))
+
+	snap, err := db.LoadFromItems(items, 0)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	i := 0
+	itr := snap.NewIterator()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if exp := fmt.Sprintf("%010d", i); string(itr.Get()) != exp {
+			t.Fatalf("Expected %s, got %s", exp, itr.Get())
+		}
+		i++
+	}
+	itr.Close()
+
+	if i != n || snap.Count() != int64(n) {
+		t.Errorf("Expected %d items, got %d, count %d", n, i, snap.Count())
+	}
+
+	if _, err := db.LoadFromItems(items, 0); err != ErrNotEmpty {
+		t.Errorf("Expected ErrNotEmpty, got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package plasma
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Advice is a recommended change of a setting which can be updated
+// through UpdateConfig
+type Advice struct {
+	Setting string
+	Current int
+	Value   int
+	Reason  string
+}
+
+func (a Advice) String() string {
+	return fmt.Sprintf("%s: %d -> %d (%s)", a.Setting, a.Current, a.Value, a.Reason)
+}
+
+// Observations made since the previous report, along with the advice
+// derived from them
+type AdvisorReport struct {
+	Interval time.Duration
+
+	// Delta chain lengths of the resident pages
+	ResidentPages int64
+	ChainLenP50   int
+	ChainLenP90   int
+	ChainLenMax   int
+
+	SwapInRate        float64
+	SwapOutRate       float64
+	CacheMissRatio    float64
+	CompactConflicts  float64
+	LSSFrag           int
+	WriteAmp          float64
+	ReadAmp           float64
+	SnapshotRate      float64
+	HasMemoryPressure bool
+
+	Advice []Advice
+}
+
+type advisorState struct {
+	sync.Mutex
+	sts       Stats
+	at        time.Time
+	snCreated int
+}
+
+func (s *Plasma) initAdvisor() {
+	s.advisor.sts = s.GetStats()
+	s.advisor.at = s.Clock.Now()
+}
+
+func (s *Plasma) chainLenStats(r *AdvisorReport) error {
+	var lens []int
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	callb := func(pid PageId, partn RangePartition) error {
+		pg, err := s.ReadPage(pid, nil, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		head := pg.(*page).head
+		if head != nil && !head.state.IsEvicted() {
+			lens = append(lens, int(head.chainLen))
+		}
+
+		return nil
+	}
+
+	opts := PageVisitorOptions{Ordered: true}
+	if err := s.PageVisitorWithOptions(context.Background(), callb, opts); err != nil {
+		return err
+	}
+
+	if len(lens) > 0 {
+		sort.Ints(lens)
+		r.ResidentPages = int64(len(lens))
+		r.ChainLenP50 = lens[(len(lens)-1)/2]
+		r.ChainLenP90 = lens[(len(lens)-1)*9/10]
+		r.ChainLenMax = lens[len(lens)-1]
+	}
+
+	return nil
+}
+
+// Observe the stats since the previous call and recommend configuration
+// changes. The first call observes the stats since the store was opened.
+func (s *Plasma) Advise() (AdvisorReport, error) {
+	var r AdvisorReport
+
+	s.advisor.Lock()
+	defer s.advisor.Unlock()
+
+	if err := s.chainLenStats(&r); err != nil {
+		return r, err
+	}
+
+	now := s.Clock.Now()
+	sts := s.GetStats()
+	s.mvcc.RLock()
+	snCreated := s.numSnCreated
+	s.mvcc.RUnlock()
+
+	prev := s.advisor.sts
+	r.Interval = now.Sub(s.advisor.at)
+	secs := r.Interval.Seconds()
+	if secs > 0 {
+		r.SwapInRate = float64(sts.NumRecordSwapIn-prev.NumRecordSwapIn) / secs
+		r.SwapOutRate = float64(sts.NumRecordSwapOut-prev.NumRecordSwapOut) / secs
+		r.SnapshotRate = float64(snCreated-s.advisor.snCreated) / secs
+	}
+
+	hits, misses := sts.CacheHits-prev.CacheHits, sts.CacheMisses-prev.CacheMisses
+	if hits+misses > 0 {
+		r.CacheMissRatio = float64(misses) / float64(hits+misses)
+	}
+
+	if compacts := sts.Compacts - prev.Compacts; compacts > 0 {
+		r.CompactConflicts = float64(sts.CompactConflicts-prev.CompactConflicts) / float64(compacts)
+	}
+
+	incoming := sts.BytesIncoming - prev.BytesIncoming
+	outgoing := sts.BytesOutgoing - prev.BytesOutgoing
+	if incoming > 0 {
+		r.WriteAmp = float64(sts.BytesWritten-prev.BytesWritten) / float64(incoming)
+	}
+
+	if outgoing > 0 {
+		read := (sts.LSSReadBytes - sts.LSSCleanerReadBytes) - (prev.LSSReadBytes - prev.LSSCleanerReadBytes)
+		r.ReadAmp = float64(read) / float64(outgoing)
+	}
+
+	r.LSSFrag = sts.LSSFrag
+	r.HasMemoryPressure = s.hasMemoryPressure
+	r.Advice = s.advise(&r, incoming, outgoing)
+
+	s.advisor.sts = sts
+	s.advisor.at = now
+	s.advisor.snCreated = snCreated
+	return r, nil
+}
+
+func (s *Plasma) advise(r *AdvisorReport, incoming, outgoing int64) []Advice {
+	var advice []Advice
+	add := func(setting string, curr, val int, reason string) {
+		if val != curr {
+			advice = append(advice, Advice{Setting: setting, Current: curr, Value: val, Reason: reason})
+		}
+	}
+
+	cfg := s.liveConfig()
+
+	// Compaction threshold
+	if r.CompactConflicts > 0.1 && cfg.MaxDeltaChainLen < 1000 {
+		add("MaxDeltaChainLen", cfg.MaxDeltaChainLen, cfg.MaxDeltaChainLen*3/2,
+			"compactions frequently conflict with writers")
+	} else if outgoing > 4*incoming && r.ChainLenP90 >= cfg.MaxDeltaChainLen/2 && cfg.MaxDeltaChainLen > 20 {
+		add("MaxDeltaChainLen", cfg.MaxDeltaChainLen, cfg.MaxDeltaChainLen*2/3,
+			"lookups walk long delta chains in a read mostly workload")
+	}
+
+	if s.shouldPersist {
+		if r.ReadAmp > 4 && cfg.MaxPageItems/2 >= 2*cfg.MinPageItems {
+			add("MaxPageItems", cfg.MaxPageItems, cfg.MaxPageItems/2,
+				"pages read from the log are much larger than the items returned")
+		}
+
+		// Segments of a page in the log
+		if r.SwapInRate > 0 && r.ReadAmp > 2 && cfg.MaxPageLSSSegments > 1 {
+			add("MaxPageLSSSegments", cfg.MaxPageLSSSegments, cfg.MaxPageLSSSegments-1,
+				"swapped in pages are read from many log segments")
+		} else if r.WriteAmp > 10 && cfg.MaxPageLSSSegments < 16 {
+			add("MaxPageLSSSegments", cfg.MaxPageLSSSegments, cfg.MaxPageLSSSegments+2,
+				"pages are rewritten in full too often")
+		}
+
+		if r.WriteAmp > 10 && r.LSSFrag <= cfg.LSSCleanerThreshold+10 && cfg.LSSCleanerThreshold < 50 {
+			add("LSSCleanerThreshold", cfg.LSSCleanerThreshold, cfg.LSSCleanerThreshold+10,
+				"relocations by the log cleaner dominate the writes")
+		}
+
+		// Eviction
+		if r.HasMemoryPressure && cfg.NumEvictorThreads < runtime.NumCPU() {
+			add("NumEvictorThreads", cfg.NumEvictorThreads, cfg.NumEvictorThreads+1,
+				"memory usage is above the quota")
+		} else if !r.HasMemoryPressure && r.SwapInRate > r.SwapOutRate && r.SwapOutRate > 0 &&
+			cfg.NumEvictorThreads > 1 {
+			add("NumEvictorThreads", cfg.NumEvictorThreads, cfg.NumEvictorThreads-1,
+				"evicted pages are swapped back in")
+		}
+
+		// The max sequence number is synced every MaxSnSyncFrequency
+		// snapshots, at most once a second is enough
+		if r.SnapshotRate > float64(cfg.MaxSnSyncFrequency) {
+			add("MaxSnSyncFrequency", cfg.MaxSnSyncFrequency, int(math.Ceil(r.SnapshotRate)),
+				"max sequence number is synced more than once a second")
+		}
+	}
+
+	return advice
+}
+
+// Apply the advice through UpdateConfig
+func (s *Plasma) ApplyAdvice(advice []Advice) error {
+	var d ConfigDelta
+	for i := range advice {
+		v := &advice[i].Value
+		switch advice[i].Setting {
+		case "MaxDeltaChainLen":
+			d.MaxDeltaChainLen = v
+		case "MaxPageItems":
+			d.MaxPageItems = v
+		case "MinPageItems":
+			d.MinPageItems = v
+		case "MaxPageLSSSegments":
+			d.MaxPageLSSSegments = v
+		case "LSSCleanerThreshold":
+			d.LSSCleanerThreshold = v
+		case "NumPersistorThreads":
+			d.NumPersistorThreads = v
+		case "NumEvictorThreads":
+			d.NumEvictorThreads = v
+		case "MaxSnSyncFrequency":
+			d.MaxSnSyncFrequency = v
+		default:
+			return fmt.Errorf("Unknown setting %s", advice[i].Setting)
+		}
+	}
+
+	return s.UpdateConfig(d)
+}
+
+func (s *Plasma) autoTuneDaemon(ws *workerState) {
+	for {
+		ws.beat()
+		ws.wait(s.AutoTuneInterval)
+		select {
+		case <-s.stopmon:
+			return
+		case <-s.Clock.After(s.AutoTuneInterval):
+		}
+
+		ws.doing("advising")
+		r, err := s.Advise()
+		if err == nil && len(r.Advice) > 0 {
+			err = s.ApplyAdvice(r.Advice)
+			for _, a := range r.Advice {
+				fmt.Printf("Plasma: Auto tune: %v\n", a)
+			}
+		}
+
+		if err != nil {
+			fmt.Printf("Plasma: Auto tune failed (err=%v)\n", err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPlasmaAdvisor(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	clock := NewVirtualClock(time.Now())
+	cfg := DefaultConfig()
+	cfg.File = "teststore.data"
+	cfg.AutoLSSCleaning = false
+	cfg.MaxSnSyncFrequency = 10
+	cfg.Clock = clock
+	s := newTestIntPlasmaStore(cfg)
+	defer func() {
+		clock.Advance(time.Minute)
+		s.Close()
+	}()
+
+	w := s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), nil)
+		if i%10 == 0 {
+			s.NewSnapshot().Close()
+		}
+	}
+
+	clock.Advance(time.Second)
+	r, err := s.Advise()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Interval != time.Second || r.SnapshotRate < 1000 || r.ResidentPages == 0 ||
+		r.ChainLenMax == 0 || r.ChainLenMax > cfg.MaxDeltaChainLen+1 {
+		t.Errorf("unexpected observations %+v", r)
+	}
+
+	var advice *Advice
+	for i := range r.Advice {
+		if r.Advice[i].Setting == "MaxSnSyncFrequency" {
+			advice = &r.Advice[i]
+		}
+	}
+
+	if advice == nil || advice.Current != 10 || advice.Value != int(r.SnapshotRate) {
+		t.Fatalf("expected advice for MaxSnSyncFrequency, got %v", r.Advice)
+	}
+
+	if err := s.ApplyAdvice([]Advice{*advice}); err != nil || s.LiveConfig().MaxSnSyncFrequency != advice.Value {
+		t.Errorf("expected advice to be applied (err=%v)", err)
+	}
+
+	// Observations start over from the previous call
+	clock.Advance(time.Second)
+	if r, _ := s.Advise(); r.SnapshotRate != 0 || len(r.Advice) != 0 {
+		t.Errorf("expected no advice, got %v", r.Advice)
+	}
+
+	if err := s.ApplyAdvice([]Advice{{Setting: "Unknown", Value: 1}}); err == nil {
+		t.Errorf("expected error for an unknown setting")
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package bench runs YCSB-like workloads against a plasma store and
+// reports throughput, latencies and amplification.
+//
+// A run loads RecordCount records and then performs OperationCount
+// operations from Threads goroutines. Only the operations are measured:
+//
+//	cfg := bench.DefaultConfig()
+//	cfg.Workload = bench.WorkloadA()
+//	cfg.DGMRatio = 0.2
+//	report, err := bench.Run(cfg)
+//	fmt.Println(report)
+//
+// With a non-zero DGMRatio the memory quota of the store is limited to
+// that fraction of its memory usage after the load, so that the
+// operations are served partly from the log.
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/nitro/plasma"
+)
+
+const dgmSettleTimeout = time.Second * 30
+
+type Config struct {
+	Plasma   plasma.Config
+	Workload Workload
+	Seed     int64
+	// Ratio of the memory quota to the memory used after the load. Zero
+	// keeps all the data in memory. It requires a persistent store.
+	DGMRatio float64
+	// The store files are removed after the run
+	Cleanup bool
+}
+
+func DefaultConfig() Config {
+	cfg := plasma.DefaultConfig()
+	cfg.File = "bench.data"
+
+	return Config{
+		Plasma:   cfg,
+		Workload: WorkloadA(),
+		Seed:     1,
+		Cleanup:  true,
+	}
+}
+
+type LatencyStats struct {
+	Count int64
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+type Report struct {
+	Workload   string
+	Ops        int64
+	Duration   time.Duration
+	Throughput float64
+	// Reads and scans which did not find the key
+	NotFound int64
+
+	Latencies map[OpType]LatencyStats
+
+	// Amplification during the operations
+	WriteAmp      float64
+	ReadAmp       float64
+	CacheHitRatio float64
+	ResidentRatio float64
+	MemoryInUse   int64
+	MemoryQuota   int64
+	LSSUsedSpace  int64
+}
+
+func (r *Report) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "workload %s: %d ops in %v, %.0f ops/sec, %d not found\n",
+		r.Workload, r.Ops, r.Duration, r.Throughput, r.NotFound)
+
+	for op := OpType(0); op < numOpTypes; op++ {
+		if l, ok := r.Latencies[op]; ok {
+			fmt.Fprintf(&b, "%-7s count:%d mean:%v p50:%v p95:%v p99:%v max:%v\n",
+				op, l.Count, l.Mean, l.P50, l.P95, l.P99, l.Max)
+		}
+	}
+
+	fmt.Fprintf(&b, "write_amp:%.2f read_amp:%.2f cache_hit_ratio:%.2f resident_ratio:%.2f\n",
+		r.WriteAmp, r.ReadAmp, r.CacheHitRatio, r.ResidentRatio)
+	fmt.Fprintf(&b, "memory_in_use:%d memory_quota:%d lss_used_space:%d",
+		r.MemoryInUse, r.MemoryQuota, r.LSSUsedSpace)
+	return b.String()
+}
+
+func latencyStats(ds []time.Duration) LatencyStats {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+	var sum time.Duration
+	for _, d := range ds {
+		sum += d
+	}
+
+	pct := func(p float64) time.Duration {
+		return ds[int(float64(len(ds)-1)*p)]
+	}
+
+	return LatencyStats{
+		Count: int64(len(ds)),
+		Mean:  sum / time.Duration(len(ds)),
+		P50:   pct(0.5),
+		P95:   pct(0.95),
+		P99:   pct(0.99),
+		Max:   ds[len(ds)-1],
+	}
+}
+
+type worker struct {
+	g        *generator
+	w        *plasma.Writer
+	s        *plasma.Plasma
+	vbuf     []byte
+	lat      [numOpTypes][]time.Duration
+	notFound int64
+}
+
+func (wr *worker) insert() error {
+	i := atomic.AddInt64(wr.g.inserted, 1) - 1
+	wr.vbuf = wr.g.value(wr.vbuf)
+	_, err := wr.w.InsertKV(wr.g.key(i), wr.vbuf)
+	return err
+}
+
+func (wr *worker) read() error {
+	_, err := wr.w.LookupKV(wr.g.key(wr.g.nextKey()))
+	if err == plasma.ErrItemNotFound || err == plasma.ErrItemNoValue {
+		wr.notFound++
+		err = nil
+	}
+
+	return err
+}
+
+func (wr *worker) update() error {
+	wr.vbuf = wr.g.value(wr.vbuf)
+	_, _, err := wr.w.UpsertKV(wr.g.key(wr.g.nextKey()), wr.vbuf)
+	return err
+}
+
+func (wr *worker) readModifyWrite() error {
+	k := wr.g.key(wr.g.nextKey())
+	if _, err := wr.w.LookupKV(k); err == plasma.ErrItemNotFound || err == plasma.ErrItemNoValue {
+		wr.notFound++
+	} else if err != nil {
+		return err
+	}
+
+	wr.vbuf = wr.g.value(wr.vbuf)
+	_, _, err := wr.w.UpsertKV(k, wr.vbuf)
+	return err
+}
+
+func (wr *worker) scan() error {
+	snap := wr.s.NewSnapshot()
+	itr := snap.NewIterator()
+	snap.Close()
+	defer itr.Close()
+
+	itr.Seek(wr.g.key(wr.g.nextKey()))
+	if !itr.Valid() {
+		wr.notFound++
+	}
+
+	for n := wr.g.scanLength(); n > 0 && itr.Valid(); n-- {
+		itr.Next()
+	}
+
+	return nil
+}
+
+func (wr *worker) do(op OpType) error {
+	t0 := time.Now()
+
+	var err error
+	switch op {
+	case OpRead:
+		err = wr.read()
+	case OpUpdate:
+		err = wr.update()
+	case OpInsert:
+		err = wr.insert()
+	case OpScan:
+		err = wr.scan()
+	case OpReadModifyWrite:
+		err = wr.readModifyWrite()
+	}
+
+	wr.lat[op] = append(wr.lat[op], time.Since(t0))
+	return err
+}
+
+// Run the workload on threads goroutines, each calling fn n/threads times
+func parallel(threads, n int, fn func(id, i int) error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, threads)
+	for id := 0; id < threads; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for i := id; i < n; i += threads {
+				if err := fn(id, i); err != nil {
+					errs[id] = err
+					return
+				}
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func Run(cfg Config) (*Report, error) {
+	wl := cfg.Workload
+	if err := wl.Validate(); err != nil {
+		return nil, err
+	}
+
+	pcfg := cfg.Plasma
+	if cfg.DGMRatio > 0 {
+		if pcfg.File == "" {
+			return nil, fmt.Errorf("Invalid config: DGMRatio requires a persistent store")
+		}
+
+		pcfg.QuotaManager = plasma.NewQuotaManager(1 << 62)
+		pcfg.AutoSwapper = true
+	}
+
+	if cfg.Cleanup && pcfg.File != "" {
+		os.RemoveAll(pcfg.File)
+		defer os.RemoveAll(pcfg.File)
+	}
+
+	s, err := plasma.New(pcfg)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	var inserted int64
+	workers := make([]*worker, wl.Threads)
+	for i := range workers {
+		workers[i] = &worker{
+			g: newGenerator(wl, cfg.Seed+int64(i), &inserted),
+			w: s.NewWriter(),
+			s: s,
+		}
+	}
+
+	err = parallel(wl.Threads, wl.RecordCount, func(id, i int) error {
+		return workers[id].insert()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Workload: wl.Name, Latencies: make(map[OpType]LatencyStats)}
+	if cfg.DGMRatio > 0 {
+		s.PersistAll()
+		report.MemoryQuota = int64(float64(s.MemoryInUse()) * cfg.DGMRatio)
+		pcfg.QuotaManager.SetQuota(report.MemoryQuota)
+
+		// Give the swapper time to reach the quota
+		deadline := time.Now().Add(dgmSettleTimeout)
+		for s.MemoryInUse() > report.MemoryQuota && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	before := s.GetStats()
+	t0 := time.Now()
+	err = parallel(wl.Threads, wl.OperationCount, func(id, i int) error {
+		wr := workers[id]
+		return wr.do(wr.g.nextOp())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.Duration = time.Since(t0)
+	after := s.GetStats()
+
+	for op := OpType(0); op < numOpTypes; op++ {
+		var ds []time.Duration
+		for _, wr := range workers {
+			ds = append(ds, wr.lat[op]...)
+		}
+
+		if len(ds) > 0 {
+			report.Latencies[op] = latencyStats(ds)
+			report.Ops += int64(len(ds))
+		}
+	}
+
+	for _, wr := range workers {
+		report.NotFound += wr.notFound
+	}
+
+	report.Throughput = float64(report.Ops) / report.Duration.Seconds()
+
+	if in := after.BytesIncoming - before.BytesIncoming; in > 0 {
+		report.WriteAmp = float64(after.BytesWritten-before.BytesWritten) / float64(in)
+	}
+
+	if out := after.BytesOutgoing - before.BytesOutgoing; out > 0 {
+		read := (after.LSSReadBytes - after.LSSCleanerReadBytes) -
+			(before.LSSReadBytes - before.LSSCleanerReadBytes)
+		report.ReadAmp = float64(read) / float64(out)
+	}
+
+	if n := (after.CacheHits - before.CacheHits) + (after.CacheMisses - before.CacheMisses); n > 0 {
+		report.CacheHitRatio = float64(after.CacheHits-before.CacheHits) / float64(n)
+	}
+
+	report.ResidentRatio = after.ResidentRatio
+	report.MemoryInUse = s.MemoryInUse()
+	report.LSSUsedSpace = after.LSSUsedSpace
+	return report, nil
+}
@@ -0,0 +1,64 @@
+package bench
+
+import (
+	"testing"
+)
+
+func TestBenchWorkloads(t *testing.T) {
+	for _, wl := range []Workload{WorkloadA(), WorkloadD(), WorkloadE(), WorkloadF()} {
+		cfg := DefaultConfig()
+		cfg.Workload = wl
+		cfg.Workload.RecordCount = 10000
+		cfg.Workload.OperationCount = 10000
+		cfg.Workload.Threads = 2
+
+		r, err := Run(cfg)
+		if err != nil {
+			t.Fatalf("workload %s failed: %v", wl.Name, err)
+		}
+
+		if r.Ops != int64(cfg.Workload.OperationCount) || r.Throughput <= 0 {
+			t.Errorf("workload %s: unexpected report\n%v", wl.Name, r)
+		}
+
+		if r.NotFound > r.Ops/10 {
+			t.Errorf("workload %s: too many missing keys %d", wl.Name, r.NotFound)
+		}
+	}
+}
+
+func TestBenchDGM(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Workload = WorkloadC()
+	cfg.Workload.RecordCount = 50000
+	cfg.Workload.OperationCount = 20000
+	cfg.Workload.KeyDistribution = Uniform
+	cfg.DGMRatio = 0.2
+
+	r, err := Run(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.MemoryQuota == 0 || r.ReadAmp == 0 || r.CacheHitRatio == 1 {
+		t.Errorf("expected reads from the log\n%v", r)
+	}
+
+	if _, ok := r.Latencies[OpRead]; !ok || len(r.Latencies) != 1 {
+		t.Errorf("expected only read latencies\n%v", r)
+	}
+}
+
+func TestWorkloadValidate(t *testing.T) {
+	wl := WorkloadA()
+	wl.ReadProportion, wl.UpdateProportion = 0, 0
+	if wl.Validate() == nil {
+		t.Errorf("expected error for a workload without operations")
+	}
+
+	wl = WorkloadA()
+	wl.MaxValueSize = wl.MinValueSize - 1
+	if wl.Validate() == nil {
+		t.Errorf("expected error for an invalid value size range")
+	}
+}
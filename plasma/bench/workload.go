@@ -0,0 +1,278 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package bench
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+)
+
+type OpType int
+
+const (
+	OpRead OpType = iota
+	OpUpdate
+	OpInsert
+	OpScan
+	OpReadModifyWrite
+	numOpTypes
+)
+
+func (op OpType) String() string {
+	switch op {
+	case OpRead:
+		return "read"
+	case OpUpdate:
+		return "update"
+	case OpInsert:
+		return "insert"
+	case OpScan:
+		return "scan"
+	case OpReadModifyWrite:
+		return "rmw"
+	}
+
+	return "unknown"
+}
+
+type Distribution int
+
+const (
+	Uniform Distribution = iota
+	// Few keys are accessed most of the time
+	Zipfian
+	// Recently inserted keys are accessed most of the time
+	Latest
+	Sequential
+)
+
+// Workload is modelled after the YCSB core workloads. The proportions of
+// the operations are relative to their sum.
+type Workload struct {
+	Name string
+
+	// Records inserted before the operations are run
+	RecordCount    int
+	OperationCount int
+
+	ReadProportion            float64
+	UpdateProportion          float64
+	InsertProportion          float64
+	ScanProportion            float64
+	ReadModifyWriteProportion float64
+
+	KeyDistribution Distribution
+	// Inserted keys are in order of insertion, otherwise their order is
+	// scattered by hashing
+	OrderedInserts bool
+
+	// Values are between MinValueSize and MaxValueSize bytes
+	MinValueSize int
+	MaxValueSize int
+	// Scans read up to MaxScanLength items
+	MaxScanLength int
+
+	Threads int
+}
+
+func coreWorkload(name string) Workload {
+	return Workload{
+		Name:            name,
+		RecordCount:     100000,
+		OperationCount:  100000,
+		KeyDistribution: Zipfian,
+		MinValueSize:    100,
+		MaxValueSize:    100,
+		MaxScanLength:   100,
+		Threads:         1,
+	}
+}
+
+// Update heavy, 50% reads and 50% updates
+func WorkloadA() Workload {
+	w := coreWorkload("A")
+	w.ReadProportion, w.UpdateProportion = 0.5, 0.5
+	return w
+}
+
+// Read mostly, 95% reads and 5% updates
+func WorkloadB() Workload {
+	w := coreWorkload("B")
+	w.ReadProportion, w.UpdateProportion = 0.95, 0.05
+	return w
+}
+
+// Read only
+func WorkloadC() Workload {
+	w := coreWorkload("C")
+	w.ReadProportion = 1
+	return w
+}
+
+// Read latest, 95% reads of recent inserts and 5% inserts
+func WorkloadD() Workload {
+	w := coreWorkload("D")
+	w.ReadProportion, w.InsertProportion = 0.95, 0.05
+	w.KeyDistribution = Latest
+	return w
+}
+
+// Short ranges, 95% scans and 5% inserts
+func WorkloadE() Workload {
+	w := coreWorkload("E")
+	w.ScanProportion, w.InsertProportion = 0.95, 0.05
+	return w
+}
+
+// Read-modify-write, 50% reads and 50% read-modify-writes
+func WorkloadF() Workload {
+	w := coreWorkload("F")
+	w.ReadProportion, w.ReadModifyWriteProportion = 0.5, 0.5
+	return w
+}
+
+func (w Workload) Validate() error {
+	if w.RecordCount <= 0 && w.InsertProportion <= 0 {
+		return fmt.Errorf("Invalid workload: no records to operate on")
+	}
+
+	if w.MinValueSize < 0 || w.MaxValueSize < w.MinValueSize {
+		return fmt.Errorf("Invalid workload: value size range (%d-%d)", w.MinValueSize, w.MaxValueSize)
+	}
+
+	if w.Threads <= 0 {
+		return fmt.Errorf("Invalid workload: Threads (%d) should be positive", w.Threads)
+	}
+
+	total := w.ReadProportion + w.UpdateProportion + w.InsertProportion +
+		w.ScanProportion + w.ReadModifyWriteProportion
+	if total <= 0 {
+		return fmt.Errorf("Invalid workload: operation proportions should not be zero")
+	}
+
+	return nil
+}
+
+// Generates the operations of a thread
+type generator struct {
+	w   Workload
+	rnd *rand.Rand
+
+	// Number of records inserted, shared by the threads
+	inserted *int64
+
+	zipf *rand.Zipf
+	seq  int64
+
+	cdf [numOpTypes]float64
+}
+
+func newGenerator(w Workload, seed int64, inserted *int64) *generator {
+	g := &generator{
+		w:        w,
+		rnd:      rand.New(rand.NewSource(seed)),
+		inserted: inserted,
+	}
+
+	props := [numOpTypes]float64{
+		OpRead:            w.ReadProportion,
+		OpUpdate:          w.UpdateProportion,
+		OpInsert:          w.InsertProportion,
+		OpScan:            w.ScanProportion,
+		OpReadModifyWrite: w.ReadModifyWriteProportion,
+	}
+
+	var total, sum float64
+	for _, p := range props {
+		total += p
+	}
+
+	for op, p := range props {
+		sum += p
+		g.cdf[op] = sum / total
+	}
+
+	n := w.RecordCount + int(float64(w.OperationCount)*w.InsertProportion)
+	if n < 2 {
+		n = 2
+	}
+	g.zipf = rand.NewZipf(g.rnd, 1.1, 1, uint64(n-1))
+	return g
+}
+
+func (g *generator) nextOp() OpType {
+	r := g.rnd.Float64()
+	for op := OpType(0); op < numOpTypes; op++ {
+		if r < g.cdf[op] {
+			return op
+		}
+	}
+
+	return OpRead
+}
+
+// Index of an existing record to operate on
+func (g *generator) nextKey() int64 {
+	n := atomic.LoadInt64(g.inserted)
+	if n == 0 {
+		return 0
+	}
+
+	switch g.w.KeyDistribution {
+	case Zipfian:
+		return int64(g.zipf.Uint64()) % n
+	case Latest:
+		return n - 1 - int64(g.zipf.Uint64())%n
+	case Sequential:
+		g.seq++
+		return g.seq % n
+	}
+
+	return g.rnd.Int63n(n)
+}
+
+func (g *generator) key(i int64) []byte {
+	if g.w.OrderedInserts {
+		return []byte(fmt.Sprintf("user%020d", i))
+	}
+
+	h := fnv.New64a()
+	var buf [8]byte
+	for j := range buf {
+		buf[j] = byte(uint64(i) >> (8 * uint(j)))
+	}
+	h.Write(buf[:])
+	return []byte(fmt.Sprintf("user%020d", h.Sum64()))
+}
+
+func (g *generator) value(buf []byte) []byte {
+	n := g.w.MinValueSize
+	if d := g.w.MaxValueSize - g.w.MinValueSize; d > 0 {
+		n += g.rnd.Intn(d + 1)
+	}
+
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+
+	buf = buf[:n]
+	g.rnd.Read(buf)
+	return buf
+}
+
+func (g *generator) scanLength() int {
+	if g.w.MaxScanLength <= 1 {
+		return 1
+	}
+
+	return 1 + g.rnd.Intn(g.w.MaxScanLength)
+}
@@ -0,0 +1,382 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package bolt provides transactions and nested buckets with the semantics
+// of BoltDB on top of the Plasma MVCC key value API, so that applications
+// written against BoltDB can be moved onto Plasma.
+//
+//	db, err := bolt.Open("data")
+//	err = db.Update(func(tx *bolt.Tx) error {
+//		b, err := tx.CreateBucketIfNotExists([]byte("users"))
+//		if err != nil {
+//			return err
+//		}
+//		return b.Put([]byte("alice"), []byte("admin"))
+//	})
+//
+// Any number of read-only transactions run concurrently with a single
+// read-write transaction. A read-write transaction buffers its writes,
+// which are applied into Plasma on commit. Read-only transactions view the
+// store as of the last commit, hence a commit is visible atomically.
+// Committed transactions are made durable by Sync or Close, rather than
+// by every commit.
+//
+// Cursors iterate forward only and there is no support for the BoltDB
+// file level APIs such as Stats, Check or WriteTo.
+package bolt
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/couchbase/nitro/keyenc"
+	"github.com/couchbase/nitro/plasma"
+)
+
+var (
+	// ErrTxClosed means the transaction is committed or rolled back
+	ErrTxClosed = errors.New("tx closed")
+	// ErrTxNotWritable means a write is attempted by a read-only transaction
+	ErrTxNotWritable = errors.New("tx not writable")
+	// ErrBucketNotFound means the bucket does not exist
+	ErrBucketNotFound = errors.New("bucket not found")
+	// ErrBucketExists means the bucket being created exists already
+	ErrBucketExists = errors.New("bucket already exists")
+	// ErrBucketNameRequired means the bucket name is empty
+	ErrBucketNameRequired = errors.New("bucket name required")
+	// ErrKeyRequired means the key is empty
+	ErrKeyRequired = errors.New("key required")
+	// ErrIncompatibleValue means a bucket is accessed as a value or the
+	// other way round
+	ErrIncompatibleValue = errors.New("incompatible value")
+)
+
+// Layout of the Plasma keys:
+// Keys of a bucket are prefixed by the bucket prefix followed by tagEntry.
+// Nested buckets have the prefix of the parent followed by tagBucket and
+// the order preserving encoding of the bucket name. The root bucket, which
+// holds the top level buckets, has an empty prefix.
+//
+// Values are prefixed by tagValue, whereas an entry of a nested bucket has
+// tagBucket followed by the bucket sequence.
+const (
+	tagBucket = 'b'
+	tagEntry  = 'e'
+	tagValue  = 'v'
+)
+
+// DB is a store of buckets persisted into a Plasma instance
+type DB struct {
+	s *plasma.Plasma
+	w *plasma.Writer
+
+	// Held by the read-write transaction
+	wmu sync.Mutex
+
+	// Snapshot as of the last commit, which is read by the transactions
+	mu        sync.Mutex
+	committed *plasma.Snapshot
+}
+
+// Open opens or creates the store in the file by using the default
+// Plasma configuration
+func Open(file string) (*DB, error) {
+	cfg := plasma.DefaultConfig()
+	cfg.File = file
+	return OpenWithConfig(cfg)
+}
+
+// OpenWithConfig opens or creates the store by using the configuration
+func OpenWithConfig(cfg plasma.Config) (*DB, error) {
+	s, err := plasma.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{
+		s:         s,
+		w:         s.NewWriter(),
+		committed: s.NewSnapshot(),
+	}, nil
+}
+
+// Sync persists the committed transactions, so that they are recovered
+// when the store is opened again
+func (db *DB) Sync() error {
+	return db.s.PersistAllContext(context.Background())
+}
+
+// Close persists the committed transactions and closes the store.
+// Transactions should be closed beforehand.
+func (db *DB) Close() error {
+	err := db.Sync()
+	db.committed.Close()
+	db.s.Close()
+	return err
+}
+
+// Begin starts a transaction, which should be closed by Commit or
+// Rollback. A read-write transaction blocks the other read-write
+// transactions until it is closed.
+func (db *DB) Begin(writable bool) (*Tx, error) {
+	if writable {
+		db.wmu.Lock()
+	}
+
+	db.mu.Lock()
+	snap := db.committed
+	snap.Open()
+	db.mu.Unlock()
+
+	tx := &Tx{
+		db:       db,
+		writable: writable,
+		snap:     snap,
+	}
+
+	tx.root = &Bucket{tx: tx}
+	if writable {
+		tx.pending = make(map[string][]byte)
+	}
+
+	return tx, nil
+}
+
+// Update runs the function in a read-write transaction. The transaction
+// is committed if the function returns no error and rolled back otherwise.
+func (db *DB) Update(fn func(*Tx) error) error {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// View runs the function in a read-only transaction
+func (db *DB) View(fn func(*Tx) error) error {
+	tx, err := db.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	return fn(tx)
+}
+
+// Tx is a read-only or a read-write transaction. A transaction should
+// not be shared by goroutines.
+type Tx struct {
+	db       *DB
+	writable bool
+	closed   bool
+	root     *Bucket
+
+	snap    *plasma.Snapshot
+	lookup  *plasma.MVCCIterator
+	cursors []*Cursor
+
+	// Writes buffered until commit, where nil marks a delete. Keys are
+	// sorted into keys for the cursors whenever they are stale.
+	pending map[string][]byte
+	keys    []string
+	stale   bool
+}
+
+// Writable returns true for a read-write transaction
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+// Bucket returns the top level bucket of the name, or nil
+func (tx *Tx) Bucket(name []byte) *Bucket {
+	return tx.root.Bucket(name)
+}
+
+// CreateBucket creates a top level bucket
+func (tx *Tx) CreateBucket(name []byte) (*Bucket, error) {
+	return tx.root.CreateBucket(name)
+}
+
+// CreateBucketIfNotExists creates a top level bucket, unless it exists
+func (tx *Tx) CreateBucketIfNotExists(name []byte) (*Bucket, error) {
+	return tx.root.CreateBucketIfNotExists(name)
+}
+
+// DeleteBucket deletes a top level bucket along with its contents
+func (tx *Tx) DeleteBucket(name []byte) error {
+	return tx.root.DeleteBucket(name)
+}
+
+// ForEach calls the function for each top level bucket
+func (tx *Tx) ForEach(fn func(name []byte, b *Bucket) error) error {
+	return tx.root.ForEach(func(k, v []byte) error {
+		return fn(k, tx.root.Bucket(k))
+	})
+}
+
+// Cursor creates a cursor over the names of the top level buckets
+func (tx *Tx) Cursor() *Cursor {
+	return tx.root.Cursor()
+}
+
+// Commit applies the writes of a read-write transaction and closes it.
+// Commit of a read-only transaction returns ErrTxNotWritable.
+func (tx *Tx) Commit() error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	db := tx.db
+	var applied []string
+	for k, v := range tx.pending {
+		if err := db.write([]byte(k), v); err != nil {
+			// Writes applied so far are not visible to the transactions
+			// until the next commit, hence those are reverted
+			for _, k := range applied {
+				delete(tx.pending, k)
+				db.write([]byte(k), tx.get([]byte(k)))
+			}
+
+			tx.close()
+			return err
+		}
+		applied = append(applied, k)
+	}
+
+	snap := db.s.NewSnapshot()
+	db.mu.Lock()
+	db.committed, snap = snap, db.committed
+	db.mu.Unlock()
+	snap.Close()
+
+	tx.close()
+	return nil
+}
+
+// A nil value deletes the key
+func (db *DB) write(k, v []byte) (err error) {
+	if v == nil {
+		_, err = db.w.DeleteKV(k)
+	} else {
+		_, err = db.w.ReplaceKV(k, v)
+	}
+
+	return
+}
+
+// Rollback discards the writes of the transaction and closes it
+func (tx *Tx) Rollback() error {
+	if tx.closed {
+		return ErrTxClosed
+	}
+
+	tx.close()
+	return nil
+}
+
+func (tx *Tx) close() {
+	for _, c := range tx.cursors {
+		c.itr.Close()
+	}
+
+	if tx.lookup != nil {
+		tx.lookup.Close()
+	}
+
+	tx.snap.Close()
+	tx.closed = true
+	tx.pending = nil
+	tx.cursors = nil
+	if tx.writable {
+		tx.db.wmu.Unlock()
+	}
+}
+
+// Returns the value of the key written by the transaction, or else
+// visible in its snapshot. The value is a copy valid for the transaction.
+func (tx *Tx) get(k []byte) []byte {
+	if tx.closed {
+		return nil
+	}
+
+	if v, ok := tx.pending[string(k)]; ok {
+		return v
+	}
+
+	if tx.lookup == nil {
+		tx.lookup = tx.snap.NewIterator()
+	}
+
+	if !tx.lookup.SeekExact(k) {
+		return nil
+	}
+
+	return append([]byte(nil), tx.lookup.Value()...)
+}
+
+func (tx *Tx) checkWritable() error {
+	if tx.closed {
+		return ErrTxClosed
+	}
+
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+
+	return nil
+}
+
+func (tx *Tx) put(k, v []byte) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	if _, ok := tx.pending[string(k)]; !ok {
+		tx.stale = true
+	}
+
+	tx.pending[string(k)] = v
+	return nil
+}
+
+// Pending writes in the key order
+func (tx *Tx) sortedKeys() []string {
+	if tx.stale {
+		tx.keys = make([]string, 0, len(tx.pending))
+		for k := range tx.pending {
+			tx.keys = append(tx.keys, k)
+		}
+
+		sort.Strings(tx.keys)
+		tx.stale = false
+	}
+
+	return tx.keys
+}
+
+func entryKey(prefix, k []byte) []byte {
+	key := make([]byte, 0, len(prefix)+len(k)+1)
+	key = append(key, prefix...)
+	key = append(key, tagEntry)
+	return append(key, k...)
+}
+
+func bucketPrefix(prefix, name []byte) []byte {
+	p := append([]byte(nil), prefix...)
+	p = append(p, tagBucket)
+	return keyenc.AppendBytes(p, name)
+}
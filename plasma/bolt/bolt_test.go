@@ -0,0 +1,181 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package bolt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func newTestDB(t *testing.T) *DB {
+	db, err := Open("teststore.data")
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	return db
+}
+
+func bucketKeys(b *Bucket) (keys []string) {
+	b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			keys = append(keys, "["+string(k)+"]")
+		} else {
+			keys = append(keys, string(k)+"="+string(v))
+		}
+		return nil
+	})
+
+	return
+}
+
+func TestBoltBuckets(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	defer os.RemoveAll("teststore.data")
+	db := newTestDB(t)
+
+	err := db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < 100; i++ {
+			b.Put([]byte(fmt.Sprintf("user-%03d", i)), []byte(fmt.Sprint(i)))
+		}
+
+		nested, err := b.CreateBucket([]byte("admins"))
+		if err != nil {
+			return err
+		}
+		nested.Put([]byte("alice"), []byte("1"))
+
+		if _, err := b.CreateBucket([]byte("admins")); err != ErrBucketExists {
+			t.Errorf("Expected ErrBucketExists, got %v", err)
+		}
+
+		if err := b.Put([]byte("admins"), []byte("x")); err != ErrIncompatibleValue {
+			t.Errorf("Expected ErrIncompatibleValue, got %v", err)
+		}
+
+		_, err = tx.CreateBucket([]byte("groups"))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	// Uncommitted writes are visible to the cursors of the transaction
+	// along with the committed ones, but not to the other transactions
+	view, _ := db.Begin(false)
+	err = db.Update(func(tx *Tx) error {
+		b := tx.Bucket([]byte("users"))
+		for i := 0; i < 100; i += 2 {
+			b.Delete([]byte(fmt.Sprintf("user-%03d", i)))
+		}
+		b.Put([]byte("user-001"), []byte("new"))
+		b.Put([]byte("user-1000"), []byte("1000"))
+
+		keys := bucketKeys(b)
+		if len(keys) != 52 || keys[0] != "[admins]" || keys[1] != "user-001=new" ||
+			keys[2] != "user-003=3" || keys[51] != "user-1000=1000" {
+			t.Errorf("Unexpected keys %v", keys)
+		}
+
+		c := b.Cursor()
+		if k, v := c.Seek([]byte("user-050")); string(k) != "user-051" || string(v) != "51" {
+			t.Errorf("Expected user-051, got %s", k)
+		}
+
+		if seq, _ := b.NextSequence(); seq != 1 {
+			t.Errorf("Expected sequence 1, got %d", seq)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	if keys := bucketKeys(view.Bucket([]byte("users"))); len(keys) != 101 {
+		t.Errorf("Expected the view to be isolated, got %d keys", len(keys))
+	}
+	view.Rollback()
+
+	// Transaction failing is rolled back
+	errFail := errors.New("fail")
+	err = db.Update(func(tx *Tx) error {
+		tx.Bucket([]byte("users")).Put([]byte("user-002"), []byte("2"))
+		tx.DeleteBucket([]byte("groups"))
+		return errFail
+	})
+	if err != errFail {
+		t.Fatalf("Expected error %v, got %v", errFail, err)
+	}
+
+	db.View(func(tx *Tx) error {
+		b := tx.Bucket([]byte("users"))
+		if b.Get([]byte("user-002")) != nil || string(b.Get([]byte("user-001"))) != "new" {
+			t.Errorf("Expected the failed transaction to be rolled back")
+		}
+
+		if b.Sequence() != 1 {
+			t.Errorf("Expected sequence 1, got %d", b.Sequence())
+		}
+
+		if err := b.Put([]byte("x"), nil); err != ErrTxNotWritable {
+			t.Errorf("Expected ErrTxNotWritable, got %v", err)
+		}
+
+		var names []string
+		tx.ForEach(func(name []byte, b *Bucket) error {
+			names = append(names, string(name))
+			return nil
+		})
+		if len(names) != 2 || names[0] != "groups" || names[1] != "users" {
+			t.Errorf("Unexpected buckets %v", names)
+		}
+		return nil
+	})
+
+	// Deleting a bucket removes its nested buckets
+	err = db.Update(func(tx *Tx) error {
+		return tx.DeleteBucket([]byte("users"))
+	})
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	db.Update(func(tx *Tx) error {
+		b, _ := tx.CreateBucket([]byte("users"))
+		if keys := bucketKeys(b); len(keys) != 0 {
+			t.Errorf("Expected recreated bucket to be empty, got %v", keys)
+		}
+		if b.Bucket([]byte("admins")) != nil {
+			t.Errorf("Expected nested bucket to be deleted")
+		}
+		return b.Put([]byte("bob"), []byte("2"))
+	})
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	// Committed transactions are recovered on reopening
+	db = newTestDB(t)
+	defer db.Close()
+	db.View(func(tx *Tx) error {
+		if keys := bucketKeys(tx.Bucket([]byte("users"))); len(keys) != 1 || keys[0] != "bob=2" {
+			t.Errorf("Unexpected keys %v", keys)
+		}
+		return nil
+	})
+}
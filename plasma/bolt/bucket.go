@@ -0,0 +1,356 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/couchbase/nitro/keyenc"
+	"github.com/couchbase/nitro/plasma"
+)
+
+// Bucket is a namespace of keys, which may hold nested buckets. A bucket
+// is valid for the lifetime of the transaction it is obtained from.
+type Bucket struct {
+	tx     *Tx
+	prefix []byte
+	// Key of the bucket entry in the parent bucket, nil for the root
+	entry []byte
+}
+
+// Tx returns the transaction of the bucket
+func (b *Bucket) Tx() *Tx {
+	return b.tx
+}
+
+// Writable returns true if the bucket belongs to a read-write transaction
+func (b *Bucket) Writable() bool {
+	return b.tx.writable
+}
+
+// Get returns the value of the key. It returns nil if the key does not
+// exist or it is a nested bucket. The value is valid for the lifetime of
+// the transaction.
+func (b *Bucket) Get(k []byte) []byte {
+	v := b.tx.get(entryKey(b.prefix, k))
+	if v == nil || v[0] != tagValue {
+		return nil
+	}
+
+	return v[1:]
+}
+
+// Put sets the value of the key
+func (b *Bucket) Put(k, v []byte) error {
+	if len(k) == 0 {
+		return ErrKeyRequired
+	}
+
+	key := entryKey(b.prefix, k)
+	if old := b.tx.get(key); old != nil && old[0] == tagBucket {
+		return ErrIncompatibleValue
+	}
+
+	val := make([]byte, 0, len(v)+1)
+	val = append(val, tagValue)
+	return b.tx.put(key, append(val, v...))
+}
+
+// Delete removes the key. Deleting a missing key is not an error.
+func (b *Bucket) Delete(k []byte) error {
+	key := entryKey(b.prefix, k)
+	old := b.tx.get(key)
+	if old != nil && old[0] == tagBucket {
+		return ErrIncompatibleValue
+	}
+
+	if old == nil {
+		return b.tx.checkWritable()
+	}
+
+	return b.tx.put(key, nil)
+}
+
+// Bucket returns the nested bucket of the name, or nil
+func (b *Bucket) Bucket(name []byte) *Bucket {
+	key := entryKey(b.prefix, name)
+	if v := b.tx.get(key); v == nil || v[0] != tagBucket {
+		return nil
+	}
+
+	return &Bucket{
+		tx:     b.tx,
+		prefix: bucketPrefix(b.prefix, name),
+		entry:  key,
+	}
+}
+
+// CreateBucket creates a nested bucket
+func (b *Bucket) CreateBucket(name []byte) (*Bucket, error) {
+	if len(name) == 0 {
+		return nil, ErrBucketNameRequired
+	}
+
+	key := entryKey(b.prefix, name)
+	if v := b.tx.get(key); v != nil {
+		if v[0] == tagBucket {
+			return nil, ErrBucketExists
+		}
+
+		return nil, ErrIncompatibleValue
+	}
+
+	if err := b.tx.put(key, newBucketEntry(0)); err != nil {
+		return nil, err
+	}
+
+	return b.Bucket(name), nil
+}
+
+// CreateBucketIfNotExists creates a nested bucket, unless it exists
+func (b *Bucket) CreateBucketIfNotExists(name []byte) (*Bucket, error) {
+	if child := b.Bucket(name); child != nil {
+		return child, nil
+	}
+
+	return b.CreateBucket(name)
+}
+
+// DeleteBucket deletes a nested bucket along with its contents
+func (b *Bucket) DeleteBucket(name []byte) error {
+	if err := b.tx.checkWritable(); err != nil {
+		return err
+	}
+
+	key := entryKey(b.prefix, name)
+	v := b.tx.get(key)
+	if v == nil {
+		return ErrBucketNotFound
+	} else if v[0] != tagBucket {
+		return ErrIncompatibleValue
+	}
+
+	// Keys of the bucket and of the buckets nested within it
+	prefix := bucketPrefix(b.prefix, name)
+	var keys [][]byte
+	c := b.tx.newCursor(b, prefix, keyenc.PrefixEnd(prefix))
+	for c.seek(prefix); c.key != nil; c.next() {
+		keys = append(keys, c.key)
+	}
+
+	for _, k := range append(keys, key) {
+		b.tx.put(k, nil)
+	}
+
+	return nil
+}
+
+// Sequence returns the sequence of the bucket
+func (b *Bucket) Sequence() uint64 {
+	if b.entry == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b.tx.get(b.entry)[1:])
+}
+
+// SetSequence sets the sequence of the bucket
+func (b *Bucket) SetSequence(seq uint64) error {
+	if b.entry == nil {
+		return ErrIncompatibleValue
+	}
+
+	return b.tx.put(b.entry, newBucketEntry(seq))
+}
+
+// NextSequence increments the sequence of the bucket and returns it
+func (b *Bucket) NextSequence() (uint64, error) {
+	seq := b.Sequence() + 1
+	if err := b.SetSequence(seq); err != nil {
+		return 0, err
+	}
+
+	return seq, nil
+}
+
+// ForEach calls the function for each key of the bucket in the key order.
+// The value is nil for the nested buckets. The bucket should not be
+// modified by the function.
+func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Cursor creates a cursor over the keys of the bucket
+func (b *Bucket) Cursor() *Cursor {
+	lo := append(append([]byte(nil), b.prefix...), tagEntry)
+	return b.tx.newCursor(b, lo, keyenc.PrefixEnd(lo))
+}
+
+func newBucketEntry(seq uint64) []byte {
+	v := make([]byte, 9)
+	v[0] = tagBucket
+	binary.BigEndian.PutUint64(v[1:], seq)
+	return v
+}
+
+// Cursor iterates over the keys of a bucket in the key order, by merging
+// the keys of the transaction snapshot with the writes of the transaction.
+// Writes of the transaction made after positioning the cursor by First or
+// Seek may not be seen by it.
+type Cursor struct {
+	b      *Bucket
+	lo, hi []byte
+	itr    *plasma.MVCCIterator
+
+	// Sorted pending writes of the transaction
+	keys []string
+	pos  int
+
+	// Current key and its tagged value, nil once the cursor is invalid
+	key, val []byte
+}
+
+func (tx *Tx) newCursor(b *Bucket, lo, hi []byte) *Cursor {
+	c := &Cursor{b: b, lo: lo, hi: hi}
+	if !tx.closed {
+		c.itr = tx.snap.NewIterator()
+		tx.cursors = append(tx.cursors, c)
+	}
+
+	return c
+}
+
+// Bucket returns the bucket of the cursor
+func (c *Cursor) Bucket() *Bucket {
+	return c.b
+}
+
+// First moves the cursor to the first key of the bucket. It returns nil
+// key if the bucket is empty. The value is nil for the nested buckets.
+func (c *Cursor) First() (k, v []byte) {
+	c.seek(c.lo)
+	return c.current()
+}
+
+// Seek moves the cursor to the first key greater than or equal to seek
+func (c *Cursor) Seek(seek []byte) (k, v []byte) {
+	c.seek(append(append([]byte(nil), c.lo...), seek...))
+	return c.current()
+}
+
+// Next moves the cursor to the next key
+func (c *Cursor) Next() (k, v []byte) {
+	c.next()
+	return c.current()
+}
+
+// Delete removes the current key
+func (c *Cursor) Delete() error {
+	if c.key == nil {
+		return nil
+	}
+
+	if c.val[0] == tagBucket {
+		return ErrIncompatibleValue
+	}
+
+	return c.b.tx.put(c.key, nil)
+}
+
+func (c *Cursor) current() (k, v []byte) {
+	if c.key == nil {
+		return nil, nil
+	}
+
+	if c.val[0] == tagValue {
+		v = c.val[1:]
+	}
+
+	return c.key[len(c.lo):], v
+}
+
+func (c *Cursor) seek(k []byte) {
+	if c.itr == nil || c.b.tx.closed {
+		c.key = nil
+		return
+	}
+
+	c.itr.Seek(k)
+	c.keys = c.b.tx.sortedKeys()
+	c.pos = sort.SearchStrings(c.keys, string(k))
+	c.settle()
+}
+
+func (c *Cursor) next() {
+	if c.key == nil || c.b.tx.closed {
+		c.key = nil
+		return
+	}
+
+	c.skip(c.key)
+	c.settle()
+}
+
+// Moves both the sources past the key
+func (c *Cursor) skip(k []byte) {
+	if c.itr.Valid() && bytes.Equal(c.itr.Key(), k) {
+		c.itr.Next()
+	}
+
+	if c.pos < len(c.keys) && c.keys[c.pos] == string(k) {
+		c.pos++
+	}
+}
+
+// Positions the cursor at the smaller key of the two sources, skipping
+// the keys deleted by the transaction
+func (c *Cursor) settle() {
+	tx := c.b.tx
+	for {
+		var key, val []byte
+		hasSnap, hasPending := c.itr.Valid(), c.pos < len(c.keys)
+		switch {
+		case !hasSnap && !hasPending:
+			c.key = nil
+			return
+		case hasSnap && (!hasPending || bytes.Compare(c.itr.Key(), []byte(c.keys[c.pos])) <= 0):
+			key = append([]byte(nil), c.itr.Key()...)
+			if v, ok := tx.pending[string(key)]; ok {
+				val = v
+			} else {
+				val = append([]byte(nil), c.itr.Value()...)
+			}
+		default:
+			key = []byte(c.keys[c.pos])
+			val = tx.pending[c.keys[c.pos]]
+		}
+
+		if c.hi != nil && bytes.Compare(key, c.hi) >= 0 {
+			c.key = nil
+			return
+		}
+
+		if val != nil {
+			c.key, c.val = key, val
+			return
+		}
+
+		c.skip(key)
+	}
+}
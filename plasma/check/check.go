@@ -0,0 +1,465 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package check runs random operations against a plasma store and an
+// in-memory model of it, and verifies that the two remain equivalent.
+//
+// Concurrent writers apply inserts, updates and deletes to disjoint sets of
+// keys in rounds. Between rounds the harness randomly creates snapshots,
+// recovery points, rolls back to a recovery point or reopens the store, and
+// checks the invariants:
+//
+//   - A writer reads back every mutation it made.
+//   - A snapshot has exactly the items of the model when it was created,
+//     regardless of the mutations made since.
+//   - A rollback yields exactly the items of the recovery point, and drops
+//     the recovery points newer than it.
+//   - A reopened store has the items and recovery points it was closed with.
+//
+// Model and Verify can be used on their own to test code built on plasma.
+package check
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/couchbase/nitro/plasma"
+)
+
+// Model is the expected state of a store. It is safe for concurrent use.
+type Model struct {
+	sync.RWMutex
+	items map[string][]byte
+}
+
+func NewModel() *Model {
+	return &Model{items: make(map[string][]byte)}
+}
+
+func (m *Model) Set(k, v []byte) {
+	m.Lock()
+	defer m.Unlock()
+	m.items[string(k)] = append([]byte(nil), v...)
+}
+
+func (m *Model) Delete(k []byte) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.items, string(k))
+}
+
+func (m *Model) Get(k []byte) ([]byte, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	v, ok := m.items[string(k)]
+	return v, ok
+}
+
+func (m *Model) Len() int {
+	m.RLock()
+	defer m.RUnlock()
+	return len(m.items)
+}
+
+// Copy of the model, which is not affected by later mutations
+func (m *Model) Clone() *Model {
+	m.RLock()
+	defer m.RUnlock()
+
+	c := NewModel()
+	for k, v := range m.items {
+		c.items[k] = v
+	}
+
+	return c
+}
+
+// Keys of the model in sorted order
+func (m *Model) Keys() []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	keys := make([]string, 0, len(m.items))
+	for k := range m.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Verify returns an error describing the first difference between the
+// items of the snapshot and the model
+func Verify(snap *plasma.Snapshot, m *Model) error {
+	itr := snap.NewIterator()
+	defer itr.Close()
+
+	keys := m.Keys()
+	i := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		k := string(itr.Key())
+		if i == len(keys) || k < keys[i] {
+			return fmt.Errorf("snapshot %d has unexpected key %q", snap.SeqNum(), k)
+		} else if k > keys[i] {
+			return fmt.Errorf("snapshot %d is missing key %q", snap.SeqNum(), keys[i])
+		}
+
+		if exp, _ := m.Get(itr.Key()); !bytes.Equal(itr.Value(), exp) {
+			return fmt.Errorf("snapshot %d has value %q for key %q, expected %q",
+				snap.SeqNum(), itr.Value(), k, exp)
+		}
+		i++
+	}
+
+	if err := itr.Err(); err != nil {
+		return fmt.Errorf("snapshot %d iteration failed: %v", snap.SeqNum(), err)
+	}
+
+	if i < len(keys) {
+		return fmt.Errorf("snapshot %d is missing key %q", snap.SeqNum(), keys[i])
+	}
+
+	return nil
+}
+
+type Config struct {
+	// Configuration of the store. File and InMemoryLSS are set by the
+	// harness.
+	Plasma plasma.Config
+	// Name of the in-memory log
+	Path string
+	Seed int64
+
+	NumOps     int
+	NumKeys    int
+	NumWriters int
+	// Percentage of the mutations which are deletes
+	DeletePercent int
+	// Mutations made by each writer in a round
+	RoundOps int
+	// Snapshots held open and verified after every round
+	MaxSnapshots int
+	// Percentage of the rounds followed by a recovery point, a rollback
+	// and a reopening of the store
+	RecoveryPointPercent int
+	RollbackPercent      int
+	ReopenPercent        int
+}
+
+func DefaultConfig() Config {
+	cfg := plasma.DefaultConfig()
+	cfg.FlushBufferSize = 64 * 1024
+
+	return Config{
+		Plasma:               cfg,
+		Path:                 "check",
+		Seed:                 1,
+		NumOps:               50000,
+		NumKeys:              1000,
+		NumWriters:           4,
+		DeletePercent:        20,
+		RoundOps:             100,
+		MaxSnapshots:         4,
+		RecoveryPointPercent: 20,
+		RollbackPercent:      10,
+		ReopenPercent:        5,
+	}
+}
+
+type Result struct {
+	Ops            int
+	Snapshots      int
+	RecoveryPoints int
+	Rollbacks      int
+	Reopens        int
+}
+
+// Snapshot along with the model at its creation
+type heldSnapshot struct {
+	snap  *plasma.Snapshot
+	model *Model
+}
+
+// Recovery point created by the harness, identified by its metadata
+type recoveryPoint struct {
+	id    int
+	model *Model
+}
+
+type harness struct {
+	cfg Config
+	rnd *rand.Rand
+	res Result
+
+	s     *plasma.Plasma
+	ws    []*plasma.Writer
+	model *Model
+	rnds  []*rand.Rand
+
+	snaps []heldSnapshot
+	rps   []recoveryPoint
+	rpID  int
+}
+
+func keyName(k int) []byte {
+	return []byte(fmt.Sprintf("key-%10d", k))
+}
+
+// Run the operations and verify the invariants after every round
+func Run(cfg Config) (Result, error) {
+	if cfg.NumWriters <= 0 || cfg.RoundOps <= 0 || cfg.NumKeys < cfg.NumWriters {
+		return Result{}, fmt.Errorf("NumWriters (%d) and RoundOps (%d) should be positive, with at least a key per writer (%d)",
+			cfg.NumWriters, cfg.RoundOps, cfg.NumKeys)
+	}
+
+	h := &harness{
+		cfg:   cfg,
+		rnd:   rand.New(rand.NewSource(cfg.Seed)),
+		model: NewModel(),
+	}
+
+	for i := 0; i < cfg.NumWriters; i++ {
+		h.rnds = append(h.rnds, rand.New(rand.NewSource(cfg.Seed+int64(i)+1)))
+	}
+
+	plasma.RemoveInMemoryLSS(cfg.Path)
+	defer plasma.RemoveInMemoryLSS(cfg.Path)
+
+	if err := h.open(); err != nil {
+		return h.res, err
+	}
+
+	err := h.run()
+	h.closeSnapshots()
+	if h.s != nil {
+		h.s.Close()
+	}
+	return h.res, err
+}
+
+func (h *harness) open() error {
+	cfg := h.cfg.Plasma
+	cfg.File = h.cfg.Path
+	cfg.InMemoryLSS = true
+
+	s, err := plasma.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	h.s, h.ws = s, nil
+	for i := 0; i < h.cfg.NumWriters; i++ {
+		h.ws = append(h.ws, s.NewWriter())
+	}
+
+	return nil
+}
+
+func (h *harness) run() error {
+	for h.res.Ops < h.cfg.NumOps {
+		if err := h.round(); err != nil {
+			return err
+		}
+
+		for _, hs := range h.snaps {
+			if err := Verify(hs.snap, hs.model); err != nil {
+				return err
+			}
+		}
+
+		if err := h.snapshot(); err != nil {
+			return err
+		}
+
+		r := h.rnd.Intn(100)
+		var err error
+		switch {
+		case r < h.cfg.RecoveryPointPercent:
+			err = h.recoveryPoint()
+		case r < h.cfg.RecoveryPointPercent+h.cfg.RollbackPercent:
+			err = h.rollback()
+		case r < h.cfg.RecoveryPointPercent+h.cfg.RollbackPercent+h.cfg.ReopenPercent:
+			err = h.reopen()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Writers mutate the keys k, for which k % NumWriters is the writer id, so
+// that the model is updated in the same order as the store
+func (h *harness) round() error {
+	n := h.cfg.RoundOps
+	if rem := (h.cfg.NumOps - h.res.Ops + h.cfg.NumWriters - 1) / h.cfg.NumWriters; rem < n {
+		n = rem
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, h.cfg.NumWriters)
+	for id := range h.ws {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			errs[id] = h.mutate(id, n)
+		}(id)
+	}
+	wg.Wait()
+	h.res.Ops += n * h.cfg.NumWriters
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *harness) mutate(id int, n int) error {
+	w, rnd := h.ws[id], h.rnds[id]
+	keys := (h.cfg.NumKeys - id + h.cfg.NumWriters - 1) / h.cfg.NumWriters
+	for i := 0; i < n; i++ {
+		k := keyName(rnd.Intn(keys)*h.cfg.NumWriters + id)
+		_, exists := h.model.Get(k)
+
+		// An update deletes the previous version before inserting the
+		// new one
+		if exists {
+			if _, err := w.DeleteKV(k); err != nil {
+				return err
+			}
+			h.model.Delete(k)
+		}
+
+		if !exists || rnd.Intn(100) >= h.cfg.DeletePercent {
+			v := []byte(strconv.Itoa(rnd.Int()))
+			if _, err := w.InsertKV(k, v); err != nil {
+				return err
+			}
+			h.model.Set(k, v)
+		}
+
+		exp, ok := h.model.Get(k)
+		if v, err := w.LookupKV(k); ok && (err != nil || !bytes.Equal(v, exp)) {
+			return fmt.Errorf("lookup of key %q returned %q, %v, expected %q", k, v, err, exp)
+		} else if !ok && err != plasma.ErrItemNotFound {
+			return fmt.Errorf("lookup of deleted key %q returned %q, %v", k, v, err)
+		}
+	}
+
+	return nil
+}
+
+func (h *harness) snapshot() error {
+	snap := h.s.NewSnapshot()
+	model := h.model.Clone()
+	h.res.Snapshots++
+	if err := Verify(snap, model); err != nil {
+		snap.Close()
+		return err
+	}
+
+	h.snaps = append(h.snaps, heldSnapshot{snap: snap, model: model})
+	if len(h.snaps) > h.cfg.MaxSnapshots {
+		h.snaps[0].snap.Close()
+		h.snaps = h.snaps[1:]
+	}
+
+	return nil
+}
+
+func (h *harness) closeSnapshots() {
+	for _, hs := range h.snaps {
+		hs.snap.Close()
+	}
+	h.snaps = nil
+}
+
+func (h *harness) recoveryPoint() error {
+	h.rpID++
+	snap := h.s.NewSnapshot()
+	if err := h.s.CreateRecoveryPoint(snap, []byte(strconv.Itoa(h.rpID))); err != nil {
+		return fmt.Errorf("recovery point %d failed: %v", h.rpID, err)
+	}
+
+	h.rps = append(h.rps, recoveryPoint{id: h.rpID, model: h.model.Clone()})
+	h.res.RecoveryPoints++
+	return h.checkRecoveryPoints()
+}
+
+// Recovery points of the store should be the ones created by the harness
+func (h *harness) checkRecoveryPoints() error {
+	rps := h.s.GetRecoveryPoints()
+	if len(rps) != len(h.rps) {
+		return fmt.Errorf("store has %d recovery points, expected %d", len(rps), len(h.rps))
+	}
+
+	for i, rp := range rps {
+		if id := string(rp.Meta()); id != strconv.Itoa(h.rps[i].id) {
+			return fmt.Errorf("recovery point %s found in place of %d", id, h.rps[i].id)
+		}
+	}
+
+	return nil
+}
+
+func (h *harness) rollback() error {
+	if len(h.rps) == 0 {
+		return nil
+	}
+
+	h.closeSnapshots()
+	i := h.rnd.Intn(len(h.rps))
+	rp := h.s.GetRecoveryPoints()[i]
+	snap, err := h.s.Rollback(rp)
+	if err != nil {
+		return fmt.Errorf("rollback to recovery point %d failed: %v", h.rps[i].id, err)
+	}
+	defer snap.Close()
+
+	h.model = h.rps[i].model.Clone()
+	h.rps = h.rps[:i+1]
+	h.res.Rollbacks++
+	if err := Verify(snap, h.model); err != nil {
+		return fmt.Errorf("rollback to recovery point %d: %v", h.rps[i].id, err)
+	}
+
+	return h.checkRecoveryPoints()
+}
+
+func (h *harness) reopen() error {
+	h.closeSnapshots()
+	if err := h.s.PersistAllContext(context.Background()); err != nil {
+		return err
+	}
+	h.s.Close()
+	h.s = nil
+
+	if err := h.open(); err != nil {
+		return fmt.Errorf("recovery failed: %v", err)
+	}
+
+	h.res.Reopens++
+	snap := h.s.NewSnapshot()
+	defer snap.Close()
+	if err := Verify(snap, h.model); err != nil {
+		return fmt.Errorf("after recovery: %v", err)
+	}
+
+	return h.checkRecoveryPoints()
+}
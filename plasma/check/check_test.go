@@ -0,0 +1,59 @@
+package check
+
+import (
+	"testing"
+
+	"github.com/couchbase/nitro/plasma"
+)
+
+func TestModelCheck(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.NumOps = 20000
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Ops < cfg.NumOps || res.RecoveryPoints == 0 || res.Rollbacks == 0 || res.Reopens == 0 {
+		t.Errorf("expected every kind of operation to be run, got %+v", res)
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	cfg := plasma.DefaultConfig()
+	cfg.File = "check-verify"
+	cfg.InMemoryLSS = true
+	plasma.RemoveInMemoryLSS(cfg.File)
+	defer plasma.RemoveInMemoryLSS(cfg.File)
+
+	s, err := plasma.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	m := NewModel()
+	w := s.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.InsertKV(keyName(i), []byte("val"))
+		m.Set(keyName(i), []byte("val"))
+	}
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+	if err := Verify(snap, m); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mutate := range []func(m *Model){
+		func(m *Model) { m.Delete(keyName(10)) },
+		func(m *Model) { m.Set(keyName(100), []byte("val")) },
+		func(m *Model) { m.Set(keyName(50), []byte("newval")) },
+	} {
+		c := m.Clone()
+		mutate(c)
+		if err := Verify(snap, c); err == nil {
+			t.Errorf("expected a mismatch to be reported")
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package plasma
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"github.com/couchbase/nitro/skiplist"
+)
+
+var ErrCheckpointNotSupported = errors.New("checkpoints require a store on disk")
+var errCheckpointCorrupt = errors.New("mapping checkpoint is corrupt")
+var errCheckpointInconsistent = errors.New("mapping checkpoint pages do not cover the key space")
+
+const (
+	checkpointFileName = "checkpoint.data"
+	// Version 2 adds the id of the last operation marker
+	checkpointVersion = 2
+)
+
+// Mapping of a page as of its latest block in the log. Keys are kept in
+// their encoded form.
+type checkpointPage struct {
+	low, hiItm []byte
+
+	state       pageState
+	chainLen    uint16
+	numItems    uint16
+	offset      LSSOffset
+	numSegments int
+	flushDataSz int64
+}
+
+// Page mapping table as reconstructed by recovery from the log up to offset.
+// It is maintained by replaying the log in the same way as recovery does,
+// so that it is consistent with the log regardless of concurrent writers.
+type mappingCheckpoint struct {
+	offset      LSSOffset
+	flushDataSz int64
+	rps         []byte
+	maxSn       []byte
+	ops         *opReplayState
+	pages       map[string]*checkpointPage
+}
+
+type checkpointState struct {
+	sync.Mutex
+	cp      *mappingCheckpoint
+	written LSSOffset
+}
+
+func newMappingCheckpoint(offset LSSOffset) *mappingCheckpoint {
+	return &mappingCheckpoint{
+		offset: offset,
+		ops:    newOpReplayState(),
+		pages:  make(map[string]*checkpointPage),
+	}
+}
+
+func decodePageHeader(pg *page, data []byte) (*checkpointPage, error) {
+	v16, roffset, err := readUint16(data, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	cpg := &checkpointPage{state: pageState(v16)}
+	cpg.state.SetFlushed()
+
+	start := roffset
+	if _, roffset, err = pg.unmarshalIndexKey(data, roffset); err != nil {
+		return nil, err
+	}
+	cpg.low = append([]byte(nil), data[start:roffset]...)
+
+	if cpg.chainLen, roffset, err = readUint16(data, roffset); err != nil {
+		return nil, err
+	}
+
+	if cpg.numItems, roffset, err = readUint16(data, roffset); err != nil {
+		return nil, err
+	}
+
+	start = roffset
+	if _, roffset, err = pg.unmarshalIndexKey(data, roffset); err != nil {
+		return nil, err
+	}
+	cpg.hiItm = append([]byte(nil), data[start:roffset]...)
+
+	return cpg, nil
+}
+
+// Apply a log block in the same way as doRecovery
+func (cp *mappingCheckpoint) apply(pg *page, offset LSSOffset, typ lssBlockType, bs []byte) error {
+	if skip, err := cp.ops.visit(typ, bs); skip || err != nil {
+		return err
+	}
+
+	switch typ {
+	case lssRecoveryPoints:
+		cp.rps = append([]byte(nil), bs...)
+	case lssMaxSn:
+		cp.maxSn = append([]byte(nil), bs...)
+	case lssPageRemove:
+		l, roffset, err := readUint16(bs, 0)
+		if err != nil {
+			return err
+		}
+
+		if int(l) > len(bs)-roffset {
+			return ErrCorruptPageData
+		}
+
+		key := string(append([]byte{itemKeyEncoded}, bs[roffset:roffset+int(l)]...))
+		if cpg, ok := cp.pages[key]; ok {
+			cp.flushDataSz -= cpg.flushDataSz
+			delete(cp.pages, key)
+		}
+	case lssPageData, lssPageReloc, lssPageUpdate:
+		cpg, err := decodePageHeader(pg, bs)
+		if err != nil {
+			return err
+		}
+
+		cpg.offset = offset
+		cpg.numSegments = 1
+		cpg.flushDataSz = int64(len(bs))
+
+		curr, ok := cp.pages[string(cpg.low)]
+		if typ == lssPageUpdate {
+			if !ok {
+				return nil
+			}
+
+			cpg.numSegments = curr.numSegments + 1
+			cpg.flushDataSz += curr.flushDataSz
+		} else if ok {
+			cp.flushDataSz -= curr.flushDataSz
+		}
+
+		cp.flushDataSz += int64(len(bs))
+		cp.pages[string(cpg.low)] = cpg
+	}
+
+	return nil
+}
+
+// Pages ordered by their low key. An error is returned if they do not
+// cover the key space without gaps.
+func (cp *mappingCheckpoint) sortedPages(pg *page) ([]*checkpointPage, error) {
+	pages := make([]*checkpointPage, 0, len(cp.pages))
+	lows := make(map[*checkpointPage]unsafe.Pointer, len(cp.pages))
+	for _, cpg := range cp.pages {
+		low, _, err := pg.unmarshalIndexKey(cpg.low, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		pages = append(pages, cpg)
+		lows[cpg] = low
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pg.cmp(lows[pages[i]], lows[pages[j]]) < 0
+	})
+
+	if len(pages) == 0 {
+		return pages, nil
+	}
+
+	if lows[pages[0]] != skiplist.MinItem {
+		return nil, errCheckpointInconsistent
+	}
+
+	for i, cpg := range pages {
+		hiItm, _, err := pg.unmarshalIndexKey(cpg.hiItm, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == len(pages)-1 {
+			if hiItm != skiplist.MaxItem {
+				return nil, errCheckpointInconsistent
+			}
+		} else if pg.cmp(hiItm, lows[pages[i+1]]) != 0 {
+			return nil, errCheckpointInconsistent
+		}
+	}
+
+	return pages, nil
+}
+
+func appendCheckpointField(buf, bs []byte) []byte {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(bs)))
+	buf = append(buf, l[:]...)
+	return append(buf, bs...)
+}
+
+func marshalCheckpoint(cp *mappingCheckpoint, pages []*checkpointPage, uuid []byte) []byte {
+	var b [8]byte
+	u32 := func(buf []byte, v uint32) []byte {
+		binary.BigEndian.PutUint32(b[:4], v)
+		return append(buf, b[:4]...)
+	}
+
+	u64 := func(buf []byte, v uint64) []byte {
+		binary.BigEndian.PutUint64(b[:], v)
+		return append(buf, b[:]...)
+	}
+
+	buf := u32(nil, checkpointVersion)
+	buf = appendCheckpointField(buf, uuid)
+	buf = u64(buf, uint64(cp.offset))
+	buf = u64(buf, uint64(cp.flushDataSz))
+	buf = appendCheckpointField(buf, cp.rps)
+	buf = appendCheckpointField(buf, cp.maxSn)
+	buf = u64(buf, cp.ops.maxId)
+	buf = u32(buf, uint32(len(pages)))
+	for _, cpg := range pages {
+		buf = u32(buf, uint32(cpg.state))
+		buf = u32(buf, uint32(cpg.chainLen)<<16|uint32(cpg.numItems))
+		buf = u64(buf, uint64(cpg.offset))
+		buf = u32(buf, uint32(cpg.numSegments))
+		buf = u64(buf, uint64(cpg.flushDataSz))
+		buf = appendCheckpointField(buf, cpg.low)
+		buf = appendCheckpointField(buf, cpg.hiItm)
+	}
+
+	return u32(buf, crc32.ChecksumIEEE(buf))
+}
+
+func unmarshalCheckpoint(buf []byte) (cp *mappingCheckpoint, uuid []byte, err error) {
+	if len(buf) < 4 || crc32.ChecksumIEEE(buf[:len(buf)-4]) != binary.BigEndian.Uint32(buf[len(buf)-4:]) {
+		return nil, nil, errCheckpointCorrupt
+	}
+	buf = buf[:len(buf)-4]
+
+	// Reads past the end leave the buffer empty and are detected at the end
+	short := false
+	next := func(n int) []byte {
+		if n > len(buf) {
+			short = true
+			buf = nil
+			return make([]byte, n)
+		}
+
+		bs := buf[:n]
+		buf = buf[n:]
+		return bs
+	}
+
+	u32 := func() uint32 { return binary.BigEndian.Uint32(next(4)) }
+	u64 := func() uint64 { return binary.BigEndian.Uint64(next(8)) }
+	field := func() []byte {
+		l := int(u32())
+		if l == 0 {
+			return nil
+		}
+		return append([]byte(nil), next(l)...)
+	}
+
+	version := u32()
+	if version < 1 || version > checkpointVersion {
+		return nil, nil, errCheckpointCorrupt
+	}
+
+	uuid = field()
+	cp = newMappingCheckpoint(LSSOffset(u64()))
+	cp.flushDataSz = int64(u64())
+	cp.rps = field()
+	cp.maxSn = field()
+	if version >= 2 {
+		cp.ops.maxId = u64()
+	}
+	n := int(u32())
+	for i := 0; i < n && !short; i++ {
+		cpg := &checkpointPage{state: pageState(u32())}
+		v := u32()
+		cpg.chainLen, cpg.numItems = uint16(v>>16), uint16(v)
+		cpg.offset = LSSOffset(u64())
+		cpg.numSegments = int(u32())
+		cpg.flushDataSz = int64(u64())
+		cpg.low = field()
+		cpg.hiItm = field()
+		cp.pages[string(cpg.low)] = cpg
+	}
+
+	if short || len(buf) != 0 || len(cp.pages) != n {
+		return nil, nil, errCheckpointCorrupt
+	}
+
+	return cp, uuid, nil
+}
+
+func (s *Plasma) checkpointFile() string {
+	return filepath.Join(s.File, checkpointFileName)
+}
+
+func (s *Plasma) writeCheckpoint(cp *mappingCheckpoint) error {
+	pg := newPage2(nil, nil, nil, s.gCtx.storeCtx, nil).(*page)
+	pages, err := cp.sortedPages(pg)
+	if err != nil {
+		return err
+	}
+
+	uuid, _ := s.getStoreMeta(storeMetaUUID)
+	buf := marshalCheckpoint(cp, pages, uuid)
+
+	tmpFile := s.checkpointFile() + ".tmp"
+	fd, err := os.OpenFile(tmpFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+
+	if _, err = fd.Write(buf); err == nil {
+		err = fd.Sync()
+	}
+
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	return os.Rename(tmpFile, s.checkpointFile())
+}
+
+// Replay the log written after the checkpoint up to end
+func (s *Plasma) replayCheckpoint(cp *mappingCheckpoint, end LSSOffset) error {
+	pg := newPage2(nil, nil, nil, s.gCtx.storeCtx, nil).(*page)
+	fn := func(offset LSSOffset, bs []byte) (bool, error) {
+		typ := getLSSBlockType(bs)
+		return true, cp.apply(pg, offset, typ, bs[lssBlockTypeSize:])
+	}
+
+	buf := newBuffer(initBufferSize)
+	if err := s.lss.(*lsStore).visitor(int64(cp.offset), int64(end), fn, buf); err != nil {
+		return err
+	}
+
+	cp.offset = end
+	return nil
+}
+
+// Write a checkpoint of the page mapping table. Recovery loads the
+// checkpoint as swapped out pages and only replays the log written after
+// it. The checkpoint is brought up to date by reading the log written
+// since the previous one.
+func (s *Plasma) Checkpoint() error {
+	if !s.shouldPersist || s.InMemoryLSS {
+		return ErrCheckpointNotSupported
+	}
+
+	s.ckpt.Lock()
+	defer s.ckpt.Unlock()
+
+	s.lss.Sync(true)
+	head, tail := s.lss.HeadOffset(), s.lss.TailOffset()
+
+	// The log before the checkpoint may have been cleaned, along with
+	// blocks which were not relocated, such as page removals
+	cp := s.ckpt.cp
+	if cp == nil || cp.offset < head {
+		cp = newMappingCheckpoint(head)
+	}
+
+	s.ckpt.cp = nil
+	if err := s.replayCheckpoint(cp, tail); err != nil {
+		return err
+	}
+	s.ckpt.cp = cp
+
+	if cp.offset == s.ckpt.written {
+		return nil
+	}
+
+	if err := s.writeCheckpoint(cp); err != nil {
+		return err
+	}
+
+	s.ckpt.written = cp.offset
+	return nil
+}
+
+// Load the checkpoint if it can be used with the log. It is ignored if
+// the log has been cleaned past it or was not committed up to it.
+func (s *Plasma) loadCheckpoint() *mappingCheckpoint {
+	if s.InMemoryLSS || s.RepairOnRecovery {
+		return nil
+	}
+
+	buf, err := ioutil.ReadFile(s.checkpointFile())
+	if err != nil {
+		return nil
+	}
+
+	cp, uuid, err := unmarshalCheckpoint(buf)
+	if err != nil {
+		fmt.Printf("Plasma: Ignoring mapping checkpoint (err=%v)\n", err)
+		return nil
+	}
+
+	if u, _ := s.getStoreMeta(storeMetaUUID); string(u) != string(uuid) ||
+		cp.offset < s.lss.HeadOffset() || cp.offset > s.lss.TailOffset() {
+		return nil
+	}
+
+	return cp
+}
+
+// Map the pages of the checkpoint as swapped out pages
+func (s *Plasma) restoreCheckpoint(cp *mappingCheckpoint) error {
+	pg := newPage(s.gCtx, nil, nil).(*page)
+	pages, err := cp.sortedPages(pg)
+	if err != nil {
+		return err
+	}
+
+	if cp.rps != nil {
+		var rps []*RecoveryPoint
+		s.rpVersion, rps = unmarshalRPs(cp.rps)
+		s.recoveryPoints = setRPsLogOffset(nil, rps, s.lss.HeadOffset())
+	}
+
+	if cp.maxSn != nil {
+		s.currSn = decodeMaxSn(cp.maxSn)
+	}
+
+	s.gCtx.sts.FlushDataSz += cp.flushDataSz
+	for _, cpg := range pages {
+		low, _, _ := pg.unmarshalIndexKey(cpg.low, 0)
+		hiItm, _, _ := pg.unmarshalIndexKey(cpg.hiItm, 0)
+
+		sod := pg.allocSwapoutDelta(hiItm)
+		sod.op = opSwapoutDelta
+		sod.chainLen = cpg.chainLen
+		sod.numItems = cpg.numItems
+		sod.state = cpg.state
+		sod.state.SetEvicted(true)
+		sod.offset = cpg.offset
+		sod.numSegments = int32(cpg.numSegments)
+		sod.next = nil
+		sod.rightSibling = nil
+		sod.leftSibling = nil
+
+		pg.low = low
+		pg.head = (*pageDelta)(unsafe.Pointer(sod))
+		pid := s.AllocPageId(s.gCtx)
+		s.CreateMapping(pid, pg, s.gCtx)
+		s.indexPage(pid, s.gCtx)
+		pg.Reset()
+	}
+
+	_, _, _, _, memUsed := pg.GetAllocOps()
+	s.gCtx.sts.AllocSz += int64(memUsed)
+	return nil
+}
+
+func (s *Plasma) checkpointDaemon(ws *workerState) {
+	for {
+		ws.beat()
+		ws.wait(s.CheckpointInterval)
+		select {
+		case <-s.stopmon:
+			return
+		case <-s.Clock.After(s.CheckpointInterval):
+		}
+
+		ws.doing("checkpointing")
+		if err := s.Checkpoint(); err != nil {
+			fmt.Printf("Plasma: Mapping checkpoint failed (err=%v)\n", err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package plasma
+
+import (
+	"fmt"
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+	"time"
+)
+
+func verifyCheckpointItems(t *testing.T, s *Plasma, start, end int) {
+	w := s.NewWriter()
+	for i := 0; i < end; i++ {
+		itm := skiplist.NewIntKeyItem(i)
+		got, _ := w.Lookup(itm)
+		if i < start && got != nil {
+			t.Fatalf("expected item %d to be deleted", i)
+		} else if i >= start && (got == nil || skiplist.CompareInt(itm, got) != 0) {
+			t.Fatalf("expected item %d to be found", i)
+		}
+	}
+
+	count := 0
+	itr := s.NewIterator()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		count++
+	}
+
+	if count != end-start {
+		t.Errorf("expected %d items, got %d", end-start, count)
+	}
+}
+
+func TestPlasmaCheckpoint(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+
+	s.PersistAll()
+	if err := s.Checkpoint(); err != nil {
+		t.Fatalf("checkpoint failed %v", err)
+	}
+	offset := s.ckpt.written
+
+	// Merges and splits after the checkpoint are replayed from the log
+	for i := 0; i < 50000; i++ {
+		w.Delete(skiplist.NewIntKeyItem(i))
+	}
+
+	for i := 100000; i < 150000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	s.Close()
+
+	s = newTestIntPlasmaStore(testCfg)
+	if s.ckpt.written != offset || s.ckpt.cp == nil || s.ckpt.cp.offset <= offset {
+		t.Errorf("expected recovery from the checkpoint at %d", offset)
+	}
+	verifyCheckpointItems(t, s, 50000, 150000)
+
+	if err := s.Checkpoint(); err != nil {
+		t.Fatalf("checkpoint failed %v", err)
+	}
+	s.Close()
+
+	// Recovery from the log alone
+	fd, _ := os.OpenFile(s.checkpointFile(), os.O_RDWR, 0755)
+	fd.Truncate(100)
+	fd.Close()
+
+	s = newTestIntPlasmaStore(testCfg)
+	if s.ckpt.cp != nil {
+		t.Errorf("expected the corrupt checkpoint to be ignored")
+	}
+	verifyCheckpointItems(t, s, 50000, 150000)
+	s.Close()
+}
+
+func TestPlasmaCheckpointInterval(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testCfg
+	cfg.CheckpointInterval = time.Hour
+	s := newTestIntPlasmaStore(cfg)
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	s.Close()
+
+	// Pages are only read from the log when they are accessed
+	s = newTestIntPlasmaStore(cfg)
+	sts := s.GetStats()
+	if s.ckpt.cp == nil || s.ckpt.written != s.ckpt.cp.offset || sts.NumLSSReads != 0 {
+		t.Errorf("expected recovery from the checkpoint at the end of the log, %d reads", sts.NumLSSReads)
+	}
+	verifyCheckpointItems(t, s, 0, 100000)
+	s.Close()
+
+	cfg.InMemoryLSS = true
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+	if err := s.Checkpoint(); err != ErrCheckpointNotSupported {
+		t.Errorf("expected checkpoint not supported error, got %v", err)
+	}
+}
+
+func TestPlasmaCheckpointRecoveryPoints(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.CheckpointInterval = time.Hour
+	s := newTestIntPlasmaStore(cfg)
+	w := s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), nil)
+		if i%1000 == 999 {
+			snap := s.NewSnapshot()
+			snap.Open()
+			s.CreateRecoveryPoint(snap, []byte(fmt.Sprint(i+1)))
+			snap.Close()
+		}
+	}
+	sn := s.currSn
+	s.Close()
+
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+	rps := s.GetRecoveryPoints()
+	if s.ckpt.cp == nil || len(rps) != 10 || s.currSn < sn {
+		t.Fatalf("expected recovery points and sn from the checkpoint, got %d, sn %d", len(rps), s.currSn)
+	}
+
+	snap, err := s.Rollback(rps[4])
+	if err != nil {
+		t.Fatalf("rollback failed %v", err)
+	}
+	defer snap.Close()
+
+	count := 0
+	itr := snap.NewIterator()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		count++
+	}
+	itr.Close()
+
+	if count != 5000 {
+		t.Errorf("expected 5000 items after rollback, got %d", count)
+	}
+}
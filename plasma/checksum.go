@@ -0,0 +1,92 @@
+package plasma
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"unsafe"
+)
+
+var ErrItemChecksum = errors.New("item checksum does not match its contents")
+
+const itmChecksumSize = 4
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Implemented by the codecs which can detect corrupt items. Items read
+// through the key value APIs are verified before they are returned.
+type ItemVerifier interface {
+	VerifyItem(itm unsafe.Pointer) bool
+}
+
+// ChecksumItemCodec stores a crc32c checksum of the key and value in front
+// of the value of the items of c. The codec c should support variable
+// length values.
+func ChecksumItemCodec(c ItemCodec) ItemCodec {
+	return checksumItemCodec{ItemCodec: c}
+}
+
+type checksumItemCodec struct {
+	ItemCodec
+}
+
+func itemChecksum(k, v []byte) uint32 {
+	return crc32.Update(crc32.Checksum(k, crc32cTable), crc32cTable, v)
+}
+
+func (c checksumItemCodec) Id() string {
+	return c.ItemCodec.Id() + "+crc32c"
+}
+
+func (c checksumItemCodec) NewItem(k, v []byte, sn uint64, meta *uint64, del bool, buf *Buffer) (unsafe.Pointer, error) {
+	cv := make([]byte, itmChecksumSize+len(v))
+	binary.BigEndian.PutUint32(cv, itemChecksum(k, v))
+	copy(cv[itmChecksumSize:], v)
+	return c.ItemCodec.NewItem(k, cv, sn, meta, del, buf)
+}
+
+func (c checksumItemCodec) checksumValue(itm unsafe.Pointer) []byte {
+	if !c.ItemCodec.HasValue(itm) {
+		return nil
+	}
+
+	return c.ItemCodec.Value(itm)
+}
+
+func (c checksumItemCodec) Value(itm unsafe.Pointer) []byte {
+	if cv := c.checksumValue(itm); len(cv) > itmChecksumSize {
+		return cv[itmChecksumSize:]
+	}
+
+	return nil
+}
+
+func (c checksumItemCodec) HasValue(itm unsafe.Pointer) bool {
+	return len(c.checksumValue(itm)) > itmChecksumSize
+}
+
+func (c checksumItemCodec) VerifyItem(itm unsafe.Pointer) bool {
+	cv := c.checksumValue(itm)
+	if len(cv) < itmChecksumSize {
+		return false
+	}
+
+	return binary.BigEndian.Uint32(cv) == itemChecksum(c.Key(itm), cv[itmChecksumSize:])
+}
+
+// Returns ErrCorruptValue if the value of itm cannot be decoded and
+// ErrItemChecksum if the codec detects that itm is corrupt
+func (s *Plasma) verifyItem(itm unsafe.Pointer, ctx *wCtx) error {
+	if dc, ok := s.dictCodec(); ok {
+		if err := dc.checkValue(itm); err != nil {
+			return err
+		}
+	}
+
+	if v, ok := s.ItemCodec.(ItemVerifier); ok && !v.VerifyItem(itm) {
+		ctx.sts.ChecksumMismatches++
+		return ErrItemChecksum
+	}
+
+	return nil
+}
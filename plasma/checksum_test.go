@@ -0,0 +1,87 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPlasmaItemChecksums(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.ItemChecksums = true
+	s := newTestIntPlasmaStore(cfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", i)))
+	}
+	w.InsertKV([]byte("novalue"), nil)
+	w.DeleteKV([]byte(fmt.Sprintf("key-%10d", 0)))
+
+	if _, err := w.LookupKV([]byte("novalue")); err != ErrItemNoValue {
+		t.Errorf("expected no value error, got %v", err)
+	}
+
+	if _, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", 0))); err != ErrItemNotFound {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	snap := s.NewSnapshot()
+	s.PersistAll()
+	snap.Close()
+	s.Close()
+
+	cfg2 := cfg
+	cfg2.ItemChecksums = false
+	if _, err := New(cfg2); err != ErrItemCodecMismatch {
+		t.Fatalf("expected codec mismatch error, got %v", err)
+	}
+
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+	w = s.NewWriter()
+
+	for i := 1; i < 1000; i++ {
+		exp := fmt.Sprintf("val-%d", i)
+		if v, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i))); err != nil || string(v) != exp {
+			t.Fatalf("expected %s, got %s %v", exp, v, err)
+		}
+	}
+
+	// Corrupt the value of an item in memory
+	k := []byte(fmt.Sprintf("key-%10d", 500))
+	itm, _ := s.ItemCodec.NewItem(k, nil, 0, nil, false, nil)
+	pitr := s.NewIterator().(*Iterator)
+	pitr.Seek(itm)
+	DefaultItemCodec.Value(pitr.Get())[itmChecksumSize] ^= 0xff
+	pitr.Close()
+
+	if _, err := w.LookupKV(k); err != ErrItemChecksum {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+
+	if _, err := s.VersionsOf(k); err != ErrItemChecksum {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+
+	snap = s.NewSnapshot()
+	defer snap.Close()
+	itr := snap.NewIterator()
+	defer itr.Close()
+
+	n := 0
+	for itr.SeekFirst(); itr.Valid() && itr.Err() == nil; itr.Next() {
+		if itr.Value() != nil {
+			n++
+		}
+	}
+
+	if itr.Err() != ErrItemChecksum || n != 499 {
+		t.Errorf("expected checksum error after 499 items, got %v after %d", itr.Err(), n)
+	}
+
+	if sts := s.GetStats(); sts.ChecksumMismatches != 3 {
+		t.Errorf("expected 3 checksum mismatches, got %d", sts.ChecksumMismatches)
+	}
+}
@@ -0,0 +1,161 @@
+package plasma
+
+import (
+	"hash/fnv"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for the background activity of the store,
+// such as worker sleeps, sync intervals and sweep timers. Tests can use a
+// VirtualClock to make the timing of the background work deterministic.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+// Clock backed by the time package
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type virtualTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// VirtualClock only moves forward when it is advanced. Sleeps and timers
+// expire once the clock has been advanced past their deadline.
+type VirtualClock struct {
+	sync.Mutex
+	now    time.Time
+	timers []*virtualTimer
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	t := &virtualTimer{at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+	} else {
+		c.timers = append(c.timers, t)
+	}
+
+	return t.ch
+}
+
+// Move the clock forward by d and fire the expired timers in the order of
+// their deadlines
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].at.Before(c.timers[j].at)
+	})
+
+	n := 0
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			break
+		}
+		t.ch <- t.at
+		n++
+	}
+
+	c.timers = append([]*virtualTimer(nil), c.timers[n:]...)
+}
+
+// Number of sleeps and timers which have not expired
+func (c *VirtualClock) Waiters() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.timers)
+}
+
+// Scheduler is called by the background workers before every unit of work
+// with the name of the worker. It can delay the worker to explore
+// different interleavings of the background work.
+type Scheduler interface {
+	Schedule(worker string)
+}
+
+// Scheduler which yields the processor a pseudo random number of times
+// before every unit of work. The yields of a worker only depend on the seed
+// and the number of units of work done by it, hence a seed reproduces the
+// perturbation of the schedule.
+type SeededScheduler struct {
+	seed      int64
+	maxYields int
+
+	sync.Mutex
+	steps map[string]uint64
+}
+
+func NewSeededScheduler(seed int64, maxYields int) *SeededScheduler {
+	return &SeededScheduler{
+		seed:      seed,
+		maxYields: maxYields,
+		steps:     make(map[string]uint64),
+	}
+}
+
+func (s *SeededScheduler) yields(worker string) int {
+	s.Lock()
+	step := s.steps[worker]
+	s.steps[worker]++
+	s.Unlock()
+
+	if s.maxYields <= 0 {
+		return 0
+	}
+
+	var buf [16]byte
+	for i := 0; i < 8; i++ {
+		buf[i] = byte(uint64(s.seed) >> (8 * uint(i)))
+		buf[8+i] = byte(step >> (8 * uint(i)))
+	}
+
+	h := fnv.New64a()
+	h.Write(buf[:])
+	h.Write([]byte(worker))
+	return int(h.Sum64() % uint64(s.maxYields+1))
+}
+
+func (s *SeededScheduler) Schedule(worker string) {
+	for i := s.yields(worker); i > 0; i-- {
+		runtime.Gosched()
+	}
+}
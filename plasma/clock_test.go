@@ -0,0 +1,108 @@
+package plasma
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestVirtualClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewVirtualClock(start)
+
+	ch1 := c.After(time.Second * 2)
+	ch2 := c.After(time.Second)
+	done := make(chan struct{})
+	go func() {
+		c.Sleep(time.Second * 3)
+		close(done)
+	}()
+
+	for c.Waiters() != 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	c.Advance(time.Second)
+	if at := <-ch2; !at.Equal(start.Add(time.Second)) {
+		t.Errorf("unexpected timer expiry %v", at)
+	}
+
+	select {
+	case <-ch1:
+		t.Errorf("expected timer to wait for the clock")
+	case <-done:
+		t.Errorf("expected sleep to wait for the clock")
+	default:
+	}
+
+	c.Advance(time.Second * 5)
+	<-ch1
+	<-done
+
+	if c.Waiters() != 0 || !c.Now().Equal(start.Add(time.Second*6)) {
+		t.Errorf("unexpected clock state %v, %d waiters", c.Now(), c.Waiters())
+	}
+}
+
+func TestSeededScheduler(t *testing.T) {
+	yields := func(seed int64) []int {
+		s := NewSeededScheduler(seed, 10)
+		var ys []int
+		for i := 0; i < 100; i++ {
+			ys = append(ys, s.yields(fmt.Sprintf("worker-%d", i%3)))
+		}
+		return ys
+	}
+
+	a, b, c := yields(1), yields(1), yields(2)
+	if fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Errorf("expected the same yields for a seed")
+	}
+
+	if fmt.Sprint(a) == fmt.Sprint(c) {
+		t.Errorf("expected different yields for different seeds")
+	}
+}
+
+func TestPlasmaVirtualClock(t *testing.T) {
+	clock := NewVirtualClock(time.Now())
+	cfg := testSnCfg
+	cfg.File = ""
+	cfg.MaxTxTokenAge = time.Minute
+	cfg.ReleaseAbandonedTxTokens = true
+	cfg.Clock = clock
+	cfg.Scheduler = NewSeededScheduler(1, 4)
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), nil)
+	}
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+
+	itr := snap.NewIterator()
+	defer itr.Close()
+	itr.SeekFirst()
+
+	// Token does not age without the clock moving
+	time.Sleep(time.Millisecond * 100)
+	if len(s.TxTokenDiagnostics()) != 0 {
+		t.Errorf("expected no abandoned tokens")
+	}
+
+	clock.Advance(time.Minute * 2)
+	for i := 0; i < 1000 && s.GetStats().ReleasedTxTokens == 0; i++ {
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	if sts := s.GetStats(); sts.ReleasedTxTokens != 1 {
+		t.Errorf("expected 1 released token, got %d", sts.ReleasedTxTokens)
+	}
+
+	if infos := s.TxTokenDiagnostics(); len(infos) != 1 || infos[0].Age != time.Minute*2 {
+		t.Errorf("unexpected diagnostics %+v", infos)
+	}
+}
@@ -0,0 +1,152 @@
+package plasma
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/couchbase/nitro/skiplist"
+	"unsafe"
+)
+
+var ErrItemCodecMismatch = errors.New("item codec identity does not match the store")
+var ErrItemMetaNotSupported = errors.New("item codec does not support metadata")
+
+// ItemCodec defines the layout of the items created by the key value and
+// MVCC APIs. Items are opaque to the store other than through the codec and
+// the item size, copy and compare functions in the config.
+type ItemCodec interface {
+	// Identity of the layout persisted with the store. A store cannot
+	// be opened using a codec with a different identity.
+	Id() string
+
+	// Encode an item into buf. Codecs which cannot store metadata
+	// should return ErrItemMetaNotSupported if meta is not nil.
+	NewItem(k, v []byte, sn uint64, meta *uint64, del bool, buf *Buffer) (unsafe.Pointer, error)
+
+	Key(itm unsafe.Pointer) []byte
+	Value(itm unsafe.Pointer) []byte
+	HasValue(itm unsafe.Pointer) bool
+	Sn(itm unsafe.Pointer) uint64
+	IsInsert(itm unsafe.Pointer) bool
+	Meta(itm unsafe.Pointer) uint64
+
+	// Encoded size of the item
+	Size(itm unsafe.Pointer) uintptr
+}
+
+// Codec for the default variable length item layout
+var DefaultItemCodec ItemCodec = defaultItemCodec{}
+
+type defaultItemCodec struct{}
+
+func (defaultItemCodec) Id() string {
+	return "plasma.item/1"
+}
+
+func (defaultItemCodec) NewItem(k, v []byte, sn uint64, meta *uint64, del bool, buf *Buffer) (unsafe.Pointer, error) {
+	itm, err := newItemMeta(k, v, sn, meta, del, buf)
+	return unsafe.Pointer(itm), err
+}
+
+func (defaultItemCodec) Key(itm unsafe.Pointer) []byte {
+	return (*item)(itm).Key()
+}
+
+func (defaultItemCodec) Value(itm unsafe.Pointer) []byte {
+	return (*item)(itm).Value()
+}
+
+func (defaultItemCodec) HasValue(itm unsafe.Pointer) bool {
+	return (*item)(itm).HasValue()
+}
+
+func (defaultItemCodec) Sn(itm unsafe.Pointer) uint64 {
+	return (*item)(itm).Sn()
+}
+
+func (defaultItemCodec) IsInsert(itm unsafe.Pointer) bool {
+	return (*item)(itm).IsInsert()
+}
+
+func (defaultItemCodec) Meta(itm unsafe.Pointer) uint64 {
+	return (*item)(itm).Meta()
+}
+
+func (defaultItemCodec) Size(itm unsafe.Pointer) uintptr {
+	return uintptr((*item)(itm).Size())
+}
+
+// Config for storing items using the codec. Keys are ordered bytewise and
+// items are copied as is, since the store cannot know about any key
+// sharing done by the codec.
+func ItemCodecConfig(c ItemCodec) Config {
+	cfg := DefaultConfig()
+	cfg.ItemCodec = c
+	cfg.CompareId = "plasma.bytes/1"
+	cfg.Compare = func(a, b unsafe.Pointer) int {
+		if a == skiplist.MinItem || b == skiplist.MaxItem {
+			return -1
+		}
+
+		if a == skiplist.MaxItem || b == skiplist.MinItem {
+			return 1
+		}
+
+		return bytes.Compare(c.Key(a), c.Key(b))
+	}
+
+	cfg.ItemSize = func(itm unsafe.Pointer) uintptr {
+		if itm == skiplist.MinItem || itm == skiplist.MaxItem {
+			return 0
+		}
+		return c.Size(itm)
+	}
+
+	cfg.ItemSizeActual = cfg.ItemSize
+	cfg.ItemDataSize = nil
+	cfg.IndexKeySize = nil
+	cfg.CopyItem = memcopy
+	cfg.CopyIndexKey = memcopy
+	cfg.ItemRunSize = nil
+	cfg.CopyItemRun = nil
+	return cfg
+}
+
+// IndexKeySeparator for the configs which order the items bytewise by
+// their codec keys. The separator is the shortest prefix of the key of b
+// which is greater than the key of a.
+func BytesKeySeparator(c ItemCodec) func(a, b unsafe.Pointer) unsafe.Pointer {
+	return func(a, b unsafe.Pointer) unsafe.Pointer {
+		ka, kb := c.Key(a), c.Key(b)
+		i := 0
+		for i < len(ka) && i < len(kb) && ka[i] == kb[i] {
+			i++
+		}
+
+		if i+1 >= len(kb) {
+			return b
+		}
+
+		itm, err := c.NewItem(kb[:i+1], nil, 0, nil, false, newBuffer(0))
+		if err != nil {
+			return b
+		}
+
+		return itm
+	}
+}
+
+func (s *Plasma) checkItemCodec() error {
+	id, ok := s.getStoreMeta(storeMetaItemCodec)
+	if !ok {
+		return s.setStoreMeta(storeMetaItemCodec, []byte(s.ItemCodec.Id()))
+	}
+
+	if string(id) != s.ItemCodec.Id() {
+		fmt.Printf("Plasma: Item codec identity mismatch (store: %q, config: %q)\n",
+			string(id), s.ItemCodec.Id())
+		return ErrItemCodecMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package plasma
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+// Fixed width records with 8 byte keys and values
+// [1 byte flags][8 byte key][8 byte sn][8 byte val]
+type fixedItemCodec struct{}
+
+const (
+	fixedItmSize      = 25
+	fixedItmInsertBit = 1
+	fixedItmValBit    = 2
+)
+
+func (fixedItemCodec) Id() string {
+	return "test.fixed/1"
+}
+
+func (fixedItemCodec) bytes(itm unsafe.Pointer) (bs []byte) {
+	sh := (*reflect.SliceHeader)(unsafe.Pointer(&bs))
+	sh.Data = uintptr(itm)
+	sh.Len = fixedItmSize
+	sh.Cap = fixedItmSize
+	return
+}
+
+func (c fixedItemCodec) NewItem(k, v []byte, sn uint64, meta *uint64, del bool, buf *Buffer) (unsafe.Pointer, error) {
+	if meta != nil {
+		return nil, ErrItemMetaNotSupported
+	}
+
+	bs := buf.Get(0, fixedItmSize)
+	bs[0] = 0
+	if !del {
+		bs[0] |= fixedItmInsertBit
+	}
+
+	if v != nil {
+		bs[0] |= fixedItmValBit
+	}
+
+	copy(bs[1:9], k)
+	binary.BigEndian.PutUint64(bs[9:17], sn)
+	copy(bs[17:], v)
+	return buf.Ptr(0), nil
+}
+
+func (c fixedItemCodec) Key(itm unsafe.Pointer) []byte {
+	return c.bytes(itm)[1:9]
+}
+
+func (c fixedItemCodec) Value(itm unsafe.Pointer) []byte {
+	return c.bytes(itm)[17:]
+}
+
+func (c fixedItemCodec) HasValue(itm unsafe.Pointer) bool {
+	return c.bytes(itm)[0]&fixedItmValBit > 0
+}
+
+func (c fixedItemCodec) Sn(itm unsafe.Pointer) uint64 {
+	return binary.BigEndian.Uint64(c.bytes(itm)[9:17])
+}
+
+func (c fixedItemCodec) IsInsert(itm unsafe.Pointer) bool {
+	return c.bytes(itm)[0]&fixedItmInsertBit > 0
+}
+
+func (c fixedItemCodec) Meta(itm unsafe.Pointer) uint64 {
+	return 0
+}
+
+func (c fixedItemCodec) Size(itm unsafe.Pointer) uintptr {
+	return fixedItmSize
+}
+
+func fixedBytes(i int) []byte {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(i))
+	return bs
+}
+
+func TestPlasmaItemCodec(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := ItemCodecConfig(fixedItemCodec{})
+	cfg.File = "teststore.data"
+	cfg.AutoLSSCleaning = false
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	w := s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.InsertKV(fixedBytes(i), fixedBytes(i*2))
+	}
+
+	for i := 0; i < 10000; i += 2 {
+		w.DeleteKV(fixedBytes(i))
+	}
+
+	if _, err := w.InsertKVMeta(fixedBytes(1), fixedBytes(1), 1); err != ErrItemMetaNotSupported {
+		t.Errorf("expected meta not supported error, got %v", err)
+	}
+
+	snap := s.NewSnapshot()
+	s.PersistAll()
+	snap.Close()
+	s.Close()
+
+	cfg2 := cfg
+	cfg2.ItemCodec = DefaultItemCodec
+	if _, err := New(cfg2); err != ErrItemCodecMismatch {
+		t.Fatalf("expected codec mismatch error, got %v", err)
+	}
+
+	s, err = New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer s.Close()
+
+	snap = s.NewSnapshot()
+	defer snap.Close()
+	itr := snap.NewIterator()
+	defer itr.Close()
+
+	i := 1
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		k := binary.BigEndian.Uint64(itr.Key())
+		v := binary.BigEndian.Uint64(itr.Value())
+		if k != uint64(i) || v != uint64(i*2) {
+			t.Errorf("expected %d:%d, got %d:%d", i, i*2, k, v)
+		}
+		i += 2
+	}
+
+	if i != 10001 {
+		t.Errorf("expected 5000 items, got %d", (i-1)/2)
+	}
+
+	v, err := s.NewWriter().LookupKV(fixedBytes(9))
+	if err != nil || binary.BigEndian.Uint64(v) != 18 {
+		t.Errorf("unexpected lookup result %v %v", v, err)
+	}
+}
+
+func TestPlasmaIndexKeySeparator(t *testing.T) {
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("users/accounts/profiles/%010d/settings/preferences", i))
+	}
+
+	memSzIndex := func(sep bool) int64 {
+		os.RemoveAll("teststore.data")
+		cfg := DefaultConfig()
+		cfg.File = "teststore.data"
+		cfg.MaxPageItems = 50
+		cfg.AutoLSSCleaning = false
+		if sep {
+			cfg.IndexKeySeparator = BytesKeySeparator(DefaultItemCodec)
+		}
+
+		s, err := New(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		w := s.NewWriter()
+		for i := 0; i < 20000; i++ {
+			w.InsertKV(key(i), key(i))
+		}
+
+		s.PersistAll()
+		sz := s.GetStats().MemSzIndex
+		s.Close()
+
+		s, err = New(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer s.Close()
+
+		s.EvictAll()
+		w = s.NewWriter()
+		for i := 0; i < 20000; i++ {
+			if v, err := w.LookupKV(key(i)); err != nil || !bytes.Equal(v, key(i)) {
+				t.Fatalf("unexpected lookup result for %d %s %v", i, v, err)
+			}
+		}
+
+		snap := s.NewSnapshot()
+		defer snap.Close()
+		itr := snap.NewIterator()
+		defer itr.Close()
+
+		n := 0
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			if !bytes.Equal(itr.Key(), key(n)) {
+				t.Fatalf("expected %s, got %s", key(n), itr.Key())
+			}
+			n++
+		}
+
+		if n != 20000 {
+			t.Errorf("expected 20000 items, got %d", n)
+		}
+
+		return sz
+	}
+
+	full, trunc := memSzIndex(false), memSzIndex(true)
+	if trunc >= full {
+		t.Errorf("expected truncated keys to shrink the index, %d >= %d", trunc, full)
+	}
+}
@@ -0,0 +1,220 @@
+package plasma
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/couchbase/nitro/skiplist"
+	"sync"
+	"unsafe"
+)
+
+// Pages with long delta chains are queued for compaction when they are
+// accessed and the compactor threads compact the pages with the longest
+// chains first. A queue entry is only a hint, the page owning the key is
+// looked up again before compaction.
+type compactionReq struct {
+	pid   PageId
+	key   unsafe.Pointer
+	score int
+	index int
+}
+
+type compactionHeap []*compactionReq
+
+func (h compactionHeap) Len() int           { return len(h) }
+func (h compactionHeap) Less(i, j int) bool { return h[i].score > h[j].score }
+
+func (h compactionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *compactionHeap) Push(x interface{}) {
+	req := x.(*compactionReq)
+	req.index = len(*h)
+	*h = append(*h, req)
+}
+
+func (h *compactionHeap) Pop() interface{} {
+	old := *h
+	req := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return req
+}
+
+type compactionQueue struct {
+	sync.Mutex
+	h      compactionHeap
+	queued map[PageId]*compactionReq
+	notify chan struct{}
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (s *Plasma) compactionQueueThreshold() int {
+	if s.CompactionQueueThreshold > 0 {
+		return s.CompactionQueueThreshold
+	}
+
+	if n := s.liveConfig().MaxDeltaChainLen / 4; n > 1 {
+		return n
+	}
+
+	return 1
+}
+
+func compactionScore(pg *page) int {
+	if pg.head == nil {
+		return 0
+	}
+
+	return int(pg.head.chainLen)
+}
+
+func (s *Plasma) tryQueueCompaction(pid PageId, pg Page) {
+	q := s.compactionQ
+	if q == nil {
+		return
+	}
+
+	p := pg.(*page)
+	score := compactionScore(p)
+	if score < s.compactionQueueThreshold() {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if req, ok := q.queued[pid]; ok {
+		if req.score < score {
+			req.score = score
+			heap.Fix(&q.h, req.index)
+		}
+		return
+	}
+
+	if len(q.h) >= s.MaxCompactionQueueSize {
+		return
+	}
+
+	req := &compactionReq{pid: pid, score: score, key: p.MinItem()}
+	if req.key != skiplist.MinItem {
+		buf := make([]byte, s.itemSize(req.key))
+		s.copyItem(unsafe.Pointer(&buf[0]), req.key, len(buf))
+		req.key = unsafe.Pointer(&buf[0])
+	}
+
+	heap.Push(&q.h, req)
+	q.queued[pid] = req
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Plasma) popCompactionReq() *compactionReq {
+	q := s.compactionQ
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.h) == 0 {
+		return nil
+	}
+
+	req := heap.Pop(&q.h).(*compactionReq)
+	delete(q.queued, req.pid)
+	return req
+}
+
+func (s *Plasma) CompactionQueueLen() int {
+	q := s.compactionQ
+	if q == nil {
+		return 0
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	return len(q.h)
+}
+
+func (s *Plasma) compactQueuedPage(req *compactionReq, ctx *wCtx) {
+	tok := ctx.BeginTx()
+	defer ctx.EndTx(tok)
+
+retry:
+	pid, pg, err := s.fetchPageForKey(req.key, ctx)
+	if err != nil {
+		return
+	}
+
+	if compactionScore(pg.(*page)) < s.compactionQueueThreshold() {
+		return
+	}
+
+	if !s.compactPage(pid, pg, ctx) {
+		goto retry
+	}
+
+	ctx.sts.QueuedCompacts++
+}
+
+func (s *Plasma) compactor(ctx *wCtx, ws *workerState) {
+	q := s.compactionQ
+
+	for {
+		ws.beat()
+		for req := s.popCompactionReq(); req != nil; req = s.popCompactionReq() {
+			ws.doing("compacting")
+			s.compactQueuedPage(req, ctx)
+			ws.beat()
+			s.trySMRObjects(ctx, compactorSMRInterval)
+
+			select {
+			case <-q.stop:
+				s.trySMRObjects(ctx, 0)
+				return
+			default:
+			}
+		}
+
+		ws.idle()
+		select {
+		case <-q.stop:
+			s.trySMRObjects(ctx, 0)
+			return
+		case <-q.notify:
+		}
+	}
+}
+
+func (s *Plasma) startCompactors() {
+	if s.NumCompactorThreads <= 0 {
+		return
+	}
+
+	s.compactionQ = &compactionQueue{
+		queued: make(map[PageId]*compactionReq),
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+
+	for i := 0; i < s.NumCompactorThreads; i++ {
+		s.compactionQ.wg.Add(1)
+		go func(name string, ctx *wCtx) {
+			defer s.compactionQ.wg.Done()
+			s.superviseWorker(name, func(ws *workerState) {
+				s.compactor(ctx, ws)
+			})
+		}(fmt.Sprintf("compactor-%d", i), s.newWCtx())
+	}
+}
+
+func (s *Plasma) stopCompactors() {
+	if q := s.compactionQ; q != nil {
+		close(q.stop)
+		q.wg.Wait()
+	}
+}
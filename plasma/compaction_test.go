@@ -0,0 +1,40 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPlasmaCompactionQueue(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.MaxDeltaChainLen = 100000
+	cfg.NumCompactorThreads = 1
+	cfg.CompactionQueueThreshold = 50
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 20000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i%100)), []byte(fmt.Sprintf("val-%d", i)))
+	}
+
+	for i := 0; i < 100 && s.CompactionQueueLen() > 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	sts := s.GetStats()
+	if sts.QueuedCompacts == 0 {
+		t.Errorf("expected pages to be compacted by the compactor")
+	}
+
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("key-%10d", i))
+		exp := fmt.Sprintf("val-%d", 19900+i)
+		if v, err := w.LookupKV(k); err != nil || string(v) != exp {
+			t.Errorf("expected %s, got %s (%v)", exp, v, err)
+		}
+	}
+}
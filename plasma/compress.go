@@ -0,0 +1,446 @@
+package plasma
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+var ErrDictRotationPending = errors.New("values compressed with the previous dictionary have not been recompressed")
+
+var ErrCorruptValue = errors.New("value cannot be decoded")
+
+// Dictionaries are kept in the log superblock, which limits their size
+const maxValueDictSize = 1024
+
+const (
+	storeMetaValueDict     = "value_dict"
+	storeMetaValueDictPrev = "value_dict_prev"
+)
+
+// Values are prefixed with their encoding
+const (
+	valueRaw byte = iota
+	valueDeflateDict
+)
+
+const valueDictHdrSize = 5
+
+type valueDict struct {
+	id   uint32
+	data []byte
+
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func newValueDict(id uint32, data []byte) *valueDict {
+	return &valueDict{id: id, data: data}
+}
+
+func unmarshalValueDict(bs []byte) (*valueDict, error) {
+	if len(bs) < 4 {
+		return nil, errStoreMetaCorrupt
+	}
+
+	return newValueDict(binary.BigEndian.Uint32(bs), append([]byte(nil), bs[4:]...)), nil
+}
+
+func (d *valueDict) marshal() []byte {
+	bs := make([]byte, 4, 4+len(d.data))
+	binary.BigEndian.PutUint32(bs, d.id)
+	return append(bs, d.data...)
+}
+
+func (d *valueDict) compress(v []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(valueDeflateDict)
+	var id [4]byte
+	binary.BigEndian.PutUint32(id[:], d.id)
+	buf.Write(id[:])
+
+	w, _ := d.writers.Get().(*flate.Writer)
+	if w == nil {
+		w, _ = flate.NewWriterDict(&buf, flate.BestCompression, d.data)
+	} else {
+		w.Reset(&buf)
+	}
+
+	w.Write(v)
+	w.Close()
+	d.writers.Put(w)
+	return buf.Bytes()
+}
+
+func (d *valueDict) decompress(bs []byte) ([]byte, error) {
+	r, _ := d.readers.Get().(io.ReadCloser)
+	if r == nil {
+		r = flate.NewReaderDict(bytes.NewReader(bs), d.data)
+	} else {
+		r.(flate.Resetter).Reset(bytes.NewReader(bs), d.data)
+	}
+
+	v, err := ioutil.ReadAll(r)
+	d.readers.Put(r)
+	return v, err
+}
+
+// Current dictionary and the one it replaced, whose values have not been
+// recompressed yet
+type valueDicts struct {
+	curr, prev *valueDict
+}
+
+type dictSet struct {
+	p unsafe.Pointer
+}
+
+func (ds *dictSet) load() *valueDicts {
+	if p := atomic.LoadPointer(&ds.p); p != nil {
+		return (*valueDicts)(p)
+	}
+
+	return &valueDicts{}
+}
+
+func (ds *dictSet) store(d *valueDicts) {
+	atomic.StorePointer(&ds.p, unsafe.Pointer(d))
+}
+
+func (d *valueDicts) get(id uint32) *valueDict {
+	if d.curr != nil && d.curr.id == id {
+		return d.curr
+	} else if d.prev != nil && d.prev.id == id {
+		return d.prev
+	}
+
+	return nil
+}
+
+// Compresses the values of up to maxSize bytes of the items of c using the
+// current dictionary of the store
+type dictItemCodec struct {
+	ItemCodec
+	dicts   *dictSet
+	maxSize int
+}
+
+func (c *dictItemCodec) Id() string {
+	return c.ItemCodec.Id() + "+dict"
+}
+
+func (c *dictItemCodec) encodeValue(v []byte) []byte {
+	if len(v) == 0 {
+		return v
+	}
+
+	if d := c.dicts.load().curr; d != nil && len(v) <= c.maxSize {
+		if cv := d.compress(v); len(cv) < len(v)+1 {
+			return cv
+		}
+	}
+
+	return append([]byte{valueRaw}, v...)
+}
+
+func (c *dictItemCodec) NewItem(k, v []byte, sn uint64, meta *uint64, del bool, buf *Buffer) (unsafe.Pointer, error) {
+	return c.ItemCodec.NewItem(k, c.encodeValue(v), sn, meta, del, buf)
+}
+
+// Returns the encoding of the value of itm and the id of its dictionary
+func (c *dictItemCodec) valueDict(itm unsafe.Pointer) (byte, uint32) {
+	if !c.ItemCodec.HasValue(itm) {
+		return valueRaw, 0
+	}
+
+	ev := c.ItemCodec.Value(itm)
+	if len(ev) >= valueDictHdrSize && ev[0] == valueDeflateDict {
+		return valueDeflateDict, binary.BigEndian.Uint32(ev[1:])
+	}
+
+	return valueRaw, 0
+}
+
+func (c *dictItemCodec) Value(itm unsafe.Pointer) []byte {
+	v, _ := c.decodeValue(itm)
+	return v
+}
+
+// Reports whether the value of itm can be decoded
+func (c *dictItemCodec) checkValue(itm unsafe.Pointer) error {
+	_, err := c.decodeValue(itm)
+	return err
+}
+
+func (c *dictItemCodec) decodeValue(itm unsafe.Pointer) ([]byte, error) {
+	ev := c.ItemCodec.Value(itm)
+	if len(ev) == 0 {
+		return nil, nil
+	}
+
+	switch ev[0] {
+	case valueRaw:
+		return ev[1:], nil
+	case valueDeflateDict:
+		if len(ev) < valueDictHdrSize {
+			return nil, ErrCorruptValue
+		}
+
+		d := c.dicts.load().get(binary.BigEndian.Uint32(ev[1:]))
+		if d == nil {
+			return nil, ErrCorruptValue
+		}
+
+		v, err := d.decompress(ev[valueDictHdrSize:])
+		if err != nil {
+			return nil, ErrCorruptValue
+		}
+		return v, nil
+	}
+
+	return nil, ErrCorruptValue
+}
+
+// Build a dictionary of up to size bytes from the substrings shared by the
+// most samples. The most common substrings are placed at the end of the
+// dictionary, where they are cheapest to refer to.
+func TrainValueDictionary(samples [][]byte, size int) []byte {
+	const chunkSize = 8
+
+	type chunk struct {
+		s     string
+		count int
+	}
+
+	counts := make(map[string]int)
+	for _, v := range samples {
+		seen := make(map[string]bool)
+		for i := 0; i+chunkSize <= len(v); i += chunkSize / 2 {
+			c := string(v[i : i+chunkSize])
+			if !seen[c] {
+				seen[c] = true
+				counts[c]++
+			}
+		}
+	}
+
+	var chunks []chunk
+	for s, n := range counts {
+		if n > 1 {
+			chunks = append(chunks, chunk{s, n})
+		}
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		if chunks[i].count != chunks[j].count {
+			return chunks[i].count > chunks[j].count
+		}
+		return chunks[i].s < chunks[j].s
+	})
+
+	if n := size / chunkSize; len(chunks) > n {
+		chunks = chunks[:n]
+	}
+
+	dict := make([]byte, 0, len(chunks)*chunkSize)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		dict = append(dict, chunks[i].s...)
+	}
+
+	return dict
+}
+
+// Sample up to n values of the items of the store
+func (s *Plasma) SampleValues(n int) [][]byte {
+	var samples [][]byte
+
+	itr := s.NewIterator().(*Iterator)
+	defer itr.Close()
+
+	seen := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		itm := itr.Get()
+		if !s.ItemCodec.IsInsert(itm) || !s.ItemCodec.HasValue(itm) {
+			continue
+		}
+
+		seen++
+		if len(samples) < n {
+			samples = append(samples, append([]byte(nil), s.ItemCodec.Value(itm)...))
+		} else if i := rand.Intn(seen); i < n {
+			samples[i] = append([]byte(nil), s.ItemCodec.Value(itm)...)
+		}
+	}
+
+	return samples
+}
+
+func (s *Plasma) loadValueDicts() error {
+	var dicts valueDicts
+	var err error
+
+	if bs, ok := s.getStoreMeta(storeMetaValueDict); ok {
+		if dicts.curr, err = unmarshalValueDict(bs); err != nil {
+			return err
+		}
+	}
+
+	if bs, ok := s.getStoreMeta(storeMetaValueDictPrev); ok {
+		if dicts.prev, err = unmarshalValueDict(bs); err != nil {
+			return err
+		}
+	}
+
+	s.valueDicts.store(&dicts)
+	return nil
+}
+
+// Compress the small values written from now on using a dictionary trained
+// from samples. Values compressed with the dictionary it replaces are
+// recompressed by RecompressValues, which should complete before the
+// dictionary can be rotated again.
+func (s *Plasma) RotateValueDictionary(samples [][]byte) error {
+	if !s.ValueDictionary {
+		return ErrItemCodecMismatch
+	}
+
+	s.dictLock.Lock()
+	defer s.dictLock.Unlock()
+
+	old := s.valueDicts.load()
+	if old.prev != nil {
+		return ErrDictRotationPending
+	}
+
+	var id uint32 = 1
+	if old.curr != nil {
+		id = old.curr.id + 1
+	}
+
+	dicts := &valueDicts{
+		curr: newValueDict(id, TrainValueDictionary(samples, maxValueDictSize)),
+		prev: old.curr,
+	}
+
+	if s.shouldPersist {
+		if dicts.prev != nil {
+			if err := s.setStoreMeta(storeMetaValueDictPrev, dicts.prev.marshal()); err != nil {
+				return err
+			}
+		}
+
+		if err := s.setStoreMeta(storeMetaValueDict, dicts.curr.marshal()); err != nil {
+			return err
+		}
+	}
+
+	s.valueDicts.store(dicts)
+	return nil
+}
+
+// Recompress the values compressed with the previous dictionary by
+// compacting every page, and drop the dictionary
+func (s *Plasma) RecompressValues(ctx context.Context) error {
+	s.dictLock.Lock()
+	defer s.dictLock.Unlock()
+
+	dicts := s.valueDicts.load()
+	if dicts.prev == nil {
+		return nil
+	}
+
+	if err := s.ForceGC(ctx); err != nil {
+		return err
+	}
+
+	if s.shouldPersist {
+		s.lss.Sync(true)
+		if err := s.deleteStoreMeta(storeMetaValueDictPrev); err != nil {
+			return err
+		}
+	}
+
+	s.valueDicts.store(&valueDicts{curr: dicts.curr})
+	return nil
+}
+
+// Compaction filter recompressing the values compressed with the previous
+// dictionary
+type dictFilter struct {
+	ItemFilter
+	dc     *dictItemCodec
+	prevId uint32
+}
+
+func (s *Plasma) newDictFilter(f ItemFilter) ItemFilter {
+	dc, ok := s.dictCodec()
+	if !ok {
+		return f
+	}
+
+	dicts := dc.dicts.load()
+	if dicts.prev == nil {
+		return f
+	}
+
+	return &dictFilter{ItemFilter: f, dc: dc, prevId: dicts.prev.id}
+}
+
+func (s *Plasma) dictCodec() (*dictItemCodec, bool) {
+	c := s.ItemCodec
+	if cc, ok := c.(checksumItemCodec); ok {
+		c = cc.ItemCodec
+	}
+
+	dc, ok := c.(*dictItemCodec)
+	return dc, ok
+}
+
+func (f *dictFilter) recompress(o PageItem) PageItem {
+	itm := o.Item()
+	if !o.IsInsert() {
+		return o
+	}
+
+	if enc, id := f.dc.valueDict(itm); enc != valueDeflateDict || id != f.prevId {
+		return o
+	}
+
+	var meta *uint64
+	if m := f.dc.Meta(itm); m != 0 {
+		meta = &m
+	}
+
+	nitm, err := f.dc.NewItem(f.dc.Key(itm), f.dc.Value(itm), f.dc.Sn(itm), meta, false, nil)
+	if err != nil {
+		return o
+	}
+
+	return &pageItem{itm: nitm}
+}
+
+func (f *dictFilter) Process(o PageItem) PageItemsList {
+	l := f.ItemFilter.Process(o)
+	switch l.Len() {
+	case 0:
+		return l
+	case 1:
+		return f.recompress(l.At(0))
+	}
+
+	items := make([]PageItem, l.Len())
+	for i := range items {
+		items[i] = f.recompress(l.At(i))
+	}
+
+	return (*pageItemsList)(&items)
+}
@@ -0,0 +1,123 @@
+package plasma
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func testDictValue(i int) []byte {
+	return []byte(fmt.Sprintf(`{"type":"order","status":"shipped","customer":"customer-%d","total":%d}`, i%97, i))
+}
+
+func TestPlasmaValueDictionary(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.ValueDictionary = true
+	s := newTestIntPlasmaStore(cfg)
+
+	verify := func(n int) {
+		w := s.NewWriter()
+		for i := 0; i < n; i++ {
+			exp := testDictValue(i)
+			if v, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i))); err != nil || string(v) != string(exp) {
+				t.Fatalf("expected %s, got %s %v", exp, v, err)
+			}
+		}
+	}
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), testDictValue(i))
+	}
+	w.InsertKV([]byte("novalue"), nil)
+
+	samples := s.SampleValues(100)
+	if len(samples) != 100 {
+		t.Fatalf("expected 100 samples, got %d", len(samples))
+	}
+
+	if err := s.RotateValueDictionary(samples); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	dc, _ := s.dictCodec()
+	for i := 1000; i < 2000; i++ {
+		v := testDictValue(i)
+		if ev := dc.encodeValue(v); len(ev) >= len(v) {
+			t.Fatalf("expected value to be compressed, got %d bytes for %d", len(ev), len(v))
+		}
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), v)
+	}
+	verify(2000)
+
+	if _, err := w.LookupKV([]byte("novalue")); err != ErrItemNoValue {
+		t.Errorf("expected no value error, got %v", err)
+	}
+
+	// A value of an unknown dictionary is reported rather than read as empty
+	bad := []byte{valueDeflateDict, 0, 0, 0, 99, 1, 2, 3}
+	itm, _ := dc.ItemCodec.NewItem([]byte("badvalue"), bad, 0, nil, false, newBuffer(0))
+	if err := dc.checkValue(itm); err != ErrCorruptValue {
+		t.Errorf("expected corrupt value error, got %v", err)
+	}
+
+	if err := s.RotateValueDictionary(s.SampleValues(100)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := s.RotateValueDictionary(samples); err != ErrDictRotationPending {
+		t.Errorf("expected rotation pending error, got %v", err)
+	}
+	verify(2000)
+
+	snap := s.NewSnapshot()
+	s.PersistAll()
+	snap.Close()
+	s.Close()
+
+	// The previous dictionary is recovered until values are recompressed
+	s = newTestIntPlasmaStore(cfg)
+	verify(2000)
+
+	if err := s.RecompressValues(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if dicts := s.valueDicts.load(); dicts.prev != nil || dicts.curr.id != 2 {
+		t.Fatalf("expected only the second dictionary, got %+v", dicts)
+	}
+
+	if err := s.RotateValueDictionary(samples); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	verify(2000)
+	s.Close()
+
+	cfg2 := cfg
+	cfg2.ValueDictionary = false
+	if _, err := New(cfg2); err != ErrItemCodecMismatch {
+		t.Fatalf("expected codec mismatch error, got %v", err)
+	}
+
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+	verify(2000)
+}
+
+func TestTrainValueDictionary(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		samples = append(samples, testDictValue(i))
+	}
+
+	dict := TrainValueDictionary(samples, 64)
+	if len(dict) == 0 || len(dict) > 64 {
+		t.Fatalf("expected a dictionary of up to 64 bytes, got %d", len(dict))
+	}
+
+	if dict := TrainValueDictionary(nil, 64); len(dict) != 0 {
+		t.Errorf("expected an empty dictionary, got %d bytes", len(dict))
+	}
+}
@@ -1,8 +1,11 @@
 package plasma
 
 import (
+	"fmt"
 	"github.com/couchbase/nitro/skiplist"
+	"reflect"
 	"runtime"
+	"time"
 	"unsafe"
 )
 
@@ -12,38 +15,199 @@ type Config struct {
 	MinPageItems       int
 	MaxPageLSSSegments int
 	Compare            skiplist.CompareFn
+	CompareId          string // Comparator is not checked if empty
 	ItemSize           ItemSizeFn
 	ItemSizeActual     ItemSizeFn
 	CopyItem           ItemCopyFn
 	ItemRunSize        ItemRunSizeFn
 	CopyItemRun        ItemRunCopyFn
 
+	// Pages are merged into their left sibling only if the merged page has
+	// at most MaxPageItems - MergeHysteresis items, and not within
+	// MergeCooldown of a split of them
+	MergeHysteresis int
+	MergeCooldown   time.Duration
+
 	IndexKeySize ItemSizeFn
 	CopyIndexKey ItemCopyFn
 
+	// Returns the shortest item x such that a < x <= b, if set. Pages split
+	// off at b use it as their low key in the index instead of b.
+	IndexKeySeparator func(a, b unsafe.Pointer) unsafe.Pointer
+
+	// Picks the item at which pages are split. Defaults to SplitBySize.
+	SplitKeyPolicy SplitKeyPolicy
+
+	// Store a checksum with the items created through the key value APIs,
+	// which is verified when they are read. See ChecksumItemCodec.
+	ItemChecksums bool
+
+	// Compress values of up to MaxDictValueSize bytes written through the
+	// key value APIs with a dictionary trained from sampled values. See
+	// RotateValueDictionary.
+	ValueDictionary  bool
+	MaxDictValueSize int
+
+	// Validates items read from the log. Defaults to a bounds check
+	// using ItemSize.
+	ItemDataSize ItemDataSizeFn
+
+	// Item layout used by the key value and MVCC APIs
+	ItemCodec ItemCodec
+
 	LSSLogSegmentSize   int64
 	File                string
 	FlushBufferSize     int
 	NumPersistorThreads int
 	NumEvictorThreads   int
 
+	// Page update records of up to a quarter of FlushCoalesceSize written
+	// by PersistAll are batched into log writes of up to FlushCoalesceSize
+	// bytes, if set
+	FlushCoalesceSize int
+
 	LSSCleanerThreshold int
 	AutoLSSCleaning     bool
 	AutoSwapper         bool
 
-	EnableShapshots bool
+	// Bytes of the log read per second by the log cleaner and Defragment.
+	// Zero leaves it unlimited.
+	LSSCleanerMaxRate int64
+
+	// The swapper first evicts only the deltas above the base page of a
+	// page, keeping the base page in memory. The page is fully evicted if
+	// it is picked again without being accessed.
+	PartialEviction bool
+
+	EnableShapshots     bool
+	SnapshotBatchWindow time.Duration
+
+	// Writers look up the previous version of a key to keep the items count
+	// exact across overwrites and deletes of missing keys
+	ExactItemsCount bool
+
+	// Pages with delta chains longer than CompactionQueueThreshold are
+	// compacted in the background by the compactor threads
+	NumCompactorThreads      int
+	CompactionQueueThreshold int
+	MaxCompactionQueueSize   int
+
+	// Writes are delayed by up to WriteStallDelay once the compaction
+	// queue, the memory in use or the stale log space exceed their limit,
+	// and rejected with ErrWriteStalled once one exceeds twice its limit.
+	// Zero disables a limit.
+	WriteStallCompactionQueue int
+	WriteStallMemSize         int64
+	WriteStallLSSStaleBytes   int64
+	WriteStallDelay           time.Duration
+
+	// Swap in the pages ahead of the iterators scanning sequentially, see
+	// Iterator.SetPrefetch
+	NumPrefetchThreads   int
+	MaxPrefetchQueueSize int
 
 	TriggerSwapper func(SwapperContext) bool
 	shouldPersist  bool
 
+	// Instances sharing a QuotaManager split its memory quota. It takes
+	// precedence over TriggerSwapper.
+	QuotaManager *QuotaManager
+
 	MaxSnSyncFrequency int
-	SyncInterval       int
+
+	// Log writes are committed by a background goroutine every
+	// SyncInterval seconds instead of being synced as they are written.
+	// The max sn record is not synced on its own then. SyncPeriod sets a
+	// finer grained period and takes precedence over SyncInterval.
+	SyncInterval int
+	SyncPeriod   time.Duration
 
 	UseMemoryMgmt bool
 	UseMmap       bool
+
+	// Keep the log in memory instead of in File. A store reopened with the
+	// same File in the same process recovers the data synced to the log.
+	// File may be empty for a store which is dropped on close.
+	InMemoryLSS bool
+
+	// Pages are kept in memory until the swapper evicts them, when they are
+	// written to the log in File. The log is neither synced nor recovered,
+	// and File is removed when the store is opened and closed, so that it
+	// should not be shared with other data.
+	Ephemeral bool
+
+	// Moves cold log segments to an object store, if set
+	ColdTier *ColdTierConfig
+
+	// Called with each log segment before it is deleted once the log has
+	// been trimmed beyond it. A segment is retained and offered again on the
+	// next commit if an error is returned. The log is not written to while
+	// the hook runs.
+	ArchiveLogSegment func(LogSegment) error
+
+	// Injects faults into the log IO. Only meant for tests.
+	FaultInjector FaultInjector
+
+	// Transaction tokens held longer than MaxTxTokenAge are reported.
+	// Releasing abandoned tokens lets memory reclamation proceed, but it is
+	// unsafe if the owner is still accessing the store using the token.
+	MaxTxTokenAge            time.Duration
+	CaptureTxTokenStacks     bool
+	ReleaseAbandonedTxTokens bool
+
+	// Background worker failures are reported to EventListener, which
+	// should not block. Workers which do not report progress within
+	// WorkerStallTimeout are reported as stalled.
+	EventListener      func(Event)
+	WorkerStallTimeout time.Duration
+
+	// Advice from Advise is applied every AutoTuneInterval, if it is set
+	AutoTuneInterval time.Duration
+
+	// The page mapping is checkpointed every CheckpointInterval, if it is
+	// set, so that recovery only replays the log written after the latest
+	// checkpoint
+	CheckpointInterval time.Duration
+
+	// Count the lookups, mutations and iterator visits of each page for
+	// PageAccessStats
+	TrackPageAccess bool
+
+	// Memory budget of the page index. Once exceeded, runs of adjacent
+	// evicted pages are merged in the log to release their index nodes.
+	// Merged pages are split again as they are accessed. Zero disables it.
+	IndexMemoryQuota int64
+
+	IgnoreCompareIdMismatch bool
+	RepairOnRecovery        bool
+
+	// Time source of the background workers and the lss sync interval.
+	// Scheduler is called by the background workers before every unit of
+	// work. Both are meant to make background work reproducible in tests.
+	// Workers waiting on a VirtualClock only make progress when it is
+	// advanced, which includes stopping them on Close.
+	Clock     Clock
+	Scheduler Scheduler
 }
 
 func applyConfigDefaults(cfg Config) Config {
+	cfg = applyTunableDefaults(cfg)
+
+	if cfg.Ephemeral {
+		cfg.AutoSwapper = true
+	}
+
+	if cfg.File == "" && !cfg.InMemoryLSS {
+		cfg.AutoLSSCleaning = false
+		cfg.AutoSwapper = false
+	} else {
+		cfg.shouldPersist = true
+	}
+
+	return cfg
+}
+
+func applyTunableDefaults(cfg Config) Config {
 	if cfg.NumPersistorThreads == 0 {
 		cfg.NumPersistorThreads = runtime.NumCPU()
 	}
@@ -56,17 +220,22 @@ func applyConfigDefaults(cfg Config) Config {
 		cfg.TriggerSwapper = QuotaSwapper
 	}
 
-	if cfg.File == "" {
-		cfg.AutoLSSCleaning = false
-		cfg.AutoSwapper = false
-	} else {
-		cfg.shouldPersist = true
+	if cfg.Clock == nil {
+		cfg.Clock = SystemClock
 	}
 
 	if cfg.MaxSnSyncFrequency == 0 {
 		cfg.MaxSnSyncFrequency = 360000
 	}
 
+	if cfg.CompareId == "" && isDefaultCompare(cfg.Compare) {
+		cfg.CompareId = defaultCompareId
+	}
+
+	if cfg.SyncPeriod == 0 {
+		cfg.SyncPeriod = time.Duration(cfg.SyncInterval) * time.Second
+	}
+
 	if cfg.LSSLogSegmentSize == 0 {
 		cfg.LSSLogSegmentSize = 1024 * 1024 * 1024 * 4
 	}
@@ -75,6 +244,14 @@ func applyConfigDefaults(cfg Config) Config {
 		cfg.MaxPageLSSSegments = 4
 	}
 
+	if cfg.MaxCompactionQueueSize == 0 {
+		cfg.MaxCompactionQueueSize = 10000
+	}
+
+	if cfg.MaxPrefetchQueueSize == 0 {
+		cfg.MaxPrefetchQueueSize = 1000
+	}
+
 	if cfg.CopyItem == nil {
 		cfg.CopyItem = memcopy
 	}
@@ -87,6 +264,10 @@ func applyConfigDefaults(cfg Config) Config {
 		cfg.IndexKeySize = cfg.ItemSize
 	}
 
+	if cfg.SplitKeyPolicy == nil {
+		cfg.SplitKeyPolicy = SplitBySize
+	}
+
 	if cfg.ItemRunSize == nil {
 		cfg.ItemRunSize = func(srcItms []unsafe.Pointer) uintptr {
 			var sz uintptr
@@ -113,9 +294,187 @@ func applyConfigDefaults(cfg Config) Config {
 		cfg.ItemSizeActual = cfg.ItemSize
 	}
 
+	if cfg.ItemDataSize == nil {
+		itemSize := cfg.ItemSize
+		cfg.ItemDataSize = func(data []byte) (int, bool) {
+			if len(data) == 0 {
+				return 0, false
+			}
+
+			l := int(itemSize(unsafe.Pointer(&data[0])))
+			return l, l > 0 && l <= len(data)
+		}
+	}
+
+	if cfg.ItemCodec == nil {
+		cfg.ItemCodec = DefaultItemCodec
+	}
+
+	if cfg.MaxDictValueSize == 0 {
+		cfg.MaxDictValueSize = 512
+	}
+
 	return cfg
 }
 
+// Validate checks the config for inconsistent settings. Values left
+// unset are validated as they would be after applying the defaults.
+func (cfg Config) Validate() error {
+	cfg = applyConfigDefaults(cfg)
+
+	if cfg.Compare == nil {
+		return fmt.Errorf("Invalid config: Compare is not set")
+	}
+
+	if cfg.ItemSize == nil {
+		return fmt.Errorf("Invalid config: ItemSize is not set")
+	}
+
+	if cfg.CopyItemRun == nil {
+		return fmt.Errorf("Invalid config: CopyItemRun is required along with ItemRunSize")
+	}
+
+	if cfg.MaxDeltaChainLen <= 0 {
+		return fmt.Errorf("Invalid config: MaxDeltaChainLen (%d) should be positive", cfg.MaxDeltaChainLen)
+	}
+
+	if cfg.MaxPageItems <= 0 {
+		return fmt.Errorf("Invalid config: MaxPageItems (%d) should be positive", cfg.MaxPageItems)
+	}
+
+	if cfg.MinPageItems < 0 || cfg.MinPageItems >= cfg.MaxPageItems {
+		return fmt.Errorf("Invalid config: MinPageItems (%d) should be in the range [0, MaxPageItems (%d))",
+			cfg.MinPageItems, cfg.MaxPageItems)
+	}
+
+	if cfg.MergeHysteresis < 0 || cfg.MergeHysteresis >= cfg.MaxPageItems {
+		return fmt.Errorf("Invalid config: MergeHysteresis (%d) should be in the range [0, MaxPageItems (%d))",
+			cfg.MergeHysteresis, cfg.MaxPageItems)
+	}
+
+	if cfg.MergeCooldown < 0 {
+		return fmt.Errorf("Invalid config: MergeCooldown (%v) should not be negative", cfg.MergeCooldown)
+	}
+
+	if cfg.WriteStallCompactionQueue < 0 || cfg.WriteStallMemSize < 0 ||
+		cfg.WriteStallLSSStaleBytes < 0 || cfg.WriteStallDelay < 0 {
+		return fmt.Errorf("Invalid config: write stall limits should not be negative")
+	}
+
+	if cfg.MaxPageLSSSegments < 0 {
+		return fmt.Errorf("Invalid config: MaxPageLSSSegments (%d) should not be negative", cfg.MaxPageLSSSegments)
+	}
+
+	if cfg.LSSCleanerThreshold < 0 || cfg.LSSCleanerThreshold > 100 {
+		return fmt.Errorf("Invalid config: LSSCleanerThreshold (%d) should be a percentage", cfg.LSSCleanerThreshold)
+	}
+
+	if cfg.LSSCleanerMaxRate < 0 {
+		return fmt.Errorf("Invalid config: LSSCleanerMaxRate (%d) should not be negative", cfg.LSSCleanerMaxRate)
+	}
+
+	if cfg.MaxDictValueSize < 0 {
+		return fmt.Errorf("Invalid config: MaxDictValueSize (%d) should not be negative", cfg.MaxDictValueSize)
+	}
+
+	if cfg.SyncInterval < 0 {
+		return fmt.Errorf("Invalid config: SyncInterval (%d) should not be negative", cfg.SyncInterval)
+	}
+
+	if cfg.SyncPeriod < 0 {
+		return fmt.Errorf("Invalid config: SyncPeriod (%v) should not be negative", cfg.SyncPeriod)
+	}
+
+	if cfg.MaxSnSyncFrequency < 0 {
+		return fmt.Errorf("Invalid config: MaxSnSyncFrequency (%d) should not be negative", cfg.MaxSnSyncFrequency)
+	}
+
+	if cfg.NumCompactorThreads < 0 || cfg.CompactionQueueThreshold < 0 || cfg.MaxCompactionQueueSize < 0 {
+		return fmt.Errorf("Invalid config: NumCompactorThreads (%d), CompactionQueueThreshold (%d) and "+
+			"MaxCompactionQueueSize (%d) should not be negative", cfg.NumCompactorThreads,
+			cfg.CompactionQueueThreshold, cfg.MaxCompactionQueueSize)
+	}
+
+	if cfg.NumPrefetchThreads < 0 || cfg.MaxPrefetchQueueSize < 0 {
+		return fmt.Errorf("Invalid config: NumPrefetchThreads (%d) and MaxPrefetchQueueSize (%d) "+
+			"should not be negative", cfg.NumPrefetchThreads, cfg.MaxPrefetchQueueSize)
+	}
+
+	if cfg.MaxTxTokenAge < 0 {
+		return fmt.Errorf("Invalid config: MaxTxTokenAge (%v) should not be negative", cfg.MaxTxTokenAge)
+	}
+
+	if cfg.SnapshotBatchWindow < 0 {
+		return fmt.Errorf("Invalid config: SnapshotBatchWindow (%v) should not be negative", cfg.SnapshotBatchWindow)
+	}
+
+	if cfg.AutoTuneInterval < 0 {
+		return fmt.Errorf("Invalid config: AutoTuneInterval (%v) should not be negative", cfg.AutoTuneInterval)
+	}
+
+	if cfg.CheckpointInterval < 0 {
+		return fmt.Errorf("Invalid config: CheckpointInterval (%v) should not be negative", cfg.CheckpointInterval)
+	}
+
+	if cfg.IndexMemoryQuota < 0 {
+		return fmt.Errorf("Invalid config: IndexMemoryQuota (%d) should not be negative", cfg.IndexMemoryQuota)
+	}
+
+	if cfg.Ephemeral && (cfg.File == "" || cfg.InMemoryLSS || cfg.CheckpointInterval > 0) {
+		return fmt.Errorf("Invalid config: Ephemeral requires a file backed log without checkpoints")
+	}
+
+	if cfg.IndexMemoryQuota > 0 && !cfg.shouldPersist {
+		return fmt.Errorf("Invalid config: IndexMemoryQuota requires a persistent store")
+	}
+
+	if cfg.shouldPersist {
+		if cfg.NumPersistorThreads < 1 || cfg.NumEvictorThreads < 1 {
+			return fmt.Errorf("Invalid config: NumPersistorThreads (%d) and NumEvictorThreads (%d) should be positive "+
+				"when persistence is enabled", cfg.NumPersistorThreads, cfg.NumEvictorThreads)
+		}
+
+		if cfg.ArchiveLogSegment != nil && cfg.InMemoryLSS {
+			return fmt.Errorf("Invalid config: ArchiveLogSegment requires a file backed log")
+		}
+
+		if cfg.ColdTier != nil && (cfg.ColdTier.Store == nil || cfg.UseMmap || cfg.InMemoryLSS) {
+			return fmt.Errorf("Invalid config: ColdTier requires an object store and a file backed log without mmap")
+		}
+
+		if cfg.FlushBufferSize <= 0 {
+			return fmt.Errorf("Invalid config: FlushBufferSize (%d) should be positive when persistence is enabled",
+				cfg.FlushBufferSize)
+		}
+
+		if cfg.FlushCoalesceSize < 0 || cfg.FlushCoalesceSize > cfg.FlushBufferSize {
+			return fmt.Errorf("Invalid config: FlushCoalesceSize (%d) should be in the range [0, FlushBufferSize (%d)]",
+				cfg.FlushCoalesceSize, cfg.FlushBufferSize)
+		}
+
+		if cfg.LSSLogSegmentSize < int64(cfg.FlushBufferSize) {
+			return fmt.Errorf("Invalid config: LSSLogSegmentSize (%d) should not be smaller than FlushBufferSize (%d)",
+				cfg.LSSLogSegmentSize, cfg.FlushBufferSize)
+		}
+	}
+
+	return nil
+}
+
+// Identity of cmpItem, which is recorded for the stores using the default
+// comparator
+const defaultCompareId = "plasma.cmpItem/1"
+
+func isDefaultCompare(cmp skiplist.CompareFn) bool {
+	return cmp != nil && reflect.ValueOf(cmp).Pointer() == reflect.ValueOf(cmpItem).Pointer()
+}
+
+// NewDefaultConfig returns the default config with the defaults for tunables
+// filled in, so that the effective settings can be inspected and changed
+func NewDefaultConfig() Config {
+	return applyTunableDefaults(DefaultConfig())
+}
+
 func DefaultConfig() Config {
 	return Config{
 		MaxDeltaChainLen: 200,
@@ -134,15 +493,19 @@ func DefaultConfig() Config {
 			}
 			return uintptr((*item)(itm).ActualSize())
 		},
+		ItemDataSize:        itemDataSize,
 		CopyItem:            copyItem,
 		CopyIndexKey:        copyItem,
 		ItemRunSize:         itemRunSize,
 		CopyItemRun:         copyItemRun,
+		ItemCodec:           DefaultItemCodec,
 		FlushBufferSize:     1024 * 1024 * 1,
 		LSSCleanerThreshold: 10,
 		AutoLSSCleaning:     true,
 		AutoSwapper:         false,
 		EnableShapshots:     true,
 		SyncInterval:        0,
+		NumCompactorThreads: 1,
+		NumPrefetchThreads:  1,
 	}
 }
@@ -0,0 +1,138 @@
+package plasma
+
+import (
+	"fmt"
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.File = "teststore.data"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if cfg.NumPersistorThreads == 0 || cfg.MaxPageLSSSegments == 0 {
+		t.Errorf("expected defaults to be applied")
+	}
+
+	cfg.MinPageItems = cfg.MaxPageItems
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "MinPageItems") {
+		t.Errorf("expected MinPageItems error, got %v", err)
+	}
+
+	cfg = NewDefaultConfig()
+	cfg.File = "teststore.data"
+	cfg.NumPersistorThreads = -1
+	if _, err := New(cfg); err == nil || !strings.Contains(err.Error(), "NumPersistorThreads") {
+		t.Errorf("expected NumPersistorThreads error, got %v", err)
+	}
+
+	cfg = NewDefaultConfig()
+	cfg.Compare = nil
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected Compare error")
+	}
+
+	cfg = DefaultConfig()
+	cfg.Compare = skiplist.CompareInt
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error for a custom Compare without CompareId %v", err)
+	}
+
+	cfg = NewDefaultConfig()
+	cfg.SyncInterval = 5
+	if cfg = applyConfigDefaults(cfg); cfg.SyncPeriod != 5*time.Second {
+		t.Errorf("expected SyncInterval in seconds, got %v", cfg.SyncPeriod)
+	}
+}
+
+func TestPlasmaUpdateConfig(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testCfg
+	cfg.AutoSwapper = true
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	minItems := s.MaxPageItems
+	if err := s.UpdateConfig(ConfigDelta{MinPageItems: &minItems}); err == nil {
+		t.Errorf("expected invalid config error")
+	}
+
+	maxItems, threads := 100, 2*runtime.NumCPU()+1
+	err := s.UpdateConfig(ConfigDelta{
+		MaxPageItems:        &maxItems,
+		NumPersistorThreads: &threads,
+		NumEvictorThreads:   &threads,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	s.PersistAll()
+
+	sts := s.GetStats()
+	if sts.NumPersistorThreads != threads || sts.NumEvictorThreads != threads || sts.ConfigUpdates != 1 {
+		t.Errorf("expected config changes in stats, got %v", sts)
+	}
+
+	// Pages are split with at most MaxPageItems items
+	if n := sts.NumPages; n < int64(100000/testCfg.MaxPageItems*2) {
+		t.Errorf("expected pages to be split as per MaxPageItems=%d, got %d pages", maxItems, n)
+	}
+
+	if s.LiveConfig().MaxPageItems != maxItems || s.MaxPageItems != testCfg.MaxPageItems {
+		t.Errorf("expected MaxPageItems %d to be live, got %d", maxItems, s.LiveConfig().MaxPageItems)
+	}
+
+	period := time.Second
+	if err := s.UpdateConfig(ConfigDelta{SyncPeriod: &period}); err == nil {
+		t.Errorf("expected SyncPeriod error for a store without background commits")
+	}
+
+	threads = 1
+	s.UpdateConfig(ConfigDelta{NumEvictorThreads: &threads})
+	s.EvictAll()
+	time.Sleep(time.Second * 2)
+}
+
+func TestPlasmaUpdateSyncPeriod(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.SyncPeriod = time.Hour
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("val"))
+	}
+
+	snap := s.NewSnapshot()
+	sn := snap.sn
+	snap.Close()
+	s.PersistAll()
+
+	period := 10 * time.Millisecond
+	if err := s.UpdateConfig(ConfigDelta{SyncPeriod: &period}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The syncer waiting for an hour picks up the new period
+	deadline := time.Now().Add(10 * time.Second)
+	for s.LastDurableSn() < sn {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected sn %d to become durable, got %d", sn, s.LastDurableSn())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
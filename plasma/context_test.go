@@ -0,0 +1,73 @@
+package plasma
+
+import (
+	"context"
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+)
+
+func TestPlasmaContextCancel(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := s.PersistAllContext(ctx); err != context.Canceled {
+		t.Errorf("expected canceled error, got %v", err)
+	}
+
+	visited := 0
+	callb := func(pid PageId, partn RangePartition) error {
+		visited++
+		return nil
+	}
+
+	if err := s.PageVisitorContext(ctx, callb, 4); err != context.Canceled || visited != 0 {
+		t.Errorf("expected no pages to be visited, got %d (%v)", visited, err)
+	}
+
+	if err := s.PersistAllContext(context.Background()); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	s.Close()
+
+	ninstances := numDbInstances()
+	if _, err := NewWithContext(ctx, testCfg); err != context.Canceled {
+		t.Errorf("expected canceled recovery, got %v", err)
+	}
+
+	if n := numDbInstances(); n != ninstances {
+		t.Errorf("expected the canceled store not to be registered, got %d instances", n)
+	}
+
+	s = newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+
+	w = s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		itm := skiplist.NewIntKeyItem(i)
+		got, _ := w.Lookup(itm)
+		if skiplist.CompareInt(itm, got) != 0 {
+			t.Fatalf("mismatch %d != %d", i, skiplist.IntFromItem(got))
+		}
+	}
+}
+
+func numDbInstances() (n int) {
+	buf := dbInstances.MakeBuf()
+	defer dbInstances.FreeBuf(buf)
+
+	itr := dbInstances.NewIterator(ComparePlasma, buf)
+	defer itr.Close()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		n++
+	}
+	return
+}
@@ -0,0 +1,156 @@
+package plasma
+
+import (
+	"bytes"
+	"context"
+	"github.com/couchbase/nitro/skiplist"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Invoke fn for the live items visible at sn within the key range
+// [lo, hi), in key order. A nil bound leaves that end of the range
+// unbounded. The items are only valid during the call.
+func (s *Plasma) foldItems(sn uint64, lo, hi []byte, fn func(itm unsafe.Pointer, ctx *wCtx) error) error {
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	loItm, hiItm, err := s.keyBoundItems(lo, hi)
+	if err != nil || hiItm == nil {
+		return err
+	}
+
+	codec := s.ItemCodec
+	callb := func(pid PageId, partn RangePartition) error {
+		tok := w.BeginTx()
+		defer w.EndTx(tok)
+
+		pg, err := s.ReadPage(pid, w.pgRdrFn, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		head := pg.(*page).head
+		if head == nil {
+			return nil
+		}
+
+		// Versions of a key are visited from the newest to the oldest and
+		// only the newest version visible at sn decides if the key is live
+		var lastKey []byte
+		var first = true
+		var sts pgOpIteratorStats
+		filter := &rollbackFilter{codec: codec}
+		it := newPgOpIterator(head, pg.(*page).cmp, nil, head.hiItm, filter, w.wCtx, &sts)
+		defer it.Close()
+
+		for it.Init(); it.Valid(); it.Next() {
+			itm := it.Get().Item()
+			if codec.Sn(itm) > sn {
+				continue
+			}
+
+			key := codec.Key(itm)
+			if !first && bytes.Equal(key, lastKey) {
+				continue
+			}
+
+			lastKey = append(lastKey[:0], key...)
+			first = false
+
+			if lo != nil && bytes.Compare(key, lo) < 0 {
+				continue
+			}
+
+			if hi != nil && bytes.Compare(key, hi) >= 0 {
+				continue
+			}
+
+			if codec.IsInsert(itm) {
+				if err := fn(itm, w.wCtx); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	opts := PageVisitorOptions{Ordered: true, MinKey: loItm, MaxKey: hiItm}
+	return s.PageVisitorWithOptions(context.Background(), callb, opts)
+}
+
+// Count the items visible at sn within the key range [lo, hi).
+// A nil bound leaves that end of the range unbounded.
+func (s *Plasma) countItems(sn uint64, lo, hi []byte) (int64, error) {
+	var count int64
+	err := s.foldItems(sn, lo, hi, func(unsafe.Pointer, *wCtx) error {
+		count++
+		return nil
+	})
+
+	return count, err
+}
+
+// Range bounds as items for the page visitor. A nil hiItm denotes an
+// empty range.
+func (s *Plasma) keyBoundItems(lo, hi []byte) (loItm, hiItm unsafe.Pointer, err error) {
+	loItm, hiItm = skiplist.MinItem, skiplist.MaxItem
+	if lo != nil && hi != nil && bytes.Compare(lo, hi) >= 0 {
+		return nil, nil, nil
+	}
+
+	if lo != nil {
+		if loItm, err = s.ItemCodec.NewItem(lo, nil, 0, nil, false, newBuffer(0)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if hi != nil {
+		if hiItm, err = s.ItemCodec.NewItem(hi, nil, 0, nil, false, newBuffer(0)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return loItm, hiItm, nil
+}
+
+// Count the items in the snapshot within the key range [lo, hi) by
+// walking the pages. A nil bound leaves that end of the range unbounded.
+func (sn *Snapshot) CountRange(lo, hi []byte) (int64, error) {
+	return sn.db.countItems(sn.sn, lo, hi)
+}
+
+// Fold function of Snapshot.Aggregate. The key and value refer to the page
+// memory and are only valid during the call.
+type AggregateFunc func(k, v []byte) error
+
+// Invoke agg for the items in the snapshot within the key range [lo, hi)
+// in key order, by walking the pages without an iterator. A nil bound
+// leaves that end of the range unbounded. The first error returned by agg
+// stops the walk and is returned.
+func (sn *Snapshot) Aggregate(lo, hi []byte, agg AggregateFunc) error {
+	s := sn.db
+	codec := s.ItemCodec
+	return s.foldItems(sn.sn, lo, hi, func(itm unsafe.Pointer, ctx *wCtx) error {
+		if err := s.verifyItem(itm, ctx); err != nil {
+			return err
+		}
+
+		return agg(codec.Key(itm), codec.Value(itm))
+	})
+}
+
+// The items count is not persisted, so it is rebuilt from the store
+// contents after recovery
+func (s *Plasma) recountItems() error {
+	count, err := s.countItems(s.currSn, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	s.itemsCountOff = count - s.sumItemsCounters()
+	s.itemsCount = count
+	atomic.StoreInt64(&s.currSnapshot.count, count)
+	return nil
+}
@@ -0,0 +1,343 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package crashtest simulates power cuts of a plasma store and verifies
+// what is recovered after them.
+//
+// A random workload of inserts and deletes with periodic recovery points is
+// run against a store using the in-memory LSS. Images of the log are
+// captured at write boundaries, with a random prefix of the last write
+// torn. Every image is reopened as a store and checked for the recovery
+// point guarantees:
+//
+//   - The latest recovery point is not older than the last one created
+//     before the crash, nor newer than the mutations issued before it.
+//   - Rolling back to the latest recovery point yields exactly the items of
+//     the snapshot it was created with.
+package crashtest
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/nitro/plasma"
+)
+
+type Config struct {
+	// Configuration of the store. File, InMemoryLSS and FaultInjector are
+	// set by the harness.
+	Plasma plasma.Config
+	// Name of the in-memory log. Crash images are named after it.
+	Path string
+	Seed int64
+
+	NumOps  int
+	NumKeys int
+	// Percentage of the mutations which are deletes
+	DeletePercent int
+	// Mutations between snapshots, every RecoveryPointInterval-th snapshot
+	// is made a recovery point. It should not be more than NumKeys.
+	SnapshotInterval      int
+	RecoveryPointInterval int
+
+	// An image is captured after every CrashInterval writes to the log, up
+	// to MaxCrashes images
+	CrashInterval int
+	MaxCrashes    int
+}
+
+func DefaultConfig() Config {
+	cfg := plasma.DefaultConfig()
+	cfg.FlushBufferSize = 64 * 1024
+
+	return Config{
+		Plasma:                cfg,
+		Path:                  "crashtest",
+		Seed:                  1,
+		NumOps:                20000,
+		NumKeys:               1000,
+		DeletePercent:         20,
+		SnapshotInterval:      100,
+		RecoveryPointInterval: 10,
+		CrashInterval:         5,
+		MaxCrashes:            20,
+	}
+}
+
+type Result struct {
+	Writes     int
+	Crashes    int
+	TornWrites int
+}
+
+// Crash image of the log
+type crashPoint struct {
+	id   int
+	path string
+	// Offset of the write and bytes of it which reached the log
+	offset, torn int64
+	size         int
+
+	// Mutations covered by the last recovery point and issued before
+	// the crash
+	durable, issued int
+}
+
+type version struct {
+	op  int
+	val []byte
+}
+
+type workload struct {
+	cfg Config
+	rnd *rand.Rand
+
+	// Versions of every key in the order they were written
+	history [][]version
+
+	issued  int64
+	durable int64
+
+	sync.Mutex
+	crashRnd *rand.Rand
+	writes   int
+	pending  *crashPoint
+	crashes  []*crashPoint
+	err      error
+}
+
+func keyName(k int) []byte {
+	return []byte(fmt.Sprintf("key-%10d", k))
+}
+
+// Run the workload and verify the store recovered from each crash image
+func Run(cfg Config) (Result, error) {
+	if cfg.NumKeys < cfg.SnapshotInterval {
+		return Result{}, fmt.Errorf("NumKeys (%d) should not be less than SnapshotInterval (%d)",
+			cfg.NumKeys, cfg.SnapshotInterval)
+	}
+
+	w := &workload{
+		cfg:      cfg,
+		rnd:      rand.New(rand.NewSource(cfg.Seed)),
+		crashRnd: rand.New(rand.NewSource(cfg.Seed + 1)),
+		history:  make([][]version, cfg.NumKeys),
+	}
+
+	plasma.RemoveInMemoryLSS(cfg.Path)
+	defer plasma.RemoveInMemoryLSS(cfg.Path)
+	defer func() {
+		for _, cp := range w.crashes {
+			plasma.RemoveInMemoryLSS(cp.path)
+		}
+	}()
+
+	err := w.run()
+	res := w.result()
+	if err != nil {
+		return res, err
+	}
+
+	for _, cp := range w.crashes {
+		if err := w.verify(cp); err != nil {
+			return res, fmt.Errorf("crash %d at offset %d (torn %d/%d): %v",
+				cp.id, cp.offset, cp.torn, cp.size, err)
+		}
+	}
+
+	return res, nil
+}
+
+func (w *workload) result() Result {
+	w.Lock()
+	defer w.Unlock()
+
+	res := Result{Writes: w.writes, Crashes: len(w.crashes)}
+	for _, cp := range w.crashes {
+		if cp.torn < int64(cp.size) {
+			res.TornWrites++
+		}
+	}
+
+	return res
+}
+
+func (w *workload) run() error {
+	cfg := w.cfg.Plasma
+	cfg.File = w.cfg.Path
+	cfg.InMemoryLSS = true
+	cfg.FaultInjector = plasma.FaultInjectorFunc(w.fault)
+
+	s, err := plasma.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Versions of a key are ordered by the snapshot sequence number, hence
+	// a key is mutated at most once between snapshots
+	var keys []int
+	wr := s.NewWriter()
+	for op := 1; op <= w.cfg.NumOps; op++ {
+		if (op-1)%w.cfg.SnapshotInterval == 0 {
+			keys = w.rnd.Perm(w.cfg.NumKeys)
+		}
+		k := keys[(op-1)%w.cfg.SnapshotInterval]
+		atomic.StoreInt64(&w.issued, int64(op))
+
+		// An update deletes the previous version before inserting the
+		// new one
+		var val []byte
+		exists := w.valueAt(k, op) != nil
+		if exists {
+			_, err = wr.DeleteKV(keyName(k))
+		}
+
+		if err == nil && (!exists || w.rnd.Intn(100) >= w.cfg.DeletePercent) {
+			val = []byte(strconv.Itoa(op))
+			_, err = wr.InsertKV(keyName(k), val)
+		}
+		w.history[k] = append(w.history[k], version{op: op, val: val})
+
+		if err != nil {
+			s.Close()
+			return err
+		}
+
+		if op%w.cfg.SnapshotInterval == 0 {
+			snap := s.NewSnapshot()
+			if op%(w.cfg.SnapshotInterval*w.cfg.RecoveryPointInterval) == 0 {
+				snap.Open()
+				if err := s.CreateRecoveryPoint(snap, []byte(strconv.Itoa(op))); err != nil {
+					s.Close()
+					return err
+				}
+				atomic.StoreInt64(&w.durable, int64(op))
+			}
+			snap.Close()
+		}
+	}
+
+	s.Close()
+
+	w.Lock()
+	defer w.Unlock()
+	return w.err
+}
+
+// Called before every write and sync of the log. The image of a crash
+// point is captured at the next write or sync, once the torn write has
+// reached the log and before it is committed.
+func (w *workload) fault(op plasma.IOOp, offset int64, size int) plasma.IOFault {
+	if op == plasma.IOOpRead {
+		return plasma.IOFault{}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if cp := w.pending; cp != nil {
+		w.pending = nil
+		cp.issued = int(atomic.LoadInt64(&w.issued))
+		if err := plasma.CrashInMemoryLSS(w.cfg.Path, cp.path, cp.offset+cp.torn); err != nil {
+			if w.err == nil {
+				w.err = err
+			}
+		} else {
+			w.crashes = append(w.crashes, cp)
+		}
+	}
+
+	if op != plasma.IOOpWrite {
+		return plasma.IOFault{}
+	}
+
+	w.writes++
+	if w.writes%w.cfg.CrashInterval == 0 && len(w.crashes) < w.cfg.MaxCrashes {
+		id := len(w.crashes)
+		w.pending = &crashPoint{
+			id:      id,
+			path:    fmt.Sprintf("%s.crash-%d", w.cfg.Path, id),
+			offset:  offset,
+			torn:    int64(w.crashRnd.Intn(size + 1)),
+			size:    size,
+			durable: int(atomic.LoadInt64(&w.durable)),
+		}
+	}
+
+	return plasma.IOFault{}
+}
+
+// Value of the key after the mutation op, nil if it does not exist
+func (w *workload) valueAt(k int, op int) []byte {
+	var val []byte
+	for _, v := range w.history[k] {
+		if v.op > op {
+			break
+		}
+		val = v.val
+	}
+
+	return val
+}
+
+func (w *workload) verify(cp *crashPoint) error {
+	cfg := w.cfg.Plasma
+	cfg.File = cp.path
+	cfg.InMemoryLSS = true
+
+	s, err := plasma.New(cfg)
+	if err != nil {
+		return fmt.Errorf("recovery failed: %v", err)
+	}
+	defer s.Close()
+
+	var rp *plasma.RecoveryPoint
+	rpOp := 0
+	if rps := s.GetRecoveryPoints(); len(rps) > 0 {
+		rp = rps[len(rps)-1]
+		rpOp, _ = strconv.Atoi(string(rp.Meta()))
+	}
+
+	if rpOp < cp.durable || rpOp > cp.issued {
+		return fmt.Errorf("recovery point %d is outside the durable range %d-%d",
+			rpOp, cp.durable, cp.issued)
+	}
+
+	if rp == nil {
+		return nil
+	}
+
+	snap, err := s.Rollback(rp)
+	if err != nil {
+		return fmt.Errorf("rollback to %d failed: %v", rpOp, err)
+	}
+	defer snap.Close()
+
+	itr := snap.NewIterator()
+	defer itr.Close()
+
+	found := make(map[string][]byte)
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		found[string(itr.Key())] = append([]byte(nil), itr.Value()...)
+	}
+
+	for k := 0; k < w.cfg.NumKeys; k++ {
+		expected := w.valueAt(k, rpOp)
+		val, ok := found[string(keyName(k))]
+		if ok != (expected != nil) || string(val) != string(expected) {
+			return fmt.Errorf("key %d has value %q after rollback to %d, expected %q",
+				k, val, rpOp, expected)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+package crashtest
+
+import (
+	"testing"
+)
+
+func TestCrashRecovery(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxCrashes = 10
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Crashes != cfg.MaxCrashes || res.TornWrites == 0 {
+		t.Errorf("expected %d crashes with torn writes, got %d crashes, %d torn (%d writes)",
+			cfg.MaxCrashes, res.Crashes, res.TornWrites, res.Writes)
+	}
+}
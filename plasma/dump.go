@@ -0,0 +1,139 @@
+package plasma
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"unicode/utf8"
+)
+
+// Options for dumping the items of a snapshot
+type DumpOptions struct {
+	// Range of keys [Start, End), nil is unbounded
+	Start, End []byte
+	// Maximum number of items dumped, zero is unlimited
+	Limit int
+	// Include the deletes visible in the snapshot
+	Deleted bool
+	// Write the values as JSON or text where possible, instead of base64.
+	// Only compact JSON values are written as JSON, as the values are
+	// written verbatim.
+	Typed bool
+}
+
+// Value types of the dump records
+const (
+	DumpBase64 = "base64"
+	DumpJSON   = "json"
+	DumpString = "string"
+)
+
+// A line of the snapshot dump. Value holds the base64 encoded bytes unless
+// Type is DumpJSON or DumpString, in which case it holds the JSON value or
+// the string. Deletes have no value.
+type DumpRecord struct {
+	Key     []byte          `json:"key"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Type    string          `json:"type,omitempty"`
+	Sn      uint64          `json:"sn"`
+	Deleted bool            `json:"deleted,omitempty"`
+}
+
+// Decoded bytes of the record value
+func (r *DumpRecord) Bytes() ([]byte, error) {
+	if r.Value == nil {
+		return nil, nil
+	}
+
+	switch r.Type {
+	case DumpJSON:
+		return []byte(r.Value), nil
+	case DumpString:
+		var v string
+		err := json.Unmarshal(r.Value, &v)
+		return []byte(v), err
+	default:
+		var v []byte
+		err := json.Unmarshal(r.Value, &v)
+		return v, err
+	}
+}
+
+func encodeDumpValue(v []byte, typed bool) (json.RawMessage, string, error) {
+	if typed {
+		if json.Valid(v) {
+			// Encoder compacts JSON values, which would alter the others
+			var buf bytes.Buffer
+			if json.Compact(&buf, v); bytes.Equal(buf.Bytes(), v) {
+				return v, DumpJSON, nil
+			}
+		} else if utf8.Valid(v) {
+			bs, err := json.Marshal(string(v))
+			return bs, DumpString, err
+		}
+	}
+
+	bs, err := json.Marshal(v)
+	return bs, DumpBase64, err
+}
+
+// Write the items of the snapshot in key order to w as newline delimited
+// JSON, one DumpRecord per line. It can be used to inspect a store or to
+// export its items.
+func (s *Snapshot) Dump(w io.Writer, opts DumpOptions) error {
+	itr := s.NewIterator()
+	defer itr.Close()
+
+	itr.filter.(*snFilter).deletes = opts.Deleted
+	if opts.Start == nil {
+		itr.SeekFirst()
+	} else {
+		itr.Seek(opts.Start)
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	codec := s.db.ItemCodec
+	for n := 0; itr.Valid(); itr.Next() {
+		if opts.Limit > 0 && n == opts.Limit {
+			break
+		}
+
+		itm := itr.Get()
+		k := codec.Key(itm)
+		if opts.End != nil && bytes.Compare(k, opts.End) >= 0 {
+			break
+		}
+
+		r := DumpRecord{
+			Key:     k,
+			Sn:      codec.Sn(itm),
+			Deleted: !codec.IsInsert(itm),
+		}
+
+		if !r.Deleted && codec.HasValue(itm) {
+			v := itr.Value()
+			if v == nil && itr.Err() != nil {
+				break
+			}
+
+			var err error
+			if r.Value, r.Type, err = encodeDumpValue(v, opts.Typed); err != nil {
+				return err
+			}
+		}
+
+		if err := enc.Encode(&r); err != nil {
+			return err
+		}
+		n++
+	}
+
+	if err := itr.Err(); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,135 @@
+package plasma
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func readDump(t *testing.T, buf *bytes.Buffer) []DumpRecord {
+	var recs []DumpRecord
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		var r DumpRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+		recs = append(recs, r)
+	}
+
+	return recs
+}
+
+func TestSnapshotDump(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	n := 1000
+	for i := 0; i < n; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", i)))
+	}
+	w.InsertKV([]byte("key-json"), []byte(`{"a":[1,"<b>"]}`))
+	w.InsertKV([]byte("key-json2"), []byte(`{"a": [1, 2]}`))
+	w.InsertKV([]byte("key-raw"), []byte{0, 0xff})
+
+	snap1 := s.NewSnapshot()
+	defer snap1.Close()
+
+	for i := 0; i < n; i += 2 {
+		w.DeleteKV([]byte(fmt.Sprintf("key-%10d", i)))
+	}
+
+	snap2 := s.NewSnapshot()
+	defer snap2.Close()
+
+	var buf bytes.Buffer
+	if err := snap1.Dump(&buf, DumpOptions{}); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	recs := readDump(t, &buf)
+	if len(recs) != n+3 {
+		t.Fatalf("Expected %d records, got %d", n+3, len(recs))
+	}
+
+	for i, r := range recs[:n] {
+		v, err := r.Bytes()
+		if k, exp := fmt.Sprintf("key-%10d", i), fmt.Sprintf("val-%d", i); string(r.Key) != k ||
+			string(v) != exp || err != nil || r.Type != DumpBase64 || r.Deleted || r.Sn == 0 || r.Sn > snap1.SeqNum() {
+			t.Fatalf("Expected %s:%s, got %+v, %v", k, exp, r, err)
+		}
+	}
+
+	// Range, limit and deletes
+	buf.Reset()
+	opts := DumpOptions{
+		Start:   []byte(fmt.Sprintf("key-%10d", 100)),
+		End:     []byte(fmt.Sprintf("key-%10d", 200)),
+		Deleted: true,
+	}
+	if err := snap2.Dump(&buf, opts); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	recs = readDump(t, &buf)
+	if len(recs) != 100 {
+		t.Fatalf("Expected 100 records, got %d", len(recs))
+	}
+
+	for i, r := range recs {
+		k := fmt.Sprintf("key-%10d", 100+i)
+		deleted := i%2 == 0
+		if string(r.Key) != k || r.Deleted != deleted || (r.Value == nil) != deleted {
+			t.Fatalf("Expected %s with deleted=%v, got %+v", k, deleted, r)
+		}
+
+		if deleted && r.Sn <= snap1.SeqNum() {
+			t.Fatalf("Expected delete after the first snapshot, got %+v", r)
+		}
+	}
+
+	buf.Reset()
+	opts.Deleted = false
+	opts.Limit = 10
+	if err := snap2.Dump(&buf, opts); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	if recs = readDump(t, &buf); len(recs) != 10 || string(recs[0].Key) != fmt.Sprintf("key-%10d", 101) {
+		t.Fatalf("Expected 10 records from key-101, got %+v", recs)
+	}
+
+	// Typed values
+	buf.Reset()
+	opts = DumpOptions{Start: []byte(fmt.Sprintf("key-%10d", n-1)), Typed: true}
+	if err := snap2.Dump(&buf, opts); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	recs = readDump(t, &buf)
+	exp := []struct {
+		typ, value string
+		bs         []byte
+	}{
+		{DumpString, fmt.Sprintf(`"val-%d"`, n-1), []byte(fmt.Sprintf("val-%d", n-1))},
+		{DumpJSON, `{"a":[1,"<b>"]}`, []byte(`{"a":[1,"<b>"]}`)},
+		{DumpBase64, `"eyJhIjogWzEsIDJdfQ=="`, []byte(`{"a": [1, 2]}`)},
+		{DumpBase64, `"AP8="`, []byte{0, 0xff}},
+	}
+
+	if len(recs) != len(exp) {
+		t.Fatalf("Expected %d records, got %d", len(exp), len(recs))
+	}
+
+	for i, r := range recs {
+		v, err := r.Bytes()
+		if r.Type != exp[i].typ || string(r.Value) != exp[i].value || !bytes.Equal(v, exp[i].bs) || err != nil {
+			t.Errorf("Expected %s value %s, got %+v, %v", exp[i].typ, exp[i].value, r, err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPlasmaEphemeral(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.Ephemeral = true
+	s := newTestIntPlasmaStore(cfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", i)))
+	}
+
+	if sz := s.GetStats().LSSDataSize; sz != 0 {
+		t.Errorf("expected no log writes before eviction, got %d", sz)
+	}
+
+	s.EvictAll()
+	if s.GetStats().LSSDataSize == 0 {
+		t.Errorf("expected evicted pages to be written")
+	}
+
+	for i := 0; i < 10000; i++ {
+		exp := fmt.Sprintf("val-%d", i)
+		if v, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i))); err != nil || string(v) != exp {
+			t.Fatalf("expected %s, got %s %v", exp, v, err)
+		}
+	}
+
+	s.Close()
+	if _, err := os.Stat("teststore.data"); !os.IsNotExist(err) {
+		t.Errorf("expected the store to be removed, got %v", err)
+	}
+
+	cfg.File = ""
+	if _, err := New(cfg); err == nil {
+		t.Errorf("expected an error for an ephemeral store without a file")
+	}
+}
@@ -0,0 +1,173 @@
+package plasma
+
+import (
+	"context"
+	"github.com/couchbase/nitro/skiplist"
+	"unsafe"
+)
+
+// Approximate size of a key range computed from the page metadata.
+// Pages partially overlapping the range are counted in full.
+type RangeSizeEstimate struct {
+	NumPages  int64
+	NumItems  int64
+	MemBytes  int64
+	DiskBytes int64
+}
+
+func pageNumItems(pg Page) int64 {
+	if head := pg.(*page).head; head != nil {
+		return int64(head.numItems)
+	}
+
+	return 0
+}
+
+// Estimate the size of the key range [lo, hi). skiplist.MinItem and
+// skiplist.MaxItem can be used as the range bounds.
+func (s *Plasma) EstimateSize(lo, hi unsafe.Pointer) (RangeSizeEstimate, error) {
+	var est RangeSizeEstimate
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	callb := func(pid PageId, partn RangePartition) error {
+		pg, err := s.ReadPage(pid, nil, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		est.NumPages++
+		est.NumItems += pageNumItems(pg)
+		est.MemBytes += int64(pg.ComputeMemUsed())
+		est.DiskBytes += int64(pg.GetFlushDataSize())
+		return nil
+	}
+
+	opts := PageVisitorOptions{Ordered: true, MinKey: lo, MaxKey: hi}
+	err := s.PageVisitorWithOptions(context.Background(), callb, opts)
+	return est, err
+}
+
+// Approximate space reclaimed by compacting a key range. The records of
+// the delta chains of a page are assumed to be garbage in proportion to
+// their share of the records of the page.
+type CompactionEstimate struct {
+	NumPages int64
+	// Pages with delta chains and the deltas consolidated by compaction
+	ChainedPages int64
+	NumDeltas    int64
+
+	// Memory of the resident pages and the log data of the pages released
+	// by compacting them
+	MemBytes  int64
+	DiskBytes int64
+
+	// Log space not referenced by any page, released by cleaning the whole
+	// log with Defragment
+	StaleLogBytes int64
+}
+
+func (s *Plasma) estimatePageCompaction(pg Page, est *CompactionEstimate) {
+	head := pg.(*page).head
+	if head == nil {
+		return
+	}
+
+	est.NumPages++
+	if head.chainLen == 0 {
+		return
+	}
+
+	est.ChainedPages++
+	est.NumDeltas += int64(head.chainLen)
+
+	records := int64(head.chainLen) + int64(head.numItems)
+	garbage := func(sz int) int64 {
+		return int64(sz) * int64(head.chainLen) / records
+	}
+
+	est.MemBytes += garbage(pg.ComputeMemUsed())
+	est.DiskBytes += garbage(pg.GetFlushDataSize())
+}
+
+// Estimate the space reclaimed by compacting the key range [lo, hi).
+// skiplist.MinItem and skiplist.MaxItem can be used as the range bounds.
+func (s *Plasma) EstimateCompaction(lo, hi unsafe.Pointer) (CompactionEstimate, error) {
+	var est CompactionEstimate
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	callb := func(pid PageId, partn RangePartition) error {
+		pg, err := s.ReadPage(pid, nil, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		s.estimatePageCompaction(pg, &est)
+		return nil
+	}
+
+	opts := PageVisitorOptions{Ordered: true, MinKey: lo, MaxKey: hi}
+	if err := s.PageVisitorWithOptions(context.Background(), callb, opts); err != nil {
+		return est, err
+	}
+
+	if s.shouldPersist {
+		if _, data, used := s.GetLSSInfo(); used > data {
+			est.StaleLogBytes = used - data
+		}
+	}
+
+	return est, nil
+}
+
+// Keys which split the store into n ranges with roughly equal number of
+// items. Fewer keys are returned if the store does not have enough pages.
+func (s *Plasma) GetRangeSplitKeys(n int) ([]unsafe.Pointer, error) {
+	type pageInfo struct {
+		minKey   unsafe.Pointer
+		numItems int64
+	}
+
+	var pages []pageInfo
+	var total int64
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	callb := func(pid PageId, partn RangePartition) error {
+		pg, err := s.ReadPage(pid, nil, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		numItems := pageNumItems(pg)
+		pages = append(pages, pageInfo{minKey: s.copyKey(pg.MinItem()), numItems: numItems})
+		total += numItems
+		return nil
+	}
+
+	opts := PageVisitorOptions{Ordered: true}
+	if err := s.PageVisitorWithOptions(context.Background(), callb, opts); err != nil {
+		return nil, err
+	}
+
+	var keys []unsafe.Pointer
+	var sum int64
+	for _, pi := range pages {
+		if len(keys) == n-1 {
+			break
+		}
+
+		// Start a new range once the current one has its share of items
+		if sum >= total*int64(len(keys)+1)/int64(n) && pi.minKey != skiplist.MinItem && sum > 0 {
+			keys = append(keys, pi.minKey)
+		}
+
+		sum += pi.numItems
+	}
+
+	return keys, nil
+}
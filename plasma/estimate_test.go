@@ -0,0 +1,110 @@
+package plasma
+
+import (
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+)
+
+func TestPlasmaEstimateSize(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	w.CompactAll()
+	s.PersistAll()
+
+	all, err := s.EstimateSize(skiplist.MinItem, skiplist.MaxItem)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if all.NumItems != 100000 || all.NumPages != s.GetStats().NumPages {
+		t.Errorf("unexpected estimate %+v", all)
+	}
+
+	if all.MemBytes == 0 || all.DiskBytes == 0 {
+		t.Errorf("expected non zero sizes %+v", all)
+	}
+
+	part, err := s.EstimateSize(skiplist.NewIntKeyItem(25000), skiplist.NewIntKeyItem(75000))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if part.NumItems < 50000 || part.NumItems > 60000 {
+		t.Errorf("unexpected estimate %+v", part)
+	}
+
+	keys, err := s.GetRangeSplitKeys(4)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 split keys, got %d", len(keys))
+	}
+
+	for i, k := range keys {
+		exp := (i + 1) * 25000
+		if v := skiplist.IntFromItem(k); v < exp-1000 || v > exp+1000 {
+			t.Errorf("expected split key close to %d, got %d", exp, v)
+		}
+	}
+}
+
+func TestPlasmaEstimateCompaction(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	w.CompactAll()
+	s.PersistAll()
+
+	est, err := s.EstimateCompaction(skiplist.MinItem, skiplist.MaxItem)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if est.NumPages != s.GetStats().NumPages || est.ChainedPages != 0 || est.MemBytes != 0 || est.DiskBytes != 0 {
+		t.Errorf("expected nothing to reclaim after compaction %+v", est)
+	}
+
+	for i := 25000; i < 50000; i++ {
+		w.Delete(skiplist.NewIntKeyItem(i))
+	}
+	s.PersistAll()
+
+	part, err := s.EstimateCompaction(skiplist.NewIntKeyItem(50000), skiplist.MaxItem)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Only the page at the boundary of the deleted range has deltas
+	if part.ChainedPages > 1 {
+		t.Errorf("expected the untouched range to have no deltas %+v", part)
+	}
+
+	est, err = s.EstimateCompaction(skiplist.MinItem, skiplist.MaxItem)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if est.ChainedPages == 0 || est.NumDeltas == 0 || est.MemBytes == 0 || est.DiskBytes == 0 || est.StaleLogBytes == 0 {
+		t.Errorf("expected space to reclaim after deletes %+v", est)
+	}
+
+	w.CompactAll()
+	s.PersistAll()
+	if est, _ = s.EstimateCompaction(skiplist.MinItem, skiplist.MaxItem); est.NumDeltas != 0 {
+		t.Errorf("expected no deltas after compaction %+v", est)
+	}
+}
@@ -0,0 +1,116 @@
+package plasma
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrInjectedFault = errors.New("injected IO fault")
+
+type IOOp int
+
+const (
+	IOOpRead IOOp = iota
+	IOOpWrite
+	IOOpSync
+)
+
+func (op IOOp) String() string {
+	switch op {
+	case IOOpRead:
+		return "read"
+	case IOOpWrite:
+		return "write"
+	case IOOpSync:
+		return "sync"
+	}
+
+	return "unknown"
+}
+
+// Fault applied to an IO operation on the log
+type IOFault struct {
+	Delay time.Duration
+	Err   error
+	// A failed write persists ShortWrite bytes before returning Err. The
+	// write is retried by the store from where it failed.
+	ShortWrite int
+}
+
+// FaultInjector is intended for tests. It is consulted before every read,
+// write and sync of the log. A failed sync leaves the log as of the
+// previous sync, which is what is recovered after a crash.
+type FaultInjector interface {
+	Fault(op IOOp, offset int64, size int) IOFault
+}
+
+type FaultInjectorFunc func(op IOOp, offset int64, size int) IOFault
+
+func (f FaultInjectorFunc) Fault(op IOOp, offset int64, size int) IOFault {
+	return f(op, offset, size)
+}
+
+type faultLog struct {
+	Log
+	inj FaultInjector
+
+	sync.Mutex
+	// Bytes of the failed write which have been persisted
+	written int
+}
+
+func newFaultLog(l Log, inj FaultInjector) *faultLog {
+	return &faultLog{Log: l, inj: inj}
+}
+
+func (l *faultLog) fault(op IOOp, offset int64, size int) error {
+	f := l.inj.Fault(op, offset, size)
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+
+	return f.Err
+}
+
+func (l *faultLog) Read(bs []byte, off int64) error {
+	if err := l.fault(IOOpRead, off, len(bs)); err != nil {
+		return err
+	}
+
+	return l.Log.Read(bs, off)
+}
+
+func (l *faultLog) Append(bs []byte) error {
+	l.Lock()
+	defer l.Unlock()
+
+	// Retry of a short write
+	bs = bs[l.written:]
+	f := l.inj.Fault(IOOpWrite, l.Log.Tail(), len(bs))
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+
+	if f.Err == nil {
+		l.written = 0
+		return l.Log.Append(bs)
+	}
+
+	if n := f.ShortWrite; n > 0 && n < len(bs) {
+		if err := l.Log.Append(bs[:n]); err != nil {
+			return err
+		}
+		l.written += n
+	}
+
+	return f.Err
+}
+
+func (l *faultLog) Commit() error {
+	if err := l.fault(IOOpSync, l.Log.Tail(), 0); err != nil {
+		return err
+	}
+
+	return l.Log.Commit()
+}
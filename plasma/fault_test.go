@@ -0,0 +1,91 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPlasmaFaultInjection(t *testing.T) {
+	os.RemoveAll("teststore.data")
+
+	var failReads, failSyncs, shortWrites int32
+	var numShortWrites, numFailedSyncs int32
+	inj := func(op IOOp, offset int64, size int) IOFault {
+		switch op {
+		case IOOpRead:
+			if atomic.LoadInt32(&failReads) == 1 {
+				return IOFault{Err: ErrInjectedFault}
+			}
+		case IOOpWrite:
+			if size > 100 && atomic.CompareAndSwapInt32(&shortWrites, 1, 0) {
+				atomic.AddInt32(&numShortWrites, 1)
+				return IOFault{Err: ErrInjectedFault, ShortWrite: size / 2}
+			}
+		case IOOpSync:
+			if atomic.LoadInt32(&failSyncs) == 1 {
+				atomic.AddInt32(&numFailedSyncs, 1)
+				return IOFault{Err: ErrInjectedFault}
+			}
+		}
+
+		return IOFault{}
+	}
+
+	cfg := DefaultConfig()
+	cfg.File = "teststore.data"
+	cfg.AutoLSSCleaning = false
+	cfg.FaultInjector = FaultInjectorFunc(inj)
+	s := newTestIntPlasmaStore(cfg)
+
+	n := 10000
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%10d", i))
+	}
+
+	// A torn write is completed by the retry
+	atomic.StoreInt32(&shortWrites, 1)
+	w := s.NewWriter()
+	for i := 0; i < n; i++ {
+		w.InsertKV(key(i), key(i))
+	}
+	s.EvictAll()
+	s.PersistAll()
+
+	if atomic.LoadInt32(&numShortWrites) != 1 {
+		t.Errorf("expected a short write")
+	}
+
+	atomic.StoreInt32(&failReads, 1)
+	buf := newBuffer(0)
+	if _, err := s.lss.Read(s.lss.HeadOffset(), buf); err != ErrInjectedFault {
+		t.Errorf("expected %v, got %v", ErrInjectedFault, err)
+	}
+	atomic.StoreInt32(&failReads, 0)
+
+	// Writes which are never synced are lost on a crash
+	atomic.StoreInt32(&failSyncs, 1)
+	for i := n; i < 2*n; i++ {
+		w.InsertKV(key(i), key(i))
+	}
+	s.Close()
+
+	if atomic.LoadInt32(&numFailedSyncs) == 0 {
+		t.Errorf("expected failed syncs")
+	}
+
+	atomic.StoreInt32(&failSyncs, 0)
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w = s.NewWriter()
+	for i := 0; i < 2*n; i++ {
+		v, err := w.LookupKV(key(i))
+		if i < n && (err != nil || string(v) != string(key(i))) {
+			t.Errorf("expected value for %d, got %s (err=%v)", i, string(v), err)
+		} else if i >= n && err != ErrItemNotFound {
+			t.Errorf("expected %d to be lost, got err=%v", i, err)
+		}
+	}
+}
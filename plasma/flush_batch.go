@@ -0,0 +1,122 @@
+package plasma
+
+// Page update records written by PersistAll which are small enough are
+// batched and written with a single reservation of the log. Each record
+// remains a separate block, so that pages refer to their own records.
+type flushBatch struct {
+	data    []byte
+	entries []flushBatchEntry
+	token   TxToken
+}
+
+type flushBatchEntry struct {
+	pid         PageId
+	pg          Page
+	start, end  int
+	dataSz      int
+	staleFdSz   int
+	numSegments int
+}
+
+func (b *flushBatch) size() int {
+	return len(b.data) + len(b.entries)*(lssBlockTypeSize+headerFBSize)
+}
+
+func (b *flushBatch) reset() {
+	b.data = b.data[:0]
+	for i := range b.entries {
+		b.entries[i] = flushBatchEntry{}
+	}
+	b.entries = b.entries[:0]
+}
+
+// Persist the page, deferring the write of a small page update record
+// until the batch of ctx is flushed
+func (s *Plasma) persistCoalesced(pid PageId, ctx *wCtx) {
+	b := ctx.flushBatch
+	if b == nil {
+		b = new(flushBatch)
+		ctx.flushBatch = b
+	}
+
+	if len(b.entries) == 0 {
+		b.token = ctx.BeginTx()
+	}
+
+	buf := ctx.GetBuffer(bufPersist)
+	pg, _ := s.ReadPage(pid, nil, false, ctx)
+	if !pg.NeedsFlush() {
+		s.endFlushBatch(ctx)
+		return
+	}
+
+	bs, dataSz, staleFdSz, numSegments := pg.Marshal(buf, s.maxPageSegments(pg))
+	if pgFlushLSSType(pg, numSegments) != lssPageUpdate || len(bs) > s.FlushCoalesceSize/4 {
+		if !s.writePage(pid, pg, bs, dataSz, staleFdSz, numSegments, false, ctx) {
+			s.Persist(pid, false, ctx)
+		}
+		s.endFlushBatch(ctx)
+		return
+	}
+
+	start := len(b.data)
+	b.data = append(b.data, bs...)
+	b.entries = append(b.entries, flushBatchEntry{
+		pid:         pid,
+		pg:          pg,
+		start:       start,
+		end:         len(b.data),
+		dataSz:      dataSz,
+		staleFdSz:   staleFdSz,
+		numSegments: numSegments,
+	})
+
+	if b.size() >= s.FlushCoalesceSize {
+		s.flushBatch(ctx)
+	}
+}
+
+func (s *Plasma) endFlushBatch(ctx *wCtx) {
+	if b := ctx.flushBatch; b != nil && len(b.entries) == 0 {
+		ctx.EndTx(b.token)
+		b.token = nil
+	}
+}
+
+// Write the records of the batch of ctx. Pages modified since their
+// records were marshalled are persisted again on their own.
+func (s *Plasma) flushBatch(ctx *wCtx) {
+	b := ctx.flushBatch
+	if b == nil || len(b.entries) == 0 {
+		return
+	}
+
+	sizes := make([]int, len(b.entries))
+	for i, e := range b.entries {
+		sizes[i] = lssBlockTypeSize + e.end - e.start
+	}
+
+	var conflicts []PageId
+	offsets, wbufs, res := s.lss.ReserveSpaceMulti(sizes)
+	for i, e := range b.entries {
+		writeLSSBlock(wbufs[i], lssPageUpdate, b.data[e.start:e.end])
+		e.pg.AddFlushRecord(offsets[i], e.dataSz, e.numSegments)
+		if s.UpdateMapping(e.pid, e.pg, ctx) {
+			ctx.sts.FlushDataSz += int64(e.dataSz) - int64(e.staleFdSz)
+		} else {
+			discardLSSBlock(wbufs[i])
+			conflicts = append(conflicts, e.pid)
+		}
+	}
+	s.lss.FinalizeWrite(res)
+
+	ctx.sts.CoalescedFlushes++
+	ctx.sts.CoalescedPages += int64(len(b.entries) - len(conflicts))
+	b.reset()
+
+	for _, pid := range conflicts {
+		s.Persist(pid, false, ctx)
+	}
+
+	s.endFlushBatch(ctx)
+}
@@ -0,0 +1,54 @@
+package plasma
+
+import (
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+)
+
+func TestPlasmaFlushCoalescing(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testCfg
+	cfg.FlushCoalesceSize = 64 * 1024
+	s := newTestIntPlasmaStore(cfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i += 2 {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	s.PersistAll()
+
+	// A few items in every page
+	for r := 1; r < 10; r += 2 {
+		for i := r; i < 100000; i += 200 {
+			w.Insert(skiplist.NewIntKeyItem(i))
+		}
+		s.PersistAll()
+	}
+
+	sts := s.GetStats()
+	if sts.CoalescedFlushes == 0 || sts.CoalescedPages < 4*sts.CoalescedFlushes {
+		t.Errorf("expected page updates to be coalesced, got %d pages in %d writes",
+			sts.CoalescedPages, sts.CoalescedFlushes)
+	}
+	s.Close()
+
+	cfg.FlushCoalesceSize = 0
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w = s.NewWriter()
+	var count int
+	for i := 0; i < 100000; i++ {
+		exp := i%2 == 0 || (i%200 < 10)
+		if got, _ := w.Lookup(skiplist.NewIntKeyItem(i)); (got != nil) != exp {
+			t.Fatalf("item %d: expected found %v", i, exp)
+		} else if exp {
+			count++
+		}
+	}
+
+	if count != 50000+5*500 {
+		t.Errorf("unexpected count %d", count)
+	}
+}
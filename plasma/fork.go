@@ -0,0 +1,58 @@
+package plasma
+
+import (
+	"errors"
+	"os"
+)
+
+var ErrForkNotSupported = errors.New("forking requires a store with a local file backed log")
+var ErrForkExists = errors.New("fork path already exists")
+
+// Open a copy of the store in path. The copy shares the log segments written
+// so far with the store, and writes to segments of its own from then on.
+// Items written concurrently with Fork may be left out of the copy, which
+// gets an UUID of its own.
+func (s *Plasma) Fork(path string) (*Plasma, error) {
+	if !s.shouldPersist || s.InMemoryLSS || s.coldTier != nil {
+		return nil, ErrForkNotSupported
+	}
+
+	ml, ok := s.lss.(*lsStore).log.(*multiFilelog)
+	if !ok {
+		return nil, ErrForkNotSupported
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return nil, ErrForkExists
+	}
+
+	if s.EnableShapshots {
+		s.NewSnapshot().Close()
+	}
+
+	s.PersistAll()
+	s.lss.Sync(true)
+	if err := ml.fork(path); err != nil {
+		os.RemoveAll(path)
+		return nil, err
+	}
+
+	cfg := s.openCfg
+	cfg.File = path
+	fs, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = fs.deleteStoreMeta(storeMetaUUID); err == nil {
+		err = fs.initUUID()
+	}
+
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+
+	fs.lss.Sync(true)
+	return fs, nil
+}
@@ -0,0 +1,87 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPlasmaFork(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	os.RemoveAll("teststore_fork.data")
+	defer os.RemoveAll("teststore_fork.data")
+
+	cfg := testSnCfg
+	cfg.LSSLogSegmentSize = 64 * 1024
+	cfg.FlushBufferSize = 64 * 1024
+	s := newTestIntPlasmaStore(cfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("parent"))
+	}
+
+	fs, err := s.Fork("teststore_fork.data")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := s.Fork("teststore_fork.data"); err != ErrForkExists {
+		t.Errorf("expected fork exists error, got %v", err)
+	}
+
+	if s.UUID() == fs.UUID() {
+		t.Errorf("expected the fork to have an UUID of its own")
+	}
+
+	fw := fs.NewWriter()
+	for i := 10000; i < 20000; i++ {
+		fw.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("fork"))
+	}
+
+	for i := 20000; i < 30000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("parent"))
+	}
+
+	verify := func(s *Plasma, exp func(i int) string) {
+		w := s.NewWriter()
+		for i := 0; i < 30000; i++ {
+			v, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i)))
+			if e := exp(i); e == "" && err != ErrItemNotFound {
+				t.Fatalf("expected item %d not to be found, got %s %v", i, v, err)
+			} else if e != "" && (err != nil || string(v) != e) {
+				t.Fatalf("expected %s for item %d, got %s %v", e, i, v, err)
+			}
+		}
+	}
+
+	expParent := func(i int) string {
+		if i < 10000 || i >= 20000 {
+			return "parent"
+		}
+		return ""
+	}
+
+	expFork := func(i int) string {
+		if i < 10000 {
+			return "parent"
+		} else if i < 20000 {
+			return "fork"
+		}
+		return ""
+	}
+
+	verify(s, expParent)
+	verify(fs, expFork)
+	s.Close()
+	fs.Close()
+
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+	verify(s, expParent)
+
+	cfg.File = "teststore_fork.data"
+	fs = newTestIntPlasmaStore(cfg)
+	defer fs.Close()
+	verify(fs, expFork)
+}
@@ -0,0 +1,131 @@
+package plasma
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrSnGarbageCollected = errors.New("sequence number is older than the GC watermark")
+
+// Sequence numbers pinned by reads which are not backed by a Snapshot.
+// Versions visible at a pinned sequence number are not garbage collected.
+type snPins struct {
+	sync.Mutex
+	n   int32
+	sns map[uint64]int
+}
+
+// Snapshots with sequence numbers up to GCSn are closed. Item versions
+// shadowed by a newer version created at or before GCSn+1 are garbage
+// and are removed when their page is compacted.
+func (s *Plasma) GCSn() uint64 {
+	return atomic.LoadUint64(&s.gcSn)
+}
+
+// Sequence number of the oldest snapshot which is still referenced
+func (s *Plasma) OldestActiveSnapshot() uint64 {
+	return s.GCSn() + 1
+}
+
+func (s *Plasma) pinSn(sn uint64) error {
+	p := &s.snPins
+	p.Lock()
+	defer p.Unlock()
+
+	// Pinning is visible to the compactors before the watermark is checked
+	atomic.AddInt32(&p.n, 1)
+	if sn < s.OldestActiveSnapshot() {
+		atomic.AddInt32(&p.n, -1)
+		return ErrSnGarbageCollected
+	}
+
+	if p.sns == nil {
+		p.sns = make(map[uint64]int)
+	}
+	p.sns[sn]++
+	return nil
+}
+
+func (s *Plasma) unpinSn(sn uint64) {
+	p := &s.snPins
+	p.Lock()
+	defer p.Unlock()
+
+	if p.sns[sn]--; p.sns[sn] == 0 {
+		delete(p.sns, sn)
+	}
+	atomic.AddInt32(&p.n, -1)
+}
+
+// GCSn held back by the pinned sequence numbers
+func (s *Plasma) gcWatermark() uint64 {
+	gcSn := s.GCSn()
+	p := &s.snPins
+	if atomic.LoadInt32(&p.n) == 0 {
+		return gcSn
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	for sn := range p.sns {
+		if sn <= gcSn {
+			gcSn = sn - 1
+		}
+	}
+
+	return gcSn
+}
+
+// ForceGC compacts every page, so that garbage versions are removed
+// immediately instead of during the next compaction triggered by writes.
+// Compacted pages are persisted, so that the log space used by the garbage
+// can be reclaimed by the log cleaner. Pages compacted before ctx is done
+// remain compacted.
+func (s *Plasma) ForceGC(ctx context.Context) error {
+	n := s.numPersistorThreads()
+	writers := make([]*Writer, n)
+	for i := range writers {
+		writers[i] = s.AcquireWriter()
+	}
+
+	defer func() {
+		for _, w := range writers {
+			s.ReleaseWriter(w)
+		}
+	}()
+
+	callb := func(pid PageId, partn RangePartition) error {
+		w := writers[partn.Shard].wCtx
+	retry:
+		pg, err := s.ReadPage(pid, w.pgRdrFn, false, w)
+		if err != nil {
+			return err
+		}
+
+		head := pg.(*page).head
+		if head == nil {
+			return nil
+		}
+
+		evicted := head.state.IsEvicted()
+		if !s.compactPage(pid, pg, w) {
+			goto retry
+		}
+
+		// Evicted pages are written back and evicted again
+		if s.shouldPersist {
+			s.Persist(pid, evicted, w)
+		}
+
+		return nil
+	}
+
+	err := s.PageVisitorContext(ctx, callb, n)
+	if s.shouldPersist {
+		s.lss.Sync(false)
+	}
+
+	return err
+}
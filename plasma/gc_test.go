@@ -0,0 +1,151 @@
+package plasma
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPlasmaForceGC(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.MaxDeltaChainLen = 1000000
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for v := 0; v < 5; v++ {
+		snap := s.NewSnapshot()
+		if snap.sn != s.OldestActiveSnapshot() {
+			t.Errorf("expected oldest snapshot %d, got %d", snap.sn, s.OldestActiveSnapshot())
+		}
+		snap.Close()
+
+		for i := 0; i < 2000; i++ {
+			w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", v)))
+		}
+
+		if v < 2 {
+			s.NewSnapshot().Close()
+		}
+	}
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+
+	if s.GCSn() != snap.sn-1 {
+		t.Errorf("expected gc sn %d, got %d", snap.sn-1, s.GCSn())
+	}
+
+	before := s.GetStats().MemSz
+	if err := s.ForceGC(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if after := s.GetStats().MemSz; after >= before {
+		t.Errorf("expected memory usage to reduce, before %d after %d", before, after)
+	}
+
+	for i := 0; i < 2000; i++ {
+		v, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i)))
+		if err != nil || string(v) != "val-4" {
+			t.Errorf("expected val-4, got %s (%v)", v, err)
+		}
+	}
+}
+
+func TestPlasmaGCSnAfterRecovery(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("val"))
+	}
+	s.NewSnapshot().Close()
+	s.PersistAll()
+	s.Close()
+
+	s = newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	for v := 0; v < 3; v++ {
+		snap := s.NewSnapshot()
+		if s.GCSn() != snap.sn-1 {
+			t.Errorf("expected gc sn %d, got %d", snap.sn-1, s.GCSn())
+		}
+		snap.Close()
+	}
+}
+
+func TestPlasmaReadsAtSn(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	n := 1000
+	w := s.NewWriter()
+	for v := 0; v < 3; v++ {
+		for i := 0; i < n; i++ {
+			k := []byte(fmt.Sprintf("key-%10d", i))
+			if v > 0 {
+				w.DeleteKV(k)
+			}
+			w.InsertKV(k, []byte(fmt.Sprintf("val-%d", v)))
+		}
+
+		if v < 2 {
+			s.NewSnapshot().Close()
+		}
+	}
+
+	snap := s.NewSnapshot()
+	sn := snap.SeqNum()
+	for i := 0; i < n; i++ {
+		w.DeleteKV([]byte(fmt.Sprintf("key-%10d", i)))
+	}
+	s.NewSnapshot().Close()
+
+	k := []byte(fmt.Sprintf("key-%10d", 10))
+	if v, err := s.GetAt(k, sn); err != nil || string(v) != "val-2" {
+		t.Errorf("expected val-2, got %s (%v)", v, err)
+	}
+
+	if _, err := s.GetAt(k, s.CurrentSeqNum()); err != ErrItemNotFound {
+		t.Errorf("expected deleted item, got %v", err)
+	}
+
+	itr, err := s.NewIteratorAt(sn)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Versions visible to the iterator survive garbage collection
+	snap.Close()
+	for i := 0; i < 3; i++ {
+		s.NewSnapshot().Close()
+	}
+	s.ForceGC(context.Background())
+
+	count := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if string(itr.Value()) != "val-2" {
+			t.Errorf("expected val-2, got %s", itr.Value())
+		}
+		count++
+	}
+	itr.Close()
+
+	if count != n {
+		t.Errorf("expected %d items, got %d", n, count)
+	}
+
+	if _, err := s.GetAt(k, sn); err != ErrSnGarbageCollected {
+		t.Errorf("expected gc error, got %v", err)
+	}
+
+	if _, err := s.NewIteratorAt(sn); err != ErrSnGarbageCollected {
+		t.Errorf("expected gc error, got %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package plasma
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	workerMinBackoff = time.Millisecond * 100
+	workerMaxBackoff = time.Second * 10
+)
+
+type EventType int
+
+const (
+	// A background worker panicked and is restarted after a backoff
+	EventWorkerPanic EventType = iota
+	EventWorkerRestart
+	// A background worker did not report progress within WorkerStallTimeout
+	EventWorkerStalled
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventWorkerPanic:
+		return "worker_panic"
+	case EventWorkerRestart:
+		return "worker_restart"
+	case EventWorkerStalled:
+		return "worker_stalled"
+	}
+
+	return "unknown"
+}
+
+type Event struct {
+	Type   EventType
+	Worker string
+	Err    error
+	Stack  string
+}
+
+// Status of a background worker reported by WorkerHealth
+type WorkerStatus struct {
+	Name          string
+	Role          string
+	Shard         string
+	LastHeartbeat time.Time
+	Panics        int64
+	Restarts      int64
+	Running       bool
+	Stalled       bool
+
+	// What the worker is currently doing and since when
+	Activity      string
+	ActivitySince time.Time
+}
+
+// Live background workers of an instance reported by Introspect
+type Introspection struct {
+	Instance          string
+	Workers           []WorkerStatus
+	CompactionQueue   int
+	PrefetchQueue     int
+	MemoryInUse       int64
+	HasMemoryPressure bool
+}
+
+type workerActivity struct {
+	what  string
+	since time.Time
+}
+
+type workerState struct {
+	name      string
+	role      string
+	shard     string
+	clock     Clock
+	sched     Scheduler
+	lastBeat  int64
+	deadline  int64
+	panics    int64
+	running   int32
+	stalled   int32
+	restarted int64
+	activity  atomic.Value
+}
+
+// Workers named role-N run the shard N of the role
+func splitWorkerName(name string) (role, shard string) {
+	if i := strings.LastIndexByte(name, '-'); i > 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			return name[:i], name[i+1:]
+		}
+	}
+
+	return name, ""
+}
+
+func (ws *workerState) doing(what string) {
+	if a, ok := ws.activity.Load().(*workerActivity); ok && a.what == what {
+		return
+	}
+
+	ws.activity.Store(&workerActivity{what: what, since: ws.clock.Now()})
+}
+
+func (ws *workerState) beat() {
+	if ws.sched != nil {
+		ws.sched.Schedule(ws.name)
+	}
+
+	now := ws.clock.Now().UnixNano()
+	atomic.StoreInt64(&ws.lastBeat, now)
+	atomic.StoreInt64(&ws.deadline, now)
+}
+
+// The worker is going to wait for d before the next heartbeat
+func (ws *workerState) wait(d time.Duration) {
+	atomic.StoreInt64(&ws.deadline, ws.clock.Now().UnixNano()+int64(d))
+	ws.doing("sleeping")
+}
+
+// The worker is waiting for work without a time bound
+func (ws *workerState) idle() {
+	atomic.StoreInt64(&ws.deadline, math.MaxInt64)
+	ws.doing("idle")
+}
+
+type workerRegistry struct {
+	sync.Mutex
+	workers map[string]*workerState
+}
+
+func (s *Plasma) emitEvent(e Event) {
+	if s.EventListener != nil {
+		s.EventListener(e)
+	}
+}
+
+func (s *Plasma) registerWorker(name string) *workerState {
+	s.workers.Lock()
+	defer s.workers.Unlock()
+
+	if s.workers.workers == nil {
+		s.workers.workers = make(map[string]*workerState)
+	}
+
+	ws, ok := s.workers.workers[name]
+	if !ok {
+		ws = &workerState{name: name, clock: s.Clock, sched: s.Scheduler}
+		ws.role, ws.shard = splitWorkerName(name)
+		ws.doing("starting")
+		s.workers.workers[name] = ws
+	}
+
+	return ws
+}
+
+// Run fn until it returns. A panic in fn is reported through the event
+// listener and fn is restarted after a backoff, which doubles for every
+// consecutive panic.
+func (s *Plasma) superviseWorker(name string, fn func(ws *workerState)) {
+	ws := s.registerWorker(name)
+	atomic.StoreInt32(&ws.running, 1)
+	defer atomic.StoreInt32(&ws.running, 0)
+
+	backoff := workerMinBackoff
+	for {
+		start := s.Clock.Now()
+		ws.beat()
+		if !s.runWorker(ws, fn) {
+			return
+		}
+
+		if s.Clock.Now().Sub(start) > workerMaxBackoff {
+			backoff = workerMinBackoff
+		}
+
+		s.Clock.Sleep(backoff)
+		if backoff *= 2; backoff > workerMaxBackoff {
+			backoff = workerMaxBackoff
+		}
+
+		atomic.AddInt64(&ws.restarted, 1)
+		s.emitEvent(Event{Type: EventWorkerRestart, Worker: name})
+	}
+}
+
+// Returns true if fn panicked
+func (s *Plasma) runWorker(ws *workerState, fn func(ws *workerState)) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			stack := make([]byte, txTokenStackSize)
+			stack = stack[:runtime.Stack(stack, false)]
+			atomic.AddInt64(&ws.panics, 1)
+			fmt.Printf("Plasma: Worker %s panicked: %v\n%s\n", ws.name, r, stack)
+			s.emitEvent(Event{
+				Type:   EventWorkerPanic,
+				Worker: ws.name,
+				Err:    fmt.Errorf("%v", r),
+				Stack:  string(stack),
+			})
+		}
+	}()
+
+	pprof.Do(context.Background(), s.workerLabels(ws.role, ws.shard), func(context.Context) {
+		fn(ws)
+	})
+	return false
+}
+
+// Labels of the goroutines of a background worker in cpu and goroutine
+// profiles
+func (s *Plasma) workerLabels(role, shard string) pprof.LabelSet {
+	if shard == "" {
+		return pprof.Labels("plasma", s.instance, "role", role)
+	}
+
+	return pprof.Labels("plasma", s.instance, "role", role, "shard", shard)
+}
+
+func (ws *workerState) status() WorkerStatus {
+	st := WorkerStatus{
+		Name:          ws.name,
+		Role:          ws.role,
+		Shard:         ws.shard,
+		LastHeartbeat: time.Unix(0, atomic.LoadInt64(&ws.lastBeat)),
+		Panics:        atomic.LoadInt64(&ws.panics),
+		Restarts:      atomic.LoadInt64(&ws.restarted),
+		Running:       atomic.LoadInt32(&ws.running) > 0,
+		Stalled:       atomic.LoadInt32(&ws.stalled) == 1,
+	}
+
+	if a, ok := ws.activity.Load().(*workerActivity); ok {
+		st.Activity, st.ActivitySince = a.what, a.since
+	}
+
+	return st
+}
+
+func (s *Plasma) WorkerHealth() []WorkerStatus {
+	var sts []WorkerStatus
+
+	s.workers.Lock()
+	for _, ws := range s.workers.workers {
+		sts = append(sts, ws.status())
+	}
+	s.workers.Unlock()
+
+	sort.Slice(sts, func(i, j int) bool {
+		return sts[i].Name < sts[j].Name
+	})
+
+	return sts
+}
+
+// The running background workers with their current activity
+func (s *Plasma) Introspect() Introspection {
+	r := Introspection{
+		Instance:          s.instance,
+		CompactionQueue:   s.CompactionQueueLen(),
+		MemoryInUse:       s.MemoryInUse(),
+		HasMemoryPressure: s.hasMemoryPressure,
+	}
+
+	if q := s.prefetchQ; q != nil {
+		r.PrefetchQueue = len(q.reqs)
+	}
+
+	for _, st := range s.WorkerHealth() {
+		if st.Running {
+			r.Workers = append(r.Workers, st)
+		}
+	}
+
+	return r
+}
+
+func (s *Plasma) checkWorkers() {
+	var stalledWorkers []string
+	now := s.Clock.Now().UnixNano()
+
+	s.workers.Lock()
+	for _, ws := range s.workers.workers {
+		if atomic.LoadInt32(&ws.running) == 0 {
+			continue
+		}
+
+		stalled := now-atomic.LoadInt64(&ws.deadline) > int64(s.WorkerStallTimeout)
+		if stalled && atomic.CompareAndSwapInt32(&ws.stalled, 0, 1) {
+			stalledWorkers = append(stalledWorkers, ws.name)
+		} else if !stalled {
+			atomic.StoreInt32(&ws.stalled, 0)
+		}
+	}
+	s.workers.Unlock()
+
+	for _, name := range stalledWorkers {
+		fmt.Printf("Plasma: Worker %s has not made progress for %v\n", name, s.WorkerStallTimeout)
+		s.emitEvent(Event{Type: EventWorkerStalled, Worker: name})
+	}
+}
+
+func (s *Plasma) workerHealthMonitor() {
+	interval := s.WorkerStallTimeout / 4
+	if interval < time.Millisecond*10 {
+		interval = time.Millisecond * 10
+	}
+
+	for {
+		select {
+		case <-s.stopmon:
+			return
+		case <-s.Clock.After(interval):
+		}
+
+		s.checkWorkers()
+	}
+}
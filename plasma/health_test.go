@@ -0,0 +1,126 @@
+package plasma
+
+import (
+	"bytes"
+	"os"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerSupervision(t *testing.T) {
+	var mu sync.Mutex
+	var events []Event
+
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.WorkerStallTimeout = time.Millisecond * 200
+	cfg.EventListener = func(e Event) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	waitEvent := func(typ EventType) bool {
+		for i := 0; i < 100; i++ {
+			mu.Lock()
+			for _, e := range events {
+				if e.Type == typ && e.Worker == "test" {
+					mu.Unlock()
+					return true
+				}
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond * 20)
+		}
+		return false
+	}
+
+	runs := 0
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.superviseWorker("test", func(ws *workerState) {
+			if runs++; runs == 1 {
+				panic("worker failure")
+			}
+			<-stop
+		})
+	}()
+
+	if !waitEvent(EventWorkerPanic) || !waitEvent(EventWorkerRestart) {
+		t.Fatalf("expected panic and restart events, got %v", events)
+	}
+
+	// The restarted worker does not beat
+	if !waitEvent(EventWorkerStalled) {
+		t.Errorf("expected stalled event, got %v", events)
+	}
+
+	close(stop)
+	<-done
+
+	found := map[string]WorkerStatus{}
+	for _, ws := range s.WorkerHealth() {
+		found[ws.Name] = ws
+	}
+
+	if ws := found["test"]; ws.Panics != 1 || ws.Restarts != 1 || ws.Running {
+		t.Errorf("unexpected worker status %+v", ws)
+	}
+
+	for _, name := range []string{"memory_monitor", "runtime_stats", "lss_cleaner"} {
+		if ws, ok := found[name]; !ok || !ws.Running || ws.Stalled {
+			t.Errorf("unexpected %s status %+v", name, ws)
+		}
+	}
+}
+
+func TestPlasmaIntrospect(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.NumCompactorThreads = 2
+	cfg.WorkerStallTimeout = time.Second
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	// Workers are started in the background
+	for i := 0; i < 100 && len(s.Introspect().Workers) < 6; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	s.PersistAll()
+
+	r := s.Introspect()
+	if r.Instance != "teststore.data" {
+		t.Errorf("unexpected instance %s", r.Instance)
+	}
+
+	workers := make(map[string]WorkerStatus)
+	for _, st := range r.Workers {
+		workers[st.Name] = st
+	}
+
+	if st, ok := workers["compactor-1"]; !ok || st.Role != "compactor" || st.Shard != "1" || st.Activity != "idle" {
+		t.Errorf("unexpected compactor status %+v", st)
+	}
+
+	if st, ok := workers["memory_monitor"]; !ok || st.Role != "memory_monitor" || st.Shard != "" {
+		t.Errorf("unexpected memory monitor status %+v", st)
+	}
+
+	if st, ok := workers["persistor"]; ok {
+		t.Errorf("expected the persistor to have finished, got %+v", st)
+	}
+
+	var b bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&b, 1)
+	if !strings.Contains(b.String(), `"role":"compactor"`) || !strings.Contains(b.String(), `"shard":"1"`) {
+		t.Errorf("expected labelled compactor goroutines")
+	}
+}
@@ -0,0 +1,90 @@
+package plasma
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Passes all the item versions of a page and keeps the ranges of sequence
+// numbers discarded by the rollbacks applied to it
+type historyFilter struct {
+	rollbackFilter
+}
+
+func (f *historyFilter) Process(o PageItem) PageItemsList {
+	return o
+}
+
+func (f *historyFilter) isRolledBack(itm unsafe.Pointer) bool {
+	sn := f.codec.Sn(itm)
+	for _, filter := range f.filters {
+		if sn >= filter.start && sn <= filter.end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Iterator over all the item versions retained in the pages, newest first
+// for a key, including the deletes and the versions discarded by rollbacks
+// which have not been purged by compaction yet. Meant for auditing, it does
+// not hold back garbage collection.
+type HistoryIterator struct {
+	*Iterator
+	token TxToken
+}
+
+func (s *Plasma) NewHistoryIterator() *HistoryIterator {
+	itr := s.NewIterator().(*Iterator)
+	itr.filter = &historyFilter{rollbackFilter{codec: s.ItemCodec}}
+
+	return &HistoryIterator{
+		token:    itr.BeginTx(),
+		Iterator: itr,
+	}
+}
+
+// Position at the newest version of the first key >= k
+func (itr *HistoryIterator) Seek(k []byte) {
+	sn := atomic.LoadUint64(&itr.store.currSn)
+	kbuf := itr.Iterator.GetBuffer(bufTempItem)
+	itm, _ := itr.store.ItemCodec.NewItem(k, nil, sn, nil, false, kbuf)
+	itr.Iterator.Seek(itm)
+}
+
+func (itr *HistoryIterator) Key() []byte {
+	return itr.store.ItemCodec.Key(itr.Get())
+}
+
+// Returns nil and sets the error returned by Err if the item is corrupt
+func (itr *HistoryIterator) Value() []byte {
+	if err := itr.store.verifyItem(itr.Get(), itr.wCtx); err != nil {
+		itr.err = err
+		return nil
+	}
+
+	return itr.store.ItemCodec.Value(itr.Get())
+}
+
+func (itr *HistoryIterator) Err() error {
+	return itr.err
+}
+
+func (itr *HistoryIterator) SeqNum() uint64 {
+	return itr.store.ItemCodec.Sn(itr.Get())
+}
+
+func (itr *HistoryIterator) IsDelete() bool {
+	return !itr.store.ItemCodec.IsInsert(itr.Get())
+}
+
+// Check whether the version was discarded by a rollback
+func (itr *HistoryIterator) RolledBack() bool {
+	return itr.filter.(*historyFilter).isRolledBack(itr.Get())
+}
+
+func (itr *HistoryIterator) Close() {
+	itr.Iterator.Close()
+	itr.EndTx(itr.token)
+}
@@ -0,0 +1,71 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPlasmaHistoryIterator(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%10d", i))
+	}
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV(key(i), []byte("v1"))
+	}
+
+	snap := s.NewSnapshot()
+	s.CreateRecoveryPoint(snap, nil)
+	snap.Close()
+
+	for i := 0; i < 1000; i++ {
+		if i%10 == 0 {
+			w.DeleteKV(key(i))
+		} else if i%10 == 1 {
+			w.InsertKV(key(i), []byte("v2"))
+		}
+	}
+	s.NewSnapshot().Close()
+
+	rollSn, err := s.Rollback(s.GetRecoveryPoints()[0])
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	rollSn.Close()
+
+	w.InsertKV(key(2), []byte("v3"))
+	s.NewSnapshot().Close()
+
+	itr := s.NewHistoryIterator()
+	defer itr.Close()
+
+	var versions, rolledBack, deletes int
+	for itr.Seek(key(0)); itr.Valid(); itr.Next() {
+		versions++
+		if itr.RolledBack() {
+			rolledBack++
+			if itr.IsDelete() {
+				deletes++
+			} else if string(itr.Value()) != "v2" {
+				t.Errorf("unexpected rolled back value %s for %s", itr.Value(), itr.Key())
+			}
+		} else if v := string(itr.Value()); v != "v1" && !(v == "v3" && string(itr.Key()) == string(key(2))) {
+			t.Errorf("unexpected value %s for %s", v, itr.Key())
+		}
+	}
+
+	if versions != 1201 || rolledBack != 200 || deletes != 100 {
+		t.Errorf("unexpected versions %d, rolled back %d, deletes %d", versions, rolledBack, deletes)
+	}
+
+	itr.Seek(key(1))
+	if !itr.Valid() || !itr.RolledBack() || itr.SeqNum() <= s.GetRecoveryPoints()[0].SeqNum() {
+		t.Errorf("expected the rolled back version of %s first", key(1))
+	}
+}
@@ -0,0 +1,129 @@
+package plasma
+
+import (
+	"github.com/couchbase/nitro/skiplist"
+	"math"
+	"time"
+	"unsafe"
+)
+
+// When the keyspace is far larger than memory, the index nodes of the
+// evicted pages dominate the memory used. Index eviction merges runs of
+// adjacent evicted pages into a single page in the lss, which releases
+// their index nodes. A merged page larger than MaxPageItems is split again
+// once it is swapped in, rebuilding the index of the range on demand.
+
+const (
+	indexEvictorInterval = time.Second
+
+	// Index memory usage is checked after every batch of visited pages
+	indexEvictBatchSize = 64
+
+	// A merged page holds up to indexEvictMaxFanout times MaxPageItems items
+	indexEvictMaxFanout = 16
+)
+
+func isSwappedOut(pg Page) bool {
+	head := pg.(*page).head
+	return head != nil && head.op == opSwapoutDelta
+}
+
+// Merge the page following pid into it if both of them are swapped out.
+// Returns the page to be visited next.
+func (s *Plasma) tryEvictIndexNode(pid PageId, maxItems int, ctx *wCtx) (PageId, bool) {
+	pg, _ := s.ReadPage(pid, nil, false, ctx)
+	next := pg.Next()
+	if !isSwappedOut(pg) || next == s.EndPageId() {
+		return next, false
+	}
+
+	npg, _ := s.ReadPage(next, nil, false, ctx)
+	if !isSwappedOut(npg) || !s.isMergablePage(next, ctx) {
+		return next, false
+	}
+
+	numItems := int(pg.(*page).head.numItems) + int(npg.(*page).head.numItems)
+	if numItems > maxItems {
+		return next, false
+	}
+
+	npg.Close()
+	if !s.UpdateMapping(next, npg, ctx) {
+		ctx.sts.MergeConflicts++
+		return pid, false
+	}
+
+	s.tryPageRemoval(next, npg, ctx)
+	ctx.sts.Merges++
+	ctx.sts.IndexEvictions++
+
+	// The merged page was written to the lss by the page removal
+	s.Persist(pid, true, ctx)
+	return pid, true
+}
+
+// Merge runs of adjacent evicted pages until the memory used by the page
+// index is within target or all the pages have been visited. Returns the
+// number of index nodes released.
+func (s *Plasma) EvictIndex(target int64) int {
+	if !s.shouldPersist {
+		return 0
+	}
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+	ctx := w.wCtx
+
+	maxItems := s.liveConfig().MaxPageItems * indexEvictMaxFanout
+	if maxItems > math.MaxUint16 {
+		maxItems = math.MaxUint16
+	}
+
+	var evicted int
+	var key unsafe.Pointer = skiplist.MinItem
+	for done := false; !done && s.GetStats().MemSzIndex > target; {
+		tok := ctx.BeginTx()
+		pid := s.StartPageId()
+		if key != skiplist.MinItem {
+			if prev, curr, found := s.Skiplist.Lookup(key, s.cmp, ctx.buf, ctx.slSts); found {
+				pid = curr
+			} else {
+				pid = prev
+			}
+		}
+
+		for i := 0; i < indexEvictBatchSize; i++ {
+			next, ok := s.tryEvictIndexNode(pid, maxItems, ctx)
+			if ok {
+				evicted++
+			}
+
+			if done = next == s.EndPageId(); done {
+				break
+			}
+
+			pid = next
+		}
+
+		key = s.copyKey(pid.(*skiplist.Node).Item())
+		ctx.EndTx(tok)
+		s.trySMRObjects(ctx, swapperSMRInterval)
+	}
+
+	return evicted
+}
+
+func (s *Plasma) indexEvictorDaemon(ws *workerState) {
+	for {
+		ws.beat()
+		ws.wait(indexEvictorInterval)
+		select {
+		case <-s.stopmon:
+			return
+		case <-s.Clock.After(indexEvictorInterval):
+		}
+
+		ws.doing("evicting index")
+		s.EvictIndex(s.IndexMemoryQuota)
+	}
+}
@@ -0,0 +1,79 @@
+package plasma
+
+import (
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+)
+
+func TestPlasmaIndexEviction(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testCfg
+	cfg.MaxPageItems = 20
+	cfg.MinPageItems = 5
+	s := newTestIntPlasmaStore(cfg)
+
+	n := 20000
+	w := s.NewWriter()
+	for i := 0; i < n; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+
+	s.PersistAll()
+	s.EvictAll()
+
+	before := s.GetStats()
+	if evicted := s.EvictIndex(0); evicted == 0 {
+		t.Fatalf("expected index nodes to be evicted")
+	}
+
+	after := s.GetStats()
+	if after.MemSzIndex > before.MemSzIndex/4 {
+		t.Errorf("expected index memory to drop, before %d after %d", before.MemSzIndex, after.MemSzIndex)
+	}
+
+	if after.IndexEvictions == 0 || after.NumPages >= before.NumPages {
+		t.Errorf("expected fewer pages, before %d after %d", before.NumPages, after.NumPages)
+	}
+
+	itr := s.NewIterator()
+	count := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if got := skiplist.IntFromItem(itr.Get()); got != count {
+			t.Fatalf("expected %d, got %d", count, got)
+		}
+		count++
+	}
+
+	if count != n {
+		t.Errorf("expected %d items, got %d", n, count)
+	}
+
+	// Accessed pages are split again
+	for i := 0; i < n; i++ {
+		itm := skiplist.NewIntKeyItem(i)
+		if got, _ := w.Lookup(itm); got == nil || skiplist.CompareInt(itm, got) != 0 {
+			t.Fatalf("lookup failed for %d", i)
+		}
+	}
+
+	if np := s.GetStats().NumPages; np <= after.NumPages {
+		t.Errorf("expected pages to be split on access, got %d pages", np)
+	}
+
+	s.EvictAll()
+	s.EvictIndex(0)
+	s.PersistAll()
+	s.Close()
+
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w = s.NewWriter()
+	for i := 0; i < n; i++ {
+		itm := skiplist.NewIntKeyItem(i)
+		if got, _ := w.Lookup(itm); got == nil || skiplist.CompareInt(itm, got) != 0 {
+			t.Fatalf("lookup failed for %d after recovery", i)
+		}
+	}
+}
@@ -11,17 +11,21 @@ import (
 // TODO: Cleanup the current ugly-hackup implementation
 
 // Layout for the item is as follows:
-// [    32 bit header                    ][opt 32 bit keylen][opt key][64 bit sn][opt val]
-// [insert bit][val bit][ptr key bit][len]
+// [    32 bit header                               ][opt 32 bit keylen][opt key][64 bit sn][opt 64 bit meta][opt val]
+// [insert bit][val bit][ptr key bit][meta bit][len]
+// The 28 bit len limits the key and value to 256MB together. It was 29 bits
+// before the meta bit, see storeFormatVersion.
 
 const (
-	itmInsertFlag = 0x80000000
-	itmHasValFlag = 0x40000000
-	itmPtrKeyFlag = 0x20000000
-	itmLenMask    = 0x1fffffff
-	itmHdrLen     = 4
-	itmSnSize     = 8
-	itmKlenSize   = 4
+	itmInsertFlag  = 0x80000000
+	itmHasValFlag  = 0x40000000
+	itmPtrKeyFlag  = 0x20000000
+	itmHasMetaFlag = 0x10000000
+	itmLenMask     = 0x0fffffff
+	itmHdrLen      = 4
+	itmSnSize      = 8
+	itmKlenSize    = 4
+	itmMetaSize    = 8
 )
 
 const (
@@ -33,7 +37,7 @@ const (
 type item uint32
 
 func (itm *item) Size() int {
-	sz := itm.l() + itmHdrLen + itmSnSize
+	sz := itm.ActualSize()
 	if *itm&itmPtrKeyFlag > 0 {
 		itm = itm.getPtrKeyItem()
 		_, klen := itm.k()
@@ -44,7 +48,7 @@ func (itm *item) Size() int {
 }
 
 func (itm *item) ActualSize() int {
-	return itm.l() + itmHdrLen + itmSnSize
+	return itm.l() + itmHdrLen + itmSnSize + itm.metaSize()
 }
 
 func (itm *item) IsInsert() bool {
@@ -73,6 +77,29 @@ func (itm *item) Sn() uint64 {
 	return *(*uint64)(unsafe.Pointer(kptr + uintptr(klen)))
 }
 
+func (itm *item) HasMeta() bool {
+	return itmHasMetaFlag&*itm > 0
+}
+
+// Application defined metadata stored along with the item.
+// Items written without metadata return zero.
+func (itm *item) Meta() uint64 {
+	if !itm.HasMeta() {
+		return 0
+	}
+
+	kptr, klen := itm.k()
+	return *(*uint64)(unsafe.Pointer(kptr + uintptr(klen) + itmSnSize))
+}
+
+func (itm *item) metaSize() int {
+	if itm.HasMeta() {
+		return itmMetaSize
+	}
+
+	return 0
+}
+
 func (itm *item) l() int {
 	return int(itmLenMask & *itm)
 }
@@ -117,13 +144,45 @@ func (itm *item) Value() (bs []byte) {
 	l := itm.l()
 
 	sh := (*reflect.SliceHeader)(unsafe.Pointer(&bs))
-	sh.Data = kptr + uintptr(klen) + itmSnSize
+	sh.Data = kptr + uintptr(klen) + itmSnSize + uintptr(itm.metaSize())
 	sh.Len = l - klen - itmKlenSize
 	sh.Cap = sh.Len
 	return
 }
 
+// Size of the item encoded at the start of data. Items are always written
+// to the log with the key inline.
+func itemDataSize(data []byte) (int, bool) {
+	if len(data) < itmHdrLen+itmSnSize {
+		return 0, false
+	}
+
+	itm := (*item)(unsafe.Pointer(&data[0]))
+	if *itm&itmPtrKeyFlag > 0 {
+		return 0, false
+	}
+
+	if itm.HasValue() {
+		if itm.l() < itmKlenSize || len(data) < itmHdrLen+itmKlenSize {
+			return 0, false
+		}
+
+		if _, klen := itm.k(); klen > itm.l()-itmKlenSize {
+			return 0, false
+		}
+	}
+
+	l := itm.ActualSize()
+	return l, l <= len(data)
+}
+
 func newItem(k, v []byte, sn uint64, del bool, buf *Buffer) (
+	*item, error) {
+	return newItemMeta(k, v, sn, nil, del, buf)
+}
+
+// Create an item carrying metadata, if meta is not nil
+func newItemMeta(k, v []byte, sn uint64, meta *uint64, del bool, buf *Buffer) (
 	*item, error) {
 	if len(k) > itmLenMask {
 		return nil, ErrKeyTooLarge
@@ -131,12 +190,19 @@ func newItem(k, v []byte, sn uint64, del bool, buf *Buffer) (
 
 	kl := len(k)
 	vl := len(v)
+	if vl > 0 && kl+vl+itmKlenSize > itmLenMask {
+		return nil, ErrValueTooLarge
+	}
 
 	sz := uintptr(itmHdrLen + itmSnSize + kl + vl)
 	if vl > 0 {
 		sz += itmKlenSize
 	}
 
+	if meta != nil {
+		sz += itmMetaSize
+	}
+
 	var ptr unsafe.Pointer
 	if buf == nil {
 		b := make([]byte, sz)
@@ -145,11 +211,12 @@ func newItem(k, v []byte, sn uint64, del bool, buf *Buffer) (
 		buf.Grow(0, int(sz))
 		ptr = buf.Ptr(0)
 	}
-	newItem2(k, v, sn, del, false, ptr)
+	newItem2(k, v, sn, meta, del, false, ptr)
 	return (*item)(ptr), nil
 }
 
-func newItem2(k, v []byte, sn uint64, del bool, ptrKey bool, ptr unsafe.Pointer) {
+func newItem2(k, v []byte, sn uint64, meta *uint64, del bool, ptrKey bool, ptr unsafe.Pointer) {
+	var msz uintptr
 
 	kl := len(k)
 	vl := len(v)
@@ -164,6 +231,11 @@ func newItem2(k, v []byte, sn uint64, del bool, ptrKey bool, ptr unsafe.Pointer)
 		*hdr |= itmPtrKeyFlag
 	}
 
+	if meta != nil {
+		*hdr |= itmHasMetaFlag
+		msz = itmMetaSize
+	}
+
 	if vl > 0 {
 		*hdr |= itmHasValFlag | uint32(vl+kl+itmKlenSize)
 		klen := (*uint32)(unsafe.Pointer(uintptr(ptr) + itmHdrLen))
@@ -171,13 +243,19 @@ func newItem2(k, v []byte, sn uint64, del bool, ptrKey bool, ptr unsafe.Pointer)
 
 		snp := (*uint64)(unsafe.Pointer(uintptr(ptr) + uintptr(itmHdrLen+itmKlenSize+kl)))
 		*snp = sn
+		if meta != nil {
+			*(*uint64)(unsafe.Pointer(uintptr(unsafe.Pointer(snp)) + itmSnSize)) = *meta
+		}
 		if kl > 0 {
 			memcopy(unsafe.Pointer(uintptr(ptr)+itmHdrLen+itmKlenSize), unsafe.Pointer(&k[0]), kl)
 		}
-		memcopy(unsafe.Pointer(uintptr(ptr)+itmHdrLen+itmKlenSize+itmSnSize+uintptr(kl)), unsafe.Pointer(&v[0]), vl)
+		memcopy(unsafe.Pointer(uintptr(ptr)+itmHdrLen+itmKlenSize+itmSnSize+msz+uintptr(kl)), unsafe.Pointer(&v[0]), vl)
 	} else {
 		snp := (*uint64)(unsafe.Pointer(uintptr(ptr) + uintptr(itmHdrLen+kl)))
 		*snp = sn
+		if meta != nil {
+			*(*uint64)(unsafe.Pointer(uintptr(unsafe.Pointer(snp)) + itmSnSize)) = *meta
+		}
 		*hdr |= uint32(kl)
 		if kl > 0 {
 			memcopy(unsafe.Pointer(uintptr(ptr)+itmHdrLen), unsafe.Pointer(&k[0]), kl)
@@ -212,7 +290,7 @@ func itemStringer(itm unsafe.Pointer) string {
 	if x.HasValue() {
 		v = string(x.Value())
 	}
-	return fmt.Sprintf("item key:%s val:%s sn:%d insert: %v", string(x.Key()), v, x.Sn(), x.IsInsert())
+	return fmt.Sprintf("item key:%s val:%s sn:%d meta:%d insert: %v", string(x.Key()), v, x.Sn(), x.Meta(), x.IsInsert())
 }
 
 func copyItem(a, b unsafe.Pointer, sz int) {
@@ -232,7 +310,7 @@ func encodePtrKeyItem(dstItm, srcItm unsafe.Pointer) {
 	sn := itm.Sn()
 	del := !itm.IsInsert()
 
-	newItem2(nil, v, sn, del, true, dstItm)
+	newItem2(nil, v, sn, itm.metaPtr(), del, true, dstItm)
 }
 
 func copyPtrKeyItem(dstItm, srcItm unsafe.Pointer) {
@@ -245,7 +323,16 @@ func copyPtrKeyItem(dstItm, srcItm unsafe.Pointer) {
 	sn := itm.Sn()
 	del := !itm.IsInsert()
 
-	newItem2(k, v, sn, del, false, dstItm)
+	newItem2(k, v, sn, itm.metaPtr(), del, false, dstItm)
+}
+
+func (itm *item) metaPtr() *uint64 {
+	if !itm.HasMeta() {
+		return nil
+	}
+
+	meta := itm.Meta()
+	return &meta
 }
 
 func copyItemRun(srcItms, dstItms []unsafe.Pointer, data unsafe.Pointer) {
@@ -36,3 +36,43 @@ func TestCompressItemRun(t *testing.T) {
 		}
 	}
 }
+
+func TestItemMeta(t *testing.T) {
+	buf := newBuffer(0)
+	var itms []unsafe.Pointer
+	var expected []string
+	for i := 0; i < 10; i++ {
+		var v []byte
+		if i%2 == 0 {
+			v = []byte(fmt.Sprintf("v-%d", i))
+		}
+
+		meta := uint64(i) << 40
+		x, _ := newItemMeta([]byte("key"), v, 1000, &meta, false, buf)
+		if x.Meta() != meta || x.Sn() != 1000 || (x.HasValue() && string(x.Value()) != string(v)) {
+			t.Fatalf("invalid item %s", itemStringer(unsafe.Pointer(x)))
+		}
+
+		b := make([]byte, x.Size())
+		itmPtr := unsafe.Pointer(&b[0])
+		memcopy(itmPtr, unsafe.Pointer(x), x.Size())
+		itms = append(itms, itmPtr)
+		expected = append(expected, itemStringer(itmPtr))
+	}
+
+	dst := make([]unsafe.Pointer, 10)
+	sz := itemRunSize(itms)
+	bbuf := make([]byte, sz)
+	copyItemRun(itms, dst, unsafe.Pointer(&bbuf[0]))
+
+	for i, itm := range dst {
+		if s := itemStringer(itm); s != expected[i] {
+			t.Errorf("Expected: '%s', got: '%s'", expected[i], s)
+		}
+	}
+
+	x, _ := newItem([]byte("key"), []byte("val"), 1, false, buf)
+	if x.HasMeta() || x.Meta() != 0 {
+		t.Errorf("expected item without metadata")
+	}
+}
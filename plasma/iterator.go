@@ -59,6 +59,12 @@ type Iterator struct {
 	currPgItr pgOpIterator
 	filter    ItemFilter
 
+	// Sequential scan prefetching
+	prefetch int
+	seqPages int
+	pfAhead  int
+	pfCold   bool
+
 	err error
 }
 
@@ -70,11 +76,38 @@ func (s *Plasma) NewIterator() ItemIterator {
 	}
 }
 
+// Swap in up to n pages ahead of the cursor once the iterator moves across
+// pages sequentially and misses the cache. Zero disables prefetching.
+func (itr *Iterator) SetPrefetch(n int) {
+	itr.prefetch = n
+}
+
+func (itr *Iterator) resetPrefetch() {
+	itr.seqPages = 0
+	itr.pfAhead = 0
+	itr.pfCold = false
+}
+
+// Queue the pages which are not ahead of the cursor yet, once half of the
+// queued pages have been visited
+func (itr *Iterator) tryPrefetch() {
+	if itr.prefetch <= 0 || !itr.pfCold || itr.seqPages < prefetchSeqPages {
+		return
+	}
+
+	if itr.pfAhead <= itr.prefetch/2 {
+		if itr.store.queuePrefetch(itr.currPid, itr.pfAhead, itr.prefetch-itr.pfAhead) {
+			itr.pfAhead = itr.prefetch
+		}
+	}
+}
+
 func (itr *Iterator) initPgIterator(pid PageId, seekItm unsafe.Pointer) {
 	itr.currPid = pid
 	itr.nr = itr.sts.NumLSSReads
 	if pgPtr, err := itr.store.ReadPage(pid, itr.wCtx.pgRdrFn, true, itr.wCtx); err == nil {
 		itr.store.updateCacheMeta(pid)
+		itr.store.tryQueueCompaction(pid, pgPtr)
 		pg := pgPtr.(*page)
 		if err == nil {
 			if pg.IsEmpty() {
@@ -100,6 +133,7 @@ func (itr *Iterator) Close() {
 }
 
 func (itr *Iterator) SeekFirst() error {
+	itr.resetPrefetch()
 	itr.initPgIterator(itr.store.Skiplist.HeadNode(), nil)
 	itr.tryNextPg()
 	return itr.err
@@ -114,6 +148,7 @@ func (itr *Iterator) Seek(itm unsafe.Pointer) error {
 		pid = prev
 	}
 
+	itr.resetPrefetch()
 	itr.initPgIterator(pid, itm)
 	itr.tryNextPg()
 	return itr.err
@@ -133,6 +168,7 @@ func (itr *Iterator) tryNextPg() {
 		itr.currPgItr.Close()
 		if itr.sts.NumLSSReads-itr.nr > 0 {
 			itr.sts.CacheMisses++
+			itr.pfCold = true
 		} else {
 			itr.sts.CacheHits++
 		}
@@ -141,6 +177,15 @@ func (itr *Iterator) tryNextPg() {
 			break
 		}
 		itr.initPgIterator(itr.nextPid, nil)
+		itr.seqPages++
+		if itr.pfAhead > 0 {
+			itr.pfAhead--
+		}
+		itr.tryPrefetch()
+	}
+
+	if itr.Valid() {
+		itr.sts.BytesOutgoing += int64(itr.itemSize(itr.Get()))
 	}
 }
 
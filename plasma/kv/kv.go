@@ -0,0 +1,185 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package kv provides a simple key value API over Plasma. Methods of DB
+// can be called by any goroutine, as Plasma writers are managed by DB and
+// iterators hold their own snapshots.
+//
+//	db, err := kv.Open("data")
+//	db.Set([]byte("key"), []byte("value"))
+//	v, err := db.Get([]byte("key"))
+//	db.Close()
+package kv
+
+import (
+	"context"
+	"sync"
+
+	"github.com/couchbase/nitro/plasma"
+)
+
+// ErrNotFound is returned by Get for the keys which do not exist
+var ErrNotFound = plasma.ErrItemNotFound
+
+// DB is a key value store persisted into a Plasma instance
+type DB struct {
+	s *plasma.Plasma
+
+	// Idle writers, which are reused by the calls
+	sync.Mutex
+	writers []*plasma.Writer
+}
+
+// Open opens or creates the store in the file by using the default
+// Plasma configuration
+func Open(file string) (*DB, error) {
+	cfg := plasma.DefaultConfig()
+	cfg.File = file
+	return OpenWithConfig(cfg)
+}
+
+// OpenWithConfig opens or creates the store by using the configuration
+func OpenWithConfig(cfg plasma.Config) (*DB, error) {
+	s, err := plasma.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{s: s}, nil
+}
+
+func (db *DB) getWriter() *plasma.Writer {
+	db.Lock()
+	defer db.Unlock()
+
+	if n := len(db.writers); n > 0 {
+		w := db.writers[n-1]
+		db.writers = db.writers[:n-1]
+		return w
+	}
+
+	return db.s.NewWriter()
+}
+
+func (db *DB) putWriter(w *plasma.Writer) {
+	db.Lock()
+	defer db.Unlock()
+	db.writers = append(db.writers, w)
+}
+
+// Get returns a copy of the value of the key, or ErrNotFound
+func (db *DB) Get(k []byte) ([]byte, error) {
+	w := db.getWriter()
+	defer db.putWriter(w)
+
+	v, err := w.LookupKV(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), v...), nil
+}
+
+// Has returns true if the key exists
+func (db *DB) Has(k []byte) (bool, error) {
+	_, err := db.Get(k)
+	if err == ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Set inserts or replaces the value of the key
+func (db *DB) Set(k, v []byte) error {
+	w := db.getWriter()
+	defer db.putWriter(w)
+
+	_, err := w.ReplaceKV(k, v)
+	return err
+}
+
+// Delete removes the key. Deleting a missing key is not an error.
+func (db *DB) Delete(k []byte) error {
+	w := db.getWriter()
+	defer db.putWriter(w)
+
+	_, err := w.DeleteKV(k)
+	return err
+}
+
+// Sync persists the items written so far, so that they are recovered
+// when the store is opened again
+func (db *DB) Sync() error {
+	return db.s.PersistAllContext(context.Background())
+}
+
+// Close persists the items and closes the store. Iterators should be
+// closed beforehand.
+func (db *DB) Close() error {
+	err := db.Sync()
+	db.s.Close()
+	return err
+}
+
+// Iterator iterates over a snapshot of the store in the key order. The key
+// and the value returned are valid until the iterator is moved.
+type Iterator struct {
+	itr *plasma.MVCCIterator
+}
+
+// NewIterator creates an iterator over the items present in the store
+// currently. The iterator should be closed once done.
+func (db *DB) NewIterator() *Iterator {
+	snap := db.s.NewSnapshot()
+	defer snap.Close()
+
+	return &Iterator{itr: snap.NewIterator()}
+}
+
+// First moves the iterator to the first item
+func (it *Iterator) First() {
+	it.itr.SeekFirst()
+}
+
+// Seek moves the iterator to the first key greater than or equal to k
+func (it *Iterator) Seek(k []byte) {
+	it.itr.Seek(k)
+}
+
+// Valid returns false once the iterator has moved past the last item or
+// has failed
+func (it *Iterator) Valid() bool {
+	return it.itr.Err() == nil && it.itr.Valid()
+}
+
+// Next moves the iterator to the next item
+func (it *Iterator) Next() {
+	it.itr.Next()
+}
+
+// Key returns the key of the current item
+func (it *Iterator) Key() []byte {
+	return it.itr.Key()
+}
+
+// Value returns the value of the current item
+func (it *Iterator) Value() []byte {
+	return it.itr.Value()
+}
+
+// Err returns the error which made the iterator invalid, if any
+func (it *Iterator) Err() error {
+	return it.itr.Err()
+}
+
+// Close releases the iterator and its snapshot
+func (it *Iterator) Close() {
+	it.itr.Close()
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package kv
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestKVOps(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	defer os.RemoveAll("teststore.data")
+
+	db, err := Open("teststore.data")
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	var wg sync.WaitGroup
+	n, thr := 10000, 4
+	for x := 0; x < thr; x++ {
+		wg.Add(1)
+		go func(x int) {
+			defer wg.Done()
+			for i := x; i < n; i += thr {
+				db.Set([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", i)))
+				if i%2 == 1 {
+					db.Delete([]byte(fmt.Sprintf("key-%10d", i)))
+				}
+			}
+		}(x)
+	}
+	wg.Wait()
+
+	if v, err := db.Get([]byte(fmt.Sprintf("key-%10d", 10))); err != nil || string(v) != "val-10" {
+		t.Errorf("Expected val-10, got %s, %v", v, err)
+	}
+
+	if _, err := db.Get([]byte(fmt.Sprintf("key-%10d", 11))); err != ErrNotFound {
+		t.Errorf("Expected key to be deleted, got %v", err)
+	}
+
+	if ok, err := db.Has([]byte("missing")); ok || err != nil {
+		t.Errorf("Expected key to be missing, got %v, %v", ok, err)
+	}
+
+	// Iterator is not affected by the later mutations
+	itr := db.NewIterator()
+	db.Set([]byte(fmt.Sprintf("key-%10d", 1)), []byte("newval"))
+
+	i := 0
+	for itr.First(); itr.Valid(); itr.Next() {
+		k, v := fmt.Sprintf("key-%10d", i), fmt.Sprintf("val-%d", i)
+		if string(itr.Key()) != k || string(itr.Value()) != v {
+			t.Fatalf("Expected %s:%s, got %s:%s", k, v, itr.Key(), itr.Value())
+		}
+		i += 2
+	}
+
+	if i != n || itr.Err() != nil {
+		t.Errorf("Expected %d items, got %d, %v", n/2, i/2, itr.Err())
+	}
+
+	itr.Seek([]byte(fmt.Sprintf("key-%10d", 101)))
+	if !itr.Valid() || string(itr.Key()) != fmt.Sprintf("key-%10d", 102) {
+		t.Errorf("Expected seek to the next key")
+	}
+	itr.Close()
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	// Items are recovered on reopening
+	db, err = Open("teststore.data")
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer db.Close()
+
+	if v, err := db.Get([]byte(fmt.Sprintf("key-%10d", 1))); err != nil || string(v) != "newval" {
+		t.Errorf("Expected newval, got %s, %v", v, err)
+	}
+
+	if v, err := db.Get([]byte(fmt.Sprintf("key-%10d", 9998))); err != nil || string(v) != "val-9998" {
+		t.Errorf("Expected val-9998, got %s, %v", v, err)
+	}
+
+	count := 0
+	itr = db.NewIterator()
+	for itr.First(); itr.Valid(); itr.Next() {
+		count++
+	}
+	itr.Close()
+
+	if count != n/2+1 {
+		t.Errorf("Expected %d items, got %d", n/2+1, count)
+	}
+}
@@ -0,0 +1,30 @@
+// +build !windows
+
+package plasma
+
+import (
+	"os"
+	"syscall"
+)
+
+func lockFile(path string) (*os.File, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		fd.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrStoreLocked
+		}
+		return nil, err
+	}
+
+	return fd, nil
+}
+
+func unlockFile(fd *os.File) error {
+	syscall.Flock(int(fd.Fd()), syscall.LOCK_UN)
+	return fd.Close()
+}
@@ -0,0 +1,18 @@
+package plasma
+
+import (
+	"os"
+)
+
+// Windows does not allow deleting an open file, which is used as the lock
+func lockFile(path string) (*os.File, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, ErrStoreLocked
+	}
+
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+}
+
+func unlockFile(fd *os.File) error {
+	return fd.Close()
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 )
@@ -15,6 +17,9 @@ import (
 const (
 	logSBSize  = 4096
 	logVersion = 0
+
+	logSBMetaOffset  = 32
+	maxLogSBMetaSize = logSBSize - logSBMetaOffset - 4
 )
 
 var segFileNameFormat = "log.%014d.data"
@@ -22,6 +27,7 @@ var segFilePattern = "log.*.data"
 var segFileIdPattern = "log.%d.data"
 var headerFileName = "header.data"
 var ErrLogSuperBlockCorrupt = fmt.Errorf("Log superblock is corrupt")
+var ErrLogSuperBlockMetaSize = fmt.Errorf("Log superblock metadata is too large")
 
 type Log interface {
 	Head() int64
@@ -31,12 +37,17 @@ type Log interface {
 	Trim(offset int64)
 	Commit() error
 	Size() int64
+	GetMeta() []byte
+	SetMeta([]byte) error
 	Close() error
 }
 
 type logFile struct {
 	fd   *os.File
 	data mmap.MMap
+
+	// Object key of a segment moved to the cold tier
+	remote string
 }
 
 type fileIndex struct {
@@ -50,6 +61,7 @@ type multiFilelog struct {
 	sbBuffer [logSBSize]byte
 	sbGen    int64
 	sbFd     *os.File
+	sbMeta   unsafe.Pointer
 
 	basePath    string
 	segmentSize int64
@@ -61,9 +73,35 @@ type multiFilelog struct {
 
 	sync       bool
 	enableMmap bool
+
+	// Serializes the updates of the index
+	idxLock sync.Mutex
+
+	tier     *coldTier
+	tierLock sync.Mutex
+	tierSegs map[int64]string
+	closed   bool
+
+	tierGCLock sync.Mutex
+	tierGC     []string
+
+	archive func(LogSegment) error
+}
+
+// Log segment offered to Config.ArchiveLogSegment. Segments moved to the
+// cold tier have the key of their object instead of a path.
+type LogSegment struct {
+	Path        string
+	Remote      string
+	StartOffset int64
+	EndOffset   int64
 }
 
 func newLog(path string, segmentSize int64, sync bool, mmap bool) (Log, error) {
+	return openLog(path, segmentSize, sync, mmap, nil)
+}
+
+func openLog(path string, segmentSize int64, sync bool, mmap bool, tier *coldTier) (Log, error) {
 	var sbBuffer [logSBSize]byte
 	os.MkdirAll(path, 0755)
 	headerFile := filepath.Join(path, headerFileName)
@@ -72,12 +110,13 @@ func newLog(path string, segmentSize int64, sync bool, mmap bool) (Log, error) {
 		return nil, err
 	}
 
-	h, t, g, err := readLogSB(fd, sbBuffer[:])
+	h, t, g, meta, err := readLogSB(fd, sbBuffer[:])
 	if err != nil {
 		return nil, err
 	}
 
 	log := &multiFilelog{
+		sbMeta:      unsafe.Pointer(&meta),
 		segmentSize: segmentSize,
 		sbBuffer:    sbBuffer,
 		sbGen:       g + 1,
@@ -87,6 +126,17 @@ func newLog(path string, segmentSize int64, sync bool, mmap bool) (Log, error) {
 		tailOffset:  t,
 		enableMmap:  mmap,
 		sync:        sync,
+		tier:        tier,
+	}
+
+	if tier != nil {
+		tier.log = log
+	}
+
+	if tier != nil {
+		if log.tierSegs, err = readTierIndex(path); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := log.initIndex(); err != nil {
@@ -126,16 +176,45 @@ func (lf *logFile) Close() error {
 func (l *multiFilelog) initIndex() error {
 	fi := new(fileIndex)
 	files, _ := filepath.Glob(filepath.Join(l.basePath, segFilePattern))
-	if len(files) > 0 {
+
+	// Segments in the cold tier precede the local segments
+	var remoteIds []int64
+	for id := range l.tierSegs {
+		var fileId int64 = -1
+		if len(files) > 0 {
+			fmt.Sscanf(filepath.Base(files[0]), segFileIdPattern, &fileId)
+		}
+
+		if fileId < 0 || id < fileId {
+			remoteIds = append(remoteIds, id)
+		}
+	}
+	sort.Slice(remoteIds, func(i, j int) bool { return remoteIds[i] < remoteIds[j] })
+
+	if len(files) > 0 || len(remoteIds) > 0 {
 		var startId, endId int64
-		startFile := filepath.Base(files[0])
-		endFile := filepath.Base(files[len(files)-1])
-		fmt.Sscanf(startFile, segFileIdPattern, &startId)
-		fmt.Sscanf(endFile, segFileIdPattern, &endId)
+		if len(remoteIds) > 0 {
+			startId = remoteIds[0]
+			endId = remoteIds[len(remoteIds)-1]
+		} else {
+			fmt.Sscanf(filepath.Base(files[0]), segFileIdPattern, &startId)
+		}
+
+		if len(files) > 0 {
+			fmt.Sscanf(filepath.Base(files[len(files)-1]), segFileIdPattern, &endId)
+		}
 		fi.startOffset = startId * l.segmentSize
 		fi.endOffset = endId*l.segmentSize + l.segmentSize
 	}
 
+	for _, id := range remoteIds {
+		fi.index = append(fi.index, &logFile{remote: l.tierSegs[id]})
+	}
+
+	if l.tier != nil {
+		atomic.StoreInt64(&l.tier.numSegments, int64(len(remoteIds)))
+	}
+
 	for i, f := range files {
 		if lf, err := newLogFile(f, 0, int(l.segmentSize), l.enableMmap); err == nil {
 			fi.index = append(fi.index, lf)
@@ -176,10 +255,22 @@ retry:
 		bs = bs[:avail]
 	}
 
-	if l.enableMmap {
-		copy(bs, idx.index[fdIdx].data[fdOffset:])
+	if lf := idx.index[fdIdx]; lf.remote != "" {
+		if err := l.tier.read(lf.remote, l.segmentSize, bs, fdOffset); err != nil {
+			return err
+		}
+	} else if l.enableMmap {
+		copy(bs, lf.data[fdOffset:])
 	} else {
-		if _, err := idx.index[fdIdx].fd.ReadAt(bs, fdOffset); err != nil {
+		if _, err := lf.fd.ReadAt(bs, fdOffset); err != nil {
+			// The segment may have been moved to the cold tier
+			if newIdx := l.getIndex(); newIdx != idx {
+				idx = newIdx
+				if len(residue) > 0 {
+					bs = bs[:len(bs)+len(residue)]
+				}
+				goto retry
+			}
 			return err
 		}
 	}
@@ -207,6 +298,9 @@ func (l *multiFilelog) getIndex() *fileIndex {
 
 func (l *multiFilelog) growLog() error {
 	var err error
+	l.idxLock.Lock()
+	defer l.idxLock.Unlock()
+
 	idx := l.getIndex()
 	newFileId := (idx.endOffset + 1) / l.segmentSize
 	file := filepath.Join(l.basePath, fmt.Sprintf(segFileNameFormat, newFileId))
@@ -275,16 +369,34 @@ func (l *multiFilelog) Trim(offset int64) {
 }
 
 func (l *multiFilelog) doGCSegments() {
+	l.idxLock.Lock()
+	defer l.idxLock.Unlock()
+
 	idx := l.getIndex()
 	free := (l.headOffset/l.segmentSize)*l.segmentSize - idx.startOffset
+	if n := l.archiveSegments(idx, free/l.segmentSize); n*l.segmentSize < free {
+		free = n * l.segmentSize
+	}
+
 	if free > 0 {
 		n := free / l.segmentSize
 		toRemove := idx.index[:n]
-		var rmList []string
+		var rmList, remoteList []string
 		for _, lf := range toRemove {
+			if lf.remote != "" {
+				remoteList = append(remoteList, lf.remote)
+				continue
+			}
 			rmList = append(rmList, lf.fd.Name())
 			lf.Close()
 		}
+
+		// Objects are deleted by the cold tier worker
+		if len(remoteList) > 0 {
+			l.tierGCLock.Lock()
+			l.tierGC = append(l.tierGC, remoteList...)
+			l.tierGCLock.Unlock()
+		}
 		toRetain := append([]*logFile(nil), idx.index[n:]...)
 
 		newIdx := *idx
@@ -302,6 +414,33 @@ func (l *multiFilelog) doGCSegments() {
 	}
 }
 
+// Returns the number of the first n segments of idx which were archived
+func (l *multiFilelog) archiveSegments(idx *fileIndex, n int64) int64 {
+	if l.archive == nil {
+		return n
+	}
+
+	for i := int64(0); i < n; i++ {
+		lf := idx.index[i]
+		seg := LogSegment{
+			Remote:      lf.remote,
+			StartOffset: idx.startOffset + i*l.segmentSize,
+			EndOffset:   idx.startOffset + (i+1)*l.segmentSize,
+		}
+
+		if lf.remote == "" {
+			seg.Path = lf.fd.Name()
+		}
+
+		if err := l.archive(seg); err != nil {
+			fmt.Printf("Plasma: Unable to archive log segment %d - err %v\n", seg.StartOffset, err)
+			return i
+		}
+	}
+
+	return n
+}
+
 func (l *multiFilelog) Commit() error {
 	idx := l.getIndex()
 	if !l.sync {
@@ -310,7 +449,7 @@ func (l *multiFilelog) Commit() error {
 		}
 	}
 
-	marshalLogSB(l.sbBuffer[:], l.Head(), l.Tail(), l.sbGen)
+	marshalLogSB(l.sbBuffer[:], l.Head(), l.Tail(), l.sbGen, l.GetMeta())
 	offset := int64(logSBSize * (l.sbGen % 2))
 	if _, err := l.sbFd.WriteAt(l.sbBuffer[:], offset); err != nil {
 		return err
@@ -322,21 +461,42 @@ func (l *multiFilelog) Commit() error {
 	return nil
 }
 
+// Metadata is written along with the superblock during the next commit
+func (l *multiFilelog) SetMeta(meta []byte) error {
+	if len(meta) > maxLogSBMetaSize {
+		return ErrLogSuperBlockMetaSize
+	}
+
+	meta = append([]byte(nil), meta...)
+	atomic.StorePointer(&l.sbMeta, unsafe.Pointer(&meta))
+	return nil
+}
+
+func (l *multiFilelog) GetMeta() []byte {
+	return *(*[]byte)(atomic.LoadPointer(&l.sbMeta))
+}
+
 func (l *multiFilelog) Size() int64 {
 	return l.Tail() - l.Head()
 }
 
 func (l *multiFilelog) Close() error {
+	l.tierLock.Lock()
+	l.closed = true
+	l.tierLock.Unlock()
+
 	idx := l.getIndex()
 	for _, fd := range idx.index {
-		fd.Close()
+		if fd.remote == "" {
+			fd.Close()
+		}
 	}
 
 	idx.index = nil
 	return nil
 }
 
-func marshalLogSB(buf []byte, headOffset, tailOffset int64, gen int64) {
+func marshalLogSB(buf []byte, headOffset, tailOffset int64, gen int64, meta []byte) {
 	woffset := 4
 	binary.BigEndian.PutUint32(buf[woffset:woffset+4], uint32(logVersion))
 	woffset += 4
@@ -350,11 +510,15 @@ func marshalLogSB(buf []byte, headOffset, tailOffset int64, gen int64) {
 	binary.BigEndian.PutUint64(buf[woffset:woffset+8], uint64(tailOffset))
 	woffset += 8
 
+	binary.BigEndian.PutUint32(buf[woffset:woffset+4], uint32(len(meta)))
+	woffset += 4
+	copy(buf[woffset:], meta)
+
 	hash := crc32.ChecksumIEEE(buf[4:logSBSize])
 	binary.BigEndian.PutUint32(buf[0:4], hash)
 }
 
-func unmarshalLogSB(buf []byte) (headOffset, tailOffset int64, gen int64, err error) {
+func unmarshalLogSB(buf []byte) (headOffset, tailOffset int64, gen int64, meta []byte, err error) {
 	hash := binary.BigEndian.Uint32(buf[0:4])
 	computedHash := crc32.ChecksumIEEE(buf[4:logSBSize])
 	if hash != computedHash {
@@ -369,28 +533,39 @@ func unmarshalLogSB(buf []byte) (headOffset, tailOffset int64, gen int64, err er
 	roffset += 8
 	tailOffset = int64(binary.BigEndian.Uint64(buf[roffset : roffset+8]))
 	roffset += 8
+	l := int(binary.BigEndian.Uint32(buf[roffset : roffset+4]))
+	roffset += 4
+	if l > maxLogSBMetaSize {
+		err = ErrCorruptSuperBlock
+		return
+	}
+
+	if l > 0 {
+		meta = append([]byte(nil), buf[roffset:roffset+l]...)
+	}
 	return
 }
 
-func readLogSB(fd *os.File, buf []byte) (headOff, tailOff, gen int64, err error) {
+func readLogSB(fd *os.File, buf []byte) (headOff, tailOff, gen int64, meta []byte, err error) {
 	var hs, ts, gens [2]int64
+	var metas [2][]byte
 	var errs [2]error
 
 	if _, err = fd.ReadAt(buf, 0); err == io.EOF {
-		return 0, 0, 0, nil
+		return 0, 0, 0, nil, nil
 	} else if err != nil {
 		return
 	}
 
-	hs[0], ts[0], gens[0], errs[0] = unmarshalLogSB(buf)
+	hs[0], ts[0], gens[0], metas[0], errs[0] = unmarshalLogSB(buf)
 
 	if _, err = fd.ReadAt(buf, logSBSize); err == io.EOF {
-		return hs[0], ts[0], gens[0], errs[0]
+		return hs[0], ts[0], gens[0], metas[0], errs[0]
 	} else if err != nil {
 		return
 	}
 
-	hs[1], ts[1], gens[1], errs[1] = unmarshalLogSB(buf)
+	hs[1], ts[1], gens[1], metas[1], errs[1] = unmarshalLogSB(buf)
 
 	var sbIndex int
 	if errs[0] == nil && errs[1] == nil {
@@ -408,9 +583,79 @@ func readLogSB(fd *os.File, buf []byte) (headOff, tailOff, gen int64, err error)
 		return
 	}
 
-	return hs[sbIndex], ts[sbIndex], gens[sbIndex] + 1, nil
+	return hs[sbIndex], ts[sbIndex], gens[sbIndex] + 1, metas[sbIndex], nil
 }
 
 func GetLogVersion() uint32 {
 	return uint32(logVersion)
 }
+
+// Create a log in path which shares the segments of the committed log.
+// Segments are not modified once the log has grown beyond them, so that
+// they are hard linked. The segment being written is copied.
+func (l *multiFilelog) fork(path string) error {
+	var buf [logSBSize]byte
+
+	// Segments are not removed or added meanwhile
+	l.idxLock.Lock()
+	defer l.idxLock.Unlock()
+
+	head, tail, _, meta, err := readLogSB(l.sbFd, buf[:])
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+
+	idx := l.getIndex()
+	for i, lf := range idx.index {
+		start := idx.startOffset + int64(i)*l.segmentSize
+		if start+l.segmentSize <= head || start >= tail {
+			continue
+		}
+
+		if lf.remote != "" {
+			return ErrForkNotSupported
+		}
+
+		dst := filepath.Join(path, filepath.Base(lf.fd.Name()))
+		if start+l.segmentSize <= tail {
+			if err = os.Link(lf.fd.Name(), dst); err == nil {
+				continue
+			}
+		}
+
+		if err := copySegment(lf.fd, dst, tail-start); err != nil {
+			return err
+		}
+	}
+
+	fd, err := os.OpenFile(filepath.Join(path, headerFileName), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	marshalLogSB(buf[:], head, tail, 0, meta)
+	if _, err := fd.WriteAt(buf[:], 0); err != nil {
+		return err
+	}
+
+	return fd.Sync()
+}
+
+func copySegment(src *os.File, dst string, n int64) error {
+	fd, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if _, err := io.Copy(fd, io.NewSectionReader(src, 0, n)); err != nil {
+		return err
+	}
+
+	return fd.Sync()
+}
@@ -0,0 +1,289 @@
+package plasma
+
+import (
+	"errors"
+	"github.com/couchbase/nitro/skiplist"
+	"unsafe"
+)
+
+var ErrLogIteratorNotSupported = errors.New("log iteration requires a persistent store")
+var ErrLogTrimmed = errors.New("log offset has been trimmed")
+
+type LogRecordType uint16
+
+const (
+	LogPageData       = LogRecordType(lssPageData)
+	LogPageReloc      = LogRecordType(lssPageReloc)
+	LogPageUpdate     = LogRecordType(lssPageUpdate)
+	LogPageRemove     = LogRecordType(lssPageRemove)
+	LogRecoveryPoints = LogRecordType(lssRecoveryPoints)
+	LogMaxSn          = LogRecordType(lssMaxSn)
+	LogDiscard        = LogRecordType(lssDiscard)
+	LogOpMarker       = LogRecordType(lssOpMarker)
+)
+
+func (t LogRecordType) String() string {
+	switch t {
+	case LogPageData:
+		return "page_data"
+	case LogPageReloc:
+		return "page_reloc"
+	case LogPageUpdate:
+		return "page_update"
+	case LogPageRemove:
+		return "page_remove"
+	case LogRecoveryPoints:
+		return "recovery_points"
+	case LogMaxSn:
+		return "max_sn"
+	case LogDiscard:
+		return "discard"
+	case LogOpMarker:
+		return "op_marker"
+	}
+
+	return "unknown"
+}
+
+// Record of the log. Data and MinKey refer to the buffer of the iterator
+// and are valid until it is advanced.
+type LogRecord struct {
+	Type       LogRecordType
+	Offset     LSSOffset
+	NextOffset LSSOffset
+	Data       []byte
+
+	// Low key of the page of page records, which is skiplist.MinItem for
+	// the first page
+	MinKey unsafe.Pointer
+
+	// Items of page records and the offset of the earlier records of the
+	// page which they are chained to
+	NumItems   int
+	HasPrev    bool
+	PrevOffset LSSOffset
+
+	// Sequence numbers of the items of page records and the max sequence
+	// number of max sn records. They are set for stores with snapshots.
+	MinSn, MaxSn uint64
+}
+
+// Iterates the records committed to the log independently of the pages
+// of the store. Records committed after Seek are visited by seeking again
+// to the NextOffset of the last record. Valid turns false with Err set if
+// the log is trimmed beyond the iterator, such as by the log cleaner.
+type LogIterator struct {
+	store *Plasma
+	buf   *Buffer
+	end   LSSOffset
+	rec   LogRecord
+	valid bool
+	err   error
+}
+
+func (s *Plasma) NewLogIterator() (*LogIterator, error) {
+	if !s.shouldPersist {
+		return nil, ErrLogIteratorNotSupported
+	}
+
+	return &LogIterator{store: s, buf: newBuffer(initBufferSize)}, nil
+}
+
+func (itr *LogIterator) SeekFirst() {
+	itr.Seek(itr.store.lss.HeadOffset())
+}
+
+// Offset should be the offset of a record or the tail of the log
+func (itr *LogIterator) Seek(offset LSSOffset) {
+	itr.err = nil
+	itr.end = itr.store.lss.TailOffset()
+	if offset < itr.store.lss.HeadOffset() {
+		itr.valid, itr.err = false, ErrLogTrimmed
+		return
+	}
+
+	itr.read(offset)
+}
+
+func (itr *LogIterator) Valid() bool {
+	return itr.valid
+}
+
+func (itr *LogIterator) Next() {
+	itr.read(itr.rec.NextOffset)
+}
+
+func (itr *LogIterator) Get() *LogRecord {
+	return &itr.rec
+}
+
+func (itr *LogIterator) Err() error {
+	return itr.err
+}
+
+func (itr *LogIterator) Close() {
+	itr.valid = false
+	itr.buf = nil
+}
+
+func (itr *LogIterator) read(offset LSSOffset) {
+	itr.valid = false
+	if offset >= itr.end {
+		return
+	}
+
+	n, err := itr.store.lss.Read(offset, itr.buf)
+	if err != nil {
+		itr.err = err
+		return
+	}
+
+	bs := itr.buf.Get(0, n)
+	if len(bs) < lssBlockTypeSize {
+		itr.err = ErrCorruptLSSBlock
+		return
+	}
+
+	itr.rec = LogRecord{
+		Type:       LogRecordType(getLSSBlockType(bs)),
+		Offset:     offset,
+		NextOffset: offset + LSSOffset(n+headerFBSize),
+		Data:       bs[lssBlockTypeSize:],
+	}
+
+	if itr.err = itr.store.decodeLogRecord(&itr.rec); itr.err == nil {
+		itr.valid = true
+	}
+}
+
+func (s *Plasma) decodeLogRecord(rec *LogRecord) error {
+	switch rec.Type {
+	case LogPageData, LogPageReloc, LogPageUpdate:
+		return s.decodeLogPage(rec)
+	case LogPageRemove:
+		low, err := getRmPageLow(rec.Data)
+		if low == nil {
+			low = skiplist.MinItem
+		}
+		rec.MinKey = low
+		return err
+	case LogMaxSn:
+		if len(rec.Data) < 8 {
+			return ErrCorruptLSSBlock
+		}
+		rec.MinSn = decodeMaxSn(rec.Data)
+		rec.MaxSn = rec.MinSn
+	}
+
+	return nil
+}
+
+func (s *Plasma) decodeLogItem(data []byte, roffset int) (unsafe.Pointer, int, error) {
+	if roffset >= len(data) {
+		return nil, 0, ErrCorruptPageData
+	}
+
+	l, ok := s.ItemDataSize(data[roffset:])
+	if !ok {
+		return nil, 0, ErrCorruptPageData
+	}
+
+	return unsafe.Pointer(&data[roffset]), roffset + l, nil
+}
+
+func (s *Plasma) decodeLogIndexKey(data []byte, roffset int) (unsafe.Pointer, int, error) {
+	if roffset >= len(data) {
+		return nil, 0, ErrCorruptPageData
+	}
+
+	switch data[roffset] {
+	case itemKeyEncoded:
+		return s.decodeLogItem(data, roffset+1)
+	case minKeyEncoded:
+		return skiplist.MinItem, roffset + 1, nil
+	case maxKeyEncoded:
+		return skiplist.MaxItem, roffset + 1, nil
+	}
+
+	return nil, 0, ErrCorruptPageData
+}
+
+// Decode the items of a page record laid out by page.marshal
+func (s *Plasma) decodeLogPage(rec *LogRecord) error {
+	var v16 uint16
+	var v64 uint64
+	var itm unsafe.Pointer
+	var err error
+
+	// Page state
+	data := rec.Data
+	roffset := 2
+	if roffset > len(data) {
+		return ErrCorruptPageData
+	}
+
+	if rec.MinKey, roffset, err = s.decodeLogIndexKey(data, roffset); err != nil {
+		return err
+	}
+
+	// Chain length and number of items precede the high key
+	roffset += 4
+	if _, roffset, err = s.decodeLogIndexKey(data, roffset); err != nil {
+		return err
+	}
+
+	addItem := func(itm unsafe.Pointer) {
+		rec.NumItems++
+		if s.EnableShapshots {
+			sn := s.ItemCodec.Sn(itm)
+			if rec.MinSn == 0 || sn < rec.MinSn {
+				rec.MinSn = sn
+			}
+
+			if sn > rec.MaxSn {
+				rec.MaxSn = sn
+			}
+		}
+	}
+
+	for roffset < len(data) {
+		if v16, roffset, err = readUint16(data, roffset); err != nil {
+			return err
+		}
+
+		switch pageOp(v16) {
+		case opInsertDelta, opDeleteDelta:
+			if itm, roffset, err = s.decodeLogItem(data, roffset); err != nil {
+				return err
+			}
+			addItem(itm)
+		case opPageSplitDelta:
+		case opBasePage:
+			if v16, roffset, err = readUint16(data, roffset); err != nil {
+				return err
+			}
+
+			for i := 0; i < int(v16); i++ {
+				if itm, roffset, err = s.decodeLogItem(data, roffset); err != nil {
+					return err
+				}
+				addItem(itm)
+			}
+		case opFlushPageDelta, opRelocPageDelta:
+			if v64, roffset, err = readUint64(data, roffset); err != nil {
+				return err
+			}
+			rec.HasPrev, rec.PrevOffset = true, LSSOffset(v64)
+			return nil
+		case opRollbackDelta:
+			if roffset+16 > len(data) {
+				return ErrCorruptPageData
+			}
+			roffset += 16
+		default:
+			return ErrCorruptPageData
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package plasma
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+)
+
+func TestPlasmaLogIterator(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", i)))
+	}
+	s.NewSnapshot().Close()
+	s.PersistAll()
+
+	itr, err := s.NewLogIterator()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer itr.Close()
+
+	counts := make(map[LogRecordType]int)
+	var items int
+	var last LSSOffset
+	visit := func() {
+		for ; itr.Valid(); itr.Next() {
+			rec := itr.Get()
+			counts[rec.Type]++
+			switch rec.Type {
+			case LogPageData, LogPageReloc, LogPageUpdate:
+				items += rec.NumItems
+				if rec.MinKey != skiplist.MinItem && !bytes.HasPrefix(s.ItemCodec.Key(rec.MinKey), []byte("key-")) {
+					t.Fatalf("unexpected page key %s", s.ItemCodec.Key(rec.MinKey))
+				}
+
+				if rec.NumItems > 0 && (rec.MinSn == 0 || rec.MaxSn < rec.MinSn || rec.MaxSn > s.CurrentSeqNum()) {
+					t.Fatalf("unexpected sn range [%d, %d]", rec.MinSn, rec.MaxSn)
+				}
+
+				if rec.HasPrev && rec.PrevOffset >= rec.Offset {
+					t.Fatalf("expected chained offset %d before %d", rec.PrevOffset, rec.Offset)
+				}
+			}
+			last = rec.NextOffset
+		}
+
+		if err := itr.Err(); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	itr.SeekFirst()
+	visit()
+
+	if counts[LogPageData] == 0 || counts[LogMaxSn] == 0 || items < 10000 {
+		t.Fatalf("expected page and max sn records, got %v with %d items", counts, items)
+	}
+
+	if last != s.lss.TailOffset() {
+		t.Errorf("expected to reach the tail %d, got %d", s.lss.TailOffset(), last)
+	}
+
+	// Resume from the last record
+	for i := 0; i < 100; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), nil)
+	}
+	s.PersistAll()
+
+	pages := counts[LogPageUpdate] + counts[LogPageData]
+	itr.Seek(last)
+	visit()
+	if counts[LogPageUpdate]+counts[LogPageData] == pages {
+		t.Errorf("expected the records written since the last record")
+	}
+
+	cfg := testSnCfg
+	cfg.File = ""
+	s2 := newTestIntPlasmaStore(cfg)
+	defer s2.Close()
+	if _, err := s2.NewLogIterator(); err != ErrLogIteratorNotSupported {
+		t.Errorf("expected not supported error, got %v", err)
+	}
+}
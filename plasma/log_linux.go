@@ -2,6 +2,7 @@ package plasma
 
 import (
 	"os"
+	"sync"
 	"sync/atomic"
 	"syscall"
 )
@@ -15,7 +16,9 @@ type singleFileLog struct {
 	headOffset, tailOffset int64
 	sbBuffer               [logSBSize]byte
 	sbGen                  int64
+	sbMeta                 []byte
 	lastTrimOffset         int64
+	sync.Mutex
 }
 
 func newSingleFileLog(path string) (Log, error) {
@@ -26,7 +29,7 @@ func newSingleFileLog(path string) (Log, error) {
 		return nil, err
 	}
 
-	h, t, g, err := readLogSB(fd, sbBuffer[:])
+	h, t, g, meta, err := readLogSB(fd, sbBuffer[:])
 	if err != nil {
 		return nil, err
 	}
@@ -36,6 +39,7 @@ func newSingleFileLog(path string) (Log, error) {
 		headOffset: h,
 		tailOffset: t,
 		sbGen:      g + 1,
+		sbMeta:     meta,
 	}
 
 	return log, nil
@@ -68,7 +72,7 @@ func (l *singleFileLog) Trim(offset int64) {
 }
 
 func (l *singleFileLog) Commit() error {
-	marshalLogSB(l.sbBuffer[:], l.headOffset, l.tailOffset, l.sbGen)
+	marshalLogSB(l.sbBuffer[:], l.headOffset, l.tailOffset, l.sbGen, l.GetMeta())
 	offset := int64(logSBSize * (l.sbGen % 2))
 	if _, err := l.fd.WriteAt(l.sbBuffer[:], offset); err != nil {
 		return err
@@ -81,6 +85,23 @@ func (l *singleFileLog) Commit() error {
 	return nil
 }
 
+func (l *singleFileLog) SetMeta(meta []byte) error {
+	if len(meta) > maxLogSBMetaSize {
+		return ErrLogSuperBlockMetaSize
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.sbMeta = append([]byte(nil), meta...)
+	return nil
+}
+
+func (l *singleFileLog) GetMeta() []byte {
+	l.Lock()
+	defer l.Unlock()
+	return l.sbMeta
+}
+
 func (l *singleFileLog) Size() int64 {
 	return atomic.LoadInt64(&l.tailOffset) - atomic.LoadInt64(&l.headOffset)
 }
@@ -0,0 +1,241 @@
+package plasma
+
+import (
+	"fmt"
+	"sync"
+)
+
+const memLogChunkSize = 1024 * 1024 * 4
+
+// In-memory logs which outlive the store using them, keyed by path. A store
+// reopened with the same path recovers the data committed to the log.
+var memLogs = struct {
+	sync.Mutex
+	logs map[string]*memLog
+}{logs: make(map[string]*memLog)}
+
+// Log held in memory in fixed size chunks. Chunks before the head are freed
+// on commit. Data appended after the last commit is discarded when the log
+// is reopened, similar to a crash of a file backed log.
+type memLog struct {
+	sync.RWMutex
+	path string
+	open bool
+
+	chunks      [][]byte
+	startOffset int64
+
+	headOffset, tailOffset int64
+	meta                   []byte
+
+	commitHead, commitTail int64
+	commitMeta             []byte
+}
+
+// Open the in-memory log for path. An empty path creates a log which is
+// dropped when it is closed.
+func openMemLog(path string) (Log, error) {
+	if path == "" {
+		return &memLog{open: true}, nil
+	}
+
+	memLogs.Lock()
+	defer memLogs.Unlock()
+
+	l, ok := memLogs.logs[path]
+	if !ok {
+		l = &memLog{path: path}
+		memLogs.logs[path] = l
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.open {
+		return nil, ErrStoreLocked
+	}
+
+	l.open = true
+	l.headOffset, l.tailOffset = l.commitHead, l.commitTail
+	l.meta = l.commitMeta
+	return l, nil
+}
+
+// Free the in-memory log for path. The store using the log should be
+// closed.
+func RemoveInMemoryLSS(path string) error {
+	memLogs.Lock()
+	defer memLogs.Unlock()
+
+	if l, ok := memLogs.logs[path]; ok {
+		l.RLock()
+		open := l.open
+		l.RUnlock()
+
+		if open {
+			return ErrStoreLocked
+		}
+
+		delete(memLogs.logs, path)
+	}
+
+	return nil
+}
+
+// Copy the in-memory log at src to dst as it would be found after a power
+// cut when the log had been written up to tail. The image has the state of
+// the last commit, data written after it is discarded when dst is opened.
+func CrashInMemoryLSS(src, dst string, tail int64) error {
+	memLogs.Lock()
+	defer memLogs.Unlock()
+
+	l, ok := memLogs.logs[src]
+	if !ok {
+		return fmt.Errorf("In-memory LSS %s does not exist", src)
+	}
+
+	if d, ok := memLogs.logs[dst]; ok && d.open {
+		return ErrStoreLocked
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+
+	if tail < l.commitTail || tail > l.tailOffset {
+		return fmt.Errorf("Crash offset %d is outside the uncommitted data (%d-%d)", tail, l.commitTail, l.tailOffset)
+	}
+
+	c := &memLog{
+		path:        dst,
+		startOffset: l.startOffset,
+		commitHead:  l.commitHead,
+		commitTail:  l.commitTail,
+		commitMeta:  l.commitMeta,
+	}
+
+	for off := l.startOffset; off < tail; off += memLogChunkSize {
+		n := tail - off
+		if n > memLogChunkSize {
+			n = memLogChunkSize
+		}
+
+		chunk := make([]byte, memLogChunkSize)
+		copy(chunk, l.chunks[(off-l.startOffset)/memLogChunkSize][:n])
+		c.chunks = append(c.chunks, chunk)
+	}
+
+	memLogs.logs[dst] = c
+	return nil
+}
+
+func (l *memLog) Head() int64 {
+	l.RLock()
+	defer l.RUnlock()
+	return l.headOffset
+}
+
+func (l *memLog) Tail() int64 {
+	l.RLock()
+	defer l.RUnlock()
+	return l.tailOffset
+}
+
+func (l *memLog) Read(bs []byte, off int64) error {
+	l.RLock()
+	defer l.RUnlock()
+
+	if off+int64(len(bs)) > l.tailOffset {
+		return fmt.Errorf("Log size is smaller than offset (%d < %d)", l.tailOffset, off+int64(len(bs)))
+	}
+
+	if off < l.startOffset {
+		return fmt.Errorf("Log starts at offset %d, trying to read %d", l.startOffset, off)
+	}
+
+	for len(bs) > 0 {
+		chunk := l.chunks[(off-l.startOffset)/memLogChunkSize]
+		n := copy(bs, chunk[off%memLogChunkSize:])
+		bs = bs[n:]
+		off += int64(n)
+	}
+
+	return nil
+}
+
+func (l *memLog) Append(bs []byte) error {
+	l.Lock()
+	defer l.Unlock()
+
+	for len(bs) > 0 {
+		idx := int((l.tailOffset - l.startOffset) / memLogChunkSize)
+		if idx == len(l.chunks) {
+			l.chunks = append(l.chunks, make([]byte, memLogChunkSize))
+		}
+
+		n := copy(l.chunks[idx][l.tailOffset%memLogChunkSize:], bs)
+		bs = bs[n:]
+		l.tailOffset += int64(n)
+	}
+
+	return nil
+}
+
+func (l *memLog) Trim(offset int64) {
+	l.Lock()
+	defer l.Unlock()
+
+	if offset > 0 {
+		l.headOffset = offset
+	}
+}
+
+func (l *memLog) Commit() error {
+	l.Lock()
+	defer l.Unlock()
+
+	l.commitHead, l.commitTail = l.headOffset, l.tailOffset
+	l.commitMeta = l.meta
+
+	if n := int((l.headOffset - l.startOffset) / memLogChunkSize); n > 0 {
+		l.chunks = append([][]byte(nil), l.chunks[n:]...)
+		l.startOffset += int64(n) * memLogChunkSize
+	}
+
+	return nil
+}
+
+func (l *memLog) Size() int64 {
+	l.RLock()
+	defer l.RUnlock()
+	return l.tailOffset - l.headOffset
+}
+
+// Metadata is committed along with the log
+func (l *memLog) SetMeta(meta []byte) error {
+	if len(meta) > maxLogSBMetaSize {
+		return ErrLogSuperBlockMetaSize
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.meta = append([]byte(nil), meta...)
+	return nil
+}
+
+func (l *memLog) GetMeta() []byte {
+	l.RLock()
+	defer l.RUnlock()
+	return l.meta
+}
+
+func (l *memLog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	l.open = false
+	if l.path == "" {
+		l.chunks = nil
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package plasma
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestMemLogOperation(t *testing.T) {
+	path := "memlog"
+	RemoveInMemoryLSS(path)
+	defer RemoveInMemoryLSS(path)
+
+	l, _ := openMemLog(path)
+	bs := make([]byte, 973)
+	n := 1024 * 20
+	for i := 0; i < n; i++ {
+		copy(bs, []byte(fmt.Sprintf("hello %05d", i)))
+		copy(bs[973-5:], []byte(fmt.Sprintf("%05d", i)))
+		l.Append(bs)
+	}
+
+	l.Commit()
+
+	// Not committed and discarded on reopen
+	l.Append(bs)
+
+	if _, err := openMemLog(path); err != ErrStoreLocked {
+		t.Errorf("expected %v, got %v", ErrStoreLocked, err)
+	}
+
+	l.Close()
+	l, _ = openMemLog(path)
+	if l.Tail() != int64(n*973) {
+		t.Errorf("expected tail %d, got %d", n*973, l.Tail())
+	}
+
+	bs2 := make([]byte, 973)
+	for i := 0; i < n; i++ {
+		copy(bs, []byte(fmt.Sprintf("hello %05d", i)))
+		copy(bs[973-5:], []byte(fmt.Sprintf("%05d", i)))
+
+		if err := l.Read(bs2, int64(i*973)); err != nil || !bytes.Equal(bs, bs2) {
+			t.Errorf("Got invalid item for %d (err=%v)", i, err)
+		}
+	}
+
+	l.Trim(int64(n * 973))
+	l.Commit()
+	if err := l.Read(bs2, 0); err == nil {
+		t.Errorf("expected trimmed data to be freed")
+	}
+
+	if ml := l.(*memLog); len(ml.chunks) != 1 {
+		t.Errorf("expected 1 chunk, got %d", len(ml.chunks))
+	}
+}
+
+func TestPlasmaInMemoryLSS(t *testing.T) {
+	path := "teststore.mem"
+	os.RemoveAll(path)
+	RemoveInMemoryLSS(path)
+	defer RemoveInMemoryLSS(path)
+
+	cfg := DefaultConfig()
+	cfg.File = path
+	cfg.InMemoryLSS = true
+	s := newTestIntPlasmaStore(cfg)
+
+	n := 100000
+	w := s.NewWriter()
+	for i := 0; i < n; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+	}
+
+	s.EvictAll()
+	for i := 0; i < n; i++ {
+		if v, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i))); err != nil || string(v) != fmt.Sprintf("val-%10d", i) {
+			t.Errorf("expected value for %d, got %s (err=%v)", i, string(v), err)
+		}
+	}
+
+	if _, err := New(cfg); err != ErrStoreLocked {
+		t.Errorf("expected %v, got %v", ErrStoreLocked, err)
+	}
+
+	s.Close()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected no files to be created")
+	}
+
+	s = newTestIntPlasmaStore(cfg)
+	w = s.NewWriter()
+	for i := 0; i < n; i++ {
+		if v, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i))); err != nil || string(v) != fmt.Sprintf("val-%10d", i) {
+			t.Errorf("expected recovered value for %d, got %s (err=%v)", i, string(v), err)
+		}
+	}
+	s.Close()
+
+	if err := RemoveInMemoryLSS(path); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+	if sts := s.GetStats(); sts.Inserts != 0 || sts.NumPages != 1 {
+		t.Errorf("expected an empty store, got %d inserts", sts.Inserts)
+	}
+}
+
+func TestPlasmaInMemoryLSSEphemeral(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.InMemoryLSS = true
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	n := 10000
+	w := s.NewWriter()
+	for i := 0; i < n; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), nil)
+	}
+
+	s.EvictAll()
+	if sts := s.GetStats(); sts.BytesWritten == 0 || sts.NumRecordSwapOut == 0 {
+		t.Errorf("expected pages to be evicted to the log")
+	}
+
+	for i := 0; i < n; i++ {
+		if _, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i))); err != ErrItemNoValue {
+			t.Errorf("expected %v for %d, got %v", ErrItemNoValue, i, err)
+		}
+	}
+}
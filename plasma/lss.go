@@ -18,6 +18,7 @@ const lssReclaimBlockSize = 1024 * 1024 * 8
 const expiredLSSOffset = LSSOffset(^uint64(0))
 
 var ErrCorruptSuperBlock = errors.New("Superblock is corrupted")
+var ErrCorruptLSSBlock = errors.New("LSS block is corrupted")
 
 type LSSOffset uint64
 type LSSResource interface{}
@@ -40,6 +41,9 @@ type LSS interface {
 	HeadOffset() LSSOffset
 	TailOffset() LSSOffset
 	UsedSpace() int64
+	ResetHead(LSSOffset)
+	GetMeta() []byte
+	SetMeta([]byte) error
 	Close()
 }
 
@@ -62,7 +66,8 @@ type lsStore struct {
 	segmentSize int64
 
 	lastCommitTS   time.Time
-	commitDuration time.Duration
+	commitDuration int64 // time.Duration, updated atomically
+	clock          Clock
 	trimOffset     LSSOffset
 	log            Log
 
@@ -88,20 +93,35 @@ func (s *lsStore) BytesWritten() int64 {
 }
 
 func NewLSStore(path string, segSize int64, bufSize int, nbufs int, mmap bool, commitDur time.Duration) (LSS, error) {
-	var err error
+	log, err := newLog(path, segSize, commitDur == 0, mmap)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLSStoreWithLog(log, path, segSize, bufSize, nbufs, commitDur), nil
+}
+
+// Log structured store backed by memory. See Config.InMemoryLSS.
+func NewMemLSStore(path string, bufSize int, nbufs int, commitDur time.Duration) (LSS, error) {
+	log, err := openMemLog(path)
+	if err != nil {
+		return nil, err
+	}
 
+	return newLSStoreWithLog(log, path, memLogChunkSize, bufSize, nbufs, commitDur), nil
+}
+
+func newLSStoreWithLog(log Log, path string, segSize int64, bufSize int, nbufs int, commitDur time.Duration) *lsStore {
 	s := &lsStore{
 		path:           path,
 		segmentSize:    segSize,
 		nbufs:          nbufs,
 		bufSize:        bufSize,
 		trimBatchSize:  int64(bufSize),
-		commitDuration: commitDur,
+		commitDuration: int64(commitDur),
+		clock:          SystemClock,
 		safeOffset:     func() LSSOffset { return expiredLSSOffset },
-	}
-
-	if s.log, err = newLog(path, segSize, commitDur == 0, mmap); err != nil {
-		return nil, err
+		log:            log,
 	}
 
 	head := newFlushBuffer(bufSize, s.flush)
@@ -124,13 +144,38 @@ func NewLSStore(path string, segSize int64, bufSize int, nbufs int, mmap bool, c
 	s.head = unsafe.Pointer(head)
 	s.tail = s.head
 
-	return s, nil
+	return s
 }
 
 func (s *lsStore) Close() {
 	s.log.Close()
 }
 
+// Discard all blocks before off. Blocks in the range should not be
+// referenced by any page.
+func (s *lsStore) ResetHead(off LSSOffset) {
+	s.Lock()
+	defer s.Unlock()
+
+	atomic.StoreInt64(&s.startOffset, int64(off))
+	atomic.StoreInt64(&s.cleanerTrimOffset, int64(off))
+	s.TrimLog(off)
+}
+
+// Metadata is persisted in the log superblock on the next commit
+// Log writes are committed once older than d
+func (s *lsStore) setCommitDuration(d time.Duration) {
+	atomic.StoreInt64(&s.commitDuration, int64(d))
+}
+
+func (s *lsStore) SetMeta(meta []byte) error {
+	return s.log.SetMeta(meta)
+}
+
+func (s *lsStore) GetMeta() []byte {
+	return s.log.GetMeta()
+}
+
 func (s *lsStore) UsedSpace() int64 {
 	return s.log.Size()
 }
@@ -144,20 +189,20 @@ func (s *lsStore) flush(fb *flushBuffer) {
 		}
 
 		fmt.Printf("Plasma: (%s) Unable to write - err %v\n", s.path, err)
-		time.Sleep(time.Second)
+		s.clock.Sleep(time.Second)
 	}
 
 	if trimOffset, doTrim := fb.GetTrimLogOffset(); doTrim {
 		s.trimOffset = trimOffset
 	}
 
-	doCommit := fb.doCommit || time.Since(s.lastCommitTS) > s.commitDuration
+	doCommit := fb.doCommit || s.clock.Now().Sub(s.lastCommitTS) > time.Duration(atomic.LoadInt64(&s.commitDuration))
 
 	if doCommit {
 		off := minLSSOffset(s.safeOffset(), s.trimOffset)
 		s.log.Trim(int64(off))
 		s.log.Commit()
-		s.lastCommitTS = time.Now()
+		s.lastCommitTS = s.clock.Now()
 	}
 
 	nextFb := fb.NextBuffer()
@@ -248,6 +293,10 @@ retry:
 	}
 
 	l := int(binary.BigEndian.Uint32(lenBuf))
+	if int64(l) > tailOff-offset-headerFBSize {
+		return 0, ErrCorruptLSSBlock
+	}
+
 	err := s.log.Read(buf.Get(0, l), offset+headerFBSize)
 	return l, err
 }
@@ -279,7 +328,15 @@ func (s *lsStore) RunCleaner(callb LSSCleanerCallback, buf *Buffer) error {
 		return cont, nil
 	}
 
-	return s.visitor(startOff, tailOff, fn, buf)
+	err := s.visitor(startOff, tailOff, fn, buf)
+
+	// Trim the blocks cleaned since the last batch
+	if cleanOff := atomic.LoadInt64(&s.startOffset); cleanOff > s.cleanerTrimOffset {
+		s.TrimLog(LSSOffset(cleanOff))
+		atomic.StoreInt64(&s.cleanerTrimOffset, cleanOff)
+	}
+
+	return err
 }
 
 func (s *lsStore) Visitor(callb LSSBlockCallback, buf *Buffer) error {
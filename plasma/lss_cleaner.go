@@ -1,11 +1,16 @@
 package plasma
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync/atomic"
 	"time"
 )
 
+var ErrDefragmentNotSupported = errors.New("defragmentation requires a persistent store")
+var ErrTrimNotSupported = errors.New("log trimming requires a persistent store")
+
 func (s *Plasma) tryPageRelocation(pid PageId, pg Page, buf *Buffer, ctx *wCtx) (bool, LSSOffset) {
 	var ok bool
 	bs, dataSz, staleSz, numSegments := pg.Marshal(buf, FullMarshal)
@@ -29,6 +34,11 @@ func (s *Plasma) tryPageRelocation(pid PageId, pg Page, buf *Buffer, ctx *wCtx)
 }
 
 func (s *Plasma) CleanLSS(proceed func() bool) error {
+	return s.cleanLSS(proceed, expiredLSSOffset)
+}
+
+// Clean the log up to the first block ending at or after end
+func (s *Plasma) cleanLSS(proceed func() bool, end LSSOffset) error {
 	var pg Page
 	w := s.lssCleanerWriter
 	relocBuf := w.GetBuffer(bufReloc)
@@ -38,7 +48,13 @@ func (s *Plasma) CleanLSS(proceed func() bool) error {
 	retries := 0
 	skipped := 0
 
+	startTime := s.Clock.Now()
+	var readBytes int64
+
 	callb := func(startOff, endOff LSSOffset, bs []byte) (cont bool, headOff LSSOffset, err error) {
+		readBytes += int64(len(bs))
+		s.throttleCleaner(startTime, readBytes)
+
 		tok := w.BeginTx()
 		defer w.EndTx(tok)
 
@@ -82,7 +98,7 @@ func (s *Plasma) CleanLSS(proceed func() bool) error {
 			}
 			s.mvcc.Unlock()
 			return true, endOff, nil
-		case lssDiscard, lssPageUpdate, lssPageRemove:
+		case lssDiscard, lssPageUpdate, lssPageRemove, lssOpMarker:
 			return true, endOff, nil
 		case lssMaxSn:
 			maxSn := decodeMaxSn(bs[lssBlockTypeSize:])
@@ -98,18 +114,91 @@ func (s *Plasma) CleanLSS(proceed func() bool) error {
 		return true, endOff, nil
 	}
 
+	cleanUpTo := func(startOff, endOff LSSOffset, bs []byte) (bool, LSSOffset, error) {
+		cont, headOff, err := callb(startOff, endOff, bs)
+		return cont && endOff < end, headOff, err
+	}
+
 	frag, ds, used := s.GetLSSInfo()
 	start := s.lss.HeadOffset()
-	end := s.lss.TailOffset()
-	fmt.Printf("logCleaner: starting... frag %d, data: %d, used: %d log:(%d - %d)\n", frag, ds, used, start, end)
-	err := s.lss.RunCleaner(callb, cleanerBuf)
+	tail := s.lss.TailOffset()
+	fmt.Printf("logCleaner: starting... frag %d, data: %d, used: %d log:(%d - %d)\n", frag, ds, used, start, tail)
+	err := s.lss.RunCleaner(cleanUpTo, cleanerBuf)
 	frag, ds, used = s.GetLSSInfo()
 	start = s.lss.HeadOffset()
-	end = s.lss.TailOffset()
-	fmt.Printf("logCleaner: completed... frag %d, data: %d, used: %d, relocated: %d, retries: %d, skipped: %d log:(%d - %d)\n", frag, ds, used, relocated, retries, skipped, start, end)
+	tail = s.lss.TailOffset()
+	fmt.Printf("logCleaner: completed... frag %d, data: %d, used: %d, relocated: %d, retries: %d, skipped: %d log:(%d - %d)\n", frag, ds, used, relocated, retries, skipped, start, tail)
 	return err
 }
 
+// Sleep to keep the bytes read by the cleaner since start within
+// LSSCleanerMaxRate
+func (s *Plasma) throttleCleaner(start time.Time, bytes int64) {
+	if s.LSSCleanerMaxRate <= 0 {
+		return
+	}
+
+	due := time.Duration(float64(bytes) / float64(s.LSSCleanerMaxRate) * float64(time.Second))
+	if d := due - s.Clock.Now().Sub(start); d > 0 {
+		s.Clock.Sleep(d)
+	}
+}
+
+// Relocate the live pages of the log written before the call to its tail
+// and release the log before them, regardless of LSSCleanerThreshold. It is
+// meant to shrink the log after large deletions. Defragment stops with the
+// context error if ctx is done before it completes.
+func (s *Plasma) Defragment(ctx context.Context) error {
+	if !s.shouldPersist {
+		return ErrDefragmentNotSupported
+	}
+
+	err := s.CleanLSS(func() bool {
+		return ctx.Err() == nil
+	})
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	// Commit the relocated pages and the trimmed log
+	s.lss.Sync(true)
+	return err
+}
+
+// Relocate the live pages of the log before the given offset and release
+// the log up to the first record at or after it. Trimming stops at the log
+// offset of the oldest recovery point, so that the log written since every
+// recovery point remains available. Returns the new head of the log.
+func (s *Plasma) TrimLSS(before LSSOffset) (LSSOffset, error) {
+	if !s.shouldPersist {
+		return 0, ErrTrimNotSupported
+	}
+
+	s.mvcc.RLock()
+	for _, rp := range s.recoveryPoints {
+		if rp.logOffset < before {
+			before = rp.logOffset
+		}
+	}
+	s.mvcc.RUnlock()
+
+	if tail := s.lss.TailOffset(); before > tail {
+		before = tail
+	}
+
+	if before > s.lss.HeadOffset() {
+		if err := s.cleanLSS(func() bool { return true }, before); err != nil {
+			return s.lss.HeadOffset(), err
+		}
+
+		// Commit the relocated pages and the trimmed log
+		s.lss.Sync(true)
+	}
+
+	return s.lss.HeadOffset(), nil
+}
+
 func (s *Plasma) GetLSSInfo() (frag int, data int64, used int64) {
 	frag = 0
 	data = s.LSSDataSize()
@@ -122,13 +211,18 @@ func (s *Plasma) GetLSSInfo() (frag int, data int64, used int64) {
 }
 
 func (s *Plasma) lssCleanerDaemon() {
+	s.superviseWorker("lss_cleaner", s.lssCleaner)
+}
+
+func (s *Plasma) lssCleaner(ws *workerState) {
 	shouldClean := func() bool {
 		frag, _, _ := s.GetLSSInfo()
-		return frag > 0 && frag > s.Config.LSSCleanerThreshold
+		return frag > 0 && frag > s.liveConfig().LSSCleanerThreshold
 	}
 
 loop:
 	for {
+		ws.beat()
 		select {
 		case <-s.stoplssgc:
 			s.stoplssgc <- struct{}{}
@@ -137,11 +231,13 @@ loop:
 		}
 
 		if shouldClean() {
+			ws.doing("cleaning")
 			if err := s.CleanLSS(shouldClean); err != nil {
 				fmt.Printf("logCleaner: failed (err=%v)\n", err)
 			}
 		}
 
-		time.Sleep(time.Second)
+		ws.wait(time.Second)
+		s.Clock.Sleep(time.Second)
 	}
 }
@@ -0,0 +1,145 @@
+package plasma
+
+import (
+	"errors"
+	"github.com/couchbase/nitro/skiplist"
+	"runtime"
+	"unsafe"
+)
+
+var ErrPageNotSplittable = errors.New("page does not have enough distinct items to split")
+
+// fetchPage which also accepts skiplist.MinItem
+func (s *Plasma) fetchPageForKey(itm unsafe.Pointer, ctx *wCtx) (PageId, Page, error) {
+	if itm == skiplist.MinItem {
+		pid := s.StartPageId()
+		pg, err := s.ReadPage(pid, ctx.pgRdrFn, false, ctx)
+		return pid, pg, err
+	}
+
+	return s.fetchPage(itm, ctx)
+}
+
+func (s *Plasma) copyKey(itm unsafe.Pointer) unsafe.Pointer {
+	if itm == skiplist.MinItem || itm == skiplist.MaxItem {
+		return itm
+	}
+
+	buf := make([]byte, s.itemSize(itm))
+	s.copyItem(unsafe.Pointer(&buf[0]), itm, len(buf))
+	return unsafe.Pointer(&buf[0])
+}
+
+func (s *Plasma) compactPage(pid PageId, pg Page, ctx *wCtx) bool {
+	staleFdSz := pg.Compact()
+	if !s.UpdateMapping(pid, pg, ctx) {
+		ctx.sts.CompactConflicts++
+		return false
+	}
+
+	ctx.sts.Compacts++
+	ctx.sts.FlushDataSz -= int64(staleFdSz)
+	return true
+}
+
+// Compact the pages overlapping the key range [lo, hi).
+// skiplist.MinItem and skiplist.MaxItem can be used as the range bounds.
+func (s *Plasma) CompactRange(lo, hi unsafe.Pointer) error {
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	key := lo
+	for {
+		pid, pg, err := s.fetchPageForKey(key, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		if !s.compactPage(pid, pg, w.wCtx) {
+			continue
+		}
+
+		maxItm := pg.MaxItem()
+		if maxItm == skiplist.MaxItem || s.cmp(maxItm, hi) >= 0 {
+			return nil
+		}
+
+		key = s.copyKey(maxItm)
+	}
+}
+
+// Split the page containing the key into two pages at its middle item
+func (s *Plasma) SplitPage(key unsafe.Pointer) error {
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	// Split considers only the base page items
+	for compacted := false; !compacted; {
+		pid, pg, err := s.fetchPageForKey(key, w.wCtx)
+		if err != nil {
+			return err
+		}
+		compacted = s.compactPage(pid, pg, w.wCtx)
+	}
+
+	for {
+		pid, pg, err := s.fetchPageForKey(key, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		updated, split := s.trySplit(pid, pg, w.wCtx)
+		if split {
+			return nil
+		} else if updated {
+			return ErrPageNotSplittable
+		}
+	}
+}
+
+// Merge the pages starting within the key range (lo, hi) into the page
+// containing lo
+func (s *Plasma) MergeRange(lo, hi unsafe.Pointer) error {
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+	ctx := w.wCtx
+
+	for {
+		_, pg, err := s.fetchPageForKey(lo, ctx)
+		if err != nil {
+			return err
+		}
+
+		pid := pg.Next()
+		if pid == s.EndPageId() {
+			return nil
+		}
+
+		npg, err := s.ReadPage(pid, ctx.pgRdrFn, false, ctx)
+		if err != nil {
+			return err
+		}
+
+		if npg.NeedRemoval() {
+			s.tryPageRemoval(pid, npg, ctx)
+			continue
+		}
+
+		if npg.MinItem() == skiplist.MaxItem || s.cmp(npg.MinItem(), hi) >= 0 {
+			return nil
+		}
+
+		if !s.isMergablePage(pid, ctx) {
+			runtime.Gosched()
+			continue
+		}
+
+		npg.Close()
+		if s.UpdateMapping(pid, npg, ctx) {
+			s.tryPageRemoval(pid, npg, ctx)
+			ctx.sts.Merges++
+		} else {
+			ctx.sts.MergeConflicts++
+		}
+	}
+}
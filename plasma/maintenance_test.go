@@ -0,0 +1,76 @@
+package plasma
+
+import (
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+)
+
+func TestPlasmaPageMaintenance(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testCfg
+	cfg.MaxPageItems = 100000
+	cfg.MaxDeltaChainLen = 100000
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+
+	if n := s.GetStats().NumPages; n != 1 {
+		t.Fatalf("expected 1 page, got %d", n)
+	}
+
+	if err := s.CompactRange(skiplist.MinItem, skiplist.MaxItem); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := s.SplitPage(skiplist.NewIntKeyItem(9999)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	if n := s.GetStats().NumPages; n != 4 {
+		t.Errorf("expected 4 pages, got %d", n)
+	}
+
+	w.Insert(skiplist.NewIntKeyItem(20000))
+	if err := s.SplitPage(skiplist.NewIntKeyItem(20000)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := s.CompactRange(skiplist.NewIntKeyItem(5000), skiplist.NewIntKeyItem(9000)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := s.MergeRange(skiplist.NewIntKeyItem(0), skiplist.NewIntKeyItem(9000)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if n := s.GetStats().NumPages; n != 2 {
+		t.Errorf("expected 2 pages, got %d", n)
+	}
+
+	if err := s.MergeRange(skiplist.MinItem, skiplist.MaxItem); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if n := s.GetStats().NumPages; n != 1 {
+		t.Errorf("expected 1 page, got %d", n)
+	}
+
+	if err := s.SplitPage(skiplist.NewIntKeyItem(0)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for i := 0; i < 10000; i++ {
+		itm := skiplist.NewIntKeyItem(i)
+		got, _ := w.Lookup(itm)
+		if skiplist.CompareInt(itm, got) != 0 {
+			t.Errorf("mismatch %d != %d", i, skiplist.IntFromItem(got))
+		}
+	}
+}
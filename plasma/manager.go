@@ -0,0 +1,285 @@
+package plasma
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrManagerClosed = errors.New("manager is closed")
+
+const managerCleanerInterval = time.Second
+
+type ManagerConfig struct {
+	// Memory quota shared by the instances. Zero leaves memory management
+	// to the config of each instance.
+	MemoryQuota int64
+
+	// Log cleaner threads shared by the instances which have
+	// AutoLSSCleaning set. Zero leaves each instance with its own cleaner.
+	NumCleanerThreads int
+
+	// Maximum number of instances persisted concurrently by PersistAll
+	NumPersistorThreads int
+}
+
+// Manager tracks the instances opened through it, runs background workers
+// shared by the instances and closes all of them on shutdown
+type Manager struct {
+	sync.Mutex
+	cfg       ManagerConfig
+	quota     *QuotaManager
+	instances map[*Plasma]*managedInstance
+	closed    bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type managedInstance struct {
+	// Shared workers operating on the instance
+	active sync.WaitGroup
+
+	sharedCleaning bool
+	cleaning       bool
+	lastCleaned    time.Time
+}
+
+func NewManager(cfg ManagerConfig) *Manager {
+	m := &Manager{
+		cfg:       cfg,
+		instances: make(map[*Plasma]*managedInstance),
+		stop:      make(chan struct{}),
+	}
+
+	if cfg.MemoryQuota > 0 {
+		m.quota = NewQuotaManager(cfg.MemoryQuota)
+	}
+
+	for i := 0; i < cfg.NumCleanerThreads; i++ {
+		m.wg.Add(1)
+		go m.cleaner()
+	}
+
+	return m
+}
+
+// Open an instance managed by the manager
+func (m *Manager) Open(cfg Config) (*Plasma, error) {
+	m.Lock()
+	closed := m.closed
+	m.Unlock()
+	if closed {
+		return nil, ErrManagerClosed
+	}
+
+	mi := &managedInstance{}
+	if m.quota != nil {
+		cfg.QuotaManager = m.quota
+	}
+
+	if m.cfg.NumCleanerThreads > 0 && cfg.AutoLSSCleaning && (cfg.File != "" || cfg.InMemoryLSS) {
+		cfg.AutoLSSCleaning = false
+		mi.sharedCleaning = true
+	}
+
+	s, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		s.Close()
+		return nil, ErrManagerClosed
+	}
+
+	s.manager = m
+	m.instances[s] = mi
+	return s, nil
+}
+
+// Called by Plasma.Close. Waits for the shared workers operating on the
+// instance.
+func (m *Manager) remove(s *Plasma) {
+	m.Lock()
+	mi, ok := m.instances[s]
+	delete(m.instances, s)
+	m.Unlock()
+
+	if ok {
+		mi.active.Wait()
+	}
+}
+
+func (m *Manager) Instances() []*Plasma {
+	m.Lock()
+	defer m.Unlock()
+
+	insts := make([]*Plasma, 0, len(m.instances))
+	for s := range m.instances {
+		insts = append(insts, s)
+	}
+
+	return insts
+}
+
+// Stats summed over all the instances
+func (m *Manager) GetStats() Stats {
+	var sts Stats
+	for _, s := range m.Instances() {
+		is := s.GetStats()
+		sts.Merge(&is)
+		sts.NumPages += is.NumPages
+		sts.FlushDataSz += is.FlushDataSz
+		sts.MemSz += is.MemSz
+		sts.MemSzIndex += is.MemSzIndex
+		sts.BytesWritten += is.BytesWritten
+		sts.LSSDataSize += is.LSSDataSize
+		sts.LSSUsedSpace += is.LSSUsedSpace
+	}
+
+	return sts
+}
+
+// Residency of the instances, if the manager has a memory quota
+func (m *Manager) Residency() []InstanceResidency {
+	if m.quota == nil {
+		return nil
+	}
+
+	return m.quota.Residency()
+}
+
+// Persist all the instances, running at most NumPersistorThreads
+// instances at a time
+func (m *Manager) PersistAll() {
+	n := m.cfg.NumPersistorThreads
+	if n < 1 {
+		n = 1
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, n)
+	for _, s := range m.Instances() {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(s *Plasma) {
+			defer wg.Done()
+			if mi := m.acquire(s); mi != nil {
+				s.PersistAll()
+				mi.active.Done()
+			}
+			<-sem
+		}(s)
+	}
+
+	wg.Wait()
+}
+
+// Mark a shared worker active on the instance, if it is still open
+func (m *Manager) acquire(s *Plasma) *managedInstance {
+	m.Lock()
+	defer m.Unlock()
+
+	mi, ok := m.instances[s]
+	if !ok {
+		return nil
+	}
+
+	mi.active.Add(1)
+	return mi
+}
+
+// Pick the most fragmented instance which needs cleaning. An instance is
+// cleaned by one thread at a time and at most once per cleaner interval.
+func (m *Manager) nextCleanerWork() (*Plasma, *managedInstance) {
+	m.Lock()
+	defer m.Unlock()
+
+	var best *Plasma
+	var bestFrag int
+	now := time.Now()
+	for s, mi := range m.instances {
+		if !mi.sharedCleaning || mi.cleaning || now.Sub(mi.lastCleaned) < managerCleanerInterval {
+			continue
+		}
+
+		if frag, _, _ := s.GetLSSInfo(); frag > 0 && frag > s.liveConfig().LSSCleanerThreshold && frag > bestFrag {
+			best, bestFrag = s, frag
+		}
+	}
+
+	if best == nil {
+		return nil, nil
+	}
+
+	mi := m.instances[best]
+	mi.cleaning = true
+	mi.active.Add(1)
+	return best, mi
+}
+
+func (m *Manager) cleaner() {
+	defer m.wg.Done()
+
+	for {
+		s, mi := m.nextCleanerWork()
+		if s != nil {
+			shouldClean := func() bool {
+				select {
+				case <-m.stop:
+					return false
+				default:
+				}
+
+				frag, _, _ := s.GetLSSInfo()
+				return frag > 0 && frag > s.liveConfig().LSSCleanerThreshold
+			}
+
+			if err := s.CleanLSS(shouldClean); err != nil {
+				fmt.Printf("logCleaner: failed (err=%v)\n", err)
+			}
+
+			m.Lock()
+			mi.cleaning = false
+			mi.lastCleaned = time.Now()
+			m.Unlock()
+			mi.active.Done()
+			continue
+		}
+
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(managerCleanerInterval):
+		}
+	}
+}
+
+// Close all the instances and stop the shared workers
+func (m *Manager) Close() {
+	m.Lock()
+	if m.closed {
+		m.Unlock()
+		return
+	}
+	m.closed = true
+	m.Unlock()
+
+	close(m.stop)
+	m.wg.Wait()
+
+	var wg sync.WaitGroup
+	for _, s := range m.Instances() {
+		wg.Add(1)
+		go func(s *Plasma) {
+			defer wg.Done()
+			s.Close()
+		}(s)
+	}
+
+	wg.Wait()
+}
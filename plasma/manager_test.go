@@ -0,0 +1,81 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	m := NewManager(ManagerConfig{
+		MemoryQuota:         maxMemoryQuota,
+		NumCleanerThreads:   1,
+		NumPersistorThreads: 2,
+	})
+
+	var insts []*Plasma
+	for i := 0; i < 3; i++ {
+		cfg := testSnCfg
+		cfg.File = fmt.Sprintf("teststore%d.data", i)
+		os.RemoveAll(cfg.File)
+		defer os.RemoveAll(cfg.File)
+
+		s, err := m.Open(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		insts = append(insts, s)
+	}
+
+	for v := 0; v < 5; v++ {
+		for _, s := range insts {
+			w := s.NewWriter()
+			for i := 0; i < 10000; i++ {
+				w.DeleteKV([]byte(fmt.Sprintf("key-%10d", i)))
+				w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", v)))
+			}
+		}
+		m.PersistAll()
+	}
+
+	if n := len(m.Instances()); n != 3 {
+		t.Errorf("expected 3 instances, got %d", n)
+	}
+
+	// Deletes are inserted as items
+	if sts := m.GetStats(); sts.Inserts != 3*100000 {
+		t.Errorf("expected %d inserts, got %d", 3*100000, sts.Inserts)
+	}
+
+	if rs := m.Residency(); len(rs) != 3 {
+		t.Errorf("expected residency of 3 instances, got %d", len(rs))
+	}
+
+	// Log cleaning is performed by the shared cleaner
+	var cleaned bool
+	for i := 0; i < 100 && !cleaned; i++ {
+		time.Sleep(time.Millisecond * 100)
+		m.Lock()
+		cleaned = !m.instances[insts[2]].lastCleaned.IsZero()
+		m.Unlock()
+	}
+
+	if !cleaned {
+		t.Errorf("expected the shared cleaner to clean the logs")
+	}
+
+	insts[0].Close()
+	if n := len(m.Instances()); n != 2 {
+		t.Errorf("expected 2 instances, got %d", n)
+	}
+
+	m.Close()
+	if n := len(m.Instances()); n != 0 {
+		t.Errorf("expected no instances, got %d", n)
+	}
+
+	if _, err := m.Open(testSnCfg); err != ErrManagerClosed {
+		t.Errorf("expected manager closed error, got %v", err)
+	}
+}
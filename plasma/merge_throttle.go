@@ -0,0 +1,87 @@
+package plasma
+
+import (
+	"sync"
+	"time"
+)
+
+// Pages split within MergeCooldown, which are not merged automatically
+type splitTracker struct {
+	sync.Mutex
+	pages map[PageId]time.Time
+}
+
+// Entries are pruned once the tracker grows beyond this size
+const splitTrackerPruneSize = 1024
+
+func (s *Plasma) recordSplit(pid, splitPid PageId) {
+	if s.MergeCooldown <= 0 {
+		return
+	}
+
+	now := s.Clock.Now()
+	t := &s.splits
+	t.Lock()
+	defer t.Unlock()
+
+	if t.pages == nil {
+		t.pages = make(map[PageId]time.Time)
+	}
+
+	if len(t.pages) >= splitTrackerPruneSize {
+		for p, at := range t.pages {
+			if now.Sub(at) >= s.MergeCooldown {
+				delete(t.pages, p)
+			}
+		}
+	}
+
+	t.pages[pid] = now
+	t.pages[splitPid] = now
+}
+
+func (s *Plasma) inMergeCooldown(pid PageId) bool {
+	if s.MergeCooldown <= 0 {
+		return false
+	}
+
+	t := &s.splits
+	t.Lock()
+	defer t.Unlock()
+
+	at, ok := t.pages[pid]
+	if ok && s.Clock.Now().Sub(at) >= s.MergeCooldown {
+		delete(t.pages, pid)
+		ok = false
+	}
+
+	return ok
+}
+
+// Check whether the page is to be merged into its left sibling. Pages are
+// not merged during the cooldown after a split of them, nor if the merged
+// page would be within MergeHysteresis items of being split again.
+func (s *Plasma) shouldMerge(pid PageId, pg Page, ctx *wCtx) bool {
+	cfg := s.liveConfig()
+	if !pg.NeedMerge(cfg.MinPageItems) || !s.isMergablePage(pid, ctx) {
+		return false
+	}
+
+	if s.inMergeCooldown(pid) {
+		ctx.sts.ThrottledMerges++
+		return false
+	}
+
+	_, ppg, err := s.prevPage(pid, pg, ctx)
+	if err != nil || ppg == nil {
+		return err == nil
+	}
+
+	numItems := int(ppg.(*page).head.numItems) + int(pg.(*page).head.numItems)
+	if numItems > cfg.MaxPageItems-cfg.MergeHysteresis {
+		ctx.sts.ThrottledMerges++
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,58 @@
+package plasma
+
+import (
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPlasmaMergeThrottle(t *testing.T) {
+	run := func(hysteresis int, cooldown time.Duration) Stats {
+		os.RemoveAll("teststore.data")
+		cfg := testCfg
+		cfg.MaxDeltaChainLen = 2
+		cfg.MaxPageItems = 100
+		cfg.MinPageItems = 40
+		cfg.MergeHysteresis = hysteresis
+		cfg.MergeCooldown = cooldown
+		s := newTestIntPlasmaStore(cfg)
+		defer s.Close()
+
+		w := s.NewWriter()
+		n := 20000
+		for i := 0; i < n; i++ {
+			w.Insert(skiplist.NewIntKeyItem(i))
+		}
+
+		// Shrink the pages below MinPageItems
+		for i := 0; i < n; i++ {
+			if i%50 < 20 {
+				w.Delete(skiplist.NewIntKeyItem(i))
+			}
+		}
+
+		for i := 0; i < n; i++ {
+			itm, err := w.Lookup(skiplist.NewIntKeyItem(i))
+			if err != nil || (itm == nil) != (i%50 < 20) {
+				t.Fatalf("unexpected lookup result for item %d", i)
+			}
+		}
+
+		return s.GetStats()
+	}
+
+	if sts := run(0, 0); sts.Merges == 0 {
+		t.Errorf("expected pages to be merged")
+	}
+
+	if sts := run(50, 0); sts.Merges != 0 || sts.ThrottledMerges == 0 {
+		t.Errorf("expected merges to be throttled by hysteresis, got %d merges, %d throttled",
+			sts.Merges, sts.ThrottledMerges)
+	}
+
+	if sts := run(0, time.Hour); sts.Merges != 0 || sts.ThrottledMerges == 0 {
+		t.Errorf("expected merges to be throttled by cooldown, got %d merges, %d throttled",
+			sts.Merges, sts.ThrottledMerges)
+	}
+}
@@ -1,8 +1,12 @@
 package plasma
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 )
@@ -10,6 +14,7 @@ import (
 var ErrItemNotFound = errors.New("item not found")
 var ErrItemNoValue = errors.New("item has no value")
 var ErrKeyTooLarge = errors.New("key is too large")
+var ErrValueTooLarge = errors.New("value is too large")
 
 type Snapshot struct {
 	sn       uint64
@@ -19,18 +24,100 @@ type Snapshot struct {
 
 	count     int64
 	persisted bool
-	meta      []byte
+
+	metaLock sync.Mutex
+	meta     []byte
+	tags     map[string]string
 }
 
 func (sn *Snapshot) Count() int64 {
 	return sn.count
 }
 
+// Mutations with sequence number up to SeqNum() are visible
+// in the snapshot
+func (sn *Snapshot) SeqNum() uint64 {
+	return sn.sn
+}
+
+// Metadata and tags of a snapshot are carried over to the recovery points
+// created from it. Snapshots returned to concurrent callers of NewSnapshot
+// may be shared, along with their metadata and tags.
+func (sn *Snapshot) SetMeta(meta []byte) {
+	sn.metaLock.Lock()
+	defer sn.metaLock.Unlock()
+	sn.meta = append([]byte(nil), meta...)
+}
+
+func (sn *Snapshot) Meta() []byte {
+	sn.metaLock.Lock()
+	defer sn.metaLock.Unlock()
+	return sn.meta
+}
+
+func (sn *Snapshot) SetTag(k, v string) {
+	sn.metaLock.Lock()
+	defer sn.metaLock.Unlock()
+	if sn.tags == nil {
+		sn.tags = make(map[string]string)
+	}
+	sn.tags[k] = v
+}
+
+func (sn *Snapshot) Tag(k string) (string, bool) {
+	sn.metaLock.Lock()
+	defer sn.metaLock.Unlock()
+	v, ok := sn.tags[k]
+	return v, ok
+}
+
+func (sn *Snapshot) Tags() map[string]string {
+	sn.metaLock.Lock()
+	defer sn.metaLock.Unlock()
+	return copyTags(sn.tags)
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	cp := make(map[string]string, len(tags))
+	for k, v := range tags {
+		cp[k] = v
+	}
+
+	return cp
+}
+
+const numItemsCounters = 32
+
+// Writers share a fixed set of padded counters, so that snapshot creation
+// does not need to visit every writer to compute the items count
+type itemsCounter struct {
+	v int64
+	_ [56]byte
+}
+
+func (c *itemsCounter) add(n int64) {
+	atomic.AddInt64(&c.v, n)
+}
+
+func (s *Plasma) sumItemsCounters() int64 {
+	var n int64
+	for i := range s.itemsCounters {
+		n += atomic.LoadInt64(&s.itemsCounters[i].v)
+	}
+
+	return n
+}
+
 type rollbackSn struct {
 	start, end uint64
 }
 
 type rollbackFilter struct {
+	codec   ItemCodec
 	filters []*rollbackSn
 }
 
@@ -39,8 +126,7 @@ func (f *rollbackFilter) Process(o PageItem) PageItemsList {
 		return o
 	}
 
-	itm := (*item)(o.Item())
-	sn := itm.Sn()
+	sn := f.codec.Sn(o.Item())
 	for _, filter := range f.filters {
 		if sn >= filter.start && sn <= filter.end {
 			return nilPageItemsList
@@ -61,8 +147,11 @@ func (f *rollbackFilter) Reset() {
 
 // Used by snapshot iterator
 type snFilter struct {
-	sn   uint64
-	skip bool
+	sn      uint64
+	skip    bool
+	skipKey []byte
+	// Return the deletes, which hide the older versions all the same
+	deletes bool
 	rollbackFilter
 }
 
@@ -71,14 +160,26 @@ func (f *snFilter) Process(o PageItem) PageItemsList {
 		return nilPageItemsList
 	}
 
-	itm := (*item)(o.Item())
-	if f.skip || itm.Sn() > f.sn {
+	// Only an older version of the deleted key is hidden by the delete,
+	// the delete of a missing key is followed by the next key
+	itm := o.Item()
+	if f.skip {
 		f.skip = false
+		if bytes.Equal(f.skipKey, f.codec.Key(itm)) {
+			return nilPageItemsList
+		}
+	}
+
+	if f.codec.Sn(itm) > f.sn {
 		return nilPageItemsList
 	}
 
-	if !itm.IsInsert() {
+	if !f.codec.IsInsert(itm) {
 		f.skip = true
+		f.skipKey = append(f.skipKey[:0], f.codec.Key(itm)...)
+		if f.deletes {
+			return o
+		}
 		return nilPageItemsList
 	}
 
@@ -89,7 +190,7 @@ func (f *snFilter) Process(o PageItem) PageItemsList {
 type gcFilter struct {
 	snIntervals []uint64
 
-	skipItm *item
+	skipItm PageItem
 	rollbackFilter
 }
 
@@ -115,22 +216,29 @@ func (f *gcFilter) Process(o PageItem) PageItemsList {
 		return nilPageItemsList
 	}
 
-	itm := (*item)(o.Item())
-	sn := itm.Sn()
+	itm := o.Item()
+	sn := f.codec.Sn(itm)
 	skipItm := f.skipItm
 	f.skipItm = nil
 
-	if !itm.IsInsert() {
-		f.skipItm = itm
+	if !f.codec.IsInsert(itm) {
+		f.skipItm = o
 		return nilPageItemsList
 	}
 
 	if skipItm != nil {
-		if skipItm.Sn() == sn {
+		// Only an older version of the deleted key is garbage, the delete
+		// may well be followed by the next key inserted at the same sn
+		if !bytes.Equal(f.codec.Key(skipItm.Item()), f.codec.Key(itm)) {
+			return (*pageItemsList)(&[]PageItem{skipItm, o})
+		}
+
+		skipSn := f.codec.Sn(skipItm.Item())
+		if skipSn == sn {
 			return nilPageItemsList
 		}
 
-		if in, ok := f.findInterval(skipItm.Sn()); ok {
+		if in, ok := f.findInterval(skipSn); ok {
 			if f.inInterval(in, sn) {
 				return nilPageItemsList
 			}
@@ -153,39 +261,149 @@ type MVCCIterator struct {
 	snap *Snapshot
 	*Iterator
 	token TxToken
+
+	// Pinned sequence number of an iterator without snapshot
+	pinSn uint64
 }
 
 func (itr *MVCCIterator) Seek(k []byte) {
-	sn := atomic.LoadUint64(&itr.snap.db.currSn)
+	sn := atomic.LoadUint64(&itr.store.currSn)
 	kbuf := itr.Iterator.GetBuffer(bufTempItem)
-	newItm, _ := newItem(k, nil, sn, false, kbuf)
-	itm := unsafe.Pointer(newItm)
+	itm, _ := itr.codec().NewItem(k, nil, sn, nil, false, kbuf)
 	itr.Iterator.Seek(itm)
 }
 
+// Position at the first key >= k, same as Seek
+func (itr *MVCCIterator) SeekGE(k []byte) {
+	itr.Seek(k)
+}
+
+// Position at k and return true if it is visible to the iterator.
+// Otherwise, the iterator is positioned at the first key > k.
+func (itr *MVCCIterator) SeekExact(k []byte) bool {
+	itr.Seek(k)
+	return itr.Valid() && bytes.Equal(itr.Key(), k)
+}
+
+// Position at the last key < k. The iterator is invalid if there is no
+// such key. The pages are scanned from the page owning k to the left
+// until a key is found, after which the iterator seeks to it.
+func (itr *MVCCIterator) SeekLT(k []byte) {
+	sn := atomic.LoadUint64(&itr.store.currSn)
+	kbuf := itr.Iterator.GetBuffer(bufTempItem)
+	itm, _ := itr.codec().NewItem(k, nil, sn, nil, false, kbuf)
+
+	var pid PageId
+	if prev, curr, found := itr.store.Skiplist.Lookup(itm, itr.store.cmp, itr.wCtx.buf, itr.wCtx.slSts); found {
+		pid = curr
+	} else {
+		pid = prev
+	}
+
+	var last []byte
+	for pid != nil {
+		itr.initPgIterator(pid, nil)
+		for ; itr.Valid() && bytes.Compare(itr.Key(), k) < 0; itr.currPgItr.Next() {
+			last = append(last[:0], itr.Key()...)
+		}
+		itr.Iterator.Close()
+
+		if last != nil {
+			itr.Seek(last)
+			return
+		}
+
+		pg, err := itr.store.ReadPage(pid, itr.wCtx.pgRdrFn, false, itr.wCtx)
+		if err != nil {
+			itr.err = err
+			return
+		}
+
+		if pid, _, err = itr.store.prevPage(pid, pg, itr.wCtx); err != nil {
+			itr.err = err
+			return
+		}
+	}
+}
+
+func (itr *MVCCIterator) codec() ItemCodec {
+	return itr.Iterator.store.ItemCodec
+}
+
 func (itr *MVCCIterator) Key() []byte {
-	return (*item)(itr.Get()).Key()
+	return itr.codec().Key(itr.Get())
 }
 
+// Returns nil and sets the error returned by Err if the item is corrupt
 func (itr *MVCCIterator) Value() []byte {
-	return (*item)(itr.Get()).Value()
+	if err := itr.store.verifyItem(itr.Get(), itr.wCtx); err != nil {
+		itr.err = err
+		return nil
+	}
+
+	return itr.codec().Value(itr.Get())
+}
+
+func (itr *MVCCIterator) Err() error {
+	return itr.err
 }
 
 func (itr *MVCCIterator) HasValue() bool {
-	return (*item)(itr.Get()).HasValue()
+	return itr.codec().HasValue(itr.Get())
+}
+
+// Metadata written using InsertKVMeta, zero for items without metadata
+func (itr *MVCCIterator) Meta() uint64 {
+	return itr.codec().Meta(itr.Get())
 }
 
 func (itr *MVCCIterator) Close() {
-	itr.snap.Close()
+	if itr.snap != nil {
+		itr.snap.Close()
+	} else {
+		itr.store.unpinSn(itr.pinSn)
+	}
+	itr.Iterator.Close()
+	itr.EndTx(itr.token)
+}
+
+// Move the iterator onto a new snapshot of the store, releasing the
+// snapshot or sequence number it held, and seek to the key it was
+// positioned at. The iterator moves to the next key if the key was
+// deleted since. An invalid iterator remains invalid.
+func (itr *MVCCIterator) Refresh() {
+	var key []byte
+	valid := itr.Valid()
+	if valid {
+		key = append([]byte(nil), itr.Key()...)
+	}
+
+	snap := itr.store.NewSnapshot()
+	if itr.snap != nil {
+		itr.snap.Close()
+	} else {
+		itr.store.unpinSn(itr.pinSn)
+	}
+
+	itr.snap, itr.pinSn = snap, 0
+	itr.filter.(*snFilter).sn = snap.sn
+
+	// Let the memory reclaimed since the iterator was created be freed
 	itr.Iterator.Close()
 	itr.EndTx(itr.token)
+	itr.token = itr.BeginTx()
+
+	if valid {
+		itr.Seek(key)
+	}
 }
 
 func (s *Snapshot) NewIterator() *MVCCIterator {
 	s.Open()
 	itr := s.db.NewIterator().(*Iterator)
 	itr.filter = &snFilter{
-		sn: s.sn,
+		sn:             s.sn,
+		rollbackFilter: rollbackFilter{codec: s.db.ItemCodec},
 	}
 
 	tok := itr.BeginTx()
@@ -200,10 +418,64 @@ func (s *Snapshot) Open() {
 	atomic.AddInt32(&s.refCount, 1)
 }
 
+// Sequence number assigned to the mutations performed currently
+func (s *Plasma) CurrentSeqNum() uint64 {
+	return atomic.LoadUint64(&s.currSn)
+}
+
 func (s *Plasma) NewSnapshot() (snap *Snapshot) {
+	if s.SnapshotBatchWindow > 0 {
+		return s.newBatchedSnapshot()
+	}
+
 	s.mvcc.Lock()
-	defer s.mvcc.Unlock()
-	return s.newSnapshot()
+	snap = s.newSnapshot()
+	s.mvcc.Unlock()
+
+	s.freeWriterObjects()
+	return snap
+}
+
+type snapshotBatch struct {
+	waiters int32
+	snap    *Snapshot
+	done    chan struct{}
+}
+
+// Requests arriving within the batch window share a single snapshot.
+// The snapshot is created at the end of the window, so that it covers
+// the mutations performed by every caller before requesting it.
+func (s *Plasma) newBatchedSnapshot() *Snapshot {
+	s.snBatchLock.Lock()
+	b := s.snBatch
+	leader := b == nil
+	if leader {
+		b = &snapshotBatch{done: make(chan struct{})}
+		s.snBatch = b
+	}
+	b.waiters++
+	s.snBatchLock.Unlock()
+
+	if !leader {
+		<-b.done
+		return b.snap
+	}
+
+	s.Clock.Sleep(s.SnapshotBatchWindow)
+
+	s.snBatchLock.Lock()
+	s.snBatch = nil
+	s.snBatchLock.Unlock()
+
+	s.mvcc.Lock()
+	snap := s.newSnapshot()
+	atomic.AddInt32(&snap.refCount, b.waiters-1)
+	s.mvcc.Unlock()
+	s.freeWriterObjects()
+
+	b.snap = snap
+	close(b.done)
+	return snap
 }
 
 func (s *Plasma) newSnapshot() (snap *Snapshot) {
@@ -223,90 +495,413 @@ func (s *Plasma) newSnapshot() (snap *Snapshot) {
 	s.currSnapshot.child = nextSnap
 	s.currSnapshot = nextSnap
 	s.updateMaxSn(nextSnap.sn, false)
+	s.snTimes.record(nextSnap.sn, s.Clock.Now())
+
+	s.itemsCount = s.itemsCountOff + s.sumItemsCounters()
+	snap.count = s.itemsCount
+
+	return
+}
+
+// Hands the objects released by the writers over to SMR. It is done
+// outside of the mvcc lock, so that snapshot creation does not wait for
+// walking the writers. The writers list is guarded by the store lock.
+func (s *Plasma) freeWriterObjects() {
+	if !s.useMemMgmt {
+		return
+	}
 
 	var smrList [][]reclaimObject
+	s.Lock()
 	for _, w := range s.wlist {
-		if s.useMemMgmt {
-			if len(w.wCtx.reclaimList) > 0 {
-				smrList = append(smrList, w.wCtx.reclaimList)
-				w.wCtx.reclaimList = nil
-			}
+		if len(w.wCtx.reclaimList) > 0 {
+			smrList = append(smrList, w.wCtx.reclaimList)
+			w.wCtx.reclaimList = nil
+		}
+	}
+	s.Unlock()
+
+	s.FreeObjects(smrList)
+}
+
+// Returns the sequence number assigned to the mutation
+func (w *Writer) InsertKV(k, v []byte) (uint64, error) {
+	return w.insertKV(k, v, nil)
+}
+
+// Insert a key value pair along with a fixed size application defined
+// metadata, which can be read without decoding the value
+func (w *Writer) InsertKVMeta(k, v []byte, meta uint64) (uint64, error) {
+	return w.insertKV(k, v, &meta)
+}
+
+func (w *Writer) insertKV(k, v []byte, meta *uint64) (uint64, error) {
+	if err := w.throttleWrite(); err != nil {
+		return 0, err
+	}
+
+	sn := atomic.LoadUint64(&w.currSn)
+	itmBuf := w.GetBuffer(bufUpsert)
+	itm, err := w.ItemCodec.NewItem(k, v, sn, meta, false, itmBuf)
+	if err != nil {
+		return 0, err
+	}
 
+	w.sts.KeySizes.add(len(k))
+	w.sts.ValueSizes.add(len(v))
+
+	if !w.ExactItemsCount {
+		if err = w.insert(itm); err == nil {
+			w.count.add(1)
 		}
+		return sn, err
+	}
 
-		s.itemsCount += w.count
-		w.count = 0
+	prev, err := w.upsert(itm)
+	if err == nil && (prev == nil || !w.ItemCodec.IsInsert(prev)) {
+		w.count.add(1)
 	}
 
-	snap.count = s.itemsCount
-	s.FreeObjects(smrList)
+	return sn, err
+}
 
-	return
+// Insert a key value pair and return the previously visible value, if any
+func (w *Writer) UpsertKV(k, v []byte) ([]byte, uint64, error) {
+	if err := w.throttleWrite(); err != nil {
+		return nil, 0, err
+	}
+
+	sn := atomic.LoadUint64(&w.currSn)
+	itmBuf := w.GetBuffer(bufUpsert)
+	itm, err := w.ItemCodec.NewItem(k, v, sn, nil, false, itmBuf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	w.sts.KeySizes.add(len(k))
+	w.sts.ValueSizes.add(len(v))
+
+	prev, err := w.upsert(itm)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var old []byte
+	if prev == nil || !w.ItemCodec.IsInsert(prev) {
+		w.count.add(1)
+	} else if w.ItemCodec.HasValue(prev) {
+		old = append([]byte(nil), w.ItemCodec.Value(prev)...)
+	}
+
+	return old, sn, nil
 }
 
-func (w *Writer) InsertKV(k, v []byte) error {
+// Insert or replace the value of a key. The previous version is deleted,
+// so that it is not returned by the iterators along with the new one. The
+// delete and the insert are applied at once, hence readers see either the
+// previous or the new value.
+func (w *Writer) ReplaceKV(k, v []byte) (uint64, error) {
+	if err := w.throttleWrite(); err != nil {
+		return 0, err
+	}
+
 	sn := atomic.LoadUint64(&w.currSn)
-	itmBuf := w.GetBuffer(bufTempItem)
-	itm, err := newItem(k, v, sn, false, itmBuf)
+	delItm, err := w.ItemCodec.NewItem(k, nil, sn, nil, true, w.GetBuffer(bufReplace))
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	itm, err := w.ItemCodec.NewItem(k, v, sn, nil, false, w.GetBuffer(bufUpsert))
+	if err != nil {
+		return 0, err
 	}
 
-	w.count++
-	return w.Insert(unsafe.Pointer(itm))
+	w.sts.KeySizes.add(len(k))
+	w.sts.ValueSizes.add(len(v))
+
+	prev, err := w.replace(delItm, itm)
+	if err == nil && (prev == nil || !w.ItemCodec.IsInsert(prev)) {
+		w.count.add(1)
+	}
+
+	return sn, err
 }
 
-func (w *Writer) DeleteKV(k []byte) error {
+func (w *Writer) DeleteKV(k []byte) (uint64, error) {
 	sn := atomic.LoadUint64(&w.currSn)
-	itmBuf := w.GetBuffer(bufTempItem)
-	itm, err := newItem(k, nil, sn, true, itmBuf)
+	itmBuf := w.GetBuffer(bufUpsert)
+	itm, err := w.ItemCodec.NewItem(k, nil, sn, nil, true, itmBuf)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	w.count--
-	return w.Insert(unsafe.Pointer(itm))
+	w.sts.KeySizes.add(len(k))
+
+	if !w.ExactItemsCount {
+		if err = w.insert(itm); err == nil {
+			w.count.add(-1)
+		}
+		return sn, err
+	}
+
+	prev, err := w.upsert(itm)
+	if err == nil && prev != nil && w.ItemCodec.IsInsert(prev) {
+		w.count.add(-1)
+	}
+
+	return sn, err
 }
 
 func (w *Writer) LookupKV(k []byte) ([]byte, error) {
+	v, _, err := w.LookupKVMeta(k)
+	return v, err
+}
+
+// Lookup the value along with the metadata of the item
+func (w *Writer) LookupKVMeta(k []byte) ([]byte, uint64, error) {
 	itmBuf := w.GetBuffer(bufTempItem)
-	itm, err := newItem(k, nil, 0, false, itmBuf)
+	itm, err := w.ItemCodec.NewItem(k, nil, 0, nil, false, itmBuf)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	itm, err = w.Lookup(itm)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	codec := w.ItemCodec
+	if itm == nil || !codec.IsInsert(itm) {
+		return nil, 0, ErrItemNotFound
+	}
+
+	if err := w.verifyItem(itm, w.wCtx); err != nil {
+		return nil, 0, err
+	}
+
+	if codec.HasValue(itm) {
+		return codec.Value(itm), codec.Meta(itm), nil
+	}
+
+	return nil, codec.Meta(itm), ErrItemNoValue
+}
+
+// A version of a key which has not yet been garbage collected
+type ItemVersion struct {
+	Sn       uint64
+	IsDelete bool
+	Value    []byte
+}
+
+// Visit the retained versions of a key, newest first, until callb
+// returns false. Versions removed by a rollback are not visited.
+func (s *Plasma) visitVersions(k []byte, w *Writer, callb func(itm unsafe.Pointer) bool) error {
+	codec := s.ItemCodec
+	itm, err := codec.NewItem(k, nil, 0, nil, false, w.GetBuffer(bufUpsert))
+	if err != nil {
+		return err
 	}
 
-	o, err := w.Lookup(unsafe.Pointer(itm))
-	itm = (*item)(o)
+	tok := w.BeginTx()
+	defer w.EndTx(tok)
 
+	_, pg, err := s.fetchPage(itm, w.wCtx)
 	if err != nil {
+		return err
+	}
+
+	head := pg.(*page).head
+	if head == nil {
+		return nil
+	}
+
+	var sts pgOpIteratorStats
+	filter := &rollbackFilter{codec: codec}
+	it := newPgOpIterator(head, s.cmp, itm, head.hiItm, filter, w.wCtx, &sts)
+	defer it.Close()
+
+	for it.Init(); it.Valid(); it.Next() {
+		vitm := it.Get().Item()
+		if !bytes.Equal(codec.Key(vitm), k) || !callb(vitm) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// All the retained versions of a key, newest first
+func (s *Plasma) VersionsOf(k []byte) ([]ItemVersion, error) {
+	var versions []ItemVersion
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	codec := s.ItemCodec
+	var verr error
+	err := s.visitVersions(k, w, func(itm unsafe.Pointer) bool {
+		if verr = s.verifyItem(itm, w.wCtx); verr != nil {
+			return false
+		}
+
+		v := ItemVersion{Sn: codec.Sn(itm), IsDelete: !codec.IsInsert(itm)}
+		if codec.HasValue(itm) {
+			v.Value = append([]byte(nil), codec.Value(itm)...)
+		}
+		versions = append(versions, v)
+		return true
+	})
+
+	if err == nil {
+		err = verr
+	}
+
+	return versions, err
+}
+
+// Lookup the value of a key as of the sequence number sn, which should not
+// be older than OldestActiveSnapshot()
+func (s *Plasma) GetAt(k []byte, sn uint64) ([]byte, error) {
+	if err := s.pinSn(sn); err != nil {
 		return nil, err
 	}
+	defer s.unpinSn(sn)
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	var val []byte
+	var found, hasVal bool
+	var verr error
+	codec := s.ItemCodec
+	err := s.visitVersions(k, w, func(itm unsafe.Pointer) bool {
+		if codec.Sn(itm) > sn {
+			return true
+		}
+
+		if verr = s.verifyItem(itm, w.wCtx); verr != nil {
+			return false
+		}
+
+		if found = codec.IsInsert(itm); found {
+			if hasVal = codec.HasValue(itm); hasVal {
+				val = append([]byte(nil), codec.Value(itm)...)
+			}
+		}
+		return false
+	})
 
-	if itm == nil || !itm.IsInsert() {
+	if err != nil {
+		return nil, err
+	} else if verr != nil {
+		return nil, verr
+	} else if !found {
 		return nil, ErrItemNotFound
+	} else if !hasVal {
+		return nil, ErrItemNoValue
+	}
+
+	return val, nil
+}
+
+// Iterator over the items visible at the sequence number sn, which should
+// not be older than OldestActiveSnapshot(). Garbage collection of the
+// versions visible at sn is held back until the iterator is closed.
+func (s *Plasma) NewIteratorAt(sn uint64) (*MVCCIterator, error) {
+	if err := s.pinSn(sn); err != nil {
+		return nil, err
 	}
 
-	if itm.HasValue() {
-		return itm.Value(), nil
+	itr := s.NewIterator().(*Iterator)
+	itr.filter = &snFilter{
+		sn:             sn,
+		rollbackFilter: rollbackFilter{codec: s.ItemCodec},
 	}
 
-	return nil, ErrItemNoValue
+	tok := itr.BeginTx()
+	return &MVCCIterator{
+		token:    tok,
+		pinSn:    sn,
+		Iterator: itr,
+	}, nil
 }
 
 type RecoveryPoint struct {
 	sn    uint64
 	count int64
 	meta  []byte
+	tags  map[string]string
+
+	// Tail of the log when the recovery point was created. Recovery points
+	// recovered from the log use the offset at which they were found.
+	logOffset LSSOffset
 }
 
 func (rp *RecoveryPoint) Meta() []byte {
 	return rp.meta
 }
 
+func (rp *RecoveryPoint) SeqNum() uint64 {
+	return rp.sn
+}
+
+func (rp *RecoveryPoint) LogOffset() LSSOffset {
+	return rp.logOffset
+}
+
+// Recovery points read from the log at off keep the offsets of the
+// recovery points read earlier
+func setRPsLogOffset(prev, rps []*RecoveryPoint, off LSSOffset) []*RecoveryPoint {
+	offsets := make(map[uint64]LSSOffset, len(prev))
+	for _, rp := range prev {
+		offsets[rp.sn] = rp.logOffset
+	}
+
+	for _, rp := range rps {
+		rp.logOffset = off
+		if o, ok := offsets[rp.sn]; ok {
+			rp.logOffset = o
+		}
+	}
+
+	return rps
+}
+
+func (rp *RecoveryPoint) Tag(k string) (string, bool) {
+	v, ok := rp.tags[k]
+	return v, ok
+}
+
+func (rp *RecoveryPoint) Tags() map[string]string {
+	return copyTags(rp.tags)
+}
+
+// Returns the most recent recovery point matching pred, or nil
+func (s *Plasma) FindRecoveryPoint(pred func(*RecoveryPoint) bool) *RecoveryPoint {
+	rps := s.GetRecoveryPoints()
+	for i := len(rps) - 1; i >= 0; i-- {
+		if pred(rps[i]) {
+			return rps[i]
+		}
+	}
+
+	return nil
+}
+
 func (s *Plasma) updateRecoveryPoints(rps []*RecoveryPoint) {
 	if s.shouldPersist {
 		version := s.rpVersion + 1
-		bs := marshalRPs(rps, version)
+		persistRps := rps
+		if s.preparedRP != nil {
+			persistRps = nil
+			for _, rp := range rps {
+				if rp != s.preparedRP {
+					persistRps = append(persistRps, rp)
+				}
+			}
+		}
+
+		bs := marshalRPs(persistRps, version)
 		_, wbuf, res := s.lss.ReserveSpace(len(bs) + lssBlockTypeSize)
 		writeLSSBlock(wbuf, lssRecoveryPoints, bs)
 		s.lss.FinalizeWrite(res)
@@ -324,27 +919,58 @@ func (s *Plasma) updateRPSns(rps []*RecoveryPoint) {
 	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&s.rpSns)), unsafe.Pointer(&rpSns))
 }
 
+// The recovery point is tagged with the tags of the snapshot. The metadata
+// of the snapshot is used if meta is nil.
 func (s *Plasma) CreateRecoveryPoint(sn *Snapshot, meta []byte) error {
+	return s.CreateRecoveryPointContext(context.Background(), sn, meta)
+}
+
+// If ctx is done before all pages are persisted, the prepared recovery
+// point is discarded and ctx.Err() is returned
+func (s *Plasma) CreateRecoveryPointContext(ctx context.Context, sn *Snapshot, meta []byte) error {
 	if s.shouldPersist {
+		if meta == nil {
+			meta = sn.Meta()
+		}
+
 		// Prepare
 		s.mvcc.Lock()
 		rp := &RecoveryPoint{
 			sn:    sn.sn,
 			count: sn.count,
 			meta:  meta,
+			tags:  sn.Tags(),
+
+			logOffset: s.lss.TailOffset(),
 		}
 
 		rps := append(s.recoveryPoints, rp)
+		s.preparedRP = rp
 		s.updateRecoveryPoints(rps)
 		s.updateRPSns(rps)
 
 		s.mvcc.Unlock()
 
 		sn.Close()
-		s.PersistAll()
+		if err := s.PersistAllContext(ctx); err != nil {
+			s.mvcc.Lock()
+			s.preparedRP = nil
+			var newRpts []*RecoveryPoint
+			for _, x := range s.recoveryPoints {
+				if x != rp {
+					newRpts = append(newRpts, x)
+				}
+			}
+			s.updateRecoveryPoints(newRpts)
+			s.updateRPSns(newRpts)
+			s.mvcc.Unlock()
+
+			return err
+		}
 
 		// Commit
 		s.mvcc.Lock()
+		s.preparedRP = nil
 		s.updateRecoveryPoints(rps)
 		s.mvcc.Unlock()
 
@@ -362,58 +988,102 @@ func (s *Plasma) GetRecoveryPoints() []*RecoveryPoint {
 }
 
 func (s *Plasma) Rollback(rollRP *RecoveryPoint) (*Snapshot, error) {
+	return s.RollbackContext(context.Background(), rollRP)
+}
+
+// A rollback interrupted by ctx leaves a subset of pages rolled back.
+// Rollback should be retried with the same recovery point to complete it.
+// Rollback can be canceled using ctx. The store remains in a pending
+// rollback state until the rollback is retried to the same or an older
+// recovery point. A pending rollback is completed during recovery.
+func (s *Plasma) RollbackContext(ctx context.Context, rollRP *RecoveryPoint) (*Snapshot, error) {
 	s.mvcc.Lock()
 	defer s.mvcc.Unlock()
 
+	pending, err := s.getPendingRollback()
+	if err != nil {
+		return nil, err
+	}
+
+	if pending != nil && rollRP.sn >= pending.start {
+		return nil, ErrRollbackPending
+	}
+
+	p := pendingRollback{start: rollRP.sn + 1, end: s.currSn, count: rollRP.count}
+	return s.doRollback(ctx, p)
+}
+
+// Impact of rolling back to a recovery point, computed by RollbackPreview
+type RollbackImpact struct {
+	// Every page is rewritten to the log by a rollback
+	NumPages  int64
+	DiskBytes int64
+
+	// Pages having item versions newer than the recovery point
+	AffectedPages int64
+	NumItems      int64
+	ItemBytes     int64
+
+	// Evicted pages which have to be read from the log
+	EvictedPages int64
+
+	ItemsCount int64
+}
+
+// Compute the impact of Rollback(rollRP) without modifying the store
+func (s *Plasma) RollbackPreview(ctx context.Context, rollRP *RecoveryPoint) (RollbackImpact, error) {
+	impact := RollbackImpact{ItemsCount: rollRP.count}
 	start := rollRP.sn + 1
-	end := s.currSn
 
-	callb := func(pid PageId, partn RangePartition) error {
-		w := s.persistWriters[partn.Shard]
-		pgBuf := w.GetBuffer(bufPersist)
-	retry:
-		if pg, err := s.ReadPage(pid, w.pgRdrFn, false, w); err == nil {
-			pg.Rollback(start, end)
-			pgBuf, fdSz, staleFdSz, numSegments := pg.Marshal(pgBuf, s.Config.MaxPageLSSSegments)
-			offset, wbuf, res := s.lss.ReserveSpace(len(pgBuf) + lssBlockTypeSize)
-			typ := pgFlushLSSType(pg, numSegments)
-			writeLSSBlock(wbuf, typ, pgBuf)
-			pg.AddFlushRecord(offset, fdSz, numSegments)
-			s.lss.FinalizeWrite(res)
-			w.sts.FlushDataSz += int64(fdSz) - int64(staleFdSz)
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
 
-			// May conflict with cleaner
-			if !s.UpdateMapping(pid, pg, w) {
-				goto retry
-			}
+	codec := s.ItemCodec
+	callb := func(pid PageId, partn RangePartition) error {
+		tok := w.BeginTx()
+		defer w.EndTx(tok)
 
-		} else {
+		pg, err := s.ReadPage(pid, w.pgRdrFn, false, w.wCtx)
+		if err != nil {
 			return err
 		}
 
-		return nil
-	}
+		head := pg.(*page).head
+		impact.NumPages++
+		impact.DiskBytes += int64(pg.GetFlushDataSize())
+		if head == nil {
+			return nil
+		}
 
-	if err := s.PageVisitor(callb, s.NumPersistorThreads); err != nil {
-		return nil, err
-	}
+		if head.state.IsEvicted() {
+			impact.EvictedPages++
+		}
 
-	s.lss.Sync(false)
+		var n int64
+		var sts pgOpIteratorStats
+		filter := &rollbackFilter{codec: codec}
+		it := newPgOpIterator(head, pg.(*page).cmp, nil, head.hiItm, filter, w.wCtx, &sts)
+		defer it.Close()
+
+		for it.Init(); it.Valid(); it.Next() {
+			itm := it.Get().Item()
+			if codec.Sn(itm) >= start {
+				n++
+				impact.ItemBytes += int64(s.itemSizeAct(itm))
+			}
+		}
 
-	s.itemsCount = rollRP.count
-	newSnap := s.newSnapshot()
-	var newRpts []*RecoveryPoint
-	for _, rp := range s.recoveryPoints {
-		if rp.sn <= rollRP.sn {
-			newRpts = append(newRpts, rp)
+		if n > 0 {
+			impact.AffectedPages++
+			impact.NumItems += n
 		}
-	}
 
-	s.updateRecoveryPoints(newRpts)
-	s.gcSn = newSnap.sn
+		return nil
+	}
 
-	s.lss.Sync(true)
-	return newSnap, nil
+	opts := PageVisitorOptions{Ordered: true}
+	err := s.PageVisitorWithOptions(ctx, callb, opts)
+	return impact, err
 }
 
 func (s *Plasma) RemoveRecoveryPoint(rmRP *RecoveryPoint) {
@@ -454,6 +1124,43 @@ func marshalRPs(rps []*RecoveryPoint, version uint16) []byte {
 		offset += len(rp.meta)
 	}
 
+	return marshalRPTags(bs, rps)
+}
+
+// Tags follow the recovery points, where they are ignored by older
+// versions. Each recovery point has a count of tags followed by the
+// length prefixed keys and values.
+func marshalRPTags(bs []byte, rps []*RecoveryPoint) []byte {
+	var tagged bool
+	for _, rp := range rps {
+		tagged = tagged || len(rp.tags) > 0
+	}
+
+	if !tagged {
+		return bs
+	}
+
+	var buf [2]byte
+	appendStr := func(s string) {
+		binary.BigEndian.PutUint16(buf[:], uint16(len(s)))
+		bs = append(append(bs, buf[:]...), s...)
+	}
+
+	for _, rp := range rps {
+		keys := make([]string, 0, len(rp.tags))
+		for k := range rp.tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		binary.BigEndian.PutUint16(buf[:], uint16(len(keys)))
+		bs = append(bs, buf[:]...)
+		for _, k := range keys {
+			appendStr(k)
+			appendStr(rp.tags[k])
+		}
+	}
+
 	return bs
 }
 
@@ -476,12 +1183,51 @@ func unmarshalRPs(bs []byte) (version uint16, rps []*RecoveryPoint) {
 		offset = endOffset
 	}
 
+	unmarshalRPTags(bs[offset:], rps)
 	return
 }
 
+func unmarshalRPTags(bs []byte, rps []*RecoveryPoint) {
+	readStr := func() (string, bool) {
+		if len(bs) < 2 {
+			return "", false
+		}
+
+		l := int(binary.BigEndian.Uint16(bs))
+		if len(bs) < 2+l {
+			return "", false
+		}
+
+		s := string(bs[2 : 2+l])
+		bs = bs[2+l:]
+		return s, true
+	}
+
+	for _, rp := range rps {
+		if len(bs) < 2 {
+			return
+		}
+
+		n := int(binary.BigEndian.Uint16(bs))
+		bs = bs[2:]
+		for i := 0; i < n; i++ {
+			k, ok1 := readStr()
+			v, ok2 := readStr()
+			if !ok1 || !ok2 {
+				return
+			}
+
+			if rp.tags == nil {
+				rp.tags = make(map[string]string)
+			}
+			rp.tags[k] = v
+		}
+	}
+}
+
 func (s *Plasma) updateMaxSn(sn uint64, force bool) {
-	if s.shouldPersist {
-		freq := s.MaxSnSyncFrequency
+	if s.shouldPersist && !s.Ephemeral {
+		freq := s.liveConfig().MaxSnSyncFrequency
 		if s.numSnCreated%freq == 0 || force {
 			var bs [8]byte
 			maxSn := sn + uint64(freq+1)
@@ -489,7 +1235,10 @@ func (s *Plasma) updateMaxSn(sn uint64, force bool) {
 			_, wbuf, res := s.lss.ReserveSpace(len(bs) + lssBlockTypeSize)
 			writeLSSBlock(wbuf, lssMaxSn, bs[:])
 			s.lss.FinalizeWrite(res)
-			s.lss.Sync(true)
+			// Later records are durable only after the max sn record
+			if s.SyncPeriod == 0 || force {
+				s.lss.Sync(true)
+			}
 			s.lastMaxSn = maxSn
 		}
 
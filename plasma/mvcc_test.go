@@ -1,11 +1,14 @@
 package plasma
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/couchbase/nitro/skiplist"
 	"os"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -382,6 +385,9 @@ func TestMVCCRecoveryPoint(t *testing.T) {
 	if err != ErrItemNotFound {
 		t.Errorf("Expected not found")
 	}
+
+	snap.Close()
+	s.Close()
 }
 
 func TestMVCCIntervalGC(t *testing.T) {
@@ -578,6 +584,279 @@ func TestMVCCItemsCount(t *testing.T) {
 	}
 }
 
+func TestMVCCExactItemsCount(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.ExactItemsCount = true
+	s := newTestIntPlasmaStore(cfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+	}
+
+	snap1 := s.NewSnapshot()
+	s.CreateRecoveryPoint(snap1, nil)
+
+	// Overwrites and deletes of missing keys do not change the count
+	for i := 0; i < 500; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val2-%10d", i)))
+	}
+
+	for i := 800; i < 1100; i++ {
+		w.DeleteKV([]byte(fmt.Sprintf("key-%10d", i)))
+	}
+
+	snap2 := s.NewSnapshot()
+	defer snap2.Close()
+
+	if snap1.Count() != 1000 || snap2.Count() != 800 {
+		t.Errorf("unexpected counts %d %d", snap1.Count(), snap2.Count())
+	}
+
+	checkRange := func(snap *Snapshot, lo, hi []byte, exp int64) {
+		if n, err := snap.CountRange(lo, hi); err != nil || n != exp {
+			t.Errorf("expected range count %d, got %d %v", exp, n, err)
+		}
+	}
+
+	lo, hi := []byte(fmt.Sprintf("key-%10d", 100)), []byte(fmt.Sprintf("key-%10d", 900))
+	checkRange(snap1, nil, nil, 1000)
+	checkRange(snap1, lo, hi, 800)
+	checkRange(snap2, nil, nil, 800)
+	checkRange(snap2, lo, hi, 700)
+	checkRange(snap2, hi, lo, 0)
+	snap1.Close()
+
+	rollSn, _ := s.Rollback(s.GetRecoveryPoints()[0])
+	if rollSn.Count() != 1000 {
+		t.Errorf("expected count 1000, got %d", rollSn.Count())
+	}
+	rollSn.Close()
+
+	for i := 0; i < 100; i++ {
+		w.DeleteKV([]byte(fmt.Sprintf("key-%10d", i)))
+	}
+
+	s.NewSnapshot().Close()
+	s.PersistAll()
+	s.Close()
+
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+	if snap.Count() != 900 {
+		t.Errorf("expected count 900 after recovery, got %d", snap.Count())
+	}
+	checkRange(snap, lo, nil, 900)
+}
+
+func TestMVCCVersionsOf(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("v1"))
+	}
+
+	snap1 := s.NewSnapshot()
+	defer snap1.Close()
+
+	k := []byte(fmt.Sprintf("key-%10d", 50))
+	w.InsertKV(k, []byte("v2"))
+	snap2 := s.NewSnapshot()
+	defer snap2.Close()
+
+	// Older versions move to the base page
+	s.CompactRange(skiplist.MinItem, skiplist.MaxItem)
+	w.DeleteKV(k)
+
+	versions, err := s.VersionsOf(k)
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("unexpected versions %v %v", versions, err)
+	}
+
+	if !versions[0].IsDelete || versions[0].Sn != snap2.SeqNum()+1 {
+		t.Errorf("unexpected newest version %+v", versions[0])
+	}
+
+	if string(versions[1].Value) != "v2" || versions[1].Sn != snap2.SeqNum() {
+		t.Errorf("unexpected version %+v", versions[1])
+	}
+
+	if string(versions[2].Value) != "v1" || versions[2].Sn != snap1.SeqNum() {
+		t.Errorf("unexpected oldest version %+v", versions[2])
+	}
+
+	if versions, _ := s.VersionsOf([]byte("missing")); len(versions) != 0 {
+		t.Errorf("expected no versions, got %v", versions)
+	}
+}
+
+func TestMVCCRollbackPreview(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+	}
+
+	snap := s.NewSnapshot()
+	s.CreateRecoveryPoint(snap, nil)
+	snap.Close()
+
+	for i := 1000; i < 1200; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+	}
+	s.NewSnapshot().Close()
+
+	rp := s.GetRecoveryPoints()[0]
+	impact, err := s.RollbackPreview(context.Background(), rp)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if impact.NumItems != 200 || impact.ItemsCount != 1000 || impact.AffectedPages == 0 ||
+		impact.AffectedPages > impact.NumPages || impact.ItemBytes == 0 {
+		t.Errorf("unexpected impact %+v", impact)
+	}
+
+	if _, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", 1100))); err != nil {
+		t.Errorf("preview should not modify the store, got %v", err)
+	}
+
+	rollSn, _ := s.Rollback(rp)
+	rollSn.Close()
+
+	if impact, _ = s.RollbackPreview(context.Background(), rp); impact.NumItems != 0 {
+		t.Errorf("expected no items after rollback, got %+v", impact)
+	}
+}
+
+func TestMVCCRollbackGCSn(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("val-1"))
+	}
+
+	snap := s.NewSnapshot()
+	s.CreateRecoveryPoint(snap, nil)
+
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i+1000)), []byte("val-1"))
+	}
+	s.NewSnapshot().Close()
+
+	rollSn, _ := s.Rollback(s.GetRecoveryPoints()[0])
+	rollSn.Close()
+
+	// Versions visible to a snapshot taken after the rollback are not
+	// garbage collected
+	snap = s.NewSnapshot()
+	defer snap.Close()
+	if s.GCSn() >= snap.sn {
+		t.Errorf("expected gc sn below %d, got %d", snap.sn, s.GCSn())
+	}
+
+	for i := 0; i < 1000; i++ {
+		w.DeleteKV([]byte(fmt.Sprintf("key-%10d", i)))
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("val-2"))
+	}
+
+	if err := s.ForceGC(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	count := 0
+	itr := snap.NewIterator()
+	defer itr.Close()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if string(itr.Value()) != "val-1" {
+			t.Fatalf("expected val-1 for %s, got %s", itr.Key(), itr.Value())
+		}
+		count++
+	}
+
+	if count != 1000 {
+		t.Errorf("expected 1000 items, got %d", count)
+	}
+}
+
+func TestMVCCResumeRollback(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+	}
+
+	snap1 := s.NewSnapshot()
+	s.CreateRecoveryPoint(snap1, []byte("rp1"))
+	snap1.Close()
+
+	for i := 1000; i < 2000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+	}
+
+	snap2 := s.NewSnapshot()
+	s.CreateRecoveryPoint(snap2, []byte("rp2"))
+	snap2.Close()
+
+	rpts := s.GetRecoveryPoints()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.RollbackContext(ctx, rpts[0]); err != context.Canceled {
+		t.Fatalf("expected canceled rollback, got %v", err)
+	}
+
+	if sn, ok := s.PendingRollback(); !ok || sn != rpts[0].sn {
+		t.Errorf("expected pending rollback to %d, got %d %v", rpts[0].sn, sn, ok)
+	}
+
+	if _, err := s.Rollback(rpts[1]); err != ErrRollbackPending {
+		t.Errorf("expected pending rollback error, got %v", err)
+	}
+
+	s.PersistAll()
+	s.Close()
+
+	s = newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	if _, ok := s.PendingRollback(); ok {
+		t.Errorf("expected rollback to be completed during recovery")
+	}
+
+	if rpts := s.GetRecoveryPoints(); len(rpts) != 1 || string(rpts[0].Meta()) != "rp1" {
+		t.Errorf("unexpected recovery points %v", rpts)
+	}
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+	itr := snap.NewIterator()
+	defer itr.Close()
+
+	count := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		count++
+	}
+
+	if count != 1000 || snap.Count() != 1000 {
+		t.Errorf("expected 1000 items, got %d (count %d)", count, snap.Count())
+	}
+}
+
 func TestLargeItems(t *testing.T) {
 	os.RemoveAll("teststore.data")
 	s := newTestIntPlasmaStore(testSnCfg)
@@ -632,11 +911,11 @@ func TestTooLargeKey(t *testing.T) {
 	tooBigKey := make([]byte, 0x7fffffff, 0x7fffffff)
 	w := s.NewWriter()
 
-	err := w.InsertKV(tooBigKey, nil)
+	_, err := w.InsertKV(tooBigKey, nil)
 	if err != ErrKeyTooLarge {
 		t.Errorf("Expected too large key InsertKV to fail")
 	}
-	err = w.DeleteKV(tooBigKey)
+	_, err = w.DeleteKV(tooBigKey)
 	if err != ErrKeyTooLarge {
 		t.Errorf("Expected too large key DeleteKV to fail")
 	}
@@ -644,6 +923,11 @@ func TestTooLargeKey(t *testing.T) {
 	if err != ErrKeyTooLarge {
 		t.Errorf("Expected too large key LookupKV to fail")
 	}
+
+	_, err = w.InsertKV([]byte("key"), tooBigKey[:itmLenMask])
+	if err != ErrValueTooLarge {
+		t.Errorf("Expected too large value InsertKV to fail")
+	}
 }
 
 func TestMVCCItemUpdateSize(t *testing.T) {
@@ -711,3 +995,530 @@ func TestEvictionStats(t *testing.T) {
 	}
 
 }
+
+func TestMVCCSeqNum(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	sn1, err := w.InsertKV([]byte("key1"), []byte("val1"))
+	if err != nil || sn1 != s.CurrentSeqNum() {
+		t.Fatalf("expected sn %d, got %d (%v)", s.CurrentSeqNum(), sn1, err)
+	}
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+	if snap.SeqNum() != sn1 {
+		t.Errorf("expected snapshot sn %d, got %d", sn1, snap.SeqNum())
+	}
+
+	sn2, _ := w.DeleteKV([]byte("key1"))
+	if sn2 <= sn1 || sn2 != s.CurrentSeqNum() {
+		t.Errorf("expected sn greater than %d, got %d", sn1, sn2)
+	}
+}
+
+func TestMVCCUpsertKV(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		k := []byte(fmt.Sprintf("key-%10d", i))
+		old, _, err := w.UpsertKV(k, []byte(fmt.Sprintf("val-%10d", i)))
+		if err != nil || old != nil {
+			t.Fatalf("expected no previous value, got %s (%v)", old, err)
+		}
+	}
+
+	snap := s.NewSnapshot()
+	snap.Close()
+
+	for i := 0; i < 1000; i++ {
+		k := []byte(fmt.Sprintf("key-%10d", i))
+		old, _, err := w.UpsertKV(k, []byte("newval"))
+		if exp := fmt.Sprintf("val-%10d", i); err != nil || string(old) != exp {
+			t.Errorf("expected %s, got %s (%v)", exp, old, err)
+		}
+	}
+
+	if v, _ := w.LookupKV([]byte(fmt.Sprintf("key-%10d", 10))); string(v) != "newval" {
+		t.Errorf("expected newval, got %s", v)
+	}
+
+	snap = s.NewSnapshot()
+	defer snap.Close()
+	if snap.Count() != 1000 {
+		t.Errorf("expected count 1000, got %d", snap.Count())
+	}
+}
+
+func TestMVCCReplaceKV(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.ReplaceKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+	}
+
+	snap := s.NewSnapshot()
+	snap.Close()
+
+	for i := 0; i < 1000; i += 2 {
+		w.ReplaceKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("newval"))
+	}
+
+	snap = s.NewSnapshot()
+	defer snap.Close()
+	if snap.Count() != 1000 {
+		t.Errorf("expected count 1000, got %d", snap.Count())
+	}
+
+	// Only the new value of a replaced key is returned
+	i := 0
+	itr := snap.NewIterator()
+	defer itr.Close()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		exp := fmt.Sprintf("val-%10d", i)
+		if i%2 == 0 {
+			exp = "newval"
+		}
+
+		if k := fmt.Sprintf("key-%10d", i); string(itr.Key()) != k || string(itr.Value()) != exp {
+			t.Fatalf("expected %s:%s, got %s:%s", k, exp, itr.Key(), itr.Value())
+		}
+		i++
+	}
+
+	if i != 1000 {
+		t.Errorf("expected 1000 items, got %d", i)
+	}
+}
+
+func TestMVCCBatchedSnapshot(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.SnapshotBatchWindow = time.Millisecond * 20
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	snaps := make([]*Snapshot, 8)
+	for i := range snaps {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			w := s.NewWriter()
+			w.InsertKV([]byte(fmt.Sprintf("key-%d", id)), nil)
+			snaps[id] = s.NewSnapshot()
+		}(i)
+	}
+	wg.Wait()
+
+	distinct := make(map[*Snapshot]bool)
+	for _, snap := range snaps {
+		distinct[snap] = true
+	}
+
+	if len(distinct) == len(snaps) {
+		t.Errorf("expected snapshots to be shared")
+	}
+
+	for _, snap := range snaps {
+		itr := snap.NewIterator()
+		count := 0
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			count++
+		}
+		itr.Close()
+		snap.Close()
+
+		if int64(count) != snap.Count() {
+			t.Errorf("expected %d items, got %d", snap.Count(), count)
+		}
+	}
+}
+
+func TestMVCCItemMeta(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		k := []byte(fmt.Sprintf("key-%10d", i))
+		v := []byte(fmt.Sprintf("val-%10d", i))
+		if i%2 == 0 {
+			w.InsertKVMeta(k, v, uint64(i))
+		} else {
+			w.InsertKV(k, v)
+		}
+	}
+
+	snap := s.NewSnapshot()
+	s.PersistAll()
+	s.Close()
+
+	s = newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+	snap.Close()
+
+	w = s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		var exp uint64
+		if i%2 == 0 {
+			exp = uint64(i)
+		}
+
+		k := []byte(fmt.Sprintf("key-%10d", i))
+		v, meta, err := w.LookupKVMeta(k)
+		if err != nil || meta != exp || string(v) != fmt.Sprintf("val-%10d", i) {
+			t.Errorf("unexpected lookup result for %s: %s %d %v", k, v, meta, err)
+		}
+	}
+
+	snap = s.NewSnapshot()
+	defer snap.Close()
+	itr := snap.NewIterator()
+	defer itr.Close()
+
+	i := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if exp := uint64(i); i%2 == 0 && itr.Meta() != exp {
+			t.Errorf("expected meta %d, got %d", exp, itr.Meta())
+		}
+		i++
+	}
+
+	if i != 1000 {
+		t.Errorf("expected 1000 items, got %d", i)
+	}
+}
+
+func TestMVCCAggregate(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprint(i)))
+	}
+
+	for i := 0; i < 10000; i += 2 {
+		w.DeleteKV([]byte(fmt.Sprintf("key-%10d", i)))
+	}
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+
+	for i := 1; i < 10000; i += 2 {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("0"))
+	}
+
+	var count, sum, min, max int
+	agg := func(k, v []byte) error {
+		x, err := strconv.Atoi(string(v))
+		if count == 0 || x < min {
+			min = x
+		}
+
+		if x > max {
+			max = x
+		}
+
+		count++
+		sum += x
+		return err
+	}
+
+	lo, hi := []byte(fmt.Sprintf("key-%10d", 100)), []byte(fmt.Sprintf("key-%10d", 200))
+	if err := snap.Aggregate(lo, hi, agg); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if count != 50 || sum != 7500 || min != 101 || max != 199 {
+		t.Errorf("unexpected aggregate count:%d sum:%d min:%d max:%d", count, sum, min, max)
+	}
+
+	errStop := errors.New("stop")
+	count = 0
+	err := snap.Aggregate(nil, nil, func(k, v []byte) error {
+		if count++; count == 10 {
+			return errStop
+		}
+		return nil
+	})
+
+	if err != errStop || count != 10 {
+		t.Errorf("expected the aggregation to stop, got %v after %d items", err, count)
+	}
+}
+
+func TestMVCCIteratorRefresh(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%10d", i))
+	}
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV(key(i), []byte("val-0"))
+	}
+
+	snap := s.NewSnapshot()
+	itr := snap.NewIterator()
+	defer itr.Close()
+	snap.Close()
+
+	itr.Seek(key(500))
+	w.DeleteKV(key(500))
+	w.DeleteKV(key(501))
+	w.DeleteKV(key(600))
+	w.InsertKV(key(600), []byte("val-1"))
+
+	oldest := s.OldestActiveSnapshot()
+	itr.Refresh()
+	if s.OldestActiveSnapshot() <= oldest {
+		t.Errorf("expected the old snapshot to be released")
+	}
+
+	// Deleted keys are skipped from the position of the iterator
+	if !itr.Valid() || string(itr.Key()) != string(key(502)) {
+		t.Fatalf("expected %s after refresh", key(502))
+	}
+
+	count := 0
+	for ; itr.Valid(); itr.Next() {
+		if exp := "val-0"; string(itr.Key()) == string(key(600)) {
+			exp = "val-1"
+			if string(itr.Value()) != exp {
+				t.Errorf("expected %s, got %s", exp, itr.Value())
+			}
+		}
+		count++
+	}
+
+	if count != 498 {
+		t.Errorf("expected 498 items, got %d", count)
+	}
+
+	// Iterators at a sequence number are unpinned
+	pitr, err := s.NewIteratorAt(s.CurrentSeqNum())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer pitr.Close()
+
+	pitr.Refresh()
+	if s.snPins.n != 0 {
+		t.Errorf("expected the sequence number to be unpinned")
+	}
+
+	for pitr.SeekFirst(); pitr.Valid(); pitr.Next() {
+		count++
+	}
+
+	if count != 498+998 {
+		t.Errorf("expected %d items, got %d", 998, count-498)
+	}
+}
+
+func TestMVCCRecoveryPointTags(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 1000; j++ {
+			w.InsertKV([]byte(fmt.Sprintf("key-%d-%10d", i, j)), nil)
+		}
+
+		snap := s.NewSnapshot()
+		snap.SetMeta([]byte(fmt.Sprint("meta-", i)))
+		snap.SetTag("checkpoint", fmt.Sprint("cp-", i))
+		if i == 1 {
+			snap.SetTag("source", "import")
+		}
+		snap.Open()
+		s.CreateRecoveryPoint(snap, nil)
+		snap.Close()
+	}
+
+	snap := s.NewSnapshot()
+	snap.SetMeta([]byte("ignored"))
+	snap.Open()
+	s.CreateRecoveryPoint(snap, []byte("explicit"))
+	snap.Close()
+
+	s.PersistAll()
+	s.Close()
+
+	s = newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	rps := s.GetRecoveryPoints()
+	if len(rps) != 4 {
+		t.Fatalf("expected 4 recovery points, got %d", len(rps))
+	}
+
+	for i := 0; i < 3; i++ {
+		if string(rps[i].Meta()) != fmt.Sprint("meta-", i) {
+			t.Errorf("expected snapshot meta, got %s", rps[i].Meta())
+		}
+	}
+
+	if string(rps[3].Meta()) != "explicit" || len(rps[3].Tags()) != 0 {
+		t.Errorf("expected explicit meta and no tags, got %s %v", rps[3].Meta(), rps[3].Tags())
+	}
+
+	rp := s.FindRecoveryPoint(func(rp *RecoveryPoint) bool {
+		v, _ := rp.Tag("checkpoint")
+		return v == "cp-1"
+	})
+
+	if rp == nil || rp != rps[1] {
+		t.Fatalf("expected the second recovery point, got %v", rp)
+	}
+
+	if v, ok := rp.Tag("source"); !ok || v != "import" || len(rp.Tags()) != 2 {
+		t.Errorf("expected source tag, got %v", rp.Tags())
+	}
+
+	rp = s.FindRecoveryPoint(func(rp *RecoveryPoint) bool {
+		_, ok := rp.Tag("checkpoint")
+		return ok
+	})
+
+	if rp != rps[2] {
+		t.Errorf("expected the most recent tagged recovery point")
+	}
+
+	if s.FindRecoveryPoint(func(*RecoveryPoint) bool { return false }) != nil {
+		t.Errorf("expected no recovery point")
+	}
+}
+
+func TestMVCCIteratorSeekModes(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%10d", i))
+	}
+
+	w := s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.InsertKV(key(i), []byte("val"))
+	}
+
+	// Pages without visible keys are skipped by SeekLT
+	for i := 3000; i < 6000; i++ {
+		w.DeleteKV(key(i))
+	}
+
+	snap := s.NewSnapshot()
+	itr := snap.NewIterator()
+	defer itr.Close()
+	snap.Close()
+
+	seekLT := []struct {
+		k   []byte
+		exp []byte
+	}{
+		{key(100), key(99)},
+		{key(5500), key(2999)},
+		{key(6001), key(6000)},
+		{[]byte("zzz"), key(9999)},
+		{key(0), nil},
+		{[]byte("a"), nil},
+	}
+
+	for _, tc := range seekLT {
+		itr.SeekLT(tc.k)
+		if tc.exp == nil {
+			if itr.Valid() {
+				t.Errorf("expected no key < %s, got %s", tc.k, itr.Key())
+			}
+		} else if !itr.Valid() || string(itr.Key()) != string(tc.exp) {
+			t.Errorf("expected %s < %s", tc.exp, tc.k)
+		}
+	}
+
+	itr.SeekLT(key(1000))
+	if itr.Next(); !itr.Valid() || string(itr.Key()) != string(key(1000)) {
+		t.Errorf("expected %s after SeekLT and Next", key(1000))
+	}
+
+	if !itr.SeekExact(key(10)) || string(itr.Value()) != "val" {
+		t.Errorf("expected %s to be found", key(10))
+	}
+
+	if itr.SeekExact(key(4000)) || !itr.Valid() || string(itr.Key()) != string(key(6000)) {
+		t.Errorf("expected %s not to be found", key(4000))
+	}
+
+	if itr.SeekGE(key(4000)); !itr.Valid() || string(itr.Key()) != string(key(6000)) {
+		t.Errorf("expected %s >= %s", key(6000), key(4000))
+	}
+}
+
+func TestMVCCCompactDeleteOfMissingKey(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	// A delete of a key which does not exist is followed by the next key
+	// inserted at the same sn. Compaction should retain the insert.
+	w := s.NewWriter()
+	for i := 0; i < 100; i += 2 {
+		w.DeleteKV([]byte(fmt.Sprintf("key-%10d", i)))
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i+1)), []byte("val"))
+	}
+	w.CompactAll()
+
+	for i := 1; i < 100; i += 2 {
+		if v, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i))); err != nil || string(v) != "val" {
+			t.Fatalf("Expected key-%d to be found, got %s, %v", i, v, err)
+		}
+	}
+}
+
+func TestMVCCIterateDeleteOfMissingKey(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	// Replacing a key which does not exist by a delete followed by an
+	// insert should not hide the next key from the snapshot iterators
+	w := s.NewWriter()
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("key-%10d", i))
+		w.DeleteKV(k)
+		w.InsertKV(k, []byte("val"))
+	}
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+
+	i := 0
+	itr := snap.NewIterator()
+	defer itr.Close()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if exp := fmt.Sprintf("key-%10d", i); string(itr.Key()) != exp {
+			t.Fatalf("Expected %s, got %s", exp, itr.Key())
+		}
+		i++
+	}
+
+	if i != 100 {
+		t.Errorf("Expected 100 items, got %d", i)
+	}
+}
@@ -0,0 +1,37 @@
+package plasma
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// Directories of the stores open in this process. The file lock does not
+// reliably exclude a second open from within the same process, which
+// would corrupt the log.
+var openDirs = struct {
+	sync.Mutex
+	dirs map[string]bool
+}{dirs: make(map[string]bool)}
+
+func registerOpenDir(dir string) (string, error) {
+	path, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	openDirs.Lock()
+	defer openDirs.Unlock()
+
+	if openDirs.dirs[path] {
+		return "", ErrAlreadyOpen
+	}
+
+	openDirs.dirs[path] = true
+	return path, nil
+}
+
+func unregisterOpenDir(path string) {
+	openDirs.Lock()
+	defer openDirs.Unlock()
+	delete(openDirs.dirs, path)
+}
@@ -2,6 +2,7 @@ package plasma
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/couchbase/nitro/skiplist"
 	"sort"
@@ -29,6 +30,9 @@ const (
 
 	opSwapoutDelta
 	opSwapinDelta
+
+	// Deltas above the base page are in the lss
+	opDeltaSwapoutDelta
 )
 
 const (
@@ -42,6 +46,8 @@ const (
 	itemKeyEncoded
 )
 
+var ErrCorruptPageData = errors.New("page data is corrupt")
+
 var pageHeaderSize = int(unsafe.Sizeof(*new(pageDelta)))
 
 type PageId interface{}
@@ -82,8 +88,11 @@ type Page interface {
 	MinItem() unsafe.Pointer
 	SetNext(PageId)
 	Next() PageId
+	SetPrev(PageId)
+	Prev() PageId
 
 	Evict(offset LSSOffset, numSegments int)
+	EvictDeltas() int
 	SwapIn(ptr *pageDelta)
 
 	GetAllocOps() (a []*pageDelta, f []pgFreeObj, nra int, nrs int, sz int)
@@ -193,6 +202,9 @@ type pageDelta struct {
 
 	hiItm        unsafe.Pointer
 	rightSibling PageId
+
+	// Hint of the page to the left. See Plasma.prevPage.
+	leftSibling PageId
 }
 
 func (pd *pageDelta) IsInsert() bool {
@@ -221,6 +233,7 @@ type basePage struct {
 
 	hiItm        unsafe.Pointer
 	rightSibling PageId
+	leftSibling  PageId
 	items        []unsafe.Pointer
 }
 
@@ -282,6 +295,10 @@ type ItemSizeFn func(unsafe.Pointer) uintptr
 type ItemCopyFn func(a, b unsafe.Pointer, l int)
 type ItemRunSizeFn func(src []unsafe.Pointer) uintptr
 type ItemRunCopyFn func(src, dst []unsafe.Pointer, dstData unsafe.Pointer)
+
+// Size of the encoded item at the start of data. Returns false if data
+// does not hold a valid item.
+type ItemDataSizeFn func(data []byte) (int, bool)
 type FilterGetter func() ItemFilter
 
 type page struct {
@@ -290,6 +307,7 @@ type page struct {
 	*allocCtx
 
 	nextPid     PageId
+	prevPid     PageId
 	low         unsafe.Pointer
 	state       pageState
 	prevHeadPtr unsafe.Pointer
@@ -305,12 +323,21 @@ func (pg *page) SetNext(pid PageId) {
 	}
 }
 
+func (pg *page) SetPrev(pid PageId) {
+	if pg.head == nil {
+		pg.prevPid = pid
+	} else {
+		pg.head.leftSibling = pid
+	}
+}
+
 func (pg *page) InCache() bool {
 	return uintptr(unsafe.Pointer(pg.prevHeadPtr))&uintptr(evictMask) == 0
 }
 
 func (pg *page) Reset() {
 	pg.nextPid = nil
+	pg.prevPid = nil
 	pg.low = nil
 	pg.head = nil
 	pg.tail = nil
@@ -406,6 +433,9 @@ func (pg *page) newBasePage(itms []unsafe.Pointer) *pageDelta {
 
 	if pg.head != nil {
 		bp.rightSibling = pg.head.rightSibling
+		bp.leftSibling = pg.head.leftSibling
+	} else {
+		bp.leftSibling = nil
 	}
 
 	return (*pageDelta)(unsafe.Pointer(bp))
@@ -501,6 +531,7 @@ loop:
 		case opSwapinDelta:
 		case opMetaDelta:
 		case opSwapoutDelta:
+		case opDeltaSwapoutDelta:
 		default:
 			panic(fmt.Sprint("should not happen op:", op))
 		}
@@ -540,23 +571,20 @@ func (pg *page) Split(pid PageId) Page {
 		}
 	}
 
-	var mid int
-	if len(items) > 0 {
-		mid = len(items) / 2
-		for mid > 0 {
-			// Make sure that split is performed by different key boundary
-			if pg.cmp(items[mid], pg.head.hiItm) < 0 {
-				if mid-1 >= 0 && pg.cmp(items[mid], items[mid-1]) > 0 {
-					break
-				}
+	mid := pg.splitIndex(items)
+	for mid > 0 {
+		// Make sure that split is performed by different key boundary
+		if pg.cmp(items[mid], pg.head.hiItm) < 0 {
+			if mid-1 >= 0 && pg.cmp(items[mid], items[mid-1]) > 0 {
+				break
 			}
-			mid--
 		}
+		mid--
 	}
 
 	if mid > 0 {
 		numItems := len(items[:mid])
-		if pgi := pg.doSplit(items[mid], pid, numItems); pgi != nil {
+		if pgi := pg.doSplit(items[mid-1], items[mid], pid, numItems); pgi != nil {
 			return pgi
 		}
 	}
@@ -564,7 +592,29 @@ func (pg *page) Split(pid PageId) Page {
 	return nil
 }
 
-func (pg *page) doSplit(itm unsafe.Pointer, pid PageId, numItems int) *page {
+// Shortest key separating the pages split at itm, where prev is the item
+// preceding itm in the base page
+func (pg *page) splitKey(prev, itm unsafe.Pointer) unsafe.Pointer {
+	if pg.indexKeySeparator == nil {
+		return itm
+	}
+
+	sep := pg.indexKeySeparator(prev, itm)
+	if pg.cmp(sep, prev) <= 0 || pg.cmp(sep, itm) > 0 {
+		return itm
+	}
+
+	// Deltas may have items between prev and itm
+	it, itms, _, _ := pg.collectItems(pg.head, sep, itm)
+	it.Close()
+	if len(itms) > 0 {
+		return itm
+	}
+
+	return sep
+}
+
+func (pg *page) doSplit(prev, itm unsafe.Pointer, pid PageId, numItems int) *page {
 	splitPage := new(page)
 	*splitPage = *pg
 	splitPage.prevHeadPtr = nil
@@ -576,7 +626,7 @@ func (pg *page) doSplit(itm unsafe.Pointer, pid PageId, numItems int) *page {
 	bp := pg.newBasePage(itms)
 	splitPage.head = bp
 
-	itm = (*basePage)(unsafe.Pointer(bp)).items[0]
+	itm = pg.splitKey(prev, (*basePage)(unsafe.Pointer(bp)).items[0])
 	splitPage.low = itm
 	pg.head = pg.newSplitPageDelta(itm, pid)
 
@@ -711,35 +761,60 @@ func (pg *page) NewIterator() ItemIterator {
 	}
 }
 
+// Encode the page into buf, which is grown to fit the page. Returns the
+// encoded bytes and their size.
 func (pg *page) Marshal(buf *Buffer, maxSegments int) (bs []byte, dataSz, staleFdSz int, numSegments int) {
 	hiItm := pg.MaxItem()
 	offset, staleFdSz, numSegments := pg.marshal(buf, 0, pg.head, hiItm, false, maxSegments)
 	return buf.Get(0, offset), offset, staleFdSz, numSegments
 }
 
-func (pg *page) unmarshalIndexKey(data []byte, roffset int) (unsafe.Pointer, int) {
+func (pg *page) unmarshalIndexKey(data []byte, roffset int) (unsafe.Pointer, int, error) {
+	if roffset >= len(data) {
+		return nil, 0, ErrCorruptPageData
+	}
+
 	flag := data[roffset]
 	roffset += 1
 	switch flag {
 	case itemKeyEncoded:
-		itm := unsafe.Pointer(&data[roffset])
-		roffset += int(pg.itemSize(itm))
-		return itm, roffset
+		return pg.unmarshalItem(data, roffset)
 	case minKeyEncoded:
-		return skiplist.MinItem, roffset
+		return skiplist.MinItem, roffset, nil
 	case maxKeyEncoded:
-		return skiplist.MaxItem, roffset
+		return skiplist.MaxItem, roffset, nil
+	}
+
+	return nil, 0, ErrCorruptPageData
+}
+
+func (pg *page) unmarshalItem(data []byte, roffset int) (unsafe.Pointer, int, error) {
+	if roffset >= len(data) {
+		return nil, 0, ErrCorruptPageData
+	}
+
+	l, ok := pg.itemDataSize(data[roffset:])
+	if !ok {
+		return nil, 0, ErrCorruptPageData
 	}
 
-	panic(fmt.Sprintf("invalid flag %d", flag))
+	return unsafe.Pointer(&data[roffset]), roffset + l, nil
 }
 
-func (pg *page) unmarshalItem(data []byte, roffset int) (unsafe.Pointer, int) {
-	itm := unsafe.Pointer(&data[roffset])
-	l := pg.itemSize(itm)
-	roffset += int(l)
-	return itm, roffset
+func readUint16(data []byte, roffset int) (uint16, int, error) {
+	if roffset+2 > len(data) {
+		return 0, 0, ErrCorruptPageData
+	}
 
+	return binary.BigEndian.Uint16(data[roffset : roffset+2]), roffset + 2, nil
+}
+
+func readUint64(data []byte, roffset int) (uint64, int, error) {
+	if roffset+8 > len(data) {
+		return 0, 0, ErrCorruptPageData
+	}
+
+	return binary.BigEndian.Uint64(data[roffset : roffset+8]), roffset + 8, nil
 }
 
 func (pg *page) marshalIndexKey(key unsafe.Pointer, woffset int, buf *Buffer) int {
@@ -861,7 +936,7 @@ loop:
 				binary.BigEndian.PutUint16(buf.Get(bufNitmOffset, 2), uint16(nItms))
 			}
 			break loop
-		case opFlushPageDelta, opRelocPageDelta, opSwapoutDelta:
+		case opFlushPageDelta, opRelocPageDelta, opSwapoutDelta, opDeltaSwapoutDelta:
 			offset, dataSz, numSegs := pw.FlushInfo()
 			if int(numSegs) > maxSegments {
 				isFullMarshal = true
@@ -926,28 +1001,47 @@ func getLSSPageMeta(data []byte) (itm unsafe.Pointer, pv uint16) {
 	return
 }
 
-func (pg *page) Unmarshal(data []byte, ctx *wCtx) {
-	pg.unmarshalDelta(data, ctx)
+// Decode a page written by Marshal. Lengths and op codes are validated
+// and ErrCorruptPageData is returned if the data is malformed.
+func (pg *page) Unmarshal(data []byte, ctx *wCtx) error {
+	_, _, err := pg.unmarshalDelta(data, ctx)
+	return err
+}
+
+func (pg *page) unmarshalDelta(data []byte, ctx *wCtx) (offset LSSOffset, hasChain bool, err error) {
+	return pg.unmarshalDelta2(data, ctx, false)
 }
 
-func (pg *page) unmarshalDelta(data []byte, ctx *wCtx) (offset LSSOffset, hasChain bool) {
+// The base page is skipped if deltasOnly is set
+func (pg *page) unmarshalDelta2(data []byte, ctx *wCtx, deltasOnly bool) (offset LSSOffset, hasChain bool, err error) {
+	var v16 uint16
+	var v64 uint64
 	roffset := 0
-	state := pageState(binary.BigEndian.Uint16(data[roffset : roffset+2]))
-	state.SetFlushed()
 
-	roffset += 2
+	if v16, roffset, err = readUint16(data, roffset); err != nil {
+		return
+	}
+	state := pageState(v16)
+	state.SetFlushed()
 	pg.state = state
 
-	pg.low, roffset = pg.unmarshalIndexKey(data, roffset)
+	if pg.low, roffset, err = pg.unmarshalIndexKey(data, roffset); err != nil {
+		return
+	}
 
-	chainLen := binary.BigEndian.Uint16(data[roffset : roffset+2])
-	roffset += 2
+	var chainLen, numItems uint16
+	if chainLen, roffset, err = readUint16(data, roffset); err != nil {
+		return
+	}
 
-	numItems := binary.BigEndian.Uint16(data[roffset : roffset+2])
-	roffset += 2
+	if numItems, roffset, err = readUint16(data, roffset); err != nil {
+		return
+	}
 
 	var itm, hiItm unsafe.Pointer
-	hiItm, roffset = pg.unmarshalIndexKey(data, roffset)
+	if hiItm, roffset, err = pg.unmarshalIndexKey(data, roffset); err != nil {
+		return
+	}
 
 	lastPd := (*pageDelta)(unsafe.Pointer(pg.allocMetaDelta(hiItm)))
 	lastPd.op = opMetaDelta
@@ -956,17 +1050,22 @@ func (pg *page) unmarshalDelta(data []byte, ctx *wCtx) (offset LSSOffset, hasCha
 	lastPd.chainLen = chainLen
 	lastPd.next = nil
 	lastPd.rightSibling = nil
+	lastPd.leftSibling = nil
 	pg.head = lastPd
 
 	var pd *pageDelta
 loop:
 	for roffset < len(data) {
-		op := pageOp(binary.BigEndian.Uint16(data[roffset : roffset+2]))
-		roffset += 2
+		if v16, roffset, err = readUint16(data, roffset); err != nil {
+			return
+		}
 
+		op := pageOp(v16)
 		switch op {
 		case opInsertDelta, opDeleteDelta:
-			itm, roffset = pg.unmarshalItem(data, roffset)
+			if itm, roffset, err = pg.unmarshalItem(data, roffset); err != nil {
+				return
+			}
 			rpd := pg.allocRecordDelta(itm)
 			*(*pageDelta)(unsafe.Pointer(rpd)) = *pg.head
 			rpd.next = nil
@@ -981,36 +1080,53 @@ loop:
 			spd.itm = pg.head.hiItm
 			pd = (*pageDelta)(unsafe.Pointer(spd))
 		case opBasePage:
-			nItms := int(binary.BigEndian.Uint16(data[roffset : roffset+2]))
-			roffset += 2
-			size := 0
+			if deltasOnly {
+				break loop
+			}
+
+			if v16, roffset, err = readUint16(data, roffset); err != nil {
+				return
+			}
+
+			nItms := int(v16)
 			var itms []unsafe.Pointer
 			for i := 0; i < nItms; i++ {
-				itm, roffset = pg.unmarshalItem(data, roffset)
+				if itm, roffset, err = pg.unmarshalItem(data, roffset); err != nil {
+					return
+				}
 				itms = append(itms, itm)
-				size += int(pg.itemSize(itm))
 			}
 
 			bp := pg.newBasePage(itms)
 			bp.state = state
 			pd = (*pageDelta)(unsafe.Pointer(bp))
 		case opFlushPageDelta, opRelocPageDelta:
-			offset = LSSOffset(binary.BigEndian.Uint64(data[roffset : roffset+8]))
+			if v64, roffset, err = readUint64(data, roffset); err != nil {
+				return
+			}
+			offset = LSSOffset(v64)
 			hasChain = true
 			break loop
 		case opRollbackDelta:
+			var start, end uint64
+			if start, roffset, err = readUint64(data, roffset); err != nil {
+				return
+			}
+
+			if end, roffset, err = readUint64(data, roffset); err != nil {
+				return
+			}
+
 			rpd := pg.allocRollbackPageDelta()
 			*(*pageDelta)(unsafe.Pointer(rpd)) = *pg.head
 			rpd.next = nil
-			rpd.rb = rollbackSn{
-				start: binary.BigEndian.Uint64(data[roffset : roffset+8]),
-				end:   binary.BigEndian.Uint64(data[roffset+8 : roffset+16]),
-			}
-
+			rpd.rb = rollbackSn{start: start, end: end}
 			rpd.op = op
 			pd = (*pageDelta)(unsafe.Pointer(rpd))
-			roffset += 16
 			pd.next = nil
+		default:
+			err = ErrCorruptPageData
+			return
 		}
 
 		lastPd.next = pd
@@ -1052,15 +1168,21 @@ func marshalPageSMO(pg Page, b *Buffer) []byte {
 	return buf[:woffset]
 }
 
-func getRmPageLow(data []byte) unsafe.Pointer {
-	roffset := 0
-	l := int(binary.BigEndian.Uint16(data[roffset : roffset+2]))
+func getRmPageLow(data []byte) (unsafe.Pointer, error) {
+	l, roffset, err := readUint16(data, 0)
+	if err != nil {
+		return nil, err
+	}
+
 	if l == 0 {
-		return nil
+		return nil, nil
 	}
 
-	roffset += 2
-	return unsafe.Pointer(&data[roffset])
+	if int(l) > len(data)-roffset {
+		return nil, ErrCorruptPageData
+	}
+
+	return unsafe.Pointer(&data[roffset]), nil
 }
 
 func (pg *page) GetFlushDataSize() int {
@@ -1141,6 +1263,14 @@ func (pg *page) Next() PageId {
 	return pg.head.rightSibling
 }
 
+func (pg *page) Prev() PageId {
+	if pg.head == nil {
+		return pg.prevPid
+	}
+
+	return pg.head.leftSibling
+}
+
 func newPage(ctx *wCtx, low unsafe.Pointer, ptr unsafe.Pointer) Page {
 	return newPage2(low, ptr, ctx, ctx.storeCtx, ctx.pgAllocCtx)
 }
@@ -1163,6 +1293,7 @@ func (s *Plasma) newSeedPage(ctx *wCtx) Page {
 	d := pg.allocMetaDelta(skiplist.MaxItem)
 	d.op = opMetaDelta
 	d.rightSibling = s.EndPageId()
+	d.leftSibling = nil
 	d.next = nil
 
 	pg.head = (*pageDelta)(unsafe.Pointer(d))
@@ -1180,7 +1311,7 @@ func (pg *page) IsEvictable() bool {
 	}
 
 	switch pg.head.op {
-	case opFlushPageDelta, opRelocPageDelta:
+	case opFlushPageDelta, opRelocPageDelta, opDeltaSwapoutDelta:
 		return true
 	}
 
@@ -1193,7 +1324,7 @@ func (pg *page) NeedsFlush() bool {
 	}
 
 	switch pg.head.op {
-	case opFlushPageDelta, opRelocPageDelta, opPageRemoveDelta, opSwapoutDelta:
+	case opFlushPageDelta, opRelocPageDelta, opPageRemoveDelta, opSwapoutDelta, opDeltaSwapoutDelta:
 		return false
 	}
 
@@ -1204,7 +1335,7 @@ func (pg *page) GetFlushInfo() (LSSOffset, int, int) {
 	if pg.head.op == opFlushPageDelta || pg.head.op == opRelocPageDelta {
 		fpd := (*flushPageDelta)(unsafe.Pointer(pg.head))
 		return fpd.offset, int(fpd.numSegments), int(fpd.flushDataSz)
-	} else if pg.head.op == opSwapoutDelta {
+	} else if pg.head.op == opSwapoutDelta || pg.head.op == opDeltaSwapoutDelta {
 		sod := (*swapoutDelta)(unsafe.Pointer(pg.head))
 		return sod.offset, int(sod.numSegments), 0
 	}
@@ -1232,6 +1363,61 @@ func (pg *page) Evict(offset LSSOffset, numSegments int) {
 	pg.head = (*pageDelta)(unsafe.Pointer(sod))
 }
 
+// Base page below the deltas which can be evicted by EvictDeltas along
+// with the number of records in those deltas. The deltas should have been
+// flushed and the base page should be part of the last full flush.
+func (pg *page) deltaEvictableBase() (*basePage, int) {
+	var n int
+	if !pg.IsEvictable() {
+		return nil, 0
+	}
+
+	for pd := pg.head; pd != nil; pd = pd.next {
+		switch pd.op {
+		case opBasePage:
+			if n == 0 {
+				return nil, 0
+			}
+			return (*basePage)(unsafe.Pointer(pd)), n
+		case opInsertDelta, opDeleteDelta:
+			n++
+		case opFlushPageDelta, opRelocPageDelta, opPageSplitDelta, opRollbackDelta,
+			opMetaDelta, opDeltaSwapoutDelta:
+		default:
+			return nil, 0
+		}
+	}
+
+	return nil, 0
+}
+
+// Replace the deltas above the base page by a reference to their copy in
+// the lss, keeping the base page in memory. The base page is copied so
+// that the replaced delta chain can be freed. Returns the number of
+// records evicted, which is zero if the page has no deltas to evict.
+func (pg *page) EvictDeltas() int {
+	bp, n := pg.deltaEvictableBase()
+	if bp == nil {
+		return 0
+	}
+
+	offset, numSegments, _ := pg.GetFlushInfo()
+	base := pg.newBasePage(bp.items)
+	base.state = bp.state
+
+	pg.free(false)
+	sod := pg.allocSwapoutDelta(pg.head.hiItm)
+	hiItm := sod.hiItm
+	*(*pageDelta)(unsafe.Pointer(sod)) = *pg.head
+	sod.hiItm = hiItm
+	sod.op = opDeltaSwapoutDelta
+	sod.offset = offset
+	sod.numSegments = int32(numSegments)
+	sod.next = base
+	pg.head = (*pageDelta)(unsafe.Pointer(sod))
+	return n
+}
+
 func (pg *page) SwapIn(ptr *pageDelta) {
 	sid := pg.allocSwapinDelta()
 	sid.ptr = ptr
@@ -1308,6 +1494,9 @@ loop:
 			sod := (*swapoutDelta)(unsafe.Pointer(pd))
 			size += int(swapoutDeltaSize + itemSize(sod.hiItm))
 			break loop
+		case opDeltaSwapoutDelta:
+			sod := (*swapoutDelta)(unsafe.Pointer(pd))
+			size += int(swapoutDeltaSize + itemSize(sod.hiItm))
 		case opSwapinDelta:
 			sid := (*swapinDelta)(unsafe.Pointer(pd))
 			nx, mx, sx := computeMemUsed(sid.ptr, itemSize, cmp, hiItm)
@@ -0,0 +1,105 @@
+package plasma
+
+import (
+	"context"
+	"github.com/couchbase/nitro/skiplist"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Lookups, mutations and iterator visits of each page, counted if
+// TrackPageAccess is set
+type pageAccessTracker struct {
+	pages sync.Map
+}
+
+type pageAccess struct {
+	count int64
+	last  int64
+}
+
+// Access statistics of a page reported by PageAccessStats. Pages are
+// reported in key order, so that the low key of the next page is the
+// high key of the page.
+type PageAccessInfo struct {
+	LowKey   unsafe.Pointer
+	NumItems int64
+	Accesses int64
+
+	// Zero if the page is evicted
+	ResidentBytes int64
+
+	// Zero if the page was not accessed since it was created or the
+	// statistics were reset
+	LastAccess time.Time
+}
+
+type PageAccessCallback func(PageAccessInfo) error
+
+func (s *Plasma) recordPageAccess(pid PageId) {
+	if !s.TrackPageAccess {
+		return
+	}
+
+	v, ok := s.pageAccess.pages.Load(pid)
+	if !ok {
+		v, _ = s.pageAccess.pages.LoadOrStore(pid, new(pageAccess))
+	}
+
+	pa := v.(*pageAccess)
+	atomic.AddInt64(&pa.count, 1)
+	atomic.StoreInt64(&pa.last, s.Clock.Now().UnixNano())
+}
+
+func (s *Plasma) forgetPageAccess(pid PageId) {
+	if s.TrackPageAccess {
+		s.pageAccess.pages.Delete(pid)
+	}
+}
+
+// Stream the access statistics of all the pages to callb, for rendering
+// heat maps of the key space. Visiting stops if callb returns an error or
+// ctx is done. The accesses are only counted if TrackPageAccess is set.
+func (s *Plasma) PageAccessStats(ctx context.Context, callb PageAccessCallback) error {
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	visit := func(pid PageId, partn RangePartition) error {
+		pg, err := s.ReadPage(pid, nil, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		info := PageAccessInfo{
+			LowKey:   s.dup(pg.MinItem()),
+			NumItems: pageNumItems(pg),
+		}
+
+		if head := pg.(*page).head; head != nil && !head.state.IsEvicted() {
+			info.ResidentBytes = int64(pg.ComputeMemUsed())
+		}
+
+		if v, ok := s.pageAccess.pages.Load(pid); ok {
+			pa := v.(*pageAccess)
+			info.Accesses = atomic.LoadInt64(&pa.count)
+			if last := atomic.LoadInt64(&pa.last); last > 0 {
+				info.LastAccess = time.Unix(0, last)
+			}
+		}
+
+		return callb(info)
+	}
+
+	opts := PageVisitorOptions{Ordered: true, MinKey: skiplist.MinItem, MaxKey: skiplist.MaxItem}
+	return s.PageVisitorWithOptions(ctx, visit, opts)
+}
+
+// Clear the access statistics of all the pages
+func (s *Plasma) ResetPageAccessStats() {
+	s.pageAccess.pages.Range(func(k, _ interface{}) bool {
+		s.pageAccess.pages.Delete(k)
+		return true
+	})
+}
@@ -0,0 +1,69 @@
+package plasma
+
+import (
+	"context"
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+	"unsafe"
+)
+
+func TestPlasmaPageAccessStats(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testCfg
+	cfg.TrackPageAccess = true
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	w.CompactAll()
+	s.ResetPageAccessStats()
+
+	// Keys below 10000 are hot
+	for n := 0; n < 10; n++ {
+		for i := 0; i < 10000; i++ {
+			w.Lookup(skiplist.NewIntKeyItem(i))
+		}
+	}
+
+	var numItems, hot, cold int64
+	var prev unsafe.Pointer
+	callb := func(info PageAccessInfo) error {
+		if prev != nil && s.cmp(prev, info.LowKey) >= 0 {
+			t.Errorf("pages not reported in key order")
+		}
+		prev = info.LowKey
+
+		if info.ResidentBytes == 0 {
+			t.Errorf("expected resident page %+v", info)
+		}
+
+		numItems += info.NumItems
+		if info.LowKey != skiplist.MinItem && skiplist.IntFromItem(info.LowKey) >= 10000 {
+			cold += info.Accesses
+			return nil
+		}
+
+		hot += info.Accesses
+		if info.Accesses > 0 && info.LastAccess.IsZero() {
+			t.Errorf("expected the last access time to be set %+v", info)
+		}
+
+		return nil
+	}
+
+	if err := s.PageAccessStats(context.Background(), callb); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if numItems != 100000 {
+		t.Errorf("expected 100000 items, got %d", numItems)
+	}
+
+	if hot < 100000 || cold > hot/100 {
+		t.Errorf("unexpected accesses hot=%d cold=%d", hot, cold)
+	}
+}
@@ -1,8 +1,10 @@
 package plasma
 
 import (
+	"fmt"
 	"github.com/couchbase/nitro/skiplist"
 	"math/rand"
+	"os"
 	"testing"
 	"unsafe"
 )
@@ -33,6 +35,10 @@ func newTestPage() (*page, *storePtr) {
 			}
 			return unsafe.Sizeof(new(skiplist.IntKeyItem))
 		},
+		itemDataSize: func(data []byte) (int, bool) {
+			l := int(unsafe.Sizeof(new(skiplist.IntKeyItem)))
+			return l, l <= len(data)
+		},
 		cmp: skiplist.CompareInt,
 		getPageId: func(unsafe.Pointer, *wCtx) PageId {
 			return nil
@@ -372,3 +378,97 @@ func BenchmarkPageIterator(b *testing.B) {
 		itr.Close()
 	}
 }
+
+func newTestUnmarshalStore() *Plasma {
+	os.RemoveAll("teststore.data")
+	cfg := DefaultConfig()
+	cfg.File = "teststore.data"
+	cfg.AutoLSSCleaning = false
+	s := newTestIntPlasmaStore(cfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 200; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%05d", i)), []byte("val"))
+	}
+
+	for i := 100; i < 120; i++ {
+		w.DeleteKV([]byte(fmt.Sprintf("key-%05d", i)))
+	}
+
+	return s
+}
+
+func marshalTestPage(s *Plasma) []byte {
+	w := s.NewWriter()
+	pid := s.getPageId(skiplist.MinItem, w.wCtx)
+	pg, _ := s.ReadPage(pid, w.pgRdrFn, false, w.wCtx)
+	encb, _, _, _ := pg.(*page).Marshal(newBuffer(0), 100)
+	return append([]byte(nil), encb...)
+}
+
+func unmarshalTestPage(s *Plasma, data []byte) error {
+	pg := newPage(s.gCtx, nil, nil).(*page)
+	if err := pg.Unmarshal(data, s.gCtx); err != nil {
+		return err
+	}
+
+	pw := newPgDeltaWalker(pg.head, s.gCtx)
+	defer pw.Close()
+	for ; !pw.End(); pw.Next() {
+		switch pw.Op() {
+		case opInsertDelta, opDeleteDelta:
+			s.ItemCodec.Key(pw.Item())
+		case opBasePage:
+			for _, itm := range pw.BaseItems() {
+				s.ItemCodec.Key(itm)
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestPageUnmarshalCorrupt(t *testing.T) {
+	s := newTestUnmarshalStore()
+	defer s.Close()
+
+	encb := marshalTestPage(s)
+	if err := unmarshalTestPage(s, encb); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for l := 0; l < len(encb); l++ {
+		unmarshalTestPage(s, encb[:l])
+	}
+
+	if err := unmarshalTestPage(s, encb[:len(encb)-3]); err != ErrCorruptPageData {
+		t.Errorf("expected %v for truncated data, got %v", ErrCorruptPageData, err)
+	}
+
+	badOp := append(append([]byte(nil), encb...), 0xff, 0xff)
+	if err := unmarshalTestPage(s, badOp); err != ErrCorruptPageData {
+		t.Errorf("expected %v for invalid op, got %v", ErrCorruptPageData, err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]byte, len(encb))
+	for i := 0; i < 10000; i++ {
+		copy(data, encb)
+		for n := rnd.Intn(4) + 1; n > 0; n-- {
+			data[rnd.Intn(len(data))] = byte(rnd.Intn(256))
+		}
+		unmarshalTestPage(s, data[:rnd.Intn(len(data)+1)])
+	}
+}
+
+func FuzzPageUnmarshal(f *testing.F) {
+	s := newTestUnmarshalStore()
+	defer s.Close()
+
+	encb := marshalTestPage(s)
+	f.Add(encb)
+	f.Add(encb[:len(encb)/2])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		unmarshalTestPage(s, data)
+	})
+}
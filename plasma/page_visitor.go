@@ -1,6 +1,7 @@
 package plasma
 
 import (
+	"context"
 	"github.com/couchbase/nitro/skiplist"
 	"sync"
 	"unsafe"
@@ -14,16 +15,94 @@ type RangePartition struct {
 	MaxKey unsafe.Pointer
 }
 
+type PageVisitorOptions struct {
+	Concurrency int
+
+	// Invoke the callback in key order from the calling goroutine.
+	// Concurrency is ignored.
+	Ordered bool
+
+	// Invoke the callback in reverse key order from the calling goroutine.
+	// Concurrency and Ordered are ignored.
+	Reverse bool
+
+	// Visit only the pages overlapping [MinKey, MaxKey). Nil bounds are
+	// treated as skiplist.MinItem and skiplist.MaxItem.
+	MinKey unsafe.Pointer
+	MaxKey unsafe.Pointer
+}
+
 func (s *Plasma) PageVisitor(callb PageVisitorCallback, concurr int) error {
+	return s.PageVisitorContext(context.Background(), callb, concurr)
+}
+
+func (s *Plasma) PageVisitorWithOptions(ctx context.Context, callb PageVisitorCallback,
+	opts PageVisitorOptions) error {
+	lo, hi := opts.MinKey, opts.MaxKey
+	if lo == nil {
+		lo = skiplist.MinItem
+	}
+
+	if hi == nil {
+		hi = skiplist.MaxItem
+	}
+
+	if opts.Reverse {
+		return s.visitReverse(ctx, lo, hi, callb)
+	}
+
+	concurr := opts.Concurrency
+	if opts.Ordered || concurr < 1 {
+		concurr = 1
+	}
+
+	var partns []RangePartition
+	for _, partn := range s.GetRangePartitions(concurr) {
+		if s.cmp(partn.MaxKey, lo) <= 0 || s.cmp(partn.MinKey, hi) >= 0 {
+			continue
+		}
+
+		if s.cmp(partn.MinKey, lo) < 0 {
+			partn.MinKey = lo
+		}
+
+		if s.cmp(partn.MaxKey, hi) > 0 {
+			partn.MaxKey = hi
+		}
+
+		partn.Shard = len(partns)
+		partns = append(partns, partn)
+	}
+
+	if opts.Ordered {
+		for _, partn := range partns {
+			if err := s.VisitPartitionContext(ctx, partn, callb); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return s.visitPartitions(ctx, partns, callb)
+}
+
+// Visiting stops at the next page once ctx is done. Pages visited so far
+// remain processed and ctx.Err() is returned.
+func (s *Plasma) PageVisitorContext(ctx context.Context, callb PageVisitorCallback, concurr int) error {
+	return s.visitPartitions(ctx, s.GetRangePartitions(concurr), callb)
+}
+
+func (s *Plasma) visitPartitions(ctx context.Context, partitions []RangePartition,
+	callb PageVisitorCallback) error {
 	var wg sync.WaitGroup
-	partitions := s.GetRangePartitions(concurr)
 	errors := make([]error, len(partitions))
 
 	for _, partn := range partitions {
 		wg.Add(1)
 		go func(p RangePartition) {
 			defer wg.Done()
-			errors[p.Shard] = s.VisitPartition(p, callb)
+			errors[p.Shard] = s.VisitPartitionContext(ctx, p, callb)
 		}(partn)
 	}
 
@@ -39,19 +118,40 @@ func (s *Plasma) PageVisitor(callb PageVisitorCallback, concurr int) error {
 }
 
 func (s *Plasma) VisitPartition(partn RangePartition, callb PageVisitorCallback) error {
+	return s.VisitPartitionContext(context.Background(), partn, callb)
+}
+
+func (s *Plasma) VisitPartitionContext(ctx context.Context, partn RangePartition,
+	callb PageVisitorCallback) error {
 	buf := s.Skiplist.MakeBuf()
 	itr := s.Skiplist.NewIterator(s.cmp, buf)
 	itr.SetRefreshInterval(pageVisitorSMRInterval)
 	defer itr.Close()
 
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
 	if partn.MinKey == skiplist.MinItem {
 		pid := s.StartPageId()
 		if err := callb(pid, partn); err != nil {
 			return err
 		}
+	} else {
+		// Partition starts within a page
+		var sts skiplist.Stats
+		if prev, _, found := s.Skiplist.Lookup(partn.MinKey, s.cmp, buf, &sts); !found {
+			if err := callb(PageId(prev), partn); err != nil {
+				return err
+			}
+		}
 	}
 
 	for itr.Seek(partn.MinKey); itr.Valid() && s.cmp(itr.Get(), partn.MaxKey) < 0; itr.Next() {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+
 		pid := PageId(itr.GetNode())
 		if err := callb(pid, partn); err != nil {
 			return err
@@ -1,11 +1,13 @@
 package plasma
 
 import (
+	"context"
 	"fmt"
 	"github.com/couchbase/nitro/skiplist"
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -71,3 +73,62 @@ func TestPlasmaPageVisitor(t *testing.T) {
 
 	fmt.Println("Paritition counts", counts)
 }
+
+func TestPlasmaPageVisitorOptions(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+
+	var minKeys []int
+	lo, hi := 12345, 54321
+	callb := func(pid PageId, partn RangePartition) error {
+		pg, _ := s.ReadPage(pid, nil, false, w.wCtx)
+		minKeys = append(minKeys, skiplist.IntFromItem(pg.MinItem()))
+		if s.cmp(pg.MaxItem(), skiplist.NewIntKeyItem(lo)) <= 0 ||
+			s.cmp(pg.MinItem(), skiplist.NewIntKeyItem(hi)) >= 0 {
+			t.Errorf("page [%d, %d) outside the range", skiplist.IntFromItem(pg.MinItem()),
+				skiplist.IntFromItem(pg.MaxItem()))
+		}
+		return nil
+	}
+
+	opts := PageVisitorOptions{
+		Ordered:     true,
+		Concurrency: 8,
+		MinKey:      skiplist.NewIntKeyItem(lo),
+		MaxKey:      skiplist.NewIntKeyItem(hi),
+	}
+
+	if err := s.PageVisitorWithOptions(context.Background(), callb, opts); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if !sort.IntsAreSorted(minKeys) {
+		t.Errorf("pages not visited in order")
+	}
+
+	if len(minKeys) == 0 || minKeys[0] > lo {
+		t.Errorf("page containing %d not visited", lo)
+	}
+
+	var count int64
+	opts.Ordered = false
+	opts.MinKey, opts.MaxKey = nil, nil
+	countCallb := func(pid PageId, partn RangePartition) error {
+		atomic.AddInt64(&count, 1)
+		return nil
+	}
+
+	if err := s.PageVisitorWithOptions(context.Background(), countCallb, opts); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if count != s.GetStats().NumPages {
+		t.Errorf("expected %d pages, got %d", s.GetStats().NumPages, count)
+	}
+}
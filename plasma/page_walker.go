@@ -15,6 +15,9 @@ type pageWalker struct {
 
 	aCtx    *allocCtx
 	pgCache *pageDelta
+
+	// Resident base page following the deltas read from the lss
+	base *pageDelta
 }
 
 func newPgDeltaWalker(pd *pageDelta, ctx *wCtx) pageWalker {
@@ -60,7 +63,7 @@ func (w *pageWalker) MergeSibling() *pageDelta {
 }
 
 func (w *pageWalker) FlushInfo() (LSSOffset, int32, int32) {
-	if w.currPd.op == opSwapoutDelta {
+	if w.currPd.op == opSwapoutDelta || w.currPd.op == opDeltaSwapoutDelta {
 		sod := (*swapoutDelta)(unsafe.Pointer(w.currPd))
 		return sod.offset, 0, sod.numSegments
 	}
@@ -85,12 +88,13 @@ func (w *pageWalker) Next() {
 		w.pgCache = (*swapinDelta)(unsafe.Pointer(w.currPd)).ptr
 		w.currPd = w.currPd.next
 		w.count++
-	} else if w.currPd.op == opSwapoutDelta {
+	} else if op := w.currPd.op; op == opSwapoutDelta || op == opDeltaSwapoutDelta {
+		deltasOnly := op == opDeltaSwapoutDelta
 		if w.pgCache == nil {
 			var err error
 			sod := (*swapoutDelta)(unsafe.Pointer(w.currPd))
 			w.aCtx = new(allocCtx)
-			fetchPg, err := w.fetchPageFromLSS2(sod.offset, w.wCtx,
+			fetchPg, err := w.fetchPageFromLSS3(sod.offset, deltasOnly, w.wCtx,
 				w.aCtx, w.wCtx.storeCtx)
 			if err != nil {
 				panic(fmt.Sprintf("fatal: %v", err))
@@ -99,12 +103,20 @@ func (w *pageWalker) Next() {
 			w.pgCache = fetchPg.head
 		}
 
+		if deltasOnly {
+			w.base = w.currPd.next
+		}
+
 		w.currPd = w.pgCache
 		w.count++
 	} else {
 		w.currPd = w.currPd.next
 		w.count++
 	}
+
+	if w.currPd == nil && w.base != nil {
+		w.currPd, w.base = w.base, nil
+	}
 }
 
 func (w *pageWalker) End() bool {
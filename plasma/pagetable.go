@@ -15,6 +15,7 @@ type storeCtx struct {
 	useMemMgmt       bool
 	itemSize         ItemSizeFn
 	itemSizeAct      ItemSizeFn
+	itemDataSize     ItemDataSizeFn
 	copyItem         ItemCopyFn
 	copyIndexKey     ItemCopyFn
 	indexKeySize     ItemSizeFn
@@ -24,6 +25,9 @@ type storeCtx struct {
 	getPageId        func(unsafe.Pointer, *wCtx) PageId
 	getCompactFilter FilterGetter
 	getLookupFilter  FilterGetter
+
+	indexKeySeparator func(a, b unsafe.Pointer) unsafe.Pointer
+	splitKeyPolicy    SplitKeyPolicy
 }
 
 func (ctx *storeCtx) alloc(sz uintptr) unsafe.Pointer {
@@ -59,6 +63,7 @@ func newStoreContext(indexLayer *skiplist.Skiplist, cfg Config,
 		cmp:          cfg.Compare,
 		itemSize:     cfg.ItemSize,
 		itemSizeAct:  cfg.ItemSizeActual,
+		itemDataSize: cfg.ItemDataSize,
 		copyItem:     cfg.CopyItem,
 		indexKeySize: cfg.IndexKeySize,
 		copyItemRun:  cfg.CopyItemRun,
@@ -85,6 +90,9 @@ func newStoreContext(indexLayer *skiplist.Skiplist, cfg Config,
 		},
 		getCompactFilter: getCompactFilter,
 		getLookupFilter:  getLookupFilter,
+
+		indexKeySeparator: cfg.IndexKeySeparator,
+		splitKeyPolicy:    cfg.SplitKeyPolicy,
 	}
 }
 
@@ -94,6 +102,7 @@ func (s *Plasma) AllocPageId(*wCtx) PageId {
 }
 
 func (s *Plasma) FreePageId(pid PageId, ctx *wCtx) {
+	s.forgetPageAccess(pid)
 	if s.useMemMgmt {
 		n := pid.(*skiplist.Node)
 		ptr := n.Item()
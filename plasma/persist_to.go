@@ -0,0 +1,95 @@
+package plasma
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var ErrPersistToNotSupported = errors.New("PersistTo requires a store without persistence")
+var ErrPersistToExists = errors.New("target directory already contains a store")
+
+func (s *Plasma) PersistTo(dir string) error {
+	return s.PersistToContext(context.Background(), dir)
+}
+
+// Write the pages of a store opened without persistence to a new log in
+// dir, which can be opened as a persistent store with File set to dir.
+// Pages are written as they are when they are visited, so that writes
+// concurrent with PersistTo may be left out. The log is committed only
+// once all the pages are written.
+func (s *Plasma) PersistToContext(ctx context.Context, dir string) error {
+	if s.shouldPersist {
+		return ErrPersistToNotSupported
+	}
+
+	if sb, err := ReadSuperblock(dir); err == nil && (sb.FormatVersion > 0 || sb.Tail > 0) {
+		return ErrPersistToExists
+	}
+
+	// Commits are deferred to the final sync
+	lss, err := NewLSStore(dir, s.LSSLogSegmentSize, s.FlushBufferSize, 2, false, time.Duration(math.MaxInt64))
+	if err != nil {
+		return err
+	}
+	defer lss.Close()
+
+	s.meta.Lock()
+	kv := make(map[string][]byte)
+	for k, v := range s.meta.kv {
+		kv[k] = v
+	}
+	s.meta.Unlock()
+
+	kv[storeMetaFormatVersion] = []byte(strconv.Itoa(storeFormatVersion))
+	kv[storeMetaCompareId] = []byte(s.Config.CompareId)
+	kv[storeMetaItemCodec] = []byte(s.ItemCodec.Id())
+	kv[storeMetaConfig] = []byte(configFingerprint(s.Config))
+	if err := lss.SetMeta(marshalStoreMeta(kv)); err != nil {
+		return err
+	}
+
+	if s.EnableShapshots {
+		s.NewSnapshot().Close()
+	}
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	buf := w.wCtx.GetBuffer(bufPersist)
+	callb := func(pid PageId, partn RangePartition) error {
+		tok := w.BeginTx()
+		defer w.EndTx(tok)
+
+		pg, err := s.ReadPage(pid, nil, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		bs, _, _, _ := pg.Marshal(buf, FullMarshal)
+		_, wbuf, res := lss.ReserveSpace(lssBlockTypeSize + len(bs))
+		writeLSSBlock(wbuf, lssPageData, bs)
+		lss.FinalizeWrite(res)
+		return nil
+	}
+
+	if err := s.PageVisitorWithOptions(ctx, callb, PageVisitorOptions{Ordered: true}); err != nil {
+		return err
+	}
+
+	// Items written concurrently have sequence numbers up to the current
+	if s.EnableShapshots {
+		var bs [8]byte
+		binary.BigEndian.PutUint64(bs[:], atomic.LoadUint64(&s.currSn)+1)
+		_, wbuf, res := lss.ReserveSpace(len(bs) + lssBlockTypeSize)
+		writeLSSBlock(wbuf, lssMaxSn, bs[:])
+		lss.FinalizeWrite(res)
+	}
+
+	lss.Sync(true)
+	return nil
+}
@@ -0,0 +1,48 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPlasmaPersistTo(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.File = ""
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 20000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", i)))
+	}
+
+	if err := s.PersistTo("teststore.data"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := s.PersistTo("teststore.data"); err != ErrPersistToExists {
+		t.Errorf("expected store exists error, got %v", err)
+	}
+
+	cfg.File = "teststore.data"
+	s2 := newTestIntPlasmaStore(cfg)
+	defer s2.Close()
+
+	if s2.CurrentSeqNum() <= s.CurrentSeqNum() {
+		t.Errorf("expected sn after %d, got %d", s.CurrentSeqNum(), s2.CurrentSeqNum())
+	}
+
+	w2 := s2.NewWriter()
+	for i := 0; i < 20000; i++ {
+		exp := fmt.Sprintf("val-%d", i)
+		if v, err := w2.LookupKV([]byte(fmt.Sprintf("key-%10d", i))); err != nil || string(v) != exp {
+			t.Fatalf("expected %s, got %s %v", exp, v, err)
+		}
+	}
+
+	if err := s2.PersistTo("teststore2.data"); err != ErrPersistToNotSupported {
+		t.Errorf("expected not supported error, got %v", err)
+	}
+}
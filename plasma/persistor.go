@@ -1,11 +1,16 @@
 package plasma
 
 import (
+	"context"
 	"encoding/binary"
+	"runtime/pprof"
+	"sync/atomic"
 	"unsafe"
 )
 
-var maxPageEncodedSize = 1024 * 4
+// Initial size of the context buffers. Buffers grow on demand to fit the
+// largest page or item encoded into them.
+var initBufferSize = 1024 * 4
 
 type lssBlockType uint16
 
@@ -19,6 +24,7 @@ const (
 	lssRecoveryPoints
 	lssMaxSn
 	lssDiscard
+	lssOpMarker
 )
 
 func discardLSSBlock(wbuf []byte) {
@@ -41,24 +47,8 @@ retry:
 	// Never read from lss
 	pg, _ := s.ReadPage(pid, nil, false, ctx)
 	if pg.NeedsFlush() {
-		bs, dataSz, staleFdSz, numSegments := pg.Marshal(buf, s.Config.MaxPageLSSSegments)
-		offset, wbuf, res := s.lss.ReserveSpace(lssBlockTypeSize + len(bs))
-		typ := pgFlushLSSType(pg, numSegments)
-		writeLSSBlock(wbuf, typ, bs)
-
-		var ok bool
-		if evict {
-			pg.Evict(offset, numSegments)
-		} else {
-			pg.AddFlushRecord(offset, dataSz, numSegments)
-		}
-
-		if ok = s.UpdateMapping(pid, pg, ctx); ok {
-			s.lss.FinalizeWrite(res)
-			ctx.sts.FlushDataSz += int64(dataSz) - int64(staleFdSz)
-		} else {
-			discardLSSBlock(wbuf)
-			s.lss.FinalizeWrite(res)
+		bs, dataSz, staleFdSz, numSegments := pg.Marshal(buf, s.maxPageSegments(pg))
+		if !s.writePage(pid, pg, bs, dataSz, staleFdSz, numSegments, evict, ctx) {
 			goto retry
 		}
 	} else if evict && pg.IsEvictable() {
@@ -72,23 +62,119 @@ retry:
 	return pg
 }
 
+// Write the marshalled page to the log. Returns false if the page was
+// modified since it was marshalled.
+func (s *Plasma) writePage(pid PageId, pg Page, bs []byte, dataSz, staleFdSz, numSegments int,
+	evict bool, ctx *wCtx) bool {
+	offset, wbuf, res := s.lss.ReserveSpace(lssBlockTypeSize + len(bs))
+	typ := pgFlushLSSType(pg, numSegments)
+	writeLSSBlock(wbuf, typ, bs)
+
+	if evict {
+		pg.Evict(offset, numSegments)
+	} else {
+		pg.AddFlushRecord(offset, dataSz, numSegments)
+	}
+
+	if !s.UpdateMapping(pid, pg, ctx) {
+		discardLSSBlock(wbuf)
+		s.lss.FinalizeWrite(res)
+		return false
+	}
+
+	s.lss.FinalizeWrite(res)
+	ctx.sts.FlushDataSz += int64(dataSz) - int64(staleFdSz)
+	return true
+}
+
 func (s *Plasma) PersistAll() {
+	s.PersistAllContext(context.Background())
+}
+
+// On cancellation, pages persisted so far are synced to the lss
+func (s *Plasma) PersistAllContext(ctx context.Context) error {
+	ws := s.registerWorker("persistor")
+	ws.idle()
+	ws.doing("persisting")
+	atomic.AddInt32(&ws.running, 1)
+	defer func() {
+		if atomic.AddInt32(&ws.running, -1) == 0 {
+			ws.idle()
+		}
+	}()
+
+	sn := s.persistSn()
+	persistWriters, _ := s.getWorkerCtxs()
 	callb := func(pid PageId, partn RangePartition) error {
-		s.Persist(pid, false, s.persistWriters[partn.Shard])
+		if s.FlushCoalesceSize > 0 {
+			s.persistCoalesced(pid, persistWriters[partn.Shard])
+		} else {
+			s.Persist(pid, false, persistWriters[partn.Shard])
+		}
 		return nil
 	}
 
-	s.PageVisitor(callb, s.NumPersistorThreads)
+	// The persistor goroutines inherit the labels
+	var err error
+	pprof.Do(ctx, s.workerLabels("persistor", ""), func(ctx context.Context) {
+		err = s.PageVisitorContext(ctx, callb, s.numPersistorThreads())
+	})
+
+	for _, w := range persistWriters {
+		s.flushBatch(w)
+	}
+
 	s.lss.Sync(false)
+	if err == nil {
+		s.setPersistedSn(sn)
+	}
+	return err
 }
 
 func (s *Plasma) EvictAll() {
+	s.EvictAllContext(context.Background())
+}
+
+func (s *Plasma) EvictAllContext(ctx context.Context) error {
+	_, evictWriters := s.getWorkerCtxs()
+	callb := func(pid PageId, partn RangePartition) error {
+		s.Persist(pid, true, evictWriters[partn.Shard])
+		return nil
+	}
+
+	return s.PageVisitorContext(ctx, callb, s.numPersistorThreads())
+}
+
+// Write the page if needed and evict only its deltas above the base page.
+// Returns false if the page has no deltas which can be evicted.
+func (s *Plasma) PersistDeltas(pid PageId, ctx *wCtx) bool {
+retry:
+	pg := s.Persist(pid, false, ctx)
+	n := pg.EvictDeltas()
+	if n == 0 {
+		return false
+	}
+
+	if !s.UpdateMapping(pid, pg, ctx) {
+		goto retry
+	}
+
+	ctx.sts.NumRecordSwapOut += int64(n)
+	return true
+}
+
+func (s *Plasma) EvictAllDeltas() {
+	s.EvictAllDeltasContext(context.Background())
+}
+
+func (s *Plasma) EvictAllDeltasContext(ctx context.Context) error {
+	_, evictWriters := s.getWorkerCtxs()
 	callb := func(pid PageId, partn RangePartition) error {
-		s.Persist(pid, true, s.evictWriters[partn.Shard])
+		s.PersistDeltas(pid, evictWriters[partn.Shard])
 		return nil
 	}
 
-	s.PageVisitor(callb, s.NumPersistorThreads)
+	return s.PageVisitorContext(ctx, callb, s.numPersistorThreads())
 }
 
 func pgFlushLSSType(pg Page, numSegments int) lssBlockType {
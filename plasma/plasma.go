@@ -1,9 +1,13 @@
 package plasma
 
 import (
+	"context"
 	"fmt"
 	"github.com/couchbase/nitro/mm"
 	"github.com/couchbase/nitro/skiplist"
+	"math"
+	"os"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -13,7 +17,7 @@ import (
 
 type PageReader func(offset LSSOffset) (Page, error)
 
-const maxCtxBuffers = 8
+const maxCtxBuffers = 10
 const (
 	bufEncPage int = iota
 	bufEncMeta
@@ -23,6 +27,8 @@ const (
 	bufRecovery
 	bufFetch
 	bufPersist
+	bufUpsert
+	bufReplace
 )
 
 const recoverySMRInterval = 100
@@ -31,6 +37,9 @@ var (
 	memQuota       int64
 	maxMemoryQuota = int64(1024 * 1024 * 1024 * 1024)
 	dbInstances    *skiplist.Skiplist
+
+	// Names the in memory instances in profiles
+	numMemInstances int64
 )
 
 func init() {
@@ -47,24 +56,53 @@ type Plasma struct {
 	persistWriters                  []*wCtx
 	evictWriters                    []*wCtx
 	stoplssgc, stopswapper, stopmon chan struct{}
+	stopsync                        chan struct{}
+	syncPeriodUpdated               chan struct{}
 	sync.RWMutex
 
 	// MVCC data structures
-	itemsCount   int64
-	mvcc         sync.RWMutex
-	currSn       uint64
-	numSnCreated int
-	gcSn         uint64
-	currSnapshot *Snapshot
+	itemsCount    int64
+	itemsCountOff int64
+	itemsCounters [numItemsCounters]itemsCounter
+	mvcc          sync.RWMutex
+	currSn        uint64
+	numSnCreated  int
+	gcSn          uint64
+	snPins        snPins
+	currSnapshot  *Snapshot
+	snBatch       *snapshotBatch
+	snBatchLock   sync.Mutex
 
 	lastMaxSn uint64
 
+	// Snapshots written to the log and made durable by a commit
+	persistedSn uint64
+	durableSn   uint64
+
+	// Retention policies and the times of the sequence numbers
+	retention unsafe.Pointer
+	snTimes   snTimes
+
+	// Per key range overrides of MaxPageLSSSegments
+	segPolicies unsafe.Pointer
+
+	// Dictionaries of the compressed values
+	valueDicts *dictSet
+	dictLock   sync.Mutex
+
 	rpSns          unsafe.Pointer
 	rpVersion      uint16
 	recoveryPoints []*RecoveryPoint
+	// Recovery point whose pages are being persisted. It is not written
+	// to the lss until they are.
+	preparedRP *RecoveryPoint
 
 	hasMemoryPressure bool
 	clockHandle       *clockHandle
+	quotaInst         *quotaInstance
+	manager           *Manager
+	workers           workerRegistry
+	instance          string
 	clockLock         sync.Mutex
 
 	smrWg   sync.WaitGroup
@@ -75,6 +113,42 @@ type Plasma struct {
 	wCtxLock sync.Mutex
 	wCtxList *wCtx
 	gCtx     *wCtx
+
+	meta storeMeta
+	// Set while a store written without a format version is not yet
+	// stamped by a write
+	legacyFormat int32
+
+	writerPool     []*Writer
+	writerPoolLock sync.Mutex
+
+	shards  shardTable
+	lockFd  *os.File
+	openDir string
+
+	coldTier *coldTier
+	repair   *RepairReport
+
+	// Id of the last structural operation written to the log
+	lastOpId uint64
+
+	cfgLock       sync.Mutex
+	configUpdates int64
+	advisor       advisorState
+	ckpt          checkpointState
+
+	// *Config with the tunables changed by UpdateConfig, see liveConfig
+	liveCfg unsafe.Pointer
+
+	// Configuration the store was opened with, before defaults are applied
+	openCfg Config
+
+	compactionQ *compactionQueue
+	prefetchQ   *prefetchQueue
+	txTokens    txTokenTracker
+	splits      splitTracker
+	pageAccess  pageAccessTracker
+	writeStall  writeStallState
 }
 
 type Stats struct {
@@ -85,14 +159,27 @@ type Stats struct {
 	Deletes  int64
 
 	CompactConflicts int64
+	QueuedCompacts   int64
 	SplitConflicts   int64
 	MergeConflicts   int64
+	ThrottledMerges  int64
 	InsertConflicts  int64
 	DeleteConflicts  int64
 	SwapInConflicts  int64
 
+	// Writes delayed and rejected by the write stall limits, and the
+	// time in nanoseconds for which they were delayed
+	StalledWrites  int64
+	DeniedWrites   int64
+	WriteStallTime int64
+
 	BytesIncoming int64
 	BytesWritten  int64
+	BytesOutgoing int64
+
+	// Sizes of the keys and values written through the key value APIs
+	KeySizes   SizeHistogram
+	ValueSizes SizeHistogram
 
 	FlushDataSz int64
 
@@ -110,9 +197,22 @@ type Stats struct {
 	AllocSzIndex     int64
 	FreeSzIndex      int64
 	ReclaimSzIndex   int64
+	IndexEvictions   int64
 
 	NumPages int64
 
+	ChecksumMismatches int64
+
+	// Structural operations replayed by recovery, skipped as already
+	// applied and found with missing blocks
+	ReplayedOps   int64
+	SkippedOps    int64
+	IncompleteOps int64
+
+	// Batched writes of page update records and the pages written by them
+	CoalescedFlushes int64
+	CoalescedPages   int64
+
 	LSSFrag      int
 	LSSDataSize  int64
 	LSSUsedSpace int64
@@ -122,13 +222,28 @@ type Stats struct {
 	NumLSSCleanerReads  int64
 	LSSCleanerReadBytes int64
 
-	CacheHits   int64
-	CacheMisses int64
+	ColdSegments   int64
+	ColdFetches    int64
+	ColdFetchBytes int64
+	ColdCacheHits  int64
+
+	CacheHits       int64
+	CacheMisses     int64
+	PrefetchedPages int64
 
 	WriteAmp      float64
 	WriteAmpAvg   float64
+	ReadAmp       float64
+	ReadAmpAvg    float64
 	CacheHitRatio float64
 	ResidentRatio float64
+
+	NumPersistorThreads int
+	NumEvictorThreads   int
+	ConfigUpdates       int64
+
+	AbandonedTxTokens int64
+	ReleasedTxTokens  int64
 }
 
 func (s *Stats) Merge(o *Stats) {
@@ -139,8 +254,10 @@ func (s *Stats) Merge(o *Stats) {
 	s.Deletes += o.Deletes
 
 	s.CompactConflicts += o.CompactConflicts
+	s.QueuedCompacts += o.QueuedCompacts
 	s.SplitConflicts += o.SplitConflicts
 	s.MergeConflicts += o.MergeConflicts
+	s.ThrottledMerges += o.ThrottledMerges
 	s.InsertConflicts += o.InsertConflicts
 	s.DeleteConflicts += o.DeleteConflicts
 	s.SwapInConflicts += o.SwapInConflicts
@@ -152,19 +269,34 @@ func (s *Stats) Merge(o *Stats) {
 	s.AllocSzIndex += o.AllocSzIndex
 	s.FreeSzIndex += o.FreeSzIndex
 	o.ReclaimSzIndex += o.ReclaimSzIndex
+	s.IndexEvictions += o.IndexEvictions
 
 	s.NumRecordAllocs += o.NumRecordAllocs
 	s.NumRecordFrees += o.NumRecordFrees
 	s.NumRecordSwapOut += o.NumRecordSwapOut
 	s.NumRecordSwapIn += o.NumRecordSwapIn
 
+	s.StalledWrites += o.StalledWrites
+	s.DeniedWrites += o.DeniedWrites
+	s.WriteStallTime += o.WriteStallTime
+
 	s.BytesIncoming += o.BytesIncoming
+	s.BytesOutgoing += o.BytesOutgoing
+	s.KeySizes.Merge(&o.KeySizes)
+	s.ValueSizes.Merge(&o.ValueSizes)
 
 	s.NumLSSReads += o.NumLSSReads
 	s.LSSReadBytes += o.LSSReadBytes
 
 	s.CacheHits += o.CacheHits
 	s.CacheMisses += o.CacheMisses
+	s.PrefetchedPages += o.PrefetchedPages
+	s.ChecksumMismatches += o.ChecksumMismatches
+	s.ReplayedOps += o.ReplayedOps
+	s.SkippedOps += o.SkippedOps
+	s.IncompleteOps += o.IncompleteOps
+	s.CoalescedFlushes += o.CoalescedFlushes
+	s.CoalescedPages += o.CoalescedPages
 }
 
 func (s Stats) String() string {
@@ -177,11 +309,16 @@ func (s Stats) String() string {
 		"inserts           = %d\n"+
 		"deletes           = %d\n"+
 		"compact_conflicts = %d\n"+
+		"queued_compacts   = %d\n"+
 		"split_conflicts   = %d\n"+
 		"merge_conflicts   = %d\n"+
+		"throttled_merges  = %d\n"+
 		"insert_conflicts  = %d\n"+
 		"delete_conflicts  = %d\n"+
 		"swapin_conflicts  = %d\n"+
+		"stalled_writes    = %d\n"+
+		"denied_writes     = %d\n"+
+		"write_stall_ms    = %d\n"+
 		"memory_size       = %d\n"+
 		"memory_size_index = %d\n"+
 		"allocated         = %d\n"+
@@ -191,7 +328,14 @@ func (s Stats) String() string {
 		"allocated_index   = %d\n"+
 		"freed_index       = %d\n"+
 		"reclaimed_index   = %d\n"+
+		"index_evictions   = %d\n"+
 		"num_pages         = %d\n"+
+		"checksum_errors   = %d\n"+
+		"replayed_ops      = %d\n"+
+		"skipped_ops       = %d\n"+
+		"incomplete_ops    = %d\n"+
+		"coalesced_flushes = %d\n"+
+		"coalesced_pages   = %d\n"+
 		"num_rec_allocs    = %d\n"+
 		"num_rec_frees     = %d\n"+
 		"num_rec_swapout   = %d\n"+
@@ -200,6 +344,9 @@ func (s Stats) String() string {
 		"bytes_written     = %d\n"+
 		"write_amp         = %.2f\n"+
 		"write_amp_avg     = %.2f\n"+
+		"bytes_outgoing    = %d\n"+
+		"read_amp          = %.2f\n"+
+		"read_amp_avg      = %.2f\n"+
 		"lss_fragmentation = %d%%\n"+
 		"lss_data_size     = %d\n"+
 		"lss_used_space    = %d\n"+
@@ -207,41 +354,89 @@ func (s Stats) String() string {
 		"lss_read_bs       = %d\n"+
 		"lss_gc_num_reads  = %d\n"+
 		"lss_gc_reads_bs   = %d\n"+
+		"cold_segments     = %d\n"+
+		"cold_fetches      = %d\n"+
+		"cold_fetch_bs     = %d\n"+
+		"cold_cache_hits   = %d\n"+
 		"cache_hits        = %d\n"+
 		"cache_misses      = %d\n"+
+		"prefetched_pages  = %d\n"+
 		"cache_hit_ratio   = %.2f\n"+
-		"resident_ratio    = %.2f\n",
+		"resident_ratio    = %.2f\n"+
+		"persistor_threads = %d\n"+
+		"evictor_threads   = %d\n"+
+		"config_updates    = %d\n"+
+		"abandoned_tokens  = %d\n"+
+		"released_tokens   = %d\n",
 		atomic.LoadInt64(&memQuota),
 		s.Inserts-s.Deletes,
 		s.Compacts, s.Splits, s.Merges,
 		s.Inserts, s.Deletes, s.CompactConflicts,
-		s.SplitConflicts, s.MergeConflicts,
+		s.QueuedCompacts, s.SplitConflicts, s.MergeConflicts, s.ThrottledMerges,
 		s.InsertConflicts, s.DeleteConflicts,
-		s.SwapInConflicts, s.MemSz, s.MemSzIndex,
+		s.SwapInConflicts, s.StalledWrites, s.DeniedWrites,
+		s.WriteStallTime/int64(time.Millisecond), s.MemSz, s.MemSzIndex,
 		s.AllocSz, s.FreeSz, s.ReclaimSz,
 		s.FreeSz-s.ReclaimSz,
-		s.AllocSzIndex, s.FreeSzIndex, s.ReclaimSzIndex,
-		s.NumPages, s.NumRecordAllocs, s.NumRecordFrees,
+		s.AllocSzIndex, s.FreeSzIndex, s.ReclaimSzIndex, s.IndexEvictions,
+		s.NumPages, s.ChecksumMismatches,
+		s.ReplayedOps, s.SkippedOps, s.IncompleteOps,
+		s.CoalescedFlushes, s.CoalescedPages,
+		s.NumRecordAllocs, s.NumRecordFrees,
 		s.NumRecordSwapOut, s.NumRecordSwapIn,
 		s.BytesIncoming, s.BytesWritten,
 		s.WriteAmp, s.WriteAmpAvg,
+		s.BytesOutgoing, s.ReadAmp, s.ReadAmpAvg,
 		s.LSSFrag, s.LSSDataSize, s.LSSUsedSpace,
 		s.NumLSSReads, s.LSSReadBytes,
 		s.NumLSSCleanerReads, s.LSSCleanerReadBytes,
-		s.CacheHits, s.CacheMisses, s.CacheHitRatio,
-		s.ResidentRatio)
+		s.ColdSegments, s.ColdFetches, s.ColdFetchBytes, s.ColdCacheHits,
+		s.CacheHits, s.CacheMisses, s.PrefetchedPages, s.CacheHitRatio,
+		s.ResidentRatio, s.NumPersistorThreads,
+		s.NumEvictorThreads, s.ConfigUpdates,
+		s.AbandonedTxTokens, s.ReleasedTxTokens)
 }
 
 func New(cfg Config) (*Plasma, error) {
+	return NewWithContext(context.Background(), cfg)
+}
+
+// Recovery is aborted if ctx is done before it completes
+func NewWithContext(ctx context.Context, cfg Config) (*Plasma, error) {
 	var err error
 
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	openCfg := cfg
 	cfg = applyConfigDefaults(cfg)
+	valueDicts := new(dictSet)
+	if cfg.ValueDictionary {
+		cfg.ItemCodec = &dictItemCodec{ItemCodec: cfg.ItemCodec, dicts: valueDicts,
+			maxSize: cfg.MaxDictValueSize}
+	}
+
+	if cfg.ItemChecksums {
+		cfg.ItemCodec = ChecksumItemCodec(cfg.ItemCodec)
+	}
 
 	s := &Plasma{
 		Config:      cfg,
 		stopmon:     make(chan struct{}),
 		stoplssgc:   make(chan struct{}),
 		stopswapper: make(chan struct{}),
+		stopsync:    make(chan struct{}),
+		valueDicts:  valueDicts,
+		openCfg:     openCfg,
+
+		syncPeriodUpdated: make(chan struct{}, 1),
+	}
+
+	s.txTokens.tokens = make(map[TxToken]*txTokenState)
+	s.instance = cfg.File
+	if s.instance == "" {
+		s.instance = fmt.Sprintf("mem-%d", atomic.AddInt64(&numMemInstances, 1))
 	}
 
 	slCfg := skiplist.DefaultConfig()
@@ -259,7 +454,7 @@ func New(cfg Config) (*Plasma, error) {
 	var cfGetter, lfGetter FilterGetter
 	if cfg.EnableShapshots {
 		cfGetter = func() ItemFilter {
-			gcSn := atomic.LoadUint64(&s.gcSn) + 1
+			gcSn := s.gcWatermark() + 1
 			rpSns := (*[]uint64)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&s.rpSns))))
 
 			var gcPos int
@@ -280,15 +475,16 @@ func New(cfg Config) (*Plasma, error) {
 				snIntervals[gcPos+1] = gcSn
 			}
 
-			return &gcFilter{snIntervals: snIntervals}
+			return s.newDictFilter(s.newRetentionFilter(&gcFilter{snIntervals: snIntervals,
+				rollbackFilter: rollbackFilter{codec: s.ItemCodec}}))
 		}
 
 		lfGetter = func() ItemFilter {
-			return &rollbackFilter{}
+			return &rollbackFilter{codec: s.ItemCodec}
 		}
 	} else {
 		cfGetter = func() ItemFilter {
-			return new(defaultFilter)
+			return s.newDictFilter(new(defaultFilter))
 		}
 
 		lfGetter = func() ItemFilter {
@@ -304,33 +500,121 @@ func New(cfg Config) (*Plasma, error) {
 		go s.smrWorker(s.gCtx)
 	}
 
-	sbuf := dbInstances.MakeBuf()
-	defer dbInstances.FreeBuf(sbuf)
-	dbInstances.Insert(unsafe.Pointer(s), ComparePlasma, sbuf, &dbInstances.Stats)
+	if cfg.QuotaManager != nil {
+		s.Config.TriggerSwapper = cfg.QuotaManager.swapperFor(s)
+	}
+
+	liveCfg := s.Config
+	s.liveCfg = unsafe.Pointer(&liveCfg)
 
 	if s.shouldPersist {
-		commitDur := time.Duration(cfg.SyncInterval) * time.Second
-		s.lss, err = NewLSStore(cfg.File, cfg.LSSLogSegmentSize, cfg.FlushBufferSize, 2, cfg.UseMmap, commitDur)
+		commitDur := cfg.SyncPeriod
+		if cfg.Ephemeral {
+			// The log is only committed to release the space cleaned
+			commitDur = time.Duration(math.MaxInt64)
+		}
+
+		if cfg.InMemoryLSS {
+			if s.lss, err = NewMemLSStore(cfg.File, cfg.FlushBufferSize, 2, commitDur); err != nil {
+				s.abortOpen()
+				return nil, err
+			}
+		} else {
+			if s.openDir, err = registerOpenDir(cfg.File); err != nil {
+				s.abortOpen()
+				return nil, err
+			}
+
+			os.MkdirAll(cfg.File, 0755)
+			if s.lockFd, err = lockFile(filepath.Join(cfg.File, lockFileName)); err != nil {
+				s.abortOpen()
+				return nil, err
+			}
+
+			if cfg.Ephemeral {
+				if err = removeEphemeralLog(cfg.File); err != nil {
+					s.abortOpen()
+					return nil, err
+				}
+			}
+
+			if cfg.ColdTier != nil {
+				s.lss, s.coldTier, err = newTieredLSStore(cfg.File, cfg.LSSLogSegmentSize,
+					cfg.FlushBufferSize, 2, commitDur, *cfg.ColdTier)
+			} else {
+				s.lss, err = NewLSStore(cfg.File, cfg.LSSLogSegmentSize, cfg.FlushBufferSize, 2, cfg.UseMmap, commitDur)
+			}
+
+			if err != nil {
+				s.abortOpen()
+				return nil, err
+			}
+		}
+
+		ls := s.lss.(*lsStore)
+		ls.clock = cfg.Clock
+		if ml, ok := ls.log.(*multiFilelog); ok {
+			ml.archive = cfg.ArchiveLogSegment
+		}
+		if cfg.FaultInjector != nil {
+			ls.log = newFaultLog(ls.log, cfg.FaultInjector)
+		}
+
+		checks := []func() error{s.loadStoreMeta, s.checkFormatVersion, s.checkCompareId,
+			s.checkItemCodec, s.checkConfigFingerprint, s.initUUID, s.loadValueDicts}
+		for _, check := range checks {
+			if err = check(); err != nil {
+				break
+			}
+		}
+
 		if err != nil {
+			s.abortOpen()
 			return nil, err
 		}
 
 		s.lss.SetSafeTrimCallback(s.findSafeLSSTrimOffset)
 		s.initLRUClock()
-		err = s.doRecovery()
+		if err = s.doRecovery(ctx); ctx.Err() != nil {
+			s.abortOpen()
+			return nil, ctx.Err()
+		}
 	}
 
 	s.doInit()
 
 	if s.shouldPersist {
-		s.persistWriters = make([]*wCtx, runtime.NumCPU())
-		s.evictWriters = make([]*wCtx, runtime.NumCPU())
-		for i, _ := range s.persistWriters {
-			s.persistWriters[i] = s.newWCtx()
-			s.evictWriters[i] = s.newWCtx()
-		}
+		s.growWorkerCtxs(runtime.NumCPU())
+		s.growWorkerCtxs(cfg.NumPersistorThreads)
+		s.growWorkerCtxs(cfg.NumEvictorThreads)
 		s.lssCleanerWriter = s.newWCtx()
 
+		if s.repair != nil && s.repair.HasLoss() {
+			fmt.Printf("Plasma: Repair: %v\n", s.repair)
+			if err := s.rewriteLSS(); err != nil {
+				s.abortOpen()
+				return nil, err
+			}
+		}
+
+		if s.EnableShapshots {
+			if err := s.resumeRollback(); err != nil {
+				s.abortOpen()
+				return nil, err
+			}
+		}
+	}
+
+	// Counted before the background workers are started, as they are not
+	// stopped if the open fails
+	if s.EnableShapshots && s.ExactItemsCount {
+		if err := s.recountItems(); err != nil {
+			s.abortOpen()
+			return nil, err
+		}
+	}
+
+	if s.shouldPersist {
 		if cfg.AutoLSSCleaning {
 			go s.lssCleanerDaemon()
 		}
@@ -338,23 +622,66 @@ func New(cfg Config) (*Plasma, error) {
 		if cfg.AutoSwapper {
 			go s.swapperDaemon()
 		}
+
+		if cfg.SyncPeriod > 0 {
+			go s.lssSyncDaemon()
+		}
+
+		if s.coldTier != nil {
+			go s.superviseWorker("cold_tier", s.coldTierDaemon)
+		}
+	}
+
+	// Registered once the open can no longer fail, as the registry is
+	// visited for the stats of all the instances
+	sbuf := dbInstances.MakeBuf()
+	defer dbInstances.FreeBuf(sbuf)
+	dbInstances.Insert(unsafe.Pointer(s), ComparePlasma, sbuf, &dbInstances.Stats)
+
+	s.startCompactors()
+	s.startPrefetchers()
+	if cfg.MaxTxTokenAge > 0 {
+		go s.superviseWorker("txtoken_monitor", s.txTokenMonitor)
+	}
+
+	s.initAdvisor()
+	if cfg.AutoTuneInterval > 0 {
+		go s.superviseWorker("auto_tune", s.autoTuneDaemon)
+	}
+
+	if cfg.CheckpointInterval > 0 && s.shouldPersist && !cfg.InMemoryLSS {
+		go s.superviseWorker("checkpoint", s.checkpointDaemon)
+	}
+
+	if cfg.IndexMemoryQuota > 0 {
+		go s.superviseWorker("index_evictor", s.indexEvictorDaemon)
+	}
+
+	if cfg.QuotaManager != nil {
+		cfg.QuotaManager.register(s)
+	}
+
+	if cfg.WorkerStallTimeout > 0 {
+		go s.workerHealthMonitor()
 	}
 
-	go s.monitorMemUsage()
-	go s.runtimeStats()
+	go s.superviseWorker("memory_monitor", s.monitorMemUsage)
+	go s.superviseWorker("runtime_stats", s.runtimeStats)
 	return s, err
 }
 
-func (s *Plasma) runtimeStats() {
+func (s *Plasma) runtimeStats(ws *workerState) {
 	so := s.GetStats()
 	for {
+		ws.beat()
 		select {
 		case <-s.stopmon:
 			return
 		default:
 		}
 
-		time.Sleep(time.Second * 5)
+		ws.wait(time.Second * 5)
+		s.Clock.Sleep(time.Second * 5)
 
 		now := s.GetStats()
 		bsOut := (float64(now.BytesWritten) - float64(so.BytesWritten))
@@ -363,6 +690,13 @@ func (s *Plasma) runtimeStats() {
 			s.gCtx.sts.WriteAmp = bsOut / bsIn
 		}
 
+		bsRead := float64(now.LSSReadBytes-now.LSSCleanerReadBytes) -
+			float64(so.LSSReadBytes-so.LSSCleanerReadBytes)
+		bsOutgoing := float64(now.BytesOutgoing) - float64(so.BytesOutgoing)
+		if bsOutgoing > 0 {
+			s.gCtx.sts.ReadAmp = bsRead / bsOutgoing
+		}
+
 		hits := now.CacheHits - so.CacheHits
 		miss := now.CacheMisses - so.CacheMisses
 		if tot := float64(hits + miss); tot > 0 {
@@ -372,17 +706,19 @@ func (s *Plasma) runtimeStats() {
 	}
 }
 
-func (s *Plasma) monitorMemUsage() {
+func (s *Plasma) monitorMemUsage(ws *workerState) {
 	sctx := s.newWCtx2().SwapperContext()
 
 	for {
+		ws.beat()
 		select {
 		case <-s.stopmon:
 			return
 		default:
 		}
 		s.hasMemoryPressure = s.TriggerSwapper(sctx)
-		time.Sleep(time.Millisecond * 100)
+		ws.wait(time.Millisecond * 100)
+		s.Clock.Sleep(time.Millisecond * 100)
 	}
 }
 
@@ -404,29 +740,70 @@ func (s *Plasma) doInit() {
 			refCount: 1,
 			db:       s,
 		}
+		s.snTimes.record(s.currSn, s.Clock.Now())
 
 		s.updateMaxSn(s.currSn, true)
+		s.persistedSn = s.currSn - 1
+		s.durableSn = s.currSn - 1
 		s.updateRecoveryPoints(s.recoveryPoints)
 		s.updateRPSns(s.recoveryPoints)
 	}
 }
 
-func (s *Plasma) doRecovery() error {
+func (s *Plasma) doRecovery(ctx context.Context) error {
 	pg := newPage(s.gCtx, nil, nil).(*page)
 
 	buf := s.gCtx.GetBuffer(bufRecovery)
 
+	// The mapping is restored from the checkpoint and the log written after
+	// it is replayed. It is kept up to date during the replay for the next
+	// checkpoint.
+	start := s.lss.HeadOffset()
+	cp := s.loadCheckpoint()
+	if cp != nil {
+		if err := s.restoreCheckpoint(cp); err != nil {
+			return err
+		}
+		start = cp.offset
+	} else if s.CheckpointInterval > 0 && !s.RepairOnRecovery {
+		cp = newMappingCheckpoint(start)
+	}
+
+	ops := newOpReplayState()
+	if cp != nil {
+		ops.maxId = cp.ops.maxId
+	}
+
 	fn := func(offset LSSOffset, bs []byte) (bool, error) {
+		if err := checkContext(ctx); err != nil {
+			return false, err
+		}
+
 		typ := getLSSBlockType(bs)
 		bs = bs[lssBlockTypeSize:]
+		if cp != nil && cp.apply(pg, offset, typ, bs) != nil {
+			cp = nil
+		}
+
+		// Blocks of an operation which has already been applied
+		if skip, err := ops.visit(typ, bs); skip || err != nil {
+			return err == nil, err
+		}
+
 		switch typ {
 		case lssDiscard:
 		case lssRecoveryPoints:
-			s.rpVersion, s.recoveryPoints = unmarshalRPs(bs)
+			var rps []*RecoveryPoint
+			s.rpVersion, rps = unmarshalRPs(bs)
+			s.recoveryPoints = setRPsLogOffset(s.recoveryPoints, rps, offset)
 		case lssMaxSn:
 			s.currSn = decodeMaxSn(bs)
 		case lssPageRemove:
-			rmPglow := getRmPageLow(bs)
+			rmPglow, err := getRmPageLow(bs)
+			if err != nil {
+				return false, err
+			}
+
 			pid := s.getPageId(rmPglow, s.gCtx)
 			if pid != nil {
 				currPg, err := s.ReadPage(pid, s.gCtx.pgRdrFn, false, s.gCtx)
@@ -440,7 +817,12 @@ func (s *Plasma) doRecovery() error {
 				s.unindexPage(pid, s.gCtx)
 			}
 		case lssPageData, lssPageReloc, lssPageUpdate:
-			pg.Unmarshal(bs, s.gCtx)
+			if err := pg.Unmarshal(bs, s.gCtx); err != nil {
+				pg.free(false)
+				pg.Reset()
+				return false, err
+			}
+
 			flushDataSz := len(bs)
 
 			newPageData := (typ == lssPageData || typ == lssPageReloc)
@@ -483,15 +865,51 @@ func (s *Plasma) doRecovery() error {
 		return true, nil
 	}
 
-	err := s.lss.Visitor(fn, buf)
+	var err error
+	if s.RepairOnRecovery {
+		s.repair = new(RepairReport)
+		repairFn := func(offset LSSOffset, bs []byte) (bool, error) {
+			if err := checkContext(ctx); err != nil {
+				return false, err
+			}
+			return s.repairBlock(fn, offset, bs, pg)
+		}
+
+		if err = s.repairVisitLSS(repairFn, buf); err == nil && ctx.Err() == nil {
+			err = s.repairPageRanges()
+		}
+	} else {
+		err = s.lss.(*lsStore).visitor(int64(start), int64(s.lss.TailOffset()), fn, buf)
+	}
+
 	if err != nil {
 		return err
 	}
 
+	replayed, skipped, incomplete := ops.finish()
+	s.gCtx.sts.ReplayedOps += replayed
+	s.gCtx.sts.SkippedOps += skipped
+	s.gCtx.sts.IncompleteOps += incomplete
+	if skipped > 0 || incomplete > 0 {
+		fmt.Printf("Plasma: Recovery: replayed %d operations, skipped %d already applied, %d incomplete\n",
+			replayed, skipped, incomplete)
+	}
+	s.lastOpId = ops.maxId
+
+	if cp != nil {
+		cp.offset = s.lss.TailOffset()
+		s.ckpt.cp, s.ckpt.written = cp, start
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
 	s.trySMRObjects(s.gCtx, 0)
 
-	// Initialize rightSiblings for all pages
+	// Initialize the siblings of all pages
 	var lastPg Page
+	var lastPid PageId
 	callb := func(pid PageId, partn RangePartition) error {
 		pg, err := s.ReadPage(pid, s.gCtx.pgRdrFn, false, s.gCtx)
 		if lastPg != nil {
@@ -500,14 +918,21 @@ func (s *Plasma) doRecovery() error {
 			}
 
 			lastPg.SetNext(pid)
+			if err == nil {
+				pg.SetPrev(lastPid)
+			}
 		}
 
-		lastPg = pg
+		lastPg, lastPid = pg, pid
 		return err
 	}
 
 	s.PageVisitor(callb, 1)
-	s.gcSn = s.currSn
+
+	// The current snapshot has the recovered sequence number and is open
+	if s.currSn > 0 {
+		s.gcSn = s.currSn - 1
+	}
 
 	if lastPg != nil {
 		lastPg.SetNext(s.EndPageId())
@@ -520,6 +945,13 @@ func (s *Plasma) doRecovery() error {
 }
 
 func (s *Plasma) Close() {
+	if s.manager != nil {
+		s.manager.remove(s)
+	}
+
+	s.stopCompactors()
+	s.stopPrefetchers()
+
 	if s.EnableShapshots {
 		// Force SMR flush
 		s.NewSnapshot().Close()
@@ -537,8 +969,33 @@ func (s *Plasma) Close() {
 	}
 
 	if s.Config.shouldPersist {
-		s.PersistAll()
+		if s.SyncPeriod > 0 {
+			s.stopsync <- struct{}{}
+			<-s.stopsync
+		}
+
+		if !s.Ephemeral {
+			s.PersistAll()
+		}
+		if s.SyncPeriod > 0 {
+			s.syncLSS()
+		}
+		if s.CheckpointInterval > 0 && !s.InMemoryLSS {
+			if err := s.Checkpoint(); err != nil {
+				fmt.Printf("Plasma: Mapping checkpoint failed (err=%v)\n", err)
+			}
+		}
 		s.lss.Close()
+		s.unlockStore()
+		if s.Ephemeral {
+			if err := os.RemoveAll(s.File); err != nil {
+				fmt.Printf("Plasma: Unable to remove ephemeral store %s (err=%v)\n", s.File, err)
+			}
+		}
+	}
+
+	if s.QuotaManager != nil {
+		s.QuotaManager.unregister(s)
 	}
 
 	sbuf := dbInstances.MakeBuf()
@@ -552,13 +1009,56 @@ func (s *Plasma) Close() {
 	}
 }
 
+// Releases the log, the directory lock and the SMR worker of a store
+// whose open failed
+func (s *Plasma) abortOpen() {
+	if s.lss != nil {
+		s.lss.Close()
+	}
+	s.unlockStore()
+
+	if s.useMemMgmt {
+		close(s.smrChan)
+		s.smrWg.Wait()
+		s.destroyAllObjects()
+	}
+}
+
+func (s *Plasma) unlockStore() {
+	if s.lockFd != nil {
+		unlockFile(s.lockFd)
+	}
+
+	if s.openDir != "" {
+		unregisterOpenDir(s.openDir)
+	}
+}
+
+// Remove the files left behind by an ephemeral store which was not closed
+func removeEphemeralLog(dir string) error {
+	fis, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range fis {
+		if fi.Name() != lockFileName {
+			if err := os.RemoveAll(filepath.Join(dir, fi.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func ComparePlasma(a, b unsafe.Pointer) int {
 	return int(uintptr(a)) - int(uintptr(b))
 }
 
 type Writer struct {
 	*wCtx
-	count int64
+	count *itemsCounter
 }
 
 type Reader struct {
@@ -583,6 +1083,8 @@ type wCtx struct {
 	next *wCtx
 
 	safeOffset LSSOffset
+
+	flushBatch *flushBatch
 }
 
 func (ctx *wCtx) freePages(pages []pgFreeObj) {
@@ -643,7 +1145,7 @@ func (s *Plasma) newWCtx2() *wCtx {
 
 func (ctx *wCtx) GetBuffer(id int) *Buffer {
 	if ctx.pgBuffers[id] == nil {
-		ctx.pgBuffers[id] = newBuffer(maxPageEncodedSize)
+		ctx.pgBuffers[id] = newBuffer(initBufferSize)
 	}
 
 	return ctx.pgBuffers[id]
@@ -658,6 +1160,7 @@ func (s *Plasma) NewWriter() *Writer {
 	s.Lock()
 	defer s.Unlock()
 
+	w.count = &s.itemsCounters[len(s.wlist)%numItemsCounters]
 	s.wlist = append(s.wlist, w)
 	if s.useMemMgmt {
 		s.smrWg.Add(1)
@@ -667,9 +1170,39 @@ func (s *Plasma) NewWriter() *Writer {
 	return w
 }
 
+// Lend an idle writer from the pool. A new writer is created if none of
+// the pooled writers are free. The writer must be returned using ReleaseWriter.
+func (s *Plasma) AcquireWriter() *Writer {
+	s.writerPoolLock.Lock()
+	if n := len(s.writerPool); n > 0 {
+		w := s.writerPool[n-1]
+		s.writerPool = s.writerPool[:n-1]
+		s.writerPoolLock.Unlock()
+		return w
+	}
+	s.writerPoolLock.Unlock()
+
+	return s.NewWriter()
+}
+
+func (s *Plasma) ReleaseWriter(w *Writer) {
+	s.writerPoolLock.Lock()
+	defer s.writerPoolLock.Unlock()
+
+	s.writerPool = append(s.writerPool, w)
+}
+
+// Run fn with a writer borrowed from the writer pool
+func (s *Plasma) Execute(fn func(w *Writer)) {
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	fn(w)
+}
+
 func (s *Plasma) NewReader() *Reader {
 	iter := s.NewIterator().(*Iterator)
-	iter.filter = &snFilter{}
+	iter.filter = &snFilter{rollbackFilter: rollbackFilter{codec: s.ItemCodec}}
 
 	return &Reader{
 		iter: &MVCCIterator{
@@ -704,6 +1237,12 @@ func (s *Plasma) GetStats() Stats {
 		sts.Merge(w.sts)
 	}
 
+	sts.NumPersistorThreads = s.numPersistorThreads()
+	sts.NumEvictorThreads = s.numEvictorThreads()
+	sts.ConfigUpdates = atomic.LoadInt64(&s.configUpdates)
+	sts.AbandonedTxTokens = atomic.LoadInt64(&s.txTokens.abandoned)
+	sts.ReleasedTxTokens = atomic.LoadInt64(&s.txTokens.released)
+
 	sts.MemSz = sts.AllocSz - sts.FreeSz
 	sts.MemSzIndex = sts.AllocSzIndex - sts.FreeSzIndex
 	if s.shouldPersist {
@@ -711,6 +1250,12 @@ func (s *Plasma) GetStats() Stats {
 		sts.LSSFrag, sts.LSSDataSize, sts.LSSUsedSpace = s.GetLSSInfo()
 		sts.NumLSSCleanerReads = s.lssCleanerWriter.sts.NumLSSReads
 		sts.LSSCleanerReadBytes = s.lssCleanerWriter.sts.LSSReadBytes
+		if t := s.coldTier; t != nil {
+			sts.ColdSegments = atomic.LoadInt64(&t.numSegments)
+			sts.ColdFetches = atomic.LoadInt64(&t.fetches)
+			sts.ColdFetchBytes = atomic.LoadInt64(&t.fetchBytes)
+			sts.ColdCacheHits = atomic.LoadInt64(&t.cacheHits)
+		}
 		sts.CacheHitRatio = s.gCtx.sts.CacheHitRatio
 		sts.WriteAmp = s.gCtx.sts.WriteAmp
 		bsOut := float64(sts.BytesWritten)
@@ -718,6 +1263,13 @@ func (s *Plasma) GetStats() Stats {
 		if bsIn > 0 {
 			sts.WriteAmpAvg = bsOut / bsIn
 		}
+
+		// Reads done by the log cleaner are accounted as write amplification
+		sts.ReadAmp = s.gCtx.sts.ReadAmp
+		bsRead := float64(sts.LSSReadBytes - sts.LSSCleanerReadBytes)
+		if bsOutgoing := float64(sts.BytesOutgoing); bsOutgoing > 0 {
+			sts.ReadAmpAvg = bsRead / bsOutgoing
+		}
 		cachedRecs := sts.NumRecordAllocs - sts.NumRecordFrees
 		lssRecs := sts.NumRecordSwapOut - sts.NumRecordSwapIn
 		totalRecs := cachedRecs + lssRecs
@@ -808,7 +1360,7 @@ retry:
 	var wbufs [][]byte
 	var res LSSResource
 
-	if s.shouldPersist {
+	if s.logSMOs() {
 		var numSegments int
 		metaBS = marshalPageSMO(pg, metaBuf)
 		pgBS, fdSz, staleFdSz, numSegments = pPg.Marshal(pgBuf, FullMarshal)
@@ -818,33 +1370,38 @@ retry:
 			lssBlockTypeSize + len(pgBS),
 		}
 
-		offsets, wbufs, res = s.lss.ReserveSpaceMulti(sizes)
+		offsets, wbufs, res = s.reserveOpSpace(opKindMerge, sizes)
 
-		writeLSSBlock(wbufs[0], lssPageRemove, metaBS)
+		writeLSSBlock(wbufs[1], lssPageRemove, metaBS)
 
-		writeLSSBlock(wbufs[1], lssPageData, pgBS)
-		pPg.AddFlushRecord(offsets[1], fdSz, numSegments)
+		writeLSSBlock(wbufs[2], lssPageData, pgBS)
+		pPg.AddFlushRecord(offsets[2], fdSz, numSegments)
 	}
 
 	if s.UpdateMapping(pPid, pPg, ctx) {
 		s.unindexPage(pid, ctx)
 
-		if s.shouldPersist {
+		if s.logSMOs() {
 			ctx.sts.FlushDataSz += int64(fdSz) - int64(staleFdSz)
 			s.lss.FinalizeWrite(res)
 		}
 
 		return
 
-	} else if s.shouldPersist {
-		discardLSSBlock(wbufs[0])
-		discardLSSBlock(wbufs[1])
+	} else if s.logSMOs() {
+		discardOpBlocks(wbufs)
 		s.lss.FinalizeWrite(res)
 	}
 
 	goto retry
 }
 
+// Splits and merges are written to the log for recovery. Ephemeral stores
+// are not recovered, so that pages are written only when evicted.
+func (s *Plasma) logSMOs() bool {
+	return s.shouldPersist && !s.Ephemeral
+}
+
 func (s *Plasma) isMergablePage(pid PageId, ctx *wCtx) bool {
 	n := pid.(*skiplist.Node)
 	if n == s.Skiplist.HeadNode() {
@@ -864,81 +1421,92 @@ func (s *Plasma) EndPageId() PageId {
 	return s.Skiplist.TailNode()
 }
 
-func (s *Plasma) trySMOs(pid PageId, pg Page, ctx *wCtx, doUpdate bool) bool {
-	var updated bool
+// Split the page at its middle item. If the page cannot be split, it is
+// compacted instead.
+func (s *Plasma) trySplit(pid PageId, pg Page, ctx *wCtx) (updated bool, split bool) {
+	splitPid := s.AllocPageId(ctx)
 
-	if pg.NeedCompaction(s.Config.MaxDeltaChainLen) {
+	var fdSz, splitFdSz, staleFdSz, numSegments, numSegmentsSplit int
+	var pgBuf = ctx.GetBuffer(bufEncPage)
+	var splitPgBuf = ctx.GetBuffer(bufEncMeta)
+	var pgBS, splitPgBS []byte
+
+	newPg := pg.Split(splitPid)
+	if newPg != nil {
+		newPg.SetPrev(pid)
+	}
+
+	// Skip split, but compact
+	if newPg == nil {
+		s.FreePageId(splitPid, ctx)
 		staleFdSz := pg.Compact()
 		if updated = s.UpdateMapping(pid, pg, ctx); updated {
-			ctx.sts.Compacts++
 			ctx.sts.FlushDataSz -= int64(staleFdSz)
-		} else {
-			ctx.sts.CompactConflicts++
 		}
-	} else if pg.NeedSplit(s.Config.MaxPageItems) {
-		splitPid := s.AllocPageId(ctx)
+		return updated, false
+	}
 
-		var fdSz, splitFdSz, staleFdSz, numSegments, numSegmentsSplit int
-		var pgBuf = ctx.GetBuffer(bufEncPage)
-		var splitPgBuf = ctx.GetBuffer(bufEncMeta)
-		var pgBS, splitPgBS []byte
+	var offsets []LSSOffset
+	var wbufs [][]byte
+	var res LSSResource
 
-		newPg := pg.Split(splitPid)
+	// Replace one page with two pages
+	if s.logSMOs() {
+		pgBS, fdSz, staleFdSz, numSegments = pg.Marshal(pgBuf, s.maxPageSegments(pg))
+		splitPgBS, splitFdSz, _, numSegmentsSplit = newPg.Marshal(splitPgBuf, 1)
 
-		// Skip split, but compact
-		if newPg == nil {
-			s.FreePageId(splitPid, ctx)
-			staleFdSz := pg.Compact()
-			if updated = s.UpdateMapping(pid, pg, ctx); updated {
-				ctx.sts.FlushDataSz -= int64(staleFdSz)
-			}
-			return updated
+		sizes := []int{
+			lssBlockTypeSize + len(pgBS),
+			lssBlockTypeSize + len(splitPgBS),
 		}
 
-		var offsets []LSSOffset
-		var wbufs [][]byte
-		var res LSSResource
+		offsets, wbufs, res = s.reserveOpSpace(opKindSplit, sizes)
 
-		// Replace one page with two pages
-		if s.shouldPersist {
-			pgBS, fdSz, staleFdSz, numSegments = pg.Marshal(pgBuf, s.Config.MaxPageLSSSegments)
-			splitPgBS, splitFdSz, _, numSegmentsSplit = newPg.Marshal(splitPgBuf, 1)
+		typ := pgFlushLSSType(pg, numSegments)
+		writeLSSBlock(wbufs[1], typ, pgBS)
+		pg.AddFlushRecord(offsets[1], fdSz, numSegments)
 
-			sizes := []int{
-				lssBlockTypeSize + len(pgBS),
-				lssBlockTypeSize + len(splitPgBS),
-			}
+		writeLSSBlock(wbufs[2], lssPageData, splitPgBS)
+		newPg.AddFlushRecord(offsets[2], splitFdSz, numSegmentsSplit)
+	}
 
-			offsets, wbufs, res = s.lss.ReserveSpaceMulti(sizes)
+	s.CreateMapping(splitPid, newPg, ctx)
+	if updated = s.UpdateMapping(pid, pg, ctx); updated {
+		s.indexPage(splitPid, ctx)
+		s.recordSplit(pid, splitPid)
+		ctx.sts.Splits++
 
-			typ := pgFlushLSSType(pg, numSegments)
-			writeLSSBlock(wbufs[0], typ, pgBS)
-			pg.AddFlushRecord(offsets[0], fdSz, numSegments)
+		if s.logSMOs() {
+			ctx.sts.FlushDataSz += int64(fdSz) + int64(splitFdSz) - int64(staleFdSz)
+			s.lss.FinalizeWrite(res)
+		}
+	} else {
+		ctx.sts.SplitConflicts++
+		s.FreePageId(splitPid, ctx)
 
-			writeLSSBlock(wbufs[1], lssPageData, splitPgBS)
-			newPg.AddFlushRecord(offsets[1], splitFdSz, numSegmentsSplit)
+		if s.logSMOs() {
+			discardOpBlocks(wbufs)
+			s.lss.FinalizeWrite(res)
 		}
+	}
 
-		s.CreateMapping(splitPid, newPg, ctx)
-		if updated = s.UpdateMapping(pid, pg, ctx); updated {
-			s.indexPage(splitPid, ctx)
-			ctx.sts.Splits++
+	return updated, updated
+}
 
-			if s.shouldPersist {
-				ctx.sts.FlushDataSz += int64(fdSz) + int64(splitFdSz) - int64(staleFdSz)
-				s.lss.FinalizeWrite(res)
-			}
-		} else {
-			ctx.sts.SplitConflicts++
-			s.FreePageId(splitPid, ctx)
+func (s *Plasma) trySMOs(pid PageId, pg Page, ctx *wCtx, doUpdate bool) bool {
+	var updated bool
 
-			if s.shouldPersist {
-				discardLSSBlock(wbufs[0])
-				discardLSSBlock(wbufs[1])
-				s.lss.FinalizeWrite(res)
-			}
+	if cfg := s.liveConfig(); pg.NeedCompaction(cfg.MaxDeltaChainLen) {
+		staleFdSz := pg.Compact()
+		if updated = s.UpdateMapping(pid, pg, ctx); updated {
+			ctx.sts.Compacts++
+			ctx.sts.FlushDataSz -= int64(staleFdSz)
+		} else {
+			ctx.sts.CompactConflicts++
 		}
-	} else if pg.NeedMerge(s.Config.MinPageItems) && s.isMergablePage(pid, ctx) {
+	} else if pg.NeedSplit(cfg.MaxPageItems) {
+		updated, _ = s.trySplit(pid, pg, ctx)
+	} else if s.shouldMerge(pid, pg, ctx) {
 		pg.Close()
 		if updated = s.UpdateMapping(pid, pg, ctx); updated {
 			s.tryPageRemoval(pid, pg, ctx)
@@ -946,8 +1514,12 @@ func (s *Plasma) trySMOs(pid PageId, pg Page, ctx *wCtx, doUpdate bool) bool {
 		} else {
 			ctx.sts.MergeConflicts++
 		}
-	} else if doUpdate {
-		updated = s.UpdateMapping(pid, pg, ctx)
+	} else {
+		if doUpdate {
+			updated = s.UpdateMapping(pid, pg, ctx)
+		}
+
+		s.tryQueueCompaction(pid, pg)
 	}
 
 	return updated
@@ -956,7 +1528,7 @@ func (s *Plasma) trySMOs(pid PageId, pg Page, ctx *wCtx, doUpdate bool) bool {
 func (s *Plasma) tryThrottleForMemory(ctx *wCtx) {
 	if s.hasMemoryPressure {
 		for s.TriggerSwapper(ctx.SwapperContext()) {
-			time.Sleep(swapperWaitInterval)
+			s.Clock.Sleep(swapperWaitInterval)
 		}
 	}
 }
@@ -992,6 +1564,18 @@ refresh:
 }
 
 func (w *Writer) Insert(itm unsafe.Pointer) error {
+	if err := w.throttleWrite(); err != nil {
+		return err
+	}
+
+	return w.insert(itm)
+}
+
+func (w *Writer) insert(itm unsafe.Pointer) error {
+	if err := w.stampFormatVersion(); err != nil {
+		return err
+	}
+
 retry:
 	pid, pg, err := w.fetchPage(itm, w.wCtx)
 	if err != nil {
@@ -1018,7 +1602,92 @@ retry:
 	return nil
 }
 
+// Insert an item and return the previously visible item with the same key
+// found while traversing the page. The returned item is valid only until
+// the next lookup using this writer.
+func (w *Writer) Upsert(itm unsafe.Pointer) (unsafe.Pointer, error) {
+	if err := w.throttleWrite(); err != nil {
+		return nil, err
+	}
+
+	return w.upsert(itm)
+}
+
+func (w *Writer) upsert(itm unsafe.Pointer) (unsafe.Pointer, error) {
+	if err := w.stampFormatVersion(); err != nil {
+		return nil, err
+	}
+
+retry:
+	pid, pg, err := w.fetchPage(itm, w.wCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	nr := w.sts.NumLSSReads
+	prev := pg.Lookup(itm)
+	pg.Insert(itm)
+
+	if !w.trySMOs(pid, pg, w.wCtx, true) {
+		w.sts.InsertConflicts++
+		goto retry
+	}
+
+	w.sts.BytesIncoming += int64(w.itemSize(itm))
+	w.sts.Inserts++
+	if w.sts.NumLSSReads-nr > 0 {
+		w.sts.CacheMisses++
+	} else {
+		w.sts.CacheHits++
+	}
+
+	w.trySMRObjects(w.wCtx, writerSMRBufferSize)
+	return prev, nil
+}
+
+// Applies the delete item of a key and its new item in a single page
+// update, so that readers do not see the key missing in between. The
+// previously visible item is returned as by Upsert.
+func (w *Writer) replace(delItm, itm unsafe.Pointer) (unsafe.Pointer, error) {
+	if err := w.stampFormatVersion(); err != nil {
+		return nil, err
+	}
+
+retry:
+	pid, pg, err := w.fetchPage(itm, w.wCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	nr := w.sts.NumLSSReads
+	prev := pg.Lookup(itm)
+	pg.Insert(delItm)
+	pg.Insert(itm)
+
+	if !w.trySMOs(pid, pg, w.wCtx, true) {
+		w.sts.InsertConflicts++
+		goto retry
+	}
+
+	w.sts.BytesIncoming += int64(w.itemSize(delItm) + w.itemSize(itm))
+	w.sts.Inserts += 2
+	if w.sts.NumLSSReads-nr > 0 {
+		w.sts.CacheMisses++
+	} else {
+		w.sts.CacheHits++
+	}
+
+	w.trySMRObjects(w.wCtx, writerSMRBufferSize)
+	return prev, nil
+}
+
+// Deletes are not throttled, as they let the store catch up on its
+// backlog
 func (w *Writer) Delete(itm unsafe.Pointer) error {
+	if err := w.stampFormatVersion(); err != nil {
+		return err
+	}
+
 retry:
 	pid, pg, err := w.fetchPage(itm, w.wCtx)
 	if err != nil {
@@ -1052,6 +1721,9 @@ func (w *Writer) Lookup(itm unsafe.Pointer) (unsafe.Pointer, error) {
 
 	nr := w.sts.NumLSSReads
 	ret := pg.Lookup(itm)
+	if ret != nil {
+		w.sts.BytesOutgoing += int64(w.itemSize(ret))
+	}
 	w.trySMOs(pid, pg, w.wCtx, false)
 	if w.sts.NumLSSReads-nr > 0 {
 		w.sts.CacheMisses++
@@ -1067,6 +1739,12 @@ func (s *Plasma) fetchPageFromLSS(baseOffset LSSOffset, ctx *wCtx) (*page, error
 }
 
 func (s *Plasma) fetchPageFromLSS2(baseOffset LSSOffset, ctx *wCtx,
+	aCtx *allocCtx, sCtx *storeCtx) (*page, error) {
+	return s.fetchPageFromLSS3(baseOffset, false, ctx, aCtx, sCtx)
+}
+
+// The base page is not fetched if deltasOnly is set
+func (s *Plasma) fetchPageFromLSS3(baseOffset LSSOffset, deltasOnly bool, ctx *wCtx,
 	aCtx *allocCtx, sCtx *storeCtx) (*page, error) {
 	pg := newPage2(nil, nil, ctx, sCtx, aCtx).(*page)
 	offset := baseOffset
@@ -1088,7 +1766,13 @@ loop:
 		case lssPageData, lssPageReloc, lssPageUpdate:
 			currPgDelta := newPage2(nil, nil, ctx, sCtx, aCtx).(*page)
 			data := data[lssBlockTypeSize:l]
-			nextOffset, hasChain := currPgDelta.unmarshalDelta(data, ctx)
+			nextOffset, hasChain, err := currPgDelta.unmarshalDelta2(data, ctx, deltasOnly)
+			if err != nil {
+				currPgDelta.free(false)
+				pg.free(false)
+				return nil, err
+			}
+
 			currPgDelta.AddFlushRecord(offset, len(data), 1)
 			pg.Append(currPgDelta)
 			offset = nextOffset
@@ -1098,7 +1782,8 @@ loop:
 				break loop
 			}
 		default:
-			panic(fmt.Sprintf("Invalid page data type %d", typ))
+			pg.free(false)
+			return nil, ErrCorruptLSSBlock
 		}
 	}
 
@@ -1153,11 +1838,11 @@ func MemoryInUse2(ctx SwapperContext) (sz int64) {
 func (s *Plasma) tryPageSwapin(pg Page) bool {
 	var ok bool
 	pgi := pg.(*page)
-	if pgi.head != nil && pgi.head.state.IsEvicted() {
+	if pgi.head != nil && (pgi.head.state.IsEvicted() || pgi.head.op == opDeltaSwapoutDelta) {
 		pw := newPgDeltaWalker(pgi.head, pgi.ctx)
 		// Force the pagewalker to read the swapout delta
 		for ; !pw.End(); pw.Next() {
-			if pw.Op() == opSwapoutDelta {
+			if op := pw.Op(); op == opSwapoutDelta || op == opDeltaSwapoutDelta {
 				pw.Next()
 				break
 			}
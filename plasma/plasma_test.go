@@ -1,11 +1,14 @@
 package plasma
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/couchbase/nitro/skiplist"
 	"os"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -17,6 +20,7 @@ var testCfg = Config{
 	MaxPageItems:     400,
 	MinPageItems:     25,
 	Compare:          skiplist.CompareInt,
+	CompareId:        "skiplist.CompareInt/1",
 	ItemSize: func(x unsafe.Pointer) uintptr {
 		if x == skiplist.MinItem || x == skiplist.MaxItem {
 			return 0
@@ -393,6 +397,91 @@ func TestPlasmaRecovery(t *testing.T) {
 	s.Close()
 }
 
+func TestPlasmaLargeItems(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := DefaultConfig()
+	cfg.File = "teststore.data"
+	cfg.MaxPageItems = 20
+	cfg.MinPageItems = 5
+	s := newTestIntPlasmaStore(cfg)
+
+	n := 200
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%010d-%s", i, strings.Repeat("k", 8192)))
+	}
+
+	w := s.NewWriter()
+	for i := 0; i < n; i++ {
+		w.InsertKV(key(i), []byte(strings.Repeat("v", 4096)))
+	}
+
+	for i := 0; i < 20; i++ {
+		h := s.acquireClockHandle()
+		s.sweepClock(h)
+		s.releaseClockHandle(h)
+	}
+
+	s.EvictAll()
+	s.PersistAll()
+	s.Close()
+
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w = s.NewWriter()
+	for i := 0; i < n; i++ {
+		if v, err := w.LookupKV(key(i)); err != nil || len(v) != 4096 {
+			t.Errorf("expected value for key %d, got %d bytes (err=%v)", i, len(v), err)
+		}
+	}
+}
+
+func TestPlasmaAmplificationStats(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := DefaultConfig()
+	cfg.File = "teststore.data"
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	n := 100000
+	w := s.NewWriter()
+	for i := 0; i < n; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+	}
+
+	s.EvictAll()
+	sts := s.GetStats()
+	if sts.BytesWritten < sts.BytesIncoming || sts.WriteAmpAvg < 1 {
+		t.Errorf("expected write amplification, got %d written for %d incoming (%.2f)",
+			sts.BytesWritten, sts.BytesIncoming, sts.WriteAmpAvg)
+	}
+
+	for i := 0; i < n; i++ {
+		if _, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i))); err != nil {
+			t.Errorf("lookup failed for %d (err=%v)", i, err)
+		}
+	}
+
+	sts = s.GetStats()
+	if sts.BytesOutgoing == 0 || sts.ReadAmpAvg <= 0 {
+		t.Errorf("expected read amplification, got %d read for %d outgoing (%.2f)",
+			sts.LSSReadBytes, sts.BytesOutgoing, sts.ReadAmpAvg)
+	}
+
+	outgoing := sts.BytesOutgoing
+	itr := s.NewIterator()
+	count := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		count++
+	}
+
+	sts = s.GetStats()
+	if count != n || sts.BytesOutgoing != 2*outgoing {
+		t.Errorf("expected %d outgoing bytes for %d items, got %d for %d items",
+			2*outgoing, n, sts.BytesOutgoing, count)
+	}
+}
+
 func TestPlasmaLSSCleaner(t *testing.T) {
 	os.RemoveAll("teststore.data")
 	cfg := testCfg
@@ -450,6 +539,151 @@ func TestPlasmaLSSCleaner(t *testing.T) {
 	<-s.stoplssgc
 }
 
+func TestPlasmaDefragment(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testCfg
+	cfg.LSSLogSegmentSize = 1024 * 1024
+	s := newTestIntPlasmaStore(cfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 1000000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	s.PersistAll()
+
+	for i := 0; i < 950000; i++ {
+		w.Delete(skiplist.NewIntKeyItem(i))
+	}
+	s.PersistAll()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Defragment(ctx); err != context.Canceled {
+		t.Errorf("expected defragment to be canceled, got %v", err)
+	}
+
+	_, _, used0 := s.GetLSSInfo()
+	if err := s.Defragment(context.Background()); err != nil {
+		t.Fatalf("defragment failed %v", err)
+	}
+
+	frag, ds, used := s.GetLSSInfo()
+	if used > used0/4 || frag > 50 {
+		t.Errorf("expected the log to shrink from %d, got %d (data %d, frag %d%%)", used0, used, ds, frag)
+	}
+	s.Close()
+
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+	verifyCheckpointItems(t, s, 950000, 1000000)
+
+	cfg.File = ""
+	cfg.InMemoryLSS = false
+	mem := newTestIntPlasmaStore(cfg)
+	defer mem.Close()
+	if err := mem.Defragment(context.Background()); err != ErrDefragmentNotSupported {
+		t.Errorf("expected defragment not supported error, got %v", err)
+	}
+}
+
+func TestPlasmaTrimLSS(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.FlushBufferSize = 64 * 1024
+	cfg.LSSLogSegmentSize = 64 * 1024
+	cfg.AutoLSSCleaning = false
+
+	var mu sync.Mutex
+	var archived []LogSegment
+	cfg.ArchiveLogSegment = func(seg LogSegment) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := os.Stat(seg.Path); err != nil {
+			return err
+		}
+
+		if len(archived) == 2 {
+			return errors.New("archive unavailable")
+		}
+
+		archived = append(archived, seg)
+		return nil
+	}
+	s := newTestIntPlasmaStore(cfg)
+
+	w := s.NewWriter()
+	// Replace the items of the previous update
+	update := func(v string) {
+		for i := 0; i < 100000; i++ {
+			w.InsertKV([]byte(fmt.Sprintf("%s-%10d", v, i)), []byte(v))
+			if v != "a" {
+				w.DeleteKV([]byte(fmt.Sprintf("%c-%10d", v[0]-1, i)))
+			}
+		}
+		s.NewSnapshot().Close()
+		s.PersistAll()
+	}
+
+	update("a")
+	snap := s.NewSnapshot()
+	snap.Open()
+	s.CreateRecoveryPoint(snap, nil)
+	snap.Close()
+	rp := s.GetRecoveryPoints()[0]
+
+	update("b")
+	update("c")
+	tail := s.lss.TailOffset()
+
+	head, err := s.TrimLSS(tail)
+	if err != nil || head > rp.LogOffset() {
+		t.Fatalf("expected trimming to stop at the recovery point %d, got %d %v", rp.LogOffset(), head, err)
+	}
+
+	s.RemoveRecoveryPoint(rp)
+	if head, err = s.TrimLSS(tail); err != nil || head < tail {
+		t.Fatalf("expected the log to be trimmed to %d, got %d %v", tail, head, err)
+	}
+
+	// Wait for the commit of the trimmed log
+	s.lss.Sync(true)
+	mu.Lock()
+	if len(archived) != 2 {
+		t.Fatalf("expected 2 archived segments, got %d (head %d, tail %d, used %d)", len(archived), head, tail, s.lss.UsedSpace())
+	}
+
+	if archived[0].EndOffset != archived[1].StartOffset {
+		t.Errorf("expected consecutive segments, got %+v", archived)
+	}
+
+	// Segments which could not be archived are retained
+	if _, err := os.Stat(archived[1].Path); err == nil {
+		t.Errorf("expected archived segment %s to be deleted", archived[1].Path)
+	}
+
+	if s.lss.UsedSpace() < cfg.LSSLogSegmentSize {
+		t.Errorf("expected segments which failed to archive to be retained")
+	}
+	mu.Unlock()
+	s.Close()
+
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+	w = s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		if v, err := w.LookupKV([]byte(fmt.Sprintf("c-%10d", i))); err != nil || string(v) != "c" {
+			t.Fatalf("expected c, got %s %v", v, err)
+		}
+	}
+
+	cfg.File = ""
+	mem := newTestIntPlasmaStore(cfg)
+	defer mem.Close()
+	if _, err := mem.TrimLSS(0); err != ErrTrimNotSupported {
+		t.Errorf("expected trim not supported error, got %v", err)
+	}
+}
+
 func TestPlasmaCleanerPerf(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -581,6 +815,87 @@ func TestPlasmaEviction(t *testing.T) {
 
 }
 
+func TestPlasmaPartialEviction(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+
+	n := 100000
+	w := s.NewWriter()
+	for i := 0; i < n; i += 2 {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+
+	w.CompactAll()
+	s.PersistAll()
+
+	for i := 1; i < n; i += 2 {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+
+	for i := 0; i < n; i += 10 {
+		w.Delete(skiplist.NewIntKeyItem(i))
+	}
+
+	verify := func(w *Writer, msg string) {
+		for i := 0; i < n; i++ {
+			itm := skiplist.NewIntKeyItem(i)
+			got, _ := w.Lookup(itm)
+			if i%10 == 0 {
+				if got != nil {
+					t.Fatalf("%s: expected %d to be deleted", msg, i)
+				}
+			} else if got == nil || skiplist.CompareInt(itm, got) != 0 {
+				t.Fatalf("%s: lookup failed for %d", msg, i)
+			}
+		}
+
+		count := 0
+		itr := w.NewIterator()
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			count++
+		}
+
+		if exp := n - n/10; count != exp {
+			t.Fatalf("%s: expected %d items, got %d", msg, exp, count)
+		}
+	}
+
+	mem := s.GetStats().MemSz
+	s.EvictAllDeltas()
+	if after := s.GetStats().MemSz; after >= mem {
+		t.Errorf("expected memory usage to drop, before %d after %d", mem, after)
+	}
+
+	var partial int
+	s.PageVisitor(func(pid PageId, partn RangePartition) error {
+		pg, _ := s.ReadPage(pid, nil, false, w.wCtx)
+		if pg.(*page).head.op == opDeltaSwapoutDelta {
+			partial++
+		}
+		return nil
+	}, 1)
+
+	if partial == 0 {
+		t.Errorf("expected pages with evicted deltas")
+	}
+
+	verify(w, "partial eviction")
+
+	s.EvictAllDeltas()
+	s.CleanLSS(func() bool { return true })
+	verify(w, "lss cleaning")
+
+	s.EvictAllDeltas()
+	s.EvictAll()
+	verify(w, "eviction")
+	s.PersistAll()
+	s.Close()
+
+	s = newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+	verify(s.NewWriter(), "recovery")
+}
+
 func TestPlasmaEvictPerf(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -769,3 +1084,37 @@ func TestConcurrDelOps(t *testing.T) {
 
 	fmt.Println(s.GetStats())
 }
+
+func TestPlasmaWriterPool(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				s.Execute(func(w *Writer) {
+					w.Insert(skiplist.NewIntKeyItem(id*10000 + j))
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(s.wlist); n > 8 {
+		t.Errorf("expected at most 8 writers, got %d", n)
+	}
+
+	s.Execute(func(w *Writer) {
+		for i := 0; i < 80000; i++ {
+			itm := skiplist.NewIntKeyItem(i)
+			got, _ := w.Lookup(itm)
+			if skiplist.CompareInt(itm, got) != 0 {
+				t.Errorf("mismatch %d != %d", i, skiplist.IntFromItem(got))
+			}
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package plasma
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/couchbase/nitro/skiplist"
+	"sync"
+	"unsafe"
+)
+
+// An iterator which moves across pages sequentially and misses the cache
+// queues requests to swap in the pages ahead of its cursor. The prefetcher
+// threads walk the right siblings of the page owning the key of a request
+// and swap in the evicted ones.
+
+// Number of consecutive pages visited by an iterator before its access is
+// considered sequential
+const prefetchSeqPages = 2
+
+var errPrefetchStopped = errors.New("prefetch stopped under memory pressure")
+
+type prefetchReq struct {
+	key  unsafe.Pointer
+	skip int
+	n    int
+}
+
+type prefetchQueue struct {
+	reqs chan prefetchReq
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Queue the swapin of n pages following the skip pages after pid. The
+// request is dropped if the queue is full.
+func (s *Plasma) queuePrefetch(pid PageId, skip, n int) bool {
+	q := s.prefetchQ
+	if q == nil {
+		return false
+	}
+
+	req := prefetchReq{key: s.copyKey(pid.(*skiplist.Node).Item()), skip: skip, n: n}
+	select {
+	case q.reqs <- req:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Plasma) prefetchPages(req prefetchReq, ctx *wCtx) {
+	tok := ctx.BeginTx()
+	defer ctx.EndTx(tok)
+
+	_, pg, err := s.fetchPageForKey(req.key, ctx)
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < req.skip+req.n; i++ {
+		pid := pg.Next()
+		if pid == s.EndPageId() || s.hasMemoryPressure {
+			return
+		}
+
+		swapin := i >= req.skip
+		if pg, err = s.ReadPage(pid, nil, false, ctx); err != nil {
+			return
+		}
+
+		if swapin && s.tryPageSwapin(pg) {
+			if s.UpdateMapping(pid, pg, ctx) {
+				ctx.sts.PrefetchedPages++
+			} else if pg, err = s.ReadPage(pid, nil, false, ctx); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Plasma) prefetcher(ctx *wCtx, ws *workerState) {
+	q := s.prefetchQ
+
+	for {
+		ws.beat()
+		ws.idle()
+		select {
+		case <-q.stop:
+			s.trySMRObjects(ctx, 0)
+			return
+		case req := <-q.reqs:
+			ws.beat()
+			ws.doing("prefetching")
+			s.prefetchPages(req, ctx)
+			s.trySMRObjects(ctx, swapperSMRInterval)
+		}
+	}
+}
+
+func (s *Plasma) startPrefetchers() {
+	if !s.shouldPersist || s.NumPrefetchThreads <= 0 {
+		return
+	}
+
+	s.prefetchQ = &prefetchQueue{
+		reqs: make(chan prefetchReq, s.MaxPrefetchQueueSize),
+		stop: make(chan struct{}),
+	}
+
+	for i := 0; i < s.NumPrefetchThreads; i++ {
+		s.prefetchQ.wg.Add(1)
+		go func(name string, ctx *wCtx) {
+			defer s.prefetchQ.wg.Done()
+			s.superviseWorker(name, func(ws *workerState) {
+				s.prefetcher(ctx, ws)
+			})
+		}(fmt.Sprintf("prefetcher-%d", i), s.newWCtx())
+	}
+}
+
+func (s *Plasma) stopPrefetchers() {
+	if q := s.prefetchQ; q != nil {
+		close(q.stop)
+		q.wg.Wait()
+	}
+}
+
+// Warmup of the pages of a key range started by Snapshot.Prefetch
+type RangePrefetch struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	pages  int64
+	err    error
+}
+
+// Closed once the range has been prefetched
+func (p *RangePrefetch) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait for the prefetch to complete and return the number of pages
+// swapped in by it
+func (p *RangePrefetch) Wait() (int64, error) {
+	<-p.done
+	return p.pages, p.err
+}
+
+// Stop the prefetch at the next page and wait for it
+func (p *RangePrefetch) Cancel() {
+	p.cancel()
+	<-p.done
+}
+
+// Swap in the pages of the key range [lo, hi) in the background, to warm
+// up the cache before a scan. A nil bound leaves that end of the range
+// unbounded. The pages are marked as accessed, so that the swapper skips
+// them on its next pass. The snapshot is held open until the prefetch
+// completes, which stops early under memory pressure.
+func (sn *Snapshot) Prefetch(lo, hi []byte) *RangePrefetch {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &RangePrefetch{done: make(chan struct{}), cancel: cancel}
+
+	sn.Open()
+	go func() {
+		defer close(p.done)
+		defer sn.Close()
+		defer cancel()
+		p.pages, p.err = sn.db.prefetchRange(ctx, lo, hi)
+	}()
+
+	return p
+}
+
+func (s *Plasma) prefetchRange(ctx context.Context, lo, hi []byte) (int64, error) {
+	loItm, hiItm, err := s.keyBoundItems(lo, hi)
+	if err != nil || hiItm == nil {
+		return 0, err
+	}
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	tok := w.BeginTx()
+	defer w.EndTx(tok)
+
+	var pages int64
+	callb := func(pid PageId, partn RangePartition) error {
+		if s.hasMemoryPressure {
+			return errPrefetchStopped
+		}
+
+		pg, err := s.ReadPage(pid, nil, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		if s.tryPageSwapin(pg) && s.UpdateMapping(pid, pg, w.wCtx) {
+			w.sts.PrefetchedPages++
+			pages++
+		}
+
+		s.updateCacheMeta(pid)
+		return nil
+	}
+
+	opts := PageVisitorOptions{Ordered: true, MinKey: loItm, MaxKey: hiItm}
+	if err = s.PageVisitorWithOptions(ctx, callb, opts); err == errPrefetchStopped {
+		err = nil
+	}
+
+	return pages, err
+}
@@ -0,0 +1,102 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPlasmaScanPrefetch(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.NumPrefetchThreads = 2
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	n := 100000
+	for i := 0; i < n; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", i)))
+	}
+
+	scan := func(prefetch int) {
+		s.PersistAll()
+		s.EvictAll()
+
+		snap := s.NewSnapshot()
+		defer snap.Close()
+		itr := snap.NewIterator()
+		defer itr.Close()
+		itr.SetPrefetch(prefetch)
+
+		i := 0
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			if exp := fmt.Sprintf("key-%10d", i); string(itr.Key()) != exp {
+				t.Fatalf("expected %s, got %s", exp, itr.Key())
+			}
+			i++
+		}
+
+		if i != n {
+			t.Errorf("expected %d items, got %d", n, i)
+		}
+	}
+
+	scan(0)
+	if sts := s.GetStats(); sts.PrefetchedPages != 0 {
+		t.Errorf("expected no prefetched pages, got %d", sts.PrefetchedPages)
+	}
+
+	scan(32)
+	if sts := s.GetStats(); sts.PrefetchedPages == 0 {
+		t.Errorf("expected pages to be prefetched")
+	}
+}
+
+func TestPlasmaSnapshotPrefetch(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%10d", i))
+	}
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.InsertKV(key(i), []byte(fmt.Sprintf("val-%d", i)))
+	}
+
+	s.PersistAll()
+	s.EvictAll()
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+	p := snap.Prefetch(key(25000), key(75000))
+
+	<-p.Done()
+	pages, err := p.Wait()
+	if err != nil || pages == 0 {
+		t.Fatalf("expected pages to be prefetched, got %d (err=%v)", pages, err)
+	}
+
+	reads := s.GetStats().NumLSSReads
+	for i := 25000; i < 75000; i += 100 {
+		if _, err := w.LookupKV(key(i)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	if n := s.GetStats().NumLSSReads - reads; n != 0 {
+		t.Errorf("expected no reads from the log for the prefetched range, got %d", n)
+	}
+
+	w.LookupKV(key(90000))
+	if s.GetStats().NumLSSReads == reads {
+		t.Errorf("expected pages outside the range to remain evicted")
+	}
+
+	if pages, err := snap.Prefetch(key(10), key(5)).Wait(); pages != 0 || err != nil {
+		t.Errorf("expected an empty range to prefetch nothing, got %d (err=%v)", pages, err)
+	}
+}
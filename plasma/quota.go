@@ -0,0 +1,172 @@
+package plasma
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const quotaRebalanceInterval = time.Millisecond * 10
+
+// QuotaManager distributes a memory budget among the instances registered
+// with it. Instances are registered by setting Config.QuotaManager, which
+// replaces the Config.TriggerSwapper of the instance. When the memory used
+// by all the instances exceeds the quota, only the heaviest instances which
+// exceed their share of the quota evict pages.
+type QuotaManager struct {
+	sync.Mutex
+	quota     int64
+	instances map[*Plasma]*quotaInstance
+
+	lastRebalance int64
+}
+
+type quotaInstance struct {
+	memInUse int64
+	target   int64
+	evict    int32
+}
+
+// Memory residency of an instance managed by a QuotaManager
+type InstanceResidency struct {
+	File          string
+	MemoryInUse   int64
+	TargetMemory  int64
+	ResidentRatio float64
+	Evicting      bool
+}
+
+func NewQuotaManager(quota int64) *QuotaManager {
+	return &QuotaManager{
+		quota:     quota,
+		instances: make(map[*Plasma]*quotaInstance),
+	}
+}
+
+func (m *QuotaManager) SetQuota(quota int64) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.quota = quota
+	m.rebalance()
+}
+
+func (m *QuotaManager) Quota() int64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.quota
+}
+
+func (m *QuotaManager) register(s *Plasma) {
+	m.Lock()
+	defer m.Unlock()
+
+	qi := &quotaInstance{}
+	m.instances[s] = qi
+	s.quotaInst = qi
+	m.rebalance()
+}
+
+func (m *QuotaManager) unregister(s *Plasma) {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.instances, s)
+	m.rebalance()
+}
+
+// Compute the memory used by each instance and select the instances which
+// should evict. The quota is split such that the instances using less than
+// an equal share keep their memory and the heavier instances are trimmed
+// down to the same level. Caller holds the lock.
+func (m *QuotaManager) rebalance() {
+	var total int64
+
+	insts := make([]*quotaInstance, 0, len(m.instances))
+	for s, qi := range m.instances {
+		qi.memInUse = s.MemoryInUse()
+		total += qi.memInUse
+		insts = append(insts, qi)
+	}
+
+	sort.Slice(insts, func(i, j int) bool {
+		return insts[i].memInUse < insts[j].memInUse
+	})
+
+	remaining := m.quota
+	for i, qi := range insts {
+		qi.target = qi.memInUse
+		if total > m.quota {
+			if share := remaining / int64(len(insts)-i); qi.memInUse > share {
+				qi.target = share
+			}
+			remaining -= qi.target
+		}
+
+		var evict int32
+		if qi.memInUse > qi.target {
+			evict = 1
+		}
+		atomic.StoreInt32(&qi.evict, evict)
+	}
+
+	atomic.StoreInt64(&m.lastRebalance, time.Now().UnixNano())
+}
+
+func (m *QuotaManager) shouldEvict(s *Plasma) bool {
+	if time.Now().UnixNano()-atomic.LoadInt64(&m.lastRebalance) >= int64(quotaRebalanceInterval) {
+		m.Lock()
+		if time.Now().UnixNano()-atomic.LoadInt64(&m.lastRebalance) >= int64(quotaRebalanceInterval) {
+			m.rebalance()
+		}
+		m.Unlock()
+	}
+
+	qi := s.quotaInst
+	return qi != nil && atomic.LoadInt32(&qi.evict) == 1
+}
+
+func (m *QuotaManager) swapperFor(s *Plasma) func(SwapperContext) bool {
+	return func(SwapperContext) bool {
+		return m.shouldEvict(s)
+	}
+}
+
+// Total memory used by the registered instances
+func (m *QuotaManager) MemoryInUse() int64 {
+	var total int64
+	for _, r := range m.Residency() {
+		total += r.MemoryInUse
+	}
+
+	return total
+}
+
+// Residency of the registered instances, heaviest first
+func (m *QuotaManager) Residency() []InstanceResidency {
+	m.Lock()
+	m.rebalance()
+	var insts []*Plasma
+	var rs []InstanceResidency
+	for s, qi := range m.instances {
+		insts = append(insts, s)
+		rs = append(rs, InstanceResidency{
+			File:         s.File,
+			MemoryInUse:  qi.memInUse,
+			TargetMemory: qi.target,
+			Evicting:     atomic.LoadInt32(&qi.evict) == 1,
+		})
+	}
+	m.Unlock()
+
+	for i, s := range insts {
+		rs[i].ResidentRatio = s.GetStats().ResidentRatio
+	}
+
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].MemoryInUse > rs[j].MemoryInUse
+	})
+
+	return rs
+}
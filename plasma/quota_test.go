@@ -0,0 +1,57 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestQuotaManager(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	os.RemoveAll("teststore2.data")
+	defer os.RemoveAll("teststore2.data")
+
+	m := NewQuotaManager(maxMemoryQuota)
+	cfg := testSnCfg
+	cfg.QuotaManager = m
+	s1 := newTestIntPlasmaStore(cfg)
+	defer s1.Close()
+
+	cfg.File = "teststore2.data"
+	s2 := newTestIntPlasmaStore(cfg)
+	defer s2.Close()
+
+	w1, w2 := s1.NewWriter(), s2.NewWriter()
+	for i := 0; i < 50000; i++ {
+		w1.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+		if i%50 == 0 {
+			w2.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+		}
+	}
+
+	if rs := m.Residency(); len(rs) != 2 || rs[0].File != s1.File || rs[0].Evicting {
+		t.Fatalf("unexpected residency %+v", rs)
+	}
+
+	before := m.MemoryInUse()
+	mem2 := s2.MemoryInUse()
+	m.SetQuota(before / 2)
+
+	rs := m.Residency()
+	if !rs[0].Evicting || rs[1].Evicting || rs[0].TargetMemory >= rs[0].MemoryInUse {
+		t.Errorf("expected only the heaviest instance to evict %+v", rs)
+	}
+
+	for i := 0; i < 100 && m.MemoryInUse() > before/2; i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	if mem := m.MemoryInUse(); mem > before/2 {
+		t.Errorf("expected memory usage below %d, got %d", before/2, mem)
+	}
+
+	if s2.MemoryInUse() < mem2 {
+		t.Errorf("expected no eviction from the lighter instance")
+	}
+}
@@ -0,0 +1,127 @@
+package plasma
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// ConfigDelta describes a live configuration change. Only the fields
+// which are set are updated.
+type ConfigDelta struct {
+	MaxDeltaChainLen    *int
+	MaxPageItems        *int
+	MinPageItems        *int
+	MergeHysteresis     *int
+	MaxPageLSSSegments  *int
+	LSSCleanerThreshold *int
+	NumPersistorThreads *int
+	NumEvictorThreads   *int
+	MaxSnSyncFrequency  *int
+	// Period of the background log commits. Commits cannot be switched
+	// between background and synchronous on an open store.
+	SyncPeriod *time.Duration
+}
+
+func (d ConfigDelta) apply(cfg Config) Config {
+	setInt := func(dst *int, src *int) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+
+	setInt(&cfg.MaxDeltaChainLen, d.MaxDeltaChainLen)
+	setInt(&cfg.MaxPageItems, d.MaxPageItems)
+	setInt(&cfg.MinPageItems, d.MinPageItems)
+	setInt(&cfg.MergeHysteresis, d.MergeHysteresis)
+	setInt(&cfg.MaxPageLSSSegments, d.MaxPageLSSSegments)
+	setInt(&cfg.LSSCleanerThreshold, d.LSSCleanerThreshold)
+	setInt(&cfg.NumPersistorThreads, d.NumPersistorThreads)
+	setInt(&cfg.NumEvictorThreads, d.NumEvictorThreads)
+	setInt(&cfg.MaxSnSyncFrequency, d.MaxSnSyncFrequency)
+	if d.SyncPeriod != nil {
+		cfg.SyncPeriod = *d.SyncPeriod
+	}
+	return cfg
+}
+
+// UpdateConfig applies the changes if the resulting config is valid.
+// Either all or none of the changes are applied. Background workers pick
+// up thread count changes within a second. Config keeps the settings the
+// store was opened with, LiveConfig returns the current ones.
+func (s *Plasma) UpdateConfig(d ConfigDelta) error {
+	s.cfgLock.Lock()
+	defer s.cfgLock.Unlock()
+
+	curr := s.liveConfig()
+	cfg := d.apply(*curr)
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if (cfg.SyncPeriod == 0) != (curr.SyncPeriod == 0) {
+		return fmt.Errorf("Invalid config: SyncPeriod (%v) cannot enable or disable background commits", cfg.SyncPeriod)
+	}
+
+	if s.shouldPersist {
+		s.growWorkerCtxs(cfg.NumPersistorThreads)
+		s.growWorkerCtxs(cfg.NumEvictorThreads)
+
+		if ls, ok := s.lss.(*lsStore); ok && cfg.SyncPeriod > 0 && !s.Ephemeral {
+			ls.setCommitDuration(cfg.SyncPeriod)
+		}
+	}
+
+	// Readers load the config without locking, hence it is replaced as a
+	// whole instead of being updated in place
+	atomic.StorePointer(&s.liveCfg, unsafe.Pointer(&cfg))
+	atomic.AddInt64(&s.configUpdates, 1)
+
+	if cfg.SyncPeriod != curr.SyncPeriod {
+		select {
+		case s.syncPeriodUpdated <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *Plasma) liveConfig() *Config {
+	return (*Config)(atomic.LoadPointer(&s.liveCfg))
+}
+
+// LiveConfig returns the config with the changes made by UpdateConfig
+func (s *Plasma) LiveConfig() Config {
+	return *s.liveConfig()
+}
+
+// Ensure that persistor and evictor contexts are available for n threads
+func (s *Plasma) growWorkerCtxs(n int) {
+	s.Lock()
+	defer s.Unlock()
+
+	persistWriters := s.persistWriters
+	evictWriters := s.evictWriters
+	for len(persistWriters) < n {
+		persistWriters = append(persistWriters, s.newWCtx())
+		evictWriters = append(evictWriters, s.newWCtx())
+	}
+
+	s.persistWriters = persistWriters
+	s.evictWriters = evictWriters
+}
+
+func (s *Plasma) getWorkerCtxs() (persistWriters, evictWriters []*wCtx) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.persistWriters, s.evictWriters
+}
+
+func (s *Plasma) numPersistorThreads() int {
+	return s.liveConfig().NumPersistorThreads
+}
+
+func (s *Plasma) numEvictorThreads() int {
+	return s.liveConfig().NumEvictorThreads
+}
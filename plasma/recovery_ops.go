@@ -0,0 +1,158 @@
+package plasma
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+)
+
+// Structural operations which write more than one page image, such as
+// splits and merges, are preceded in the log by a marker carrying an
+// operation id and the number of blocks of the operation. Recovery applies
+// the blocks of an operation id only once and verifies that every
+// operation is replayed in full.
+
+type opKind uint16
+
+const (
+	opKindSplit opKind = iota + 1
+	opKindMerge
+)
+
+func (k opKind) String() string {
+	switch k {
+	case opKindSplit:
+		return "split"
+	case opKindMerge:
+		return "merge"
+	}
+
+	return "unknown"
+}
+
+const opMarkerSize = 12
+
+// Operations may reach the log out of the order of their ids by up to the
+// number of concurrent writers. Ids older than the window are forgotten.
+const opReplayWindow = 1 << 16
+
+func (s *Plasma) nextOpId() uint64 {
+	return atomic.AddUint64(&s.lastOpId, 1)
+}
+
+func marshalOpMarker(id uint64, kind opKind, numBlocks int) []byte {
+	var bs [opMarkerSize]byte
+	binary.BigEndian.PutUint64(bs[:8], id)
+	binary.BigEndian.PutUint16(bs[8:10], uint16(kind))
+	binary.BigEndian.PutUint16(bs[10:12], uint16(numBlocks))
+	return bs[:]
+}
+
+func unmarshalOpMarker(bs []byte) (id uint64, kind opKind, numBlocks int, err error) {
+	if len(bs) < opMarkerSize {
+		return 0, 0, 0, ErrCorruptLSSBlock
+	}
+
+	id = binary.BigEndian.Uint64(bs[:8])
+	kind = opKind(binary.BigEndian.Uint16(bs[8:10]))
+	numBlocks = int(binary.BigEndian.Uint16(bs[10:12]))
+	return
+}
+
+// Reserve space for the blocks of an operation preceded by its marker,
+// which is written into the first buffer
+func (s *Plasma) reserveOpSpace(kind opKind, sizes []int) ([]LSSOffset, [][]byte, LSSResource) {
+	marker := marshalOpMarker(s.nextOpId(), kind, len(sizes))
+	sizes = append([]int{lssBlockTypeSize + len(marker)}, sizes...)
+	offsets, wbufs, res := s.lss.ReserveSpaceMulti(sizes)
+	writeLSSBlock(wbufs[0], lssOpMarker, marker)
+	return offsets, wbufs, res
+}
+
+// Discard the marker and the blocks of an operation which was not applied
+func discardOpBlocks(wbufs [][]byte) {
+	for _, wbuf := range wbufs {
+		discardLSSBlock(wbuf)
+	}
+}
+
+// Tracks the operations seen while replaying the log
+type opReplayState struct {
+	seen    map[uint64]struct{}
+	maxId   uint64
+	curr    uint64
+	kind    opKind
+	pending int
+	skip    int
+
+	replayed   int64
+	skipped    int64
+	incomplete int64
+}
+
+func newOpReplayState() *opReplayState {
+	return &opReplayState{seen: make(map[uint64]struct{})}
+}
+
+func (r *opReplayState) checkComplete() {
+	if r.pending > 0 {
+		r.incomplete++
+		r.pending = 0
+	}
+}
+
+// Returns the number of operations replayed, skipped as duplicates and
+// found with missing blocks
+func (r *opReplayState) finish() (replayed, skipped, incomplete int64) {
+	r.checkComplete()
+	return r.replayed, r.skipped, r.incomplete
+}
+
+// Returns true if the block belongs to an operation which has already been
+// applied and should be skipped
+func (r *opReplayState) visit(typ lssBlockType, bs []byte) (bool, error) {
+	if typ == lssOpMarker {
+		id, kind, numBlocks, err := unmarshalOpMarker(bs)
+		if err != nil {
+			return false, err
+		}
+
+		r.checkComplete()
+		if _, ok := r.seen[id]; ok || (r.maxId > opReplayWindow && id <= r.maxId-opReplayWindow) {
+			r.skip = numBlocks
+			r.skipped++
+			return true, nil
+		}
+
+		r.seen[id] = struct{}{}
+		if id > r.maxId {
+			r.maxId = id
+			if r.maxId > opReplayWindow && len(r.seen) > 2*opReplayWindow {
+				for sid := range r.seen {
+					if sid <= r.maxId-opReplayWindow {
+						delete(r.seen, sid)
+					}
+				}
+			}
+		}
+
+		r.curr, r.kind, r.pending = id, kind, numBlocks
+		r.replayed++
+		return true, nil
+	}
+
+	if r.skip > 0 {
+		r.skip--
+		return true, nil
+	}
+
+	if r.pending > 0 {
+		switch typ {
+		case lssPageData, lssPageReloc, lssPageUpdate, lssPageRemove, lssDiscard:
+			r.pending--
+		default:
+			r.checkComplete()
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,87 @@
+package plasma
+
+import (
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+)
+
+func TestOpReplayState(t *testing.T) {
+	r := newOpReplayState()
+	blocks := []struct {
+		typ  lssBlockType
+		bs   []byte
+		skip bool
+	}{
+		{lssOpMarker, marshalOpMarker(1, opKindSplit, 2), true},
+		{lssPageUpdate, nil, false},
+		{lssPageData, nil, false},
+		{lssPageData, nil, false},
+		// Replayed again
+		{lssOpMarker, marshalOpMarker(1, opKindSplit, 2), true},
+		{lssPageUpdate, nil, true},
+		{lssPageData, nil, true},
+		{lssMaxSn, nil, false},
+		// Missing its page data block
+		{lssOpMarker, marshalOpMarker(3, opKindMerge, 2), true},
+		{lssPageRemove, nil, false},
+		{lssOpMarker, marshalOpMarker(2, opKindMerge, 2), true},
+		{lssDiscard, nil, false},
+		{lssDiscard, nil, false},
+	}
+
+	for i, b := range blocks {
+		skip, err := r.visit(b.typ, b.bs)
+		if err != nil || skip != b.skip {
+			t.Fatalf("block %d: expected skip %v, got %v (err %v)", i, b.skip, skip, err)
+		}
+	}
+
+	if replayed, skipped, incomplete := r.finish(); replayed != 3 || skipped != 1 || incomplete != 1 {
+		t.Errorf("expected 3 replayed, 1 skipped and 1 incomplete, got %d %d %d",
+			replayed, skipped, incomplete)
+	}
+
+	if r.maxId != 3 {
+		t.Errorf("expected max op id 3, got %d", r.maxId)
+	}
+
+	if _, err := r.visit(lssOpMarker, []byte{1}); err != ErrCorruptLSSBlock {
+		t.Errorf("expected corrupt block error, got %v", err)
+	}
+}
+
+func TestPlasmaRecoveryOpMarkers(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+
+	for i := 0; i < 50000; i++ {
+		w.Delete(skiplist.NewIntKeyItem(i))
+	}
+
+	sts := s.GetStats()
+	lastOpId := s.lastOpId
+	if sts.Splits == 0 || sts.Merges == 0 {
+		t.Fatalf("expected splits and merges, got %d %d", sts.Splits, sts.Merges)
+	}
+	s.PersistAll()
+	s.Close()
+
+	s = newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+
+	sts = s.GetStats()
+	if sts.ReplayedOps == 0 || sts.SkippedOps != 0 || sts.IncompleteOps != 0 {
+		t.Errorf("expected operations to be replayed once, got %d replayed, %d skipped, %d incomplete",
+			sts.ReplayedOps, sts.SkippedOps, sts.IncompleteOps)
+	}
+
+	if s.lastOpId != lastOpId {
+		t.Errorf("expected the last op id %d to be recovered, got %d", lastOpId, s.lastOpId)
+	}
+	verifyCheckpointItems(t, s, 50000, 100000)
+}
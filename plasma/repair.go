@@ -0,0 +1,217 @@
+package plasma
+
+import (
+	"fmt"
+	"github.com/couchbase/nitro/skiplist"
+	"unsafe"
+)
+
+// Key range [MinKey, MaxKey) whose items could not be recovered
+type LostRange struct {
+	MinKey unsafe.Pointer
+	MaxKey unsafe.Pointer
+}
+
+// Summary of the data discarded while recovering with RepairOnRecovery
+type RepairReport struct {
+	SkippedBlocks int
+	SkippedBytes  int64
+
+	// Log could not be read beyond TruncatedOffset
+	Truncated       bool
+	TruncatedOffset LSSOffset
+	TruncatedBytes  int64
+
+	// Pages dropped due to overlapping key ranges
+	DroppedPages int
+	LostRanges   []LostRange
+}
+
+func (r *RepairReport) HasLoss() bool {
+	return r.SkippedBlocks > 0 || r.Truncated || len(r.LostRanges) > 0
+}
+
+func (r *RepairReport) String() string {
+	return fmt.Sprintf("skipped blocks: %d (%d bytes), truncated: %v (offset: %d, %d bytes), "+
+		"dropped pages: %d, lost ranges: %d", r.SkippedBlocks, r.SkippedBytes,
+		r.Truncated, r.TruncatedOffset, r.TruncatedBytes, r.DroppedPages, len(r.LostRanges))
+}
+
+func (s *Plasma) RepairReport() *RepairReport {
+	return s.repair
+}
+
+// Process a recovery block and skip it if it cannot be decoded
+func (s *Plasma) repairBlock(fn LSSBlockCallback, offset LSSOffset,
+	bs []byte, pg *page) (cont bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+
+		if err != nil {
+			fmt.Printf("Plasma: Repair: skipping block at offset %d (%d bytes) - err %v\n",
+				offset, len(bs), err)
+			s.repair.SkippedBlocks++
+			s.repair.SkippedBytes += int64(len(bs))
+			pg.Reset()
+			cont, err = true, nil
+		}
+	}()
+
+	return fn(offset, bs)
+}
+
+func (s *Plasma) repairVisitLSS(fn LSSBlockCallback, buf *Buffer) (err error) {
+	start := s.lss.HeadOffset()
+	tail := s.lss.TailOffset()
+	next := start
+
+	callb := func(offset LSSOffset, bs []byte) (bool, error) {
+		next = lssBlockEndOffset(offset, bs)
+		return fn(offset, bs)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+
+		if err != nil {
+			fmt.Printf("Plasma: Repair: unable to read log beyond offset %d - err %v\n", next, err)
+			s.repair.Truncated = true
+			s.repair.TruncatedOffset = next
+			s.repair.TruncatedBytes = int64(tail - next)
+			err = nil
+		}
+	}()
+
+	return s.lss.Visitor(callb, buf)
+}
+
+func (s *Plasma) isSameKey(a, b unsafe.Pointer) bool {
+	if a == b {
+		return true
+	}
+
+	if a == skiplist.MinItem || a == skiplist.MaxItem ||
+		b == skiplist.MinItem || b == skiplist.MaxItem {
+		return false
+	}
+
+	return s.cmp(a, b) == 0
+}
+
+// Remove pages with overlapping key ranges and fill the key ranges with
+// missing pages by empty pages, so that the pages cover the key space
+func (s *Plasma) repairPageRanges() error {
+	var dropped []PageId
+	var gaps []LostRange
+	var numPages int
+
+	lastHi := skiplist.MinItem
+	callb := func(pid PageId, partn RangePartition) error {
+		if pid == s.StartPageId() && pid.(*skiplist.Node).Link == nil {
+			return nil
+		}
+
+		pg, err := s.ReadPage(pid, s.gCtx.pgRdrFn, false, s.gCtx)
+		if err != nil {
+			return err
+		}
+
+		numPages++
+		if !s.isSameKey(lastHi, pg.MinItem()) {
+			if lastHi != skiplist.MinItem && (pg.MinItem() == skiplist.MinItem ||
+				s.cmp(pg.MinItem(), lastHi) < 0) {
+				dropped = append(dropped, pid)
+				s.addLostRange(pg.MinItem(), pg.MaxItem())
+				return nil
+			}
+
+			gaps = append(gaps, s.addLostRange(lastHi, pg.MinItem()))
+		}
+
+		lastHi = pg.MaxItem()
+		return nil
+	}
+
+	if err := s.PageVisitor(callb, 1); err != nil {
+		return err
+	}
+
+	if numPages == 0 {
+		return nil
+	}
+
+	if lastHi != skiplist.MaxItem {
+		gaps = append(gaps, s.addLostRange(lastHi, skiplist.MaxItem))
+	}
+
+	for _, pid := range dropped {
+		s.unindexPage(pid, s.gCtx)
+	}
+
+	s.repair.DroppedPages = len(dropped)
+	for _, r := range gaps {
+		pg := newPage(s.gCtx, r.MinKey, nil).(*page)
+		d := pg.allocMetaDelta(r.MaxKey)
+		d.op = opMetaDelta
+		d.next = nil
+		d.rightSibling = nil
+		d.leftSibling = nil
+		pg.head = (*pageDelta)(unsafe.Pointer(d))
+
+		pid := s.AllocPageId(s.gCtx)
+		s.CreateMapping(pid, pg, s.gCtx)
+		s.indexPage(pid, s.gCtx)
+	}
+
+	return nil
+}
+
+func (s *Plasma) addLostRange(lo, hi unsafe.Pointer) LostRange {
+	r := LostRange{MinKey: s.dup(lo), MaxKey: s.dup(hi)}
+	s.repair.LostRanges = append(s.repair.LostRanges, r)
+	return r
+}
+
+// Rewrite all the pages and discard the log written before them,
+// so that the skipped blocks are not encountered during next recovery
+func (s *Plasma) rewriteLSS() error {
+	w := s.lssCleanerWriter
+	buf := w.GetBuffer(bufReloc)
+
+	s.lss.Sync(false)
+	startOff := s.lss.TailOffset()
+
+	callb := func(pid PageId, partn RangePartition) error {
+	retry:
+		pg, err := s.ReadPage(pid, w.pgRdrFn, false, w)
+		if err != nil {
+			return err
+		}
+
+		if ok, _ := s.tryPageRelocation(pid, pg, buf, w); !ok {
+			goto retry
+		}
+
+		return nil
+	}
+
+	if err := s.PageVisitor(callb, 1); err != nil {
+		return err
+	}
+
+	if s.EnableShapshots {
+		s.mvcc.Lock()
+		s.updateRecoveryPoints(s.recoveryPoints)
+		s.updateMaxSn(s.currSn, true)
+		s.mvcc.Unlock()
+	}
+
+	s.lss.Sync(true)
+	s.lss.ResetHead(startOff)
+	s.lss.Sync(true)
+	return nil
+}
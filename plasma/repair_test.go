@@ -0,0 +1,56 @@
+package plasma
+
+import (
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlasmaRepairTruncatedLog(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	w := s.NewWriter()
+	for i := 0; i < 50000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+
+	s.PersistAll()
+	corruptOff := int64(s.lss.TailOffset())
+
+	for i := 50000; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	s.Close()
+
+	f, err := os.OpenFile(filepath.Join("teststore.data", "log.00000000000000.data"), os.O_WRONLY, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, corruptOff)
+	f.Close()
+
+	cfg := testCfg
+	cfg.RepairOnRecovery = true
+	s, err = New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	r := s.RepairReport()
+	if !r.Truncated || r.TruncatedOffset != LSSOffset(corruptOff) {
+		t.Errorf("expected log to be truncated at %d, got %v", corruptOff, r)
+	}
+	s.Close()
+
+	s = newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+
+	w = s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		got, _ := w.Lookup(skiplist.NewIntKeyItem(i))
+		if (got != nil) != (i < 50000) {
+			t.Fatalf("unexpected lookup result for %d", i)
+		}
+	}
+}
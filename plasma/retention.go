@@ -0,0 +1,171 @@
+package plasma
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+var ErrRetentionNotSupported = errors.New("retention policies require snapshots")
+
+// Items under Prefix written more than MaxAge ago are removed when their
+// pages are compacted. They remain visible until then, and ForceGC can be
+// used to remove them immediately. Policies are not persisted.
+type RetentionPolicy struct {
+	Name   string
+	Prefix []byte
+	MaxAge time.Duration
+}
+
+type RetentionStats struct {
+	RetentionPolicy
+
+	// Items with sequence numbers up to ExpirySn are expired. ExpiredItems
+	// counts the removed versions. It is approximate, as the versions
+	// removed by compactions which lose a race are also counted.
+	ExpirySn     uint64
+	ExpiredItems int64
+}
+
+type retentionPolicy struct {
+	RetentionPolicy
+	expired int64
+}
+
+// Items are not timestamped. The age of an item is derived from the time
+// at which its sequence number became current, which is recorded as
+// snapshots are created. Items written before the store was opened are
+// considered to be written when it was opened.
+const maxSnTimes = 4096
+
+type snTimes struct {
+	sync.Mutex
+	sns   []uint64
+	times []time.Time
+}
+
+func (st *snTimes) record(sn uint64, t time.Time) {
+	st.Lock()
+	defer st.Unlock()
+
+	if n := len(st.sns); n > 0 && st.sns[n-1] >= sn {
+		return
+	}
+
+	// Coarser times only hold back the expiry of items
+	if len(st.sns) == maxSnTimes {
+		for i := 0; i < maxSnTimes/2; i++ {
+			st.sns[i], st.times[i] = st.sns[2*i+1], st.times[2*i+1]
+		}
+		st.sns, st.times = st.sns[:maxSnTimes/2], st.times[:maxSnTimes/2]
+	}
+
+	st.sns = append(st.sns, sn)
+	st.times = append(st.times, t)
+}
+
+// Items with sequence numbers up to the returned one were written before t
+func (st *snTimes) snBefore(t time.Time) uint64 {
+	st.Lock()
+	defer st.Unlock()
+
+	i := sort.Search(len(st.times), func(i int) bool {
+		return st.times[i].After(t)
+	})
+
+	if i == 0 {
+		return 0
+	}
+
+	return st.sns[i-1] - 1
+}
+
+func (s *Plasma) retentionPolicies() []*retentionPolicy {
+	p := atomic.LoadPointer(&s.retention)
+	if p == nil {
+		return nil
+	}
+
+	return *(*[]*retentionPolicy)(p)
+}
+
+// Replace the retention policies of the store. Items matching more than
+// one policy are expired by the first of them.
+func (s *Plasma) SetRetentionPolicies(policies []RetentionPolicy) error {
+	if !s.EnableShapshots {
+		return ErrRetentionNotSupported
+	}
+
+	rps := make([]*retentionPolicy, len(policies))
+	for i, p := range policies {
+		rps[i] = &retentionPolicy{RetentionPolicy: p}
+		rps[i].Prefix = append([]byte(nil), p.Prefix...)
+	}
+
+	atomic.StorePointer(&s.retention, unsafe.Pointer(&rps))
+	return nil
+}
+
+func (s *Plasma) RetentionStats() []RetentionStats {
+	now := s.Clock.Now()
+	var sts []RetentionStats
+	for _, p := range s.retentionPolicies() {
+		sts = append(sts, RetentionStats{
+			RetentionPolicy: p.RetentionPolicy,
+			ExpirySn:        s.snTimes.snBefore(now.Add(-p.MaxAge)),
+			ExpiredItems:    atomic.LoadInt64(&p.expired),
+		})
+	}
+
+	return sts
+}
+
+// Compaction filter removing the expired items before passing the others
+// to the filter of the store
+type retentionFilter struct {
+	ItemFilter
+	codec     ItemCodec
+	policies  []*retentionPolicy
+	expirySns []uint64
+}
+
+func (s *Plasma) newRetentionFilter(f ItemFilter) ItemFilter {
+	policies := s.retentionPolicies()
+	if len(policies) == 0 {
+		return f
+	}
+
+	now := s.Clock.Now()
+	expirySns := make([]uint64, len(policies))
+	for i, p := range policies {
+		expirySns[i] = s.snTimes.snBefore(now.Add(-p.MaxAge))
+	}
+
+	return &retentionFilter{
+		ItemFilter: f,
+		codec:      s.ItemCodec,
+		policies:   policies,
+		expirySns:  expirySns,
+	}
+}
+
+func (f *retentionFilter) Process(o PageItem) PageItemsList {
+	itm := o.Item()
+	key := f.codec.Key(itm)
+	for i, p := range f.policies {
+		if bytes.HasPrefix(key, p.Prefix) {
+			// Older versions of the key are expired along with it
+			if f.codec.Sn(itm) <= f.expirySns[i] {
+				atomic.AddInt64(&p.expired, 1)
+				return nilPageItemsList
+			}
+			break
+		}
+	}
+
+	return f.ItemFilter.Process(o)
+}
@@ -0,0 +1,74 @@
+package plasma
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlasmaRetentionPolicies(t *testing.T) {
+	clock := NewVirtualClock(time.Now())
+	cfg := testSnCfg
+	cfg.File = ""
+	cfg.Clock = clock
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	policy := RetentionPolicy{Name: "logs", Prefix: []byte("log-"), MaxAge: time.Hour * 24}
+	if err := s.SetRetentionPolicies([]RetentionPolicy{policy}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	w := s.NewWriter()
+	write := func(start, end int) {
+		for i := start; i < end; i++ {
+			w.InsertKV([]byte(fmt.Sprintf("log-%10d", i)), nil)
+			w.InsertKV([]byte(fmt.Sprintf("data-%10d", i)), nil)
+		}
+		s.NewSnapshot().Close()
+	}
+
+	write(0, 1000)
+	clock.Advance(time.Hour * 12)
+	write(1000, 2000)
+	clock.Advance(time.Hour * 13)
+
+	if err := s.ForceGC(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+	itr := snap.NewIterator()
+	defer itr.Close()
+
+	logs, data := 0, 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if k := string(itr.Key()); strings.HasPrefix(k, "log-") {
+			if k < fmt.Sprintf("log-%10d", 1000) {
+				t.Fatalf("expected %s to be expired", k)
+			}
+			logs++
+		} else {
+			data++
+		}
+	}
+
+	if logs != 1000 || data != 2000 {
+		t.Errorf("expected 1000 logs and 2000 data items, got %d %d", logs, data)
+	}
+
+	sts := s.RetentionStats()
+	if len(sts) != 1 || sts[0].Name != "logs" || sts[0].ExpiredItems < 1000 {
+		t.Errorf("unexpected retention stats %+v", sts)
+	}
+
+	cfg.EnableShapshots = false
+	s2 := newTestIntPlasmaStore(cfg)
+	defer s2.Close()
+	if err := s2.SetRetentionPolicies([]RetentionPolicy{policy}); err != ErrRetentionNotSupported {
+		t.Errorf("expected retention not supported error, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package plasma
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrRollbackPending = errors.New("an earlier rollback has not completed")
+
+const storeMetaRollback = "rollback"
+
+// A rollback is recorded in the store metadata before any page is
+// rewritten and removed once it completes. The rollback deltas flushed
+// along with the pages record its progress, so that an interrupted
+// rollback is resumed by rewriting only the remaining pages.
+type pendingRollback struct {
+	start, end uint64
+	count      int64
+}
+
+func (p pendingRollback) marshal() []byte {
+	buf := make([]byte, 24)
+	binary.BigEndian.PutUint64(buf[0:8], p.start)
+	binary.BigEndian.PutUint64(buf[8:16], p.end)
+	binary.BigEndian.PutUint64(buf[16:24], uint64(p.count))
+	return buf
+}
+
+func unmarshalPendingRollback(buf []byte) (pendingRollback, error) {
+	if len(buf) != 24 {
+		return pendingRollback{}, errStoreMetaCorrupt
+	}
+
+	return pendingRollback{
+		start: binary.BigEndian.Uint64(buf[0:8]),
+		end:   binary.BigEndian.Uint64(buf[8:16]),
+		count: int64(binary.BigEndian.Uint64(buf[16:24])),
+	}, nil
+}
+
+func (s *Plasma) getPendingRollback() (*pendingRollback, error) {
+	bs, ok := s.getStoreMeta(storeMetaRollback)
+	if !ok {
+		return nil, nil
+	}
+
+	p, err := unmarshalPendingRollback(bs)
+	return &p, err
+}
+
+// Sequence number of the recovery point targeted by a rollback which was
+// canceled or interrupted by a crash
+func (s *Plasma) PendingRollback() (uint64, bool) {
+	p, err := s.getPendingRollback()
+	if p == nil || err != nil {
+		return 0, false
+	}
+
+	return p.start - 1, true
+}
+
+// Check if the page already carries a rollback delta covering [start, end]
+func isPageRolledBack(pg *page, start, end uint64, ctx *wCtx) bool {
+	if pg.head == nil {
+		return false
+	}
+
+	pw := newPgDeltaWalker(pg.head, ctx)
+	defer pw.Close()
+
+	for ; !pw.End(); pw.Next() {
+		switch pw.Op() {
+		case opRollbackDelta:
+			if rbStart, rbEnd := pw.RollbackInfo(); rbStart <= start && rbEnd >= end {
+				return true
+			}
+		case opBasePage, opPageMergeDelta:
+			return false
+		}
+	}
+
+	return false
+}
+
+// Rollback the pages and update the mvcc state. Caller holds s.mvcc.
+func (s *Plasma) doRollback(ctx context.Context, p pendingRollback) (*Snapshot, error) {
+	if err := s.setStoreMeta(storeMetaRollback, p.marshal()); err != nil {
+		return nil, err
+	}
+	s.lss.Sync(true)
+
+	persistWriters, _ := s.getWorkerCtxs()
+	callb := func(pid PageId, partn RangePartition) error {
+		w := persistWriters[partn.Shard]
+		pgBuf := w.GetBuffer(bufPersist)
+	retry:
+		pg, err := s.ReadPage(pid, w.pgRdrFn, false, w)
+		if err != nil {
+			return err
+		}
+
+		if isPageRolledBack(pg.(*page), p.start, p.end, w) {
+			return nil
+		}
+
+		pg.Rollback(p.start, p.end)
+		bs, fdSz, staleFdSz, numSegments := pg.Marshal(pgBuf, s.maxPageSegments(pg))
+		offset, wbuf, res := s.lss.ReserveSpace(len(bs) + lssBlockTypeSize)
+		typ := pgFlushLSSType(pg, numSegments)
+		writeLSSBlock(wbuf, typ, bs)
+		pg.AddFlushRecord(offset, fdSz, numSegments)
+		s.lss.FinalizeWrite(res)
+		w.sts.FlushDataSz += int64(fdSz) - int64(staleFdSz)
+
+		// May conflict with cleaner
+		if !s.UpdateMapping(pid, pg, w) {
+			goto retry
+		}
+
+		return nil
+	}
+
+	if err := s.PageVisitorContext(ctx, callb, s.numPersistorThreads()); err != nil {
+		// Pages rolled back so far are made durable, so that a retry
+		// resumes from them
+		s.lss.Sync(false)
+		return nil, err
+	}
+
+	s.lss.Sync(false)
+
+	s.itemsCountOff = p.count - s.sumItemsCounters()
+	newSnap := s.newSnapshot()
+	var newRpts []*RecoveryPoint
+	for _, rp := range s.recoveryPoints {
+		if rp.sn < p.start {
+			newRpts = append(newRpts, rp)
+		}
+	}
+
+	// gcSn is left to be advanced as the snapshots are closed, as the
+	// snapshot returned and the ones created after it are still open
+	s.updateRecoveryPoints(newRpts)
+
+	if err := s.deleteStoreMeta(storeMetaRollback); err != nil {
+		return nil, err
+	}
+
+	s.lss.Sync(true)
+	return newSnap, nil
+}
+
+// Complete a rollback interrupted by a crash
+func (s *Plasma) resumeRollback() error {
+	p, err := s.getPendingRollback()
+	if p == nil || err != nil {
+		return err
+	}
+
+	fmt.Printf("Plasma: Resuming rollback to sn %d\n", p.start-1)
+
+	s.mvcc.Lock()
+	defer s.mvcc.Unlock()
+
+	if p.end < s.currSn {
+		p.end = s.currSn
+	}
+
+	snap, err := s.doRollback(context.Background(), *p)
+	if err == nil {
+		snap.Close()
+	}
+
+	return err
+}
@@ -0,0 +1,160 @@
+package plasma
+
+import (
+	"bytes"
+	"context"
+	"github.com/couchbase/nitro/skiplist"
+	"sync"
+)
+
+// Callback of ParallelScan invoked for every item of a sub-range, in key
+// order within the sub-range. The key and value are only valid during the
+// call.
+type ScanCallback func(shard int, k, v []byte) error
+
+type ScanStats struct {
+	Items       int64
+	Bytes       int64
+	CacheHits   int64
+	CacheMisses int64
+}
+
+func (s *ScanStats) Merge(o *ScanStats) {
+	s.Items += o.Items
+	s.Bytes += o.Bytes
+	s.CacheHits += o.CacheHits
+	s.CacheMisses += o.CacheMisses
+}
+
+// Split [lo, hi) at the keys of the range partitions of the page index
+func (s *Plasma) scanRanges(lo, hi []byte, n int) [][2][]byte {
+	var ranges [][2][]byte
+
+	if lo != nil && hi != nil && bytes.Compare(lo, hi) >= 0 {
+		return nil
+	}
+
+	start := lo
+	for _, partn := range s.GetRangePartitions(n) {
+		if partn.MaxKey == skiplist.MaxItem {
+			break
+		}
+
+		key := s.ItemCodec.Key(partn.MaxKey)
+		if start != nil && bytes.Compare(key, start) <= 0 {
+			continue
+		}
+
+		if hi != nil && bytes.Compare(key, hi) >= 0 {
+			break
+		}
+
+		key = append([]byte(nil), key...)
+		ranges = append(ranges, [2][]byte{start, key})
+		start = key
+	}
+
+	return append(ranges, [2][]byte{start, hi})
+}
+
+func (sn *Snapshot) scanRange(ctx context.Context, shard int, lo, hi []byte,
+	fn ScanCallback, sts *ScanStats) error {
+	itr := sn.NewIterator()
+	defer itr.Close()
+
+	hits, misses := itr.sts.CacheHits, itr.sts.CacheMisses
+	defer func() {
+		sts.CacheHits += itr.sts.CacheHits - hits
+		sts.CacheMisses += itr.sts.CacheMisses - misses
+	}()
+
+	if lo == nil {
+		itr.SeekFirst()
+	} else {
+		itr.Seek(lo)
+	}
+
+	for ; itr.Valid(); itr.Next() {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+
+		k := itr.Key()
+		if hi != nil && bytes.Compare(k, hi) >= 0 {
+			break
+		}
+
+		v := itr.Value()
+		if itr.err != nil {
+			return itr.err
+		}
+
+		if err := fn(shard, k, v); err != nil {
+			return err
+		}
+
+		sts.Items++
+		sts.Bytes += int64(len(k) + len(v))
+	}
+
+	return itr.err
+}
+
+// Run fn over disjoint sub-ranges of [lo, hi) of the snapshot from up to
+// parallelism goroutines. A nil bound leaves that end of the range
+// unbounded. The sub-ranges are split at page boundaries. The first error
+// returned by fn or the context stops the scan and is returned.
+func (sn *Snapshot) ParallelScan(ctx context.Context, lo, hi []byte, parallelism int,
+	fn ScanCallback) (ScanStats, error) {
+	var stats ScanStats
+
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	ranges := sn.db.scanRanges(lo, hi, parallelism)
+	if len(ranges) == 0 {
+		return stats, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(ranges))
+	sts := make([]ScanStats, len(ranges))
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(shard int, lo, hi []byte) {
+			defer wg.Done()
+			if errs[shard] = sn.scanRange(ctx, shard, lo, hi, fn, &sts[shard]); errs[shard] != nil {
+				cancel()
+			}
+		}(i, r[0], r[1])
+	}
+
+	wg.Wait()
+
+	for i := range sts {
+		stats.Merge(&sts[i])
+	}
+
+	// Prefer the error which stopped the scan over the cancellations
+	var err error
+	for _, e := range errs {
+		if e != nil && (err == nil || err == context.Canceled) {
+			err = e
+		}
+	}
+
+	return stats, err
+}
+
+// ParallelScan on a new snapshot of the store
+func (s *Plasma) ParallelScan(ctx context.Context, lo, hi []byte, parallelism int,
+	fn ScanCallback) (ScanStats, error) {
+	snap := s.NewSnapshot()
+	defer snap.Close()
+
+	return snap.ParallelScan(ctx, lo, hi, parallelism, fn)
+}
@@ -0,0 +1,99 @@
+package plasma
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestPlasmaParallelScan(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%10d", i))
+	}
+
+	w := s.NewWriter()
+	n := 50000
+	for i := 0; i < n; i++ {
+		w.InsertKV(key(i), key(i))
+	}
+
+	for i := 0; i < n; i += 10 {
+		w.DeleteKV(key(i))
+	}
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+
+	// Updates after the snapshot are not visible
+	for i := 1; i < n; i += 10 {
+		w.DeleteKV(key(i))
+	}
+
+	var mu sync.Mutex
+	var last map[int][]byte
+	scan := func(lo, hi []byte) (map[string]bool, ScanStats) {
+		seen := make(map[string]bool)
+		last = make(map[int][]byte)
+		sts, err := snap.ParallelScan(context.Background(), lo, hi, 8, func(shard int, k, v []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if !bytes.Equal(k, v) {
+				t.Errorf("unexpected value %s for %s", v, k)
+			}
+
+			if seen[string(k)] {
+				t.Errorf("duplicate key %s", k)
+			}
+
+			if bytes.Compare(last[shard], k) >= 0 {
+				t.Errorf("out of order key %s in shard %d", k, shard)
+			}
+
+			seen[string(k)] = true
+			last[shard] = append([]byte(nil), k...)
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		return seen, sts
+	}
+
+	seen, sts := scan(nil, nil)
+	if len(seen) != n*9/10 || sts.Items != int64(len(seen)) {
+		t.Errorf("expected %d items, got %d (stats %d)", n*9/10, len(seen), sts.Items)
+	}
+
+	if len(last) < 2 {
+		t.Errorf("expected the range to be split, got %d sub-ranges", len(last))
+	}
+
+	seen, _ = scan(key(1000), key(2000))
+	if len(seen) != 900 || !seen[string(key(1001))] || seen[string(key(2001))] {
+		t.Errorf("unexpected items in range, got %d", len(seen))
+	}
+
+	if seen, _ = scan(key(2000), key(1000)); len(seen) != 0 {
+		t.Errorf("expected empty range, got %d", len(seen))
+	}
+
+	errStop := errors.New("stop")
+	_, err := s.ParallelScan(context.Background(), nil, nil, 4, func(int, []byte, []byte) error {
+		return errStop
+	})
+
+	if err != errStop {
+		t.Errorf("expected callback error, got %v", err)
+	}
+}
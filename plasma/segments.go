@@ -0,0 +1,122 @@
+package plasma
+
+import (
+	"context"
+	"fmt"
+	"github.com/couchbase/nitro/skiplist"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Overrides MaxPageLSSSegments for the pages whose low key is in the range
+// [MinKey, MaxKey). skiplist.MinItem and skiplist.MaxItem can be used as
+// the range bounds. Pages of ranges with MaxPageLSSSegments 0 are written
+// in full on every flush.
+type SegmentPolicy struct {
+	MinKey, MaxKey     unsafe.Pointer
+	MaxPageLSSSegments int
+}
+
+func (s *Plasma) segmentPolicies() []SegmentPolicy {
+	p := atomic.LoadPointer(&s.segPolicies)
+	if p == nil {
+		return nil
+	}
+
+	return *(*[]SegmentPolicy)(p)
+}
+
+// Replace the segment policies of the store. Pages matching more than one
+// policy use the first of them.
+func (s *Plasma) SetSegmentPolicies(policies []SegmentPolicy) error {
+	ps := make([]SegmentPolicy, len(policies))
+	for i, p := range policies {
+		if p.MaxPageLSSSegments < 0 {
+			return fmt.Errorf("Invalid segment policy: MaxPageLSSSegments (%d) should not be negative",
+				p.MaxPageLSSSegments)
+		}
+
+		ps[i] = SegmentPolicy{
+			MinKey:             s.copyKey(p.MinKey),
+			MaxKey:             s.copyKey(p.MaxKey),
+			MaxPageLSSSegments: p.MaxPageLSSSegments,
+		}
+	}
+
+	atomic.StorePointer(&s.segPolicies, unsafe.Pointer(&ps))
+	return nil
+}
+
+// Max number of log segments of the page before it is written in full
+func (s *Plasma) maxPageSegments(pg Page) int {
+	if policies := s.segmentPolicies(); len(policies) > 0 {
+		low := pg.(*page).low
+		if low == nil {
+			low = skiplist.MinItem
+		}
+
+		for _, p := range policies {
+			if (p.MinKey == skiplist.MinItem || s.cmp(low, p.MinKey) >= 0) &&
+				(p.MaxKey == skiplist.MaxItem || s.cmp(low, p.MaxKey) < 0) {
+				return p.MaxPageLSSSegments
+			}
+		}
+	}
+
+	return s.liveConfig().MaxPageLSSSegments
+}
+
+// Log segments of a page and the limit applying to it
+type PageSegments struct {
+	MinKey      unsafe.Pointer
+	NumSegments int
+	MaxSegments int
+}
+
+// Number of log segments of the last flushed image of a page
+func pageFlushSegments(pg *page) int {
+	for pd := pg.head; pd != nil; pd = pd.next {
+		switch pd.op {
+		case opFlushPageDelta, opRelocPageDelta:
+			return int((*flushPageDelta)(unsafe.Pointer(pd)).numSegments)
+		case opSwapoutDelta, opDeltaSwapoutDelta:
+			return int((*swapoutDelta)(unsafe.Pointer(pd)).numSegments)
+		case opBasePage:
+			return 0
+		}
+	}
+
+	return 0
+}
+
+// Report the log segments of the flushed pages in the key range [lo, hi)
+func (s *Plasma) PageSegmentStats(ctx context.Context, lo, hi unsafe.Pointer) ([]PageSegments, error) {
+	var sts []PageSegments
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	callb := func(pid PageId, partn RangePartition) error {
+		tok := w.BeginTx()
+		defer w.EndTx(tok)
+
+		pg, err := s.ReadPage(pid, nil, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		if n := pageFlushSegments(pg.(*page)); n > 0 {
+			sts = append(sts, PageSegments{
+				MinKey:      s.copyKey(pg.MinItem()),
+				NumSegments: n,
+				MaxSegments: s.maxPageSegments(pg),
+			})
+		}
+
+		return nil
+	}
+
+	opts := PageVisitorOptions{Ordered: true, MinKey: lo, MaxKey: hi}
+	err := s.PageVisitorWithOptions(ctx, callb, opts)
+	return sts, err
+}
@@ -0,0 +1,64 @@
+package plasma
+
+import (
+	"context"
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+)
+
+func TestPlasmaSegmentPolicies(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+
+	mid := skiplist.NewIntKeyItem(50000)
+	err := s.SetSegmentPolicies([]SegmentPolicy{
+		{MinKey: skiplist.MinItem, MaxKey: mid, MaxPageLSSSegments: 0},
+		{MinKey: mid, MaxKey: skiplist.MaxItem, MaxPageLSSSegments: 8},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i += 2 {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	s.PersistAll()
+
+	for r := 1; r < 10; r += 2 {
+		for i := r; i < 100000; i += 200 {
+			w.Insert(skiplist.NewIntKeyItem(i))
+		}
+		s.PersistAll()
+	}
+
+	sts, err := s.PageSegmentStats(context.Background(), skiplist.MinItem, skiplist.MaxItem)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var lo, hi int
+	for _, ps := range sts {
+		if ps.MinKey != skiplist.MinItem && s.cmp(ps.MinKey, mid) >= 0 {
+			if ps.MaxSegments != 8 {
+				t.Fatalf("expected max segments 8, got %d", ps.MaxSegments)
+			}
+			hi += ps.NumSegments
+		} else {
+			if ps.MaxSegments != 0 || ps.NumSegments != 1 {
+				t.Fatalf("expected a full page, got %d of %d segments", ps.NumSegments, ps.MaxSegments)
+			}
+			lo += ps.NumSegments
+		}
+	}
+
+	if lo == 0 || hi <= lo {
+		t.Errorf("expected more segments above the mid key, got %d and %d", lo, hi)
+	}
+
+	if err := s.SetSegmentPolicies([]SegmentPolicy{{MaxPageLSSSegments: -1}}); err == nil {
+		t.Errorf("expected an error for negative max segments")
+	}
+}
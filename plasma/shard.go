@@ -0,0 +1,80 @@
+package plasma
+
+import (
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// Shards split the key space using the same range partitioning scheme as
+// the persistors. Applications can route mutations for a shard to its own
+// writer to reduce contention between writers operating on the same pages.
+// Routing is only an optimization, any writer can operate on any key.
+type shardTable struct {
+	sync.Mutex
+	partns  []RangePartition
+	writers []*Writer
+}
+
+// Recompute shard boundaries for n shards. The actual number of shards
+// can be lower than n if the store has fewer pages.
+func (s *Plasma) InitShards(n int) {
+	s.shards.Lock()
+	defer s.shards.Unlock()
+	s.initShards(n)
+}
+
+func (s *Plasma) initShards(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	s.shards.partns = s.GetRangePartitions(n)
+	for len(s.shards.writers) < len(s.shards.partns) {
+		s.shards.writers = append(s.shards.writers, s.NewWriter())
+	}
+}
+
+func (s *Plasma) getShards() []RangePartition {
+	s.shards.Lock()
+	defer s.shards.Unlock()
+
+	if s.shards.partns == nil {
+		s.initShards(s.numPersistorThreads())
+	}
+
+	return s.shards.partns
+}
+
+func (s *Plasma) NumShards() int {
+	return len(s.getShards())
+}
+
+func (s *Plasma) ShardForItem(itm unsafe.Pointer) int {
+	partns := s.getShards()
+	i := sort.Search(len(partns), func(i int) bool {
+		return s.cmp(partns[i].MinKey, itm) > 0
+	})
+
+	return i - 1
+}
+
+// Shard routing for stores using the key value APIs. Keys which cannot be
+// encoded by the item codec are not routed.
+func (s *Plasma) ShardForKey(k []byte) (int, error) {
+	itm, err := s.ItemCodec.NewItem(k, nil, 0, nil, false, newBuffer(0))
+	if err != nil {
+		return 0, err
+	}
+
+	return s.ShardForItem(itm), nil
+}
+
+// A shard writer should be used by only one goroutine at a time
+func (s *Plasma) ShardWriter(shard int) *Writer {
+	s.getShards()
+
+	s.shards.Lock()
+	defer s.shards.Unlock()
+	return s.shards.writers[shard]
+}
@@ -0,0 +1,106 @@
+package plasma
+
+import (
+	"fmt"
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestPlasmaShardRouting(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+
+	s.InitShards(4)
+	n := s.NumShards()
+	if n < 2 || n > 4 {
+		t.Fatalf("expected 2-4 shards, got %d", n)
+	}
+
+	last := 0
+	for i := 0; i < 100000; i++ {
+		shard := s.ShardForItem(skiplist.NewIntKeyItem(i))
+		if shard < last || shard >= n {
+			t.Fatalf("invalid shard %d for %d", shard, i)
+		}
+		last = shard
+	}
+
+	if last != n-1 {
+		t.Errorf("expected last shard %d, got %d", n-1, last)
+	}
+
+	var wg sync.WaitGroup
+	chs := make([]chan int, n)
+	for i := range chs {
+		chs[i] = make(chan int, 1000)
+		wg.Add(1)
+		go func(shard int) {
+			defer wg.Done()
+			sw := s.ShardWriter(shard)
+			for k := range chs[shard] {
+				sw.Delete(skiplist.NewIntKeyItem(k))
+			}
+		}(i)
+	}
+
+	for i := 0; i < 100000; i += 2 {
+		chs[s.ShardForItem(skiplist.NewIntKeyItem(i))] <- i
+	}
+
+	for _, ch := range chs {
+		close(ch)
+	}
+	wg.Wait()
+
+	for i := 0; i < 100000; i++ {
+		got, _ := w.Lookup(skiplist.NewIntKeyItem(i))
+		if (got == nil) != (i%2 == 0) {
+			t.Errorf("unexpected lookup result for %d", i)
+		}
+	}
+}
+
+func TestPlasmaShardForKey(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := DefaultConfig()
+	cfg.File = "teststore.data"
+	cfg.AutoLSSCleaning = false
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), nil)
+	}
+
+	s.InitShards(4)
+	n := s.NumShards()
+	last := 0
+	for i := 0; i < 100000; i++ {
+		shard, err := s.ShardForKey([]byte(fmt.Sprintf("key-%10d", i)))
+		if err != nil {
+			t.Fatalf("unexpected error %v for %d", err, i)
+		}
+
+		if shard < last || shard >= n {
+			t.Fatalf("invalid shard %d for %d", shard, i)
+		}
+		last = shard
+	}
+
+	if last != n-1 {
+		t.Errorf("expected last shard %d, got %d", n-1, last)
+	}
+
+	if _, err := s.ShardForKey(make([]byte, itmLenMask+1)); err != ErrKeyTooLarge {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+}
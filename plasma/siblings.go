@@ -0,0 +1,147 @@
+package plasma
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/couchbase/nitro/skiplist"
+	"unsafe"
+)
+
+var ErrPageSiblings = errors.New("page siblings are inconsistent")
+
+// Right siblings followed from the left sibling hint of a page before the
+// index is looked up
+const maxSiblingHops = 16
+
+// Page to the left of the page, which is nil for the first page.
+//
+// The left sibling of a page is a hint, which is set when the page is split
+// from its left sibling. It is not updated when the left sibling is split
+// again, but pages are only split to the right, so that the page to the left
+// is found by following the right siblings from the hint. The index is
+// looked up if the hint has been merged away. Hints are not followed with
+// UseMemoryMgmt, since the pages which they refer to may have been freed.
+func (s *Plasma) prevPage(pid PageId, pg Page, ctx *wCtx) (PageId, Page, error) {
+	if pid == s.StartPageId() || pg.MinItem() == skiplist.MinItem {
+		return nil, nil, nil
+	}
+
+	if ppid := pg.Prev(); ppid != nil && !s.useMemMgmt {
+		for i := 0; i < maxSiblingHops && ppid != s.EndPageId(); i++ {
+			ppg, err := s.ReadPage(ppid, ctx.pgRdrFn, false, ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if ppg.NeedRemoval() {
+				break
+			}
+
+			if ppg.Next() == pid {
+				return ppid, ppg, nil
+			}
+
+			ppid = ppg.Next()
+		}
+	}
+
+	prev, _, _ := s.Skiplist.Lookup(pg.MinItem(), s.cmp, ctx.buf, ctx.slSts)
+	ppid := PageId(prev)
+	ppg, err := s.ReadPage(ppid, ctx.pgRdrFn, false, ctx)
+	return ppid, ppg, err
+}
+
+// Last page of the store. The index cannot be looked up with
+// skiplist.MaxItem, so a key ordered after every other key is used instead.
+func (s *Plasma) fetchLastPage(ctx *wCtx) (PageId, Page, error) {
+	var key byte
+	cmp := func(a, b unsafe.Pointer) int { return -1 }
+	prev, _, _ := s.Skiplist.Lookup(unsafe.Pointer(&key), cmp, ctx.buf, ctx.slSts)
+
+	pid := PageId(prev)
+	for {
+		pg, err := s.ReadPage(pid, ctx.pgRdrFn, false, ctx)
+		if err != nil || pg.Next() == s.EndPageId() {
+			return pid, pg, err
+		}
+		pid = pg.Next()
+	}
+}
+
+// Visit the pages overlapping [lo, hi) in reverse key order
+func (s *Plasma) visitReverse(ctx context.Context, lo, hi unsafe.Pointer,
+	callb PageVisitorCallback) error {
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	var pid PageId
+	var pg Page
+	var err error
+
+	partn := RangePartition{MinKey: lo, MaxKey: hi}
+	tok := w.BeginTx()
+	if hi == skiplist.MaxItem {
+		pid, pg, err = s.fetchLastPage(w.wCtx)
+	} else {
+		pid, pg, err = s.fetchPage(hi, w.wCtx)
+		if err == nil && s.cmp(pg.MinItem(), hi) == 0 {
+			pid, pg, err = s.prevPage(pid, pg, w.wCtx)
+		}
+	}
+	w.EndTx(tok)
+
+	for err == nil && pid != nil {
+		if err = checkContext(ctx); err != nil {
+			break
+		}
+
+		if err = callb(pid, partn); err != nil || s.cmp(pg.MinItem(), lo) <= 0 {
+			break
+		}
+
+		tok := w.BeginTx()
+		if pg, err = s.ReadPage(pid, w.wCtx.pgRdrFn, false, w.wCtx); err == nil {
+			pid, pg, err = s.prevPage(pid, pg, w.wCtx)
+		}
+		w.EndTx(tok)
+	}
+
+	return err
+}
+
+// Check that every page is the right sibling of the page found to its left
+// and that their key ranges adjoin. Pages should not be split or merged
+// meanwhile.
+func (s *Plasma) VerifySiblings(ctx context.Context) error {
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	var lastPid PageId
+	var lastMax unsafe.Pointer
+	callb := func(pid PageId, partn RangePartition) error {
+		tok := w.BeginTx()
+		defer w.EndTx(tok)
+
+		pg, err := s.ReadPage(pid, w.wCtx.pgRdrFn, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		ppid, ppg, err := s.prevPage(pid, pg, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		if ppid != lastPid || (ppg != nil && ppg.Next() != pid) ||
+			(lastMax != nil && s.cmp(lastMax, pg.MinItem()) != 0) {
+			fmt.Printf("Plasma: Verify: page %p is not linked to its left sibling %p\n", pid, ppid)
+			return ErrPageSiblings
+		}
+
+		lastPid, lastMax = pid, s.copyKey(pg.MaxItem())
+		return nil
+	}
+
+	return s.PageVisitorWithOptions(ctx, callb, PageVisitorOptions{Ordered: true})
+}
@@ -0,0 +1,72 @@
+package plasma
+
+import (
+	"context"
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+	"unsafe"
+)
+
+func TestPlasmaLeftSiblings(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+
+	for i := 0; i < 100000; i += 3 {
+		w.Delete(skiplist.NewIntKeyItem(i))
+	}
+
+	if err := s.VerifySiblings(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var fwd []PageId
+	s.PageVisitorWithOptions(context.Background(), func(pid PageId, partn RangePartition) error {
+		fwd = append(fwd, pid)
+		return nil
+	}, PageVisitorOptions{Ordered: true})
+
+	visitReverse := func(lo, hi unsafe.Pointer) []PageId {
+		var rev []PageId
+		err := s.PageVisitorWithOptions(context.Background(), func(pid PageId, partn RangePartition) error {
+			rev = append(rev, pid)
+			return nil
+		}, PageVisitorOptions{Reverse: true, MinKey: lo, MaxKey: hi})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return rev
+	}
+
+	rev := visitReverse(nil, nil)
+	if len(rev) != len(fwd) {
+		t.Fatalf("expected %d pages, got %d", len(fwd), len(rev))
+	}
+
+	for i := range rev {
+		if rev[i] != fwd[len(fwd)-1-i] {
+			t.Fatalf("expected page %d in reverse order", i)
+		}
+	}
+
+	if n := len(visitReverse(skiplist.NewIntKeyItem(50000), skiplist.NewIntKeyItem(50001))); n != 1 {
+		t.Errorf("expected a single page, got %d", n)
+	}
+	s.Close()
+
+	// Siblings are restored by recovery
+	s = newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+	if err := s.VerifySiblings(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if rev := visitReverse(nil, nil); len(rev) != len(fwd) {
+		t.Errorf("expected %d pages, got %d", len(fwd), len(rev))
+	}
+}
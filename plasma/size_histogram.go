@@ -0,0 +1,87 @@
+package plasma
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+const sizeHistogramBuckets = 33
+
+// Counts of sizes in power of two buckets. Bucket 0 counts the zero sizes
+// and bucket i counts the sizes in [2^(i-1), 2^i).
+type SizeHistogram [sizeHistogramBuckets]int64
+
+func (h *SizeHistogram) add(sz int) {
+	i := bits.Len64(uint64(sz))
+	if i >= sizeHistogramBuckets {
+		i = sizeHistogramBuckets - 1
+	}
+
+	h[i]++
+}
+
+func (h *SizeHistogram) Merge(o *SizeHistogram) {
+	for i := range h {
+		h[i] += o[i]
+	}
+}
+
+// Sizes counted by h but not by o, where o is an earlier sample of h
+func (h SizeHistogram) Since(o *SizeHistogram) SizeHistogram {
+	for i := range h {
+		h[i] -= o[i]
+	}
+
+	return h
+}
+
+func (h *SizeHistogram) Count() int64 {
+	var n int64
+	for _, c := range h {
+		n += c
+	}
+
+	return n
+}
+
+// Range of the sizes counted by bucket i
+func SizeHistogramBucket(i int) (lo, hi int64) {
+	if i == 0 {
+		return 0, 1
+	}
+
+	return int64(1) << uint(i-1), int64(1) << uint(i)
+}
+
+// Upper bound of the size below which a fraction p of the sizes fall
+func (h *SizeHistogram) Percentile(p float64) int64 {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+
+	var sum int64
+	for i, c := range h {
+		if sum += c; float64(sum) >= p*float64(n) {
+			_, hi := SizeHistogramBucket(i)
+			return hi
+		}
+	}
+
+	_, hi := SizeHistogramBucket(sizeHistogramBuckets - 1)
+	return hi
+}
+
+// Non empty buckets formatted as [lo, hi)=count
+func (h SizeHistogram) String() string {
+	var parts []string
+	for i, c := range h {
+		if c > 0 {
+			lo, hi := SizeHistogramBucket(i)
+			parts = append(parts, fmt.Sprintf("[%d,%d)=%d", lo, hi, c))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
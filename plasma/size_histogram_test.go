@@ -0,0 +1,62 @@
+package plasma
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSizeHistogram(t *testing.T) {
+	var h SizeHistogram
+	for _, sz := range []int{0, 1, 2, 3, 4, 100, 100, 1 << 40} {
+		h.add(sz)
+	}
+
+	exp := "[0,1)=1 [1,2)=1 [2,4)=2 [4,8)=1 [64,128)=2 [2147483648,4294967296)=1"
+	if h.String() != exp {
+		t.Errorf("expected %s, got %s", exp, h)
+	}
+
+	if h.Count() != 8 || h.Percentile(0.5) != 4 || h.Percentile(0.8) != 128 {
+		t.Errorf("unexpected count %d or percentiles %d %d", h.Count(), h.Percentile(0.5), h.Percentile(0.8))
+	}
+
+	prev := h
+	h.add(100)
+	if d := h.Since(&prev); d.Count() != 1 || d[7] != 1 {
+		t.Errorf("unexpected difference %s", d)
+	}
+}
+
+func TestPlasmaKVSizeStats(t *testing.T) {
+	clock := NewVirtualClock(time.Now())
+	cfg := testSnCfg
+	cfg.File = ""
+	cfg.Clock = clock
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	tr := s.NewStatsTracker(time.Second, 2)
+	w := s.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%4d", i)), make([]byte, 1000))
+	}
+	w.DeleteKV([]byte("key-   0"))
+
+	clock.Advance(time.Second)
+	iv := tr.Sample()
+	if iv.KeySizes[4] != 101 || iv.ValueSizes[10] != 100 || iv.ValueSizes.Count() != 100 {
+		t.Errorf("unexpected key sizes %s, value sizes %s", iv.KeySizes, iv.ValueSizes)
+	}
+
+	w.UpsertKV([]byte("k"), []byte("v"))
+	clock.Advance(time.Second)
+	iv = tr.Sample()
+	if iv.KeySizes.Count() != 1 || iv.ValueSizes.Count() != 1 || iv.ValueSizes[1] != 1 {
+		t.Errorf("unexpected key sizes %s, value sizes %s", iv.KeySizes, iv.ValueSizes)
+	}
+
+	if sts := s.GetStats(); sts.KeySizes.Count() != 102 || sts.ValueSizes.Count() != 101 {
+		t.Errorf("unexpected key sizes %s, value sizes %s", sts.KeySizes, sts.ValueSizes)
+	}
+}
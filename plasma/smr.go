@@ -19,6 +19,7 @@ var (
 	swapperSMRInterval     = 20
 	lssCleanerSMRInterval  = 20
 	pageVisitorSMRInterval = 100
+	compactorSMRInterval   = 20
 )
 
 type reclaimObject struct {
@@ -34,11 +35,20 @@ func (s *wCtx) BeginTx() TxToken {
 		s.safeOffset = s.lss.HeadOffset()
 	}
 
-	return TxToken(s.Skiplist.GetAccesBarrier().Acquire())
+	t := TxToken(s.Skiplist.GetAccesBarrier().Acquire())
+	if s.MaxTxTokenAge > 0 {
+		s.trackTxToken(t, s)
+	}
+
+	return t
 }
 
 func (s *wCtx) EndTx(t TxToken) {
 	s.safeOffset = expiredLSSOffset
+	if s.MaxTxTokenAge > 0 && !s.untrackTxToken(t) {
+		return
+	}
+
 	s.Skiplist.GetAccesBarrier().Release(t)
 }
 
@@ -0,0 +1,87 @@
+package plasma
+
+import (
+	"unsafe"
+)
+
+// Picks the item at which a page is split from the sorted items of its base
+// page, given the size of an item and of its index key. The new page starts
+// at the returned index.
+type SplitKeyPolicy func(items []unsafe.Pointer, itemSize, keySize ItemSizeFn) int
+
+// Split at the middle item
+func SplitByCount(items []unsafe.Pointer, itemSize, keySize ItemSizeFn) int {
+	return len(items) / 2
+}
+
+// Split at the item holding the middle byte of the items, so that pages with
+// values of skewed sizes are split into halves of similar size
+func SplitBySize(items []unsafe.Pointer, itemSize, keySize ItemSizeFn) int {
+	var total, sz uintptr
+	for _, itm := range items {
+		total += itemSize(itm)
+	}
+
+	for i, itm := range items {
+		if sz += itemSize(itm); 2*sz > total {
+			return i
+		}
+	}
+
+	return len(items) / 2
+}
+
+// Split at the item with the shortest index key in the middle half of the
+// items by size. Ties are broken by the distance from the middle byte.
+func SplitByShortestKey(items []unsafe.Pointer, itemSize, keySize ItemSizeFn) int {
+	var total, sz uintptr
+	for _, itm := range items {
+		total += itemSize(itm)
+	}
+
+	mid := SplitBySize(items, itemSize, keySize)
+	best, bestSz := mid, keySize(items[mid])
+	for i, itm := range items {
+		isz := itemSize(itm)
+		sz += isz
+		if 4*sz <= total || 4*(sz-isz) >= 3*total {
+			continue
+		}
+
+		ksz := keySize(itm)
+		if ksz < bestSz || (ksz == bestSz && absInt(i-mid) < absInt(best-mid)) {
+			best, bestSz = i, ksz
+		}
+	}
+
+	return best
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Index of the first item of the page split off, which is 0 if the page
+// cannot be split
+func (pg *page) splitIndex(items []unsafe.Pointer) int {
+	if len(items) == 0 {
+		return 0
+	}
+
+	mid := len(items) / 2
+	if pg.splitKeyPolicy != nil {
+		mid = pg.splitKeyPolicy(items, pg.itemSize, pg.indexKeySize)
+	}
+
+	// The page to the left must keep at least one item
+	if mid < 1 {
+		mid = 1
+	} else if mid >= len(items) {
+		mid = len(items) - 1
+	}
+
+	return mid
+}
@@ -0,0 +1,78 @@
+package plasma
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"unsafe"
+)
+
+func TestSplitKeyPolicies(t *testing.T) {
+	keys := make([]uintptr, 10)
+	sizes := make(map[unsafe.Pointer]uintptr)
+	var items []unsafe.Pointer
+	for i := range keys {
+		keys[i] = uintptr(10 - i)
+		items = append(items, unsafe.Pointer(&keys[i]))
+		sizes[items[i]] = 10
+	}
+
+	itemSize := func(itm unsafe.Pointer) uintptr { return sizes[itm] }
+	keySize := func(itm unsafe.Pointer) uintptr { return *(*uintptr)(itm) }
+
+	if mid := SplitBySize(items, itemSize, keySize); mid != 5 {
+		t.Errorf("expected uniform items to be split at 5, got %d", mid)
+	}
+
+	sizes[items[1]] = 1000
+	if mid := SplitBySize(items, itemSize, keySize); mid != 1 {
+		t.Errorf("expected split at the large item, got %d", mid)
+	}
+
+	if mid := SplitByCount(items, itemSize, keySize); mid != 5 {
+		t.Errorf("expected split at the middle item, got %d", mid)
+	}
+
+	sizes[items[1]] = 10
+	if mid := SplitByShortestKey(items, itemSize, keySize); mid != 7 {
+		t.Errorf("expected split at the shortest key in the middle half, got %d", mid)
+	}
+}
+
+func TestPlasmaSplitKeyPolicy(t *testing.T) {
+	for _, policy := range []SplitKeyPolicy{SplitBySize, SplitByShortestKey} {
+		os.RemoveAll("teststore.data")
+		cfg := testSnCfg
+		cfg.SplitKeyPolicy = policy
+		s := newTestIntPlasmaStore(cfg)
+
+		w := s.NewWriter()
+		n := 20000
+		for i := 0; i < n; i++ {
+			v := []byte("val")
+			if i%50 == 0 {
+				v = bytes.Repeat([]byte("v"), 4096)
+			}
+			w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), v)
+		}
+
+		if err := s.VerifySiblings(context.Background()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		s.PersistAll()
+		s.Close()
+
+		s = newTestIntPlasmaStore(cfg)
+		w = s.NewWriter()
+		for i := 0; i < n; i++ {
+			v, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i)))
+			if err != nil || (i%50 == 0) != (len(v) == 4096) {
+				t.Fatalf("unexpected value of %d bytes, err %v for item %d", len(v), err, i)
+			}
+		}
+		s.Close()
+	}
+}
@@ -0,0 +1,194 @@
+package plasma
+
+import (
+	"sync"
+	"time"
+)
+
+// Per second rates of the counters of Stats over an interval
+type StatsRates struct {
+	Inserts float64
+	Deletes float64
+	// Inserts, deletes, lookups and pages visited by iterators
+	Ops float64
+
+	Compacts float64
+	Splits   float64
+	Merges   float64
+
+	BytesIncoming float64
+	BytesWritten  float64
+	BytesOutgoing float64
+	FlushMBps     float64
+
+	SwapIns     float64
+	SwapOuts    float64
+	LSSReads    float64
+	CacheHits   float64
+	CacheMisses float64
+}
+
+type StatsInterval struct {
+	Start time.Time
+	End   time.Time
+	Rates StatsRates
+
+	// Sizes of the keys and values written during the interval
+	KeySizes   SizeHistogram
+	ValueSizes SizeHistogram
+
+	// Stats at the end of the interval
+	Stats Stats
+}
+
+func (i StatsInterval) Duration() time.Duration {
+	return i.End.Sub(i.Start)
+}
+
+// Rates of the counters between the samples prev and curr, taken secs
+// seconds apart
+func statsRates(prev, curr *Stats, secs float64) StatsRates {
+	rate := func(p, c int64) float64 {
+		return float64(c-p) / secs
+	}
+
+	return StatsRates{
+		Inserts:       rate(prev.Inserts, curr.Inserts),
+		Deletes:       rate(prev.Deletes, curr.Deletes),
+		Ops:           rate(prev.CacheHits+prev.CacheMisses, curr.CacheHits+curr.CacheMisses),
+		Compacts:      rate(prev.Compacts, curr.Compacts),
+		Splits:        rate(prev.Splits, curr.Splits),
+		Merges:        rate(prev.Merges, curr.Merges),
+		BytesIncoming: rate(prev.BytesIncoming, curr.BytesIncoming),
+		BytesWritten:  rate(prev.BytesWritten, curr.BytesWritten),
+		BytesOutgoing: rate(prev.BytesOutgoing, curr.BytesOutgoing),
+		FlushMBps:     rate(prev.BytesWritten, curr.BytesWritten) / (1024 * 1024),
+		SwapIns:       rate(prev.NumRecordSwapIn, curr.NumRecordSwapIn),
+		SwapOuts:      rate(prev.NumRecordSwapOut, curr.NumRecordSwapOut),
+		LSSReads:      rate(prev.NumLSSReads, curr.NumLSSReads),
+		CacheHits:     rate(prev.CacheHits, curr.CacheHits),
+		CacheMisses:   rate(prev.CacheMisses, curr.CacheMisses),
+	}
+}
+
+// StatsTracker samples the stats of a store every interval and retains the
+// rates of the most recent intervals
+type StatsTracker struct {
+	sync.Mutex
+	s        *Plasma
+	interval time.Duration
+
+	last   Stats
+	lastAt time.Time
+	ring   []StatsInterval
+	pos    int
+	n      int
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Tracker retaining up to size intervals. The first interval starts now.
+func (s *Plasma) NewStatsTracker(interval time.Duration, size int) *StatsTracker {
+	if size < 1 {
+		size = 1
+	}
+
+	return &StatsTracker{
+		s:        s,
+		interval: interval,
+		last:     s.GetStats(),
+		lastAt:   s.Clock.Now(),
+		ring:     make([]StatsInterval, size),
+	}
+}
+
+// Close the current interval and start a new one
+func (t *StatsTracker) Sample() StatsInterval {
+	sts := t.s.GetStats()
+	now := t.s.Clock.Now()
+
+	t.Lock()
+	defer t.Unlock()
+
+	iv := StatsInterval{Start: t.lastAt, End: now, Stats: sts}
+	if secs := now.Sub(t.lastAt).Seconds(); secs > 0 {
+		iv.Rates = statsRates(&t.last, &sts, secs)
+	}
+
+	iv.KeySizes = sts.KeySizes.Since(&t.last.KeySizes)
+	iv.ValueSizes = sts.ValueSizes.Since(&t.last.ValueSizes)
+
+	t.ring[t.pos] = iv
+	t.pos = (t.pos + 1) % len(t.ring)
+	if t.n < len(t.ring) {
+		t.n++
+	}
+
+	t.last, t.lastAt = sts, now
+	return iv
+}
+
+// Retained intervals, oldest first
+func (t *StatsTracker) Intervals() []StatsInterval {
+	t.Lock()
+	defer t.Unlock()
+
+	ivs := make([]StatsInterval, 0, t.n)
+	for i := t.n; i > 0; i-- {
+		ivs = append(ivs, t.ring[(t.pos-i+len(t.ring))%len(t.ring)])
+	}
+
+	return ivs
+}
+
+// Most recent interval
+func (t *StatsTracker) Latest() (StatsInterval, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.n == 0 {
+		return StatsInterval{}, false
+	}
+
+	return t.ring[(t.pos-1+len(t.ring))%len(t.ring)], true
+}
+
+// Sample the stats every interval until Stop is called or the store is
+// closed
+func (t *StatsTracker) Start() {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.stop != nil {
+		return
+	}
+
+	t.stop = make(chan struct{})
+	t.wg.Add(1)
+	go func(stop chan struct{}) {
+		defer t.wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.s.stopmon:
+				return
+			case <-t.s.Clock.After(t.interval):
+				t.Sample()
+			}
+		}
+	}(t.stop)
+}
+
+func (t *StatsTracker) Stop() {
+	t.Lock()
+	stop := t.stop
+	t.stop = nil
+	t.Unlock()
+
+	if stop != nil {
+		close(stop)
+		t.wg.Wait()
+	}
+}
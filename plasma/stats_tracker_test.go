@@ -0,0 +1,55 @@
+package plasma
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPlasmaStatsTracker(t *testing.T) {
+	clock := NewVirtualClock(time.Now())
+	cfg := testSnCfg
+	cfg.File = ""
+	cfg.Clock = clock
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	tr := s.NewStatsTracker(time.Second, 3)
+	if _, ok := tr.Latest(); ok {
+		t.Errorf("expected no intervals")
+	}
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), nil)
+	}
+
+	clock.Advance(time.Second * 2)
+	iv := tr.Sample()
+	if iv.Duration() != time.Second*2 || iv.Rates.Inserts != 500 || iv.Rates.Ops != 500 {
+		t.Errorf("unexpected interval %v %+v", iv.Duration(), iv.Rates)
+	}
+
+	tr.Start()
+	defer tr.Stop()
+
+	for i := 0; i < 1000 && len(tr.Intervals()) < 3; i++ {
+		clock.Advance(time.Second)
+		time.Sleep(time.Millisecond)
+	}
+
+	ivs := tr.Intervals()
+	if len(ivs) != 3 {
+		t.Fatalf("expected 3 intervals, got %d", len(ivs))
+	}
+
+	for i := 1; i < len(ivs); i++ {
+		if ivs[i].Start != ivs[i-1].End {
+			t.Errorf("expected contiguous intervals, got %v after %v", ivs[i].Start, ivs[i-1].End)
+		}
+	}
+
+	if latest, _ := tr.Latest(); latest.End != ivs[2].End || latest.Rates.Inserts != 0 {
+		t.Errorf("unexpected latest interval %+v", latest.Rates)
+	}
+}
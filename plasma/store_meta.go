@@ -0,0 +1,390 @@
+package plasma
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+var ErrCompareIdMismatch = errors.New("comparator identity does not match the store")
+var ErrStoreLocked = errors.New("store is locked by another process")
+var ErrAlreadyOpen = errors.New("store is already open in this process")
+var ErrFormatVersion = errors.New("store format version is not supported")
+var ErrConfigMismatch = errors.New("configuration does not match the store")
+var errStoreMetaCorrupt = errors.New("store metadata is corrupt")
+
+const (
+	storeMetaCompareId     = "compare_id"
+	storeMetaUUID          = "uuid"
+	storeMetaItemCodec     = "item_codec"
+	storeMetaFormatVersion = "format_version"
+	storeMetaConfig        = "config_fingerprint"
+
+	// Stores written before the format version was recorded are version 0.
+	// Version 2 adds operation markers to the log. Version 3 marks the item
+	// encoding with the metadata flag, which stores of version 1 and 2 were
+	// written with already. Items of version 0 stores decode the same
+	// unless their length overlaps the flag, which only items of 256MB or
+	// more do. Those items are rejected until the store is stamped with the
+	// version by its first write.
+	storeFormatVersion = 3
+
+	lockFileName = "LOCK"
+)
+
+// Store level metadata is kept as key value pairs in the log superblock
+type storeMeta struct {
+	sync.Mutex
+	kv map[string][]byte
+}
+
+func marshalStoreMeta(kv map[string][]byte) []byte {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := make([]byte, 2, 64)
+	binary.BigEndian.PutUint16(buf, uint16(len(keys)))
+	for _, k := range keys {
+		buf = appendMetaField(buf, []byte(k))
+		buf = appendMetaField(buf, kv[k])
+	}
+
+	return buf
+}
+
+func appendMetaField(buf, bs []byte) []byte {
+	var l [2]byte
+	binary.BigEndian.PutUint16(l[:], uint16(len(bs)))
+	buf = append(buf, l[:]...)
+	return append(buf, bs...)
+}
+
+func unmarshalStoreMeta(buf []byte) (map[string][]byte, error) {
+	kv := make(map[string][]byte)
+	if len(buf) == 0 {
+		return kv, nil
+	}
+
+	if len(buf) < 2 {
+		return nil, errStoreMetaCorrupt
+	}
+
+	n := int(binary.BigEndian.Uint16(buf))
+	buf = buf[2:]
+	for i := 0; i < n; i++ {
+		var k, v []byte
+		var err error
+		if k, buf, err = readMetaField(buf); err != nil {
+			return nil, err
+		}
+
+		if v, buf, err = readMetaField(buf); err != nil {
+			return nil, err
+		}
+
+		kv[string(k)] = v
+	}
+
+	return kv, nil
+}
+
+func readMetaField(buf []byte) ([]byte, []byte, error) {
+	if len(buf) < 2 {
+		return nil, nil, errStoreMetaCorrupt
+	}
+
+	l := int(binary.BigEndian.Uint16(buf))
+	if len(buf) < 2+l {
+		return nil, nil, errStoreMetaCorrupt
+	}
+
+	return append([]byte(nil), buf[2:2+l]...), buf[2+l:], nil
+}
+
+func (s *Plasma) loadStoreMeta() error {
+	kv, err := unmarshalStoreMeta(s.lss.GetMeta())
+	if err != nil {
+		return err
+	}
+
+	s.meta.kv = kv
+	return nil
+}
+
+func (s *Plasma) getStoreMeta(k string) ([]byte, bool) {
+	s.meta.Lock()
+	defer s.meta.Unlock()
+
+	v, ok := s.meta.kv[k]
+	return v, ok
+}
+
+// Updated metadata becomes durable with the next lss commit
+func (s *Plasma) setStoreMeta(k string, v []byte) error {
+	s.meta.Lock()
+	defer s.meta.Unlock()
+
+	s.meta.kv[k] = append([]byte(nil), v...)
+	return s.lss.SetMeta(marshalStoreMeta(s.meta.kv))
+}
+
+func (s *Plasma) deleteStoreMeta(k string) error {
+	s.meta.Lock()
+	defer s.meta.Unlock()
+
+	delete(s.meta.kv, k)
+	return s.lss.SetMeta(marshalStoreMeta(s.meta.kv))
+}
+
+func (s *Plasma) initUUID() error {
+	if _, ok := s.getStoreMeta(storeMetaUUID); ok {
+		return nil
+	}
+
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		return err
+	}
+
+	// RFC 4122 version 4 UUID
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return s.setStoreMeta(storeMetaUUID, uuid)
+}
+
+// Unique identifier of the store generated when it is created. Copies of
+// the store retain the same UUID.
+func (s *Plasma) UUID() string {
+	u, ok := s.getStoreMeta(storeMetaUUID)
+	if !ok {
+		return ""
+	}
+
+	return formatUUID(u)
+}
+
+func formatUUID(u []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// The comparator is checked only if both the store and the config have an
+// identity, hence the stores of the configs without one can be opened
+func (s *Plasma) checkCompareId() error {
+	id, ok := s.getStoreMeta(storeMetaCompareId)
+	if s.Config.CompareId == "" {
+		if len(id) > 0 {
+			fmt.Printf("Plasma: CompareId is not set, the comparator is not checked against the store (store: %q)\n",
+				string(id))
+		}
+		return nil
+	}
+
+	if !ok || len(id) == 0 {
+		return s.setStoreMeta(storeMetaCompareId, []byte(s.Config.CompareId))
+	}
+
+	if string(id) != s.Config.CompareId {
+		fmt.Printf("Plasma: Comparator identity mismatch (store: %q, config: %q)\n",
+			string(id), s.Config.CompareId)
+		if !s.Config.IgnoreCompareIdMismatch {
+			return ErrCompareIdMismatch
+		}
+	}
+
+	return nil
+}
+
+func (s *Plasma) checkFormatVersion() error {
+	v, ok := s.getStoreMeta(storeMetaFormatVersion)
+	if !ok && s.lss.TailOffset() != s.lss.HeadOffset() {
+		s.openLegacyFormat()
+		return nil
+	}
+
+	if ok {
+		version, err := strconv.Atoi(string(v))
+		if err != nil {
+			return errStoreMetaCorrupt
+		}
+
+		if version > storeFormatVersion {
+			fmt.Printf("Plasma: Unsupported format version (store: %d, supported: %d)\n",
+				version, storeFormatVersion)
+			return ErrFormatVersion
+		}
+
+		if version == storeFormatVersion {
+			return nil
+		}
+	}
+
+	return s.setStoreMeta(storeMetaFormatVersion, []byte(strconv.Itoa(storeFormatVersion)))
+}
+
+// Items of the default layout are read with the version 0 length, which has
+// no meta flag, until the store is stamped. The flag set by an item means
+// that its length does not fit the current layout, hence it is reported as
+// corrupt page data, which is rejected by the recovery or dropped by the
+// repair.
+func (s *Plasma) openLegacyFormat() {
+	atomic.StoreInt32(&s.legacyFormat, 1)
+	if reflect.ValueOf(s.Config.ItemDataSize).Pointer() != reflect.ValueOf(itemDataSize).Pointer() {
+		return
+	}
+
+	s.storeCtx.itemDataSize = func(data []byte) (int, bool) {
+		if atomic.LoadInt32(&s.legacyFormat) == 1 && len(data) >= itmHdrLen &&
+			*(*item)(unsafe.Pointer(&data[0]))&itmHasMetaFlag > 0 {
+			return 0, false
+		}
+
+		return itemDataSize(data)
+	}
+}
+
+// Stores written without a format version are stamped by the first write,
+// as the items written from then on may have the meta flag
+func (s *Plasma) stampFormatVersion() error {
+	if atomic.LoadInt32(&s.legacyFormat) == 0 || !atomic.CompareAndSwapInt32(&s.legacyFormat, 1, 0) {
+		return nil
+	}
+
+	return s.setStoreMeta(storeMetaFormatVersion, []byte(strconv.Itoa(storeFormatVersion)))
+}
+
+// Fingerprint of the settings which determine how the log is laid out and
+// interpreted. They cannot be changed once the store is created.
+func configFingerprint(cfg Config) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "segment_size=%d,snapshots=%v,cold_tier=%v",
+		cfg.LSSLogSegmentSize, cfg.EnableShapshots, cfg.ColdTier != nil)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func (s *Plasma) checkConfigFingerprint() error {
+	fp := configFingerprint(s.Config)
+	v, ok := s.getStoreMeta(storeMetaConfig)
+	if !ok {
+		return s.setStoreMeta(storeMetaConfig, []byte(fp))
+	}
+
+	if string(v) != fp {
+		fmt.Printf("Plasma: Configuration mismatch (store: %s, config: %s), LSSLogSegmentSize, "+
+			"EnableShapshots and ColdTier cannot be changed\n", string(v), fp)
+		return ErrConfigMismatch
+	}
+
+	return nil
+}
+
+// Superblock describes a persistent store. It is kept in the log header
+// and is updated atomically with every commit of the log.
+type Superblock struct {
+	UUID          string
+	FormatVersion int
+	LogVersion    uint32
+
+	// Valid range of the log
+	Head int64
+	Tail int64
+
+	CompareId         string
+	ItemCodec         string
+	ConfigFingerprint string
+}
+
+func newSuperblock(head, tail int64, kv map[string][]byte) (Superblock, error) {
+	sb := Superblock{
+		LogVersion:        GetLogVersion(),
+		Head:              head,
+		Tail:              tail,
+		CompareId:         string(kv[storeMetaCompareId]),
+		ItemCodec:         string(kv[storeMetaItemCodec]),
+		ConfigFingerprint: string(kv[storeMetaConfig]),
+	}
+
+	if u := kv[storeMetaUUID]; len(u) == 16 {
+		sb.UUID = formatUUID(u)
+	}
+
+	if v, ok := kv[storeMetaFormatVersion]; ok {
+		var err error
+		if sb.FormatVersion, err = strconv.Atoi(string(v)); err != nil {
+			return sb, errStoreMetaCorrupt
+		}
+	}
+
+	return sb, nil
+}
+
+// Superblock of the store. Changes become durable with the next commit of
+// the log.
+func (s *Plasma) Superblock() Superblock {
+	if !s.shouldPersist {
+		return Superblock{}
+	}
+
+	s.meta.Lock()
+	sb, _ := newSuperblock(int64(s.lss.HeadOffset()), int64(s.lss.TailOffset()), s.meta.kv)
+	s.meta.Unlock()
+	return sb
+}
+
+// Read the committed superblock of a store on disk without opening it, in
+// order to check that it can be opened with a configuration
+func ReadSuperblock(path string) (Superblock, error) {
+	var buf [logSBSize]byte
+
+	fd, err := os.Open(filepath.Join(path, headerFileName))
+	if err != nil {
+		return Superblock{}, err
+	}
+	defer fd.Close()
+
+	head, tail, _, meta, err := readLogSB(fd, buf[:])
+	if err != nil {
+		return Superblock{}, err
+	}
+
+	kv, err := unmarshalStoreMeta(meta)
+	if err != nil {
+		return Superblock{}, err
+	}
+
+	return newSuperblock(head, tail, kv)
+}
+
+// Check that a store on disk can be opened with the configuration
+func (sb Superblock) Check(cfg Config) error {
+	if sb.FormatVersion > storeFormatVersion {
+		return ErrFormatVersion
+	}
+
+	compareId := applyTunableDefaults(cfg).CompareId
+	if sb.CompareId != "" && compareId != "" && sb.CompareId != compareId && !cfg.IgnoreCompareIdMismatch {
+		return ErrCompareIdMismatch
+	}
+
+	if sb.ItemCodec != "" && cfg.ItemCodec != nil && sb.ItemCodec != cfg.ItemCodec.Id() {
+		return ErrItemCodecMismatch
+	}
+
+	if sb.ConfigFingerprint != "" && sb.ConfigFingerprint != configFingerprint(applyTunableDefaults(cfg)) {
+		return ErrConfigMismatch
+	}
+
+	return nil
+}
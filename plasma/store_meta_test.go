@@ -0,0 +1,338 @@
+package plasma
+
+import (
+	"fmt"
+	"github.com/couchbase/nitro/skiplist"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestStoreMetaMarshal(t *testing.T) {
+	kv := map[string][]byte{"a": []byte("1"), "bb": nil, "c": []byte("xyz")}
+	got, err := unmarshalStoreMeta(marshalStoreMeta(kv))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(got) != len(kv) {
+		t.Fatalf("expected %d keys, got %d", len(kv), len(got))
+	}
+
+	for k, v := range kv {
+		if string(got[k]) != string(v) {
+			t.Errorf("mismatch for %s: %s != %s", k, got[k], v)
+		}
+	}
+
+	if _, err := unmarshalStoreMeta([]byte{0, 1, 0, 5, 'a'}); err != errStoreMetaCorrupt {
+		t.Errorf("expected corrupt error, got %v", err)
+	}
+}
+
+func TestPlasmaCompareIdMismatch(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testCfg
+	cfg.CompareId = "int/1"
+	s := newTestIntPlasmaStore(cfg)
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	s.Close()
+
+	cfg.CompareId = "int/2"
+	if _, err := New(cfg); err != ErrCompareIdMismatch {
+		t.Fatalf("expected comparator mismatch error, got %v", err)
+	}
+
+	cfg.IgnoreCompareIdMismatch = true
+	s = newTestIntPlasmaStore(cfg)
+	s.Close()
+
+	// Comparator is not checked without an identity in the config
+	cfg.CompareId = ""
+	cfg.IgnoreCompareIdMismatch = false
+	s = newTestIntPlasmaStore(cfg)
+	s.Close()
+
+	cfg.CompareId = "int/1"
+	cfg.IgnoreCompareIdMismatch = false
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	itm := skiplist.NewIntKeyItem(500)
+	got, _ := s.NewWriter().Lookup(itm)
+	if skiplist.CompareInt(itm, got) != 0 {
+		t.Errorf("expected item 500 to be recovered")
+	}
+}
+
+func TestPlasmaCompareIdUnset(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	defer os.RemoveAll("teststore.data")
+
+	// Identity is recorded by the first open which has one
+	cfg := testCfg
+	cfg.CompareId = ""
+	s := newTestIntPlasmaStore(cfg)
+	if id := s.Superblock().CompareId; id != "" {
+		t.Errorf("expected no comparator identity, got %q", id)
+	}
+	s.Close()
+
+	cfg.CompareId = "int/1"
+	s = newTestIntPlasmaStore(cfg)
+	if id := s.Superblock().CompareId; id != "int/1" {
+		t.Errorf("expected comparator identity int/1, got %q", id)
+	}
+	s.Close()
+
+	cfg.CompareId = "int/2"
+	if _, err := New(cfg); err != ErrCompareIdMismatch {
+		t.Errorf("expected comparator mismatch error, got %v", err)
+	}
+}
+
+func TestPlasmaLockAndUUID(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	uuid := s.UUID()
+	if len(uuid) != 36 {
+		t.Errorf("invalid uuid %s", uuid)
+	}
+
+	if _, err := New(testCfg); err != ErrAlreadyOpen {
+		t.Errorf("expected already open error, got %v", err)
+	}
+
+	cfg := testCfg
+	cfg.File = "./" + testCfg.File + "/"
+	if _, err := New(cfg); err != ErrAlreadyOpen {
+		t.Errorf("expected already open error for %s, got %v", cfg.File, err)
+	}
+	s.Close()
+
+	s = newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+	if s.UUID() != uuid {
+		t.Errorf("expected uuid %s, got %s", uuid, s.UUID())
+	}
+}
+
+func TestPlasmaConcurrentOpen(t *testing.T) {
+	os.RemoveAll("teststore.data")
+
+	var wg sync.WaitGroup
+	stores := make([]*Plasma, 8)
+	errs := make([]error, len(stores))
+	for i := range stores {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stores[i], errs[i] = New(testCfg)
+		}(i)
+	}
+	wg.Wait()
+
+	var opened int
+	for i, s := range stores {
+		if errs[i] == nil {
+			opened++
+			s.Close()
+		} else if errs[i] != ErrAlreadyOpen {
+			t.Errorf("expected already open error, got %v", errs[i])
+		}
+	}
+
+	if opened != 1 {
+		t.Errorf("expected the store to be opened once, got %d", opened)
+	}
+
+	s := newTestIntPlasmaStore(testCfg)
+	s.Close()
+}
+
+func TestPlasmaReopenAfterFailedOpen(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	s.setStoreMeta(storeMetaRollback, []byte("corrupt"))
+	s.Close()
+
+	// A failed open releases the directory for the next one
+	for i := 0; i < 2; i++ {
+		if _, err := New(testSnCfg); err != errStoreMetaCorrupt {
+			t.Fatalf("expected corrupt store meta error, got %v", err)
+		}
+	}
+	os.RemoveAll("teststore.data")
+}
+
+func TestPlasmaSuperblock(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testCfg
+	cfg.CompareId = "int/1"
+	s := newTestIntPlasmaStore(cfg)
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	s.PersistAll()
+	sb := s.Superblock()
+	s.Close()
+
+	if sb.UUID != s.UUID() || sb.FormatVersion != storeFormatVersion || sb.CompareId != "int/1" ||
+		sb.ConfigFingerprint != configFingerprint(applyTunableDefaults(cfg)) || sb.Tail <= sb.Head {
+		t.Errorf("unexpected superblock %+v", sb)
+	}
+
+	got, err := ReadSuperblock(cfg.File)
+	if err != nil || got.UUID != sb.UUID || got.Tail != sb.Tail || got.ConfigFingerprint != sb.ConfigFingerprint {
+		t.Errorf("unexpected superblock on disk %+v (err=%v), expected %+v", got, err, sb)
+	}
+
+	if err := got.Check(cfg); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	cfg2 := cfg
+	cfg2.LSSLogSegmentSize = 1024 * 1024 * 1024
+	if err := got.Check(cfg2); err != ErrConfigMismatch {
+		t.Errorf("expected config mismatch error, got %v", err)
+	}
+
+	if _, err := New(cfg2); err != ErrConfigMismatch {
+		t.Fatalf("expected config mismatch error, got %v", err)
+	}
+
+	got.FormatVersion = storeFormatVersion + 1
+	if err := got.Check(cfg); err != ErrFormatVersion {
+		t.Errorf("expected format version error, got %v", err)
+	}
+
+	got.FormatVersion = 0
+	if err := got.Check(cfg); err != nil {
+		t.Errorf("unexpected error for version 0 %v", err)
+	}
+
+	if _, err := ReadSuperblock("nonexistent.data"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestPlasmaFormatVersionUpgrade(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i))
+	}
+	s.PersistAll()
+
+	// Version 2 stores have the current item encoding
+	s.setStoreMeta(storeMetaFormatVersion, []byte("2"))
+	s.Close()
+
+	s = newTestIntPlasmaStore(testCfg)
+	if v := s.Superblock().FormatVersion; v != storeFormatVersion {
+		t.Errorf("expected format version %d, got %d", storeFormatVersion, v)
+	}
+
+	// Version 0 stores are opened and stamped by the first write
+	s.deleteStoreMeta(storeMetaFormatVersion)
+	s.Close()
+
+	s = newTestIntPlasmaStore(testCfg)
+	if v := s.Superblock().FormatVersion; v != 0 {
+		t.Errorf("expected format version 0, got %d", v)
+	}
+
+	w = s.NewWriter()
+	w.Insert(skiplist.NewIntKeyItem(1000))
+	if v := s.Superblock().FormatVersion; v != storeFormatVersion {
+		t.Errorf("expected format version %d, got %d", storeFormatVersion, v)
+	}
+	s.Close()
+	os.RemoveAll("teststore.data")
+}
+
+// Store written by a release which did not record the format version
+func TestPlasmaFormatVersion0Store(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	defer os.RemoveAll("teststore.data")
+
+	os.MkdirAll("teststore.data", 0755)
+	files, _ := filepath.Glob("testdata/v0store/*")
+	for _, f := range files {
+		bs, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join("teststore.data", filepath.Base(f)), bs, 0755); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.File = "teststore.data"
+	cfg.AutoLSSCleaning = false
+	if sb, err := ReadSuperblock(cfg.File); err != nil || sb.FormatVersion != 0 || sb.Check(cfg) != nil {
+		t.Fatalf("unexpected superblock %+v, %v", sb, err)
+	}
+
+	check := func(s *Plasma) {
+		w := s.NewWriter()
+		for i := 0; i < 1000; i++ {
+			v, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i)))
+			if i%10 == 0 && err != ErrItemNotFound {
+				t.Errorf("expected key-%d to be deleted, got %v", i, err)
+			} else if exp := fmt.Sprintf("val-%d", i); i%10 != 0 && (err != nil || string(v) != exp) {
+				t.Errorf("expected %s, got %s, %v", exp, v, err)
+			}
+		}
+	}
+
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	check(s)
+
+	// Items overlapping the meta flag are not read until the store is stamped
+	hdr := make([]byte, 32)
+	*(*uint32)(unsafe.Pointer(&hdr[0])) = itmInsertFlag | itmHasMetaFlag
+	if _, ok := s.itemDataSize(hdr); ok {
+		t.Errorf("expected the item overlapping the meta flag to be rejected")
+	}
+
+	w := s.NewWriter()
+	if _, err := w.InsertKVMeta([]byte("key-meta"), []byte("val"), 1); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	s.PersistAll()
+
+	if _, ok := s.itemDataSize(hdr); !ok {
+		t.Errorf("expected the item with the meta flag to be read once stamped")
+	}
+	s.Close()
+
+	s, err = New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer s.Close()
+
+	if v := s.Superblock().FormatVersion; v != storeFormatVersion {
+		t.Errorf("expected format version %d, got %d", storeFormatVersion, v)
+	}
+	check(s)
+
+	w = s.NewWriter()
+	if v, meta, err := w.LookupKVMeta([]byte("key-meta")); err != nil || string(v) != "val" || meta != 1 {
+		t.Errorf("expected val with meta 1, got %s, %d, %v", v, meta, err)
+	}
+}
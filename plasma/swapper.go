@@ -1,8 +1,8 @@
 package plasma
 
 import (
+	"fmt"
 	"github.com/couchbase/nitro/skiplist"
-	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -15,7 +15,7 @@ const (
 )
 
 type clockHandle struct {
-	buf []byte
+	buf *Buffer
 	pos unsafe.Pointer
 	itr *skiplist.Iterator
 }
@@ -47,7 +47,8 @@ func (s *Plasma) sweepClock(h *clockHandle) []PageId {
 	if h.itr.Valid() {
 		itm := h.itr.Get()
 		sz := s.itemSize(itm)
-		h.pos = unsafe.Pointer(&h.buf[0])
+		h.buf.Grow(0, int(sz))
+		h.pos = h.buf.Ptr(0)
 		memcopy(h.pos, itm, int(sz))
 	} else {
 		h.pos = nil
@@ -64,7 +65,7 @@ func (s *Plasma) tryEvictPages(ctx *wCtx) {
 		pids := s.sweepClock(h)
 		s.releaseClockHandle(h)
 		for _, pid := range pids {
-			if s.canEvict(pid) {
+			if s.canEvict(pid) && !(s.PartialEviction && s.PersistDeltas(pid, ctx)) {
 				s.Persist(pid, true, ctx)
 			}
 		}
@@ -74,49 +75,75 @@ func (s *Plasma) tryEvictPages(ctx *wCtx) {
 
 func (s *Plasma) initLRUClock() {
 	s.clockHandle = &clockHandle{
-		buf: make([]byte, maxPageEncodedSize),
+		buf: newBuffer(initBufferSize),
 		itr: s.Skiplist.NewIterator2(s.cmp,
 			s.Skiplist.MakeBuf()),
 	}
 }
 
 func (s *Plasma) swapperDaemon() {
-	var wg sync.WaitGroup
-
-	killch := make(chan struct{})
-	ddur := NewDecayInterval(swapperWaitInterval, time.Second)
-
-	for i := 0; i < s.NumEvictorThreads; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
-			sctx := s.evictWriters[i].SwapperContext()
-			for {
-				select {
-				case <-killch:
-					s.trySMRObjects(s.evictWriters[i], 0)
-					return
-				default:
-				}
-
-				if s.TriggerSwapper(sctx) {
-					s.tryEvictPages(s.evictWriters[i])
-					s.trySMRObjects(s.evictWriters[i], swapperSMRInterval)
-					ddur.Reset()
-				} else {
-					ddur.Sleep()
-				}
+	var killchs, donechs []chan struct{}
+
+	ddur := NewDecayIntervalWithClock(swapperWaitInterval, time.Second, s.Clock)
+
+	evictor := func(w *wCtx, killch chan struct{}, ws *workerState) {
+		sctx := w.SwapperContext()
+		for {
+			ws.beat()
+			select {
+			case <-killch:
+				s.trySMRObjects(w, 0)
+				return
+			default:
 			}
-		}(i)
+
+			if s.TriggerSwapper(sctx) {
+				ws.doing("evicting")
+				s.tryEvictPages(w)
+				s.trySMRObjects(w, swapperSMRInterval)
+				ddur.Reset()
+			} else {
+				ws.wait(time.Second)
+				ddur.Sleep()
+			}
+		}
 	}
 
-	go func() {
-		<-s.stopswapper
-		close(killch)
-	}()
+	// Evictor threads are added or removed as per the current config
+	resize := func(n int) {
+		_, evictWriters := s.getWorkerCtxs()
+		for len(killchs) < n {
+			killch, donech := make(chan struct{}), make(chan struct{})
+			name := fmt.Sprintf("evictor-%d", len(killchs))
+			go func(w *wCtx) {
+				defer close(donech)
+				s.superviseWorker(name, func(ws *workerState) {
+					evictor(w, killch, ws)
+				})
+			}(evictWriters[len(killchs)])
+			killchs = append(killchs, killch)
+			donechs = append(donechs, donech)
+		}
 
-	wg.Wait()
+		for len(killchs) > n {
+			last := len(killchs) - 1
+			close(killchs[last])
+			<-donechs[last]
+			killchs, donechs = killchs[:last], donechs[:last]
+		}
+	}
+
+loop:
+	for {
+		resize(s.numEvictorThreads())
+		select {
+		case <-s.stopswapper:
+			break loop
+		case <-s.Clock.After(time.Second):
+		}
+	}
 
+	resize(0)
 	s.stopswapper <- struct{}{}
 }
 
@@ -137,4 +164,5 @@ func (s *Plasma) canEvict(pid PageId) bool {
 
 func (s *Plasma) updateCacheMeta(pid PageId) {
 	pid.(*skiplist.Node).Cache = 1
+	s.recordPageAccess(pid)
 }
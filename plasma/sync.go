@@ -0,0 +1,66 @@
+package plasma
+
+import "sync/atomic"
+
+func (s *Plasma) lssSyncDaemon() {
+	s.superviseWorker("lss_sync", s.lssSyncer)
+}
+
+// Commits the log every SyncPeriod, so that the log written since the
+// last commit becomes durable without waiting for more writes. A change
+// of SyncPeriod restarts the wait with the new period.
+func (s *Plasma) lssSyncer(ws *workerState) {
+	for {
+		interval := s.liveConfig().SyncPeriod
+		ws.beat()
+		ws.wait(interval)
+		select {
+		case <-s.stopsync:
+			s.stopsync <- struct{}{}
+			return
+		case <-s.syncPeriodUpdated:
+			continue
+		case <-s.Clock.After(interval):
+		}
+
+		ws.doing("syncing")
+		s.syncLSS()
+	}
+}
+
+func (s *Plasma) syncLSS() {
+	sn := atomic.LoadUint64(&s.persistedSn)
+	s.lss.Sync(true)
+	storeMaxUint64(&s.durableSn, sn)
+}
+
+// Sn of the last snapshot whose items are written by PersistAll
+func (s *Plasma) persistSn() uint64 {
+	if !s.EnableShapshots {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.currSn) - 1
+}
+
+func (s *Plasma) setPersistedSn(sn uint64) {
+	storeMaxUint64(&s.persistedSn, sn)
+	if s.SyncPeriod == 0 {
+		storeMaxUint64(&s.durableSn, sn)
+	}
+}
+
+// Sn of the last snapshot which is recovered after a crash. Items of the
+// snapshot were written by PersistAll and committed to the log since.
+func (s *Plasma) LastDurableSn() uint64 {
+	return atomic.LoadUint64(&s.durableSn)
+}
+
+func storeMaxUint64(addr *uint64, v uint64) {
+	for {
+		curr := atomic.LoadUint64(addr)
+		if v <= curr || atomic.CompareAndSwapUint64(addr, curr, v) {
+			return
+		}
+	}
+}
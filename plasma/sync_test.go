@@ -0,0 +1,50 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPlasmaSyncInterval(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.SyncPeriod = 20 * time.Millisecond
+	s := newTestIntPlasmaStore(cfg)
+
+	w := s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("val"))
+	}
+
+	snap := s.NewSnapshot()
+	sn := snap.sn
+	snap.Close()
+	if s.LastDurableSn() >= sn {
+		t.Fatalf("expected sn %d not to be durable before persist, got %d", sn, s.LastDurableSn())
+	}
+
+	s.PersistAll()
+	deadline := time.Now().Add(10 * time.Second)
+	for s.LastDurableSn() < sn {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected sn %d to become durable, got %d", sn, s.LastDurableSn())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	s.Close()
+
+	s = newTestIntPlasmaStore(cfg)
+	defer s.Close()
+	if s.LastDurableSn() < sn {
+		t.Errorf("expected recovered sn %d to be durable, got %d", sn, s.LastDurableSn())
+	}
+
+	w = s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		if _, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i))); err != nil {
+			t.Fatalf("unexpected error %v for item %d", err, i)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package plasma
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+var tierIndexFileName = "tier.index"
+
+const (
+	defaultTierFetchBlockSize = 1024 * 1024
+	defaultTierInterval       = time.Minute
+)
+
+// ObjectStore is the interface to an S3 compatible object store used as
+// the cold tier
+type ObjectStore interface {
+	Put(key string, r io.Reader, size int64) error
+	// Read len(buf) bytes of the object starting at offset
+	GetRange(key string, offset int64, buf []byte) error
+	Delete(key string) error
+}
+
+// Log segments which are not written for MinSegmentAge are moved to the
+// object store. Pages in the moved segments are read through an in-memory
+// cache of the remote data.
+type ColdTierConfig struct {
+	Store ObjectStore
+	// Prefix of the object keys. It should be unique for the store.
+	Prefix string
+
+	MinSegmentAge time.Duration
+	// Number of the most recent segments which are always kept locally
+	LocalSegments int
+	// How often segments are checked for demotion
+	Interval time.Duration
+
+	// Remote reads are fetched in blocks of FetchBlockSize bytes. Up to
+	// CacheSize bytes of the fetched blocks are cached.
+	FetchBlockSize int
+	CacheSize      int64
+	// Maximum remote fetches per second. Zero is unlimited.
+	MaxFetchRate int
+}
+
+type tierBlockKey struct {
+	key   string
+	block int64
+}
+
+type tierBlock struct {
+	k    tierBlockKey
+	data []byte
+}
+
+type coldTier struct {
+	cfg ColdTierConfig
+	log *multiFilelog
+
+	sync.Mutex
+	cache     map[tierBlockKey]*list.Element
+	lru       *list.List
+	cacheSize int64
+	nextFetch time.Time
+
+	numSegments int64
+	fetches     int64
+	fetchBytes  int64
+	cacheHits   int64
+}
+
+func newColdTier(cfg ColdTierConfig) *coldTier {
+	if cfg.FetchBlockSize <= 0 {
+		cfg.FetchBlockSize = defaultTierFetchBlockSize
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultTierInterval
+	}
+
+	if cfg.LocalSegments < 1 {
+		cfg.LocalSegments = 1
+	}
+
+	return &coldTier{
+		cfg:   cfg,
+		cache: make(map[tierBlockKey]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+func newTieredLSStore(path string, segSize int64, bufSize int, nbufs int,
+	commitDur time.Duration, cfg ColdTierConfig) (LSS, *coldTier, error) {
+
+	tier := newColdTier(cfg)
+	log, err := openLog(path, segSize, commitDur == 0, false, tier)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newLSStoreWithLog(log, path, segSize, bufSize, nbufs, commitDur), tier, nil
+}
+
+func (t *coldTier) objectKey(segId int64) string {
+	return fmt.Sprintf("%s"+segFileNameFormat, t.cfg.Prefix, segId)
+}
+
+func (t *coldTier) throttle() {
+	if t.cfg.MaxFetchRate <= 0 {
+		return
+	}
+
+	interval := time.Second / time.Duration(t.cfg.MaxFetchRate)
+	t.Lock()
+	now := time.Now()
+	if t.nextFetch.Before(now) {
+		t.nextFetch = now
+	}
+	wait := t.nextFetch.Sub(now)
+	t.nextFetch = t.nextFetch.Add(interval)
+	t.Unlock()
+
+	time.Sleep(wait)
+}
+
+func (t *coldTier) getBlock(k tierBlockKey, segSize int64) ([]byte, error) {
+	t.Lock()
+	if e, ok := t.cache[k]; ok {
+		t.lru.MoveToFront(e)
+		t.Unlock()
+		atomic.AddInt64(&t.cacheHits, 1)
+		return e.Value.(*tierBlock).data, nil
+	}
+	t.Unlock()
+
+	bs := int64(t.cfg.FetchBlockSize)
+	size := bs
+	if rem := segSize - k.block*bs; rem < size {
+		size = rem
+	}
+
+	t.throttle()
+	data := make([]byte, size)
+	if err := t.cfg.Store.GetRange(k.key, k.block*bs, data); err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&t.fetches, 1)
+	atomic.AddInt64(&t.fetchBytes, size)
+
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.cache[k]; !ok && t.cfg.CacheSize > 0 {
+		t.cache[k] = t.lru.PushFront(&tierBlock{k: k, data: data})
+		t.cacheSize += size
+		for t.cacheSize > t.cfg.CacheSize {
+			e := t.lru.Back()
+			b := e.Value.(*tierBlock)
+			t.lru.Remove(e)
+			delete(t.cache, b.k)
+			t.cacheSize -= int64(len(b.data))
+		}
+	}
+
+	return data, nil
+}
+
+// Read from a segment stored in the object store
+func (t *coldTier) read(key string, segSize int64, bs []byte, off int64) error {
+	bsz := int64(t.cfg.FetchBlockSize)
+	for len(bs) > 0 {
+		k := tierBlockKey{key: key, block: off / bsz}
+		data, err := t.getBlock(k, segSize)
+		if err != nil {
+			return err
+		}
+
+		n := copy(bs, data[off-k.block*bsz:])
+		bs = bs[n:]
+		off += int64(n)
+	}
+
+	return nil
+}
+
+func (t *coldTier) evictCache(key string) {
+	t.Lock()
+	defer t.Unlock()
+
+	for k, e := range t.cache {
+		if k.key == key {
+			t.lru.Remove(e)
+			delete(t.cache, k)
+			t.cacheSize -= int64(len(e.Value.(*tierBlock).data))
+		}
+	}
+}
+
+// Segment ids and object keys of the segments in the object store
+func readTierIndex(path string) (map[int64]string, error) {
+	segs := make(map[int64]string)
+	f, err := os.Open(filepath.Join(path, tierIndexFileName))
+	if os.IsNotExist(err) {
+		return segs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var id int64
+		var key string
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %s", &id, &key); err != nil {
+			return nil, err
+		}
+		segs[id] = key
+	}
+
+	return segs, scanner.Err()
+}
+
+func writeTierIndex(path string, segs map[int64]string) error {
+	var ids []int64
+	for id := range segs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	tmpFile := filepath.Join(path, tierIndexFileName+".tmp")
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, id := range ids {
+		fmt.Fprintf(w, "%d %s\n", id, segs[id])
+	}
+
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, filepath.Join(path, tierIndexFileName))
+}
+
+// Move the cold segments to the object store. A segment is uploaded and
+// recorded in the tier index before the local file is removed.
+func (l *multiFilelog) demoteSegments(stop <-chan struct{}) (int, error) {
+	l.tierLock.Lock()
+	defer l.tierLock.Unlock()
+
+	if l.closed {
+		return 0, nil
+	}
+
+	t := l.tier
+	if err := l.deleteRemoteSegments(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	idx := l.getIndex()
+	last := len(idx.index) - t.cfg.LocalSegments
+	for i := 0; i < last; i++ {
+		select {
+		case <-stop:
+			return n, nil
+		default:
+		}
+
+		lf := idx.index[i]
+		segId := idx.startOffset/l.segmentSize + int64(i)
+		if lf.remote != "" || (segId+1)*l.segmentSize > l.Tail() {
+			continue
+		}
+
+		fi, err := lf.fd.Stat()
+		if err == nil && time.Since(fi.ModTime()) < t.cfg.MinSegmentAge {
+			continue
+		}
+
+		key := t.objectKey(segId)
+		if err == nil {
+			if err = lf.fd.Sync(); err == nil {
+				r := io.NewSectionReader(lf.fd, 0, l.segmentSize)
+				err = t.cfg.Store.Put(key, r, l.segmentSize)
+			}
+		}
+
+		if err != nil {
+			// The file is closed if the segment has been trimmed
+			if segId < l.getIndex().startOffset/l.segmentSize {
+				continue
+			}
+			return n, err
+		}
+
+		l.tierSegs[segId] = key
+		if err := writeTierIndex(l.basePath, l.tierSegs); err != nil {
+			delete(l.tierSegs, segId)
+			return n, err
+		}
+
+		atomic.AddInt64(&t.numSegments, 1)
+		if l.replaceSegment(segId, key) {
+			n++
+		} else {
+			// Trimmed while it was uploaded
+			l.tierGCLock.Lock()
+			l.tierGC = append(l.tierGC, key)
+			l.tierGCLock.Unlock()
+		}
+	}
+
+	return n, nil
+}
+
+// Switch the segment to the copy in the cold tier and remove the local
+// file. Readers using the previous index retry on the closed file.
+func (l *multiFilelog) replaceSegment(segId int64, key string) bool {
+	l.idxLock.Lock()
+	defer l.idxLock.Unlock()
+
+	idx := l.getIndex()
+	pos := segId - idx.startOffset/l.segmentSize
+	if pos < 0 {
+		return false
+	}
+
+	lf := idx.index[pos]
+	newIdx := *idx
+	newIdx.index = append([]*logFile(nil), idx.index...)
+	newIdx.index[pos] = &logFile{remote: key}
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&l.index)), unsafe.Pointer(&newIdx))
+
+	name := lf.fd.Name()
+	lf.Close()
+	os.Remove(name)
+	return true
+}
+
+// Delete the objects of the segments removed from the log by trimming
+func (l *multiFilelog) deleteRemoteSegments() error {
+	l.tierGCLock.Lock()
+	keys := l.tierGC
+	l.tierGC = nil
+	l.tierGCLock.Unlock()
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	for _, key := range keys {
+		for id, k := range l.tierSegs {
+			if k == key {
+				delete(l.tierSegs, id)
+			}
+		}
+	}
+
+	if err := writeTierIndex(l.basePath, l.tierSegs); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		l.tier.evictCache(key)
+		if err := l.tier.cfg.Store.Delete(key); err != nil {
+			return err
+		}
+		atomic.AddInt64(&l.tier.numSegments, -1)
+	}
+
+	return nil
+}
+
+func (s *Plasma) coldTierDaemon(ws *workerState) {
+	for {
+		ws.beat()
+		ws.doing("demoting")
+		if _, err := s.coldTier.log.demoteSegments(s.stopmon); err != nil {
+			fmt.Printf("Plasma: Cold tier: failed to demote segments (err=%v)\n", err)
+		}
+
+		ws.wait(s.coldTier.cfg.Interval)
+		select {
+		case <-s.stopmon:
+			return
+		case <-s.Clock.After(s.coldTier.cfg.Interval):
+		}
+	}
+}
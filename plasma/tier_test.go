@@ -0,0 +1,153 @@
+package plasma
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type memObjectStore struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemObjectStore() *memObjectStore {
+	return &memObjectStore{objects: make(map[string][]byte)}
+}
+
+func (m *memObjectStore) Put(key string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if int64(len(data)) != size {
+		return fmt.Errorf("expected %d bytes, got %d", size, len(data))
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memObjectStore) GetRange(key string, offset int64, buf []byte) error {
+	m.Lock()
+	defer m.Unlock()
+
+	data, ok := m.objects[key]
+	if !ok || offset+int64(len(buf)) > int64(len(data)) {
+		return fmt.Errorf("object %s range %d:%d not found", key, offset, len(buf))
+	}
+
+	copy(buf, data[offset:])
+	return nil
+}
+
+func (m *memObjectStore) Delete(key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memObjectStore) count() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.objects)
+}
+
+func TestPlasmaColdTier(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	objStore := newMemObjectStore()
+
+	cfg := DefaultConfig()
+	cfg.File = "teststore.data"
+	cfg.AutoLSSCleaning = false
+	cfg.LSSLogSegmentSize = 1024 * 1024
+	cfg.FlushBufferSize = 256 * 1024
+	cfg.ColdTier = &ColdTierConfig{
+		Store:          objStore,
+		Prefix:         "test/",
+		LocalSegments:  1,
+		FetchBlockSize: 64 * 1024,
+		CacheSize:      1024 * 1024,
+		MaxFetchRate:   100000,
+	}
+
+	s := newTestIntPlasmaStore(cfg)
+
+	n := 100000
+	w := s.NewWriter()
+	for i := 0; i < n; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+	}
+
+	s.EvictAll()
+	s.PersistAll()
+
+	stop := make(chan struct{})
+	log := s.lss.(*lsStore).log.(*multiFilelog)
+	demoted, err := log.demoteSegments(stop)
+	if err != nil || demoted == 0 || objStore.count() != demoted {
+		t.Fatalf("expected segments to be demoted, got %d (err=%v)", demoted, err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(cfg.File, segFilePattern))
+	if len(files) != 1 {
+		t.Errorf("expected 1 local segment, got %d", len(files))
+	}
+
+	verify := func(s *Plasma) {
+		w := s.NewWriter()
+		for i := 0; i < n; i++ {
+			if v, err := w.LookupKV([]byte(fmt.Sprintf("key-%10d", i))); err != nil || string(v) != fmt.Sprintf("val-%10d", i) {
+				t.Errorf("expected value for %d, got %s (err=%v)", i, string(v), err)
+				return
+			}
+		}
+	}
+
+	verify(s)
+	sts := s.GetStats()
+	if sts.ColdSegments != int64(demoted) || sts.ColdFetches == 0 || sts.ColdCacheHits == 0 {
+		t.Errorf("unexpected cold tier stats %d segments, %d fetches, %d hits",
+			sts.ColdSegments, sts.ColdFetches, sts.ColdCacheHits)
+	}
+	s.Close()
+
+	s = newTestIntPlasmaStore(cfg)
+	verify(s)
+
+	// Segments trimmed by the cleaner are deleted from the object store
+	w = s.NewWriter()
+	for i := 0; i < n; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%10d", i)))
+	}
+	s.PersistAll()
+	s.CleanLSS(func() bool { return true })
+	s.PersistAll()
+
+	log = s.lss.(*lsStore).log.(*multiFilelog)
+	log.demoteSegments(stop)
+	if log.Head() < int64(demoted)*cfg.LSSLogSegmentSize {
+		t.Errorf("expected the demoted segments to be cleaned, head %d", log.Head())
+	}
+
+	for id, key := range log.tierSegs {
+		if (id+1)*cfg.LSSLogSegmentSize <= log.Head() {
+			t.Errorf("expected trimmed segment %s to be deleted", key)
+		}
+	}
+
+	if sts := s.GetStats(); objStore.count() != int(sts.ColdSegments) {
+		t.Errorf("expected %d objects, got %d", sts.ColdSegments, objStore.count())
+	}
+
+	verify(s)
+	s.Close()
+}
@@ -0,0 +1,133 @@
+package plasma
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const txTokenStackSize = 4096
+
+// Diagnostics for a transaction token held longer than MaxTxTokenAge
+type TxTokenInfo struct {
+	Age      time.Duration
+	Stack    string
+	Released bool
+}
+
+type txTokenState struct {
+	start    time.Time
+	stack    []byte
+	ctx      *wCtx
+	reported bool
+	released bool
+}
+
+// Transaction tokens are tracked only if MaxTxTokenAge is set. A token
+// which is never ended blocks reclamation of the objects freed by all the
+// writers after the token was acquired.
+type txTokenTracker struct {
+	sync.Mutex
+	tokens map[TxToken]*txTokenState
+
+	abandoned int64
+	released  int64
+}
+
+func (s *Plasma) trackTxToken(t TxToken, ctx *wCtx) {
+	st := &txTokenState{start: s.Clock.Now(), ctx: ctx}
+	if s.CaptureTxTokenStacks {
+		st.stack = make([]byte, txTokenStackSize)
+		st.stack = st.stack[:runtime.Stack(st.stack, false)]
+	}
+
+	s.txTokens.Lock()
+	s.txTokens.tokens[t] = st
+	s.txTokens.Unlock()
+}
+
+// Returns false if the token has already been released by the monitor
+func (s *Plasma) untrackTxToken(t TxToken) bool {
+	s.txTokens.Lock()
+	defer s.txTokens.Unlock()
+
+	st, ok := s.txTokens.tokens[t]
+	if !ok {
+		return true
+	}
+
+	delete(s.txTokens.tokens, t)
+	return !st.released
+}
+
+// Tokens held longer than MaxTxTokenAge, oldest first
+func (s *Plasma) TxTokenDiagnostics() []TxTokenInfo {
+	var infos []TxTokenInfo
+
+	now := s.Clock.Now()
+	s.txTokens.Lock()
+	for _, st := range s.txTokens.tokens {
+		if age := now.Sub(st.start); age > s.MaxTxTokenAge {
+			infos = append(infos, TxTokenInfo{Age: age, Stack: string(st.stack), Released: st.released})
+		}
+	}
+	s.txTokens.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Age > infos[j].Age
+	})
+
+	return infos
+}
+
+func (s *Plasma) checkTxTokens() {
+	var abandoned int64
+
+	now := s.Clock.Now()
+	s.txTokens.Lock()
+	defer s.txTokens.Unlock()
+
+	for t, st := range s.txTokens.tokens {
+		age := now.Sub(st.start)
+		if st.released || age <= s.MaxTxTokenAge {
+			continue
+		}
+
+		abandoned++
+		if !st.reported {
+			st.reported = true
+			fmt.Printf("Plasma: TxToken held for %v blocks memory reclamation\n%s\n", age, st.stack)
+		}
+
+		if s.ReleaseAbandonedTxTokens {
+			st.released = true
+			st.ctx.safeOffset = expiredLSSOffset
+			s.Skiplist.GetAccesBarrier().Release(t)
+			atomic.AddInt64(&s.txTokens.released, 1)
+		}
+	}
+
+	atomic.StoreInt64(&s.txTokens.abandoned, abandoned)
+}
+
+func (s *Plasma) txTokenMonitor(ws *workerState) {
+	interval := s.MaxTxTokenAge / 4
+	if interval < time.Millisecond*10 {
+		interval = time.Millisecond * 10
+	}
+
+	for {
+		ws.beat()
+		ws.wait(interval)
+		select {
+		case <-s.stopmon:
+			return
+		case <-s.Clock.After(interval):
+		}
+
+		s.checkTxTokens()
+	}
+}
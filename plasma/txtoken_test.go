@@ -0,0 +1,52 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlasmaTxTokenAge(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	cfg := testSnCfg
+	cfg.MaxTxTokenAge = time.Millisecond * 50
+	cfg.CaptureTxTokenStacks = true
+	cfg.ReleaseAbandonedTxTokens = true
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), nil)
+	}
+
+	snap := s.NewSnapshot()
+	defer snap.Close()
+
+	itr := snap.NewIterator()
+	itr.SeekFirst()
+
+	time.Sleep(time.Millisecond * 200)
+
+	infos := s.TxTokenDiagnostics()
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 abandoned token, got %d", len(infos))
+	}
+
+	if !infos[0].Released || infos[0].Age < cfg.MaxTxTokenAge ||
+		!strings.Contains(infos[0].Stack, "TestPlasmaTxTokenAge") {
+		t.Errorf("unexpected diagnostics %+v", infos[0])
+	}
+
+	if sts := s.GetStats(); sts.ReleasedTxTokens != 1 {
+		t.Errorf("expected 1 released token, got %d", sts.ReleasedTxTokens)
+	}
+
+	// Token is not released again
+	itr.Close()
+	if len(s.TxTokenDiagnostics()) != 0 {
+		t.Errorf("expected no tokens")
+	}
+}
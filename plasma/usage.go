@@ -0,0 +1,179 @@
+package plasma
+
+import (
+	"context"
+	"fmt"
+	"github.com/couchbase/nitro/mm"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Breakdown of the memory and disk space used by the store
+type Usage struct {
+	ResidentPages int64
+	EvictedPages  int64
+
+	// Resident page memory
+	BasePageBytes int64
+	DeltaBytes    int64
+	IndexBytes    int64
+
+	// Page encoding, flush and clock buffers
+	BufferBytes int64
+
+	// Process wide memory held by the allocator in excess of the memory
+	// in use by all the open stores
+	AllocatorBytes    int64
+	AllocatorOverhead int64
+
+	LSSLiveBytes  int64
+	LSSStaleBytes int64
+
+	// Resident item versions retained only because of recovery points
+	RecoveryPointPinnedBytes int64
+}
+
+func (u Usage) String() string {
+	return fmt.Sprintf("resident_pages=%d evicted_pages=%d base_pages=%d deltas=%d "+
+		"index=%d buffers=%d allocator=%d allocator_overhead=%d lss_live=%d "+
+		"lss_stale=%d rp_pinned=%d", u.ResidentPages, u.EvictedPages, u.BasePageBytes,
+		u.DeltaBytes, u.IndexBytes, u.BufferBytes, u.AllocatorBytes, u.AllocatorOverhead,
+		u.LSSLiveBytes, u.LSSStaleBytes, u.RecoveryPointPinnedBytes)
+}
+
+// Usage walks all the pages to compute the breakdown
+func (s *Plasma) Usage() (Usage, error) {
+	var u Usage
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	var rpSns []uint64
+	gcSn := s.GCSn()
+	if s.EnableShapshots {
+		rpSns = *(*[]uint64)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&s.rpSns))))
+	}
+
+	callb := func(pid PageId, partn RangePartition) error {
+		pg, err := s.ReadPage(pid, nil, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		head := pg.(*page).head
+		if head == nil {
+			return nil
+		}
+
+		if head.state.IsEvicted() {
+			u.EvictedPages++
+			return nil
+		}
+
+		u.ResidentPages++
+		total := pg.ComputeMemUsed()
+		base := 0
+		for pd := head; pd != nil; pd = pd.next {
+			if pd.op == opBasePage {
+				_, _, base = computeMemUsed(pd, s.itemSizeAct, nil, nil)
+				break
+			}
+		}
+
+		u.BasePageBytes += int64(base)
+		u.DeltaBytes += int64(total - base)
+
+		if len(rpSns) > 0 {
+			u.RecoveryPointPinnedBytes += s.rpPinnedBytes(pg.(*page), w.wCtx, rpSns, gcSn)
+		}
+
+		return nil
+	}
+
+	opts := PageVisitorOptions{Ordered: true}
+	if err := s.PageVisitorWithOptions(context.Background(), callb, opts); err != nil {
+		return u, err
+	}
+
+	sts := s.GetStats()
+	u.IndexBytes = sts.MemSzIndex
+
+	for ctx := s.wCtxList; ctx != nil; ctx = ctx.next {
+		for _, buf := range ctx.pgBuffers {
+			if buf != nil {
+				u.BufferBytes += int64(len(buf.bs))
+			}
+		}
+	}
+
+	if s.shouldPersist {
+		u.BufferBytes += int64(s.FlushBufferSize) * 2
+		u.BufferBytes += int64(len(s.clockHandle.buf.bs))
+
+		_, data, used := s.GetLSSInfo()
+		u.LSSLiveBytes = data
+		if used > data {
+			u.LSSStaleBytes = used - data
+		}
+	}
+
+	if s.useMemMgmt {
+		u.AllocatorBytes = int64(mm.Size())
+	} else {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		u.AllocatorBytes = int64(ms.HeapInuse)
+	}
+
+	if inUse := MemoryInUse(); u.AllocatorBytes > inUse {
+		u.AllocatorOverhead = u.AllocatorBytes - inUse
+	}
+
+	return u, nil
+}
+
+// Size of the shadowed versions older than the GC watermark which are
+// retained, since they are the last version before a recovery point.
+// Versions of a key are visited from the newest to the oldest.
+func (s *Plasma) rpPinnedBytes(pg *page, ctx *wCtx, rpSns []uint64, gcSn uint64) int64 {
+	var pinned int64
+	var lastKey []byte
+	var lastIn int
+	var first = true
+
+	interval := func(sn uint64) int {
+		if sn > gcSn {
+			return -1
+		}
+
+		return sort.Search(len(rpSns), func(i int) bool {
+			return rpSns[i] >= sn
+		})
+	}
+
+	var sts pgOpIteratorStats
+	it := newPgOpIterator(pg.head, pg.cmp, nil, pg.head.hiItm, &nilFilter, ctx, &sts)
+	defer it.Close()
+
+	for it.Init(); it.Valid(); it.Next() {
+		itm := it.Get().Item()
+		key := s.ItemCodec.Key(itm)
+		in := interval(s.ItemCodec.Sn(itm))
+		if first || string(key) != string(lastKey) {
+			lastKey = append(lastKey[:0], key...)
+			lastIn = in
+			first = false
+			continue
+		}
+
+		if in >= 0 && in != lastIn && in < len(rpSns) {
+			pinned += int64(s.itemSizeAct(itm))
+		}
+
+		lastIn = in
+	}
+
+	return pinned
+}
@@ -0,0 +1,58 @@
+package plasma
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPlasmaUsage(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testSnCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	update := func(v int) {
+		for i := 0; i < 10000; i++ {
+			w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%d", v)))
+		}
+	}
+
+	update(0)
+	snap := s.NewSnapshot()
+	snap.Open()
+	s.CreateRecoveryPoint(snap, []byte("rp"))
+	snap.Close()
+
+	u, err := s.Usage()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if u.RecoveryPointPinnedBytes != 0 {
+		t.Errorf("expected no pinned bytes, got %d", u.RecoveryPointPinnedBytes)
+	}
+
+	for v := 1; v < 3; v++ {
+		update(v)
+		s.NewSnapshot().Close()
+	}
+	s.NewSnapshot().Close()
+
+	if u, err = s.Usage(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if u.ResidentPages == 0 || u.BasePageBytes == 0 || u.IndexBytes == 0 || u.BufferBytes == 0 {
+		t.Errorf("unexpected usage %v", u)
+	}
+
+	if u.LSSLiveBytes == 0 {
+		t.Errorf("expected live lss data, got %v", u)
+	}
+
+	// One version of every key is retained for the recovery point
+	if u.RecoveryPointPinnedBytes < 10000*20 {
+		t.Errorf("expected pinned bytes, got %v", u)
+	}
+}
@@ -1,6 +1,7 @@
 package plasma
 
 import (
+	"context"
 	"github.com/couchbase/nitro/skiplist"
 	"reflect"
 	"sort"
@@ -22,6 +23,16 @@ func memcopy(dst, src unsafe.Pointer, sz int) {
 	copy(db, sb)
 }
 
+// Bytes of sz at ptr without copying them
+func ptrBytes(ptr unsafe.Pointer, sz int) []byte {
+	var b []byte
+	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+	hdr.Len = sz
+	hdr.Cap = sz
+	hdr.Data = uintptr(ptr)
+	return b
+}
+
 type pageItemSorter struct {
 	itms []PageItem
 	cmp  skiplist.CompareFn
@@ -89,19 +100,25 @@ type DecayInterval struct {
 	curr    time.Duration
 	final   time.Duration
 	incr    time.Duration
+	clock   Clock
 }
 
 func NewDecayInterval(initial, final time.Duration) DecayInterval {
+	return NewDecayIntervalWithClock(initial, final, SystemClock)
+}
+
+func NewDecayIntervalWithClock(initial, final time.Duration, clock Clock) DecayInterval {
 	return DecayInterval{
 		initial: initial,
 		curr:    final,
 		final:   final,
 		incr:    final / initial,
+		clock:   clock,
 	}
 }
 
 func (d *DecayInterval) Sleep() {
-	time.Sleep(d.curr)
+	d.clock.Sleep(d.curr)
 	if d.curr < d.final {
 		d.curr += d.incr
 	}
@@ -110,3 +127,12 @@ func (d *DecayInterval) Sleep() {
 func (d *DecayInterval) Reset() {
 	d.curr = d.initial
 }
+
+func checkContext(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,137 @@
+package plasma
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+var ErrVerifyNotSupported = errors.New("verification requires a persistent store")
+
+// Flushed page whose image in the log does not match its items in memory
+type PageDivergence struct {
+	MinKey unsafe.Pointer
+	Offset LSSOffset
+
+	MemItems int
+	LSSItems int
+
+	// First item which differs. It is nil if the items of one image are a
+	// prefix of the other or the image could not be read.
+	Item unsafe.Pointer
+	Err  error
+}
+
+func (d PageDivergence) String() string {
+	return fmt.Sprintf("offset: %d, mem items: %d, lss items: %d, err: %v",
+		d.Offset, d.MemItems, d.LSSItems, d.Err)
+}
+
+type VerifyReport struct {
+	// Visited pages and the resident flushed pages compared to the log
+	Pages    int
+	Verified int
+
+	Divergences []PageDivergence
+}
+
+func (s *Plasma) itemsEqual(a, b unsafe.Pointer) bool {
+	if s.cmp(a, b) != 0 {
+		return false
+	}
+
+	la, lb := int(s.itemSize(a)), int(s.itemSize(b))
+	if la != lb {
+		return false
+	}
+
+	return bytes.Equal(ptrBytes(a, la), ptrBytes(b, lb))
+}
+
+// Compare the items of a resident flushed page with its image in the log.
+// Returns false if the page is not flushed.
+func (s *Plasma) verifyPage(pg *page, ctx *wCtx) (bool, *PageDivergence) {
+	switch pg.head.op {
+	case opFlushPageDelta, opRelocPageDelta:
+	default:
+		return false, nil
+	}
+
+	offset, _, _ := pg.GetFlushInfo()
+	d := &PageDivergence{MinKey: s.copyKey(pg.MinItem()), Offset: offset}
+
+	lssPg, err := s.fetchPageFromLSS(offset, ctx)
+	if err != nil {
+		d.Err = err
+		return true, d
+	}
+
+	defer func() {
+		allocs, _, _, _, _ := lssPg.GetAllocOps()
+		s.discardDeltas(allocs)
+	}()
+
+	memItr, memItms, _, _ := pg.collectItems(pg.head, nil, pg.head.hiItm)
+	defer memItr.Close()
+
+	lssItr, lssItms, _, _ := lssPg.collectItems(lssPg.head, nil, lssPg.head.hiItm)
+	defer lssItr.Close()
+
+	d.MemItems, d.LSSItems = len(memItms), len(lssItms)
+	for i := 0; i < len(memItms) && i < len(lssItms); i++ {
+		if !s.itemsEqual(memItms[i], lssItms[i]) {
+			d.Item = s.copyKey(memItms[i])
+			return true, d
+		}
+	}
+
+	if len(memItms) != len(lssItms) {
+		return true, d
+	}
+
+	return true, nil
+}
+
+// Re-read the log image of every resident flushed page and compare its
+// items with the items of the page in memory. Pages modified since their
+// last flush and evicted pages are not verified. Verification stops with
+// the context error if ctx is done before it completes.
+func (s *Plasma) VerifyPersisted(ctx context.Context) (*VerifyReport, error) {
+	if !s.shouldPersist {
+		return nil, ErrVerifyNotSupported
+	}
+
+	report := new(VerifyReport)
+
+	w := s.AcquireWriter()
+	defer s.ReleaseWriter(w)
+
+	callb := func(pid PageId, partn RangePartition) error {
+		tok := w.BeginTx()
+		defer w.EndTx(tok)
+
+		pg, err := s.ReadPage(pid, nil, false, w.wCtx)
+		if err != nil {
+			return err
+		}
+
+		report.Pages++
+		verified, d := s.verifyPage(pg.(*page), w.wCtx)
+		if verified {
+			report.Verified++
+		}
+
+		if d != nil {
+			fmt.Printf("Plasma: Verify: page diverges from the log (%v)\n", d)
+			report.Divergences = append(report.Divergences, *d)
+		}
+
+		return nil
+	}
+
+	opts := PageVisitorOptions{Ordered: true}
+	err := s.PageVisitorWithOptions(ctx, callb, opts)
+	return report, err
+}
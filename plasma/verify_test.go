@@ -0,0 +1,60 @@
+package plasma
+
+import (
+	"context"
+	"github.com/couchbase/nitro/skiplist"
+	"os"
+	"testing"
+)
+
+func TestPlasmaVerifyPersisted(t *testing.T) {
+	os.RemoveAll("teststore.data")
+	s := newTestIntPlasmaStore(testCfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Insert(skiplist.NewIntKeyItem(i * 2))
+	}
+	s.PersistAll()
+
+	// Pages modified after the flush are not verified
+	for i := 0; i < 100; i++ {
+		w.Delete(skiplist.NewIntKeyItem(i * 2))
+	}
+
+	report, err := s.VerifyPersisted(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if report.Pages != int(s.GetStats().NumPages) || report.Verified == 0 ||
+		report.Verified == report.Pages || len(report.Divergences) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// Corrupt an item in memory without changing the order of the items
+	itr := s.NewIterator().(*Iterator)
+	itr.Seek(skiplist.NewIntKeyItem(100000))
+	*(*int)(itr.Get()) = 100001
+	itr.Close()
+
+	report, err = s.VerifyPersisted(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(report.Divergences) != 1 {
+		t.Fatalf("expected a divergence, got %+v", report)
+	}
+
+	if d := report.Divergences[0]; skiplist.IntFromItem(d.Item) != 100001 || d.MemItems != d.LSSItems || d.Err != nil {
+		t.Errorf("unexpected divergence %+v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.VerifyPersisted(ctx); err != context.Canceled {
+		t.Errorf("expected verification to be canceled, got %v", err)
+	}
+}
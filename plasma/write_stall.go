@@ -0,0 +1,84 @@
+package plasma
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+var ErrWriteStalled = errors.New("write rejected as the store is too far behind on background work")
+
+// The backlog of the store is sampled by the writers at most once per
+// interval
+const writeStallCheckInterval = 10 * time.Millisecond
+
+type writeStallState struct {
+	checkedAt int64
+	level     uint64
+}
+
+// Backlog of the store relative to the write stall limits. The highest
+// ratio of a backlog to its limit is returned.
+func (s *Plasma) writeStallLevel() float64 {
+	var level float64
+	check := func(v, limit int64) {
+		if limit > 0 {
+			level = math.Max(level, float64(v)/float64(limit))
+		}
+	}
+
+	if s.compactionQ != nil {
+		check(int64(s.CompactionQueueLen()), int64(s.WriteStallCompactionQueue))
+	}
+
+	check(s.MemoryInUse(), s.WriteStallMemSize)
+
+	if s.shouldPersist && s.WriteStallLSSStaleBytes > 0 {
+		if stale := s.lss.UsedSpace() - s.LSSDataSize(); stale > 0 {
+			check(stale, s.WriteStallLSSStaleBytes)
+		}
+	}
+
+	return level
+}
+
+func (s *Plasma) currWriteStallLevel() float64 {
+	st := &s.writeStall
+	now := s.Clock.Now().UnixNano()
+	last := atomic.LoadInt64(&st.checkedAt)
+	if now-last >= int64(writeStallCheckInterval) && atomic.CompareAndSwapInt64(&st.checkedAt, last, now) {
+		atomic.StoreUint64(&st.level, math.Float64bits(s.writeStallLevel()))
+	}
+
+	return math.Float64frombits(atomic.LoadUint64(&st.level))
+}
+
+// Writes are delayed in proportion to the amount by which a backlog
+// exceeds its limit, up to WriteStallDelay, and rejected once it exceeds
+// twice the limit. It is checked once per mutation before its first write,
+// so that a mutation is never left partially applied. Deletes are exempt.
+func (w *Writer) throttleWrite() error {
+	if w.WriteStallCompactionQueue <= 0 && w.WriteStallMemSize <= 0 && w.WriteStallLSSStaleBytes <= 0 {
+		return nil
+	}
+
+	level := w.currWriteStallLevel()
+	if level < 1 {
+		return nil
+	}
+
+	if level >= 2 {
+		w.sts.DeniedWrites++
+		return ErrWriteStalled
+	}
+
+	d := time.Duration(float64(w.WriteStallDelay) * (level - 1))
+	if d > 0 {
+		w.Clock.Sleep(d)
+		w.sts.StalledWrites++
+		w.sts.WriteStallTime += int64(d)
+	}
+
+	return nil
+}
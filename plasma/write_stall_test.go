@@ -0,0 +1,78 @@
+package plasma
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPlasmaWriteStall(t *testing.T) {
+	clock := NewVirtualClock(time.Now())
+	cfg := testSnCfg
+	cfg.File = ""
+	cfg.Clock = clock
+	cfg.WriteStallDelay = time.Millisecond
+	s := newTestIntPlasmaStore(cfg)
+	defer s.Close()
+
+	w := s.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.InsertKV([]byte(fmt.Sprintf("key-%10d", i)), []byte("val"))
+	}
+
+	mem := s.MemoryInUse()
+	insert := func(limit int64) error {
+		s.WriteStallMemSize = limit
+		clock.Advance(writeStallCheckInterval)
+		_, err := w.InsertKV([]byte("key"), []byte("val"))
+		return err
+	}
+
+	if err := insert(mem * 2); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if sts := s.GetStats(); sts.StalledWrites != 0 || sts.DeniedWrites != 0 {
+		t.Errorf("expected no stalls, got %d stalled, %d denied", sts.StalledWrites, sts.DeniedWrites)
+	}
+
+	// Delayed by the virtual clock, which is advanced by the sleep
+	done := make(chan error)
+	go func() {
+		done <- insert(mem * 2 / 3)
+	}()
+
+	for i := 0; ; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		case <-time.After(time.Millisecond):
+			clock.Advance(time.Millisecond)
+			continue
+		}
+		break
+	}
+
+	if sts := s.GetStats(); sts.StalledWrites != 1 || sts.WriteStallTime == 0 {
+		t.Errorf("expected a stalled write, got %d stalled for %dns", sts.StalledWrites, sts.WriteStallTime)
+	}
+
+	items := s.ItemsCount()
+	if err := insert(mem / 3); err != ErrWriteStalled {
+		t.Errorf("expected write stalled error, got %v", err)
+	}
+
+	if s.ItemsCount() != items || s.GetStats().DeniedWrites != 1 {
+		t.Errorf("expected the denied write not to be counted")
+	}
+
+	if _, err := w.DeleteKV([]byte("key")); err != nil {
+		t.Errorf("expected deletes not to be stalled, got %v", err)
+	}
+
+	if err := insert(0); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -10,6 +10,7 @@
 package skiplist
 
 import "math/rand"
+import "sync/atomic"
 import "unsafe"
 
 // NodeCallback is used by segment builder
@@ -111,6 +112,73 @@ func (b *Builder) Assemble(segments ...*Segment) *Skiplist {
 
 }
 
+// MergeFrom moves the nodes of the other skiplist into this skiplist by
+// relinking them in a single ordered pass over both lists, which retains
+// the levels of the nodes. Nodes of the other skiplist holding items equal
+// to items in this skiplist are not moved and returned for freeing. The
+// other skiplist is left empty. Both skiplists should use the same node
+// allocator and there should be no concurrent access to either of them.
+func (s *Skiplist) MergeFrom(other *Skiplist, cmp CompareFn) []*Node {
+	var dups []*Node
+	var tail [MaxLevel + 1]*Node
+	for l := range tail {
+		tail[l] = s.head
+	}
+
+	// Nodes marked deleted are unlinked as a delete would do
+	next := func(sl *Skiplist, n *Node) *Node {
+		for {
+			n, _ = n.getNext(0)
+			if n == sl.tail {
+				return nil
+			}
+
+			if _, deleted := n.getNext(0); !deleted {
+				return n
+			}
+
+			sl.Stats.AddInt64(&sl.Stats.softDeletes, -1)
+			sl.Stats.AddInt64(&sl.Stats.levelNodesCount[n.Level()], -1)
+			sl.Stats.AddInt64(&sl.Stats.usedBytes, -int64(sl.Size(n)))
+		}
+	}
+
+	a, b := next(s, s.head), next(other, other.head)
+	for a != nil || b != nil {
+		var x *Node
+		if b == nil || (a != nil && compare(cmp, a.Item(), b.Item()) <= 0) {
+			if b != nil && compare(cmp, a.Item(), b.Item()) == 0 {
+				dups = append(dups, b)
+				other.Stats.AddInt64(&other.Stats.levelNodesCount[b.Level()], -1)
+				other.Stats.AddInt64(&other.Stats.usedBytes, -int64(other.Size(b)))
+				b = next(other, b)
+			}
+
+			x, a = a, next(s, a)
+		} else {
+			x, b = b, next(other, b)
+		}
+
+		for l := 0; l <= x.Level(); l++ {
+			tail[l].setNext(l, x, false)
+			tail[l] = x
+		}
+	}
+
+	for l := range tail {
+		tail[l].setNext(l, s.tail, false)
+		other.head.setNext(l, other.tail, false)
+	}
+
+	if level := atomic.LoadInt32(&other.level); level > atomic.LoadInt32(&s.level) {
+		atomic.StoreInt32(&s.level, level)
+	}
+	atomic.StoreInt32(&other.level, 0)
+
+	s.Stats.Merge(&other.Stats)
+	return dups
+}
+
 // NewBuilder creates a builder based on default config
 func NewBuilder() *Builder {
 	return NewBuilderWithConfig(DefaultConfig())
@@ -24,6 +24,9 @@ type Iterator struct {
 	bs          *BarrierSession
 	count       uint
 	smrInterval uint
+
+	paused     bool
+	checkpoint unsafe.Pointer
 }
 
 // NewIterator creates an iterator for skiplist
@@ -75,6 +78,37 @@ func (it *Iterator) Seek(itm unsafe.Pointer) bool {
 	return found
 }
 
+// SeekLE moves iterator to the greatest item less than or equal to the
+// provided item. Iterator becomes invalid if there is no such item.
+func (it *Iterator) SeekLE(itm unsafe.Pointer) bool {
+retry:
+	it.valid = true
+	if found := it.s.findPath(itm, it.cmp, it.buf, &it.s.Stats) != nil; found {
+		it.prev = it.buf.preds[0]
+		it.curr = it.buf.succs[0]
+		return true
+	}
+
+	pred := it.buf.preds[0]
+	if pred == it.s.head {
+		it.prev = it.s.head
+		it.curr = it.s.tail
+		it.valid = false
+		return false
+	}
+
+	// Obtain the predecessor of the node so that Next can unlink it
+	// if it gets deleted. Retry if it went away meanwhile.
+	if it.s.findPath(pred.Item(), it.cmp, it.buf, &it.s.Stats) != pred {
+		atomic.AddUint64(&it.s.Stats.readConflicts, 1)
+		goto retry
+	}
+
+	it.prev = it.buf.preds[0]
+	it.curr = pred
+	return false
+}
+
 // Valid returns true when iterator reaches the end
 func (it *Iterator) Valid() bool {
 	if it.valid && it.curr == it.s.tail {
@@ -160,3 +194,53 @@ func (it *Iterator) Refresh() {
 		it.s.barrier.Release(currBs)
 	}
 }
+
+// Checkpoint releases the access barrier held by the iterator, so that a
+// consumer pausing during a long scan does not delay the reclamation of
+// deleted nodes. A copy of the current item is kept for Resume, as the item
+// may be freed once the barrier is released. The iterator should not be used
+// until it is resumed.
+func (it *Iterator) Checkpoint() {
+	it.checkpoint = nil
+	if it.Valid() {
+		it.checkpoint = it.s.copyItem(it.Get())
+	}
+
+	if it.bs != nil {
+		it.s.barrier.Release(it.bs)
+		it.bs = nil
+		it.paused = true
+	}
+}
+
+// Resume acquires the access barrier again and moves the iterator to the
+// checkpointed item, or the next item if it has been deleted meanwhile.
+func (it *Iterator) Resume() {
+	if it.paused {
+		it.bs = it.s.barrier.Acquire()
+		it.paused = false
+	}
+
+	it.deleted = false
+	if it.checkpoint != nil {
+		it.Seek(it.checkpoint)
+		it.checkpoint = nil
+	} else {
+		it.prev = it.s.head
+		it.curr = it.s.tail
+		it.valid = false
+	}
+}
+
+// Items allocated by Malloc are copied to the Go heap using ItemSize, while
+// the other items are kept alive by the garbage collector
+func (s *Skiplist) copyItem(itm unsafe.Pointer) unsafe.Pointer {
+	if !s.UseMemoryMgmt {
+		return itm
+	}
+
+	sz := s.ItemSize(itm)
+	buf := make([]byte, sz)
+	copy(buf, (*[1 << 30]byte)(itm)[:sz:sz])
+	return unsafe.Pointer(&buf[0])
+}
@@ -40,14 +40,19 @@ func (n Node) Level() int {
 
 // Size returns memory used by the node
 func (n Node) Size() int {
-	return int(unsafe.Sizeof(n) +
-		uintptr(n.level+1)*(unsafe.Sizeof(unsafe.Pointer(nil))+
+	return nodeSize(n.level)
+}
+
+// Memory used by a node of the level including its tower
+func nodeSize(level int) int {
+	return int(unsafe.Sizeof(Node{}) +
+		uintptr(level+1)*(unsafe.Sizeof(unsafe.Pointer(nil))+
 			unsafe.Sizeof(NodeRef{})))
 }
 
 // Item returns item held by the node
 func (n *Node) Item() unsafe.Pointer {
-	return n.itm
+	return atomic.LoadPointer(&n.itm)
 }
 
 // SetLink can be used to set link pointer for the node
@@ -107,3 +112,17 @@ func (n *Node) dcasNext(level int, prevPtr, newPtr *Node, prevIsdeleted, newIsde
 
 	return swapped
 }
+
+// Nodes are allocated from the heap as the node arena is supported only
+// on amd64
+type nodeArena struct{}
+
+func newNodeArena() *nodeArena {
+	return nil
+}
+
+func (a *nodeArena) alloc(itm unsafe.Pointer, level int) *Node {
+	return allocNode(itm, level, nil)
+}
+
+func (a *nodeArena) free(*Node) {}
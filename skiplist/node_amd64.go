@@ -50,12 +50,17 @@ func (n Node) Level() int {
 
 // Size returns memory used by the node
 func (n Node) Size() int {
-	return int(nodeHdrSize + uintptr(n.level+1)*nodeRefSize)
+	return nodeSize(int(n.level))
+}
+
+// Memory used by a node of the level including its tower
+func nodeSize(level int) int {
+	return int(nodeHdrSize + uintptr(level+1)*nodeRefSize)
 }
 
 // Item returns item held by the node
 func (n *Node) Item() unsafe.Pointer {
-	return n.itm
+	return atomic.LoadPointer(&n.itm)
 }
 
 // SetItem sets itm ptr
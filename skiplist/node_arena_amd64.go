@@ -0,0 +1,82 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package skiplist
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// Approximate size of a chunk of nodes allocated by the arena
+const nodeArenaChunkSize = 64 * 1024
+
+// Arena carves out nodes of a level from chunks allocated as arrays of the
+// node type of the level, so that the garbage collector still scans the
+// pointers held by the nodes. Freed nodes are recycled for allocations of
+// the same level. The memory of the chunks is never returned.
+type nodeArena struct {
+	levels [MaxLevel + 1]nodeArenaLevel
+}
+
+type nodeArenaLevel struct {
+	sync.Mutex
+	free  []*Node
+	chunk unsafe.Pointer
+	next  int
+	count int
+}
+
+func newNodeArena() *nodeArena {
+	return new(nodeArena)
+}
+
+func (a *nodeArena) alloc(itm unsafe.Pointer, level int) *Node {
+	typ := nodeTypes[level]
+	l := &a.levels[level]
+
+	l.Lock()
+	var n *Node
+	if k := len(l.free); k > 0 {
+		n = l.free[k-1]
+		l.free[k-1] = nil
+		l.free = l.free[:k-1]
+	} else {
+		if l.next == l.count {
+			l.count = nodeArenaChunkSize / int(typ.Size())
+			if l.count == 0 {
+				l.count = 1
+			}
+			l.chunk = unsafe.Pointer(reflect.New(reflect.ArrayOf(l.count, typ)).Pointer())
+			l.next = 0
+		}
+
+		n = (*Node)(unsafe.Pointer(uintptr(l.chunk) + uintptr(l.next)*typ.Size()))
+		l.next++
+	}
+	l.Unlock()
+
+	n.level = uint16(level)
+	n.itm = itm
+	return n
+}
+
+// The node is cleared so that the objects referenced by it can be
+// garbage collected while it sits in the free list
+func (a *nodeArena) free(n *Node) {
+	level := int(n.level)
+	typ := nodeTypes[level]
+	reflect.NewAt(typ, unsafe.Pointer(n)).Elem().Set(reflect.Zero(typ))
+
+	l := &a.levels[level]
+	l.Lock()
+	l.free = append(l.free, n)
+	l.Unlock()
+}
@@ -47,6 +47,23 @@ type Config struct {
 	Malloc            MallocFn
 	Free              FreeFn
 	BarrierDestructor BarrierSessionDestructor
+
+	// Limit for the levels of the nodes, up to MaxLevel, and the probability
+	// of promoting a node to the next level. Small lists do well with a low
+	// limit while large lists benefit from a higher probability.
+	MaxNodeLevel     int
+	LevelProbability float32
+
+	// MemoryQuotaCallback is called when the memory used by the skiplist
+	// goes above MemoryQuota and when it drops back below it. Usage is
+	// checked on updates, after partial stats are merged.
+	MemoryQuota         int64
+	MemoryQuotaCallback func(used int64, exceeded bool)
+
+	// Allocate nodes from chunks preallocated per level and recycle the
+	// nodes released by FreeNode or RetireNodes, which reduces the heap
+	// churn of insert heavy workloads. Not used with UseMemoryMgmt.
+	UseNodeArena bool
 }
 
 // SetItemSizeFunc configures item size function
@@ -57,8 +74,10 @@ func (cfg *Config) SetItemSizeFunc(fn ItemSizeFn) {
 // DefaultConfig returns default skiplist configuration
 func DefaultConfig() Config {
 	return Config{
-		ItemSize:      defaultItemSize,
-		UseMemoryMgmt: false,
+		ItemSize:         defaultItemSize,
+		UseMemoryMgmt:    false,
+		MaxNodeLevel:     MaxLevel,
+		LevelProbability: p,
 	}
 }
 
@@ -73,6 +92,8 @@ type Skiplist struct {
 	newNode  func(itm unsafe.Pointer, level int) *Node
 	freeNode func(*Node)
 
+	overQuota int32
+
 	Config
 }
 
@@ -85,13 +106,37 @@ func New() *Skiplist {
 func NewWithConfig(cfg Config) *Skiplist {
 	if runtime.GOARCH != "amd64" {
 		cfg.UseMemoryMgmt = false
+		cfg.UseNodeArena = false
+	}
+
+	if cfg.UseMemoryMgmt {
+		cfg.UseNodeArena = false
+	}
+
+	if cfg.MaxNodeLevel <= 0 || cfg.MaxNodeLevel > MaxLevel {
+		cfg.MaxNodeLevel = MaxLevel
+	}
+
+	if cfg.LevelProbability <= 0 || cfg.LevelProbability >= 1 {
+		cfg.LevelProbability = p
 	}
 
 	s := &Skiplist{
-		Config:  cfg,
-		barrier: newAccessBarrier(cfg.UseMemoryMgmt, cfg.BarrierDestructor),
+		Config: cfg,
 	}
 
+	// Retired nodes are returned to the arena once the accessors which
+	// could be referencing them have left
+	if cfg.UseNodeArena && cfg.BarrierDestructor == nil {
+		cfg.BarrierDestructor = func(ref unsafe.Pointer) {
+			for _, n := range *(*[]*Node)(ref) {
+				s.FreeNode(n, &s.Stats)
+			}
+		}
+	}
+
+	s.barrier = newAccessBarrier(cfg.UseMemoryMgmt || cfg.UseNodeArena, cfg.BarrierDestructor)
+
 	s.newNode = func(itm unsafe.Pointer, level int) *Node {
 		return allocNode(itm, level, cfg.Malloc)
 	}
@@ -103,6 +148,10 @@ func NewWithConfig(cfg Config) *Skiplist {
 			}
 			cfg.Free(unsafe.Pointer(n))
 		}
+	} else if cfg.UseNodeArena {
+		arena := newNodeArena()
+		s.newNode = arena.alloc
+		s.freeNode = arena.free
 	} else {
 		s.freeNode = func(*Node) {}
 	}
@@ -131,6 +180,15 @@ func (s *Skiplist) FreeNode(n *Node, sts *Stats) {
 	sts.AddInt64(&sts.nodeFrees, 1)
 }
 
+// RetireNodes frees the deleted nodes once the accessors of the skiplist
+// which could be referencing them have left. It requires the node arena
+// to be used without a custom barrier destructor.
+func (s *Skiplist) RetireNodes(nodes []*Node) {
+	if len(nodes) > 0 {
+		s.barrier.FlushSession(unsafe.Pointer(&nodes))
+	}
+}
+
 func (s *Skiplist) NewNode(level int) *Node {
 	return s.newNode(nil, level)
 }
@@ -170,11 +228,11 @@ func (s *Skiplist) Size(n *Node) int {
 func (s *Skiplist) NewLevel(randFn func() float32) int {
 	var nextLevel int
 
-	for ; randFn() < p; nextLevel++ {
+	for ; randFn() < s.LevelProbability; nextLevel++ {
 	}
 
-	if nextLevel > MaxLevel {
-		nextLevel = MaxLevel
+	if nextLevel > s.MaxNodeLevel {
+		nextLevel = s.MaxNodeLevel
 	}
 
 	level := int(atomic.LoadInt32(&s.level))
@@ -192,13 +250,19 @@ func (s *Skiplist) NewLevel(randFn func() float32) int {
 func (s *Skiplist) helpDelete(level int, prev, curr, next *Node, sts *Stats) bool {
 	success := prev.dcasNext(level, curr, next, false, false)
 	if success && level == 0 {
-		sts.AddInt64(&sts.softDeletes, -1)
-		sts.AddInt64(&sts.levelNodesCount[curr.Level()], -1)
-		sts.AddInt64(&sts.usedBytes, -int64(s.Size(curr)))
+		s.countUnlinked(curr, sts)
+		s.checkMemoryQuota()
 	}
 	return success
 }
 
+// Accounts for a deleted node unlinked from level 0
+func (s *Skiplist) countUnlinked(n *Node, sts *Stats) {
+	sts.AddInt64(&sts.softDeletes, -1)
+	sts.AddInt64(&sts.levelNodesCount[n.Level()], -1)
+	sts.AddInt64(&sts.usedBytes, -int64(s.Size(n)))
+}
+
 func (s *Skiplist) Lookup(itm unsafe.Pointer, cmp CompareFn, buf *ActionBuffer, sts *Stats) (pred *Node, curr *Node, found bool) {
 	found = s.findPath(itm, cmp, buf, sts) != nil
 	pred = buf.preds[0]
@@ -334,9 +398,78 @@ finished:
 	sts.AddInt64(&sts.nodeAllocs, 1)
 	sts.AddInt64(&sts.levelNodesCount[itemLevel], 1)
 	sts.AddInt64(&sts.usedBytes, int64(s.Size(x)))
+	s.checkMemoryQuota()
 	return x, true
 }
 
+// Upsert inserts the item unless an item comparing equal is present, in
+// which case the existing item is returned. The lookup and the insert share
+// a single traversal, unlike a Lookup followed by an Insert.
+func (s *Skiplist) Upsert(itm unsafe.Pointer, cmp CompareFn,
+	buf *ActionBuffer, sts *Stats) (unsafe.Pointer, bool) {
+	token := s.barrier.Acquire()
+	defer s.barrier.Release(token)
+
+	// The level of a new node is drawn only once the key is not found, so
+	// that updates do not raise the list level
+	var x *Node
+	var itemLevel int
+	for {
+		level := int(atomic.LoadInt32(&s.level))
+
+		// A node marked deleted is unlinked by the next traversal
+		if n := s.findPath(itm, cmp, buf, sts); n != nil {
+			if _, deleted := n.getNext(0); deleted {
+				continue
+			}
+
+			if x != nil {
+				s.freeNode(x)
+			}
+			return n.Item(), false
+		}
+
+		if x == nil {
+			itemLevel = s.NewLevel(rand.Float32)
+			x = s.newNode(itm, itemLevel)
+
+			// The path does not cover a level raised after the traversal
+			if itemLevel > level {
+				continue
+			}
+		}
+
+		n, inserted := s.Insert4(x, cmp, nil, buf, itemLevel, true, false, sts)
+		if inserted {
+			return itm, true
+		}
+
+		if _, deleted := n.getNext(0); !deleted {
+			s.freeNode(x)
+			return n.Item(), false
+		}
+	}
+}
+
+// CompareAndSwapItem atomically replaces the item held by the node with
+// newItm if it still holds oldItm. The new item should compare equal to the
+// old one so that the ordering of the list is preserved. It fails if the
+// node has been deleted. Readers may still be accessing the old item, hence
+// it should be freed only after they are done, as with deleted nodes.
+func (s *Skiplist) CompareAndSwapItem(n *Node, oldItm, newItm unsafe.Pointer, sts *Stats) bool {
+	if _, deleted := n.getNext(0); deleted {
+		return false
+	}
+
+	if !atomic.CompareAndSwapPointer(&n.itm, oldItm, newItm) {
+		return false
+	}
+
+	sts.AddInt64(&sts.usedBytes, int64(s.ItemSize(newItm)-s.ItemSize(oldItm)))
+	s.checkMemoryQuota()
+	return true
+}
+
 func (s *Skiplist) softDelete(delNode *Node, sts *Stats) bool {
 	var marked bool
 
@@ -393,37 +526,184 @@ func (s *Skiplist) deleteNode(n *Node, cmp CompareFn, buf *ActionBuffer, sts *St
 	return false
 }
 
+// DeleteRange removes the items in the range [lo, hi) and returns their
+// nodes, which the caller should free once no reader can access them, as
+// with DeleteNode. The nodes are marked deleted one by one, after which
+// each level is unlinked with a single CAS. Concurrent updates within the
+// range make it fall back to unlinking the remaining nodes one by one.
+// Items inserted into the range concurrently may not be deleted.
+func (s *Skiplist) DeleteRange(lo, hi unsafe.Pointer, cmp CompareFn,
+	buf *ActionBuffer, sts *Stats) []*Node {
+	token := s.barrier.Acquire()
+	defer s.barrier.Release(token)
+
+	var deleted []*Node
+	if compare(cmp, lo, hi) >= 0 {
+		return nil
+	}
+
+	// The path covers at least the levels present when the level is read.
+	// Nodes above it are inserted later and left to the fallback.
+	level := int(atomic.LoadInt32(&s.level))
+	s.findPath(lo, cmp, buf, sts)
+	unlinked := true
+	for n := buf.succs[0]; n != s.tail && compare(cmp, n.Item(), hi) < 0; n, _ = n.getNext(0) {
+		if s.softDelete(n, sts) {
+			deleted = append(deleted, n)
+			if n.Level() > level {
+				unlinked = false
+			}
+		}
+	}
+
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	for i := level; i >= 0; i-- {
+		if !s.unlinkRun(i, buf.preds[i], hi, cmp, sts) {
+			unlinked = false
+		}
+	}
+
+	if !unlinked {
+		for _, n := range deleted {
+			s.findPath(n.Item(), cmp, buf, sts)
+		}
+	}
+
+	return deleted
+}
+
+// Unlink the run of deleted nodes after prev at the level, which should
+// extend up to the first node >= hi
+func (s *Skiplist) unlinkRun(level int, prev *Node, hi unsafe.Pointer, cmp CompareFn, sts *Stats) bool {
+	first, deleted := prev.getNext(level)
+	if deleted {
+		return false
+	}
+
+	end := first
+	for end != s.tail && compare(cmp, end.Item(), hi) < 0 {
+		next, deleted := end.getNext(level)
+		if !deleted {
+			return false
+		}
+		end = next
+	}
+
+	if end == first {
+		return true
+	}
+
+	if !prev.dcasNext(level, first, end, false, false) {
+		sts.AddUint64(&sts.readConflicts, 1)
+		return false
+	}
+
+	if level == 0 {
+		for n := first; n != end; n, _ = n.getNext(0) {
+			s.countUnlinked(n, sts)
+		}
+		s.checkMemoryQuota()
+	}
+
+	return true
+}
+
+// Number of nodes which are part of the given level and the levels above
+func (s *Skiplist) levelCount(level int) int64 {
+	var c int64
+	for l := level; l <= MaxLevel; l++ {
+		c += atomic.LoadInt64(&s.Stats.levelNodesCount[l])
+	}
+	return c
+}
+
+// Minimum number of nodes sampled from a level for estimating counts
+// and picking split items
+const minLevelSamples = 16
+
+// CountRange returns an approximate count of the items in the range [lo, hi).
+// Nodes in the range are counted at the highest level having enough of them
+// and scaled by the proportion of nodes reaching that level, which requires
+// visiting O(log n) nodes.
+func (s *Skiplist) CountRange(lo, hi unsafe.Pointer, cmp CompareFn) int64 {
+	token := s.barrier.Acquire()
+	defer s.barrier.Release(token)
+
+	if compare(cmp, lo, hi) >= 0 {
+		return 0
+	}
+
+	prev := s.head
+	for l := int(atomic.LoadInt32(&s.level)); l >= 0; l-- {
+		curr, _ := prev.getNext(l)
+		for curr != s.tail && compare(cmp, curr.Item(), lo) < 0 {
+			prev = curr
+			curr, _ = curr.getNext(l)
+		}
+
+		var count int64
+		for curr != s.tail && compare(cmp, curr.Item(), hi) < 0 {
+			next, deleted := curr.getNext(l)
+			if !deleted {
+				count++
+			}
+			curr = next
+		}
+
+		if count >= minLevelSamples || l == 0 {
+			if l == 0 {
+				return count
+			}
+			return count * s.levelCount(0) / s.levelCount(l)
+		}
+	}
+
+	return 0
+}
+
 // GetRangeSplitItems returns `nways` split range pivots of the skiplist items
 // Explicit barrier and release should be used by the caller before
 // and after this function call
 func (s *Skiplist) GetRangeSplitItems(nways int) []unsafe.Pointer {
-	var deleted bool
-repeat:
-	var itms []unsafe.Pointer
-	var finished bool
-
-	l := int(atomic.LoadInt32(&s.level))
-	for ; l >= 0; l-- {
-		c := int(atomic.LoadInt64(&s.Stats.levelNodesCount[l]) + 1)
-		if c >= nways {
-			perSplit := c / nways
-			node := s.head
-			for j := 0; node != s.tail && !finished; j++ {
-				if j == perSplit {
-					j = -1
-					itms = append(itms, node.Item())
-					finished = len(itms) == nways-1
-				}
-
-				node, deleted = node.getNext(l)
-				if deleted {
-					goto repeat
-				}
-			}
+	if nways < 2 {
+		return nil
+	}
 
+	// Use the highest level having enough nodes to pick evenly
+	// weighted pivots. Each node at a level stands for an equal
+	// share of the items on average.
+	var l int
+	for l = int(atomic.LoadInt32(&s.level)); l > 0; l-- {
+		if s.levelCount(l) >= int64(nways*minLevelSamples) {
 			break
 		}
 	}
 
+	var samples []unsafe.Pointer
+	node, _ := s.head.getNext(l)
+	for node != s.tail {
+		next, deleted := node.getNext(l)
+		if !deleted {
+			samples = append(samples, node.Item())
+		}
+		node = next
+	}
+
+	// Too few items for nways ranges, each one starts a range
+	if len(samples) < nways {
+		if len(samples) < 2 {
+			return nil
+		}
+		return samples[1:]
+	}
+
+	itms := make([]unsafe.Pointer, 0, nways-1)
+	for i := 1; i < nways; i++ {
+		itms = append(itms, samples[i*len(samples)/nways])
+	}
+
 	return itms
 }
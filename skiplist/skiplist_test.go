@@ -13,6 +13,7 @@ import "fmt"
 import "math/rand"
 import "runtime"
 import "sync"
+import "sync/atomic"
 import "time"
 import "unsafe"
 
@@ -196,3 +197,596 @@ func TestBuilder(t *testing.T) {
 	}
 
 }
+
+func TestDeleteRange(t *testing.T) {
+	s := New()
+	cmp := CompareInt
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	for i := 0; i < 10000; i++ {
+		s.Insert(NewIntKeyItem(i), cmp, buf, &s.Stats)
+	}
+
+	deleted := s.DeleteRange(NewIntKeyItem(1000), NewIntKeyItem(9000), cmp, buf, &s.Stats)
+	if len(deleted) != 8000 {
+		t.Errorf("Expected 8000 deleted nodes, got %d", len(deleted))
+	}
+
+	if len(s.DeleteRange(NewIntKeyItem(5000), NewIntKeyItem(6000), cmp, buf, &s.Stats)) != 0 {
+		t.Errorf("Expected no nodes to be deleted again")
+	}
+
+	itr := s.NewIterator(cmp, buf)
+	count := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if v := IntFromItem(itr.Get()); v >= 1000 && v < 9000 {
+			t.Errorf("Unexpected item %d", v)
+		}
+		count++
+	}
+
+	sts := s.GetStats()
+	if count != 2000 || sts.NodeCount != 2000 || sts.SoftDeletes != 0 {
+		t.Errorf("Expected 2000 items, got %d, stats %v", count, sts)
+	}
+
+	// Items ordered before the start of the list are reachable
+	s.DeleteRange(MinItem, NewIntKeyItem(10), cmp, buf, &s.Stats)
+	s.DeleteRange(NewIntKeyItem(9990), MaxItem, cmp, buf, &s.Stats)
+	if sts := s.GetStats(); sts.NodeCount != 1980 {
+		t.Errorf("Expected 1980 items, got %d", sts.NodeCount)
+	}
+}
+
+func TestDeleteRangeConcurrent(t *testing.T) {
+	s := New()
+	cmp := CompareInt
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	n := 20000
+	for i := 0; i < n; i++ {
+		s.Insert(NewIntKeyItem(i), cmp, buf, &s.Stats)
+	}
+
+	// Overlapping ranges [0, 450) of every 1000 items are deleted, while
+	// items [500, 600) are deleted one by one and new items are inserted
+	var wg sync.WaitGroup
+	var deleted int64
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			buf := s.MakeBuf()
+			defer s.FreeBuf(buf)
+
+			for lo := 0; lo < n; lo += 1000 {
+				switch {
+				case i < 4:
+					d := s.DeleteRange(NewIntKeyItem(lo+i*100), NewIntKeyItem(lo+i*100+150), cmp, buf, &s.Stats)
+					atomic.AddInt64(&deleted, int64(len(d)))
+				case i == 4:
+					for j := lo + 500; j < lo+600; j++ {
+						s.Delete(NewIntKeyItem(j), cmp, buf, &s.Stats)
+					}
+				default:
+					for j := n + lo; j < n+lo+1000; j++ {
+						s.Insert(NewIntKeyItem(j), cmp, buf, &s.Stats)
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	itr := s.NewIterator(cmp, buf)
+	count := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if v := IntFromItem(itr.Get()); v < n && (v%1000 < 450 || (v%1000 >= 500 && v%1000 < 600)) {
+			t.Errorf("Unexpected item %d", v)
+		}
+		count++
+	}
+
+	if sts := s.GetStats(); count != n+450*n/1000 || sts.NodeCount != count || sts.SoftDeletes != 0 {
+		t.Errorf("Unexpected count %d, stats %v", count, sts)
+	}
+
+	if deleted != int64(450*n/1000) {
+		t.Errorf("Expected %d deleted nodes, got %d", 450*n/1000, deleted)
+	}
+}
+
+func TestIteratorSeekLE(t *testing.T) {
+	s := New()
+	cmp := CompareInt
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	for i := 10; i < 1000; i += 10 {
+		s.Insert(NewIntKeyItem(i), cmp, buf, &s.Stats)
+	}
+
+	itr := s.NewIterator(cmp, buf)
+	defer itr.Close()
+
+	if itr.SeekLE(NewIntKeyItem(5)) || itr.Valid() {
+		t.Errorf("Expected no item <= 5")
+	}
+
+	for i := 10; i < 1100; i++ {
+		found := itr.SeekLE(NewIntKeyItem(i))
+		exp := i - i%10
+		if exp > 990 {
+			exp = 990
+		}
+
+		if !itr.Valid() || IntFromItem(itr.Get()) != exp || found != (i%10 == 0 && i <= 990) {
+			t.Errorf("SeekLE(%d): expected %d, got %v %v", i, exp, itr.Valid(), found)
+		}
+	}
+
+	// Deleted item is skipped and the iterator can move forward
+	s.Delete(NewIntKeyItem(500), cmp, buf, &s.Stats)
+	itr.SeekLE(NewIntKeyItem(505))
+	if v := IntFromItem(itr.Get()); v != 490 {
+		t.Errorf("Expected 490, got %d", v)
+	}
+
+	itr.Next()
+	if v := IntFromItem(itr.Get()); !itr.Valid() || v != 510 {
+		t.Errorf("Expected 510, got %d", v)
+	}
+}
+
+func TestCountRange(t *testing.T) {
+	s := New()
+	cmp := CompareInt
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	n := 20000
+	for i := 0; i < n; i++ {
+		s.Insert(NewIntKeyItem(i), cmp, buf, &s.Stats)
+	}
+
+	if c := s.CountRange(NewIntKeyItem(10), NewIntKeyItem(20), cmp); c != 10 {
+		t.Errorf("Expected exact count 10, got %d", c)
+	}
+
+	for _, r := range [][2]int{{0, n}, {1000, 11000}, {18000, n}} {
+		exp := r[1] - r[0]
+		c := int(s.CountRange(NewIntKeyItem(r[0]), NewIntKeyItem(r[1]), cmp))
+		if c < exp/2 || c > exp*2 {
+			t.Errorf("Expected about %d items in %v, got %d", exp, r, c)
+		}
+	}
+
+	if c := s.CountRange(NewIntKeyItem(n), MaxItem, cmp); c != 0 {
+		t.Errorf("Expected no items, got %d", c)
+	}
+}
+
+func TestGetRangeSplitItemsEven(t *testing.T) {
+	s := New()
+	cmp := CompareInt
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	n := 20000
+	for i := 0; i < n; i++ {
+		s.Insert(NewIntKeyItem(i), cmp, buf, &s.Stats)
+	}
+
+	itms := s.GetRangeSplitItems(8)
+	if len(itms) != 7 {
+		t.Fatalf("Expected 7 split items, got %d", len(itms))
+	}
+
+	prev := 0
+	for _, itm := range append(itms, NewIntKeyItem(n)) {
+		k := IntFromItem(itm)
+		if d := k - prev; d < n/16 || d > n/4 {
+			t.Errorf("Uneven split range [%d, %d)", prev, k)
+		}
+		prev = k
+	}
+
+	if itms := New().GetRangeSplitItems(8); itms != nil {
+		t.Errorf("Expected no split items for empty list")
+	}
+
+	s2 := New()
+	for i := 0; i < 4; i++ {
+		s2.Insert(NewIntKeyItem(i), cmp, buf, &s2.Stats)
+	}
+
+	if itms := s2.GetRangeSplitItems(8); len(itms) != 3 || IntFromItem(itms[0]) != 1 {
+		t.Errorf("Expected a split item per item but the first, got %d", len(itms))
+	}
+}
+
+func TestCompareAndSwapItem(t *testing.T) {
+	type kv [2]int
+	cmp := func(a, b unsafe.Pointer) int {
+		return (*kv)(a)[0] - (*kv)(b)[0]
+	}
+
+	s := New()
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	for i := 0; i < 10; i++ {
+		s.Insert(unsafe.Pointer(&kv{i, 0}), cmp, buf, &s.Stats)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			buf := s.MakeBuf()
+			defer s.FreeBuf(buf)
+			for i := 0; i < 1000; i++ {
+				k := (g + i) % 10
+				_, n, _ := s.Lookup(unsafe.Pointer(&kv{k, 0}), cmp, buf, &s.Stats)
+				for {
+					old := n.Item()
+					if s.CompareAndSwapItem(n, old, unsafe.Pointer(&kv{k, (*kv)(old)[1] + 1}), &s.Stats) {
+						break
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	total := 0
+	itr := s.NewIterator(cmp, buf)
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		total += (*kv)(itr.Get())[1]
+	}
+	itr.Close()
+
+	if total != 8000 {
+		t.Errorf("Expected 8000 increments, got %d", total)
+	}
+
+	_, n, _ := s.Lookup(unsafe.Pointer(&kv{5, 0}), cmp, buf, &s.Stats)
+	old := n.Item()
+	if s.CompareAndSwapItem(n, unsafe.Pointer(&kv{5, 0}), unsafe.Pointer(&kv{5, 1}), &s.Stats) {
+		t.Errorf("Expected swap with a stale item to fail")
+	}
+
+	s.DeleteNode(n, cmp, buf, &s.Stats)
+	if s.CompareAndSwapItem(n, old, unsafe.Pointer(&kv{5, 1}), &s.Stats) {
+		t.Errorf("Expected swap on a deleted node to fail")
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	s := New()
+	cmp := CompareInt
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	var wg sync.WaitGroup
+	var inserts int64
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := s.MakeBuf()
+			defer s.FreeBuf(buf)
+			for i := 0; i < 1000; i++ {
+				itm := NewIntKeyItem(i)
+				got, inserted := s.Upsert(itm, cmp, buf, &s.Stats)
+				if inserted {
+					atomic.AddInt64(&inserts, 1)
+					if got != itm {
+						t.Errorf("Expected the inserted item to be returned")
+					}
+				} else if IntFromItem(got) != i || got == itm {
+					t.Errorf("Expected existing item %d, got %d", i, IntFromItem(got))
+				}
+
+				if i%10 == 0 {
+					s.Delete(itm, cmp, buf, &s.Stats)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sts := s.GetStats(); inserts < 1000 || sts.NodeCount != 900 {
+		t.Errorf("Expected at least 1000 inserts and 900 items, got %d, %v", inserts, sts)
+	}
+
+	s2 := New()
+	s2.Upsert(NewIntKeyItem(0), cmp, buf, &s2.Stats)
+	level := atomic.LoadInt32(&s2.level)
+	for i := 0; i < 10000; i++ {
+		s2.Upsert(NewIntKeyItem(0), cmp, buf, &s2.Stats)
+	}
+
+	if l := atomic.LoadInt32(&s2.level); l != level {
+		t.Errorf("Expected updates not to raise the level from %d, got %d", level, l)
+	}
+}
+
+func TestNodeArena(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.UseNodeArena = true
+	s := NewWithConfig(cfg)
+	cmp := CompareInt
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			buf := s.MakeBuf()
+			defer s.FreeBuf(buf)
+			for i := g; i < 20000; i += 4 {
+				s.Insert(NewIntKeyItem(i), cmp, buf, &s.Stats)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Deleted nodes are not recycled while an iterator may be on them
+	itr := s.NewIterator(cmp, buf)
+	itr.Seek(NewIntKeyItem(5000))
+	deleted := s.DeleteRange(NewIntKeyItem(5000), NewIntKeyItem(15000), cmp, buf, &s.Stats)
+	s.RetireNodes(deleted)
+	if sts := s.GetStats(); sts.NodeFrees != 0 {
+		t.Errorf("Expected no frees with an active iterator, got %d", sts.NodeFrees)
+	}
+
+	itr.Close()
+	if sts := s.GetStats(); sts.NodeFrees != 10000 {
+		t.Errorf("Expected 10000 frees, got %d", sts.NodeFrees)
+	}
+
+	freed := make(map[*Node]bool)
+	for _, n := range deleted {
+		freed[n] = true
+	}
+
+	reused := 0
+	for i := 5000; i < 15000; i++ {
+		n, _ := s.Insert2(NewIntKeyItem(i), cmp, nil, buf, rand.Float32, &s.Stats)
+		if freed[n] {
+			reused++
+		}
+	}
+
+	if reused == 0 {
+		t.Errorf("Expected freed nodes to be reused")
+	}
+
+	i := 0
+	itr = s.NewIterator(cmp, buf)
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if v := IntFromItem(itr.Get()); v != i {
+			t.Fatalf("Expected %d, got %d", i, v)
+		}
+		i++
+	}
+	itr.Close()
+
+	if i != 20000 {
+		t.Errorf("Expected 20000 items, got %d", i)
+	}
+}
+
+func TestMemoryQuota(t *testing.T) {
+	var events []bool
+	var lastUsed int64
+
+	cfg := DefaultConfig()
+	cfg.ItemSize = func(unsafe.Pointer) int { return 100 }
+	cfg.MemoryQuota = 100000
+	cfg.MemoryQuotaCallback = func(used int64, exceeded bool) {
+		events = append(events, exceeded)
+		lastUsed = used
+	}
+
+	s := NewWithConfig(cfg)
+	cmp := CompareInt
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	for i := 0; i < 2000; i++ {
+		s.Insert(NewIntKeyItem(i), cmp, buf, &s.Stats)
+	}
+
+	if len(events) != 1 || !events[0] || lastUsed <= cfg.MemoryQuota {
+		t.Errorf("Expected a quota exceeded event, got %v %d", events, lastUsed)
+	}
+
+	sts := s.GetStats()
+	var levelMem int64
+	for i, c := range sts.NodeDistribution {
+		levelMem += c * int64(nodeSize(i))
+	}
+
+	if sts.ItemMemory != 2000*100 || sts.NodeMemory != levelMem || sts.Memory != sts.NodeMemory+sts.ItemMemory {
+		t.Errorf("Unexpected memory stats %v", sts)
+	}
+
+	s.DeleteRange(MinItem, MaxItem, cmp, buf, &s.Stats)
+	if len(events) != 2 || events[1] || lastUsed != 0 {
+		t.Errorf("Expected a quota released event, got %v %d", events, lastUsed)
+	}
+}
+
+func TestMergeFrom(t *testing.T) {
+	cmp := CompareInt
+	s1, s2 := New(), New()
+	buf := s1.MakeBuf()
+	defer s1.FreeBuf(buf)
+
+	for i := 0; i < 10000; i++ {
+		if i%2 == 0 || i%3 == 0 {
+			s1.Insert(NewIntKeyItem(i), cmp, buf, &s1.Stats)
+		}
+		if i%2 == 1 || i%3 == 0 {
+			s2.Insert(NewIntKeyItem(i), cmp, buf, &s2.Stats)
+		}
+	}
+
+	s1.Delete(NewIntKeyItem(100), cmp, buf, &s1.Stats)
+	s2.Delete(NewIntKeyItem(101), cmp, buf, &s2.Stats)
+
+	dist := s1.GetStats().NodeDistribution
+	for i, c := range s2.GetStats().NodeDistribution {
+		dist[i] += c
+	}
+
+	dups := s1.MergeFrom(s2, cmp)
+	if len(dups) != 3334 {
+		t.Errorf("Expected 3334 duplicates, got %d", len(dups))
+	}
+
+	for _, n := range dups {
+		dist[n.Level()]--
+	}
+
+	sts := s1.GetStats()
+	if sts.NodeCount != 9998 || sts.NodeDistribution != dist {
+		t.Errorf("Unexpected stats %v", sts)
+	}
+
+	if sts := s2.GetStats(); sts.NodeCount != 0 || sts.Memory != 0 {
+		t.Errorf("Expected empty skiplist, got %v", sts)
+	}
+
+	expected := 0
+	itr := s1.NewIterator(cmp, buf)
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if expected == 100 || expected == 101 {
+			expected = 102
+		}
+		if v := IntFromItem(itr.Get()); v != expected {
+			t.Fatalf("Expected %d, got %d", expected, v)
+		}
+		expected++
+	}
+	itr.Close()
+
+	// Index levels are linked in order and lookups work after the merge
+	for i := 0; i < 10000; i += 7 {
+		itr := s1.NewIterator(cmp, buf)
+		if found := itr.Seek(NewIntKeyItem(i)); found != (i != 100 && i != 101) {
+			t.Errorf("Unexpected lookup result for %d", i)
+		}
+		itr.Close()
+	}
+
+	itr = s2.NewIterator(cmp, buf)
+	if itr.SeekFirst(); itr.Valid() {
+		t.Errorf("Expected no items in the merged skiplist")
+	}
+	itr.Close()
+}
+
+func TestIteratorCheckpoint(t *testing.T) {
+	var freed int64
+	cfg := DefaultConfig()
+	cfg.UseNodeArena = true
+	s := NewWithConfig(cfg)
+	cmp := CompareInt
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	for i := 0; i < 1000; i++ {
+		s.Insert(NewIntKeyItem(i), cmp, buf, &s.Stats)
+	}
+
+	itr := s.NewIterator(cmp, buf)
+	defer itr.Close()
+
+	itr.Seek(NewIntKeyItem(100))
+	itr.Checkpoint()
+
+	// Reclamation proceeds while the iterator is paused
+	buf2 := s.MakeBuf()
+	defer s.FreeBuf(buf2)
+	s.RetireNodes(s.DeleteRange(NewIntKeyItem(100), NewIntKeyItem(200), cmp, buf2, &s.Stats))
+	if freed = s.GetStats().NodeFrees; freed != 100 {
+		t.Errorf("Expected 100 nodes to be freed, got %d", freed)
+	}
+
+	itr.Resume()
+	if !itr.Valid() || IntFromItem(itr.Get()) != 200 {
+		t.Errorf("Expected the iterator to resume at 200")
+	}
+
+	itr.Next()
+	itr.Checkpoint()
+	itr.Resume()
+	if !itr.Valid() || IntFromItem(itr.Get()) != 201 {
+		t.Errorf("Expected the iterator to resume at 201")
+	}
+
+	itr.Seek(NewIntKeyItem(1000))
+	itr.Checkpoint()
+	itr.Resume()
+	if itr.Valid() {
+		t.Errorf("Expected the iterator to remain invalid")
+	}
+
+	// Items allocated outside of the Go heap are copied
+	s.UseMemoryMgmt = true
+	s.ItemSize = func(unsafe.Pointer) int { return int(unsafe.Sizeof(0)) }
+	itm := NewIntKeyItem(7)
+	if cp := s.copyItem(itm); cp == itm || IntFromItem(cp) != 7 {
+		t.Errorf("Expected a copy of the item")
+	}
+}
+
+func TestLevelConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxNodeLevel = 3
+	cfg.LevelProbability = 0.5
+	s := NewWithConfig(cfg)
+	cmp := CompareInt
+	buf := s.MakeBuf()
+	defer s.FreeBuf(buf)
+
+	for i := 0; i < 10000; i++ {
+		s.Insert(NewIntKeyItem(i), cmp, buf, &s.Stats)
+	}
+
+	sts := s.GetStats()
+	for l := 4; l <= MaxLevel; l++ {
+		if sts.NodeDistribution[l] != 0 {
+			t.Errorf("Unexpected nodes at level %d: %v", l, sts.NodeDistribution)
+		}
+	}
+
+	// With a probability of 0.5, half of the nodes stay at level 0
+	if c := sts.NodeDistribution[0]; c < 4500 || c > 5500 {
+		t.Errorf("Unexpected node distribution %v", sts.NodeDistribution)
+	}
+
+	if sts.MaxNodeLevel != 3 || sts.LevelProbability != 0.5 {
+		t.Errorf("Unexpected level config %d %f", sts.MaxNodeLevel, sts.LevelProbability)
+	}
+
+	itr := s.NewIterator(cmp, buf)
+	defer itr.Close()
+	for i := 0; i < 10000; i += 100 {
+		if !itr.Seek(NewIntKeyItem(i)) {
+			t.Errorf("Expected to find %d", i)
+		}
+	}
+
+	if s := NewWithConfig(Config{ItemSize: defaultItemSize}); s.MaxNodeLevel != MaxLevel || s.LevelProbability != p {
+		t.Errorf("Expected default level config, got %d %f", s.MaxNodeLevel, s.LevelProbability)
+	}
+}
@@ -22,8 +22,19 @@ type StatsReport struct {
 	SoftDeletes         int64
 	Memory              int64
 
+	// Memory used by the nodes of each level including their towers,
+	// and the split of the memory in use between nodes and items
+	LevelMemory [MaxLevel + 1]int64
+	NodeMemory  int64
+	ItemMemory  int64
+
 	NodeAllocs int64
 	NodeFrees  int64
+
+	// Level configuration of the skiplist, against which the node
+	// distribution can be compared
+	MaxNodeLevel     int
+	LevelProbability float32
 }
 
 // Apply updates the report with provided paritial stats
@@ -47,6 +58,13 @@ func (report *StatsReport) Apply(s *Stats) {
 	report.NodeAllocs += s.nodeAllocs
 	report.NodeFrees += s.nodeFrees
 	report.Memory += s.usedBytes
+
+	report.NodeMemory = 0
+	for i, c := range report.NodeDistribution {
+		report.LevelMemory[i] = c * int64(nodeSize(i))
+		report.NodeMemory += report.LevelMemory[i]
+	}
+	report.ItemMemory = report.Memory - report.NodeMemory
 }
 
 // Stats keeps stats for a skiplist instance
@@ -115,11 +133,16 @@ func (report StatsReport) String() string {
 			"insert_conflicts       = %d\n"+
 			"next_pointers_per_node = %.4f\n"+
 			"memory_used            = %d\n"+
+			"node_memory_used       = %d\n"+
+			"item_memory_used       = %d\n"+
 			"node_allocs            = %d\n"+
-			"node_frees             = %d\n\n",
+			"node_frees             = %d\n"+
+			"max_node_level         = %d\n"+
+			"level_probability      = %.4f\n\n",
 		report.NodeCount, report.SoftDeletes, report.ReadConflicts,
 		report.InsertConflicts, report.NextPointersPerNode, report.Memory,
-		report.NodeAllocs, report.NodeFrees)
+		report.NodeMemory, report.ItemMemory, report.NodeAllocs, report.NodeFrees,
+		report.MaxNodeLevel, report.LevelProbability)
 
 	str += "level_node_distribution:\n"
 
@@ -127,6 +150,12 @@ func (report StatsReport) String() string {
 		str += fmt.Sprintf("level%d => %d\n", i, c)
 	}
 
+	str += "\nlevel_memory_distribution:\n"
+
+	for i, c := range report.LevelMemory {
+		str += fmt.Sprintf("level%d => %d\n", i, c)
+	}
+
 	return str
 }
 
@@ -134,6 +163,8 @@ func (report StatsReport) String() string {
 func (s *Skiplist) GetStats() StatsReport {
 	var report StatsReport
 	report.Apply(&s.Stats)
+	report.MaxNodeLevel = s.MaxNodeLevel
+	report.LevelProbability = s.LevelProbability
 	return report
 }
 
@@ -141,3 +172,21 @@ func (s *Skiplist) GetStats() StatsReport {
 func (s *Skiplist) MemoryInUse() int64 {
 	return atomic.LoadInt64(&s.Stats.usedBytes)
 }
+
+// Notify the crossing of the memory quota in either direction
+func (s *Skiplist) checkMemoryQuota() {
+	if s.MemoryQuota <= 0 || s.MemoryQuotaCallback == nil {
+		return
+	}
+
+	used := s.MemoryInUse()
+	exceeded := used > s.MemoryQuota
+	from, to := int32(0), int32(1)
+	if !exceeded {
+		from, to = 1, 0
+	}
+
+	if atomic.CompareAndSwapInt32(&s.overQuota, from, to) {
+		s.MemoryQuotaCallback(used, exceeded)
+	}
+}
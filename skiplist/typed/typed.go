@@ -0,0 +1,165 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+// Package typed provides a type-parameterized skiplist on top of the lock-free
+// skiplist package, for users who do not need its raw item pointer interface.
+//
+//	s := typed.New(func(a, b string) int { return strings.Compare(a, b) })
+//	s.Insert("k1")
+//	itr := s.NewIterator()
+//	defer itr.Close()
+//	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+//		fmt.Println(itr.Get())
+//	}
+//
+// Items are copied into the list and their ordering is defined by the
+// comparator alone, hence a struct holding a key and a value can be used
+// as a map by comparing the keys.
+package typed
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/couchbase/nitro/skiplist"
+)
+
+// CompareFn is the typed item comparator
+type CompareFn[K any] func(a, b K) int
+
+// Skiplist is a concurrent ordered set of items of type K
+type Skiplist[K any] struct {
+	sl   *skiplist.Skiplist
+	cmp  skiplist.CompareFn
+	bufs sync.Pool
+}
+
+// New creates a skiplist ordered by the comparator
+func New[K any](cmp CompareFn[K]) *Skiplist[K] {
+	s := &Skiplist[K]{
+		sl: skiplist.New(),
+		cmp: func(a, b unsafe.Pointer) int {
+			return cmp(*(*K)(a), *(*K)(b))
+		},
+	}
+
+	s.bufs.New = func() interface{} {
+		return s.sl.MakeBuf()
+	}
+
+	return s
+}
+
+func (s *Skiplist[K]) getBuf() *skiplist.ActionBuffer {
+	return s.bufs.Get().(*skiplist.ActionBuffer)
+}
+
+func (s *Skiplist[K]) putBuf(buf *skiplist.ActionBuffer) {
+	s.bufs.Put(buf)
+}
+
+// Insert adds the item unless an equal item is present
+func (s *Skiplist[K]) Insert(itm K) bool {
+	buf := s.getBuf()
+	defer s.putBuf(buf)
+	return s.sl.Insert(unsafe.Pointer(&itm), s.cmp, buf, &s.sl.Stats)
+}
+
+// Upsert adds the item unless an equal item is present, in which case
+// the present item is returned
+func (s *Skiplist[K]) Upsert(itm K) (K, bool) {
+	buf := s.getBuf()
+	defer s.putBuf(buf)
+	p, inserted := s.sl.Upsert(unsafe.Pointer(&itm), s.cmp, buf, &s.sl.Stats)
+	return *(*K)(p), inserted
+}
+
+// Get returns the item equal to the given item
+func (s *Skiplist[K]) Get(itm K) (K, bool) {
+	buf := s.getBuf()
+	defer s.putBuf(buf)
+
+	token := s.sl.GetAccesBarrier().Acquire()
+	defer s.sl.GetAccesBarrier().Release(token)
+
+	if _, n, found := s.sl.Lookup(unsafe.Pointer(&itm), s.cmp, buf, &s.sl.Stats); found {
+		return *(*K)(n.Item()), true
+	}
+
+	var zero K
+	return zero, false
+}
+
+// Delete removes the item equal to the given item
+func (s *Skiplist[K]) Delete(itm K) bool {
+	buf := s.getBuf()
+	defer s.putBuf(buf)
+	return s.sl.Delete(unsafe.Pointer(&itm), s.cmp, buf, &s.sl.Stats)
+}
+
+// Stats returns the stats of the underlying skiplist
+func (s *Skiplist[K]) Stats() skiplist.StatsReport {
+	return s.sl.GetStats()
+}
+
+// Iterator is used for lookup and range operations on the typed skiplist
+type Iterator[K any] struct {
+	s   *Skiplist[K]
+	itr *skiplist.Iterator
+	buf *skiplist.ActionBuffer
+}
+
+// NewIterator creates an iterator, which should be closed after use
+func (s *Skiplist[K]) NewIterator() *Iterator[K] {
+	buf := s.getBuf()
+	return &Iterator[K]{
+		s:   s,
+		itr: s.sl.NewIterator(s.cmp, buf),
+		buf: buf,
+	}
+}
+
+// SeekFirst moves the iterator to the first item
+func (it *Iterator[K]) SeekFirst() {
+	it.itr.SeekFirst()
+}
+
+// Seek moves the iterator to the first item >= the given item
+func (it *Iterator[K]) Seek(itm K) bool {
+	return it.itr.Seek(unsafe.Pointer(&itm))
+}
+
+// SeekLE moves the iterator to the last item <= the given item
+func (it *Iterator[K]) SeekLE(itm K) bool {
+	return it.itr.SeekLE(unsafe.Pointer(&itm))
+}
+
+// Valid returns false once the iterator moves past the last item
+func (it *Iterator[K]) Valid() bool {
+	return it.itr.Valid()
+}
+
+// Get returns the current item
+func (it *Iterator[K]) Get() K {
+	return *(*K)(it.itr.Get())
+}
+
+// Next moves the iterator to the next item
+func (it *Iterator[K]) Next() {
+	it.itr.Next()
+}
+
+// Close releases the iterator
+func (it *Iterator[K]) Close() {
+	it.itr.Close()
+	it.s.putBuf(it.buf)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package typed
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type kv struct {
+	k string
+	v int
+}
+
+func compareKV(a, b kv) int {
+	return strings.Compare(a.k, b.k)
+}
+
+func TestTypedSkiplist(t *testing.T) {
+	s := New(compareKV)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < 1000; i += 4 {
+				s.Insert(kv{fmt.Sprintf("key-%04d", i), i})
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if s.Insert(kv{"key-0001", 0}) {
+		t.Errorf("Expected duplicate insert to fail")
+	}
+
+	if got, ok := s.Get(kv{k: "key-0010"}); !ok || got.v != 10 {
+		t.Errorf("Expected 10, got %v %v", got, ok)
+	}
+
+	if got, inserted := s.Upsert(kv{"key-0020", -1}); inserted || got.v != 20 {
+		t.Errorf("Expected existing item, got %v %v", got, inserted)
+	}
+
+	if !s.Delete(kv{k: "key-0500"}) {
+		t.Errorf("Expected delete to succeed")
+	}
+
+	if _, ok := s.Get(kv{k: "key-0500"}); ok {
+		t.Errorf("Expected deleted item not to be found")
+	}
+
+	itr := s.NewIterator()
+	defer itr.Close()
+
+	count := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		count++
+	}
+
+	if count != 999 {
+		t.Errorf("Expected 999 items, got %d", count)
+	}
+
+	itr.SeekLE(kv{k: "key-0500"})
+	if !itr.Valid() || itr.Get().v != 499 {
+		t.Errorf("Expected 499, got %v", itr.Get())
+	}
+
+	itr.Seek(kv{k: "key-0500"})
+	if !itr.Valid() || itr.Get().v != 501 {
+		t.Errorf("Expected 501, got %v", itr.Get())
+	}
+}
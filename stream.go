@@ -0,0 +1,145 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"unsafe"
+
+	"github.com/couchbase/nitro/skiplist"
+)
+
+var (
+	// ErrInvalidStream means the data read is not a Nitro snapshot stream
+	ErrInvalidStream = errors.New("Invalid snapshot stream")
+)
+
+// Stream format:
+// [8 byte magic][4 byte version][1 byte compressor name len][compressor name]
+// followed by the items as compressed blocks in the key order
+const streamMagic = "NITROSNP"
+
+// StoreToWriter streams a Nitro snapshot to the writer, so that it can be
+// sent over the network without an intermediate file. Items are written
+// in blocks compressed by the configured block compressor, if any. The
+// snapshot is closed once done, as with StoreToDisk.
+func (m *Nitro) StoreToWriter(w io.Writer, snap *Snapshot, itmCallback ItemCallback) error {
+	defer snap.Close()
+
+	if m.useMemoryMgmt {
+		m.shutdownWg1.Add(1)
+		defer m.shutdownWg1.Done()
+	}
+
+	c := m.compressor
+	if c == nil {
+		c = noCompressor{}
+	}
+
+	name := c.Name()
+	hdr := make([]byte, len(streamMagic)+5, len(streamMagic)+5+len(name))
+	copy(hdr, streamMagic)
+	binary.BigEndian.PutUint32(hdr[len(streamMagic):], version)
+	hdr[len(streamMagic)+4] = byte(len(name))
+	if _, err := w.Write(append(hdr, name...)); err != nil {
+		return err
+	}
+
+	bw := newBlockWriter(w, c)
+	buf := make([]byte, encodeBufSize)
+
+	itr := m.NewIterator(snap)
+	if itr == nil {
+		return ErrShutdown
+	}
+	defer itr.Close()
+
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if m.hasShutdown {
+			return ErrShutdown
+		}
+
+		itm := (*Item)(itr.GetNode().Item())
+		if err := m.EncodeItem(itm, buf, bw); err != nil {
+			return err
+		}
+
+		if itmCallback != nil {
+			itmCallback(&ItemEntry{itm: itm, n: nil})
+		}
+	}
+
+	if err := m.EncodeItem(&Item{}, buf, bw); err != nil {
+		return err
+	}
+
+	return bw.Close()
+}
+
+// LoadFromReader restores Nitro from a snapshot stream written by
+// StoreToWriter. The stream is read up to the end of the snapshot.
+func (m *Nitro) LoadFromReader(r io.Reader, callb ItemCallback) (*Snapshot, error) {
+	hdr := make([]byte, len(streamMagic)+5)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	if string(hdr[:len(streamMagic)]) != streamMagic {
+		return nil, ErrInvalidStream
+	}
+
+	ver := int(binary.BigEndian.Uint32(hdr[len(streamMagic):]))
+	name := make([]byte, hdr[len(streamMagic)+4])
+	if _, err := io.ReadFull(r, name); err != nil {
+		return nil, err
+	}
+
+	c, err := getBlockCompressor(string(name))
+	if err != nil {
+		return nil, err
+	}
+
+	b := skiplist.NewBuilderWithConfig(m.newStoreConfig())
+	b.SetItemSizeFunc(ItemSize)
+	segment := b.NewSegment()
+	if callb != nil {
+		segment.SetNodeCallback(func(n *skiplist.Node) {
+			callb(&ItemEntry{itm: (*Item)(n.Item()), n: n})
+		})
+	}
+
+	br := newBlockReader(r, c)
+	buf := make([]byte, encodeBufSize)
+	for {
+		itm, err := m.DecodeItem(ver, buf, br)
+		if err != nil {
+			return nil, err
+		}
+
+		if itm == nil {
+			break
+		}
+
+		segment.Add(unsafe.Pointer(itm))
+	}
+
+	// Consume the terminating block so that the writer is not left blocked
+	if _, err := io.Copy(ioutil.Discard, br); err != nil {
+		return nil, err
+	}
+
+	m.store = b.Assemble(segment)
+	stats := m.store.GetStats()
+	m.itemsCount = int64(stats.NodeCount)
+	return m.NewSnapshot()
+}
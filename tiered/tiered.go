@@ -0,0 +1,327 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package tiered implements a two-tier key value store. Items are written
+// into a Nitro instance and the cold items are migrated into a backing
+// Plasma instance once Nitro exceeds its memory quota. Lookups read through
+// to Plasma for the items not found in memory.
+//
+// An item lives in Nitro, in Plasma or briefly in both while it is being
+// migrated. The copy in Nitro always takes precedence, hence a put only
+// writes into Nitro, whereas a delete removes the item from both tiers.
+package tiered
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/nitro"
+	"github.com/couchbase/nitro/plasma"
+	"github.com/couchbase/nitro/skiplist"
+)
+
+// ErrItemNotFound means the key does not exist in either tier
+var ErrItemNotFound = plasma.ErrItemNotFound
+
+// Number of access epochs tracked. Keys hashing to the same slot share
+// their access epoch, which only makes them look recently used.
+const accessSlots = 1 << 16
+
+// Number of locks serializing the replacement of a key with its lookups
+const keyLockSlots = 1024
+
+// Number of items migrated at once, while the writers are blocked
+const spillBatchSize = 256
+
+// Memory quota is checked by a writer once per this many puts
+const quotaCheckInterval = 1024
+
+// Config - tiered store configuration
+type Config struct {
+	// Memory used by Nitro beyond which the cold items are migrated
+	MemoryQuota int64
+	// Fraction of the quota to be freed by a migration
+	SpillRatio float64
+
+	Plasma plasma.Config
+}
+
+// DefaultConfig returns a configuration with a memory quota of 256MB,
+// backed by a Plasma instance stored in the file
+func DefaultConfig(file string) Config {
+	cfg := Config{
+		MemoryQuota: 256 * 1024 * 1024,
+		SpillRatio:  0.25,
+		Plasma:      plasma.DefaultConfig(),
+	}
+
+	cfg.Plasma.File = file
+	return cfg
+}
+
+// Store is a two-tier key value store
+type Store struct {
+	Config
+
+	db *nitro.Nitro
+	ps *plasma.Plasma
+
+	// Writers hold it shared, while Nitro snapshots are created
+	rw sync.RWMutex
+
+	// A put holds the lock of its key exclusively, so that a lookup does
+	// not see the key missing between its delete and insert in Nitro
+	keyLocks []sync.RWMutex
+
+	// Items not accessed in the current epoch are considered cold
+	epoch  uint32
+	access []uint32
+
+	spillMu  sync.Mutex
+	spilling int32
+	spillWg  sync.WaitGroup
+	sw       *nitro.Writer
+	spw      *plasma.Writer
+
+	// Number of items migrated into Plasma
+	SpilledItems int64
+}
+
+// New creates a tiered store
+func New(cfg Config) (*Store, error) {
+	if cfg.SpillRatio <= 0 || cfg.SpillRatio > 1 {
+		cfg.SpillRatio = 0.25
+	}
+
+	ps, err := plasma.New(cfg.Plasma)
+	if err != nil {
+		return nil, err
+	}
+
+	dbCfg := nitro.DefaultConfig()
+	dbCfg.SetKeyComparator(nitro.CompareKV)
+
+	s := &Store{
+		Config: cfg,
+		db:     nitro.NewWithConfig(dbCfg),
+		ps:     ps,
+		epoch:  1,
+		access: make([]uint32, accessSlots),
+
+		keyLocks: make([]sync.RWMutex, keyLockSlots),
+	}
+
+	s.sw = s.db.NewWriter()
+	s.spw = ps.NewWriter()
+	return s, nil
+}
+
+// Close waits for an ongoing migration and closes both tiers
+func (s *Store) Close() {
+	s.spillWg.Wait()
+	s.db.Close()
+	s.ps.Close()
+}
+
+// MemoryInUse returns the memory used by the Nitro tier
+func (s *Store) MemoryInUse() int64 {
+	return s.db.MemoryInUse()
+}
+
+func (s *Store) touch(k []byte) {
+	h := fnv.New32a()
+	h.Write(k)
+	atomic.StoreUint32(&s.access[h.Sum32()%accessSlots], atomic.LoadUint32(&s.epoch))
+}
+
+func (s *Store) keyLock(k []byte) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write(k)
+	return &s.keyLocks[h.Sum32()%keyLockSlots]
+}
+
+func (s *Store) isCold(k []byte) bool {
+	h := fnv.New32a()
+	h.Write(k)
+	return atomic.LoadUint32(&s.access[h.Sum32()%accessSlots]) != atomic.LoadUint32(&s.epoch)
+}
+
+// Writer provides access to the store for a single goroutine
+type Writer struct {
+	s     *Store
+	w     *nitro.Writer
+	pw    *plasma.Writer
+	count int
+}
+
+// NewWriter creates a writer. Writers should not be shared by goroutines.
+func (s *Store) NewWriter() *Writer {
+	return &Writer{
+		s:  s,
+		w:  s.db.NewWriter(),
+		pw: s.ps.NewWriter(),
+	}
+}
+
+// Put inserts or replaces the value of a key
+func (w *Writer) Put(k, v []byte) {
+	w.s.touch(k)
+
+	l := w.s.keyLock(k)
+	w.s.rw.RLock()
+	l.Lock()
+	w.w.Delete(nitro.KVToBytes(k, nil))
+	w.w.Put(nitro.KVToBytes(k, v))
+	l.Unlock()
+	w.s.rw.RUnlock()
+
+	if w.count++; w.count%quotaCheckInterval == 0 {
+		w.s.checkQuota()
+	}
+}
+
+// Get returns the value of a key from memory, or else from Plasma
+func (w *Writer) Get(k []byte) ([]byte, error) {
+	w.s.touch(k)
+
+	l := w.s.keyLock(k)
+	w.s.rw.RLock()
+	defer w.s.rw.RUnlock()
+	l.RLock()
+	defer l.RUnlock()
+
+	if n := w.w.GetNode(nitro.KVToBytes(k, nil)); n != nil {
+		_, v := nitro.KVFromBytes((*nitro.Item)(n.Item()).Bytes())
+		return append([]byte(nil), v...), nil
+	}
+
+	v, err := w.pw.LookupKV(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), v...), nil
+}
+
+// Delete removes a key from both tiers. A lookup does not find a value
+// spilled earlier in Plasma once the key is deleted from Nitro.
+func (w *Writer) Delete(k []byte) error {
+	l := w.s.keyLock(k)
+	w.s.rw.RLock()
+	defer w.s.rw.RUnlock()
+	l.Lock()
+	defer l.Unlock()
+
+	w.w.Delete(nitro.KVToBytes(k, nil))
+	_, err := w.pw.DeleteKV(k)
+	return err
+}
+
+// Removes the items written into Plasma from Nitro. Writers are blocked
+// meanwhile, since concurrent deletes of a node are not allowed by Nitro.
+// The items which have been replaced or deleted meanwhile are removed from
+// Plasma instead, as Nitro has the latest state of those keys.
+func (s *Store) removeSpilled(nodes []*skiplist.Node) (count int, freed int64) {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	for _, n := range nodes {
+		bs := (*nitro.Item)(n.Item()).Bytes()
+		if s.sw.GetNode(bs) == n && s.sw.DeleteNode(n) {
+			freed += int64(nitro.ItemSize(n.Item()))
+			count++
+		} else {
+			k, _ := nitro.KVFromBytes(bs)
+			s.spw.DeleteKV(k)
+		}
+	}
+
+	return
+}
+
+// Migration runs in the background, when the quota is exceeded
+func (s *Store) checkQuota() {
+	if s.MemoryInUse() <= s.MemoryQuota {
+		return
+	}
+
+	if atomic.CompareAndSwapInt32(&s.spilling, 0, 1) {
+		s.spillWg.Add(1)
+		go func() {
+			defer s.spillWg.Done()
+			defer atomic.StoreInt32(&s.spilling, 0)
+			s.Spill()
+		}()
+	}
+}
+
+func (s *Store) newSnapshot() *nitro.Snapshot {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	snap, err := s.db.NewSnapshot()
+	if err != nil {
+		panic(err)
+	}
+
+	return snap
+}
+
+// Spill migrates the items not accessed since the previous migration into
+// Plasma, until the memory quota is freed by the spill ratio. If that is not
+// sufficient, the remaining items are migrated in the key order. The memory
+// of the migrated items is reclaimed by the Nitro garbage collector.
+// Returns the number of items migrated.
+func (s *Store) Spill() (int, error) {
+	s.spillMu.Lock()
+	defer s.spillMu.Unlock()
+
+	target := s.MemoryInUse() - s.MemoryQuota + int64(float64(s.MemoryQuota)*s.SpillRatio)
+	epoch := atomic.LoadUint32(&s.epoch)
+	defer atomic.StoreUint32(&s.epoch, epoch+1)
+
+	var err error
+	var count int
+	var freed int64
+
+	for pass := 0; pass < 2 && freed < target && err == nil; pass++ {
+		// Items migrated by the previous pass are not visible anymore
+		snap := s.newSnapshot()
+		itr := snap.NewIterator()
+		for itr.SeekFirst(); itr.Valid() && freed < target && err == nil; {
+			var batch []*skiplist.Node
+			for ; itr.Valid() && len(batch) < spillBatchSize; itr.Next() {
+				k, v := nitro.KVFromBytes(itr.Get())
+				if pass == 0 && !s.isCold(k) {
+					continue
+				}
+
+				// Item is written into Plasma before removing it from Nitro,
+				// so that it can always be found by the readers
+				if _, err = s.spw.InsertKV(k, v); err != nil {
+					break
+				}
+				batch = append(batch, itr.GetNode())
+			}
+
+			n, f := s.removeSpilled(batch)
+			count += n
+			freed += f
+		}
+		itr.Close()
+		snap.Close()
+	}
+
+	// Items deleted after the last snapshot are collected once it is closed
+	s.newSnapshot().Close()
+
+	atomic.AddInt64(&s.SpilledItems, int64(count))
+	return count, err
+}
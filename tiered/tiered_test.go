@@ -0,0 +1,166 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package tiered
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/nitro"
+)
+
+func newTestStore(t *testing.T, quota int64) *Store {
+	os.RemoveAll("teststore.data")
+	cfg := DefaultConfig("teststore.data")
+	cfg.MemoryQuota = quota
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	return s
+}
+
+func TestTieredSpill(t *testing.T) {
+	s := newTestStore(t, 1<<30)
+	defer os.RemoveAll("teststore.data")
+	defer s.Close()
+
+	n := 20000
+	w := s.NewWriter()
+	for i := 0; i < n; i++ {
+		w.Put([]byte(fmt.Sprintf("key-%10d", i)), []byte(fmt.Sprintf("val-%100d", i)))
+	}
+
+	// Nothing is migrated within the quota
+	if count, _ := s.Spill(); count != 0 {
+		t.Fatalf("Expected no items to be migrated, got %d", count)
+	}
+
+	// Items accessed since the previous migration are retained in memory
+	for i := 0; i < 100; i++ {
+		w.Get([]byte(fmt.Sprintf("key-%10d", i)))
+	}
+	s.MemoryQuota = 2 << 20
+	s.Spill()
+
+	if s.SpilledItems == 0 {
+		t.Fatalf("Expected items to be migrated")
+	}
+
+	for i := 0; i < 100; i++ {
+		if w.w.GetNode(nitro.KVToBytes([]byte(fmt.Sprintf("key-%10d", i)), nil)) == nil {
+			t.Fatalf("Expected key-%d to be in memory", i)
+		}
+	}
+
+	// Migrated items can be replaced and deleted
+	for i := 0; i < n; i += 10 {
+		w.Put([]byte(fmt.Sprintf("key-%10d", i)), []byte("newval"))
+		w.Delete([]byte(fmt.Sprintf("key-%10d", i+1)))
+	}
+	s.Spill()
+
+	for i := 0; i < n; i++ {
+		v, err := w.Get([]byte(fmt.Sprintf("key-%10d", i)))
+		switch {
+		case i%10 == 0:
+			if err != nil || string(v) != "newval" {
+				t.Fatalf("Expected newval for key-%d, got %s, %v", i, v, err)
+			}
+		case i%10 == 1:
+			if err != ErrItemNotFound {
+				t.Fatalf("Expected key-%d to be deleted, got %v", i, err)
+			}
+		default:
+			if exp := fmt.Sprintf("val-%100d", i); err != nil || string(v) != exp {
+				t.Fatalf("Expected %s for key-%d, got %s, %v", exp, i, v, err)
+			}
+		}
+	}
+}
+
+func TestTieredConcurrent(t *testing.T) {
+	s := newTestStore(t, 512*1024)
+	defer os.RemoveAll("teststore.data")
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	n := 10000
+	thr := 4
+	for x := 0; x < thr; x++ {
+		wg.Add(1)
+		go func(x int) {
+			defer wg.Done()
+			w := s.NewWriter()
+			for i := 0; i < n; i++ {
+				k := []byte(fmt.Sprintf("key-%d-%10d", x, i))
+				w.Put(k, []byte(fmt.Sprintf("val-%100d", i)))
+				if i%3 == 0 {
+					w.Put(k, []byte(fmt.Sprintf("upd-%100d", i)))
+				}
+			}
+		}(x)
+	}
+	wg.Wait()
+
+	if s.SpilledItems == 0 {
+		t.Fatalf("Expected items to be migrated")
+	}
+
+	w := s.NewWriter()
+	for x := 0; x < thr; x++ {
+		for i := 0; i < n; i++ {
+			exp := fmt.Sprintf("val-%100d", i)
+			if i%3 == 0 {
+				exp = fmt.Sprintf("upd-%100d", i)
+			}
+
+			v, err := w.Get([]byte(fmt.Sprintf("key-%d-%10d", x, i)))
+			if err != nil || string(v) != exp {
+				t.Fatalf("Expected %s, got %s, %v", exp, v, err)
+			}
+		}
+	}
+}
+
+func TestTieredReplaceVisible(t *testing.T) {
+	s := newTestStore(t, 1<<30)
+	defer os.RemoveAll("teststore.data")
+	defer s.Close()
+
+	k := []byte("key")
+	w := s.NewWriter()
+	w.Put(k, []byte("val"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w := s.NewWriter()
+		for i := 0; i < 100000; i++ {
+			w.Put(k, []byte(fmt.Sprintf("val-%d", i)))
+		}
+	}()
+
+	// A replaced key is never seen missing
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		if _, err := w.Get(k); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+	}
+}